@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Yoone/blobber/internal/orchestrator"
+	"github.com/Yoone/blobber/internal/retention"
+	"github.com/Yoone/blobber/internal/storage"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit [db_name...]",
+	Short: "List files in destinations that retention ignores",
+	Long: `Lists the files in each destination whose names don't follow the backup naming
+convention ({name}_{YYYYMMDD_HHMMSS}.{ext}). Retention never considers these files and
+they are not offered for restore, so they are never cleaned up: renamed or hand-copied
+backups, leftovers from other tools, and the like.
+
+Checks the destinations of the given databases, or of all databases. Checksum files
+and run reports are not listed. Nothing is deleted.
+
+Examples:
+  blobber audit          # check every destination
+  blobber audit mydb     # check the destination of 'mydb'`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, name := range args {
+			if _, ok := cfg.Databases[name]; !ok {
+				return fmt.Errorf("database %q not found in config", name)
+			}
+		}
+		return runAudit(context.Background(), orchestrator.Destinations(cfg, args))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+}
+
+func runAudit(ctx context.Context, dests []string) error {
+	var count int
+	var size int64
+	for _, dest := range dests {
+		files, err := storage.List(ctx, dest)
+		if err != nil {
+			if storage.IsNotFound(err) {
+				fmt.Printf("[%s] No files\n", dest)
+				continue
+			}
+			fmt.Printf("[%s] Listing failed: %v\n", dest, err)
+			continue
+		}
+
+		unmatched := retention.Unmatched(files)
+		if len(unmatched) == 0 {
+			fmt.Printf("[%s] All %d file(s) are backups\n", dest, len(files))
+			continue
+		}
+
+		fmt.Printf("[%s] %d of %d file(s) ignored by retention\n", dest, len(unmatched), len(files))
+		for _, f := range unmatched {
+			fmt.Printf("  %s  %s  %s\n", f.Name, f.ModTime.Format("2006-01-02 15:04:05"), humanize.IBytes(uint64(f.Size)))
+			count++
+			size += f.Size
+		}
+	}
+
+	if count > 0 {
+		fmt.Printf("Found %d file(s) (%s) that retention ignores\n", count, humanize.IBytes(uint64(size)))
+	} else {
+		fmt.Println("No files found that retention ignores")
+	}
+	return nil
+}
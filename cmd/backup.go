@@ -2,19 +2,36 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"slices"
 	"sort"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/Yoone/blobber/internal/backup"
+	"github.com/Yoone/blobber/internal/config"
+	"github.com/Yoone/blobber/internal/lock"
 	"github.com/Yoone/blobber/internal/orchestrator"
+	"github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
 )
 
 var (
-	dryRun        bool
-	skipRetention bool
+	dryRun           bool
+	stageBackup      bool
+	skipRetention    bool
+	retentionDryRun  bool
+	deadline         time.Duration
+	databasesFrom    string
+	showRetention    bool
+	retentionConfirm bool
+	excludeDBs       []string
+	progressFormat   string
+	skipPreflight    bool
+	presetName       string
 )
 
 var backupCmd = &cobra.Command{
@@ -23,25 +40,150 @@ var backupCmd = &cobra.Command{
 	Long: `Dumps configured databases and uploads them to their respective cloud destinations.
 
 If no databases are specified, all configured databases are backed up.
-Databases are backed up in parallel for faster execution.
+Databases are backed up in parallel for faster execution. A database with an
+"after" list waits for those databases to finish first.
 
 Examples:
   blobber backup              # backup all databases
   blobber backup mydb         # backup only 'mydb'
   blobber backup db1 db2      # backup 'db1' and 'db2'
-  blobber backup --dry-run    # dump only, skip upload`,
+  blobber backup --dry-run    # dump only, skip upload
+  blobber backup --deadline 2h  # cancel anything still running after 2 hours
+  blobber backup --databases-from fleet.txt  # only databases listed in fleet.txt
+  blobber backup --exclude big --exclude old  # all databases except 'big' and 'old'
+  blobber backup --show-retention     # print old backups retention will delete
+  blobber backup --retention-dry-run  # back up and upload, but only report what retention would delete
+  blobber backup --retention-confirm  # ask before deleting old backups
+  blobber backup --progress json      # one JSON object per progress update (NDJSON)
+  blobber backup --skip-preflight     # don't test the destinations before dumping
+  blobber backup --preset nightly     # the databases and options of the 'nightly' preset
+  blobber backup --stage              # dump now, upload later with 'blobber upload-staged'
+
+Before dumping anything, blobber tests access to each destination of the run and
+aborts if one is unreachable, so a bad destination doesn't fail every upload after
+the dumps. Dry runs don't upload and skip the test.
+
+With --stage, the dumps are left in the staging directory (staging_dir, by default
+"staged" next to the config) instead of being uploaded, for 'blobber upload-staged'
+to upload and apply retention later: dump during the maintenance window, upload
+outside of it. Staged runs skip the destination test.
+
+With --progress json, stdout carries only the progress stream: one JSON object per
+line with the fields db, step, message, done, and when set skipped, error,
+estimated_bytes and warnings. Everything else is written to stderr.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runBackup(context.Background(), args, dryRun, skipRetention)
+		if progressFormat != "text" && progressFormat != "json" {
+			return fmt.Errorf("--progress must be text or json")
+		}
+		jsonProgress := progressFormat == "json"
+		out := backupOutput(jsonProgress)
+
+		if presetName != "" {
+			if len(args) > 0 || databasesFrom != "" {
+				return fmt.Errorf("cannot combine --preset with database arguments or --databases-from")
+			}
+			preset, ok := cfg.Presets[presetName]
+			if !ok {
+				return fmt.Errorf("preset %q not found in config", presetName)
+			}
+			// The preset's options add to the flags given on the command line
+			args = slices.Clone(preset.Databases)
+			dryRun = dryRun || preset.DryRun
+			skipRetention = skipRetention || preset.SkipRetention
+			retentionDryRun = retentionDryRun || preset.RetentionDryRun
+		}
+		if retentionDryRun && (dryRun || skipRetention) {
+			return fmt.Errorf("--retention-dry-run cannot be combined with --dry-run or --skip-retention")
+		}
+		if stageBackup && (dryRun || retentionDryRun || showRetention || retentionConfirm) {
+			return fmt.Errorf("--stage cannot be combined with --dry-run or the retention flags (pass them to upload-staged)")
+		}
+
+		if databasesFrom != "" {
+			if len(args) > 0 {
+				return fmt.Errorf("cannot combine database arguments with --databases-from")
+			}
+			selected, err := databasesFromFile(databasesFrom)
+			if err != nil {
+				return err
+			}
+			if len(selected) == 0 {
+				fmt.Fprintf(out, "No configured databases listed in %s\n", databasesFrom)
+				return nil
+			}
+			args = selected
+		}
+		if len(excludeDBs) > 0 {
+			args = excludeDatabases(args, excludeDBs)
+			if len(args) == 0 {
+				fmt.Fprintln(out, "No databases left to back up after --exclude")
+				return nil
+			}
+		}
+		ctx := context.Background()
+		// The flag takes precedence over run_timeout from the config
+		timeout := deadline
+		if timeout == 0 {
+			timeout = cfg.RunTimeoutDuration()
+		}
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return runBackup(ctx, args, backupRunOptions{
+			dryRun:           dryRun,
+			stage:            stageBackup,
+			skipRetention:    skipRetention,
+			retentionDryRun:  retentionDryRun,
+			showRetention:    showRetention,
+			confirmRetention: retentionConfirm,
+			skipPreflight:    skipPreflight,
+			jsonProgress:     jsonProgress,
+		})
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(backupCmd)
 	backupCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Perform dump but skip upload and retention")
+	backupCmd.Flags().BoolVar(&stageBackup, "stage", false, "Dump to the staging directory and leave the upload and retention to upload-staged")
 	backupCmd.Flags().BoolVar(&skipRetention, "skip-retention", false, "Skip retention policy for this run")
+	backupCmd.Flags().BoolVar(&retentionDryRun, "retention-dry-run", false, "Back up and upload, but only report the backups retention would delete")
+	backupCmd.Flags().DurationVar(&deadline, "deadline", 0, "Cancel backups still running after this duration (overrides run_timeout)")
+	backupCmd.Flags().StringVar(&databasesFrom, "databases-from", "", "Back up only the databases listed in this file (one name per line)")
+	backupCmd.Flags().BoolVar(&showRetention, "show-retention", false, "Print the backups retention will delete before starting")
+	backupCmd.Flags().StringArrayVar(&excludeDBs, "exclude", nil, "Skip this database (repeatable)")
+	backupCmd.Flags().BoolVar(&retentionConfirm, "retention-confirm", false, "Ask before deleting old backups (without a terminal, keeps them unless --yes)")
+	backupCmd.Flags().StringVar(&progressFormat, "progress", "text", "Progress output: text, or json for one JSON object per line on stdout")
+	backupCmd.Flags().BoolVar(&skipPreflight, "skip-preflight", false, "Don't test access to the destinations before dumping")
+	backupCmd.Flags().StringVar(&presetName, "preset", "", "Back up the databases of this preset from the config, with its options")
 }
 
-func runBackup(ctx context.Context, databases []string, dryRun, skipRetention bool) error {
+// backupRunOptions are the options of a backup run, from the flags of the backup command
+type backupRunOptions struct {
+	dryRun           bool // dump only, skip the upload and retention
+	stage            bool // dump to the staging directory, leaving the upload to upload-staged
+	skipRetention    bool
+	retentionDryRun  bool // report what retention would delete instead of deleting it
+	showRetention    bool // print what retention will delete before starting
+	confirmRetention bool // ask before retention deletes anything
+	skipPreflight    bool // don't test the destinations before dumping
+	jsonProgress     bool // progress as NDJSON on stdout, everything else on stderr
+}
+
+// backupOutput returns where human-readable backup output goes: stderr when stdout
+// carries the JSON progress stream
+func backupOutput(jsonProgress bool) io.Writer {
+	if jsonProgress {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+func runBackup(ctx context.Context, databases []string, opts backupRunOptions) error {
+	out := backupOutput(opts.jsonProgress)
+
 	// Validate specified databases exist
 	if len(databases) > 0 {
 		for _, name := range databases {
@@ -58,85 +200,230 @@ func runBackup(ctx context.Context, databases []string, dryRun, skipRetention bo
 	}
 
 	if len(databases) == 0 {
-		fmt.Println("No databases configured")
+		fmt.Fprintln(out, "No databases configured")
 		return nil
 	}
 
-	fmt.Printf("Starting backup of %d database(s): %s\n", len(databases), strings.Join(databases, ", "))
+	// Prevent overlapping runs against the same config (e.g. cron and a manual TUI run)
+	runLock, err := lock.Acquire(lock.PathFor(cfg.Path()))
+	if err != nil {
+		return err
+	}
+	defer runLock.Release()
 
-	// Pre-check retention policies
-	var retentionPlan orchestrator.RetentionPlan
-	if !dryRun && !skipRetention {
-		var err error
-		retentionPlan, err = orchestrator.PreCheckRetention(ctx, cfg, databases)
-		if err != nil {
-			return fmt.Errorf("checking retention policies: %w", err)
+	// Entries with database "*" become one entry per database found on their server
+	runCfg, databases, warnings, err := orchestrator.ExpandDatabases(ctx, cfg, databases)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(out, "Warning: %s\n", warning)
+	}
+	if len(databases) == 0 {
+		fmt.Fprintln(out, "No databases to back up")
+		return nil
+	}
+
+	startedAt := time.Now()
+	if profile := cfg.Profile(); profile != "" {
+		fmt.Fprintf(out, "Using profile %s (%s)\n", profile, cfg.Path())
+	}
+	fmt.Fprintf(out, "Starting backup of %d database(s): %s\n", len(databases), strings.Join(databases, ", "))
+
+	// Abort before dumping anything if a server rejects the credentials
+	if cfg.FailFastOnAuth {
+		if failures := orchestrator.CheckCredentials(runCfg, databases); len(failures) > 0 {
+			for _, name := range databases {
+				if err := failures[name]; err != nil {
+					fmt.Fprintf(out, "[%s] %v\n", name, err)
+				}
+			}
+			return fmt.Errorf("aborted: %d database(s) rejected their credentials (fail_fast_on_auth), nothing was backed up", len(failures))
 		}
 	}
 
-	// Track errors for summary
-	errors := make(map[string]bool)
-	errorsMu := sync.Mutex{}
+	// Abort before dumping anything if a destination is unreachable
+	if !opts.dryRun && !opts.stage && !opts.skipPreflight {
+		if failures := orchestrator.CheckDestinations(ctx, runCfg, databases); len(failures) > 0 {
+			for _, name := range databases {
+				if err := failures[name]; err != nil {
+					fmt.Fprintf(out, "[%s] Destination not accessible: %v\n", name, err)
+				}
+			}
+			return fmt.Errorf("aborted: %d database(s) have an inaccessible destination, nothing was backed up (--skip-preflight to back up anyway)", len(failures))
+		}
+	}
+
+	// Pre-check retention policies. With skip_retention_precheck, retention is decided
+	// after each upload only, unless the plan is to be shown or confirmed.
+	var retentionPlan orchestrator.RetentionPlan
+	var retentionFailures orchestrator.RetentionFailures
+	precheck := !runCfg.SkipRetentionPrecheck || opts.showRetention || opts.confirmRetention || opts.retentionDryRun
+	if !opts.dryRun && !opts.stage && !opts.skipRetention && precheck {
+		retentionPlan, retentionFailures = orchestrator.PreCheckRetention(ctx, runCfg, databases)
+		for _, name := range databases {
+			if err := retentionFailures[name]; err != nil {
+				fmt.Fprintf(out, "[%s] Warning: %v\n", name, err)
+			}
+		}
+
+		// A retention dry run deletes nothing, so there is nothing to confirm
+		if opts.showRetention || opts.confirmRetention || opts.retentionDryRun {
+			total := printRetentionPlan(out, databases, retentionPlan)
+			if total > 0 && opts.retentionDryRun {
+				fmt.Fprintln(out, "Retention dry run: these backups will be reported, not deleted")
+			}
+			if total > 0 && opts.confirmRetention && !opts.retentionDryRun {
+				if err := confirm(out, "retention", fmt.Sprintf("Delete %d old backup(s)? [y/N]", total), answeredYes); err != nil {
+					fmt.Fprintf(out, "Keeping all backups (%v)\n", err)
+					opts.skipRetention = true
+					retentionPlan = nil
+				}
+			}
+		}
+	}
 
 	// Progress channel
 	progress := make(chan orchestrator.BackupProgress, 100)
 
 	// Start backup in background
 	done := make(chan struct{})
+	var results []orchestrator.BackupResult
 	go func() {
-		orchestrator.RunBackups(ctx, cfg, databases, orchestrator.BackupOptions{
-			DryRun:        dryRun,
-			SkipRetention: skipRetention,
+		results = orchestrator.RunBackups(ctx, runCfg, databases, orchestrator.BackupOptions{
+			DryRun:            opts.dryRun,
+			Stage:             opts.stage,
+			SkipRetention:     opts.skipRetention,
+			RetentionDryRun:   opts.retentionDryRun,
+			RetentionFailures: retentionFailures,
 		}, retentionPlan, progress)
 		close(progress)
 		close(done)
 	}()
 
-	// Print progress updates as they come in
+	errors := printBackupProgress(out, runCfg, progress, opts.jsonProgress)
+	<-done
+
+	// Summary
+	failed := len(errors)
+	succeeded := len(databases) - failed
+	if failed > 0 {
+		fmt.Fprintf(out, "Backup finished: %d succeeded, %d failed\n", succeeded, failed)
+	} else {
+		fmt.Fprintf(out, "Backup finished: %d succeeded\n", succeeded)
+	}
+	if opts.stage {
+		fmt.Fprintf(out, "Dumps staged in %s, run 'blobber upload-staged' to upload them\n", runCfg.StagingDirectory())
+	} else if !opts.dryRun {
+		fmt.Fprintln(out, orchestrator.SumStorageChange(results))
+	}
+
+	var cancelled int
+	for _, r := range results {
+		if r.DeadlineExceeded {
+			cancelled++
+		}
+	}
+	if cancelled > 0 {
+		fmt.Fprintf(out, "Deadline exceeded: %d database(s) did not finish in time\n", cancelled)
+	}
+
+	// Best-effort run report; uses a fresh context so it is written even past the deadline.
+	// Staged runs are reported by upload-staged.
+	if !opts.dryRun && !opts.stage {
+		report := orchestrator.NewRunReport(startedAt, time.Now(), results)
+		if path, err := orchestrator.WriteRunReportFor(context.Background(), runCfg, databases, report); err != nil {
+			fmt.Fprintf(out, "Warning: %v\n", err)
+		} else if path != "" {
+			fmt.Fprintf(out, "Run report written to %s\n", path)
+		}
+	}
+
+	return nil
+}
+
+// printBackupProgress prints progress updates until the channel is closed, as text or
+// as JSON objects on stdout. Returns the databases a step failed for.
+func printBackupProgress(out io.Writer, runCfg *config.Config, progress <-chan orchestrator.BackupProgress, jsonProgress bool) map[string]bool {
+	errors := make(map[string]bool)
+	encoder := json.NewEncoder(os.Stdout)
 	for p := range progress {
+		if p.Error != nil {
+			errors[p.DBName] = true
+		}
+		if jsonProgress {
+			if err := encoder.Encode(p); err != nil {
+				fmt.Fprintf(out, "Warning: writing progress: %v\n", err)
+			}
+			continue
+		}
+
 		// Get step name, with compression info for dump step
 		stepName := p.Step.String()
 		if p.Step == orchestrator.StepDumping {
-			if db, ok := cfg.Databases[p.DBName]; ok {
+			if db, ok := runCfg.Databases[p.DBName]; ok {
 				if label := backup.CompressionLabel(db.Compression); label != "" {
 					stepName = fmt.Sprintf("Dumping & compressing database (%s)", label)
 				}
 			}
 		}
 
-		if p.Error != nil {
+		if p.EstimatedSize > 0 {
+			fmt.Fprintf(out, "[%s] Estimated size: %s (before compression)\n", p.DBName, humanize.IBytes(uint64(p.EstimatedSize)))
+			if runCfg.ExceedsSizeWarning(p.EstimatedSize) {
+				fmt.Fprintf(out, "[%s] Warning: estimated size exceeds size_warning_mb (%d MB)\n", p.DBName, runCfg.SizeWarningMB)
+			}
+		} else if p.Error != nil {
 			// Error occurred
 			if p.Message != "" {
-				fmt.Printf("[%s] %s failed: %s\n", p.DBName, stepName, p.Message)
+				fmt.Fprintf(out, "[%s] %s failed: %s\n", p.DBName, stepName, p.Message)
 			} else {
-				fmt.Printf("[%s] %s failed: %v\n", p.DBName, stepName, p.Error)
+				fmt.Fprintf(out, "[%s] %s failed: %v\n", p.DBName, stepName, p.Error)
 			}
-			errorsMu.Lock()
-			errors[p.DBName] = true
-			errorsMu.Unlock()
 		} else if p.Message != "" {
 			// Step completed with message
 			if p.Skipped {
-				fmt.Printf("[%s] %s skipped: %s\n", p.DBName, stepName, p.Message)
+				fmt.Fprintf(out, "[%s] %s skipped: %s\n", p.DBName, stepName, p.Message)
 			} else {
-				fmt.Printf("[%s] %s completed: %s\n", p.DBName, stepName, p.Message)
+				fmt.Fprintf(out, "[%s] %s completed: %s\n", p.DBName, stepName, p.Message)
+			}
+			for _, warning := range p.Warnings {
+				fmt.Fprintf(out, "[%s] Warning: %s\n", p.DBName, warning)
 			}
 		} else {
 			// Step starting
-			fmt.Printf("[%s] %s...\n", p.DBName, stepName)
+			fmt.Fprintf(out, "[%s] %s...\n", p.DBName, stepName)
 		}
 	}
+	return errors
+}
 
-	<-done
-
-	// Summary
-	failed := len(errors)
-	succeeded := len(databases) - failed
-	if failed > 0 {
-		fmt.Printf("Backup finished: %d succeeded, %d failed\n", succeeded, failed)
-	} else {
-		fmt.Printf("Backup finished: %d succeeded\n", succeeded)
+// printRetentionPlan prints the files retention will delete, grouped by database.
+// Returns the total number of files.
+func printRetentionPlan(out io.Writer, databases []string, plan orchestrator.RetentionPlan) int {
+	var total int
+	for _, name := range databases {
+		total += len(plan[name])
+	}
+	if total == 0 {
+		fmt.Fprintln(out, "Retention policy will not delete any backups")
+		return 0
 	}
 
-	return nil
+	fmt.Fprintf(out, "Retention policy will delete %d backup(s):\n", total)
+	for _, name := range databases {
+		files := plan[name]
+		if len(files) == 0 {
+			continue
+		}
+		var size int64
+		for _, f := range files {
+			size += f.Size
+		}
+		fmt.Fprintf(out, "[%s] %d backup(s), %s\n", name, len(files), humanize.IBytes(uint64(size)))
+		for _, f := range files {
+			fmt.Fprintf(out, "  %s  %s  %s\n", f.Name, f.ModTime.Format("2006-01-02 15:04:05"), humanize.IBytes(uint64(f.Size)))
+		}
+	}
+	return total
 }
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Yoone/blobber/internal/orchestrator"
+	"github.com/spf13/cobra"
+)
+
+var cloneCmd = &cobra.Command{
+	Use:   "clone <source_db> <target_db> [backup_file]",
+	Short: "Restore a backup of one database into another",
+	Long: `Downloads a backup of the source database and restores it into the target database,
+e.g. to refresh staging from production. Uses the latest backup unless a file is given.
+Both databases must be of the same type. The overwrite of the target is confirmed
+first; without a terminal, pass --yes.
+
+Examples:
+  blobber clone prod staging                              # latest backup of prod
+  blobber clone prod staging prod_20240115_120000.sql.gz  # specific backup`,
+	Args: cobra.RangeArgs(2, 3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backupFile := ""
+		if len(args) == 3 {
+			backupFile = args[2]
+		}
+		return runClone(context.Background(), args[0], args[1], backupFile)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cloneCmd)
+}
+
+func runClone(ctx context.Context, sourceName, targetName, backupFile string) error {
+	source, ok := cfg.Databases[sourceName]
+	if !ok {
+		return fmt.Errorf("database %q not found in config", sourceName)
+	}
+	target, ok := cfg.Databases[targetName]
+	if !ok {
+		return fmt.Errorf("database %q not found in config", targetName)
+	}
+	if sourceName == targetName {
+		return fmt.Errorf("source and target are the same database, use restore instead")
+	}
+	if source.Type != target.Type {
+		return fmt.Errorf("cannot clone %s database %q into %s database %q", source.Type, sourceName, target.Type, targetName)
+	}
+
+	if backupFile == "" {
+		files, err := orchestrator.ListBackups(ctx, sourceName, source)
+		if err != nil {
+			return fmt.Errorf("listing backups: %w", err)
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no backups found for %q", sourceName)
+		}
+		latest := files[0]
+		for _, f := range files[1:] {
+			if f.ModTime.After(latest.ModTime) {
+				latest = f
+			}
+		}
+		backupFile = latest.Name
+		fmt.Printf("[%s] Using latest backup of %s: %s\n", targetName, sourceName, backupFile)
+	}
+
+	fmt.Printf("[%s] Restoring %s's backup INTO %s\n", targetName, sourceName, targetName)
+	return restoreInto(ctx, targetName, target, source.Dest, backupFile, false)
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Yoone/blobber/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var showEffective bool
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the config file",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the config with passwords masked",
+	Long: `Prints the config file with database passwords masked. Passwords given as a
+${VAR} reference are shown as written.
+
+With --effective, prints the config as blobber runs it instead: environment
+variables expanded, ports and compression filled in, and the defaults of
+connect_timeout, dictionary_dir and failed_uploads_dir written out.
+
+Examples:
+  blobber config show
+  blobber config show --effective
+  blobber --profile staging config show --effective`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigShow(showEffective)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+	configShowCmd.Flags().BoolVar(&showEffective, "effective", false, "Print the config with env vars expanded and defaults applied")
+}
+
+func runConfigShow(effective bool) error {
+	if effective {
+		fmt.Printf("# %s (effective)\n", cfg.Path())
+		return cfg.Effective().Encode(os.Stdout)
+	}
+
+	data, err := os.ReadFile(cfg.Path())
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	masked, err := config.MaskPasswords(data)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("# %s\n", cfg.Path())
+	_, err = os.Stdout.Write(masked)
+	return err
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// assumeYes answers every confirmation of a destructive operation (--yes)
+var assumeYes bool
+
+// Where confirmations read their answer, and whether anyone is there to give it
+// (variables so tests can answer them)
+var (
+	confirmInput       io.Reader = os.Stdin
+	confirmInteractive           = func() bool { return term.IsTerminal(int(os.Stdin.Fd())) }
+)
+
+// confirm asks question before action, a destructive operation, and returns an error
+// unless the answer typed back satisfies accept. With --yes nothing is asked. Without a
+// terminal there is nobody to ask, so the operation is refused unless --yes is given.
+func confirm(out io.Writer, action, question string, accept func(answer string) bool) error {
+	if assumeYes {
+		return nil
+	}
+	if !confirmInteractive() {
+		return fmt.Errorf("no terminal to confirm %s, pass --yes to proceed", action)
+	}
+
+	fmt.Fprint(out, question+" ")
+	answer, _ := bufio.NewReader(confirmInput).ReadString('\n')
+	if !accept(strings.TrimSpace(answer)) {
+		return fmt.Errorf("%s cancelled", action)
+	}
+	return nil
+}
+
+// answeredYes accepts y or yes, in any case, for [y/N] questions
+func answeredYes(answer string) bool {
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfirm(t *testing.T) {
+	input, interactive := confirmInput, confirmInteractive
+	t.Cleanup(func() {
+		assumeYes = false
+		confirmInput, confirmInteractive = input, interactive
+	})
+
+	tests := []struct {
+		name        string
+		yes         bool
+		interactive bool
+		input       string
+		accept      func(string) bool
+		wantErr     string
+	}{
+		{name: "answered yes", interactive: true, input: "y\n", accept: answeredYes},
+		{name: "answered YES", interactive: true, input: "YES\n", accept: answeredYes},
+		{name: "answered no", interactive: true, input: "n\n", accept: answeredYes, wantErr: "the restore cancelled"},
+		{name: "no answer", interactive: true, input: "", accept: answeredYes, wantErr: "the restore cancelled"},
+		{name: "count typed back", interactive: true, input: "3\n", accept: func(a string) bool { return a == "3" }},
+		{name: "no terminal", input: "y\n", accept: answeredYes, wantErr: "pass --yes"},
+		{name: "--yes without a terminal", yes: true, accept: answeredYes},
+		{name: "--yes on a terminal asks nothing", yes: true, interactive: true, input: "n\n", accept: answeredYes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assumeYes = tt.yes
+			confirmInput = strings.NewReader(tt.input)
+			confirmInteractive = func() bool { return tt.interactive }
+
+			var out strings.Builder
+			err := confirm(&out, "the restore", "Overwrite app.db? [y/N]", tt.accept)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("confirm() error = %v, want it confirmed", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("confirm() error = %v, want one containing %q", err, tt.wantErr)
+			}
+			asked := strings.Contains(out.String(), "Overwrite app.db?")
+			if wantAsked := tt.interactive && !tt.yes; asked != wantAsked {
+				t.Errorf("confirm() asked = %v, want %v", asked, wantAsked)
+			}
+		})
+	}
+}
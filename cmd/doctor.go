@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"time"
+
+	"github.com/Yoone/blobber/internal/backup"
+	"github.com/Yoone/blobber/internal/config"
+	"github.com/Yoone/blobber/internal/storage"
+	"github.com/Yoone/blobber/internal/version"
+	"github.com/spf13/cobra"
+)
+
+// doctorAccessTimeout bounds each destination access check
+const doctorAccessTimeout = 10 * time.Second
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the environment for common problems",
+	Long: `Checks everything blobber depends on and reports pass, warn or fail for each:
+the config file, the database client tools, the rclone config and access to every
+configured destination. Exits with an error if any check fails.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDoctor(context.Background(), os.Stdout)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+// doctorReport prints check results to out and counts failures
+type doctorReport struct {
+	out    io.Writer
+	failed int
+	warned int
+}
+
+func (r *doctorReport) pass(check, detail string) {
+	fmt.Fprintf(r.out, "PASS  %-22s %s\n", check, detail)
+}
+
+func (r *doctorReport) warn(check, detail string) {
+	r.warned++
+	fmt.Fprintf(r.out, "WARN  %-22s %s\n", check, detail)
+}
+
+func (r *doctorReport) fail(check, detail string) {
+	r.failed++
+	fmt.Fprintf(r.out, "FAIL  %-22s %s\n", check, detail)
+}
+
+func runDoctor(ctx context.Context, out io.Writer) error {
+	report := &doctorReport{out: out}
+	report.pass("version", "blobber "+version.String())
+
+	// Config
+	path, err := getConfigPath()
+	if err == nil {
+		cfg, err = config.Load(path)
+	}
+	if err != nil {
+		if path != "" && errors.Is(err, os.ErrNotExist) {
+			report.fail("config", fmt.Sprintf("%s not found (use -c to point at your config)", path))
+		} else {
+			report.fail("config", err.Error())
+		}
+		cfg = nil
+	} else {
+		detail := fmt.Sprintf("%s (%d database(s))", path, len(cfg.Databases))
+		if profile := cfg.Profile(); profile != "" {
+			detail = fmt.Sprintf("profile %s: %s", profile, detail)
+		}
+		report.pass("config", detail)
+		registerInlineDests(cfg)
+		for _, warning := range cfg.SharedDestinations() {
+			report.warn("shared dest", warning)
+		}
+	}
+
+	// Client tools: missing tools fail when a configured database needs them and are
+	// only a warning when the config could not be read to tell
+	needed := make(map[string]bool)
+	if cfg != nil {
+		for _, db := range cfg.Databases {
+			needed[db.Type] = true
+		}
+	}
+	for _, dbType := range []string{"mysql", "postgres"} {
+		for _, tool := range backup.RequiredTools(dbType) {
+			path, err := tool.Find()
+			if err != nil {
+				detail := fmt.Sprintf("not found in PATH (required for %s %s)", dbType, tool.Purpose)
+				switch {
+				case needed[dbType]:
+					report.fail(tool.Binary, detail)
+				case cfg == nil:
+					report.warn(tool.Binary, detail)
+				default:
+					report.pass(tool.Binary, fmt.Sprintf("not installed, not needed (no %s databases configured)", dbType))
+				}
+				continue
+			}
+			versionLine, err := backup.ToolVersion(ctx, path)
+			if err != nil {
+				report.warn(tool.Binary, fmt.Sprintf("found but --version failed: %v", err))
+				continue
+			}
+			report.pass(tool.Binary, versionLine)
+		}
+	}
+
+	// Encryption tools are only checked when a database encrypts its backups
+	if cfg != nil {
+		checked := make(map[string]bool)
+		for _, db := range cfg.Databases {
+			for _, tool := range backup.EncryptionTools(db) {
+				if checked[tool.Binary] {
+					continue
+				}
+				checked[tool.Binary] = true
+				if _, err := exec.LookPath(tool.Binary); err != nil {
+					report.fail(tool.Binary, fmt.Sprintf("not found in PATH (required for %s)", tool.Purpose))
+					continue
+				}
+				versionLine, err := backup.ToolVersion(ctx, tool.Binary)
+				if err != nil {
+					report.warn(tool.Binary, fmt.Sprintf("found but --version failed: %v", err))
+					continue
+				}
+				report.pass(tool.Binary, versionLine)
+			}
+		}
+	}
+
+	// zstd dictionaries, read from the config's dictionary_dir as dumps and restores do
+	if cfg != nil {
+		settings := backup.SettingsFrom(cfg)
+		checked := make(map[uint32]bool)
+		for _, name := range sortedDatabaseNames(cfg) {
+			id := cfg.Databases[name].ZstdDictionary
+			if id == 0 || checked[id] {
+				continue
+			}
+			checked[id] = true
+			check := fmt.Sprintf("dictionary %d", id)
+			if err := settings.CheckDictionary(id); err != nil {
+				report.fail(check, fmt.Sprintf("%v (used by %s)", err, name))
+				continue
+			}
+			report.pass(check, backup.DictionaryPath(settings.DictionaryDir, id))
+		}
+	}
+
+	// Rclone config
+	rclonePath := storage.ConfigPath()
+	remotes := storage.RemoteNames()
+	if _, err := os.Stat(rclonePath); err != nil {
+		report.warn("rclone config", fmt.Sprintf("%s not found (only local destinations will work)", rclonePath))
+	} else {
+		report.pass("rclone config", fmt.Sprintf("%s (%d remote(s))", rclonePath, len(remotes)))
+	}
+
+	// Destinations
+	if cfg != nil {
+		for _, name := range sortedDatabaseNames(cfg) {
+			dest := cfg.Databases[name].Dest
+			checkCtx, cancel := context.WithTimeout(ctx, doctorAccessTimeout)
+			err := storage.TestAccess(checkCtx, dest)
+			cancel()
+			if err != nil {
+				report.fail("dest "+name, fmt.Sprintf("%s: %v", dest, err))
+			} else {
+				report.pass("dest "+name, dest+" accessible")
+			}
+		}
+	}
+
+	fmt.Fprintln(out)
+	if report.failed > 0 {
+		return fmt.Errorf("%d check(s) failed, %d warning(s)", report.failed, report.warned)
+	}
+	if report.warned > 0 {
+		fmt.Fprintf(out, "All checks passed with %d warning(s)\n", report.warned)
+	} else {
+		fmt.Fprintln(out, "All checks passed")
+	}
+	return nil
+}
+
+// sortedDatabaseNames returns the database names of cfg in order, for a stable report
+func sortedDatabaseNames(cfg *config.Config) []string {
+	names := make([]string, 0, len(cfg.Databases))
+	for name := range cfg.Databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunDoctor(t *testing.T) {
+	file, saved := cfgFile, cfg
+	t.Cleanup(func() { cfgFile, cfg = file, saved })
+	// No client tools are installed
+	t.Setenv("PATH", t.TempDir())
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "backups")
+	if err := os.Mkdir(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig := func(t *testing.T, yaml string) {
+		t.Helper()
+		cfgFile = filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(cfgFile, []byte(yaml), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		config   string // written to the config file; empty leaves it missing
+		wantErr  bool
+		want     []string // lines of the report, by prefix
+		dontWant []string
+	}{
+		{
+			name: "healthy file database",
+			config: `databases:
+  app:
+    type: file
+    path: ` + filepath.Join(dir, "app.db") + `
+    dest: ` + dest + "\n",
+			want: []string{
+				"PASS  config ",
+				"PASS  mysqldump              not installed, not needed (no mysql databases configured)",
+				"PASS  dest app               " + dest + " accessible",
+			},
+			dontWant: []string{"FAIL"},
+		},
+		{
+			name:    "missing config",
+			wantErr: true,
+			want:    []string{"FAIL  config ", "WARN  mysqldump              not found in PATH (required for mysql backup)"},
+		},
+		{
+			name: "database without its client tools",
+			config: `databases:
+  shop:
+    type: mysql
+    host: localhost
+    user: root
+    database: shop
+    dest: ` + dest + "\n",
+			wantErr: true,
+			want:    []string{"FAIL  mysqldump              not found in PATH (required for mysql backup)", "FAIL  mysql "},
+		},
+		{
+			name: "unreachable destination",
+			config: `databases:
+  app:
+    type: file
+    path: ` + filepath.Join(dir, "app.db") + `
+    dest: ` + filepath.Join(dir, "missing") + "\n",
+			wantErr: true,
+			want:    []string{"FAIL  dest app "},
+		},
+		{
+			name: "missing zstd dictionary",
+			config: `dictionary_dir: ` + filepath.Join(dir, "dicts") + `
+databases:
+  app:
+    type: file
+    path: ` + filepath.Join(dir, "app.db") + `
+    dest: ` + dest + `
+    compression: zstd
+    zstd_dictionary: 40000
+`,
+			wantErr: true,
+			want:    []string{"FAIL  dictionary 40000 "},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.config != "" {
+				writeConfig(t, tt.config)
+			} else {
+				cfgFile = filepath.Join(t.TempDir(), "missing.yaml")
+			}
+
+			var out strings.Builder
+			err := runDoctor(context.Background(), &out)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("runDoctor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			lines := strings.Split(out.String(), "\n")
+			for _, want := range tt.want {
+				if !hasLinePrefix(lines, want) {
+					t.Errorf("report is missing %q:\n%s", want, out.String())
+				}
+			}
+			for _, unwanted := range tt.dontWant {
+				if hasLinePrefix(lines, unwanted) {
+					t.Errorf("report has %q:\n%s", unwanted, out.String())
+				}
+			}
+		})
+	}
+}
+
+func hasLinePrefix(lines []string, prefix string) bool {
+	for _, line := range lines {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
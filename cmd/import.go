@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Yoone/blobber/internal/orchestrator"
+	"github.com/Yoone/blobber/internal/storage"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var (
+	importDryRun   bool
+	importMove     bool
+	importUseMTime bool
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import <database> <source>",
+	Short: "Import backups made outside blobber into its naming convention",
+	Long: `Copies the files at source (a local directory or rclone path) to the database's
+destination under blobber's name_YYYYMMDD_HHMMSS.ext names, so they are listed for
+restore and subject to retention like any other backup.
+
+When a backup was taken is read from the date in its filename (dump_2024_01_15.sql.gz,
+app-2024-01-15T0300.sql, 15.01.2024.sql...), or taken from its modification time when the
+name holds none. Files whose name holds several dates, a day and month that could be
+either way round, or a date in the future are skipped with a warning; pass --mtime to
+import every file by its modification time instead.
+
+Examples:
+  blobber import shop /var/backups/shop --dry-run  # preview the new names
+  blobber import shop s3:old-bucket/shop --move    # rename instead of copying
+  blobber import shop /var/backups/shop --mtime    # ignore the dates in the names`,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runImport(context.Background(), args[0], args[1])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.Flags().BoolVar(&importDryRun, "dry-run", false, "Only show the backups the files would be imported as")
+	importCmd.Flags().BoolVar(&importMove, "move", false, "Move the files instead of copying them")
+	importCmd.Flags().BoolVar(&importUseMTime, "mtime", false, "Date every file by its modification time, ignoring its name")
+}
+
+func runImport(ctx context.Context, name, src string) error {
+	db, ok := cfg.Databases[name]
+	if !ok {
+		return fmt.Errorf("database %q not found in config", name)
+	}
+	if db.AllDatabases() {
+		return fmt.Errorf("%q backs up all databases: import into an entry for a single database", name)
+	}
+
+	files, err := storage.List(ctx, src)
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", src, err)
+	}
+	existing, err := orchestrator.ListBackups(ctx, name, db)
+	if err != nil {
+		return fmt.Errorf("listing backups: %w", err)
+	}
+	var existingNames []string
+	for _, f := range existing {
+		existingNames = append(existingNames, f.Name)
+	}
+
+	plan := orchestrator.PlanImport(name, db, files, existingNames, importUseMTime, time.Now())
+	var toImport, badDates int
+	for _, f := range plan {
+		switch {
+		case f.Skip != "":
+			fmt.Printf("  Skipped %s: %s\n", f.Source, f.Skip)
+			if f.BadDate {
+				badDates++
+			}
+		case f.FromMTime:
+			toImport++
+			fmt.Printf("  %s → %s  %s (modification time)\n", f.Source, f.Target, humanize.IBytes(uint64(f.Size)))
+		default:
+			toImport++
+			fmt.Printf("  %s → %s  %s\n", f.Source, f.Target, humanize.IBytes(uint64(f.Size)))
+		}
+	}
+	if badDates > 0 {
+		fmt.Printf("Warning: %d file(s) skipped for the date in their name, --mtime imports them by modification time\n", badDates)
+	}
+	if toImport == 0 {
+		fmt.Printf("No files to import from %s\n", src)
+		return nil
+	}
+	if importDryRun {
+		fmt.Printf("Dry run: %d file(s) would be imported to %s\n", toImport, db.Dest)
+		return nil
+	}
+
+	results, warnings := orchestrator.RunImport(ctx, db, src, plan, importMove, func(r orchestrator.ImportResult) {
+		if r.Error != nil {
+			fmt.Printf("[%s] Failed to import %s: %v\n", name, r.File.Source, r.Error)
+			return
+		}
+		fmt.Printf("[%s] Imported %s as %s\n", name, r.File.Source, r.File.Target)
+	})
+	for _, warning := range warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+
+	var failed int
+	for _, r := range results {
+		if r.Error != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("Import finished: %d imported, %d failed\n", len(results)-failed, failed)
+		return fmt.Errorf("%d file(s) not imported", failed)
+	}
+	fmt.Printf("Import finished: %d imported to %s\n", len(results), db.Dest)
+	return nil
+}
@@ -4,23 +4,52 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/Yoone/blobber/internal/storage"
+	"github.com/Yoone/blobber/internal/orchestrator"
+	"github.com/Yoone/blobber/internal/retention"
 	"github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
 )
 
+var listDatabasesFrom string
+
 var listCmd = &cobra.Command{
 	Use:   "list <db_name>",
 	Short: "List backups for a database",
-	Long:  `Lists all backup files stored in the cloud for the specified database.`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Lists all backup files stored in the cloud for the specified database.
+
+Use --databases-from to list backups for every database named in a file instead.`,
+	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runList(context.Background(), args[0])
+		ctx := context.Background()
+		if listDatabasesFrom == "" {
+			if len(args) != 1 {
+				return fmt.Errorf("requires a database name or --databases-from")
+			}
+			return runList(ctx, args[0])
+		}
+		if len(args) > 0 {
+			return fmt.Errorf("cannot combine a database argument with --databases-from")
+		}
+
+		databases, err := databasesFromFile(listDatabasesFrom)
+		if err != nil {
+			return err
+		}
+		for i, name := range databases {
+			if i > 0 {
+				fmt.Println()
+			}
+			if err := runList(ctx, name); err != nil {
+				fmt.Printf("[%s] Listing failed: %v\n", name, err)
+			}
+		}
+		return nil
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().StringVar(&listDatabasesFrom, "databases-from", "", "List backups for the databases named in this file (one name per line)")
 }
 
 func runList(ctx context.Context, dbName string) error {
@@ -29,7 +58,7 @@ func runList(ctx context.Context, dbName string) error {
 		return fmt.Errorf("database %q not found in config", dbName)
 	}
 
-	files, err := storage.ListForDatabase(ctx, db.Dest, dbName)
+	files, err := orchestrator.ListBackups(ctx, dbName, db)
 	if err != nil {
 		return err
 	}
@@ -40,8 +69,13 @@ func runList(ctx context.Context, dbName string) error {
 	}
 
 	fmt.Printf("[%s] %d backup(s) in %s\n", dbName, len(files), db.Dest)
+	deltas := retention.SizeDeltas(files)
 	for _, f := range files {
-		fmt.Printf("%s  %s  %s\n", f.Name, f.ModTime.Format("2006-01-02 15:04:05"), humanize.IBytes(uint64(f.Size)))
+		line := fmt.Sprintf("%s  %s  %s", f.Name, f.ModTime.Format("2006-01-02 15:04:05"), humanize.IBytes(uint64(f.Size)))
+		if delta, ok := deltas[f.Name]; ok {
+			line += "  " + orchestrator.FormatSizeDelta(delta) + " since previous"
+		}
+		fmt.Println(line)
 	}
 
 	return nil
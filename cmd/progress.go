@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Yoone/blobber/internal/storage"
+	"github.com/dustin/go-humanize"
+	"golang.org/x/term"
+)
+
+// progressLogInterval is how often a transfer logs a progress line when stdout is not a terminal
+const progressLogInterval = 10 * time.Second
+
+// progressBarWidth is the number of cells in the single-line terminal progress bar
+const progressBarWidth = 30
+
+// printTransferProgress consumes progress updates until the transfer finishes, returning
+// its error. On a terminal it redraws a single progress line; otherwise (logs, SSH without
+// a TTY) it prints a line periodically so long transfers stay observable.
+func printTransferProgress(dbName string, progress <-chan storage.TransferProgress) error {
+	live := term.IsTerminal(int(os.Stdout.Fd()))
+	var lastLog time.Time
+
+	for p := range progress {
+		if p.Done {
+			if live {
+				fmt.Print("\r\033[K")
+			}
+			return p.Error
+		}
+		if p.Retrying != nil {
+			if live {
+				fmt.Print("\r\033[K")
+			}
+			fmt.Printf("[%s] Download failed: %v, retrying from the start (attempt %d of %d)\n", dbName, p.Retrying, p.Attempt, p.Attempts)
+			continue
+		}
+
+		if live && p.TotalKnown() {
+			fmt.Printf("\r\033[K[%s] %s %s", dbName, progressBar(p.Fraction()), formatTransferProgress(p))
+		} else if live {
+			fmt.Printf("\r\033[K[%s] %s", dbName, formatTransferProgress(p))
+		} else if time.Since(lastLog) >= progressLogInterval {
+			fmt.Printf("[%s] %s\n", dbName, formatTransferProgress(p))
+			lastLog = time.Now()
+		}
+	}
+	return nil
+}
+
+// formatTransferProgress renders percent, byte counts, speed and ETA
+// (e.g. "42% 1.1 GiB / 2.6 GiB • 12 MiB/s • ETA 2m5s"), or only the bytes transferred
+// and speed when the total is unknown (e.g. "1.1 GiB transferred • 12 MiB/s")
+func formatTransferProgress(p storage.TransferProgress) string {
+	line := fmt.Sprintf("%s transferred", humanize.IBytes(uint64(p.BytesDone)))
+	if p.TotalKnown() {
+		line = fmt.Sprintf("%3.0f%% %s / %s", p.Fraction()*100,
+			humanize.IBytes(uint64(p.BytesDone)), humanize.IBytes(uint64(p.BytesTotal)))
+	}
+	if p.Speed > 0 {
+		line += fmt.Sprintf(" • %s/s", humanize.IBytes(uint64(p.Speed)))
+	}
+	if eta := p.ETA(); eta > 0 {
+		line += fmt.Sprintf(" • ETA %s", eta)
+	}
+	return line
+}
+
+// progressBar renders a fixed-width bar such as "[=========>          ]"
+func progressBar(fraction float64) string {
+	filled := int(fraction * progressBarWidth)
+	if filled >= progressBarWidth {
+		return "[" + strings.Repeat("=", progressBarWidth) + "]"
+	}
+	return "[" + strings.Repeat("=", filled) + ">" + strings.Repeat(" ", progressBarWidth-filled-1) + "]"
+}
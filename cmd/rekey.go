@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/Yoone/blobber/internal/backup"
+	"github.com/Yoone/blobber/internal/lock"
+	"github.com/Yoone/blobber/internal/orchestrator"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rekeyFrom   string
+	rekeyTo     []string
+	rekeyDryRun bool
+)
+
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey <database>",
+	Short: "Re-encrypt existing backups to new gpg recipients",
+	Long: `Re-encrypts the gpg-encrypted backups of a database to new recipients, e.g. after
+a key was compromised. Each backup is downloaded, decrypted and encrypted again to the
+recipients of --to, then uploaded next to the original. The original is only replaced
+once the new copy's checksum is confirmed on the destination, and the database itself
+is never touched. The local tier of a tiered dest is re-encrypted too. The backups to
+replace are confirmed first; without a terminal, pass --yes.
+
+--from names the old secret key to decrypt with; without it gpg uses whichever key of
+the keyring the backup was encrypted to. gpg only tries --from first and may still
+decrypt with another key of the keyring, so a backup it did not decrypt with --from is
+refused. --to defaults to the recipients in the config,
+so updating encryption.recipients and running rekey moves every backup to the new keys.
+
+Examples:
+  blobber rekey shop --dry-run                       # list the backups to re-encrypt
+  blobber rekey shop --from OLDKEYID --to ops@example.com
+  blobber rekey shop                                 # to the recipients in the config`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRekey(context.Background(), args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rekeyCmd)
+	rekeyCmd.Flags().StringVar(&rekeyFrom, "from", "", "Secret key (ID, fingerprint or email) to decrypt the backups with")
+	rekeyCmd.Flags().StringSliceVar(&rekeyTo, "to", nil, "Recipients to encrypt the backups to (default: encryption.recipients)")
+	rekeyCmd.Flags().BoolVar(&rekeyDryRun, "dry-run", false, "Only list the backups that would be re-encrypted")
+}
+
+func runRekey(ctx context.Context, name string) error {
+	db, ok := cfg.Databases[name]
+	if !ok {
+		return fmt.Errorf("database %q not found in config", name)
+	}
+	recipients := rekeyTo
+	if len(recipients) == 0 && db.Encryption != nil {
+		recipients = db.Encryption.Recipients
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("%q has no encryption recipients in the config: pass them with --to", name)
+	}
+
+	// A backup or prune of the same config may be writing to the destination
+	runLock, err := lock.Acquire(lock.PathFor(cfg.Path()))
+	if err != nil {
+		return err
+	}
+	defer runLock.Release()
+
+	targets, plain, err := orchestrator.PlanRekey(ctx, name, db)
+	if err != nil {
+		return err
+	}
+	if plain > 0 {
+		fmt.Printf("Skipping %d unencrypted backup(s)\n", plain)
+	}
+	var total int
+	for _, target := range targets {
+		for _, f := range target.Files {
+			total++
+			if rekeyDryRun {
+				fmt.Printf("  %s  %s  (%s)\n", f.Name, humanize.IBytes(uint64(f.Size)), target.Dest)
+			}
+		}
+	}
+	if total == 0 {
+		fmt.Printf("No encrypted backups of %q to re-encrypt\n", name)
+		return nil
+	}
+	if rekeyDryRun {
+		fmt.Printf("Dry run: %d backup(s) would be re-encrypted to %v\n", total, recipients)
+		return nil
+	}
+	if err := confirm(os.Stdout, "the rekey", fmt.Sprintf("Replace %d backup(s) with copies encrypted to %v? [y/N]", total, recipients), answeredYes); err != nil {
+		return err
+	}
+
+	results, warnings := orchestrator.RunRekey(ctx, db, targets, rekeyFrom, recipients, backup.SettingsFrom(cfg), func(r orchestrator.RekeyResult) {
+		if r.Error != nil {
+			fmt.Printf("[%s] Failed to re-encrypt %s: %v\n", name, r.File, r.Error)
+			return
+		}
+		fmt.Printf("[%s] Re-encrypted %s\n", name, r.File)
+	})
+	for _, warning := range warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	if db.Encryption == nil || !slices.Equal(db.Encryption.Recipients, recipients) {
+		fmt.Println("Warning: encryption.recipients in the config differs from --to, update it so new backups are encrypted to the new keys")
+	}
+
+	var failed int
+	for _, r := range results {
+		if r.Error != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("Rekey finished: %d re-encrypted, %d failed (left as they were)\n", len(results)-failed, failed)
+		return fmt.Errorf("%d backup(s) not re-encrypted", failed)
+	}
+	fmt.Printf("Rekey finished: %d backup(s) re-encrypted to %v\n", len(results), recipients)
+	return nil
+}
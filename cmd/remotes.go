@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Yoone/blobber/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	favoriteRemote   string
+	unfavoriteRemote string
+)
+
+var remotesCmd = &cobra.Command{
+	Use:   "remotes",
+	Short: "List rclone remotes usable as backup destinations",
+	Long: `Lists the remotes found in the rclone config and tests whether each one can be used
+as a backup destination (backend type recognized and root accessible).
+
+Favorite remotes are marked with ★ and suggested first when entering a destination in the TUI.
+
+Examples:
+  blobber remotes                   # list remotes with a usable verdict
+  blobber remotes --favorite s3     # mark 's3' as a favorite
+  blobber remotes --unfavorite s3   # remove 's3' from favorites`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if favoriteRemote != "" || unfavoriteRemote != "" {
+			return updateFavoriteRemotes(favoriteRemote, unfavoriteRemote)
+		}
+		return runRemotes(context.Background())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(remotesCmd)
+	remotesCmd.Flags().StringVar(&favoriteRemote, "favorite", "", "Mark a remote as a favorite destination")
+	remotesCmd.Flags().StringVar(&unfavoriteRemote, "unfavorite", "", "Remove a remote from favorite destinations")
+}
+
+func runRemotes(ctx context.Context) error {
+	fmt.Println("Checking rclone remotes...")
+	checks := storage.CheckRemotes(ctx, 10*time.Second)
+	if len(checks) == 0 {
+		fmt.Println("No rclone remotes configured")
+		return nil
+	}
+
+	// Align columns on the longest name and type
+	nameWidth, typeWidth := 0, 0
+	for _, c := range checks {
+		nameWidth = max(nameWidth, len(c.Name))
+		typeWidth = max(typeWidth, len(c.Type)+2)
+	}
+
+	var usable int
+	for _, c := range checks {
+		mark := " "
+		if cfg.IsFavoriteRemote(c.Name) {
+			mark = "★"
+		}
+		verdict := "usable"
+		if c.Usable {
+			usable++
+		} else {
+			verdict = "not usable: " + c.Reason
+		}
+		fmt.Printf("%s %-*s  %-*s  %s\n", mark, nameWidth, c.Name, typeWidth, "("+c.Type+")", verdict)
+	}
+
+	fmt.Printf("%d of %d remote(s) usable as destinations (use as \"<remote>:<path>\")\n", usable, len(checks))
+	return nil
+}
+
+func updateFavoriteRemotes(add, remove string) error {
+	if add != "" {
+		if !storage.RemoteExists(add) {
+			return fmt.Errorf("rclone remote %q not found", add)
+		}
+		cfg.SetFavoriteRemote(add, true)
+	}
+	if remove != "" {
+		cfg.SetFavoriteRemote(remove, false)
+	}
+
+	if err := cfg.Save(); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+
+	if add != "" {
+		fmt.Printf("Marked %s as a favorite remote\n", add)
+	}
+	if remove != "" {
+		fmt.Printf("Removed %s from favorite remotes\n", remove)
+	}
+	return nil
+}
@@ -7,26 +7,98 @@ import (
 	"path/filepath"
 
 	"github.com/Yoone/blobber/internal/backup"
+	"github.com/Yoone/blobber/internal/config"
+	"github.com/Yoone/blobber/internal/orchestrator"
 	"github.com/Yoone/blobber/internal/storage"
 	"github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
 )
 
-var localRestore bool
+var (
+	localRestore bool
+	restoreFrom  string
+	thenBackup   bool
+	thenBackupDB string
+)
 
 var restoreCmd = &cobra.Command{
 	Use:   "restore <db_name> <backup_file>",
 	Short: "Restore a database from backup",
-	Long:  `Downloads the specified backup file and restores it to the database. Use --local to restore from a local file instead.`,
-	Args:  cobra.ExactArgs(2),
+	Long: `Downloads the specified backup file and restores it to the database. Use --local to restore from a local file instead.
+
+The restore asks for confirmation before overwriting the database. Without a terminal,
+pass --yes instead.
+
+Use --from to restore a backup from any rclone path, even one that is not a configured
+destination.
+
+Use --then-backup to back up the database once the restore succeeds, making the restored
+state its newest backup. --then-backup-db backs up another configured database instead.
+
+Examples:
+  blobber restore mydb mydb_20240115_120000.sql.gz
+  blobber restore --local mydb /path/to/backup.sql.gz
+  blobber restore mydb --from s3:other-bucket/exports/mydb.sql.gz --yes
+  blobber restore staging staging_20240115_120000.sql.gz --then-backup`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if restoreFrom != "" {
+			return cobra.ExactArgs(1)(cmd, args)
+		}
+		return cobra.ExactArgs(2)(cmd, args)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runRestore(context.Background(), args[0], args[1], localRestore)
+		ctx := context.Background()
+		var err error
+		// --then-backup-db implies --then-backup
+		backupAfter := ""
+		if thenBackupDB != "" {
+			backupAfter = thenBackupDB
+		} else if thenBackup {
+			backupAfter = args[0]
+		}
+		if _, ok := cfg.Databases[backupAfter]; backupAfter != "" && !ok {
+			return fmt.Errorf("database %q not found in config", backupAfter)
+		}
+		// Of a database "*" entry, only the database restored into is backed up
+		backupCfg := cfg
+		if backupAfter == args[0] && cfg.Databases[backupAfter].AllDatabases() {
+			fileName := args[len(args)-1]
+			if restoreFrom != "" {
+				if _, fileName, err = storage.SplitRemoteFile(restoreFrom); err != nil {
+					return fmt.Errorf("invalid --from path: %w", err)
+				}
+			}
+			target, err := orchestrator.RestoreTarget(backupAfter, cfg.Databases[backupAfter], filepath.Base(fileName))
+			if err != nil {
+				return err
+			}
+			if backupCfg, backupAfter, err = orchestrator.ExpandedConfig(cfg, backupAfter, target.Database); err != nil {
+				return err
+			}
+		}
+
+		if restoreFrom != "" {
+			err = runRestoreFrom(ctx, args[0], restoreFrom)
+		} else {
+			err = runRestore(ctx, args[0], args[1], localRestore)
+		}
+		if err != nil || backupAfter == "" {
+			return err
+		}
+
+		fmt.Printf("Backing up %s after the restore...\n", backupAfter)
+		cfg = backupCfg
+		return runBackup(ctx, []string{backupAfter}, backupRunOptions{})
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(restoreCmd)
 	restoreCmd.Flags().BoolVar(&localRestore, "local", false, "Restore from a local file instead of downloading from remote")
+	restoreCmd.Flags().StringVar(&restoreFrom, "from", "", "Restore from an arbitrary rclone path to a backup file")
+	restoreCmd.Flags().BoolVar(&thenBackup, "then-backup", false, "Back up the database after a successful restore")
+	restoreCmd.Flags().StringVar(&thenBackupDB, "then-backup-db", "", "Back up this database after a successful restore (implies --then-backup)")
+	restoreCmd.MarkFlagsMutuallyExclusive("local", "from")
 }
 
 func runRestore(ctx context.Context, dbName, backupFile string, local bool) error {
@@ -35,7 +107,47 @@ func runRestore(ctx context.Context, dbName, backupFile string, local bool) erro
 		return fmt.Errorf("database %q not found in config", dbName)
 	}
 
+	return restoreInto(ctx, dbName, db, db.Dest, backupFile, local)
+}
+
+// runRestoreFrom restores a backup from an rclone path outside the configured destinations
+func runRestoreFrom(ctx context.Context, dbName, remotePath string) error {
+	db, ok := cfg.Databases[dbName]
+	if !ok {
+		return fmt.Errorf("database %q not found in config", dbName)
+	}
+
+	dir, fileName, err := storage.SplitRemoteFile(remotePath)
+	if err != nil {
+		return fmt.Errorf("invalid --from path: %w", err)
+	}
+	if err := storage.TestAccess(ctx, dir); err != nil {
+		return fmt.Errorf("invalid --from path %q: %w", remotePath, err)
+	}
+
+	return restoreInto(ctx, dbName, db, dir, fileName, false)
+}
+
+// restoreInto restores backupFile into db once confirmed. Remote backups are downloaded
+// from sourceDest, which is the database's own destination except when cloning from
+// another database.
+func restoreInto(ctx context.Context, dbName string, db config.Database, sourceDest, backupFile string, local bool) error {
+	// A database "*" entry restores into the database the backup was taken from
+	db, err := orchestrator.RestoreTarget(dbName, db, filepath.Base(backupFile))
+	if err != nil {
+		return err
+	}
+	target := fmt.Sprintf("database %q", db.Database)
+	if db.Type == "file" {
+		target = db.Path
+	}
+	if err := confirm(os.Stdout, "the restore", fmt.Sprintf("Overwrite %s with %s? [y/N]", target, filepath.Base(backupFile)), answeredYes); err != nil {
+		return err
+	}
+
 	var localPath string
+	var size int64 // of the backup, for the restore audit
+	source := sourceDest
 
 	if local {
 		// Use local file directly
@@ -45,6 +157,9 @@ func runRestore(ctx context.Context, dbName, backupFile string, local bool) erro
 			return fmt.Errorf("local file not found: %w", err)
 		}
 		fmt.Printf("[%s] Using local file: %s (%s)\n", dbName, localPath, humanize.IBytes(uint64(stat.Size())))
+		size, source = stat.Size(), "local"
+	} else if orchestrator.StreamsRestore(db, backupFile) {
+		return streamRestore(ctx, dbName, db, sourceDest, backupFile)
 	} else {
 		// Download from remote
 		tmpDir, err := os.MkdirTemp("", "blobber-restore-")
@@ -55,12 +170,19 @@ func runRestore(ctx context.Context, dbName, backupFile string, local bool) erro
 
 		localPath = filepath.Join(tmpDir, backupFile)
 
-		fmt.Printf("[%s] Downloading %s from %s...\n", dbName, backupFile, db.Dest)
-		if err := storage.Download(ctx, db.Dest, backupFile, tmpDir); err != nil {
+		fmt.Printf("[%s] Downloading %s from %s...\n", dbName, backupFile, sourceDest)
+		fileSize, err := storage.FileSize(ctx, sourceDest, backupFile)
+		if err != nil {
+			return fmt.Errorf("downloading backup: %w", err)
+		}
+		progress := make(chan storage.TransferProgress, 10)
+		go storage.DownloadWithProgress(ctx, sourceDest, backupFile, tmpDir, fileSize, backup.SettingsFrom(cfg).Download, progress)
+		if err := printTransferProgress(dbName, progress); err != nil {
 			return fmt.Errorf("downloading backup: %w", err)
 		}
 		stat, _ := os.Stat(localPath)
 		fmt.Printf("[%s] Download completed (%s)\n", dbName, humanize.IBytes(uint64(stat.Size())))
+		size = stat.Size()
 	}
 
 	restoreMsg := "Restoring database"
@@ -70,10 +192,49 @@ func runRestore(ctx context.Context, dbName, backupFile string, local bool) erro
 		}
 	}
 	fmt.Printf("[%s] %s...\n", dbName, restoreMsg)
-	if err := backup.Restore(db, localPath); err != nil {
+	err = backup.Restore(db, localPath, backup.SettingsFrom(cfg))
+	for _, warning := range orchestrator.WriteRestoreRecord(ctx, cfg, orchestrator.NewRestoreRecord(dbName, source, backupFile, size, err)) {
+		fmt.Printf("[%s] Warning: %s\n", dbName, warning)
+	}
+	if err != nil {
 		return fmt.Errorf("restoring backup: %w", err)
 	}
 
 	fmt.Printf("[%s] Restore completed successfully\n", dbName)
+	return postRestore(dbName, db)
+}
+
+// streamRestore restores backupFile into db as it is downloaded from sourceDest, for
+// stream_restore: the download's progress is shown while the restore reads it
+func streamRestore(ctx context.Context, dbName string, db config.Database, sourceDest, backupFile string) error {
+	fileSize, err := storage.FileSize(ctx, sourceDest, backupFile)
+	if err != nil {
+		return fmt.Errorf("downloading backup: %w", err)
+	}
+	fmt.Printf("[%s] Streaming %s from %s into the database (%s)...\n", dbName, backupFile, sourceDest, humanize.IBytes(uint64(fileSize)))
+	progress := make(chan storage.TransferProgress, 10)
+	go orchestrator.StreamRestore(ctx, db, sourceDest, backupFile, fileSize, backup.SettingsFrom(cfg), progress)
+	err = printTransferProgress(dbName, progress)
+	for _, warning := range orchestrator.WriteRestoreRecord(ctx, cfg, orchestrator.NewRestoreRecord(dbName, sourceDest, backupFile, fileSize, err)) {
+		fmt.Printf("[%s] Warning: %s\n", dbName, warning)
+	}
+	if err != nil {
+		return fmt.Errorf("restoring backup: %w", err)
+	}
+
+	fmt.Printf("[%s] Restore completed successfully\n", dbName)
+	return postRestore(dbName, db)
+}
+
+// postRestore runs the post-restore steps of db, if any
+func postRestore(dbName string, db config.Database) error {
+	if backup.HasPostRestore(db) {
+		fmt.Printf("[%s] Running post-restore steps...\n", dbName)
+		msg, err := backup.PostRestore(db, backup.SettingsFrom(cfg))
+		if err != nil {
+			return fmt.Errorf("post-restore: %w", err)
+		}
+		fmt.Printf("[%s] %s\n", dbName, msg)
+	}
 	return nil
 }
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Yoone/blobber/internal/orchestrator"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var (
+	batchRestoreAll         bool
+	batchRestoreAt          string
+	batchRestoreSources     map[string]string
+	batchRestoreConcurrency int
+)
+
+var restoreBatchCmd = &cobra.Command{
+	Use:   "restore-batch [database...]",
+	Short: "Restore several databases at once",
+	Long: `Restores the latest backup of each given database, or of every database with --all,
+restoring up to restore_concurrency databases at a time (default 2).
+
+Use --at to restore every database to the same point in time: each restores its newest
+backup taken at or before it. A date alone means the end of that day. A database "*"
+entry restores each database it has backups of.
+
+Use --source target=source to restore another database's backups into a database (as
+clone does); both must be of the same type.
+
+The plan is printed before anything is restored and must be confirmed by typing the
+number of databases it overwrites. Without a terminal, pass --yes instead.
+
+Examples:
+  blobber restore-batch --all                              # latest backup of every database
+  blobber restore-batch shop blog --at "2024-01-15 12:00"  # both as of the same time
+  blobber restore-batch staging --source staging=prod      # latest backup of prod into staging`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && !batchRestoreAll {
+			return fmt.Errorf("name the databases to restore, or pass --all")
+		}
+		if len(args) > 0 && batchRestoreAll {
+			return fmt.Errorf("--all cannot be combined with database names")
+		}
+		databases := args
+		if batchRestoreAll {
+			for name := range cfg.Databases {
+				databases = append(databases, name)
+			}
+			sort.Strings(databases)
+		}
+		for _, name := range databases {
+			if _, ok := cfg.Databases[name]; !ok {
+				return fmt.Errorf("database %q not found in config", name)
+			}
+		}
+		for target := range batchRestoreSources {
+			if !slices.Contains(databases, target) {
+				return fmt.Errorf("--source %s: database %q is not restored", target, target)
+			}
+		}
+		at, err := orchestrator.ParseRestorePoint(batchRestoreAt)
+		if err != nil {
+			return fmt.Errorf("--at: %w", err)
+		}
+		concurrency := cfg.RestoreBatchConcurrency()
+		if batchRestoreConcurrency > 0 {
+			concurrency = batchRestoreConcurrency
+		}
+		return runRestoreBatch(context.Background(), databases, at, concurrency)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(restoreBatchCmd)
+	restoreBatchCmd.Flags().BoolVar(&batchRestoreAll, "all", false, "Restore every configured database")
+	restoreBatchCmd.Flags().StringVar(&batchRestoreAt, "at", "", "Restore the newest backups taken at or before this time (YYYY-MM-DD [HH:MM])")
+	restoreBatchCmd.Flags().StringToStringVar(&batchRestoreSources, "source", nil, "Restore another database's backups into a database (target=source)")
+	restoreBatchCmd.Flags().IntVar(&batchRestoreConcurrency, "concurrency", 0, "Databases restored at once (default: restore_concurrency)")
+}
+
+func runRestoreBatch(ctx context.Context, databases []string, at time.Time, concurrency int) error {
+	requests, failures := orchestrator.PlanRestoreBatch(ctx, cfg, databases, batchRestoreSources, at)
+	for _, name := range databases {
+		if err, ok := failures[name]; ok {
+			fmt.Printf("[%s] Skipped: %v\n", name, err)
+		}
+	}
+	if len(requests) == 0 {
+		return fmt.Errorf("no database to restore")
+	}
+
+	fmt.Printf("Restore will overwrite %d database(s):\n", len(requests))
+	for _, req := range requests {
+		from := ""
+		if req.Source != req.Entry {
+			from = fmt.Sprintf(" (from %s)", req.Source)
+		}
+		fmt.Printf("  %s ← %s%s  %s  %s\n", req.Name, req.File, from, req.Time.Format("2006-01-02 15:04:05"), humanize.IBytes(uint64(req.Size)))
+	}
+	// The number of databases is typed back, rather than a y anyone might type
+	count := strconv.Itoa(len(requests))
+	question := fmt.Sprintf("This overwrites the databases above. Type %s to proceed:", count)
+	if err := confirm(os.Stdout, "the restore", question, func(answer string) bool { return answer == count }); err != nil {
+		return err
+	}
+
+	progress := make(chan orchestrator.RestoreProgress, 100)
+	done := make(chan struct{})
+	var results []orchestrator.RestoreResult
+	go func() {
+		results = orchestrator.RestoreBatch(ctx, cfg, requests, concurrency, progress)
+		close(progress)
+		close(done)
+	}()
+
+	for p := range progress {
+		switch {
+		case p.Error != nil:
+			fmt.Printf("[%s] %s failed: %v\n", p.DBName, p.Step, p.Error)
+		case p.Message != "":
+			fmt.Printf("[%s] %s\n", p.DBName, p.Message)
+		default:
+			fmt.Printf("[%s] %s...\n", p.DBName, p.Step)
+		}
+		for _, warning := range p.Warnings {
+			fmt.Printf("[%s] Warning: %s\n", p.DBName, warning)
+		}
+	}
+	<-done
+
+	var failed int
+	for _, r := range results {
+		if !r.Success {
+			failed++
+		}
+	}
+	failed += len(failures)
+	if failed > 0 {
+		fmt.Printf("Restore finished: %d succeeded, %d failed\n", len(results)+len(failures)-failed, failed)
+		return fmt.Errorf("%d database(s) not restored", failed)
+	}
+	fmt.Printf("Restore finished: %d succeeded\n", len(results))
+	return nil
+}
@@ -1,11 +1,14 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 
+	"github.com/Yoone/blobber/internal/backup"
 	"github.com/Yoone/blobber/internal/config"
+	"github.com/Yoone/blobber/internal/diaglog"
 	"github.com/Yoone/blobber/internal/storage"
 	"github.com/Yoone/blobber/internal/tui"
 	"github.com/Yoone/blobber/internal/version"
@@ -14,9 +17,11 @@ import (
 )
 
 var cfgFile string
+var profileName string
 var rcloneCfgFile string
 var cfg *config.Config
 var cfgPath string
+var verbose bool
 
 var rootCmd = &cobra.Command{
 	Use:   "blobber",
@@ -28,8 +33,19 @@ Run without arguments to launch the interactive TUI.`,
 		// Initialize rclone storage with optional custom config
 		storage.Init(rcloneCfgFile)
 
-		// For TUI mode (root command), allow empty config
-		if cmd.Name() == "blobber" {
+		// The TUI owns the terminal, so it logs commands to its diagnostics log instead
+		// (see RunE)
+		if verbose && cmd.Name() != "blobber" {
+			backup.SetCommandLog(os.Stderr)
+		}
+
+		// doctor loads the config itself so it can report problems instead of failing
+		if cmd.Name() == "doctor" {
+			return nil
+		}
+
+		// For TUI mode (root command) and remote management, allow empty config
+		if cmd.Name() == "blobber" || cmd.Name() == "remotes" {
 			return loadConfigAllowEmpty()
 		}
 		// For subcommands, require valid config with databases
@@ -40,6 +56,30 @@ Run without arguments to launch the interactive TUI.`,
 		if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
 			return cmd.Help()
 		}
+		// The TUI only shows summarized errors: keep the full story on disk, with the
+		// commands --verbose logs
+		if path := cfg.DiagnosticsLogPath(); path != "" {
+			diag, err := diaglog.Open(path, diaglog.DefaultMaxSize, diaglog.DefaultBackups)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			} else {
+				defer diag.Close()
+				diag.Printf("blobber %s started", version.String())
+				storage.SetDiagnosticsLog(diag)
+				backup.SetDiagnosticsLog(diag)
+				tui.SetDiagnosticsLog(diag, path)
+				if verbose {
+					backup.SetCommandLog(diag)
+				}
+			}
+		} else if verbose {
+			f, err := os.OpenFile(verboseLogPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+			if err != nil {
+				return fmt.Errorf("opening verbose log: %w", err)
+			}
+			defer f.Close()
+			backup.SetCommandLog(f)
+		}
 		// Launch TUI
 		return tui.Run(cfg, version.String())
 	},
@@ -53,7 +93,16 @@ func Execute() {
 
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file (default: ~/.config/blobber/config.yaml)")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "config profile: ~/.config/blobber/profiles/<name>.yaml (default: $"+profileEnv+")")
 	rootCmd.PersistentFlags().StringVar(&rcloneCfgFile, "rclone-config", "", "rclone config file (default: ~/.config/rclone/rclone.conf)")
+	rootCmd.PersistentFlags().BoolVar(&assumeYes, "yes", false, "answer yes to every confirmation (required for restores and rekeys without a terminal)")
+	rootCmd.PersistentFlags().BoolVar(&verbose, "verbose", false, "log each dump and restore command before it runs, passwords masked (TUI: to the diagnostics log, or blobber.log next to the config when it is off)")
+}
+
+// verboseLogPath returns the file the TUI logs commands to with --verbose when the
+// diagnostics log is off: blobber.log next to the config file
+func verboseLogPath() string {
+	return filepath.Join(filepath.Dir(cfg.Path()), "blobber.log")
 }
 
 // defaultConfigPath returns the default config path (~/.config/blobber/config.yaml)
@@ -65,27 +114,98 @@ func defaultConfigPath() string {
 	return filepath.Join(home, ".config", "blobber", "config.yaml")
 }
 
-func getConfigPath() string {
+// profileEnv names the environment variable selecting a profile when neither --config
+// nor --profile is given
+const profileEnv = "BLOBBER_PROFILE"
+
+// getConfigPath resolves the config file from, in order: --config, --profile,
+// BLOBBER_PROFILE and the default path. A profile's file must already exist, so a
+// mistyped name fails instead of starting from an empty config.
+func getConfigPath() (string, error) {
 	if cfgFile != "" {
-		return cfgFile
+		if profileName != "" {
+			return "", fmt.Errorf("cannot combine --config with --profile")
+		}
+		return cfgFile, nil
+	}
+
+	profile, source := profileName, "--profile"
+	if profile == "" {
+		profile, source = os.Getenv(profileEnv), profileEnv
+	}
+	if profile == "" {
+		return defaultConfigPath(), nil
+	}
+
+	path, err := config.ProfilePath(filepath.Dir(defaultConfigPath()), profile)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", source, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("%s: profile %q not found (create %s)", source, profile, path)
+		}
+		return "", fmt.Errorf("%s: %w", source, err)
 	}
-	return defaultConfigPath()
+	return path, nil
 }
 
 func loadConfigAllowEmpty() error {
-	var err error
-	cfg, err = config.LoadOrEmpty(getConfigPath())
+	path, err := getConfigPath()
+	if err != nil {
+		return err
+	}
+	cfg, err = config.LoadOrEmpty(path)
 	if err != nil {
 		return fmt.Errorf("loading config: %w", err)
 	}
+	registerInlineDests(cfg)
 	return nil
 }
 
 func loadConfigStrict() error {
-	var err error
-	cfg, err = config.Load(getConfigPath())
+	path, err := getConfigPath()
+	if err != nil {
+		return err
+	}
+	cfg, err = config.Load(path)
 	if err != nil {
 		return fmt.Errorf("loading config: %w", err)
 	}
+	for _, warning := range cfg.SharedDestinations() {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	registerInlineDests(cfg)
 	return nil
 }
+
+// registerInlineDests makes the inline destinations of the config usable by storage
+func registerInlineDests(cfg *config.Config) {
+	for remote, dest := range cfg.InlineDests() {
+		storage.RegisterInlineRemote(remote, dest.Type, dest.RcloneOptions())
+	}
+}
+
+// databasesFromFile reads the --databases-from list and returns the names present in
+// the config, warning about any that are not
+func databasesFromFile(path string) ([]string, error) {
+	names, err := config.ReadDatabaseList(path)
+	if err != nil {
+		return nil, err
+	}
+	selected, unknown := cfg.SelectDatabases(names)
+	for _, name := range unknown {
+		fmt.Fprintf(os.Stderr, "Warning: database %q from %s not found in config, skipping\n", name, path)
+	}
+	return selected, nil
+}
+
+// excludeDatabases removes the --exclude names from databases (all databases when empty),
+// warning about any that are not in the config
+func excludeDatabases(databases, exclude []string) []string {
+	kept, unknown := cfg.DatabasesExcept(databases, exclude)
+	for _, name := range unknown {
+		fmt.Fprintf(os.Stderr, "Warning: excluded database %q not found in config\n", name)
+	}
+	return kept
+}
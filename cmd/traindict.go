@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Yoone/blobber/internal/backup"
+	"github.com/Yoone/blobber/internal/orchestrator"
+	"github.com/Yoone/blobber/internal/storage"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var trainSamples int
+
+var trainDictCmd = &cobra.Command{
+	Use:   "train-dict <db_name...>",
+	Short: "Build a zstd dictionary from recent backups",
+	Long: `Samples the most recent backups of the given databases and builds a zstd dictionary
+from them. Many small databases with similar schemas compress much better with a shared
+dictionary.
+
+The dictionary is written to dictionary_dir (default: dictionaries next to the config)
+under a new ID. Set zstd_dictionary to that ID on databases using zstd compression to
+compress with it. Each backup records the ID of its dictionary, so restores pick the
+right one; keep old dictionaries for as long as backups compressed with them exist.
+
+Examples:
+  blobber train-dict shop1 shop2 shop3
+  blobber train-dict shop1 --samples 10`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runTrainDict(context.Background(), args, trainSamples)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(trainDictCmd)
+	trainDictCmd.Flags().IntVar(&trainSamples, "samples", 5, "Number of recent backups to sample per database")
+}
+
+func runTrainDict(ctx context.Context, databases []string, samplesPerDB int) error {
+	if samplesPerDB <= 0 {
+		return fmt.Errorf("--samples must be positive")
+	}
+	for _, name := range databases {
+		if _, ok := cfg.Databases[name]; !ok {
+			return fmt.Errorf("database %q not found in config", name)
+		}
+	}
+
+	var samples [][]byte
+	for _, name := range databases {
+		db := cfg.Databases[name]
+		files, err := orchestrator.ListBackups(ctx, name, db)
+		if err != nil {
+			return fmt.Errorf("listing backups for %q: %w", name, err)
+		}
+
+		// List returns newest first
+		var sampled int
+		for _, f := range files {
+			if sampled == samplesPerDB {
+				break
+			}
+			sample, err := readSample(ctx, db.Dest, f.Name)
+			if err != nil {
+				fmt.Printf("[%s] Skipping %s: %v\n", name, f.Name, err)
+				continue
+			}
+			samples = append(samples, sample)
+			sampled++
+		}
+		fmt.Printf("[%s] Sampled %d backup(s)\n", name, sampled)
+	}
+
+	dict, err := backup.TrainDictionary(samples)
+	if err != nil {
+		return err
+	}
+	id, err := backup.NewDictionaryID()
+	if err != nil {
+		return err
+	}
+	path, err := backup.SaveDictionary(cfg.DictionaryDirectory(), id, dict)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Dictionary %d written to %s (%s)\n", id, path, humanize.IBytes(uint64(len(dict))))
+	fmt.Printf("Set \"zstd_dictionary: %d\" on databases with zstd compression to use it\n", id)
+	return nil
+}
+
+// readSample returns the start of a backup's uncompressed content, up to
+// backup.DictionarySampleBytes
+func readSample(ctx context.Context, dest, fileName string) ([]byte, error) {
+	rc, err := storage.Open(ctx, dest, fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	reader, cleanup, err := backup.DecompressStream(rc, fileName, backup.SettingsFrom(cfg))
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	sample, err := io.ReadAll(io.LimitReader(reader, backup.DictionarySampleBytes))
+	if err != nil {
+		return nil, fmt.Errorf("reading backup: %w", err)
+	}
+	return sample, nil
+}
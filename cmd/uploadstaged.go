@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/Yoone/blobber/internal/lock"
+	"github.com/Yoone/blobber/internal/orchestrator"
+	"github.com/spf13/cobra"
+)
+
+var (
+	stagedSkipRetention   bool
+	stagedRetentionDryRun bool
+)
+
+var uploadStagedCmd = &cobra.Command{
+	Use:   "upload-staged [database...]",
+	Short: "Upload the dumps staged by backup --stage",
+	Long: `Uploads the dumps 'blobber backup --stage' left in the staging directory and
+applies retention, as a regular backup would have after its dump.
+
+If no databases are specified, every staged dump is uploaded. Dumps are uploaded one
+at a time, oldest first. A dump whose upload fails stays staged for the next run, as do
+the dumps left when run_timeout expires.
+
+Examples:
+  blobber backup --stage && blobber upload-staged  # dump, then upload
+  blobber upload-staged mydb                       # upload only the dumps of 'mydb'
+  blobber upload-staged --retention-dry-run        # only report what retention would delete`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if stagedRetentionDryRun && stagedSkipRetention {
+			return fmt.Errorf("--retention-dry-run cannot be combined with --skip-retention")
+		}
+		for _, name := range args {
+			if _, exists := cfg.Databases[name]; !exists {
+				return fmt.Errorf("database %q not found in config", name)
+			}
+		}
+		// Bounded by run_timeout like the backup run it completes
+		ctx := context.Background()
+		if timeout := cfg.RunTimeoutDuration(); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return runUploadStaged(ctx, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(uploadStagedCmd)
+	uploadStagedCmd.Flags().BoolVar(&stagedSkipRetention, "skip-retention", false, "Skip retention policy after the uploads")
+	uploadStagedCmd.Flags().BoolVar(&stagedRetentionDryRun, "retention-dry-run", false, "Upload, but only report the backups retention would delete")
+}
+
+func runUploadStaged(ctx context.Context, databases []string) error {
+	// A backup run of the same config may be staging dumps right now
+	runLock, err := lock.Acquire(lock.PathFor(cfg.Path()))
+	if err != nil {
+		return err
+	}
+	defer runLock.Release()
+
+	staged, err := orchestrator.ListStaged(cfg)
+	if err != nil {
+		return err
+	}
+	if len(databases) > 0 {
+		staged = slices.DeleteFunc(staged, func(s orchestrator.StagedDump) bool {
+			return !slices.Contains(databases, s.Entry)
+		})
+	}
+	runCfg, staged, warnings := orchestrator.StagedConfig(cfg, staged)
+	for _, warning := range warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	if len(staged) == 0 {
+		fmt.Printf("No staged dumps to upload in %s\n", cfg.StagingDirectory())
+		return nil
+	}
+
+	var names []string
+	for _, s := range staged {
+		if !slices.Contains(names, s.Name) {
+			names = append(names, s.Name)
+		}
+	}
+
+	startedAt := time.Now()
+	fmt.Printf("Uploading %d staged dump(s) from %s\n", len(staged), cfg.StagingDirectory())
+
+	progress := make(chan orchestrator.BackupProgress, 100)
+	done := make(chan struct{})
+	var results []orchestrator.BackupResult
+	go func() {
+		results = orchestrator.RunStagedUploads(ctx, runCfg, staged, orchestrator.BackupOptions{
+			SkipRetention:   stagedSkipRetention,
+			RetentionDryRun: stagedRetentionDryRun,
+		}, progress)
+		close(progress)
+		close(done)
+	}()
+
+	printBackupProgress(os.Stdout, runCfg, progress, false)
+	<-done
+
+	var failed int
+	for _, r := range results {
+		if !r.Success {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("Upload finished: %d succeeded, %d failed (still staged)\n", len(results)-failed, failed)
+	} else {
+		fmt.Printf("Upload finished: %d succeeded\n", len(results))
+	}
+	fmt.Println(orchestrator.SumStorageChange(results))
+
+	// Best-effort run report; uses a fresh context so it is written even past the deadline
+	report := orchestrator.NewRunReport(startedAt, time.Now(), results)
+	if path, err := orchestrator.WriteRunReportFor(context.Background(), runCfg, names, report); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	} else if path != "" {
+		fmt.Printf("Run report written to %s\n", path)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Yoone/blobber/internal/backup"
+	"github.com/Yoone/blobber/internal/orchestrator"
+	"github.com/Yoone/blobber/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var fullVerify bool
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <db_name> [backup_file]",
+	Short: "Check the integrity of a backup",
+	Long: `Downloads a backup and checks it against the SHA-256 recorded when it was uploaded.
+Uses the latest backup unless a file is given.
+
+By default only the checksum is compared, which is fast. Use --full to also decompress
+the whole backup, validating the compression format's own integrity checks. Backups
+without a recorded checksum are always fully decompressed.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backupFile := ""
+		if len(args) == 2 {
+			backupFile = args[1]
+		}
+		mode := backup.VerifyFast
+		if fullVerify {
+			mode = backup.VerifyFull
+		}
+		return runVerify(context.Background(), args[0], backupFile, mode)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().BoolVar(&fullVerify, "full", false, "Also decompress the whole backup to validate it")
+}
+
+func runVerify(ctx context.Context, dbName, backupFile string, mode backup.VerifyMode) error {
+	db, ok := cfg.Databases[dbName]
+	if !ok {
+		return fmt.Errorf("database %q not found in config", dbName)
+	}
+
+	if backupFile == "" {
+		files, err := orchestrator.ListBackups(ctx, dbName, db)
+		if err != nil {
+			return fmt.Errorf("listing backups: %w", err)
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no backups found for %q", dbName)
+		}
+		// List returns newest first
+		backupFile = files[0].Name
+	}
+
+	expected, err := storage.ReadChecksum(ctx, db.Dest, backupFile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("[%s] Downloading %s from %s...\n", dbName, backupFile, db.Dest)
+	switch {
+	case expected == "":
+		fmt.Printf("[%s] No checksum recorded, decompressing to validate...\n", dbName)
+	case mode == backup.VerifyFull:
+		fmt.Printf("[%s] Comparing checksum and decompressing...\n", dbName)
+	default:
+		fmt.Printf("[%s] Comparing checksum...\n", dbName)
+	}
+	if err := backup.VerifyRemoteAgainst(ctx, db.Dest, backupFile, expected, mode, backup.SettingsFrom(cfg)); err != nil {
+		return fmt.Errorf("verifying %s: %w", backupFile, err)
+	}
+
+	fmt.Printf("[%s] %s is intact\n", dbName, backupFile)
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Yoone/blobber/internal/backup"
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
 )
@@ -122,6 +123,11 @@ func TestMySQLBackupRestore(t *testing.T) {
 	}
 	backupFile := files[0].Name()
 
+	// Check the backup decompresses cleanly, not just that a restore loads rows from it
+	if err := backup.VerifyFile(filepath.Join(backupDir, "mysql", backupFile), backup.Settings{}); err != nil {
+		t.Fatalf("Backup %s failed verification: %v", backupFile, err)
+	}
+
 	// Modify the database
 	_, err = db.ExecContext(ctx, "DELETE FROM orders")
 	if err != nil {
@@ -143,7 +149,7 @@ func TestMySQLBackupRestore(t *testing.T) {
 
 	// Restore from backup
 	backupPath := filepath.Join(backupDir, "mysql", backupFile)
-	output, err = runBlobber("restore", "--local", "mysql-test", backupPath)
+	output, err = runBlobber("restore", "--yes", "--local", "mysql-test", backupPath)
 	if err != nil {
 		t.Fatalf("Restore failed: %v\nOutput: %s", err, output)
 	}
@@ -207,6 +213,11 @@ func TestMariaDBBackupRestore(t *testing.T) {
 	}
 	backupFile := files[0].Name()
 
+	// Check the backup decompresses cleanly, not just that a restore loads rows from it
+	if err := backup.VerifyFile(filepath.Join(backupDir, "mariadb", backupFile), backup.Settings{}); err != nil {
+		t.Fatalf("Backup %s failed verification: %v", backupFile, err)
+	}
+
 	// Modify the database
 	_, err = db.ExecContext(ctx, "DELETE FROM orders")
 	if err != nil {
@@ -228,7 +239,7 @@ func TestMariaDBBackupRestore(t *testing.T) {
 
 	// Restore from backup
 	backupPath := filepath.Join(backupDir, "mariadb", backupFile)
-	output, err = runBlobber("restore", "--local", "mariadb-test", backupPath)
+	output, err = runBlobber("restore", "--yes", "--local", "mariadb-test", backupPath)
 	if err != nil {
 		t.Fatalf("Restore failed: %v\nOutput: %s", err, output)
 	}
@@ -291,6 +302,11 @@ func TestPostgresBackupRestore(t *testing.T) {
 	}
 	backupFile := files[0].Name()
 
+	// Check the backup decompresses cleanly, not just that a restore loads rows from it
+	if err := backup.VerifyFile(filepath.Join(backupDir, "postgres", backupFile), backup.Settings{}); err != nil {
+		t.Fatalf("Backup %s failed verification: %v", backupFile, err)
+	}
+
 	// Modify the database
 	_, err = db.ExecContext(ctx, "DELETE FROM orders")
 	if err != nil {
@@ -312,7 +328,7 @@ func TestPostgresBackupRestore(t *testing.T) {
 
 	// Restore from backup
 	backupPath := filepath.Join(backupDir, "postgres", backupFile)
-	output, err = runBlobber("restore", "--local", "postgres-test", backupPath)
+	output, err = runBlobber("restore", "--yes", "--local", "postgres-test", backupPath)
 	if err != nil {
 		t.Fatalf("Restore failed: %v\nOutput: %s", err, output)
 	}
@@ -327,6 +343,64 @@ func TestPostgresBackupRestore(t *testing.T) {
 	}
 }
 
+func TestPostgresFailedRestoreRollsBack(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	ctx := context.Background()
+
+	db, err := sql.Open("postgres", "host=localhost port=5432 user=testuser password=testpass dbname=testdb sslmode=disable")
+	if err != nil {
+		t.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	defer db.Close()
+
+	var before int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM customers").Scan(&before); err != nil {
+		t.Fatalf("Failed to query customers: %v", err)
+	}
+
+	// A dump that empties customers, then fails on a missing table
+	dumpPath := filepath.Join(testDir, "postgres-test_20240101_000000.sql")
+	dump := "DELETE FROM orders;\nDELETE FROM customers;\nINSERT INTO no_such_table VALUES (1);\n"
+	if err := os.WriteFile(dumpPath, []byte(dump), 0644); err != nil {
+		t.Fatalf("Failed to write dump: %v", err)
+	}
+
+	output, err := runBlobber("restore", "--yes", "--local", "postgres-test", dumpPath)
+	if err == nil {
+		t.Fatalf("Restore of a failing dump succeeded\nOutput: %s", output)
+	}
+
+	var after int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM customers").Scan(&after); err != nil {
+		t.Fatalf("Failed to query customers after restore: %v", err)
+	}
+	if after != before {
+		t.Fatalf("Expected %d customers after the failed restore was rolled back, got %d", before, after)
+	}
+}
+
+func TestRestoreNeedsConfirmation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Without a terminal nobody can confirm, so the restore is refused before it starts
+	dumpPath := filepath.Join(testDir, "postgres-test_20240102_000000.sql")
+	if err := os.WriteFile(dumpPath, []byte("DELETE FROM customers;\n"), 0644); err != nil {
+		t.Fatalf("Failed to write dump: %v", err)
+	}
+	output, err := runBlobber("restore", "--local", "postgres-test", dumpPath)
+	if err == nil || !strings.Contains(output, "pass --yes") {
+		t.Fatalf("Restore without --yes and a terminal was not refused: %v\nOutput: %s", err, output)
+	}
+	if strings.Contains(output, "Restore completed") {
+		t.Errorf("Restore ran without confirmation\nOutput: %s", output)
+	}
+}
+
 func TestDryRun(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping integration test in short mode")
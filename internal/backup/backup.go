@@ -5,22 +5,69 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Yoone/blobber/internal/config"
-	"github.com/klauspost/compress/zstd"
+	"github.com/Yoone/blobber/internal/storage"
+	"github.com/dustin/go-humanize"
 	"github.com/ulikunitz/xz"
 )
 
-// ConnectTimeoutSeconds is the timeout for database connections (in seconds)
-// Used for backup, restore, and connection testing
-const ConnectTimeoutSeconds = 5
+// Settings are the config-wide settings of backups, restores and verifications. They are
+// passed to each call, so that every command uses those of the config it loaded.
+type Settings struct {
+	ConnectTimeout time.Duration           // for databases without their own connect_timeout; 0 keeps config.DefaultConnectTimeout
+	DictionaryDir  string                  // where zstd dictionaries are read from
+	Download       storage.DownloadOptions // for backups read from a destination
+}
+
+// SettingsFrom returns the settings of cfg: connect_timeout, dictionary_dir,
+// download_streams and download_attempts
+func SettingsFrom(cfg *config.Config) Settings {
+	return Settings{
+		ConnectTimeout: cfg.ConnectTimeoutDuration(),
+		DictionaryDir:  cfg.DictionaryDirectory(),
+		Download:       storage.DownloadOptions{Streams: cfg.DownloadStreams, Attempts: cfg.DownloadAttempts},
+	}
+}
+
+// database returns db with the connect_timeout it inherits from s when it has none of
+// its own, so the helpers below only need db
+func (s Settings) database(db config.Database) config.Database {
+	if d, err := time.ParseDuration(db.ConnectTimeout); (err != nil || d <= 0) && s.ConnectTimeout > 0 {
+		db.ConnectTimeout = s.ConnectTimeout.String()
+	}
+	return db
+}
+
+// ConnectTimeoutFor returns how long to wait for a connection to db
+func (s Settings) ConnectTimeoutFor(db config.Database) time.Duration {
+	return connectTimeout(s.database(db))
+}
+
+// connectTimeout returns the connect_timeout of db, resolved by Settings.database
+func connectTimeout(db config.Database) time.Duration {
+	if d, err := time.ParseDuration(db.ConnectTimeout); err == nil && d > 0 {
+		return d
+	}
+	return config.DefaultConnectTimeout
+}
+
+// connectTimeoutSeconds is connectTimeout in whole seconds, rounded up, for client
+// tools that take a number of seconds
+func connectTimeoutSeconds(db config.Database) int {
+	return int((connectTimeout(db) + time.Second - 1) / time.Second)
+}
 
 // Result contains the outcome of a backup operation
 type Result struct {
@@ -30,6 +77,31 @@ type Result struct {
 	Size     int64
 	Duration time.Duration
 	Error    error
+	Warnings []string // non-fatal stderr lines emitted by the dump tool
+	SHA256   string   // hex checksum of the backup file as written
+	Kept     bool     // moved out of its temporary directory by MoveTo, so Cleanup leaves it
+
+	UncompressedSize int64 // bytes produced by the dump before compression (0 if unknown)
+}
+
+// CompressionRatio returns how many times smaller the backup file is than the raw dump,
+// or 0 when either size is unknown
+func (r *Result) CompressionRatio() float64 {
+	if r.UncompressedSize <= 0 || r.Size <= 0 {
+		return 0
+	}
+	return float64(r.UncompressedSize) / float64(r.Size)
+}
+
+// SizeSummary describes the backup size, including the raw dump size and compression
+// ratio when compression changed it (e.g. "512 MiB → 48 MiB, 10.7x")
+func (r *Result) SizeSummary() string {
+	size := humanize.IBytes(uint64(r.Size))
+	ratio := r.CompressionRatio()
+	if ratio == 0 || r.UncompressedSize == r.Size {
+		return size
+	}
+	return fmt.Sprintf("%s → %s, %.1fx", humanize.IBytes(uint64(r.UncompressedSize)), size, ratio)
 }
 
 // Compression extensions
@@ -54,8 +126,10 @@ func CompressionLabel(compression string) string {
 	return compressionLabels[compression]
 }
 
-// CompressionFromFilename detects compression type from file extension
+// CompressionFromFilename detects compression type from file extension, ignoring the
+// .gpg of encrypted backups
 func CompressionFromFilename(filename string) string {
+	filename = strings.TrimSuffix(filename, EncryptedExt)
 	switch {
 	case strings.HasSuffix(filename, ".gz"):
 		return "gz"
@@ -70,13 +144,22 @@ func CompressionFromFilename(filename string) string {
 	}
 }
 
-// Run performs a backup for the given database and returns the local file path
-func Run(name string, db config.Database) (*Result, error) {
+// Run performs a backup for the given database and returns the local file path.
+// Cancelling ctx stops the dump in progress (the dump process is killed).
+func Run(ctx context.Context, name string, db config.Database, s Settings) (*Result, error) {
 	start := time.Now()
+	db = s.database(db)
+
+	// Fail before dumping rather than after when the backup couldn't be encrypted
+	if missing := CheckEncryptionUtilities(db); len(missing) > 0 {
+		SkipConsistencyGroup(ctx, db)
+		return nil, fmt.Errorf("%s", missing[0])
+	}
 
 	// Create temp directory for backup
 	tmpDir, err := os.MkdirTemp("", "blobber-")
 	if err != nil {
+		SkipConsistencyGroup(ctx, db)
 		return nil, fmt.Errorf("creating temp dir: %w", err)
 	}
 
@@ -84,29 +167,42 @@ func Run(name string, db config.Database) (*Result, error) {
 	timestamp := time.Now().Format("20060102_150405")
 	ext := ".sql"
 	if db.Type == "file" {
-		ext = filepath.Ext(db.Path)
-		if ext == "" {
-			ext = ".bak"
-		}
+		ext = db.FileExt()
 	}
 	if compExt, ok := compressionExt[db.Compression]; ok {
 		ext += compExt
 	}
-	filename := fmt.Sprintf("%s_%s%s", name, timestamp, ext)
+	filename := fmt.Sprintf("%s_%s%s", db.BackupPrefix(name), timestamp, ext)
 	outPath := filepath.Join(tmpDir, filename)
+	// A zip archive holds the dump under the backup's name without the .zip, like the
+	// other compressions decompress to
+	innerFilename := strings.TrimSuffix(filename, compressionExt[db.Compression])
 
-	// Perform the dump
+	// A database of a consistency group dumps while its group's session is consistent. A
+	// MySQL member lets the session go as soon as its own transaction is open.
+	grouped, snapshot, dumped, err := consistencyGroupsFrom(ctx).join(ctx, db)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, err
+	}
+
+	// Perform the dump, hashing the file as it is written
 	var dumpErr error
+	var warnings []string
+	var rawSize int64
+	checksum := sha256.New()
 	switch db.Type {
 	case "file":
-		dumpErr = dumpFile(db, outPath)
+		rawSize, dumpErr = dumpFile(ctx, db, s.DictionaryDir, outPath, innerFilename, checksum)
 	case "mysql":
-		dumpErr = dumpMySQL(db, outPath)
+		rawSize, warnings, dumpErr = dumpMySQL(ctx, db, s.DictionaryDir, outPath, innerFilename, checksum, grouped, dumped)
 	case "postgres":
-		dumpErr = dumpPostgres(db, outPath)
+		rawSize, warnings, dumpErr = dumpPostgres(ctx, db, s.DictionaryDir, outPath, innerFilename, checksum, snapshot)
 	default:
+		dumped()
 		return nil, fmt.Errorf("unknown database type: %s", db.Type)
 	}
+	dumped()
 
 	if dumpErr != nil {
 		os.RemoveAll(tmpDir)
@@ -120,53 +216,129 @@ func Run(name string, db config.Database) (*Result, error) {
 		return nil, fmt.Errorf("stat backup file: %w", err)
 	}
 
+	// A dump that connected but produced (almost) nothing would otherwise become the
+	// newest backup, the one retention protects
+	dumpSize := rawSize
+	if dumpSize <= 0 {
+		dumpSize = stat.Size()
+	}
+	if dumpSize < db.MinDumpSize() {
+		os.RemoveAll(tmpDir)
+		return nil, fmt.Errorf("dump suspiciously small (%d bytes, min_backup_size is %d)", dumpSize, db.MinDumpSize())
+	}
+
+	sum := hex.EncodeToString(checksum.Sum(nil))
+	if db.Encryption != nil {
+		if outPath, err = encryptFile(ctx, db.Encryption, outPath); err != nil {
+			os.RemoveAll(tmpDir)
+			return nil, err
+		}
+		filename += EncryptedExt
+		if stat, err = os.Stat(outPath); err != nil {
+			os.RemoveAll(tmpDir)
+			return nil, fmt.Errorf("stat backup file: %w", err)
+		}
+		// gpg wrote a new file, which is the one uploaded
+		if sum, err = FileSHA256(outPath); err != nil {
+			os.RemoveAll(tmpDir)
+			return nil, fmt.Errorf("checksum backup file: %w", err)
+		}
+	}
+
 	return &Result{
 		Name:     name,
 		Filename: filename,
 		Path:     outPath,
 		Size:     stat.Size(),
 		Duration: time.Since(start),
+		Warnings: warnings,
+		SHA256:   sum,
+
+		UncompressedSize: rawSize,
 	}, nil
 }
 
-// Cleanup removes the temporary backup file
+// Cleanup removes the temporary backup file. A backup moved out with MoveTo is kept.
 func Cleanup(result *Result) {
-	if result != nil && result.Path != "" {
+	if result != nil && result.Path != "" && !result.Kept {
 		os.RemoveAll(filepath.Dir(result.Path))
 	}
 }
 
-func dumpFile(db config.Database, outPath string) error {
+// MoveTo moves the backup file out of its temporary directory into dir (created if
+// needed), so it survives Cleanup, and updates result.Path
+func MoveTo(result *Result, dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+	dst := filepath.Join(dir, result.Filename)
+	if err := os.Rename(result.Path, dst); err != nil {
+		// The temp dir may be on another filesystem, so fall back to copying
+		if err := copyFile(result.Path, dst); err != nil {
+			os.Remove(dst)
+			return err
+		}
+	}
+	Cleanup(result)
+	result.Path = dst
+	result.Kept = true
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("opening backup: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("copying backup: %w", err)
+	}
+	return out.Close()
+}
+
+// dumpFile copies the database file into outPath, returning the number of bytes read.
+// innerFilename names the file inside a zip archive. What is written to outPath is also
+// written to checksum.
+func dumpFile(ctx context.Context, db config.Database, dictDir, outPath, innerFilename string, checksum hash.Hash) (int64, error) {
 	src, err := os.Open(db.Path)
 	if err != nil {
-		return fmt.Errorf("opening source file: %w", err)
+		return 0, fmt.Errorf("opening source file: %w", err)
 	}
 	defer src.Close()
 
 	dst, err := os.Create(outPath)
 	if err != nil {
-		return fmt.Errorf("creating backup file: %w", err)
+		return 0, fmt.Errorf("creating backup file: %w", err)
 	}
 	defer dst.Close()
 
-	writer, cleanup, err := newCompressWriter(dst, db.Compression, filepath.Base(db.Path))
+	writer, cleanup, err := newCompressWriter(io.MultiWriter(dst, checksum), db.Compression, dictDir, db.ZstdDictionary, innerFilename)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if cleanup != nil {
 		defer cleanup()
 	}
 
-	if _, err := io.Copy(writer, src); err != nil {
-		return fmt.Errorf("copying file: %w", err)
+	n, err := io.Copy(writer, &contextReader{ctx: ctx, r: src})
+	if err != nil {
+		return 0, fmt.Errorf("copying file: %w", err)
 	}
 
-	return nil
+	return n, nil
 }
 
 // newCompressWriter returns a writer that compresses data according to the compression type.
+// zstd uses the dictionary dictID of dictDir when it is not 0.
 // Returns the writer, a cleanup function to call when done, and any error.
-func newCompressWriter(dst io.Writer, compression, filename string) (io.Writer, func(), error) {
+func newCompressWriter(dst io.Writer, compression, dictDir string, dictID uint32, filename string) (io.Writer, func(), error) {
 	switch compression {
 	case "none", "":
 		return dst, nil, nil
@@ -174,7 +346,7 @@ func newCompressWriter(dst io.Writer, compression, filename string) (io.Writer,
 		w := gzip.NewWriter(dst)
 		return w, func() { w.Close() }, nil
 	case "zstd":
-		w, err := zstd.NewWriter(dst)
+		w, err := newZstdWriter(dst, dictDir, dictID)
 		if err != nil {
 			return nil, nil, fmt.Errorf("creating zstd writer: %w", err)
 		}
@@ -198,49 +370,200 @@ func newCompressWriter(dst io.Writer, compression, filename string) (io.Writer,
 	}
 }
 
-// mysqlDumpSupportsColumnStats checks if mysqldump supports --column-statistics option (MySQL 8.0+)
-func mysqlDumpSupportsColumnStats() bool {
-	cmd := exec.Command("mysqldump", "--help")
-	output, err := cmd.Output()
+// mysqlDumpClient is the dump client a mysql database is dumped with
+type mysqlDumpClient struct {
+	binary      string // mysqldump or mariadb-dump
+	mariadb     bool   // MariaDB's own client, which rejects --column-statistics
+	columnStats bool   // MySQL 8.0+ client, which supports --column-statistics
+}
+
+// detectMySQLDump picks the client dumping db: mariadb-dump for a MariaDB server when it
+// is installed, since MariaDB deprecates its mysqldump name, else mysqldump, or
+// mariadb-dump on hosts with only MariaDB's client. Its --version output tells MariaDB's
+// client from MySQL's, and only MySQL's help lists --column-statistics from 8.0.
+func detectMySQLDump(db config.Database) mysqlDumpClient {
+	client := mysqlDumpClient{binary: "mysqldump"}
+	if _, err := exec.LookPath("mariadb-dump"); err == nil {
+		if _, err := exec.LookPath("mysqldump"); db.MariaDB || err != nil {
+			client.binary = "mariadb-dump"
+		}
+	}
+	version, err := exec.Command(client.binary, "--version").Output()
 	if err != nil {
-		return false
+		return client
+	}
+	client.mariadb = strings.Contains(string(version), "MariaDB")
+	if !client.mariadb {
+		help, err := exec.Command(client.binary, "--help").Output()
+		client.columnStats = err == nil && strings.Contains(string(help), "column-statistics")
 	}
-	return strings.Contains(string(output), "column-statistics")
+	return client
 }
 
-func dumpMySQL(db config.Database, outPath string) error {
+// dumpMySQL dumps db to outPath. A member of a consistency group (grouped) calls started
+// once its transaction is open, as the group's read lock is no longer needed for it then.
+func dumpMySQL(ctx context.Context, db config.Database, dictDir, outPath, innerFilename string, checksum hash.Hash, grouped bool, started func()) (int64, []string, error) {
 	// Test connection first with timeout (mysqldump doesn't support --connect-timeout)
-	if err := TestConnection(db); err != nil {
-		return err
+	if err := testConnection(ctx, db.DumpSource()); err != nil {
+		return 0, nil, err
 	}
 
+	client := detectMySQLDump(db)
+	cmd := exec.CommandContext(ctx, client.binary, mysqlDumpArgs(db, client, grouped)...)
+	if db.Password != "" {
+		cmd.Env = append(os.Environ(), "MYSQL_PWD="+db.Password)
+	}
+	if grouped {
+		cmd.Stderr = &transactionWatch{started: started}
+	}
+
+	return runDumpCommand(ctx, cmd, outPath, db, dictDir, innerFilename, checksum)
+}
+
+// mysqlDumpArgs returns the mysqldump arguments for db, connecting to its dump source.
+// A MySQL 8.0+ client gets --column-statistics=0, as the statistics it would read are
+// missing on MariaDB and older MySQL servers; MariaDB's own client has no such option. A
+// database of a consistency group dumps in a single transaction started while the group
+// holds its global read lock, instead of locking its tables, and with --verbose so that
+// transactionWatch sees when that transaction is open. With charset, the dump is
+// written in that character set rather than mysqldump's default utf8mb4.
+func mysqlDumpArgs(db config.Database, client mysqlDumpClient, grouped bool) []string {
+	src := db.DumpSource()
 	args := []string{
-		"-h", db.Host,
-		"-P", fmt.Sprintf("%d", db.Port),
+		"-h", src.Host,
+		"-P", fmt.Sprintf("%d", src.Port),
 		"-u", db.User,
 	}
-
-	// Only add --column-statistics=0 if supported (MySQL 8.0+, not MariaDB)
-	if mysqlDumpSupportsColumnStats() {
+	if client.columnStats && !client.mariadb {
 		args = append(args, "--column-statistics=0")
 	}
+	if grouped {
+		args = append(args, "--single-transaction", "--verbose")
+	}
+	if db.Charset != "" {
+		args = append(args, "--default-character-set="+db.Charset)
+	}
+	return append(args, "--add-drop-table", db.Database)
+}
 
-	args = append(args, "--add-drop-table", db.Database)
+// TestConnection tests database connectivity with a timeout, at the server dumps read
+// from (dump_host when set). Supports mysql and postgres database types.
+func TestConnection(db config.Database, s Settings) error {
+	return testConnection(context.Background(), s.database(db).DumpSource())
+}
 
-	cmd := exec.Command("mysqldump", args...)
-	if db.Password != "" {
-		cmd.Env = append(os.Environ(), "MYSQL_PWD="+db.Password)
+// TestRestoreConnection tests connectivity to the server restores write to (host), which
+// only differs from TestConnection's with dump_host or dump_port
+func TestRestoreConnection(db config.Database, s Settings) error {
+	return testConnection(context.Background(), s.database(db))
+}
+
+// testConnection is TestConnection bounded by a parent context
+func testConnection(parent context.Context, db config.Database) error {
+	ctx, cancel := context.WithTimeout(parent, connectTimeout(db))
+	defer cancel()
+
+	cmd := clientQueryCommand(ctx, db, "SELECT 1")
+	if cmd == nil {
+		return nil // No connection test for file type
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if parent.Err() != nil {
+			return parent.Err()
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("connection timed out after %s", connectTimeout(db))
+		}
+		if stderr.Len() > 0 {
+			return fmt.Errorf("connection failed: %s", strings.TrimSpace(stderr.String()))
+		}
+		return fmt.Errorf("connection failed: %w", err)
 	}
+	return nil
+}
 
-	return runDumpCommand(cmd, outPath, db.Compression, db.Database+".sql")
+// authFailureMessages are parts of the client errors printed when the server rejects the
+// credentials, as opposed to being unreachable or slow
+var authFailureMessages = []string{
+	"access denied for user",         // mysql/mariadb (ERROR 1045)
+	"password authentication failed", // postgres
+	"no pg_hba.conf entry",           // postgres: user/host not allowed
+	"no password supplied",           // postgres: password required
+	"role \"",                        // postgres: role "..." does not exist
 }
 
-// TestConnection tests database connectivity with a timeout.
-// Supports mysql and postgres database types.
-func TestConnection(db config.Database) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(ConnectTimeoutSeconds)*time.Second)
+// IsAuthError reports whether err, from TestConnection, means the server rejected the
+// user or password. Retrying with the same credentials won't help.
+func IsAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, part := range authFailureMessages {
+		if strings.Contains(msg, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// EstimateSize returns an approximate, uncompressed size in bytes of what a dump will
+// contain, queried from the server catalog (mysql/postgres) or the file size (file).
+func EstimateSize(parent context.Context, db config.Database, s Settings) (int64, error) {
+	db = s.database(db)
+	if db.Type == "file" {
+		stat, err := os.Stat(db.Path)
+		if err != nil {
+			return 0, fmt.Errorf("stat database file: %w", err)
+		}
+		return stat.Size(), nil
+	}
+
+	ctx, cancel := context.WithTimeout(parent, connectTimeout(db))
 	defer cancel()
 
+	var query string
+	switch db.Type {
+	case "mysql":
+		query = fmt.Sprintf("SELECT COALESCE(SUM(data_length + index_length), 0) FROM information_schema.TABLES WHERE table_schema = '%s'",
+			strings.ReplaceAll(db.Database, "'", "''"))
+	case "postgres":
+		query = "SELECT pg_database_size(current_database())"
+	}
+	cmd := clientQueryCommand(ctx, db.DumpSource(), query)
+	if cmd == nil {
+		return 0, fmt.Errorf("unsupported database type: %s", db.Type)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if stderr.Len() > 0 {
+			return 0, fmt.Errorf("estimating size: %s", strings.TrimSpace(stderr.String()))
+		}
+		return 0, fmt.Errorf("estimating size: %w", err)
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing size estimate %q: %w", strings.TrimSpace(string(out)), err)
+	}
+	return size, nil
+}
+
+// clientQueryCommand builds a mysql/psql command running a single query that prints
+// unadorned results. With no query the client reads its statements from stdin instead.
+// Returns nil for database types without a client.
+func clientQueryCommand(ctx context.Context, db config.Database, query string) *exec.Cmd {
+	if db.AllDatabases() {
+		db.Database = maintenanceDatabase(db.Type)
+	}
+
 	var cmd *exec.Cmd
 	switch db.Type {
 	case "mysql":
@@ -248,9 +571,12 @@ func TestConnection(db config.Database) error {
 			"-h", db.Host,
 			"-P", fmt.Sprintf("%d", db.Port),
 			"-u", db.User,
-			"-e", "SELECT 1",
-			db.Database,
+			"-N", "-B", // No column names, tab-separated output
+		}
+		if query != "" {
+			args = append(args, "-e", query)
 		}
+		args = append(args, db.Database)
 		cmd = exec.CommandContext(ctx, "mysql", args...)
 		if db.Password != "" {
 			cmd.Env = append(os.Environ(), "MYSQL_PWD="+db.Password)
@@ -261,62 +587,82 @@ func TestConnection(db config.Database) error {
 			"-p", fmt.Sprintf("%d", db.Port),
 			"-U", db.User,
 			"-d", db.Database,
-			"-c", "SELECT 1",
+			"-At", // Unaligned output, tuples only
+		}
+		if query != "" {
+			args = append(args, "-c", query)
 		}
 		cmd = exec.CommandContext(ctx, "psql", args...)
-		cmd.Env = append(os.Environ(), fmt.Sprintf("PGCONNECT_TIMEOUT=%d", ConnectTimeoutSeconds))
+		cmd.Env = append(os.Environ(), fmt.Sprintf("PGCONNECT_TIMEOUT=%d", connectTimeoutSeconds(db)))
 		if db.Password != "" {
 			cmd.Env = append(cmd.Env, "PGPASSWORD="+db.Password)
 		}
-	default:
-		return nil // No connection test for file type
 	}
+	return cmd
+}
 
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+func dumpPostgres(ctx context.Context, db config.Database, dictDir, outPath, innerFilename string, checksum hash.Hash, snapshot string) (int64, []string, error) {
+	cmd := exec.CommandContext(ctx, "pg_dump", postgresDumpArgs(db, snapshot)...)
+	cmd.Env = postgresEnv(db)
 
-	if err := cmd.Run(); err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			return fmt.Errorf("connection timed out after %ds", ConnectTimeoutSeconds)
-		}
-		if stderr.Len() > 0 {
-			return fmt.Errorf("connection failed: %s", strings.TrimSpace(stderr.String()))
-		}
-		return fmt.Errorf("connection failed: %w", err)
+	return runDumpCommand(ctx, cmd, outPath, db, dictDir, innerFilename, checksum)
+}
+
+// postgresEnv returns the environment pg_dump and psql run in to dump or restore db: its
+// connection timeout and password, and with charset the client encoding, so a dump is
+// written and read back in the same encoding
+func postgresEnv(db config.Database) []string {
+	env := append(os.Environ(), fmt.Sprintf("PGCONNECT_TIMEOUT=%d", connectTimeoutSeconds(db)))
+	if db.Password != "" {
+		env = append(env, "PGPASSWORD="+db.Password)
 	}
-	return nil
+	if db.Charset != "" {
+		env = append(env, "PGCLIENTENCODING="+db.Charset)
+	}
+	return env
 }
 
-func dumpPostgres(db config.Database, outPath string) error {
+// postgresDumpArgs returns the pg_dump arguments for db, connecting to its dump source.
+// A database of a consistency group dumps at the snapshot its group exported.
+func postgresDumpArgs(db config.Database, snapshot string) []string {
+	src := db.DumpSource()
 	args := []string{
-		"-h", db.Host,
-		"-p", fmt.Sprintf("%d", db.Port),
+		"-h", src.Host,
+		"-p", fmt.Sprintf("%d", src.Port),
 		"-U", db.User,
 		"--clean",     // Include DROP statements for clean restore
 		"--if-exists", // Don't error if objects don't exist
-		db.Database,
+		// Leave out ownership and grants: restored in a single transaction, an ALTER
+		// OWNER or GRANT naming a role the target server lacks would fail the restore
+		"--no-owner",
+		"--no-privileges",
 	}
-
-	cmd := exec.Command("pg_dump", args...)
-	// Set connection timeout and password
-	cmd.Env = append(os.Environ(), fmt.Sprintf("PGCONNECT_TIMEOUT=%d", ConnectTimeoutSeconds))
-	if db.Password != "" {
-		cmd.Env = append(cmd.Env, "PGPASSWORD="+db.Password)
+	if snapshot != "" {
+		args = append(args, "--snapshot="+snapshot)
 	}
-
-	return runDumpCommand(cmd, outPath, db.Compression, db.Database+".sql")
+	return append(args, db.Database)
 }
 
-func runDumpCommand(cmd *exec.Cmd, outPath, compression, innerFilename string) error {
+// runDumpCommand streams the command's output through the database's redact rules and
+// compression into outPath, and into checksum as it is written. On success, returns the
+// uncompressed byte count and, as warnings, anything the command wrote to stderr and
+// redact rules that matched no column. Output the rules can't be applied to fails the
+// dump.
+func runDumpCommand(ctx context.Context, cmd *exec.Cmd, outPath string, db config.Database, dictDir, innerFilename string, checksum hash.Hash) (int64, []string, error) {
+	rules, err := db.RedactRules()
+	if err != nil {
+		return 0, nil, err
+	}
+
 	outFile, err := os.Create(outPath)
 	if err != nil {
-		return fmt.Errorf("creating output file: %w", err)
+		return 0, nil, fmt.Errorf("creating output file: %w", err)
 	}
 	defer outFile.Close()
 
-	writer, cleanup, err := newCompressWriter(outFile, compression, innerFilename)
+	writer, cleanup, err := newCompressWriter(io.MultiWriter(outFile, checksum), db.Compression, dictDir, db.ZstdDictionary, innerFilename)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
 	if cleanup != nil {
 		defer cleanup()
@@ -324,28 +670,79 @@ func runDumpCommand(cmd *exec.Cmd, outPath, compression, innerFilename string) e
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return fmt.Errorf("creating stdout pipe: %w", err)
+		return 0, nil, fmt.Errorf("creating stdout pipe: %w", err)
 	}
 
-	// Capture stderr instead of sending to terminal (interferes with TUI)
-	var stderrBuf bytes.Buffer
-	cmd.Stderr = &stderrBuf
+	// Capture stderr instead of sending to terminal (interferes with TUI), unless the
+	// caller already watches it
+	stderrBuf, ok := cmd.Stderr.(stderrCapture)
+	if !ok {
+		stderrBuf = &bytes.Buffer{}
+		cmd.Stderr = stderrBuf
+	}
 
+	logCommand(cmd)
 	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("starting command: %w", err)
+		return 0, nil, fmt.Errorf("starting command: %w", err)
 	}
 
-	if _, err := io.Copy(writer, stdout); err != nil {
-		return fmt.Errorf("writing output: %w", err)
+	var n int64
+	var redactWarnings []string
+	if len(rules) > 0 {
+		n, redactWarnings, err = redactDump(writer, stdout, rules)
+	} else {
+		n, err = io.Copy(writer, stdout)
+	}
+	if err != nil {
+		// Stop the command rather than leave it blocked writing output nobody reads
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, nil, fmt.Errorf("writing output: %w", err)
 	}
 
-	if err := cmd.Wait(); err != nil {
+	err = cmd.Wait()
+	logStderr(cmd, stderrBuf.String())
+	if err != nil {
+		// Report cancellation rather than the kill signal
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return 0, nil, ctxErr
+		}
 		// Include stderr in error message if available
-		if stderrBuf.Len() > 0 {
-			return fmt.Errorf("command failed: %s", strings.TrimSpace(stderrBuf.String()))
+		if stderr := strings.TrimSpace(stderrBuf.String()); stderr != "" {
+			return 0, nil, fmt.Errorf("command failed: %s", stderr)
 		}
-		return fmt.Errorf("command failed: %w", err)
+		return 0, nil, fmt.Errorf("command failed: %w", err)
 	}
 
-	return nil
+	return n, append(stderrLines(stderrBuf.String()), redactWarnings...), nil
+}
+
+// stderrCapture is where runDumpCommand collects the stderr of a dump command
+type stderrCapture interface {
+	io.Writer
+	String() string
+}
+
+// stderrLines splits captured stderr into trimmed, non-empty lines
+func stderrLines(stderr string) []string {
+	var lines []string
+	for _, line := range strings.Split(stderr, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// contextReader wraps a reader and stops reading once the context is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
 }
@@ -3,14 +3,27 @@ package backup
 import (
 	"archive/zip"
 	"bytes"
+	"cmp"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Yoone/blobber/internal/config"
+	"github.com/Yoone/blobber/internal/storage"
 	"github.com/klauspost/compress/zstd"
 	"github.com/ulikunitz/xz"
 )
@@ -45,7 +58,7 @@ func TestNewCompressWriter(t *testing.T) {
 
 	t.Run("none compression", func(t *testing.T) {
 		var buf bytes.Buffer
-		w, cleanup, err := newCompressWriter(&buf, "none", "test.txt")
+		w, cleanup, err := newCompressWriter(&buf, "none", "", 0, "test.txt")
 		if err != nil {
 			t.Fatalf("newCompressWriter() error = %v", err)
 		}
@@ -62,7 +75,7 @@ func TestNewCompressWriter(t *testing.T) {
 
 	t.Run("empty compression", func(t *testing.T) {
 		var buf bytes.Buffer
-		w, cleanup, err := newCompressWriter(&buf, "", "test.txt")
+		w, cleanup, err := newCompressWriter(&buf, "", "", 0, "test.txt")
 		if err != nil {
 			t.Fatalf("newCompressWriter() error = %v", err)
 		}
@@ -79,7 +92,7 @@ func TestNewCompressWriter(t *testing.T) {
 
 	t.Run("gz compression", func(t *testing.T) {
 		var buf bytes.Buffer
-		w, cleanup, err := newCompressWriter(&buf, "gz", "test.txt")
+		w, cleanup, err := newCompressWriter(&buf, "gz", "", 0, "test.txt")
 		if err != nil {
 			t.Fatalf("newCompressWriter() error = %v", err)
 		}
@@ -106,7 +119,7 @@ func TestNewCompressWriter(t *testing.T) {
 
 	t.Run("zstd compression", func(t *testing.T) {
 		var buf bytes.Buffer
-		w, cleanup, err := newCompressWriter(&buf, "zstd", "test.txt")
+		w, cleanup, err := newCompressWriter(&buf, "zstd", "", 0, "test.txt")
 		if err != nil {
 			t.Fatalf("newCompressWriter() error = %v", err)
 		}
@@ -133,7 +146,7 @@ func TestNewCompressWriter(t *testing.T) {
 
 	t.Run("unknown compression", func(t *testing.T) {
 		var buf bytes.Buffer
-		_, _, err := newCompressWriter(&buf, "lz4", "test.txt")
+		_, _, err := newCompressWriter(&buf, "lz4", "", 0, "test.txt")
 		if err == nil {
 			t.Error("expected error for unknown compression, got nil")
 		}
@@ -160,10 +173,13 @@ func TestDumpFile(t *testing.T) {
 			Compression: "none",
 		}
 
-		err := dumpFile(db, outPath)
+		n, err := dumpFile(context.Background(), db, "", outPath, "source.db", sha256.New())
 		if err != nil {
 			t.Fatalf("dumpFile() error = %v", err)
 		}
+		if n != int64(len(srcContent)) {
+			t.Errorf("dumpFile() = %d bytes, want %d", n, len(srcContent))
+		}
 
 		// Verify content matches
 		outContent, err := os.ReadFile(outPath)
@@ -183,10 +199,13 @@ func TestDumpFile(t *testing.T) {
 			Compression: "gz",
 		}
 
-		err := dumpFile(db, outPath)
+		n, err := dumpFile(context.Background(), db, "", outPath, "source.db", sha256.New())
 		if err != nil {
 			t.Fatalf("dumpFile() error = %v", err)
 		}
+		if n != int64(len(srcContent)) {
+			t.Errorf("dumpFile() = %d bytes, want uncompressed size %d", n, len(srcContent))
+		}
 
 		// Verify by decompressing
 		outFile, err := os.Open(outPath)
@@ -216,11 +235,461 @@ func TestDumpFile(t *testing.T) {
 			Compression: "none",
 		}
 
-		err := dumpFile(db, outPath)
+		_, err := dumpFile(context.Background(), db, "", outPath, "source.db", sha256.New())
 		if err == nil {
 			t.Error("expected error for missing source file, got nil")
 		}
 	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		outPath := filepath.Join(tmpDir, "backup_cancelled.db")
+		db := config.Database{
+			Type:        "file",
+			Path:        srcPath,
+			Compression: "none",
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := dumpFile(ctx, db, "", outPath, "source.db", sha256.New())
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("dumpFile() error = %v, want context.Canceled", err)
+		}
+	})
+}
+
+func TestZipInnerFilename(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	srcPath := filepath.Join(t.TempDir(), "app.sqlite")
+	if err := os.WriteFile(srcPath, []byte("file content"), 0644); err != nil {
+		t.Fatalf("writing source file: %v", err)
+	}
+	// Fake pg_dump so the SQL dump runs without a server
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "pg_dump"), []byte("#!/bin/sh\necho 'CREATE TABLE t;'\n"), 0755); err != nil {
+		t.Fatalf("writing pg_dump: %v", err)
+	}
+	t.Setenv("PATH", bin)
+
+	tests := []struct {
+		name    string
+		db      config.Database
+		want    string // entry name with the timestamp replaced by TS
+		content string
+	}{
+		{"file backup", config.Database{Type: "file", Path: srcPath, Compression: "zip"}, "app_TS.sqlite", "file content"},
+		{"sql dump", config.Database{Type: "postgres", Host: "db", Port: 5432, User: "app", Database: "shop", Compression: "zip", MinBackupSize: 1}, "app_TS.sql", "CREATE TABLE t;\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Run(context.Background(), "app", tt.db, Settings{})
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			defer Cleanup(result)
+
+			zr, err := zip.OpenReader(result.Path)
+			if err != nil {
+				t.Fatalf("opening zip: %v", err)
+			}
+			defer zr.Close()
+			timestamp := strings.TrimSuffix(strings.TrimPrefix(result.Filename, "app_"), filepath.Ext(tt.want)+".zip")
+			want := strings.Replace(tt.want, "TS", timestamp, 1)
+			if len(zr.File) != 1 || zr.File[0].Name != want {
+				t.Fatalf("zip entries of %s = %v, want [%s]", result.Filename, zr.File, want)
+			}
+			if want != strings.TrimSuffix(result.Filename, ".zip") {
+				t.Errorf("entry %s is not the archive name without .zip", want)
+			}
+
+			reader, cleanup, err := newDecompressReader(result.Path, "")
+			if err != nil {
+				t.Fatalf("newDecompressReader() error = %v", err)
+			}
+			defer cleanup()
+			if got, _ := io.ReadAll(reader); string(got) != tt.content {
+				t.Errorf("restored content = %q, want %q", got, tt.content)
+			}
+		})
+	}
+}
+
+func TestZipEntry(t *testing.T) {
+	archive := func(names ...string) []*zip.File {
+		var buf bytes.Buffer
+		w := zip.NewWriter(&buf)
+		for _, name := range names {
+			if _, err := w.Create(name); err != nil {
+				t.Fatalf("creating zip entry: %v", err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("closing zip writer: %v", err)
+		}
+		r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("reading zip: %v", err)
+		}
+		return r.File
+	}
+
+	tests := []struct {
+		name    string
+		entries []string
+		want    string
+	}{
+		{"named after archive", []string{"notes.txt", "shop_20240115_143022.sql"}, "shop_20240115_143022.sql"},
+		{"older backup", []string{"shop.sql"}, "shop.sql"},
+		{"directories skipped", []string{"dump/", "dump/shop.sql"}, "dump/shop.sql"},
+		{"empty", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := zipEntry(archive(tt.entries...), "shop_20240115_143022.sql.zip")
+			if got == nil && tt.want != "" || got != nil && got.Name != tt.want {
+				t.Errorf("zipEntry() = %v, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunDumpCommandWarnings(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	t.Run("stderr on success becomes warnings", func(t *testing.T) {
+		outPath := filepath.Join(tmpDir, "ok.sql")
+		cmd := exec.Command("sh", "-c", "echo 'CREATE TABLE t;'; echo 'Warning: skipped table x' >&2; echo '' >&2")
+		checksum := sha256.New()
+		n, warnings, err := runDumpCommand(context.Background(), cmd, outPath, config.Database{Compression: "gz"}, "", "ok.sql", checksum)
+		if err != nil {
+			t.Fatalf("runDumpCommand() error = %v", err)
+		}
+		// The checksum covers the compressed file, gzip trailer included
+		if want, _ := FileSHA256(outPath); hex.EncodeToString(checksum.Sum(nil)) != want {
+			t.Errorf("runDumpCommand() checksum = %x, want %s", checksum.Sum(nil), want)
+		}
+		if n != int64(len("CREATE TABLE t;\n")) {
+			t.Errorf("runDumpCommand() = %d bytes, want uncompressed size %d", n, len("CREATE TABLE t;\n"))
+		}
+		if len(warnings) != 1 || warnings[0] != "Warning: skipped table x" {
+			t.Errorf("runDumpCommand() warnings = %q, want [\"Warning: skipped table x\"]", warnings)
+		}
+	})
+
+	t.Run("no stderr means no warnings", func(t *testing.T) {
+		outPath := filepath.Join(tmpDir, "clean.sql")
+		cmd := exec.Command("sh", "-c", "echo 'CREATE TABLE t;'")
+		_, warnings, err := runDumpCommand(context.Background(), cmd, outPath, config.Database{Compression: "none"}, "", "clean.sql", sha256.New())
+		if err != nil {
+			t.Fatalf("runDumpCommand() error = %v", err)
+		}
+		if warnings != nil {
+			t.Errorf("runDumpCommand() warnings = %q, want none", warnings)
+		}
+	})
+
+	t.Run("redact rules rewrite the output", func(t *testing.T) {
+		outPath := filepath.Join(tmpDir, "redact.sql")
+		cmd := exec.Command("sh", "-c", "printf 'INSERT INTO `users` (`id`, `email`) VALUES (1,\\047ann@example.com\\047);\\n'")
+		db := config.Database{Compression: "none", Redact: []string{"users.email", "users.phone"}}
+		_, warnings, err := runDumpCommand(context.Background(), cmd, outPath, db, "", "redact.sql", sha256.New())
+		if err != nil {
+			t.Fatalf("runDumpCommand() error = %v", err)
+		}
+		data, err := os.ReadFile(outPath)
+		if err != nil {
+			t.Fatalf("reading dump: %v", err)
+		}
+		if want := "INSERT INTO `users` (`id`, `email`) VALUES (1,NULL);\n"; string(data) != want {
+			t.Errorf("dump = %q, want %q", data, want)
+		}
+		if len(warnings) != 1 || !strings.Contains(warnings[0], "users.phone") {
+			t.Errorf("runDumpCommand() warnings = %q, want one about users.phone", warnings)
+		}
+	})
+
+	t.Run("output redact can't parse fails the dump", func(t *testing.T) {
+		outPath := filepath.Join(tmpDir, "unparsed.sql")
+		// The command is still running when the dump fails, and must be stopped
+		cmd := exec.Command("sh", "-c", "printf 'INSERT INTO `users` (`id`, `email`) VALUES (1,\\047ann);\\n'; exec sleep 60")
+		db := config.Database{Compression: "none", Redact: []string{"users.email"}}
+		_, _, err := runDumpCommand(context.Background(), cmd, outPath, db, "", "unparsed.sql", sha256.New())
+		if err == nil || !strings.Contains(err.Error(), "could not parse an INSERT into users") {
+			t.Errorf("runDumpCommand() error = %v, want one about the INSERT", err)
+		}
+	})
+
+	t.Run("stderr on failure is the error", func(t *testing.T) {
+		outPath := filepath.Join(tmpDir, "fail.sql")
+		cmd := exec.Command("sh", "-c", "echo 'access denied' >&2; exit 1")
+		_, warnings, err := runDumpCommand(context.Background(), cmd, outPath, config.Database{Compression: "none"}, "", "fail.sql", sha256.New())
+		if err == nil || !strings.Contains(err.Error(), "access denied") {
+			t.Errorf("runDumpCommand() error = %v, want one containing stderr", err)
+		}
+		if warnings != nil {
+			t.Errorf("runDumpCommand() warnings = %q, want none on failure", warnings)
+		}
+	})
+}
+
+const mysqlDump = "-- MySQL dump 10.13\n" +
+	"DROP TABLE IF EXISTS `users`;\n" +
+	"CREATE TABLE `users` (\n" +
+	"  `id` int NOT NULL AUTO_INCREMENT,\n" +
+	"  `email` varchar(255) NOT NULL,\n" +
+	"  `token` varchar(64) DEFAULT NULL,\n" +
+	"  PRIMARY KEY (`id`),\n" +
+	"  UNIQUE KEY `email` (`email`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n" +
+	"LOCK TABLES `users` WRITE;\n" +
+	"INSERT INTO `users` VALUES (1,'ann@example.com','s3cr(e)t,1'),(2,'o\\'brien@example.com',NULL),(3,'bob@example.com','it''s');\n" +
+	"UNLOCK TABLES;\n" +
+	"INSERT INTO `orders` VALUES (1,'ann@example.com');\n"
+
+const pgDump = "--\n-- PostgreSQL database dump\n--\n" +
+	"CREATE TABLE public.users (\n" +
+	"    id integer NOT NULL,\n" +
+	"    email text,\n" +
+	"    token text\n" +
+	");\n" +
+	"COPY public.users (id, email, token) FROM stdin;\n" +
+	"1\tann@example.com\ts3cr\\tet\n" +
+	"2\tbob@example.com\t\\N\n" +
+	"\\.\n" +
+	"COPY public.orders (id, email) FROM stdin;\n" +
+	"1\tann@example.com\n" +
+	"\\.\n"
+
+func TestRedactDump(t *testing.T) {
+	ann := hashValue("ann@example.com")
+
+	tests := []struct {
+		name         string
+		dump         string
+		rules        []string
+		want         []string // lines expected in the output
+		wantWarnings []string
+		wantErr      string
+	}{
+		{
+			name:  "mysqldump null",
+			dump:  mysqlDump,
+			rules: []string{"users.token"},
+			want: []string{
+				"INSERT INTO `users` VALUES (1,'ann@example.com',NULL),(2,'o\\'brien@example.com',NULL),(3,'bob@example.com',NULL);",
+				"INSERT INTO `orders` VALUES (1,'ann@example.com');",
+			},
+		},
+		{
+			name:  "mysqldump hash",
+			dump:  mysqlDump,
+			rules: []string{"users.email:hash", "users.token"},
+			want: []string{
+				"INSERT INTO `users` VALUES (1,'" + ann + "',NULL),(2,'" + hashValue("o'brien@example.com") + "',NULL),(3,'" + hashValue("bob@example.com") + "',NULL);",
+			},
+		},
+		{
+			name:  "mysqldump with a column list",
+			dump:  "INSERT INTO `users` (`id`, `email`) VALUES (1,'ann@example.com');\n",
+			rules: []string{"users.email:hash"},
+			want:  []string{"INSERT INTO `users` (`id`, `email`) VALUES (1,'" + ann + "');"},
+		},
+		{
+			name:  "pg_dump",
+			dump:  pgDump,
+			rules: []string{"users.email:hash", "public.users.token"},
+			want: []string{
+				"1\t" + ann + "\t\\N",
+				"2\t" + hashValue("bob@example.com") + "\t\\N",
+				"1\tann@example.com",
+			},
+		},
+		{
+			name:  "escaped values hash alike in both formats",
+			dump:  "INSERT INTO `users` (`id`, `token`) VALUES (1,'s3cr\\tet'),(2,'it''s'),(3,_binary 'x\\\\y'),(4,0x41);\n" + pgDump,
+			rules: []string{"users.token:hash"},
+			want: []string{
+				"INSERT INTO `users` (`id`, `token`) VALUES (1,'" + hashValue("s3cr\tet") + "'),(2,'" + hashValue("it's") + "'),(3,'" + hashValue(`x\y`) + "'),(4,'" + hashValue("A") + "');",
+				"1\tann@example.com\t" + hashValue("s3cr\tet"),
+			},
+		},
+		{
+			name:         "unknown column",
+			dump:         pgDump,
+			rules:        []string{"users.phone", "other.users.email"},
+			want:         []string{"1\tann@example.com\ts3cr\\tet"},
+			wantWarnings: []string{"redact: no column users.phone found in the dump", "redact: no column other.users.email found in the dump"},
+		},
+		{
+			name:    "insert without known columns",
+			dump:    "INSERT INTO `users` VALUES (1,'ann@example.com');\n",
+			rules:   []string{"users.email"},
+			wantErr: "redact: columns of users are unknown, so its INSERT statements can't be redacted",
+		},
+		{
+			name:    "unparseable insert",
+			dump:    "INSERT INTO `users` (`id`, `email`) VALUES (1,'ann@example.com);\n",
+			rules:   []string{"users.email"},
+			wantErr: "redact: could not parse an INSERT into users (unterminated value)",
+		},
+		{
+			name:    "unparseable value to hash",
+			dump:    "INSERT INTO `users` (`id`, `email`) VALUES (1,'ann'@example.com);\n",
+			rules:   []string{"users.email:hash"},
+			wantErr: "redact: could not parse an INSERT into users (malformed string 'ann'@example.com)",
+		},
+		{
+			name:    "COPY row with missing values",
+			dump:    "COPY public.users (id, email) FROM stdin;\n1\n\\.\n",
+			rules:   []string{"users.email"},
+			wantErr: "redact: COPY row has 1 values, expected 2",
+		},
+		{
+			name:    "COPY without a column list",
+			dump:    "COPY public.users FROM stdin;\n1\tann@example.com\n\\.\n",
+			rules:   []string{"users.email"},
+			wantErr: "redact: COPY into public.users has no column list, so its rows can't be redacted",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rules []config.RedactRule
+			for _, entry := range tt.rules {
+				rule, err := config.ParseRedactRule(entry)
+				if err != nil {
+					t.Fatalf("ParseRedactRule(%q) error = %v", entry, err)
+				}
+				rules = append(rules, rule)
+			}
+
+			var out bytes.Buffer
+			n, warnings, err := redactDump(&out, strings.NewReader(tt.dump), rules)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("redactDump() error = %v, want %q", err, tt.wantErr)
+				}
+				if strings.Contains(out.String(), "ann@example.com") {
+					t.Errorf("redactDump() wrote the unredacted value:\n%s", out.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("redactDump() error = %v", err)
+			}
+			if n != int64(out.Len()) {
+				t.Errorf("redactDump() = %d bytes, wrote %d", n, out.Len())
+			}
+			lines := strings.Split(out.String(), "\n")
+			for _, want := range tt.want {
+				if !slices.Contains(lines, want) {
+					t.Errorf("output is missing line %q:\n%s", want, out.String())
+				}
+			}
+			if !reflect.DeepEqual(warnings, tt.wantWarnings) {
+				t.Errorf("redactDump() warnings = %q, want %q", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestSizeSummary(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   Result
+		expected string
+	}{
+		{"compressed", Result{Size: 48 * 1024 * 1024, UncompressedSize: 512 * 1024 * 1024}, "512 MiB → 48 MiB, 10.7x"},
+		{"uncompressed", Result{Size: 1024, UncompressedSize: 1024}, "1.0 KiB"},
+		{"raw size unknown", Result{Size: 1024}, "1.0 KiB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.result.SizeSummary(); got != tt.expected {
+				t.Errorf("SizeSummary() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	s := Settings{ConnectTimeout: 20 * time.Second}
+
+	tests := []struct {
+		name        string
+		db          config.Database
+		want        time.Duration
+		wantSeconds int
+	}{
+		{"global default", config.Database{}, 20 * time.Second, 20},
+		{"database override", config.Database{ConnectTimeout: "1m"}, time.Minute, 60},
+		{"rounded up to seconds", config.Database{ConnectTimeout: "1500ms"}, 1500 * time.Millisecond, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.ConnectTimeoutFor(tt.db); got != tt.want {
+				t.Errorf("ConnectTimeoutFor() = %v, want %v", got, tt.want)
+			}
+			if got := connectTimeoutSeconds(s.database(tt.db)); got != tt.wantSeconds {
+				t.Errorf("connectTimeoutSeconds() = %d, want %d", got, tt.wantSeconds)
+			}
+		})
+	}
+}
+
+func TestIsAuthError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"mysql access denied", fmt.Errorf("connection failed: ERROR 1045 (28000): Access denied for user 'app'@'10.0.0.2' (using password: YES)"), true},
+		{"postgres password", fmt.Errorf(`connection failed: psql: error: FATAL:  password authentication failed for user "app"`), true},
+		{"postgres pg_hba", fmt.Errorf(`connection failed: FATAL:  no pg_hba.conf entry for host "10.0.0.2", user "app", database "shop"`), true},
+		{"postgres no password", fmt.Errorf("connection failed: fe_sendauth: no password supplied"), true},
+		{"postgres unknown role", fmt.Errorf(`connection failed: FATAL:  role "app" does not exist`), true},
+		{"timeout", fmt.Errorf("connection timed out after 5s"), false},
+		{"refused", fmt.Errorf("connection failed: ERROR 2003 (HY000): Can't connect to MySQL server on 'db' (111)"), false},
+		{"unknown database", fmt.Errorf(`connection failed: FATAL:  database "shop" does not exist`), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsAuthError(tt.err); got != tt.want {
+				t.Errorf("IsAuthError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterDatabaseNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		names    []string
+		dbType   string
+		exclude  []string
+		expected []string
+	}{
+		{"mysql system schemas", []string{"shop", "information_schema", "mysql", "performance_schema", "sys", "blog", ""}, "mysql", nil, []string{"blog", "shop"}},
+		{"postgres maintenance db", []string{"postgres", "app", " analytics "}, "postgres", nil, []string{"analytics", "app"}},
+		{"exclude patterns", []string{"app", "tmp_1", "tmp_2", "staging"}, "postgres", []string{"tmp_*", "staging"}, []string{"app"}},
+		{"nothing left", []string{"mysql", "sys"}, "mysql", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := filterDatabaseNames(tt.names, tt.dbType, tt.exclude)
+			if strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("filterDatabaseNames() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
 }
 
 func TestRunAndCleanup(t *testing.T) {
@@ -239,7 +708,7 @@ func TestRunAndCleanup(t *testing.T) {
 		Compression: "gz",
 	}
 
-	result, err := Run("testdb", db)
+	result, err := Run(context.Background(), "testdb", db, Settings{})
 	if err != nil {
 		t.Fatalf("Run() error = %v", err)
 	}
@@ -276,6 +745,99 @@ func TestRunAndCleanup(t *testing.T) {
 	}
 }
 
+func TestMinBackupSize(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	srcPath := filepath.Join(t.TempDir(), "app.db")
+	if err := os.WriteFile(srcPath, []byte("tiny"), 0644); err != nil {
+		t.Fatalf("writing source file: %v", err)
+	}
+	// Fake pg_dump printing only the header of an empty database
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "pg_dump"), []byte("#!/bin/sh\necho '-- PostgreSQL database dump'\n"), 0755); err != nil {
+		t.Fatalf("writing pg_dump: %v", err)
+	}
+	t.Setenv("PATH", bin)
+	pg := config.Database{Type: "postgres", Host: "db", Port: 5432, User: "app", Database: "shop", Compression: "gz"}
+
+	tests := []struct {
+		name    string
+		db      config.Database
+		wantErr string
+	}{
+		{"sql dump below the default", pg, "dump suspiciously small (28 bytes, min_backup_size is 1024)"},
+		{"sql dump with a lower minimum", func() config.Database { db := pg; db.MinBackupSize = 20; return db }(), ""},
+		{"file without a minimum", config.Database{Type: "file", Path: srcPath, Compression: "gz"}, ""},
+		{"file below its minimum", config.Database{Type: "file", Path: srcPath, Compression: "none", MinBackupSize: 100}, "dump suspiciously small (4 bytes, min_backup_size is 100)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Run(context.Background(), "app", tt.db, Settings{})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Run() error = %v", err)
+				}
+				Cleanup(result)
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("Run() error = %v, want %q", err, tt.wantErr)
+			}
+			if entries, _ := os.ReadDir(os.Getenv("TMPDIR")); len(entries) != 0 {
+				t.Errorf("rejected dump left %v behind", entries)
+			}
+		})
+	}
+}
+
+func TestArchiveExt(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	srcDir := t.TempDir()
+	content := []byte("file content")
+	for _, name := range []string{"appdata", "app.db.old-2"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		source     string
+		archiveExt string
+		comp       string
+		wantSuffix string
+	}{
+		{"extensionless source", "appdata", "", "none", ".bak"},
+		{"weird extension", "app.db.old-2", "", "none", ".old-2"},
+		{"archive_ext on extensionless source", "appdata", ".dat", "none", ".dat"},
+		{"archive_ext on weird extension", "app.db.old-2", "dat", "gz", ".dat.gz"},
+		{"archive_ext with zip", "appdata", ".dat", "zip", ".dat.zip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := config.Database{Type: "file", Path: filepath.Join(srcDir, tt.source), Compression: tt.comp, ArchiveExt: tt.archiveExt}
+			result, err := Run(context.Background(), "app", db, Settings{})
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			defer Cleanup(result)
+			if !regexp.MustCompile(`^app_\d{8}_\d{6}` + regexp.QuoteMeta(tt.wantSuffix) + `$`).MatchString(result.Filename) {
+				t.Fatalf("Filename = %q, want app_<timestamp>%s", result.Filename, tt.wantSuffix)
+			}
+
+			// Restoring ignores the name beyond its compression extension
+			restored := filepath.Join(t.TempDir(), "restored")
+			if err := Restore(config.Database{Type: "file", Path: restored}, result.Path, Settings{}); err != nil {
+				t.Fatalf("Restore() error = %v", err)
+			}
+			if got, _ := os.ReadFile(restored); !bytes.Equal(got, content) {
+				t.Errorf("restored %q, want %q", got, content)
+			}
+		})
+	}
+}
+
 func TestCleanupNil(t *testing.T) {
 	// Should not panic
 	Cleanup(nil)
@@ -284,78 +846,359 @@ func TestCleanupNil(t *testing.T) {
 	Cleanup(&Result{Path: ""})
 }
 
-// Helper to create a gzip compressed file
-func createGzipFile(t *testing.T, path string, content []byte) {
-	t.Helper()
-	f, err := os.Create(path)
-	if err != nil {
-		t.Fatalf("creating file: %v", err)
+func TestEstimateSize(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+	if err := os.WriteFile(dbPath, make([]byte, 4096), 0644); err != nil {
+		t.Fatalf("writing db file: %v", err)
 	}
-	defer f.Close()
 
-	w := gzip.NewWriter(f)
-	if _, err := w.Write(content); err != nil {
-		t.Fatalf("writing gzip: %v", err)
-	}
-	if err := w.Close(); err != nil {
-		t.Fatalf("closing gzip writer: %v", err)
-	}
+	t.Run("file type uses file size", func(t *testing.T) {
+		size, err := EstimateSize(context.Background(), config.Database{Type: "file", Path: dbPath}, Settings{})
+		if err != nil {
+			t.Fatalf("EstimateSize() error = %v", err)
+		}
+		if size != 4096 {
+			t.Errorf("EstimateSize() = %d, want 4096", size)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := EstimateSize(context.Background(), config.Database{Type: "file", Path: filepath.Join(tmpDir, "missing.db")}, Settings{})
+		if err == nil {
+			t.Error("EstimateSize() expected error for missing file")
+		}
+	})
+
+	t.Run("unsupported type", func(t *testing.T) {
+		_, err := EstimateSize(context.Background(), config.Database{Type: "unknown"}, Settings{})
+		if err == nil {
+			t.Error("EstimateSize() expected error for unsupported type")
+		}
+	})
 }
 
-// Helper to create a zstd compressed file
-func createZstdFile(t *testing.T, path string, content []byte) {
-	t.Helper()
-	f, err := os.Create(path)
+func TestVerify(t *testing.T) {
+	tmpDir := t.TempDir()
+	good := filepath.Join(tmpDir, "good.sql.gz")
+	createGzipFile(t, good, []byte("CREATE TABLE t (id INT);"))
+	goodSum, err := FileSHA256(good)
 	if err != nil {
-		t.Fatalf("creating file: %v", err)
+		t.Fatalf("FileSHA256() error = %v", err)
 	}
-	defer f.Close()
 
-	w, err := zstd.NewWriter(f)
-	if err != nil {
-		t.Fatalf("creating zstd writer: %v", err)
+	// Flip a byte in the compressed payload so the gzip CRC no longer matches
+	corrupt := filepath.Join(tmpDir, "corrupt.sql.gz")
+	data, _ := os.ReadFile(good)
+	data[len(data)-10] ^= 0xff
+	if err := os.WriteFile(corrupt, data, 0644); err != nil {
+		t.Fatalf("writing corrupt file: %v", err)
 	}
-	if _, err := w.Write(content); err != nil {
-		t.Fatalf("writing zstd: %v", err)
+
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+		mode     VerifyMode
+		wantErr  bool
+	}{
+		{"fast with matching checksum", good, goodSum, VerifyFast, false},
+		{"full with matching checksum", good, goodSum, VerifyFull, false},
+		{"fast with checksum mismatch", corrupt, goodSum, VerifyFast, true},
+		{"no checksum falls back to full", good, "", VerifyFast, false},
+		{"no checksum detects corruption", corrupt, "", VerifyFast, true},
 	}
-	if err := w.Close(); err != nil {
-		t.Fatalf("closing zstd writer: %v", err)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.path, tt.expected, tt.mode, Settings{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
 	}
 }
 
-// Helper to create an xz compressed file
-func createXzFile(t *testing.T, path string, content []byte) {
-	t.Helper()
-	f, err := os.Create(path)
-	if err != nil {
-		t.Fatalf("creating file: %v", err)
+func TestVerifyFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	var content []byte
+	for i := range 500 {
+		content = fmt.Appendf(content, "INSERT INTO t VALUES (%d, '%x');\n", i, i*7919)
 	}
-	defer f.Close()
 
-	w, err := xz.NewWriter(f)
-	if err != nil {
-		t.Fatalf("creating xz writer: %v", err)
+	create := map[string]func(*testing.T, string, []byte){
+		".gz":  createGzipFile,
+		".zst": createZstdFile,
+		".xz":  createXzFile,
+		".zip": createZipFile,
 	}
-	if _, err := w.Write(content); err != nil {
-		t.Fatalf("writing xz: %v", err)
+
+	type testCase struct {
+		name    string
+		path    string
+		wantErr bool
 	}
-	if err := w.Close(); err != nil {
-		t.Fatalf("closing xz writer: %v", err)
+	var tests []testCase
+	for _, ext := range []string{".gz", ".zst", ".xz", ".zip"} {
+		valid := filepath.Join(tmpDir, "app.sql"+ext)
+		create[ext](t, valid, content)
+		data, err := os.ReadFile(valid)
+		if err != nil {
+			t.Fatalf("reading %s: %v", valid, err)
+		}
+
+		// Flip a byte in the middle of the compressed payload
+		corrupt := filepath.Join(tmpDir, "corrupt.sql"+ext)
+		flipped := slices.Clone(data)
+		flipped[len(flipped)/2] ^= 0xff
+		if err := os.WriteFile(corrupt, flipped, 0644); err != nil {
+			t.Fatalf("writing corrupt file: %v", err)
+		}
+
+		truncated := filepath.Join(tmpDir, "truncated.sql"+ext)
+		if err := os.WriteFile(truncated, data[:len(data)/2], 0644); err != nil {
+			t.Fatalf("writing truncated file: %v", err)
+		}
+
+		tests = append(tests,
+			testCase{"valid " + ext, valid, false},
+			testCase{"corrupt " + ext, corrupt, true},
+			testCase{"truncated " + ext, truncated, true},
+		)
 	}
-}
 
-// Helper to create a zip file
-func createZipFile(t *testing.T, path string, content []byte) {
-	t.Helper()
-	f, err := os.Create(path)
-	if err != nil {
-		t.Fatalf("creating file: %v", err)
+	plain := filepath.Join(tmpDir, "app.sql")
+	if err := os.WriteFile(plain, content, 0644); err != nil {
+		t.Fatalf("writing plain file: %v", err)
 	}
-	defer f.Close()
+	tests = append(tests,
+		testCase{"uncompressed", plain, false},
+		testCase{"missing", filepath.Join(tmpDir, "missing.sql.gz"), true},
+	)
 
-	w := zip.NewWriter(f)
-	entry, err := w.Create("data.sql")
-	if err != nil {
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyFile(tt.path, Settings{}); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyRemote(t *testing.T) {
+	dest := t.TempDir()
+	content := []byte("CREATE TABLE t (id INT);")
+	createGzipFile(t, filepath.Join(dest, "app_20240115_143022.sql.gz"), content)
+	sum, err := FileSHA256(filepath.Join(dest, "app_20240115_143022.sql.gz"))
+	if err != nil {
+		t.Fatalf("FileSHA256() error = %v", err)
+	}
+	// A checksum recorded for other contents than the uploaded file
+	createGzipFile(t, filepath.Join(dest, "app_20240116_143022.sql.gz"), content)
+	for name, recorded := range map[string]string{
+		"app_20240115_143022.sql.gz": sum,
+		"app_20240116_143022.sql.gz": strings.Repeat("0", 64),
+	} {
+		if err := os.WriteFile(filepath.Join(dest, name+storage.ChecksumExt), []byte(recorded+"  "+name+"\n"), 0644); err != nil {
+			t.Fatalf("writing checksum: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		fileName string
+		wantErr  bool
+	}{
+		{"matching checksum", "app_20240115_143022.sql.gz", false},
+		{"checksum mismatch", "app_20240116_143022.sql.gz", true},
+		{"missing backup", "app_20240117_143022.sql.gz", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyRemote(context.Background(), dest, tt.fileName, Settings{}); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRemote() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Against a checksum read beforehand, as the verify command does
+	if err := VerifyRemoteAgainst(context.Background(), dest, "app_20240115_143022.sql.gz", sum, VerifyFast, Settings{}); err != nil {
+		t.Errorf("VerifyRemoteAgainst() error = %v", err)
+	}
+	if err := VerifyRemoteAgainst(context.Background(), dest, "app_20240115_143022.sql.gz", strings.Repeat("0", 64), VerifyFast, Settings{}); err == nil {
+		t.Error("VerifyRemoteAgainst() with another checksum should fail")
+	}
+}
+
+func TestVerifyStream(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := []byte("CREATE TABLE t (id INT);")
+	gz := filepath.Join(tmpDir, "app.sql.gz")
+	createGzipFile(t, gz, content)
+	zst := filepath.Join(tmpDir, "app.sql.zst")
+	createZstdFile(t, zst, content)
+	xzPath := filepath.Join(tmpDir, "app.sql.xz")
+	createXzFile(t, xzPath, content)
+
+	corrupt := filepath.Join(tmpDir, "corrupt.sql.gz")
+	data, _ := os.ReadFile(gz)
+	data[len(data)-10] ^= 0xff
+	if err := os.WriteFile(corrupt, data, 0644); err != nil {
+		t.Fatalf("writing corrupt file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"gzip", gz, false},
+		{"zstd", zst, false},
+		{"xz", xzPath, false},
+		{"corrupt gzip", corrupt, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !StreamVerifiable(tt.path) {
+				t.Fatalf("StreamVerifiable(%q) = false", tt.path)
+			}
+			f, err := os.Open(tt.path)
+			if err != nil {
+				t.Fatalf("opening %s: %v", tt.path, err)
+			}
+			defer f.Close()
+			if err := VerifyStream(f, tt.path, Settings{}); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyStream() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	for _, name := range []string{"app.sql", "app.sql.zip"} {
+		if StreamVerifiable(name) {
+			t.Errorf("StreamVerifiable(%q) = true, want false", name)
+		}
+	}
+}
+
+func TestPostRestore(t *testing.T) {
+	tests := []struct {
+		name     string
+		db       config.Database
+		expected bool
+	}{
+		{"nothing configured", config.Database{Type: "postgres"}, false},
+		{"vacuum analyze on postgres", config.Database{Type: "postgres", VacuumAnalyze: true}, true},
+		{"vacuum analyze ignored for mysql", config.Database{Type: "mysql", VacuumAnalyze: true}, false},
+		{"command on file", config.Database{Type: "file", PostRestoreCommand: "true"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasPostRestore(tt.db); got != tt.expected {
+				t.Errorf("HasPostRestore() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+
+	t.Run("command sees database env", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "out.txt")
+		db := config.Database{Type: "file", Path: "/data/test.db", PostRestoreCommand: "echo \"$BLOBBER_DB_TYPE $BLOBBER_DB_PATH\" > " + out}
+		msg, err := PostRestore(db, Settings{})
+		if err != nil {
+			t.Fatalf("PostRestore() error = %v", err)
+		}
+		if msg != "Ran post_restore_command" {
+			t.Errorf("PostRestore() message = %q", msg)
+		}
+		data, _ := os.ReadFile(out)
+		if strings.TrimSpace(string(data)) != "file /data/test.db" {
+			t.Errorf("command saw env %q, want %q", strings.TrimSpace(string(data)), "file /data/test.db")
+		}
+	})
+
+	t.Run("failing command", func(t *testing.T) {
+		db := config.Database{Type: "file", PostRestoreCommand: "echo boom >&2; exit 3"}
+		_, err := PostRestore(db, Settings{})
+		if err == nil || !strings.Contains(err.Error(), "boom") {
+			t.Errorf("PostRestore() error = %v, want one containing command output", err)
+		}
+	})
+}
+
+// Helper to create a gzip compressed file
+func createGzipFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating file: %v", err)
+	}
+	defer f.Close()
+
+	w := gzip.NewWriter(f)
+	if _, err := w.Write(content); err != nil {
+		t.Fatalf("writing gzip: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing gzip writer: %v", err)
+	}
+}
+
+// Helper to create a zstd compressed file
+func createZstdFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating file: %v", err)
+	}
+	defer f.Close()
+
+	w, err := zstd.NewWriter(f)
+	if err != nil {
+		t.Fatalf("creating zstd writer: %v", err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatalf("writing zstd: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing zstd writer: %v", err)
+	}
+}
+
+// Helper to create an xz compressed file
+func createXzFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating file: %v", err)
+	}
+	defer f.Close()
+
+	w, err := xz.NewWriter(f)
+	if err != nil {
+		t.Fatalf("creating xz writer: %v", err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatalf("writing xz: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing xz writer: %v", err)
+	}
+}
+
+// Helper to create a zip file
+func createZipFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating file: %v", err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	entry, err := w.Create("data.sql")
+	if err != nil {
 		t.Fatalf("creating zip entry: %v", err)
 	}
 	if _, err := entry.Write(content); err != nil {
@@ -376,7 +1219,7 @@ func TestNewDecompressReader(t *testing.T) {
 			t.Fatalf("writing file: %v", err)
 		}
 
-		reader, cleanup, err := newDecompressReader(path)
+		reader, cleanup, err := newDecompressReader(path, "")
 		if err != nil {
 			t.Fatalf("newDecompressReader() error = %v", err)
 		}
@@ -398,7 +1241,7 @@ func TestNewDecompressReader(t *testing.T) {
 		path := filepath.Join(tmpDir, "data.sql.gz")
 		createGzipFile(t, path, testData)
 
-		reader, cleanup, err := newDecompressReader(path)
+		reader, cleanup, err := newDecompressReader(path, "")
 		if err != nil {
 			t.Fatalf("newDecompressReader() error = %v", err)
 		}
@@ -420,7 +1263,7 @@ func TestNewDecompressReader(t *testing.T) {
 		path := filepath.Join(tmpDir, "data.sql.zst")
 		createZstdFile(t, path, testData)
 
-		reader, cleanup, err := newDecompressReader(path)
+		reader, cleanup, err := newDecompressReader(path, "")
 		if err != nil {
 			t.Fatalf("newDecompressReader() error = %v", err)
 		}
@@ -442,7 +1285,7 @@ func TestNewDecompressReader(t *testing.T) {
 		path := filepath.Join(tmpDir, "data.sql.xz")
 		createXzFile(t, path, testData)
 
-		reader, cleanup, err := newDecompressReader(path)
+		reader, cleanup, err := newDecompressReader(path, "")
 		if err != nil {
 			t.Fatalf("newDecompressReader() error = %v", err)
 		}
@@ -464,7 +1307,7 @@ func TestNewDecompressReader(t *testing.T) {
 		path := filepath.Join(tmpDir, "data.sql.zip")
 		createZipFile(t, path, testData)
 
-		reader, cleanup, err := newDecompressReader(path)
+		reader, cleanup, err := newDecompressReader(path, "")
 		if err != nil {
 			t.Fatalf("newDecompressReader() error = %v", err)
 		}
@@ -492,7 +1335,7 @@ func TestNewDecompressReader(t *testing.T) {
 		w.Close()
 		f.Close()
 
-		_, _, err = newDecompressReader(path)
+		_, _, err = newDecompressReader(path, "")
 		if err == nil {
 			t.Error("expected error for empty zip, got nil")
 		}
@@ -502,7 +1345,7 @@ func TestNewDecompressReader(t *testing.T) {
 	})
 
 	t.Run("missing file", func(t *testing.T) {
-		_, _, err := newDecompressReader("/nonexistent/file.sql")
+		_, _, err := newDecompressReader("/nonexistent/file.sql", "")
 		if err == nil {
 			t.Error("expected error for missing file, got nil")
 		}
@@ -514,7 +1357,7 @@ func TestNewDecompressReader(t *testing.T) {
 			t.Fatalf("writing file: %v", err)
 		}
 
-		_, _, err := newDecompressReader(path)
+		_, _, err := newDecompressReader(path, "")
 		if err == nil {
 			t.Error("expected error for invalid gzip, got nil")
 		}
@@ -537,9 +1380,9 @@ func TestRestoreFile(t *testing.T) {
 			Path: destPath,
 		}
 
-		err := restoreFile(db, backupPath)
+		err := Restore(db, backupPath, Settings{})
 		if err != nil {
-			t.Fatalf("restoreFile() error = %v", err)
+			t.Fatalf("Restore() error = %v", err)
 		}
 
 		restored, err := os.ReadFile(destPath)
@@ -561,9 +1404,9 @@ func TestRestoreFile(t *testing.T) {
 			Path: destPath,
 		}
 
-		err := restoreFile(db, backupPath)
+		err := Restore(db, backupPath, Settings{})
 		if err != nil {
-			t.Fatalf("restoreFile() error = %v", err)
+			t.Fatalf("Restore() error = %v", err)
 		}
 
 		restored, err := os.ReadFile(destPath)
@@ -585,9 +1428,9 @@ func TestRestoreFile(t *testing.T) {
 			Path: destPath,
 		}
 
-		err := restoreFile(db, backupPath)
+		err := Restore(db, backupPath, Settings{})
 		if err != nil {
-			t.Fatalf("restoreFile() error = %v", err)
+			t.Fatalf("Restore() error = %v", err)
 		}
 
 		restored, err := os.ReadFile(destPath)
@@ -599,13 +1442,42 @@ func TestRestoreFile(t *testing.T) {
 		}
 	})
 
+	t.Run("restore file mode", func(t *testing.T) {
+		backupPath := filepath.Join(tmpDir, "backup_mode.db")
+		if err := os.WriteFile(backupPath, testData, 0644); err != nil {
+			t.Fatalf("writing backup: %v", err)
+		}
+
+		destPath := filepath.Join(tmpDir, "restored_mode.db")
+		if err := os.WriteFile(destPath, []byte("old"), 0644); err != nil {
+			t.Fatalf("writing existing file: %v", err)
+		}
+		db := config.Database{
+			Type:            "file",
+			Path:            destPath,
+			RestoreFileMode: "0600",
+		}
+
+		if err := Restore(db, backupPath, Settings{}); err != nil {
+			t.Fatalf("Restore() error = %v", err)
+		}
+
+		stat, err := os.Stat(destPath)
+		if err != nil {
+			t.Fatalf("stat restored file: %v", err)
+		}
+		if stat.Mode().Perm() != 0600 {
+			t.Errorf("restored file mode = %o, want 600", stat.Mode().Perm())
+		}
+	})
+
 	t.Run("missing backup file", func(t *testing.T) {
 		db := config.Database{
 			Type: "file",
 			Path: filepath.Join(tmpDir, "wont_be_created.db"),
 		}
 
-		err := restoreFile(db, "/nonexistent/backup.db")
+		err := Restore(db, "/nonexistent/backup.db", Settings{})
 		if err == nil {
 			t.Error("expected error for missing backup, got nil")
 		}
@@ -622,7 +1494,7 @@ func TestRestoreFile(t *testing.T) {
 			Path: "/nonexistent/dir/restored.db",
 		}
 
-		err := restoreFile(db, backupPath)
+		err := Restore(db, backupPath, Settings{})
 		if err == nil {
 			t.Error("expected error for invalid destination, got nil")
 		}
@@ -645,7 +1517,7 @@ func TestRestore(t *testing.T) {
 			Path: destPath,
 		}
 
-		err := Restore(db, backupPath)
+		err := Restore(db, backupPath, Settings{})
 		if err != nil {
 			t.Fatalf("Restore() error = %v", err)
 		}
@@ -664,7 +1536,7 @@ func TestRestore(t *testing.T) {
 			Type: "mongodb",
 		}
 
-		err := Restore(db, "/some/backup.db")
+		err := Restore(db, "/some/backup.db", Settings{})
 		if err == nil {
 			t.Error("expected error for unknown type, got nil")
 		}
@@ -673,3 +1545,980 @@ func TestRestore(t *testing.T) {
 		}
 	})
 }
+
+func TestRestorePostgresTransaction(t *testing.T) {
+	// Fake psql applying each statement to a state file as it reads it. With
+	// --single-transaction and ON_ERROR_STOP, an error aborts before anything is committed;
+	// otherwise psql reports the error and carries on, as the real one does.
+	bin := t.TempDir()
+	state := filepath.Join(t.TempDir(), "state")
+	script := `#!/bin/sh
+tx=0; stop=0
+for a in "$@"; do
+  case "$a" in --single-transaction) tx=1 ;; ON_ERROR_STOP=1) stop=1 ;; esac
+done
+applied=""
+while IFS= read -r line; do
+  case "$line" in
+    *bogus*) echo "ERROR:  relation \"bogus\" does not exist" >&2
+       if [ $stop = 1 ]; then
+         if [ $tx = 0 ]; then printf '%s' "$applied" > ` + state + `; fi
+         exit 3
+       fi ;;
+    *) applied="$applied$line
+" ;;
+  esac
+done
+printf '%s' "$applied" > ` + state + `
+`
+	if err := os.WriteFile(filepath.Join(bin, "psql"), []byte(script), 0755); err != nil {
+		t.Fatalf("writing psql: %v", err)
+	}
+	t.Setenv("PATH", bin)
+
+	backupPath := filepath.Join(t.TempDir(), "app_20240115_143022.sql")
+	dump := "DROP TABLE IF EXISTS users;\nCREATE TABLE users (id int);\nINSERT INTO bogus VALUES (1);\nINSERT INTO users VALUES (1);\n"
+	if err := os.WriteFile(backupPath, []byte(dump), 0644); err != nil {
+		t.Fatalf("writing backup: %v", err)
+	}
+	db := config.Database{Type: "postgres", Host: "db", Port: 5432, User: "app", Database: "app"}
+
+	t.Run("failing dump rolls back", func(t *testing.T) {
+		if err := os.WriteFile(state, []byte("original\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := Restore(db, backupPath, Settings{})
+		if err == nil || !strings.Contains(err.Error(), "bogus") {
+			t.Fatalf("Restore() error = %v, want the failing statement's error", err)
+		}
+		if got, _ := os.ReadFile(state); string(got) != "original\n" {
+			t.Errorf("database state = %q, want it untouched", got)
+		}
+	})
+
+	t.Run("restore_no_transaction applies what it can", func(t *testing.T) {
+		if err := os.WriteFile(state, []byte("original\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		noTx := db
+		noTx.RestoreNoTransaction = true
+		if err := Restore(noTx, backupPath, Settings{}); err != nil {
+			t.Fatalf("Restore() error = %v", err)
+		}
+		if got, _ := os.ReadFile(state); !strings.Contains(string(got), "INSERT INTO users") {
+			t.Errorf("database state = %q, want the statements after the error applied", got)
+		}
+	})
+}
+
+func TestRestoreMySQLTransaction(t *testing.T) {
+	// Fake mysql recording its arguments and input
+	bin := t.TempDir()
+	out := t.TempDir()
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > " + filepath.Join(out, "args") + "\ncat > " + filepath.Join(out, "input") + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "mysql"), []byte(script), 0755); err != nil {
+		t.Fatalf("writing mysql: %v", err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	backupPath := filepath.Join(t.TempDir(), "app_20240115_143022.sql")
+	dump := "DROP TABLE IF EXISTS users;\nCREATE TABLE users (id int);\nINSERT INTO users VALUES (1);"
+	if err := os.WriteFile(backupPath, []byte(dump), 0644); err != nil {
+		t.Fatalf("writing backup: %v", err)
+	}
+	db := config.Database{Type: "mysql", Host: "db", Port: 3306, User: "app", Database: "app"}
+
+	tests := []struct {
+		name      string
+		noTx      bool
+		wantInit  bool
+		wantInput string
+	}{
+		{"autocommit off and a final commit", false, true, dump + "\nCOMMIT;\n"},
+		{"restore_no_transaction", true, false, dump},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := db
+			db.RestoreNoTransaction = tt.noTx
+			if err := Restore(db, backupPath, Settings{}); err != nil {
+				t.Fatalf("Restore() error = %v", err)
+			}
+			args, _ := os.ReadFile(filepath.Join(out, "args"))
+			if got := strings.Contains(string(args), "--init-command=SET autocommit=0\n"); got != tt.wantInit {
+				t.Errorf("mysql args = %q, autocommit=0 passed = %v, want %v", args, got, tt.wantInit)
+			}
+			input, _ := os.ReadFile(filepath.Join(out, "input"))
+			if string(input) != tt.wantInput {
+				t.Errorf("mysql input = %q, want %q", input, tt.wantInput)
+			}
+		})
+	}
+}
+
+func TestRestoreStream(t *testing.T) {
+	testData := bytes.Repeat([]byte("streamed restore data\n"), 1000)
+	tmpDir := t.TempDir()
+	gzPath := filepath.Join(tmpDir, "app_20240115_143022.db.gz")
+	createGzipFile(t, gzPath, testData)
+	zstPath := filepath.Join(tmpDir, "app_20240115_143022.db.zst")
+	createZstdFile(t, zstPath, testData)
+
+	for _, backupPath := range []string{gzPath, zstPath} {
+		t.Run(filepath.Ext(backupPath), func(t *testing.T) {
+			f, err := os.Open(backupPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			db := config.Database{Type: "file", Path: filepath.Join(t.TempDir(), "restored.db")}
+			if err := RestoreStream(db, f, filepath.Base(backupPath), Settings{}); err != nil {
+				t.Fatalf("RestoreStream() error = %v", err)
+			}
+			if got, _ := os.ReadFile(db.Path); !bytes.Equal(got, testData) {
+				t.Errorf("restored %d bytes, want the %d of the backup", len(got), len(testData))
+			}
+		})
+	}
+
+	t.Run("zip and encrypted backups are downloaded first", func(t *testing.T) {
+		db := config.Database{Type: "file", Path: filepath.Join(t.TempDir(), "restored.db")}
+		for _, name := range []string{"app_20240115_143022.db.zip", "app_20240115_143022.db.gz.gpg"} {
+			if Streamable(name) {
+				t.Errorf("Streamable(%q) = true, want false", name)
+			}
+			if err := RestoreStream(db, strings.NewReader(""), name, Settings{}); err == nil {
+				t.Errorf("RestoreStream(%q) succeeded, want an error", name)
+			}
+		}
+		if _, err := os.Stat(db.Path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("restore target was written: %v", err)
+		}
+	})
+
+	t.Run("truncated download is not committed", func(t *testing.T) {
+		// Fake psql committing what it read once its input ends
+		bin := t.TempDir()
+		state := filepath.Join(t.TempDir(), "state")
+		script := "#!/bin/sh\ndata=$(cat)\nprintf '%s' \"$data\" > " + state + "\n"
+		if err := os.WriteFile(filepath.Join(bin, "psql"), []byte(script), 0755); err != nil {
+			t.Fatalf("writing psql: %v", err)
+		}
+		t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+		restoreTargetExists = func(config.Database) (bool, error) { return true, nil }
+		t.Cleanup(func() { restoreTargetExists = targetExists })
+
+		data, err := os.ReadFile(gzPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db := config.Database{Type: "postgres", Host: "db", Port: 5432, User: "app", Database: "app"}
+		err = RestoreStream(db, bytes.NewReader(data[:len(data)/2]), filepath.Base(gzPath), Settings{})
+		if err == nil || !strings.Contains(err.Error(), "reading backup") {
+			t.Fatalf("RestoreStream() error = %v, want the read error", err)
+		}
+		if _, err := os.Stat(state); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("psql committed the truncated dump: %v", err)
+		}
+	})
+
+	t.Run("truncated download keeps the file target", func(t *testing.T) {
+		data, err := os.ReadFile(gzPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := t.TempDir()
+		db := config.Database{Type: "file", Path: filepath.Join(dir, "restored.db")}
+		if err := os.WriteFile(db.Path, []byte("previous"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := RestoreStream(db, bytes.NewReader(data[:len(data)/2]), filepath.Base(gzPath), Settings{}); err == nil {
+			t.Fatal("RestoreStream() succeeded on a truncated download, want an error")
+		}
+		if got, _ := os.ReadFile(db.Path); string(got) != "previous" {
+			t.Errorf("target holds %d bytes, want its previous content", len(got))
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("restore left %d files next to the target, want only the target", len(entries))
+		}
+	})
+}
+
+func TestRestoreMode(t *testing.T) {
+	backupPath := filepath.Join(t.TempDir(), "app_20240115_143022.db")
+	if err := os.WriteFile(backupPath, []byte("backup"), 0644); err != nil {
+		t.Fatalf("writing backup: %v", err)
+	}
+
+	tests := []struct {
+		mode    string
+		present bool
+		wantErr string
+	}{
+		{"", true, ""},
+		{"", false, ""},
+		{config.RestoreUpsert, true, ""},
+		{config.RestoreUpsert, false, ""},
+		{config.RestoreCreateOnly, true, "already exists"},
+		{config.RestoreCreateOnly, false, ""},
+		{config.RestoreOverwriteOnly, true, ""},
+		{config.RestoreOverwriteOnly, false, "does not exist"},
+	}
+
+	for _, tt := range tests {
+		name := fmt.Sprintf("%s present=%v", cmp.Or(tt.mode, "default"), tt.present)
+
+		t.Run("file "+name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "app.db")
+			if tt.present {
+				if err := os.WriteFile(target, []byte("current"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := Restore(config.Database{Type: "file", Path: target, RestoreMode: tt.mode}, backupPath, Settings{})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Restore() error = %v", err)
+				}
+				if got, _ := os.ReadFile(target); string(got) != "backup" {
+					t.Errorf("target = %q, want the backup restored", got)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Restore() error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if got, err := os.ReadFile(target); tt.present && string(got) != "current" {
+				t.Errorf("target = %q, want it untouched", got)
+			} else if !tt.present && !os.IsNotExist(err) {
+				t.Errorf("target created by a refused restore")
+			}
+		})
+
+		t.Run("postgres "+name, func(t *testing.T) {
+			var created []string
+			probed := false
+			restoreTargetExists = func(config.Database) (bool, error) {
+				probed = true
+				return tt.present, nil
+			}
+			createDatabase = func(db config.Database) error {
+				created = append(created, db.Database)
+				return nil
+			}
+			t.Cleanup(func() { restoreTargetExists, createDatabase = targetExists, createTargetDatabase })
+			// No psql on PATH: a restore allowed to run fails at the client, after
+			// create-only has created the missing database
+			t.Setenv("PATH", t.TempDir())
+
+			db := config.Database{Type: "postgres", Host: "db", Port: 5432, User: "app", Database: "app", RestoreMode: tt.mode}
+			err := Restore(db, backupPath, Settings{})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Restore() error = %v, want one containing %q", err, tt.wantErr)
+				}
+			} else if err == nil || strings.Contains(err.Error(), "restore_mode") {
+				t.Fatalf("Restore() error = %v, want the restore to run", err)
+			}
+			if upsert := cmp.Or(tt.mode, config.RestoreUpsert) == config.RestoreUpsert; probed == upsert {
+				t.Errorf("target probed = %v, want %v", probed, !upsert)
+			}
+			wantCreated := tt.mode == config.RestoreCreateOnly && !tt.present
+			if (len(created) == 1) != wantCreated {
+				t.Errorf("created = %v, want database created: %v", created, wantCreated)
+			}
+		})
+	}
+}
+
+func TestZstdDictionary(t *testing.T) {
+	dir := t.TempDir()
+
+	var samples [][]byte
+	for i := range 5 {
+		var sample strings.Builder
+		sample.WriteString("CREATE TABLE orders (id INT PRIMARY KEY, customer_id INT, total DECIMAL(10,2));\n")
+		for row := range 200 {
+			fmt.Fprintf(&sample, "INSERT INTO orders VALUES (%d, %d, %d.%02d);\n", row, (row*7919+i)%1013, row*31%977, row%100)
+		}
+		samples = append(samples, []byte(sample.String()))
+	}
+	const id = 40000
+	dict, err := TrainDictionary(samples)
+	if err != nil {
+		t.Fatalf("TrainDictionary() error = %v", err)
+	}
+	if _, err := SaveDictionary(dir, id, dict); err != nil {
+		t.Fatalf("SaveDictionary() error = %v", err)
+	}
+
+	content := []byte("CREATE TABLE orders_9 (id INT PRIMARY KEY, customer_id INT, total DECIMAL(10,2));\n")
+	path := filepath.Join(t.TempDir(), "shop.sql.zst")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating file: %v", err)
+	}
+	w, cleanup, err := newCompressWriter(f, "zstd", dir, id, "shop.sql")
+	if err != nil {
+		t.Fatalf("newCompressWriter() error = %v", err)
+	}
+	w.Write(content)
+	cleanup()
+	f.Close()
+
+	t.Run("restores with the recorded dictionary", func(t *testing.T) {
+		reader, cleanup, err := newDecompressReader(path, dir)
+		if err != nil {
+			t.Fatalf("newDecompressReader() error = %v", err)
+		}
+		defer cleanup()
+		got, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("reading: %v", err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("decompressed = %q, want %q", got, content)
+		}
+	})
+
+	t.Run("checks the dictionary directory", func(t *testing.T) {
+		if err := (Settings{DictionaryDir: dir}).CheckDictionary(id); err != nil {
+			t.Errorf("CheckDictionary() error = %v", err)
+		}
+		if err := (Settings{DictionaryDir: t.TempDir()}).CheckDictionary(id); err == nil {
+			t.Error("CheckDictionary() error = nil for a directory without it")
+		}
+	})
+
+	t.Run("missing dictionary", func(t *testing.T) {
+		if _, _, err := newDecompressReader(path, t.TempDir()); err == nil || !strings.Contains(err.Error(), "40000") {
+			t.Errorf("newDecompressReader() error = %v, want one naming dictionary 40000", err)
+		}
+	})
+
+	t.Run("unknown dictionary when compressing", func(t *testing.T) {
+		var buf bytes.Buffer
+		if _, _, err := newCompressWriter(&buf, "zstd", dir, 12345, "shop.sql"); err == nil {
+			t.Error("newCompressWriter() error = nil, want missing dictionary")
+		}
+	})
+}
+
+// newTestKeyring creates a gpg home holding a key for test@example.com without a
+// passphrase, and points gpg at it. Skips the test when gpg is not installed.
+func newTestKeyring(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not installed")
+	}
+	// The agent socket lives in the home, so keep its path short
+	home, err := os.MkdirTemp("", "gpg")
+	if err != nil {
+		t.Fatalf("creating gpg home: %v", err)
+	}
+	t.Setenv("GNUPGHOME", home)
+	t.Cleanup(func() {
+		exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+		os.RemoveAll(home)
+	})
+
+	cmd := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key", "Test <test@example.com>", "future-default", "default", "never")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generating test key: %v: %s", err, out)
+	}
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	newTestKeyring(t)
+	t.Setenv("TMPDIR", t.TempDir())
+
+	content := []byte(strings.Repeat("CREATE TABLE t (id INT);\n", 100))
+	srcPath := filepath.Join(t.TempDir(), "app.db")
+	if err := os.WriteFile(srcPath, content, 0644); err != nil {
+		t.Fatalf("writing source file: %v", err)
+	}
+
+	for _, compression := range []string{"gz", "zip", "none"} {
+		t.Run(compression, func(t *testing.T) {
+			db := config.Database{
+				Type:        "file",
+				Path:        srcPath,
+				Compression: compression,
+				Encryption:  &config.Encryption{Type: "gpg", Recipients: []string{"test@example.com"}},
+			}
+			result, err := Run(context.Background(), "app", db, Settings{})
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			defer Cleanup(result)
+
+			if want := ".db" + compressionExt[compression] + ".gpg"; !strings.HasSuffix(result.Filename, want) {
+				t.Errorf("Filename = %q, want suffix %q", result.Filename, want)
+			}
+			if CompressionFromFilename(result.Filename) != strings.TrimPrefix(compression, "none") {
+				t.Errorf("CompressionFromFilename(%q) = %q", result.Filename, CompressionFromFilename(result.Filename))
+			}
+			entries, _ := os.ReadDir(filepath.Dir(result.Path))
+			if len(entries) != 1 {
+				t.Errorf("backup dir holds %v, want only the encrypted backup", entries)
+			}
+			encrypted, err := os.ReadFile(result.Path)
+			if err != nil {
+				t.Fatalf("reading backup: %v", err)
+			}
+			if bytes.Contains(encrypted, []byte("CREATE TABLE")) {
+				t.Error("encrypted backup contains the plaintext")
+			}
+			if sum, _ := FileSHA256(result.Path); sum != result.SHA256 {
+				t.Errorf("SHA256 = %s, want the checksum of the encrypted file %s", result.SHA256, sum)
+			}
+
+			if err := Verify(result.Path, "", VerifyFull, Settings{}); err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+
+			restoreDB := config.Database{Type: "file", Path: filepath.Join(t.TempDir(), "restored.db")}
+			if err := Restore(restoreDB, result.Path, Settings{}); err != nil {
+				t.Fatalf("Restore() error = %v", err)
+			}
+			restored, err := os.ReadFile(restoreDB.Path)
+			if err != nil {
+				t.Fatalf("reading restored file: %v", err)
+			}
+			if !bytes.Equal(restored, content) {
+				t.Error("restored data mismatch")
+			}
+		})
+	}
+
+	if _, _, err := DecompressStream(bytes.NewReader(nil), "app_20240115_143022.db.gz.gpg", Settings{}); err == nil {
+		t.Error("DecompressStream() of an encrypted backup succeeded, want an error")
+	}
+
+	t.Run("unknown recipient", func(t *testing.T) {
+		db := config.Database{
+			Type:        "file",
+			Path:        srcPath,
+			Compression: "gz",
+			Encryption:  &config.Encryption{Type: "gpg", Recipients: []string{"nobody@example.com"}},
+		}
+		if _, err := Run(context.Background(), "app", db, Settings{}); err == nil || !strings.Contains(err.Error(), "encrypting backup") {
+			t.Fatalf("Run() error = %v, want an encryption error", err)
+		}
+		if entries, _ := os.ReadDir(os.Getenv("TMPDIR")); len(entries) != 0 {
+			t.Errorf("failed encryption left %v behind", entries)
+		}
+	})
+}
+
+func TestReencrypt(t *testing.T) {
+	newTestKeyring(t)
+	cmd := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key", "New <new@example.com>", "future-default", "default", "never")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generating new key: %v: %s", err, out)
+	}
+
+	dir := t.TempDir()
+	plainPath := filepath.Join(dir, "app_20240115_143022.db")
+	content := []byte("CREATE TABLE t (id INT);\n")
+	if err := os.WriteFile(plainPath, content, 0644); err != nil {
+		t.Fatalf("writing backup: %v", err)
+	}
+	oldPath, err := encryptFile(context.Background(), &config.Encryption{Type: "gpg", Recipients: []string{"test@example.com"}}, plainPath)
+	if err != nil {
+		t.Fatalf("encryptFile() error = %v", err)
+	}
+
+	newPath := filepath.Join(dir, "rekeyed.db.gpg")
+	if err := Reencrypt(context.Background(), oldPath, newPath, "test@example.com", []string{"new@example.com"}); err != nil {
+		t.Fatalf("Reencrypt() error = %v", err)
+	}
+	out, err := exec.Command("gpg", "--batch", "--list-packets", newPath).CombinedOutput()
+	if err != nil {
+		t.Fatalf("listing packets: %v: %s", err, out)
+	}
+	if !strings.Contains(string(out), "New <new@example.com>") || strings.Contains(string(out), "Test <test@example.com>") {
+		t.Errorf("re-encrypted backup is not encrypted to the new key only:\n%s", out)
+	}
+	decrypted := filepath.Join(dir, "decrypted.db")
+	if err := runGPG(context.Background(), []string{"--batch", "--output", decrypted, "--decrypt", newPath}); err != nil {
+		t.Fatalf("decrypting re-encrypted backup: %v", err)
+	}
+	if got, _ := os.ReadFile(decrypted); !bytes.Equal(got, content) {
+		t.Errorf("re-encrypted backup holds %q, want %q", got, content)
+	}
+
+	// A failure on either side leaves nothing behind
+	failed := filepath.Join(dir, "failed.db.gpg")
+	if err := Reencrypt(context.Background(), oldPath, failed, "", []string{"nobody@example.com"}); err == nil || !strings.Contains(err.Error(), "encrypting backup") {
+		t.Errorf("Reencrypt() to an unknown recipient error = %v, want an encryption error", err)
+	}
+	corrupt := filepath.Join(dir, "corrupt.db.gpg")
+	os.WriteFile(corrupt, []byte("not gpg"), 0644)
+	if err := Reencrypt(context.Background(), corrupt, failed, "", []string{"new@example.com"}); err == nil || !strings.Contains(err.Error(), "decrypting backup") {
+		t.Errorf("Reencrypt() of a corrupt backup error = %v, want a decryption error", err)
+	}
+	if _, err := os.Stat(failed); !os.IsNotExist(err) {
+		t.Error("failed Reencrypt() left its output behind")
+	}
+
+	// gpg falls back to another key of the keyring than --from: the copy is refused
+	if err := Reencrypt(context.Background(), oldPath, failed, "new@example.com", []string{"new@example.com"}); err == nil || !strings.Contains(err.Error(), "not new@example.com") {
+		t.Errorf("Reencrypt() decrypted with another key than from: error = %v, want it refused", err)
+	}
+	if err := Reencrypt(context.Background(), oldPath, failed, "nobody@example.com", []string{"new@example.com"}); err == nil || !strings.Contains(err.Error(), "no secret key matching nobody@example.com") {
+		t.Errorf("Reencrypt() from an unknown key error = %v, want it refused", err)
+	}
+	if _, err := os.Stat(failed); !os.IsNotExist(err) {
+		t.Error("refused Reencrypt() left its output behind")
+	}
+}
+
+func TestToolVersion(t *testing.T) {
+	dir := t.TempDir()
+	tool := func(name, script string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	got, err := ToolVersion(context.Background(), tool("ok", "echo '  mysqldump  Ver 8.0.36'; echo 'second line'"))
+	if err != nil || got != "mysqldump  Ver 8.0.36" {
+		t.Errorf("ToolVersion() = %q, %v; want the first line", got, err)
+	}
+	if _, err := ToolVersion(context.Background(), tool("fails", "exit 1")); err == nil {
+		t.Error("ToolVersion() error = nil for a failing tool")
+	}
+
+	defer func(timeout time.Duration) { toolVersionTimeout = timeout }(toolVersionTimeout)
+	toolVersionTimeout = 100 * time.Millisecond
+	start := time.Now()
+	_, err = ToolVersion(context.Background(), tool("hangs", "sleep 30 & wait"))
+	if err == nil || !strings.Contains(err.Error(), "no answer within 100ms") {
+		t.Errorf("ToolVersion() error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ToolVersion() took %s on a hanging tool", elapsed)
+	}
+}
+
+func TestEncryptionToolMissing(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	db := config.Database{Type: "file", Path: "/nonexistent", Encryption: &config.Encryption{Type: "gpg", Recipients: []string{"test@example.com"}}}
+	_, err := Run(context.Background(), "app", db, Settings{})
+	if err == nil || err.Error() != "gpg not found in PATH (required for encryption)" {
+		t.Errorf("Run() error = %v, want gpg not found", err)
+	}
+	if got := CheckEncryptionUtilities(config.Database{Type: "file"}); got != nil {
+		t.Errorf("CheckEncryptionUtilities() without encryption = %v, want nil", got)
+	}
+	if got := CheckRequiredUtilities(db); !slices.Equal(got, []string{"gpg not found in PATH (required for encryption)"}) {
+		t.Errorf("CheckRequiredUtilities() = %v, want gpg missing", got)
+	}
+}
+
+func TestEncryptFileRemovesPlaintext(t *testing.T) {
+	// Fake gpgs writing their --output, or failing after writing part of it
+	for _, tt := range []struct {
+		name    string
+		script  string
+		wantErr bool
+	}{
+		{"success", "#!/bin/sh\nwhile [ $# -gt 0 ]; do [ \"$1\" = --output ] && echo encrypted > \"$2\"; shift; done\n", false},
+		{"failure", "#!/bin/sh\nwhile [ $# -gt 0 ]; do [ \"$1\" = --output ] && echo partial > \"$2\"; shift; done\necho 'no public key' >&2\nexit 2\n", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			bin := t.TempDir()
+			if err := os.WriteFile(filepath.Join(bin, "gpg"), []byte(tt.script), 0755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+			path := filepath.Join(t.TempDir(), "app_20240115_143022.sql.gz")
+			if err := os.WriteFile(path, []byte("plaintext"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := encryptFile(context.Background(), &config.Encryption{Type: "gpg", Recipients: []string{"ops@example.com"}}, path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("encryptFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Error("plaintext dump left behind")
+			}
+			if _, err := os.Stat(path + EncryptedExt); (err == nil) == tt.wantErr {
+				t.Errorf("encrypted output exists = %v, want %v", err == nil, !tt.wantErr)
+			}
+			if !tt.wantErr && out != path+EncryptedExt {
+				t.Errorf("encryptFile() = %q, want %q", out, path+EncryptedExt)
+			}
+		})
+	}
+}
+
+func TestMySQLDumpClient(t *testing.T) {
+	// Fake clients answering --version and --help as the real ones do
+	mysql8 := "#!/bin/sh\ncase \"$1\" in\n--version) echo 'mysqldump  Ver 8.0.36 for Linux on x86_64 (MySQL Community Server - GPL)' ;;\n--help) echo '  --column-statistics Add ANALYZE TABLE statements to the output' ;;\nesac\n"
+	mysql57 := "#!/bin/sh\ncase \"$1\" in\n--version) echo 'mysqldump  Ver 10.13 Distrib 5.7.44, for Linux (x86_64)' ;;\n--help) echo '  --compact Give less verbose output' ;;\nesac\n"
+	mariadb := "#!/bin/sh\ncase \"$1\" in\n--version) echo 'mariadb-dump from 11.2.2-MariaDB, client 10.19 for debian-linux-gnu (x86_64)' ;;\n--help) echo '  --compact Give less verbose output' ;;\nesac\n"
+
+	tests := []struct {
+		name        string
+		installed   map[string]string // binary -> script
+		mariadb     bool
+		wantBinary  string
+		wantColStat bool
+	}{
+		{"MySQL 8 client", map[string]string{"mysqldump": mysql8}, false, "mysqldump", true},
+		{"MySQL 8 client against MariaDB", map[string]string{"mysqldump": mysql8}, true, "mysqldump", true},
+		{"MySQL 5.7 client", map[string]string{"mysqldump": mysql57}, false, "mysqldump", false},
+		{"MariaDB's mysqldump", map[string]string{"mysqldump": mariadb}, false, "mysqldump", false},
+		{"only mariadb-dump", map[string]string{"mariadb-dump": mariadb}, false, "mariadb-dump", false},
+		{"mariadb prefers mariadb-dump", map[string]string{"mysqldump": mysql8, "mariadb-dump": mariadb}, true, "mariadb-dump", false},
+		{"mysql prefers mysqldump", map[string]string{"mysqldump": mysql8, "mariadb-dump": mariadb}, false, "mysqldump", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bin := t.TempDir()
+			for name, script := range tt.installed {
+				if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+					t.Fatalf("writing %s: %v", name, err)
+				}
+			}
+			t.Setenv("PATH", bin)
+
+			db := config.Database{Type: "mysql", Host: "db", Port: 3306, User: "u", Database: "shop", MariaDB: tt.mariadb}
+			client := detectMySQLDump(db)
+			if client.binary != tt.wantBinary {
+				t.Errorf("detectMySQLDump() binary = %s, want %s", client.binary, tt.wantBinary)
+			}
+			args := mysqlDumpArgs(db, client, false)
+			if got := slices.Contains(args, "--column-statistics=0"); got != tt.wantColStat {
+				t.Errorf("mysqlDumpArgs() = %v, want --column-statistics=0: %v", args, tt.wantColStat)
+			}
+			if args[len(args)-1] != "shop" {
+				t.Errorf("mysqlDumpArgs() = %v, want the database last", args)
+			}
+		})
+	}
+}
+
+func TestConsistencyGroupDumpArgs(t *testing.T) {
+	mysqlDB := config.Database{Type: "mysql", Host: "db", Port: 3306, User: "u", Database: "orders"}
+	if args := mysqlDumpArgs(mysqlDB, mysqlDumpClient{}, false); slices.Contains(args, "--single-transaction") {
+		t.Errorf("mysqlDumpArgs() outside a group = %v, want no --single-transaction", args)
+	}
+	args := mysqlDumpArgs(mysqlDB, mysqlDumpClient{binary: "mysqldump", columnStats: true}, true)
+	if !slices.Contains(args, "--single-transaction") || !slices.Contains(args, "--verbose") || !slices.Contains(args, "--column-statistics=0") || args[len(args)-1] != "orders" {
+		t.Errorf("mysqlDumpArgs() in a group = %v, want --single-transaction and --verbose before the database", args)
+	}
+
+	pgDB := config.Database{Type: "postgres", Host: "db", Port: 5432, User: "u", Database: "app"}
+	for _, arg := range postgresDumpArgs(pgDB, "") {
+		if strings.HasPrefix(arg, "--snapshot") {
+			t.Errorf("postgresDumpArgs() outside a group passes %s", arg)
+		}
+	}
+	args = postgresDumpArgs(pgDB, "00000003-0000001B-1")
+	if !slices.Contains(args, "--snapshot=00000003-0000001B-1") || args[len(args)-1] != "app" {
+		t.Errorf("postgresDumpArgs() in a group = %v, want the exported snapshot", args)
+	}
+	if !slices.Contains(args, "--no-owner") || !slices.Contains(args, "--no-privileges") {
+		t.Errorf("postgresDumpArgs() = %v, want ownership and grants left out", args)
+	}
+}
+
+func TestCharsetArgs(t *testing.T) {
+	// A latin1 database round-trips byte for byte only when the dump and the restore both
+	// run in its character set
+	mysqlDB := config.Database{Type: "mysql", Host: "db", Port: 3306, User: "u", Database: "legacy", Charset: "latin1"}
+	for name, args := range map[string][]string{
+		"mysqldump": mysqlDumpArgs(mysqlDB, mysqlDumpClient{}, false),
+		"mysql":     mysqlRestoreArgs(mysqlDB),
+	} {
+		if !slices.Contains(args, "--default-character-set=latin1") || args[len(args)-1] != "legacy" {
+			t.Errorf("%s args = %v, want --default-character-set=latin1 before the database", name, args)
+		}
+	}
+	mysqlDB.Charset = ""
+	for _, arg := range append(mysqlDumpArgs(mysqlDB, mysqlDumpClient{}, false), mysqlRestoreArgs(mysqlDB)...) {
+		if strings.HasPrefix(arg, "--default-character-set") {
+			t.Errorf("mysql without charset passes %s", arg)
+		}
+	}
+
+	// pg_dump and psql take the encoding from the environment, next to the password
+	pgDB := config.Database{Type: "postgres", Host: "db", Port: 5432, User: "u", Password: "secret", Database: "legacy", Charset: "LATIN1"}
+	env := postgresEnv(pgDB)
+	if !slices.Contains(env, "PGCLIENTENCODING=LATIN1") || !slices.Contains(env, "PGPASSWORD=secret") {
+		t.Errorf("postgresEnv() lacks the client encoding or password: %v", env[len(env)-3:])
+	}
+	pgDB.Charset = ""
+	if without := postgresEnv(pgDB); len(without) != len(env)-1 {
+		t.Errorf("postgresEnv() without charset = %v, want no client encoding added", without[len(os.Environ()):])
+	}
+}
+
+func TestDumpSourceArgs(t *testing.T) {
+	// hostPort returns the host and port args carries after their flags
+	hostPort := func(args []string, portFlag string) (string, string) {
+		return args[slices.Index(args, "-h")+1], args[slices.Index(args, portFlag)+1]
+	}
+
+	tests := []struct {
+		name     string
+		db       config.Database
+		dumpHost string
+		dumpPort string
+	}{
+		{"no dump source", config.Database{Host: "primary", Port: 3306}, "primary", "3306"},
+		{"dump_host", config.Database{Host: "primary", Port: 3306, DumpHost: "replica"}, "replica", "3306"},
+		{"dump_host and dump_port", config.Database{Host: "primary", Port: 3306, DumpHost: "replica", DumpPort: 3307}, "replica", "3307"},
+		{"dump_port only", config.Database{Host: "primary", Port: 3306, DumpPort: 3307}, "primary", "3307"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mysqlDB := tt.db
+			mysqlDB.Type, mysqlDB.User, mysqlDB.Database = "mysql", "u", "orders"
+			if host, port := hostPort(mysqlDumpArgs(mysqlDB, mysqlDumpClient{}, false), "-P"); host != tt.dumpHost || port != tt.dumpPort {
+				t.Errorf("mysqldump connects to %s:%s, want %s:%s", host, port, tt.dumpHost, tt.dumpPort)
+			}
+			if host, port := hostPort(mysqlRestoreArgs(mysqlDB), "-P"); host != "primary" || port != "3306" {
+				t.Errorf("mysql restore connects to %s:%s, want primary:3306", host, port)
+			}
+
+			pgDB := tt.db
+			pgDB.Type, pgDB.User, pgDB.Database = "postgres", "u", "app"
+			if host, port := hostPort(postgresDumpArgs(pgDB, ""), "-p"); host != tt.dumpHost || port != tt.dumpPort {
+				t.Errorf("pg_dump connects to %s:%s, want %s:%s", host, port, tt.dumpHost, tt.dumpPort)
+			}
+			if host, port := hostPort(postgresRestoreArgs(pgDB), "-p"); host != "primary" || port != "3306" {
+				t.Errorf("psql restore connects to %s:%s, want primary:3306", host, port)
+			}
+		})
+	}
+}
+
+func TestConsistencyGroups(t *testing.T) {
+	var mu sync.Mutex
+	opened, released := 0, 0
+	groupOpener = func(ctx context.Context, db config.Database) (string, func(), error) {
+		mu.Lock()
+		defer mu.Unlock()
+		opened++
+		if db.Host == "down" {
+			return "", nil, errors.New("connection refused")
+		}
+		return "snap-1", func() {
+			mu.Lock()
+			released++
+			mu.Unlock()
+		}, nil
+	}
+	defer func() { groupOpener = openGroupSession }()
+
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"orders":    {Type: "postgres", Host: "db", Database: "app", ConsistencyGroup: "shop"},
+		"redacted":  {Type: "postgres", Host: "db", Database: "app", ConsistencyGroup: "shop"},
+		"inventory": {Type: "postgres", Host: "db", Database: "app", ConsistencyGroup: "shop"},
+		"solo":      {Type: "postgres", Host: "db", Database: "app", ConsistencyGroup: "alone"},
+		"plain":     {Type: "postgres", Host: "db", Database: "app"},
+	}}
+	groups := NewConsistencyGroups(cfg, []string{"orders", "redacted", "inventory", "solo", "plain"})
+
+	// The members dump together, at the one snapshot of their group
+	var wg sync.WaitGroup
+	var dones []func()
+	for _, name := range []string{"orders", "redacted", "inventory"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			grouped, snapshot, done, err := groups.join(context.Background(), cfg.Databases[name])
+			if err != nil || !grouped || snapshot != "snap-1" {
+				t.Errorf("join(%s) = %v, %q, %v; want the group's snapshot", name, grouped, snapshot, err)
+				return
+			}
+			mu.Lock()
+			dones = append(dones, done)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if opened != 1 {
+		t.Errorf("opened %d sessions, want 1 for the group", opened)
+	}
+
+	// A group with one database of the run and databases without a group need no session
+	for _, name := range []string{"solo", "plain"} {
+		if grouped, snapshot, _, err := groups.join(context.Background(), cfg.Databases[name]); grouped || snapshot != "" || err != nil {
+			t.Errorf("join(%s) = %v, %q, %v; want no session", name, grouped, snapshot, err)
+		}
+	}
+
+	// The session is released once every member has dumped
+	for i, done := range dones {
+		done()
+		done() // calling it again is harmless
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		want := 0
+		if i == len(dones)-1 {
+			want = 1
+		}
+		if released != want {
+			t.Errorf("after %d dump(s), released %d session(s), want %d", i+1, released, want)
+		}
+		mu.Unlock()
+	}
+	groups.Close()
+	if released != 1 {
+		t.Errorf("Close() released the session again")
+	}
+
+	// A member skipped before dumping doesn't keep the session open for the run
+	groups = NewConsistencyGroups(cfg, []string{"orders", "redacted", "inventory"})
+	ctx := WithConsistencyGroups(context.Background(), groups)
+	SkipConsistencyGroup(ctx, cfg.Databases["inventory"])
+	for _, name := range []string{"orders", "redacted"} {
+		_, _, done, err := groups.join(ctx, cfg.Databases[name])
+		if err != nil {
+			t.Fatalf("join(%s) error = %v", name, err)
+		}
+		done()
+	}
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	if released != 2 {
+		t.Errorf("released %d sessions, want the second one released once the members left dumped", released)
+	}
+	mu.Unlock()
+	groups.Close()
+
+	// A session that can't be opened fails the dumps of the group
+	cfg.Databases["orders"] = config.Database{Type: "mysql", Host: "down", Database: "orders", ConsistencyGroup: "shop"}
+	cfg.Databases["redacted"] = config.Database{Type: "mysql", Host: "down", Database: "other", ConsistencyGroup: "shop"}
+	groups = NewConsistencyGroups(cfg, []string{"orders", "redacted"})
+	defer groups.Close()
+	if _, _, _, err := groups.join(context.Background(), cfg.Databases["orders"]); err == nil || !strings.Contains(err.Error(), `consistency group "shop": connection refused`) {
+		t.Errorf("join() error = %v, want the session failure", err)
+	}
+
+	if NewConsistencyGroups(cfg, []string{"orders", "plain"}) != nil {
+		t.Error("NewConsistencyGroups() without a group of two databases should be nil")
+	}
+}
+
+func TestTransactionWatch(t *testing.T) {
+	var started int
+	w := &transactionWatch{started: func() { started++ }}
+	write := func(s string) {
+		t.Helper()
+		if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("Write() = %d, %v", n, err)
+		}
+	}
+
+	write("-- Connecting to db...\n-- Starting trans")
+	write("action...\nmysqldump: [Warning] Using a password on the command line\n")
+	if started != 0 {
+		t.Fatal("started before the transaction was open")
+	}
+	// The next progress message comes once the transaction has started
+	write("-- Setting savepoint...\n-- Retrieving table structure for table users...\n")
+	write("-- Disconnecting from db...\nmysqldump: Got error: 2013")
+	if started != 1 {
+		t.Errorf("started called %d times, want once", started)
+	}
+	if got, want := w.String(), "mysqldump: [Warning] Using a password on the command line\nmysqldump: Got error: 2013"; got != want {
+		t.Errorf("stderr = %q, want %q without the progress messages", got, want)
+	}
+}
+
+func TestCommandLog(t *testing.T) {
+	db := config.Database{Type: "postgres", Host: "db", Port: 5432, User: "backup", Password: "s3cret pass", Database: "app"}
+	cmd := exec.Command("pg_dump", postgresDumpArgs(db, "")...)
+	cmd.Env = append(os.Environ(), "PGCONNECT_TIMEOUT=5", "PGPASSWORD="+db.Password)
+
+	var buf bytes.Buffer
+	SetCommandLog(&buf)
+	defer SetCommandLog(nil)
+	logCommand(cmd)
+
+	line := buf.String()
+	if strings.Contains(line, "s3cret") {
+		t.Fatalf("command log shows the password: %s", line)
+	}
+	want := "$ PGCONNECT_TIMEOUT=5 PGPASSWORD=**** pg_dump -h db -p 5432 -U backup --clean --if-exists --no-owner --no-privileges app\n"
+	if !strings.HasSuffix(line, want) {
+		t.Errorf("command log = %q, want it to end with %q", line, want)
+	}
+	if strings.Contains(line, "HOME=") || strings.Contains(line, "PATH=") {
+		t.Errorf("command log shows inherited variables: %s", line)
+	}
+
+	mysqlCmd := exec.Command("mysql", "-e", "SELECT 1")
+	mysqlCmd.Env = []string{"MYSQL_PWD=hunter2", "BLOBBER_DB_PASSWORD=hunter2"}
+	if got := commandLine(mysqlCmd, nil); got != `MYSQL_PWD=**** BLOBBER_DB_PASSWORD=**** mysql -e "SELECT 1"` {
+		t.Errorf("commandLine() = %s", got)
+	}
+
+	SetCommandLog(nil)
+	buf.Reset()
+	logCommand(cmd)
+	if buf.Len() > 0 {
+		t.Errorf("commands logged without a command log: %s", buf.String())
+	}
+
+	SetDiagnosticsLog(&buf)
+	defer SetDiagnosticsLog(nil)
+	logStderr(cmd, "")
+	if buf.Len() > 0 {
+		t.Errorf("empty stderr logged: %s", buf.String())
+	}
+	logStderr(cmd, "pg_dump: warning: first\npg_dump: error: second\n")
+	if got := buf.String(); !strings.Contains(got, "stderr of PGCONNECT_TIMEOUT=5 PGPASSWORD=**** pg_dump") || !strings.HasSuffix(got, ":\npg_dump: warning: first\npg_dump: error: second\n") {
+		t.Errorf("diagnostics log = %q, want the command and its whole stderr", got)
+	}
+}
+
+func TestTempSpace(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+
+	plain := config.Database{Type: "file", Compression: "none"}
+	compressed := config.Database{Type: "file", Compression: "zstd"}
+	encrypted := config.Database{Type: "file", Compression: "zstd", Encryption: &config.Encryption{Type: "gpg"}}
+	tests := []struct {
+		name     string
+		db       config.Database
+		lastSize int64
+		estimate int64
+		want     int64
+	}{
+		{"previous backup", compressed, 100, 1000, 100},
+		{"uncompressed estimate", plain, 0, 1000, 1000},
+		{"compressed without a previous backup", compressed, 0, 1000, 0},
+		{"encrypted", encrypted, 100, 0, 200},
+	}
+	for _, tt := range tests {
+		if got := TempSpaceNeeded(tt.db, tt.lastSize, tt.estimate); got != tt.want {
+			t.Errorf("%s: TempSpaceNeeded() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	free, err := FreeSpace(tmpDir)
+	if err != nil || free <= 0 {
+		t.Fatalf("FreeSpace() = %d, %v; want the free space", free, err)
+	}
+	if err := CheckTempSpace(1); err != nil {
+		t.Errorf("CheckTempSpace(1) error = %v", err)
+	}
+	if err := CheckTempSpace(0); err != nil {
+		t.Errorf("CheckTempSpace() of an unknown size error = %v", err)
+	}
+	err = CheckTempSpace(free + 1<<40)
+	if err == nil || !strings.HasPrefix(err.Error(), "insufficient temp space: need ~") || !strings.Contains(err.Error(), tmpDir) {
+		t.Errorf("CheckTempSpace() past the free space error = %v, want insufficient temp space in %s", err, tmpDir)
+	}
+}
@@ -0,0 +1,98 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// commandLog receives the dump and restore commands before they run (--verbose). nil
+// logs nothing.
+var (
+	commandLog   io.Writer
+	commandLogMu sync.Mutex
+)
+
+// diagnosticsLog receives the full stderr of dump and restore commands (TUI). nil logs
+// nothing.
+var diagnosticsLog io.Writer
+
+// SetDiagnosticsLog sets where the full stderr of dump and restore commands is logged
+// once they exit, nil to log nothing
+func SetDiagnosticsLog(w io.Writer) {
+	commandLogMu.Lock()
+	defer commandLogMu.Unlock()
+	diagnosticsLog = w
+}
+
+// SetCommandLog sets where dump and restore commands are logged before they run, nil
+// to log nothing
+func SetCommandLog(w io.Writer) {
+	commandLogMu.Lock()
+	defer commandLogMu.Unlock()
+	commandLog = w
+}
+
+// logCommand logs the command line of cmd with the environment variables blobber set
+// for it. Passwords are masked: see commandLine.
+func logCommand(cmd *exec.Cmd) {
+	commandLogMu.Lock()
+	defer commandLogMu.Unlock()
+	if commandLog == nil {
+		return
+	}
+	fmt.Fprintf(commandLog, "%s $ %s\n", time.Now().Format("2006-01-02 15:04:05"), commandLine(cmd, os.Environ()))
+}
+
+// logStderr logs what cmd wrote to stderr, if anything, to the diagnostics log
+func logStderr(cmd *exec.Cmd, stderr string) {
+	commandLogMu.Lock()
+	defer commandLogMu.Unlock()
+	if diagnosticsLog == nil || strings.TrimSpace(stderr) == "" {
+		return
+	}
+	fmt.Fprintf(diagnosticsLog, "%s stderr of %s:\n%s\n", time.Now().Format("2006-01-02 15:04:05"), commandLine(cmd, os.Environ()), strings.TrimRight(stderr, "\n"))
+}
+
+// commandLine formats cmd as a shell command line, prefixed with the variables of its
+// environment that are not in inherited. The values of variables holding a password
+// (MYSQL_PWD, PGPASSWORD, BLOBBER_DB_PASSWORD, ...) are masked; passwords are never
+// passed as arguments.
+func commandLine(cmd *exec.Cmd, inherited []string) string {
+	var parts []string
+	for _, kv := range cmd.Env {
+		if slices.Contains(inherited, kv) {
+			continue
+		}
+		key, _, _ := strings.Cut(kv, "=")
+		if secretEnv(key) {
+			parts = append(parts, key+"=****")
+			continue
+		}
+		parts = append(parts, shellQuote(kv))
+	}
+	for _, arg := range cmd.Args {
+		parts = append(parts, shellQuote(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// secretEnv reports whether the environment variable key holds a password
+func secretEnv(key string) bool {
+	key = strings.ToUpper(key)
+	return strings.Contains(key, "PASSWORD") || strings.Contains(key, "PWD") || strings.Contains(key, "SECRET")
+}
+
+// shellQuote quotes s when it would not read as a single shell word
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\"'\\$`*?;&|<>()") {
+		return s
+	}
+	return strconv.Quote(s)
+}
@@ -0,0 +1,304 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Yoone/blobber/internal/config"
+)
+
+// groupLockWait bounds how long a MySQL consistency group waits for FLUSH TABLES WITH
+// READ LOCK, which queues behind running queries (and blocks writes while it waits)
+const groupLockWait = 60 * time.Second
+
+// groupMarker prefixes the line a group session prints once it is consistent
+const groupMarker = "blobber-consistent:"
+
+// ConsistencyGroups holds the sessions that keep the databases of each consistency group
+// of a run dumped at the same point: a global read lock for MySQL, an exported snapshot
+// for Postgres. A group's session is opened by the first of its databases to dump and
+// released once all of them are done with it, or when the run ends (Close). A MySQL
+// member is done once its transaction is open, a Postgres one once it has dumped.
+type ConsistencyGroups struct {
+	mu     sync.Mutex
+	groups map[string]*groupSession
+}
+
+// groupSession is the session of one consistency group
+type groupSession struct {
+	db      config.Database // the member whose connection settings open the session
+	pending int             // members not yet done with the session
+	opened  bool
+	done    chan struct{} // closed once the session is open (or failed to)
+
+	snapshot string // postgres: snapshot the dumps import
+	err      error
+	release  func()
+}
+
+// groupOpener opens a group session for db, returning its snapshot (postgres) and a
+// function ending it (a variable so tests can fake the database)
+var groupOpener = openGroupSession
+
+// NewConsistencyGroups prepares the consistency groups of the databases of a run. Groups
+// with a single database of the run need no session and are left out. Returns nil when
+// there is no group to hold.
+func NewConsistencyGroups(cfg *config.Config, databases []string) *ConsistencyGroups {
+	members := make(map[string][]string)
+	for _, name := range databases {
+		if group := cfg.Databases[name].ConsistencyGroup; group != "" {
+			members[group] = append(members[group], name)
+		}
+	}
+
+	g := &ConsistencyGroups{groups: make(map[string]*groupSession)}
+	for group, names := range members {
+		if len(names) < 2 {
+			continue
+		}
+		sort.Strings(names)
+		g.groups[group] = &groupSession{db: cfg.Databases[names[0]], pending: len(names), done: make(chan struct{})}
+	}
+	if len(g.groups) == 0 {
+		return nil
+	}
+	return g
+}
+
+type consistencyGroupsKey struct{}
+
+// WithConsistencyGroups returns ctx carrying the consistency groups that Run dumps with
+func WithConsistencyGroups(ctx context.Context, g *ConsistencyGroups) context.Context {
+	if g == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, consistencyGroupsKey{}, g)
+}
+
+func consistencyGroupsFrom(ctx context.Context) *ConsistencyGroups {
+	g, _ := ctx.Value(consistencyGroupsKey{}).(*ConsistencyGroups)
+	return g
+}
+
+// join waits for the session of db's consistency group, opening it if db is the first
+// member to dump. It returns whether db dumps in a group session, the snapshot to dump
+// at (postgres) and a function to call once the dump no longer needs the session, which
+// may be called again when the dump is done.
+func (g *ConsistencyGroups) join(ctx context.Context, db config.Database) (bool, string, func(), error) {
+	if g == nil {
+		return false, "", func() {}, nil
+	}
+	g.mu.Lock()
+	s := g.groups[db.ConsistencyGroup]
+	if s == nil {
+		g.mu.Unlock()
+		return false, "", func() {}, nil
+	}
+	if !s.opened {
+		s.opened = true
+		// The session outlives the member opening it, which may be cancelled on its own
+		go func() {
+			s.snapshot, s.release, s.err = groupOpener(context.WithoutCancel(ctx), s.db)
+			close(s.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		g.finish(db.ConsistencyGroup)
+		return false, "", nil, ctx.Err()
+	}
+	if s.err != nil {
+		g.finish(db.ConsistencyGroup)
+		return false, "", nil, fmt.Errorf("consistency group %q: %w", db.ConsistencyGroup, s.err)
+	}
+	var once sync.Once
+	return true, s.snapshot, func() { once.Do(func() { g.finish(db.ConsistencyGroup) }) }, nil
+}
+
+// SkipConsistencyGroup records that db won't dump in the session of its consistency
+// group (it failed or was skipped before dumping), so the session, which may hold a
+// read lock on the server, isn't kept open waiting for it until the run ends. Run does
+// this itself for its own failures.
+func SkipConsistencyGroup(ctx context.Context, db config.Database) {
+	if g := consistencyGroupsFrom(ctx); g != nil && db.ConsistencyGroup != "" {
+		g.finish(db.ConsistencyGroup)
+	}
+}
+
+// finish records that a member of the group is done with its session, ending the
+// session after the last one
+func (g *ConsistencyGroups) finish(group string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := g.groups[group]
+	if s == nil {
+		return
+	}
+	if s.pending--; s.pending > 0 {
+		return
+	}
+	delete(g.groups, group)
+	if s.opened {
+		go s.end()
+	}
+}
+
+// end waits for the session to open and releases it
+func (s *groupSession) end() {
+	<-s.done
+	if s.release != nil {
+		s.release()
+	}
+}
+
+// Close releases the sessions still held, those of groups whose members did not all
+// dump (failed earlier, cancelled or skipped). Safe to call on a nil ConsistencyGroups.
+func (g *ConsistencyGroups) Close() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	groups := g.groups
+	g.groups = make(map[string]*groupSession)
+	g.mu.Unlock()
+
+	for _, s := range groups {
+		if s.opened {
+			s.end()
+		}
+	}
+}
+
+// transactionWatch is the stderr of a grouped mysqldump run with --verbose. It calls
+// started once the dump's transaction is open, that is at the first progress message
+// after "-- Starting transaction...", which mysqldump prints before starting it. The
+// progress messages are dropped; the rest, warnings and errors, is kept for
+// runDumpCommand.
+type transactionWatch struct {
+	started  func()
+	starting bool
+	line     []byte // incomplete last line
+	stderr   bytes.Buffer
+}
+
+func (w *transactionWatch) Write(p []byte) (int, error) {
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := w.line[:i+1]
+		w.line = w.line[i+1:]
+		if !bytes.HasPrefix(line, []byte("-- ")) {
+			w.stderr.Write(line)
+			continue
+		}
+		if w.starting && w.started != nil {
+			w.started()
+			w.started = nil
+		}
+		w.starting = w.starting || bytes.HasPrefix(line, []byte("-- Starting transaction"))
+	}
+}
+
+func (w *transactionWatch) String() string {
+	return w.stderr.String() + string(w.line)
+}
+
+// openGroupSession connects to the server of db with its client and makes it consistent:
+// MySQL takes FLUSH TABLES WITH READ LOCK, which holds off writes on the whole server
+// until released; Postgres opens a repeatable read transaction and exports its snapshot.
+// The session stays open, reading statements from stdin, until the returned function
+// ends it.
+func openGroupSession(ctx context.Context, db config.Database) (string, func(), error) {
+	db = db.DumpSource() // the session must be on the server the dumps read from
+	var statements string
+	switch db.Type {
+	case "mysql":
+		statements = fmt.Sprintf("SET SESSION lock_wait_timeout = %d;\nFLUSH TABLES WITH READ LOCK;\nSELECT '%s';\n", int(groupLockWait.Seconds()), groupMarker)
+	case "postgres":
+		statements = fmt.Sprintf("BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY;\nSELECT '%s' || pg_export_snapshot();\n", groupMarker)
+	default:
+		return "", nil, fmt.Errorf("consistency groups need mysql or postgres databases")
+	}
+
+	// Without a query the client reads the statements from stdin, keeping the session
+	cmd := clientQueryCommand(ctx, db, "")
+	if db.Type == "mysql" {
+		// -n: unbuffered, so the marker is printed as soon as the lock is held
+		cmd.Args = slices.Insert(cmd.Args, 1, "-n")
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return "", nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", nil, err
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	logCommand(cmd)
+	if err := cmd.Start(); err != nil {
+		return "", nil, fmt.Errorf("starting %s: %w", cmd.Args[0], err)
+	}
+
+	release := func() {
+		// Ending the session releases the lock or snapshot; the statement is a courtesy
+		if db.Type == "mysql" {
+			io.WriteString(stdin, "UNLOCK TABLES;\n")
+		} else {
+			io.WriteString(stdin, "COMMIT;\n")
+		}
+		stdin.Close()
+		cmd.Wait()
+	}
+
+	if _, err := io.WriteString(stdin, statements); err != nil {
+		release()
+		return "", nil, fmt.Errorf("starting session: %w", err)
+	}
+
+	// The marker line says the session is consistent; it carries the snapshot for postgres
+	lines := make(chan string, 1)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if rest, ok := strings.CutPrefix(scanner.Text(), groupMarker); ok {
+				lines <- rest
+				break
+			}
+		}
+		io.Copy(io.Discard, stdout)
+	}()
+
+	select {
+	case snapshot, ok := <-lines:
+		if !ok {
+			release()
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return "", nil, fmt.Errorf("session failed: %s", msg)
+			}
+			return "", nil, fmt.Errorf("session ended before it was consistent")
+		}
+		return snapshot, release, nil
+	case <-time.After(connectTimeout(db) + groupLockWait):
+		cmd.Process.Kill()
+		release()
+		return "", nil, fmt.Errorf("timed out waiting for the session to be consistent")
+	}
+}
@@ -0,0 +1,140 @@
+package backup
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// dictionaryMaxHistory caps the content a trained dictionary holds. zstd
+	// dictionaries are most effective around 100 KiB.
+	dictionaryMaxHistory = 110 << 10
+	// DictionarySampleBytes is how much of each sample backup is used for training
+	DictionarySampleBytes = 1 << 20
+	// dictionaryMinID keeps generated IDs out of the range reserved by the zstd format
+	dictionaryMinID = 32768
+)
+
+// DictionaryPath returns the path of the dictionary with the given ID in dir
+func DictionaryPath(dir string, id uint32) string {
+	return filepath.Join(dir, fmt.Sprintf("%d.dict", id))
+}
+
+// loadDictionary reads the dictionary with the given ID from the dictionary directory dir
+func loadDictionary(dir string, id uint32) ([]byte, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("zstd dictionary %d: no dictionary directory configured", id)
+	}
+	dict, err := os.ReadFile(DictionaryPath(dir, id))
+	if err != nil {
+		return nil, fmt.Errorf("reading zstd dictionary %d: %w", id, err)
+	}
+	return dict, nil
+}
+
+// CheckDictionary reports whether the dictionary with the given ID can be read from the
+// dictionary directory of s
+func (s Settings) CheckDictionary(id uint32) error {
+	_, err := loadDictionary(s.DictionaryDir, id)
+	return err
+}
+
+// dictionaryMagic starts dictionaries in the zstd dictionary format (e.g. from
+// "zstd --train"). Anything else is used as raw content.
+const dictionaryMagic = 0xEC30A437
+
+// isFormattedDictionary reports whether dict is in the zstd dictionary format
+func isFormattedDictionary(dict []byte) bool {
+	return len(dict) >= 4 && binary.LittleEndian.Uint32(dict) == dictionaryMagic
+}
+
+// newZstdWriter returns a zstd encoder, compressing with the dictionary dictID of dictDir
+// when set
+func newZstdWriter(dst io.Writer, dictDir string, dictID uint32) (*zstd.Encoder, error) {
+	var opts []zstd.EOption
+	if dictID != 0 {
+		dict, err := loadDictionary(dictDir, dictID)
+		if err != nil {
+			return nil, err
+		}
+		if isFormattedDictionary(dict) {
+			opts = append(opts, zstd.WithEncoderDict(dict))
+		} else {
+			opts = append(opts, zstd.WithEncoderDictRaw(dictID, dict))
+		}
+	}
+	return zstd.NewWriter(dst, opts...)
+}
+
+// newZstdReader returns a zstd decoder for r. A frame compressed with a dictionary
+// records the dictionary's ID in its header, which selects the dictionary to load from
+// dictDir.
+func newZstdReader(r io.Reader, dictDir string) (*zstd.Decoder, error) {
+	br := bufio.NewReader(r)
+	peeked, _ := br.Peek(zstd.HeaderMaxSize)
+
+	var opts []zstd.DOption
+	var header zstd.Header
+	if err := header.Decode(peeked); err == nil && header.DictionaryID != 0 {
+		dict, err := loadDictionary(dictDir, header.DictionaryID)
+		if err != nil {
+			return nil, err
+		}
+		if isFormattedDictionary(dict) {
+			opts = append(opts, zstd.WithDecoderDicts(dict))
+		} else {
+			opts = append(opts, zstd.WithDecoderDictRaw(header.DictionaryID, dict))
+		}
+	}
+	return zstd.NewReader(br, opts...)
+}
+
+// NewDictionaryID returns a random ID for a new dictionary
+func NewDictionaryID() (uint32, error) {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, fmt.Errorf("generating dictionary ID: %w", err)
+	}
+	return dictionaryMinID + binary.BigEndian.Uint32(b[:])%(1<<31-dictionaryMinID), nil
+}
+
+// TrainDictionary builds a raw content dictionary from uncompressed sample backups.
+// The content is taken from the start of each sample, where similar databases share
+// most of their schema.
+func TrainDictionary(samples [][]byte) ([]byte, error) {
+	var contents [][]byte
+	for _, sample := range samples {
+		if len(sample) > 0 {
+			contents = append(contents, sample)
+		}
+	}
+	if len(contents) == 0 {
+		return nil, fmt.Errorf("no sample content to train a dictionary from")
+	}
+
+	perSample := dictionaryMaxHistory / len(contents)
+	var dict []byte
+	for _, sample := range contents {
+		dict = append(dict, sample[:min(len(sample), perSample)]...)
+	}
+	return dict, nil
+}
+
+// SaveDictionary writes a dictionary into dir under its ID and returns its path
+func SaveDictionary(dir string, id uint32, dict []byte) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("creating dictionary directory: %w", err)
+	}
+	path := DictionaryPath(dir, id)
+	if err := os.WriteFile(path, dict, 0644); err != nil {
+		return "", fmt.Errorf("writing dictionary: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,209 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Yoone/blobber/internal/config"
+)
+
+// EncryptedExt ends the name of backups encrypted with gpg, after the compression
+// extension (e.g. mydb_20240115_143022.sql.gz.gpg)
+const EncryptedExt = ".gpg"
+
+// Encrypted reports whether the backup file was encrypted with gpg
+func Encrypted(filename string) bool {
+	return strings.HasSuffix(filename, EncryptedExt)
+}
+
+// gpgTool is the binary encrypting and decrypting backups
+var gpgTool = Tool{Binary: "gpg", Label: "gpg", Purpose: "encryption"}
+
+// encryptFile encrypts the file at path to the recipients with gpg and returns the path
+// of the encrypted file. The plaintext is removed as soon as gpg is done with it, whether
+// it succeeded or not. Recipients are trusted as given: which keys are in the keyring is
+// up to the user.
+func encryptFile(ctx context.Context, enc *config.Encryption, path string) (string, error) {
+	outPath := path + EncryptedExt
+	args := []string{"--batch", "--yes", "--trust-model", "always", "--output", outPath, "--encrypt"}
+	for _, r := range enc.Recipients {
+		args = append(args, "--recipient", r)
+	}
+	args = append(args, path)
+
+	if err := runGPG(ctx, args); err != nil {
+		os.Remove(outPath)
+		os.Remove(path)
+		return "", fmt.Errorf("encrypting backup: %w", err)
+	}
+	if err := os.Remove(path); err != nil {
+		os.Remove(outPath)
+		return "", fmt.Errorf("removing unencrypted dump: %w", err)
+	}
+	return outPath, nil
+}
+
+// decryptFile decrypts a .gpg backup into a temporary directory, under its name without
+// the .gpg, so it can be decompressed like any other backup. Returns the path to read
+// and a cleanup function removing the decrypted copy. Other backups are returned as is.
+func decryptFile(path string) (string, func(), error) {
+	if !Encrypted(path) {
+		return path, func() {}, nil
+	}
+
+	tmpDir, err := os.MkdirTemp("", "blobber-decrypt-")
+	if err != nil {
+		return "", nil, fmt.Errorf("creating temp dir: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
+
+	outPath := filepath.Join(tmpDir, strings.TrimSuffix(filepath.Base(path), EncryptedExt))
+	if err := runGPG(context.Background(), []string{"--batch", "--yes", "--output", outPath, "--decrypt", path}); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("decrypting backup: %w", err)
+	}
+	return outPath, cleanup, nil
+}
+
+// runGPG runs gpg with args, reporting its stderr when it fails
+func runGPG(ctx context.Context, args []string) error {
+	cmd := exec.CommandContext(ctx, gpgTool.Binary, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return gpgError(ctx, err, stderr.String())
+	}
+	return nil
+}
+
+// gpgError describes a failed gpg run from its stderr
+func gpgError(ctx context.Context, err error, stderr string) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if stderr = strings.TrimSpace(stderr); stderr != "" {
+		return fmt.Errorf("gpg failed: %s", stderr)
+	}
+	return fmt.Errorf("gpg failed: %w", err)
+}
+
+// Reencrypt decrypts the .gpg backup at src and encrypts it again to recipients as dst,
+// e.g. to move backups off a compromised key. The plaintext is piped between the two gpg
+// runs and never written to disk. Without from, gpg picks the matching key from the
+// keyring. With from, gpg tries that secret key first, but it is only a hint: gpg still
+// falls back to any other key of the keyring the backup was encrypted to. Which key
+// decrypted is read from gpg's status output, and the copy is discarded with an error
+// unless it is from.
+func Reencrypt(ctx context.Context, src, dst, from string, recipients []string) error {
+	if !Encrypted(src) {
+		return fmt.Errorf("%s is not encrypted", filepath.Base(src))
+	}
+	if missing := missingTools([]Tool{gpgTool}); len(missing) > 0 {
+		return fmt.Errorf("%s", missing[0])
+	}
+	var fromKeys map[string]bool
+	if from != "" {
+		var err error
+		if fromKeys, err = secretKeyFingerprints(ctx, from); err != nil {
+			return err
+		}
+	}
+
+	decryptArgs := []string{"--batch", "--yes", "--status-fd", "2"}
+	if from != "" {
+		decryptArgs = append(decryptArgs, "--try-secret-key", from)
+	}
+	decryptArgs = append(decryptArgs, "--decrypt", src)
+	encryptArgs := []string{"--batch", "--yes", "--trust-model", "always", "--output", dst, "--encrypt"}
+	for _, r := range recipients {
+		encryptArgs = append(encryptArgs, "--recipient", r)
+	}
+
+	decrypt := exec.CommandContext(ctx, gpgTool.Binary, decryptArgs...)
+	encrypt := exec.CommandContext(ctx, gpgTool.Binary, encryptArgs...)
+	var decryptErr, encryptErr bytes.Buffer
+	decrypt.Stderr, encrypt.Stderr = &decryptErr, &encryptErr
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("re-encrypting backup: %w", err)
+	}
+	decrypt.Stdout, encrypt.Stdin = w, r
+
+	// Only the two gpg runs hold the pipe once started, so either one exiting ends the other
+	err = encrypt.Start()
+	if err == nil {
+		if err = decrypt.Start(); err != nil {
+			w.Close()
+			encrypt.Wait()
+		}
+	}
+	r.Close()
+	w.Close()
+	if err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("re-encrypting backup: %w", err)
+	}
+
+	decryptRunErr := decrypt.Wait()
+	encryptRunErr := encrypt.Wait()
+	// gpg encrypts whatever it was given before decryption failed, so a decryption error
+	// always fails the run; a failed encryption also breaks the pipe of the decryption
+	if encryptRunErr != nil {
+		os.Remove(dst)
+		return fmt.Errorf("encrypting backup: %w", gpgError(ctx, encryptRunErr, encryptErr.String()))
+	}
+	usedKeys, stderr := splitGPGStatus(decryptErr.String())
+	if decryptRunErr != nil {
+		os.Remove(dst)
+		return fmt.Errorf("decrypting backup: %w", gpgError(ctx, decryptRunErr, stderr))
+	}
+	if from != "" && !slices.ContainsFunc(usedKeys, func(fpr string) bool { return fromKeys[fpr] }) {
+		os.Remove(dst)
+		if len(usedKeys) == 0 {
+			return fmt.Errorf("decrypting backup: gpg did not report the key it decrypted with, so it can't be checked against %s", from)
+		}
+		return fmt.Errorf("decrypting backup: gpg decrypted it with key %s, not %s", usedKeys[0], from)
+	}
+	return nil
+}
+
+// secretKeyFingerprints returns the fingerprints of the secret keys matching key (an ID,
+// fingerprint or email) and of their subkeys
+func secretKeyFingerprints(ctx context.Context, key string) (map[string]bool, error) {
+	out, err := exec.CommandContext(ctx, gpgTool.Binary, "--batch", "--with-colons", "--list-secret-keys", "--", key).Output()
+	fingerprints := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		// fpr records hold the fingerprint in their tenth field
+		if fields := strings.Split(line, ":"); fields[0] == "fpr" && len(fields) > 9 {
+			fingerprints[fields[9]] = true
+		}
+	}
+	if err != nil || len(fingerprints) == 0 {
+		return nil, fmt.Errorf("no secret key matching %s in the keyring", key)
+	}
+	return fingerprints, nil
+}
+
+// splitGPGStatus separates gpg's status lines (--status-fd 2) from the rest of its
+// stderr. It returns the fingerprints of the key that decrypted, from DECRYPTION_KEY:
+// the subkey's then its primary key's.
+func splitGPGStatus(stderr string) (decryptionKey []string, rest string) {
+	var kept []string
+	for _, line := range strings.Split(stderr, "\n") {
+		status, ok := strings.CutPrefix(line, "[GNUPG:] ")
+		if !ok {
+			kept = append(kept, line)
+			continue
+		}
+		if fields := strings.Fields(status); len(fields) >= 3 && fields[0] == "DECRYPTION_KEY" {
+			decryptionKey = fields[1:3]
+		}
+	}
+	return decryptionKey, strings.Join(kept, "\n")
+}
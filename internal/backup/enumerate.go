@@ -0,0 +1,96 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Yoone/blobber/internal/config"
+)
+
+// systemDatabases are server-internal databases never included by database "*"
+var systemDatabases = map[string]map[string]bool{
+	"mysql": {
+		"information_schema": true,
+		"performance_schema": true,
+		"mysql":              true,
+		"sys":                true,
+	},
+	"postgres": {
+		"postgres": true,
+	},
+}
+
+// maintenanceDatabase is the database the client connects to when an entry targets
+// every database on the server
+func maintenanceDatabase(dbType string) string {
+	if dbType == "postgres" {
+		return "postgres"
+	}
+	return "information_schema"
+}
+
+// ListDatabases returns the databases a database "*" entry backs up: every database on
+// the server except system databases and those matching exclude_databases, sorted.
+func ListDatabases(parent context.Context, db config.Database, s Settings) ([]string, error) {
+	db = s.database(db)
+	ctx, cancel := context.WithTimeout(parent, connectTimeout(db))
+	defer cancel()
+
+	var query string
+	switch db.Type {
+	case "mysql":
+		query = "SHOW DATABASES"
+	case "postgres":
+		query = "SELECT datname FROM pg_database WHERE NOT datistemplate AND datallowconn"
+	default:
+		return nil, fmt.Errorf("listing databases is not supported for %s", db.Type)
+	}
+	db.Database = config.AllDatabasesWildcard
+	cmd := clientQueryCommand(ctx, db.DumpSource(), query)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if parent.Err() != nil {
+			return nil, parent.Err()
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("listing databases timed out after %s", connectTimeout(db))
+		}
+		if stderr.Len() > 0 {
+			return nil, fmt.Errorf("listing databases: %s", strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("listing databases: %w", err)
+	}
+
+	return filterDatabaseNames(strings.Split(string(out), "\n"), db.Type, db.ExcludeDatabases), nil
+}
+
+// filterDatabaseNames drops blank lines, system databases and names matching any of the
+// exclude patterns, and returns the rest sorted
+func filterDatabaseNames(names []string, dbType string, exclude []string) []string {
+	var result []string
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || systemDatabases[dbType][name] || matchesAny(name, exclude) {
+			continue
+		}
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,472 @@
+package backup
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Yoone/blobber/internal/config"
+)
+
+// redactHashLength is how many hex digits of the SHA-256 replace a hashed value
+const redactHashLength = 16
+
+// redactor rewrites the values of the columns named by redact rules in a plain-text SQL
+// dump as it streams through. It handles the INSERT statements mysqldump writes (one
+// per line) and the COPY blocks pg_dump writes; anything else is left unchanged. This
+// is simple value replacement, not anonymization. Data of a table with rules that it
+// can't parse fails the dump rather than being written unredacted.
+type redactor struct {
+	rules    []config.RedactRule
+	matched  []bool              // rules that matched a column in the dump
+	columns  map[string][]string // columns of tables with rules, from CREATE TABLE
+	warnings []string
+
+	createTable string               // table whose CREATE TABLE is being read
+	inCopy      bool                 // reading the data rows of a COPY block
+	copyRules   []*config.RedactRule // rule for each column of the COPY block (nil when none apply)
+}
+
+// redactDump copies the dump in src to dst, applying the redact rules. Returns the
+// number of bytes written and warnings about rules that matched no column.
+func redactDump(dst io.Writer, src io.Reader, rules []config.RedactRule) (int64, []string, error) {
+	r := &redactor{
+		rules:   rules,
+		matched: make([]bool, len(rules)),
+		columns: make(map[string][]string),
+	}
+
+	br := bufio.NewReader(src)
+	var n int64
+	for {
+		line, readErr := br.ReadString('\n')
+		if line != "" {
+			redacted, err := r.line(line)
+			if err != nil {
+				return n, nil, err
+			}
+			written, err := io.WriteString(dst, redacted)
+			n += int64(written)
+			if err != nil {
+				return n, nil, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return n, nil, readErr
+		}
+	}
+
+	for i, rule := range rules {
+		if !r.matched[i] {
+			r.warn(fmt.Sprintf("redact: no column %s.%s found in the dump", rule.Table, rule.Column))
+		}
+	}
+	return n, r.warnings, nil
+}
+
+// warn records a warning once
+func (r *redactor) warn(warning string) {
+	if !slices.Contains(r.warnings, warning) {
+		r.warnings = append(r.warnings, warning)
+	}
+}
+
+// line returns a line of the dump (including its line ending) with the redact rules applied
+func (r *redactor) line(line string) (string, error) {
+	text := strings.TrimRight(line, "\r\n")
+	switch {
+	case r.inCopy:
+		if text == `\.` {
+			r.inCopy = false
+			return line, nil
+		}
+		if r.copyRules == nil {
+			return line, nil
+		}
+		row, err := r.copyRow(text)
+		if err != nil {
+			return "", err
+		}
+		return row + line[len(text):], nil
+	case r.createTable != "":
+		if strings.HasPrefix(text, ")") {
+			r.createTable = ""
+		} else if column, ok := strings.CutPrefix(strings.TrimSpace(text), "`"); ok {
+			if end := strings.IndexByte(column, '`'); end >= 0 {
+				r.columns[r.createTable] = append(r.columns[r.createTable], column[:end])
+			}
+		}
+		return line, nil
+	case strings.HasPrefix(text, "CREATE TABLE "):
+		r.startCreateTable(text)
+	case strings.HasPrefix(text, "COPY ") && strings.HasSuffix(text, " FROM stdin;"):
+		if err := r.startCopy(text); err != nil {
+			return "", err
+		}
+	case strings.HasPrefix(text, "INSERT INTO "):
+		statement, err := r.insert(text)
+		if err != nil {
+			return "", err
+		}
+		return statement + line[len(text):], nil
+	}
+	return line, nil
+}
+
+// startCreateTable starts collecting the columns of a table with rules, which INSERT
+// statements without a column list need
+func (r *redactor) startCreateTable(text string) {
+	if !strings.HasSuffix(text, "(") {
+		return
+	}
+	name := strings.TrimPrefix(text, "CREATE TABLE ")
+	name = strings.TrimPrefix(name, "IF NOT EXISTS ")
+	name = strings.TrimSpace(strings.TrimSuffix(name, "("))
+	table := unquoteIdent(name)
+	if r.hasRules(table) {
+		r.createTable = table
+		r.columns[table] = nil
+	}
+}
+
+// startCopy starts a COPY block ("COPY public.users (id, email) FROM stdin;")
+func (r *redactor) startCopy(text string) error {
+	r.inCopy = true
+	r.copyRules = nil
+
+	header := strings.TrimSuffix(strings.TrimPrefix(text, "COPY "), " FROM stdin;")
+	name, columnList, ok := strings.Cut(header, " (")
+	table := unquoteIdent(name)
+	if !ok {
+		if r.hasRules(table) {
+			return fmt.Errorf("redact: COPY into %s has no column list, so its rows can't be redacted", table)
+		}
+		return nil
+	}
+	r.copyRules = r.columnRules(table, splitColumns(strings.TrimSuffix(columnList, ")")))
+	return nil
+}
+
+// copyRow redacts a tab-separated COPY data row
+func (r *redactor) copyRow(text string) (string, error) {
+	fields := strings.Split(text, "\t")
+	if len(fields) != len(r.copyRules) {
+		return "", fmt.Errorf("redact: COPY row has %d values, expected %d", len(fields), len(r.copyRules))
+	}
+	for i, rule := range r.copyRules {
+		if rule == nil {
+			continue
+		}
+		switch {
+		case fields[i] == `\N`:
+		case rule.Hash:
+			value, err := unescapeCopyValue(fields[i])
+			if err != nil {
+				return "", fmt.Errorf("redact: could not parse a COPY row of %s: %w", rule.Column, err)
+			}
+			fields[i] = hashValue(value)
+		default:
+			fields[i] = `\N`
+		}
+	}
+	return strings.Join(fields, "\t"), nil
+}
+
+// insert redacts an INSERT statement on a single line
+func (r *redactor) insert(text string) (string, error) {
+	head, values, ok := strings.Cut(text, " VALUES ")
+	name, columnList, hasColumns := strings.Cut(strings.TrimPrefix(head, "INSERT INTO "), " (")
+	table := unquoteIdent(name)
+	if !r.hasRules(table) {
+		return text, nil
+	}
+	if !ok {
+		return "", fmt.Errorf("redact: could not parse an INSERT into %s (no VALUES)", table)
+	}
+
+	var columns []string
+	if hasColumns {
+		columns = splitColumns(strings.TrimSuffix(columnList, ")"))
+	} else if columns = r.columns[table]; columns == nil {
+		return "", fmt.Errorf("redact: columns of %s are unknown, so its INSERT statements can't be redacted", table)
+	}
+	rules := r.columnRules(table, columns)
+	if rules == nil {
+		return text, nil
+	}
+
+	redacted, err := redactValues(values, rules)
+	if err != nil {
+		return "", fmt.Errorf("redact: could not parse an INSERT into %s (%w)", table, err)
+	}
+	return text[:len(text)-len(values)] + redacted, nil
+}
+
+// hasRules reports whether any rule applies to table
+func (r *redactor) hasRules(table string) bool {
+	return slices.ContainsFunc(r.rules, func(rule config.RedactRule) bool {
+		return tableMatches(table, rule.Table)
+	})
+}
+
+// columnRules returns the rule for each of the columns of table, or nil when none apply
+func (r *redactor) columnRules(table string, columns []string) []*config.RedactRule {
+	var rules []*config.RedactRule
+	for i, column := range columns {
+		for j := range r.rules {
+			if !tableMatches(table, r.rules[j].Table) || !strings.EqualFold(column, r.rules[j].Column) {
+				continue
+			}
+			if rules == nil {
+				rules = make([]*config.RedactRule, len(columns))
+			}
+			rules[i] = &r.rules[j]
+			r.matched[j] = true
+		}
+	}
+	return rules
+}
+
+// tableMatches reports whether a table in the dump is the rule's table. A rule without
+// a schema matches the table in any schema.
+func tableMatches(table, ruleTable string) bool {
+	if strings.EqualFold(table, ruleTable) {
+		return true
+	}
+	if strings.Contains(ruleTable, ".") {
+		return false
+	}
+	dot := strings.LastIndex(table, ".")
+	return dot >= 0 && strings.EqualFold(table[dot+1:], ruleTable)
+}
+
+// unquoteIdent removes the quoting of a (possibly qualified) identifier
+func unquoteIdent(name string) string {
+	return strings.NewReplacer("`", "", `"`, "").Replace(strings.TrimSpace(name))
+}
+
+// splitColumns splits a comma-separated column list
+func splitColumns(list string) []string {
+	var columns []string
+	for _, column := range strings.Split(list, ",") {
+		columns = append(columns, unquoteIdent(column))
+	}
+	return columns
+}
+
+// redactValues rewrites the tuples of an INSERT statement ("(1,'a'),(2,'b');"),
+// replacing the values of the columns that have a rule
+func redactValues(values string, rules []*config.RedactRule) (string, error) {
+	var out strings.Builder
+	out.Grow(len(values))
+	i := 0
+	for {
+		if i >= len(values) || values[i] != '(' {
+			return "", fmt.Errorf("expected ( at offset %d", i)
+		}
+		out.WriteByte('(')
+		i++
+
+		var count int
+		for {
+			end, err := scanSQLValue(values, i)
+			if err != nil {
+				return "", err
+			}
+			value := values[i:end]
+			if count < len(rules) && rules[count] != nil {
+				if value, err = redactSQLValue(value, rules[count].Hash); err != nil {
+					return "", err
+				}
+			}
+			out.WriteString(value)
+			out.WriteByte(values[end])
+			count++
+			i = end + 1
+			if values[end] == ')' {
+				break
+			}
+		}
+		if count != len(rules) {
+			return "", fmt.Errorf("row has %d values, expected %d", count, len(rules))
+		}
+
+		if i < len(values) && values[i] == ',' {
+			out.WriteByte(',')
+			i++
+			continue
+		}
+		out.WriteString(values[i:])
+		return out.String(), nil
+	}
+}
+
+// scanSQLValue returns the offset of the , or ) ending the value that starts at i.
+// Quoted strings may contain either, with backslash escapes and doubled quotes as
+// mysqldump writes them.
+func scanSQLValue(s string, i int) (int, error) {
+	for i < len(s) {
+		switch s[i] {
+		case ',', ')':
+			return i, nil
+		case '\'':
+			for i++; i < len(s); i++ {
+				if s[i] == '\\' {
+					i++
+					continue
+				}
+				if s[i] == '\'' {
+					if i+1 < len(s) && s[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+		}
+		i++
+	}
+	return 0, fmt.Errorf("unterminated value")
+}
+
+// redactSQLValue returns the replacement for an SQL literal: NULL, or a quoted hash of
+// the original. NULL stays NULL either way.
+func redactSQLValue(value string, hash bool) (string, error) {
+	if !hash || value == "NULL" {
+		return "NULL", nil
+	}
+	decoded, err := decodeSQLValue(value)
+	if err != nil {
+		return "", err
+	}
+	return "'" + hashValue(decoded) + "'", nil
+}
+
+// decodeSQLValue returns the value an SQL literal as mysqldump writes it stands for, so
+// it hashes like the same value in a COPY block: strings are unquoted and unescaped
+// (after any _charset introducer), hex literals decoded, and numbers kept as written.
+func decodeSQLValue(value string) (string, error) {
+	if strings.HasPrefix(value, "_") {
+		if _, literal, ok := strings.Cut(value, " "); ok {
+			value = literal
+		}
+	}
+	switch {
+	case strings.HasPrefix(value, "0x"):
+		return decodeHex(value[2:])
+	case strings.HasPrefix(value, "X'") && strings.HasSuffix(value, "'") && len(value) >= 3:
+		return decodeHex(value[2 : len(value)-1])
+	case strings.HasPrefix(value, "'"):
+		return unescapeMySQLString(value)
+	case value == "" || strings.ContainsAny(value, "'\" \t"):
+		return "", fmt.Errorf("unexpected value %q", value)
+	}
+	return value, nil
+}
+
+// decodeHex decodes the digits of a hex literal
+func decodeHex(digits string) (string, error) {
+	b, err := hex.DecodeString(digits)
+	if err != nil {
+		return "", fmt.Errorf("invalid hex literal: %w", err)
+	}
+	return string(b), nil
+}
+
+// mysqlEscapes maps the character after a backslash in a mysqldump string to the one it
+// stands for. Any other escaped character stands for itself.
+var mysqlEscapes = map[byte]byte{'0': 0, 'b': '\b', 'n': '\n', 'r': '\r', 't': '\t', 'Z': 0x1a}
+
+// unescapeMySQLString returns the content of a quoted mysqldump string, with backslash
+// escapes and doubled quotes resolved
+func unescapeMySQLString(quoted string) (string, error) {
+	if len(quoted) < 2 || quoted[len(quoted)-1] != '\'' {
+		return "", fmt.Errorf("malformed string %s", quoted)
+	}
+	s := quoted[1 : len(quoted)-1]
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s):
+			i++
+			if r, ok := mysqlEscapes[s[i]]; ok {
+				out.WriteByte(r)
+			} else {
+				out.WriteByte(s[i])
+			}
+		case c == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+			out.WriteByte('\'')
+		case c == '\\' || c == '\'':
+			return "", fmt.Errorf("malformed string %s", quoted)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), nil
+}
+
+// copyEscapes maps the character after a backslash in a COPY text field to the one it
+// stands for. Octal (\123) and hex (\x53) escapes are decoded separately; any other
+// escaped character stands for itself.
+var copyEscapes = map[byte]byte{'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v'}
+
+// unescapeCopyValue returns the value a field of a COPY text block stands for
+func unescapeCopyValue(field string) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] != '\\' {
+			out.WriteByte(field[i])
+			continue
+		}
+		i++
+		if i == len(field) {
+			return "", fmt.Errorf("field ends with a backslash")
+		}
+		c := field[i]
+		switch {
+		case isOctal(c):
+			n := 0
+			for j := 0; j < 3 && i < len(field) && isOctal(field[i]); j, i = j+1, i+1 {
+				n = n*8 + int(field[i]-'0')
+			}
+			i--
+			out.WriteByte(byte(n))
+		case c == 'x' && i+1 < len(field) && isHex(field[i+1]):
+			end := i + 2
+			if end < len(field) && isHex(field[end]) {
+				end++
+			}
+			b, _ := strconv.ParseUint(field[i+1:end], 16, 8)
+			out.WriteByte(byte(b))
+			i = end - 1
+		default:
+			if r, ok := copyEscapes[c]; ok {
+				c = r
+			}
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), nil
+}
+
+func isOctal(c byte) bool { return c >= '0' && c <= '7' }
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// hashValue returns the first redactHashLength hex digits of the SHA-256 of value, so
+// equal values stay equal after redaction, whichever format the dump is in
+func hashValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:redactHashLength]
+}
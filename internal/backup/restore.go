@@ -4,104 +4,407 @@ import (
 	"archive/zip"
 	"bytes"
 	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/Yoone/blobber/internal/config"
-	"github.com/klauspost/compress/zstd"
 	"github.com/ulikunitz/xz"
 )
 
-// Restore restores a backup file to the given database
-func Restore(db config.Database, backupPath string) error {
+// Restore restores a backup file to the given database. Encrypted backups are decrypted
+// with gpg first. The target must be missing or present as restore_mode requires.
+func Restore(db config.Database, backupPath string, s Settings) error {
+	db = s.database(db)
+	create, err := checkRestoreTarget(db)
+	if err != nil {
+		return err
+	}
+
+	backupPath, cleanup, err := decryptFile(backupPath)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	return restoreInto(db, create, func() (io.Reader, func(), error) {
+		return newDecompressReader(backupPath, s.DictionaryDir)
+	})
+}
+
+// Streamable reports whether the backup filename can be restored as it is downloaded,
+// without a temp file: zip archives need seeking and encrypted backups go through gpg
+// on disk, so both are downloaded first.
+func Streamable(filename string) bool {
+	return !Encrypted(filename) && !strings.HasSuffix(filename, ".zip")
+}
+
+// RestoreStream restores the backup filename read from r, e.g. as it is downloaded, to
+// the given database, decompressing it on the way. The target must be missing or present
+// as restore_mode requires, as for Restore. A failed read stops the restore the way a
+// failing statement does: mysql and postgres don't commit the truncated dump (unless
+// restore_no_transaction is set) and a file target keeps its previous content.
+func RestoreStream(db config.Database, r io.Reader, filename string, s Settings) error {
+	if !Streamable(filename) {
+		return fmt.Errorf("%s cannot be restored as a stream", filename)
+	}
+	db = s.database(db)
+	create, err := checkRestoreTarget(db)
+	if err != nil {
+		return err
+	}
+
+	return restoreInto(db, create, func() (io.Reader, func(), error) {
+		return DecompressStream(r, filename, s)
+	})
+}
+
+// restoreInto restores the decompressed backup returned by open into db, creating the
+// mysql/postgres database first when create is set
+func restoreInto(db config.Database, create bool, open func() (io.Reader, func(), error)) error {
+	if db.Type != "file" && db.Type != "mysql" && db.Type != "postgres" {
+		return fmt.Errorf("unknown database type: %s", db.Type)
+	}
+	reader, cleanup, err := open()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if create {
+		if err := createDatabase(db); err != nil {
+			return err
+		}
+	}
+
 	switch db.Type {
 	case "file":
-		return restoreFile(db, backupPath)
+		return restoreFile(db, reader)
 	case "mysql":
-		return restoreMySQL(db, backupPath)
+		return restoreMySQL(db, reader)
 	case "postgres":
-		return restorePostgres(db, backupPath)
+		return restorePostgres(db, reader)
 	default:
 		return fmt.Errorf("unknown database type: %s", db.Type)
 	}
 }
 
-func restoreFile(db config.Database, backupPath string) error {
-	reader, cleanup, err := newDecompressReader(backupPath)
+// checkRestoreTarget enforces restore_mode on the target. Only create-only and
+// overwrite-only look the target up; upsert restores into whatever is there without
+// touching the server first. It reports whether the mysql/postgres database must be
+// created, which only create-only asks for.
+func checkRestoreTarget(db config.Database) (create bool, err error) {
+	mode := db.RestoreTargetMode()
+	if mode == config.RestoreUpsert {
+		return false, nil
+	}
+	exists, err := restoreTargetExists(db)
 	if err != nil {
-		return err
+		return false, err
 	}
-	if cleanup != nil {
-		defer cleanup()
+	if err := checkRestoreMode(db, exists); err != nil {
+		return false, err
 	}
+	return mode == config.RestoreCreateOnly && (db.Type == "mysql" || db.Type == "postgres"), nil
+}
+
+// restoreTargetExists reports whether the file or database db restores into exists, and
+// createDatabase creates a missing mysql/postgres database (variables so tests can fake
+// the server)
+var (
+	restoreTargetExists = targetExists
+	createDatabase      = createTargetDatabase
+)
 
-	dst, err := os.Create(db.Path)
+// checkRestoreMode returns an error when restore_mode forbids restoring into a target
+// that exists (create-only) or into one that does not (overwrite-only)
+func checkRestoreMode(db config.Database, exists bool) error {
+	switch db.RestoreTargetMode() {
+	case config.RestoreCreateOnly:
+		if exists {
+			return fmt.Errorf("%s already exists and restore_mode is create-only", restoreTargetLabel(db))
+		}
+	case config.RestoreOverwriteOnly:
+		if !exists {
+			return fmt.Errorf("%s does not exist and restore_mode is overwrite-only", restoreTargetLabel(db))
+		}
+	}
+	return nil
+}
+
+// restoreTargetLabel names the target of a restore into db in errors
+func restoreTargetLabel(db config.Database) string {
+	if db.Type == "file" {
+		return db.Path
+	}
+	return fmt.Sprintf("database %q", db.Database)
+}
+
+// targetExists reports whether the file db restores into exists, or for mysql/postgres
+// whether the server has its database
+func targetExists(db config.Database) (bool, error) {
+	var query string
+	switch db.Type {
+	case "file":
+		_, err := os.Stat(db.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("checking restore target: %w", err)
+		}
+		return true, nil
+	case "mysql":
+		query = "SELECT SCHEMA_NAME FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = " + sqlString(db.Database)
+	case "postgres":
+		query = "SELECT datname FROM pg_database WHERE datname = " + sqlString(db.Database)
+	default:
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout(db))
+	defer cancel()
+	target := db
+	target.Database = config.AllDatabasesWildcard // connect to the maintenance database
+	cmd := clientQueryCommand(ctx, target, query)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if stderr.Len() > 0 {
+			return false, fmt.Errorf("checking restore target: %s", strings.TrimSpace(stderr.String()))
+		}
+		return false, fmt.Errorf("checking restore target: %w", err)
+	}
+	return strings.TrimSpace(string(out)) != "", nil
+}
+
+// createTargetDatabase creates the mysql/postgres database db restores into
+func createTargetDatabase(db config.Database) error {
+	var query string
+	if db.Type == "mysql" {
+		query = "CREATE DATABASE `" + strings.ReplaceAll(db.Database, "`", "``") + "`"
+	} else {
+		query = `CREATE DATABASE "` + strings.ReplaceAll(db.Database, `"`, `""`) + `"`
+	}
+
+	target := db
+	target.Database = config.AllDatabasesWildcard
+	cmd := clientQueryCommand(context.Background(), target, query)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return fmt.Errorf("creating database %q: %s", db.Database, strings.TrimSpace(stderr.String()))
+		}
+		return fmt.Errorf("creating database %q: %w", db.Database, err)
+	}
+	return nil
+}
+
+// sqlString quotes s as an SQL string literal
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// HasPostRestore reports whether any post-restore step is configured for db
+func HasPostRestore(db config.Database) bool {
+	return (db.Type == "postgres" && db.VacuumAnalyze) || db.PostRestoreCommand != ""
+}
+
+// PostRestore runs the steps configured to follow a successful restore: VACUUM ANALYZE
+// (postgres only), then post_restore_command. Returns a summary of what ran.
+func PostRestore(db config.Database, s Settings) (string, error) {
+	db = s.database(db)
+	var ran []string
+
+	if db.Type == "postgres" && db.VacuumAnalyze {
+		cmd := clientQueryCommand(context.Background(), db, "VACUUM ANALYZE")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if stderr.Len() > 0 {
+				return "", fmt.Errorf("VACUUM ANALYZE failed: %s", strings.TrimSpace(stderr.String()))
+			}
+			return "", fmt.Errorf("VACUUM ANALYZE failed: %w", err)
+		}
+		ran = append(ran, "VACUUM ANALYZE")
+	}
+
+	if db.PostRestoreCommand != "" {
+		cmd := exec.Command("sh", "-c", db.PostRestoreCommand)
+		cmd.Env = append(os.Environ(), postRestoreEnv(db)...)
+		var output bytes.Buffer
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		if err := cmd.Run(); err != nil {
+			if output.Len() > 0 {
+				return "", fmt.Errorf("post_restore_command failed: %s", strings.TrimSpace(output.String()))
+			}
+			return "", fmt.Errorf("post_restore_command failed: %w", err)
+		}
+		ran = append(ran, "post_restore_command")
+	}
+
+	return "Ran " + strings.Join(ran, " and "), nil
+}
+
+// postRestoreEnv exposes the restored database to post_restore_command, including the
+// client-specific password variables so mysql/psql can be called without extra setup
+func postRestoreEnv(db config.Database) []string {
+	env := []string{
+		"BLOBBER_DB_TYPE=" + db.Type,
+		"BLOBBER_DB_PATH=" + db.Path,
+		"BLOBBER_DB_HOST=" + db.Host,
+		fmt.Sprintf("BLOBBER_DB_PORT=%d", db.Port),
+		"BLOBBER_DB_USER=" + db.User,
+		"BLOBBER_DB_NAME=" + db.Database,
+	}
+	if db.Password != "" {
+		switch db.Type {
+		case "mysql":
+			env = append(env, "MYSQL_PWD="+db.Password)
+		case "postgres":
+			env = append(env, "PGPASSWORD="+db.Password)
+		}
+	}
+	return env
+}
+
+// restoreFile writes the backup to a temp file next to db.Path and renames it over the
+// target once complete, so a failed read (a download cut short) leaves the target as it
+// was. The file keeps the mode of the one it replaces unless restore_file_perm is set.
+func restoreFile(db config.Database, reader io.Reader) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(db.Path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if perm, ok, err := db.RestoreFilePerm(); err != nil {
+		return err
+	} else if ok {
+		mode = perm
+	}
+
+	dst, err := os.CreateTemp(filepath.Dir(db.Path), "."+filepath.Base(db.Path)+".restore-*")
 	if err != nil {
 		return fmt.Errorf("creating destination file: %w", err)
 	}
-	defer dst.Close()
+	defer os.Remove(dst.Name()) // fails harmlessly once renamed
 
 	if _, err := io.Copy(dst, reader); err != nil {
+		dst.Close()
 		return fmt.Errorf("copying file: %w", err)
 	}
-
+	if err := dst.Chmod(mode); err != nil {
+		dst.Close()
+		return fmt.Errorf("setting file mode: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("writing destination file: %w", err)
+	}
+	if err := os.Rename(dst.Name(), db.Path); err != nil {
+		return fmt.Errorf("replacing destination file: %w", err)
+	}
 	return nil
 }
 
-func restoreMySQL(db config.Database, backupPath string) error {
-	args := []string{
-		"-h", db.Host,
-		"-P", fmt.Sprintf("%d", db.Port),
-		"-u", db.User,
-		fmt.Sprintf("--connect-timeout=%d", ConnectTimeoutSeconds),
-		db.Database,
+// restoreMySQL pipes the dump into mysql, which stops at the first failing statement.
+// Unless restore_no_transaction is set, rows are inserted with autocommit off so the
+// inserts of the table being restored when a statement fails are rolled back. MySQL
+// commits implicitly around DDL (DROP and CREATE TABLE) and UNLOCK TABLES, so tables
+// restored before the failure stay restored.
+func restoreMySQL(db config.Database, reader io.Reader) error {
+	var trailer string
+	if !db.RestoreNoTransaction {
+		trailer = "\nCOMMIT;\n"
 	}
 
-	cmd := exec.Command("mysql", args...)
+	cmd := exec.Command("mysql", mysqlRestoreArgs(db)...)
 	if db.Password != "" {
 		cmd.Env = append(os.Environ(), "MYSQL_PWD="+db.Password)
 	}
 
-	return runRestoreCommand(cmd, backupPath)
+	return runRestoreCommand(cmd, reader, trailer)
 }
 
-func restorePostgres(db config.Database, backupPath string) error {
+// mysqlRestoreArgs returns the mysql arguments restoring into db, at host and port
+// (never dump_host), reading the dump in db's charset when set
+func mysqlRestoreArgs(db config.Database) []string {
 	args := []string{
 		"-h", db.Host,
-		"-p", fmt.Sprintf("%d", db.Port),
-		"-U", db.User,
-		"-d", db.Database,
+		"-P", fmt.Sprintf("%d", db.Port),
+		"-u", db.User,
+		fmt.Sprintf("--connect-timeout=%d", connectTimeoutSeconds(db)),
 	}
-
-	cmd := exec.Command("psql", args...)
-	// Set connection timeout and password
-	cmd.Env = append(os.Environ(), fmt.Sprintf("PGCONNECT_TIMEOUT=%d", ConnectTimeoutSeconds))
-	if db.Password != "" {
-		cmd.Env = append(cmd.Env, "PGPASSWORD="+db.Password)
+	if db.Charset != "" {
+		args = append(args, "--default-character-set="+db.Charset)
 	}
+	if !db.RestoreNoTransaction {
+		args = append(args, "--init-command=SET autocommit=0")
+	}
+	return append(args, db.Database)
+}
 
-	return runRestoreCommand(cmd, backupPath)
+// restorePostgres pipes the dump into psql. Unless restore_no_transaction is set, the
+// whole dump runs in a single transaction that psql aborts at the first error, so a
+// failed restore leaves the database as it was.
+func restorePostgres(db config.Database, reader io.Reader) error {
+	cmd := exec.Command("psql", postgresRestoreArgs(db)...)
+	cmd.Env = postgresEnv(db)
+
+	return runRestoreCommand(cmd, reader, "")
 }
 
-func runRestoreCommand(cmd *exec.Cmd, backupPath string) error {
-	reader, cleanup, err := newDecompressReader(backupPath)
-	if err != nil {
-		return err
+// postgresRestoreArgs returns the psql arguments restoring into db, at host and port
+// (never dump_host)
+func postgresRestoreArgs(db config.Database) []string {
+	args := []string{
+		"-h", db.Host,
+		"-p", fmt.Sprintf("%d", db.Port),
+		"-U", db.User,
+		"-d", db.Database,
 	}
-	if cleanup != nil {
-		defer cleanup()
+	if !db.RestoreNoTransaction {
+		args = append(args, "--single-transaction", "-v", "ON_ERROR_STOP=1")
 	}
+	return args
+}
 
-	cmd.Stdin = reader
+// runRestoreCommand runs cmd with the decompressed backup read from reader, followed by
+// trailer, as its input. When reading the backup fails, cmd is killed before its input
+// ends: mysql and psql would otherwise commit the statements read so far.
+func runRestoreCommand(cmd *exec.Cmd, reader io.Reader, trailer string) error {
 	// Capture stdout/stderr instead of sending to terminal (interferes with TUI)
 	var stdoutBuf, stderrBuf bytes.Buffer
 	cmd.Stdout = &stdoutBuf
 	cmd.Stderr = &stderrBuf
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("restore command failed: %w", err)
+	}
 
-	if err := cmd.Run(); err != nil {
+	logCommand(cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("restore command failed: %w", err)
+	}
+	src := &readErrReader{r: reader}
+	// A write error means cmd stopped reading, its own failure is reported below
+	io.Copy(stdin, io.MultiReader(src, strings.NewReader(trailer)))
+	if src.err != nil {
+		cmd.Process.Kill()
+		stdin.Close()
+		cmd.Wait()
+		return fmt.Errorf("reading backup: %w", src.err)
+	}
+	stdin.Close()
+	err = cmd.Wait()
+	logStderr(cmd, stderrBuf.String())
+	if err != nil {
 		// Include stderr in error message if available
 		if stderrBuf.Len() > 0 {
 			return fmt.Errorf("restore command failed: %s", strings.TrimSpace(stderrBuf.String()))
@@ -112,9 +415,44 @@ func runRestoreCommand(cmd *exec.Cmd, backupPath string) error {
 	return nil
 }
 
-// newDecompressReader returns a reader that decompresses data based on file extension.
+// readErrReader remembers the error reading r failed with, io.EOF aside
+type readErrReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *readErrReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// zipEntry returns the file of a zip backup to restore: the one named after the archive
+// without its .zip, as blobber writes them, or else the first file in the archive (older
+// backups named the entry after the database or source file).
+func zipEntry(files []*zip.File, archiveName string) *zip.File {
+	want := strings.TrimSuffix(archiveName, ".zip")
+	var first *zip.File
+	for _, f := range files {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if f.Name == want {
+			return f
+		}
+		if first == nil {
+			first = f
+		}
+	}
+	return first
+}
+
+// newDecompressReader returns a reader that decompresses data based on file extension,
+// reading zstd dictionaries from dictDir.
 // Returns the reader, a cleanup function to call when done, and any error.
-func newDecompressReader(path string) (io.Reader, func(), error) {
+func newDecompressReader(path, dictDir string) (io.Reader, func(), error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, nil, fmt.Errorf("opening backup file: %w", err)
@@ -130,7 +468,7 @@ func newDecompressReader(path string) (io.Reader, func(), error) {
 		return gzReader, func() { gzReader.Close(); file.Close() }, nil
 
 	case strings.HasSuffix(path, ".zst"):
-		zstReader, err := zstd.NewReader(file)
+		zstReader, err := newZstdReader(file, dictDir)
 		if err != nil {
 			file.Close()
 			return nil, nil, fmt.Errorf("creating zstd reader: %w", err)
@@ -152,12 +490,12 @@ func newDecompressReader(path string) (io.Reader, func(), error) {
 		if err != nil {
 			return nil, nil, fmt.Errorf("opening zip file: %w", err)
 		}
-		if len(zipReader.File) == 0 {
+		entry := zipEntry(zipReader.File, filepath.Base(path))
+		if entry == nil {
 			zipReader.Close()
 			return nil, nil, fmt.Errorf("zip file is empty")
 		}
-		// Read the first file in the archive
-		rc, err := zipReader.File[0].Open()
+		rc, err := entry.Open()
 		if err != nil {
 			zipReader.Close()
 			return nil, nil, fmt.Errorf("opening zip entry: %w", err)
@@ -0,0 +1,56 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/Yoone/blobber/internal/config"
+	"github.com/dustin/go-humanize"
+)
+
+// FreeSpace returns the bytes available to unprivileged users in the filesystem
+// holding dir
+func FreeSpace(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, fmt.Errorf("checking free space in %s: %w", dir, err)
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+// TempSpaceNeeded returns roughly how much room a dump of db takes in the temp directory:
+// the size of its previous backup (lastSize), which was written the same way, or else
+// the pre-dump estimate when the dump isn't compressed. The estimate is of the data
+// before compression, so it says nothing of a compressed dump. An encrypted backup needs
+// twice the room, the dump and its encrypted copy being on disk together. It returns 0
+// when the size can't be told.
+func TempSpaceNeeded(db config.Database, lastSize, estimate int64) int64 {
+	need := lastSize
+	if need <= 0 && compressionExt[db.Compression] == "" {
+		need = estimate
+	}
+	if need <= 0 {
+		return 0
+	}
+	if db.Encryption != nil {
+		need *= 2
+	}
+	return need
+}
+
+// CheckTempSpace fails when the temp directory dumps are written to has less than need
+// bytes free, so a dump that can't fit is stopped before it starts rather than failing
+// partway through. A free space that can't be read is not an error.
+func CheckTempSpace(need int64) error {
+	if need <= 0 {
+		return nil
+	}
+	dir := os.TempDir()
+	free, err := FreeSpace(dir)
+	if err != nil || free >= need {
+		return nil
+	}
+	return fmt.Errorf("insufficient temp space: need ~%s, have %s in %s (set TMPDIR to a directory with more room)",
+		humanize.IBytes(uint64(need)), humanize.IBytes(uint64(free)), dir)
+}
@@ -0,0 +1,104 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Yoone/blobber/internal/config"
+)
+
+// Tool is an external client binary used to back up or restore a database type
+type Tool struct {
+	Binary   string // executable looked up in PATH
+	Fallback string // executable used instead when Binary is not installed, if any
+	Label    string // name shown to users
+	Purpose  string // "backup" or "restore"
+}
+
+// Find returns the path of the tool's executable: Binary, or Fallback when only it is
+// installed
+func (t Tool) Find() (string, error) {
+	path, err := exec.LookPath(t.Binary)
+	if err != nil && t.Fallback != "" {
+		if fallback, ferr := exec.LookPath(t.Fallback); ferr == nil {
+			return fallback, nil
+		}
+	}
+	return path, err
+}
+
+// RequiredTools returns the client binaries needed for the database type.
+// File databases need none.
+func RequiredTools(dbType string) []Tool {
+	switch dbType {
+	case "mysql":
+		return []Tool{
+			{Binary: "mysqldump", Fallback: "mariadb-dump", Label: "mysqldump", Purpose: "backup"},
+			{Binary: "mysql", Label: "mysql client", Purpose: "restore"},
+		}
+	case "postgres":
+		return []Tool{
+			{Binary: "pg_dump", Label: "pg_dump", Purpose: "backup"},
+			{Binary: "psql", Label: "psql", Purpose: "restore"},
+		}
+	}
+	return nil
+}
+
+// EncryptionTools returns the binaries needed to encrypt and decrypt the database's
+// backups: gpg when encryption is configured
+func EncryptionTools(db config.Database) []Tool {
+	if db.Encryption == nil {
+		return nil
+	}
+	return []Tool{gpgTool}
+}
+
+// CheckRequiredUtilities checks if the database's dump/restore utilities, and gpg when
+// its backups are encrypted, are in PATH
+// Returns a list of warning messages for missing utilities
+func CheckRequiredUtilities(db config.Database) []string {
+	return missingTools(append(RequiredTools(db.Type), EncryptionTools(db)...))
+}
+
+// CheckEncryptionUtilities checks if the tools encrypting the database's backups are in
+// PATH. Returns a list of warning messages for missing utilities
+func CheckEncryptionUtilities(db config.Database) []string {
+	return missingTools(EncryptionTools(db))
+}
+
+func missingTools(tools []Tool) []string {
+	var warnings []string
+	for _, tool := range tools {
+		if _, err := tool.Find(); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s not found in PATH (required for %s)", tool.Label, tool.Purpose))
+		}
+	}
+	return warnings
+}
+
+// toolVersionTimeout bounds a tool's --version, so that a tool which hangs (a wrapper
+// waiting for input, a stale network mount) can't stall the caller
+var toolVersionTimeout = 5 * time.Second
+
+// ToolVersion returns the first line of the tool's --version output
+func ToolVersion(ctx context.Context, binary string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, toolVersionTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, binary, "--version")
+	// Don't wait on children of the tool still holding its output once it is killed
+	cmd.WaitDelay = time.Second
+	out, err := cmd.Output()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "", fmt.Errorf("no answer within %s", toolVersionTimeout)
+	}
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(line), nil
+}
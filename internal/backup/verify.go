@@ -0,0 +1,166 @@
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Yoone/blobber/internal/storage"
+	"github.com/ulikunitz/xz"
+)
+
+// VerifyMode selects how thoroughly a backup file is checked
+type VerifyMode int
+
+const (
+	// VerifyFast compares the file's SHA-256 against the recorded checksum without
+	// decompressing. Falls back to VerifyFull when no checksum was recorded.
+	VerifyFast VerifyMode = iota
+	// VerifyFull additionally decompresses the whole stream, which validates the
+	// format's own integrity checks (gzip CRC, zstd/xz checksums, zip CRC).
+	VerifyFull
+)
+
+// FileSHA256 returns the hex-encoded SHA-256 of the file at path
+func FileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hashing file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify checks the integrity of a local backup file. expectedSHA256 is the checksum
+// recorded when the backup was written, or "" if none is available.
+func Verify(path, expectedSHA256 string, mode VerifyMode, s Settings) error {
+	if expectedSHA256 != "" {
+		sum, err := FileSHA256(path)
+		if err != nil {
+			return err
+		}
+		if sum != expectedSHA256 {
+			return fmt.Errorf("checksum mismatch: expected %s, got %s", expectedSHA256, sum)
+		}
+		if mode == VerifyFast {
+			return nil
+		}
+	}
+	return VerifyFile(path, s)
+}
+
+// VerifyFile decrypts and decompresses the local backup file at path and discards the
+// output, which validates the format's own integrity checks (gzip CRC, zstd/xz
+// checksums, zip CRC). Uncompressed backups only need to be readable.
+func VerifyFile(path string, s Settings) error {
+	path, decryptCleanup, err := decryptFile(path)
+	if err != nil {
+		return err
+	}
+	defer decryptCleanup()
+
+	reader, cleanup, err := newDecompressReader(path, s.DictionaryDir)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return fmt.Errorf("reading backup: %w", err)
+	}
+	return nil
+}
+
+// VerifyRemote downloads the backup fileName from dest into a temp directory and
+// verifies it fully: against the checksum recorded at upload when there is one, then
+// with VerifyFile
+func VerifyRemote(ctx context.Context, dest, fileName string, s Settings) error {
+	expected, err := storage.ReadChecksum(ctx, dest, fileName)
+	if err != nil {
+		return err
+	}
+	return VerifyRemoteAgainst(ctx, dest, fileName, expected, VerifyFull, s)
+}
+
+// VerifyRemoteAgainst downloads the backup fileName from dest into a temp directory and
+// verifies it with Verify against expected, the checksum read with storage.ReadChecksum
+func VerifyRemoteAgainst(ctx context.Context, dest, fileName, expected string, mode VerifyMode, s Settings) error {
+	tmpDir, err := os.MkdirTemp("", "blobber-verify-")
+	if err != nil {
+		return fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := storage.Download(ctx, dest, fileName, tmpDir, s.Download); err != nil {
+		return fmt.Errorf("downloading backup: %w", err)
+	}
+	return Verify(filepath.Join(tmpDir, fileName), expected, mode, s)
+}
+
+// StreamVerifiable reports whether a backup's compression format carries its own
+// checksum that VerifyStream can check without seeking (gz, zst, xz)
+func StreamVerifiable(filename string) bool {
+	return strings.HasSuffix(filename, ".gz") || strings.HasSuffix(filename, ".zst") || strings.HasSuffix(filename, ".xz")
+}
+
+// VerifyStream decompresses a gz, zst or xz backup read from r and discards the output,
+// which validates the format's own integrity checks (gzip CRC, zstd/xz checksums)
+func VerifyStream(r io.Reader, filename string, s Settings) error {
+	if !StreamVerifiable(filename) {
+		return fmt.Errorf("%s has no stream checksum to verify", filename)
+	}
+
+	reader, cleanup, err := DecompressStream(r, filename, s)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return fmt.Errorf("reading backup: %w", err)
+	}
+	return nil
+}
+
+// DecompressStream returns a reader that decompresses a backup read from r based on its
+// file extension, with the zstd dictionaries of s. Uncompressed backups are returned as
+// is; zip archives need seeking and encrypted backups a keyring, and are not supported.
+func DecompressStream(r io.Reader, filename string, s Settings) (io.Reader, func(), error) {
+	switch {
+	case Encrypted(filename):
+		return nil, nil, fmt.Errorf("%s is encrypted and cannot be read as a stream", filename)
+	case strings.HasSuffix(filename, ".gz"):
+		gzReader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("creating gzip reader: %w", err)
+		}
+		return gzReader, func() { gzReader.Close() }, nil
+	case strings.HasSuffix(filename, ".zst"):
+		zstReader, err := newZstdReader(r, s.DictionaryDir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("creating zstd reader: %w", err)
+		}
+		return zstReader, zstReader.Close, nil
+	case strings.HasSuffix(filename, ".xz"):
+		xzReader, err := xz.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("creating xz reader: %w", err)
+		}
+		return xzReader, func() {}, nil
+	case strings.HasSuffix(filename, ".zip"):
+		return nil, nil, fmt.Errorf("%s is a zip archive and cannot be read as a stream", filename)
+	default:
+		return r, func() {}, nil
+	}
+}
@@ -2,18 +2,80 @@ package config
 
 import (
 	"bytes"
+	"cmp"
+	"crypto/sha256"
 	"fmt"
+	"io"
+	"maps"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	path      string              `yaml:"-"` // not serialized
-	Databases map[string]Database `yaml:"databases"`
+	path              string              `yaml:"-"`                            // not serialized
+	readOnly          bool                `yaml:"-"`                            // the config file could not be written when loaded
+	RunTimeout        string              `yaml:"run_timeout,omitempty"`        // ceiling for a whole backup run (e.g. "2h")
+	ConnectTimeout    string              `yaml:"connect_timeout,omitempty"`    // how long to wait for database connections (default 5s)
+	SizeWarningMB     int                 `yaml:"size_warning_mb,omitempty"`    // warn when a dump is estimated above this size
+	Reports           bool                `yaml:"reports,omitempty"`            // write a JSON report to the destination after each run
+	ReportDest        string              `yaml:"report_dest,omitempty"`        // where reports go (default: first database's dest)
+	FavoriteRemotes   []string            `yaml:"favorite_remotes,omitempty"`   // rclone remotes suggested first for destinations
+	PreferredBackends []string            `yaml:"preferred_backends,omitempty"` // TUI: rclone backends listed when adding a remote, the others behind a toggle (default: all)
+	Databases         map[string]Database `yaml:"databases"`
+
+	RestoreAudit     bool   `yaml:"restore_audit,omitempty"`      // record each restore in restore_audit_log
+	RestoreAuditLog  string `yaml:"restore_audit_log,omitempty"`  // local restore audit log (default: restore_audit.log next to the config)
+	RestoreAuditDest string `yaml:"restore_audit_dest,omitempty"` // also upload each restore record under this destination's reports/
+
+	KeepOnUploadFailure bool   `yaml:"keep_on_upload_failure,omitempty"` // move dumps whose upload failed to failed_uploads_dir
+	FailedUploadsDir    string `yaml:"failed_uploads_dir,omitempty"`     // where kept dumps go (default: failed_uploads next to the config)
+
+	StagingDir string `yaml:"staging_dir,omitempty"` // where backup --stage leaves dumps for upload-staged (default: staged next to the config)
+
+	DictionaryDir string `yaml:"dictionary_dir,omitempty"` // where zstd dictionaries are stored (default: dictionaries next to the config)
+
+	DiagnosticsLog string `yaml:"diagnostics_log,omitempty"` // TUI: where rclone logs, command stderr and panics are written (default: logs/diagnostics.log next to the config; "off" disables)
+
+	FailFastOnAuth bool `yaml:"fail_fast_on_auth,omitempty"` // test database credentials before a run and abort it if any are rejected
+
+	DownloadStreams  int `yaml:"download_streams,omitempty"`  // parallel streams downloading a large backup (default: rclone's 4; 1 disables)
+	DownloadAttempts int `yaml:"download_attempts,omitempty"` // tries of a backup download failing with a transient error (default 3; 1 disables retries)
+
+	StallTimeout string `yaml:"stall_timeout,omitempty"` // TUI: warn when an upload or download moves no bytes for this long (default 2m)
+
+	RestoreListLimit int `yaml:"restore_list_limit,omitempty"` // newest backups the TUI restore picker lists at first (default 200)
+
+	DestinationCacheTTL string `yaml:"destination_cache_ttl,omitempty"` // TUI: how long backup listings and destination tests are reused between screens (default 30s; 0 disables)
+
+	RestoreConcurrency int `yaml:"restore_concurrency,omitempty"` // databases a batch restore restores at once (default 2)
+
+	RetentionListCheckers  int `yaml:"retention_list_checkers,omitempty"`  // directories listed in parallel for retention (default: rclone's 8)
+	RetentionDeleteWorkers int `yaml:"retention_delete_workers,omitempty"` // backups retention deletes in parallel (default 1)
+
+	SkipRetentionPrecheck bool `yaml:"skip_retention_precheck,omitempty"` // don't list destinations for retention before dumping; decide after each upload
+
+	StaleCheck bool   `yaml:"stale_check,omitempty"` // TUI: look for databases without a recent backup on startup
+	StaleAfter string `yaml:"stale_after,omitempty"` // age after which a database's newest backup is stale (default: keep_days, else 48h)
+
+	Presets map[string]Preset `yaml:"presets,omitempty"` // named backup selections (blobber backup --preset, TUI)
+}
+
+// Preset is a named backup selection: the databases to back up together and the options
+// of the run
+type Preset struct {
+	Databases       []string `yaml:"databases"`
+	SkipRetention   bool     `yaml:"skip_retention,omitempty"`    // don't apply retention
+	RetentionDryRun bool     `yaml:"retention_dry_run,omitempty"` // only report what retention would delete
+	DryRun          bool     `yaml:"dry_run,omitempty"`           // dump only, skip upload and retention
 }
 
 type Database struct {
@@ -21,18 +83,548 @@ type Database struct {
 	Path        string    `yaml:"path,omitempty"`        // for file type
 	Host        string    `yaml:"host,omitempty"`        // for mysql/postgres
 	Port        int       `yaml:"port,omitempty"`        // for mysql/postgres
+	DumpHost    string    `yaml:"dump_host,omitempty"`   // mysql/postgres: server dumps read from (e.g. a read replica) instead of host
+	DumpPort    int       `yaml:"dump_port,omitempty"`   // mysql/postgres: port of dump_host (default port)
 	User        string    `yaml:"user,omitempty"`        // for mysql/postgres
 	Password    string    `yaml:"password,omitempty"`    // for mysql/postgres
-	Database    string    `yaml:"database,omitempty"`    // database name for mysql/postgres
+	Database    string    `yaml:"database,omitempty"`    // database name for mysql/postgres ("*" for all)
 	Dest        string    `yaml:"dest"`                  // rclone destination
+	FilePrefix  string    `yaml:"file_prefix,omitempty"` // backup files start with this instead of the entry name
 	Compression string    `yaml:"compression,omitempty"` // none, gz, zstd, xz, zip
 	Retention   Retention `yaml:"retention,omitempty"`
+
+	Encryption *Encryption `yaml:"encryption,omitempty"` // encrypt backups after compression
+
+	DestSpec  *InlineDest `yaml:"-"` // set when dest is given inline; Dest is then the remote it is registered under
+	DestTiers *TieredDest `yaml:"-"` // set when dest is given as a local and a remote tier; Dest is then the remote tier
+
+	ExpandedFrom string `yaml:"-"` // set on the entries a database "*" entry expands to: the name of that entry
+
+	RestoreFileMode string `yaml:"restore_file_mode,omitempty"` // file: octal permissions for the restored file (e.g. "0600")
+	ArchiveExt      string `yaml:"archive_ext,omitempty"`       // file: extension of backups instead of the source path's (e.g. ".dat")
+	ConnectTimeout  string `yaml:"connect_timeout,omitempty"`   // mysql/postgres: overrides the global connect_timeout
+	Charset         string `yaml:"charset,omitempty"`           // mysql/postgres: character set dumps and restores exchange data in (e.g. latin1)
+	Immutable       bool   `yaml:"immutable,omitempty"`         // dest is write-once (object lock): never delete or overwrite
+	VerifyUpload    bool   `yaml:"verify_upload,omitempty"`     // compare the uploaded backup's SHA-256 before removing the local dump
+	AtomicUpload    bool   `yaml:"atomic_upload,omitempty"`     // upload under a .part name and rename once complete
+	GroupBy         string `yaml:"group_by,omitempty"`          // day or month: upload each backup to a dated folder of dest
+	MinBackupSize   int64  `yaml:"min_backup_size,omitempty"`   // fail dumps smaller than this many bytes (default 1024 for mysql/postgres)
+
+	VerifyOnRetention int       `yaml:"verify_on_retention,omitempty"` // check the compression checksums of the newest N backups retention keeps
+	SizeAlert         SizeAlert `yaml:"size_alert,omitempty"`          // warn when a backup's size is far from the recent ones
+	ZstdDictionary    uint32    `yaml:"zstd_dictionary,omitempty"`     // zstd: ID of the dictionary in dictionary_dir to compress with
+	BackupIndex       bool      `yaml:"backup_index,omitempty"`        // keep an index of the backups at dest, read by the TUI restore picker instead of a listing
+
+	PostUploadVerify      bool `yaml:"post_upload_verify,omitempty"`        // download each new backup and check it reads back intact before removing the local dump
+	PostUploadVerifyMaxMB int  `yaml:"post_upload_verify_max_mb,omitempty"` // backups larger than this are not downloaded again (0: no limit)
+
+	ExcludeDatabases []string `yaml:"exclude_databases,omitempty"` // with database "*": glob patterns of databases to skip
+	After            []string `yaml:"after,omitempty"`             // databases whose backup must finish before this one starts
+	ConsistencyGroup string   `yaml:"consistency_group,omitempty"` // mysql/postgres: dump at the same point as the other databases of the group
+	MariaDB          bool     `yaml:"mariadb,omitempty"`           // mysql: the server is MariaDB, dumped with mariadb-dump when installed
+	Redact           []string `yaml:"redact,omitempty"`            // mysql/postgres: "table.column[:null|:hash]" values to rewrite in dumps
+
+	VacuumAnalyze        bool   `yaml:"vacuum_analyze,omitempty"`         // postgres: run VACUUM ANALYZE after a restore
+	RestoreNoTransaction bool   `yaml:"restore_no_transaction,omitempty"` // mysql/postgres: apply restores statement by statement, with no rollback on error
+	PostRestoreCommand   string `yaml:"post_restore_command,omitempty"`   // shell command run after a successful restore
+	RestoreMode          string `yaml:"restore_mode,omitempty"`           // create-only, overwrite-only or upsert (default): what a restore expects of its target
+	StreamRestore        bool   `yaml:"stream_restore,omitempty"`         // restore backups as they are downloaded, without a temp file
+}
+
+// InlineDest is a destination whose backend settings are written in the blobber config
+// instead of an rclone remote, so a database entry is self-contained
+type InlineDest struct {
+	Type        string            `yaml:"type"`                    // rclone backend: s3, b2 or azureblob
+	Provider    string            `yaml:"provider,omitempty"`      // s3: provider (default AWS)
+	Bucket      string            `yaml:"bucket"`                  // bucket (container for azureblob)
+	Path        string            `yaml:"path,omitempty"`          // folder inside the bucket
+	Region      string            `yaml:"region,omitempty"`        // s3: region
+	Endpoint    string            `yaml:"endpoint,omitempty"`      // custom endpoint (S3-compatible services)
+	AccessKeyID string            `yaml:"access_key_id,omitempty"` // s3: access key ID; b2, azureblob: account
+	Secret      string            `yaml:"secret,omitempty"`        // s3: secret access key; b2, azureblob: key
+	Options     map[string]string `yaml:"options,omitempty"`       // other rclone options of the backend
+}
+
+// InlineRemotePrefix starts the remote name of inline destinations
+const InlineRemotePrefix = "inline-"
+
+// Remote returns the name the inline destination is registered under with the storage
+// backend: its type and a hash of its settings, so destinations with the same settings
+// share a backend and editing them gets a new one
+func (d *InlineDest) Remote() string {
+	opts := d.RcloneOptions()
+	h := sha256.New()
+	for _, k := range slices.Sorted(maps.Keys(opts)) {
+		fmt.Fprintf(h, "%s=%s\x00", k, opts[k])
+	}
+	return fmt.Sprintf("%s%s-%x", InlineRemotePrefix, d.Type, h.Sum(nil)[:4])
+}
+
+// Dest returns the rclone destination of the inline destination: its remote, bucket and
+// path
+func (d *InlineDest) Dest() string {
+	return d.Remote() + ":" + path.Join(d.Bucket, d.Path)
+}
+
+// RcloneOptions returns the settings of the inline destination as options of its rclone
+// backend
+func (d *InlineDest) RcloneOptions() map[string]string {
+	opts := make(map[string]string)
+	for k, v := range d.Options {
+		opts[k] = v
+	}
+	set := func(key, value string) {
+		if value != "" {
+			opts[key] = value
+		}
+	}
+	switch d.Type {
+	case "s3":
+		set("provider", cmp.Or(d.Provider, "AWS"))
+		set("access_key_id", d.AccessKeyID)
+		set("secret_access_key", d.Secret)
+		set("region", d.Region)
+		set("endpoint", d.Endpoint)
+	case "b2", "azureblob":
+		set("account", d.AccessKeyID)
+		set("key", d.Secret)
+		set("endpoint", d.Endpoint)
+	}
+	return opts
+}
+
+// masked returns a copy of the inline destination with its secret and option values
+// masked, since options can hold credentials (session tokens, SAS URLs) too
+func (d *InlineDest) masked() *InlineDest {
+	spec := *d
+	if spec.Secret != "" {
+		spec.Secret = maskedPassword
+	}
+	if len(d.Options) > 0 {
+		spec.Options = make(map[string]string, len(d.Options))
+		for k, v := range d.Options {
+			if v != "" {
+				v = maskedPassword
+			}
+			spec.Options[k] = v
+		}
+	}
+	return &spec
+}
+
+// Validate checks that the fields the backend requires are set
+func (d *InlineDest) Validate() error {
+	switch d.Type {
+	case "s3", "b2", "azureblob":
+	case "":
+		return fmt.Errorf("type is required")
+	default:
+		return fmt.Errorf("unsupported type %q (inline destinations support s3, b2 and azureblob; use an rclone remote for others)", d.Type)
+	}
+	if d.Bucket == "" {
+		return fmt.Errorf("bucket is required")
+	}
+	if d.AccessKeyID == "" {
+		return fmt.Errorf("access_key_id is required for %s", d.Type)
+	}
+	if d.Secret == "" {
+		return fmt.Errorf("secret is required for %s", d.Type)
+	}
+	if d.Type != "s3" && (d.Provider != "" || d.Region != "") {
+		return fmt.Errorf("provider and region are only supported for s3")
+	}
+	return nil
+}
+
+// TieredDest is a dest written as two tiers: each backup is written to the local
+// directory and kept there, then uploaded from it to the remote. Each tier applies the
+// database's retention unless it has its own.
+type TieredDest struct {
+	Local           string     `yaml:"local"`                      // directory of the local tier ("~" is the home directory)
+	Remote          string     `yaml:"remote"`                     // rclone destination of the remote tier
+	LocalRetention  *Retention `yaml:"local_retention,omitempty"`  // retention of the local tier, instead of the database's
+	RemoteRetention *Retention `yaml:"remote_retention,omitempty"` // retention of the remote tier, instead of the database's
+}
+
+// LocalPath returns the directory of the local tier, with a leading "~" expanded
+func (t *TieredDest) LocalPath() string {
+	if t.Local == "~" || strings.HasPrefix(t.Local, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, t.Local[1:])
+		}
+	}
+	return t.Local
+}
+
+// Validate checks that both tiers are set
+func (t *TieredDest) Validate() error {
+	if t.Local == "" {
+		return fmt.Errorf("local is required")
+	}
+	if t.Remote == "" {
+		return fmt.Errorf("remote is required")
+	}
+	return nil
+}
+
+// UnmarshalYAML reads dest as an rclone destination string, as an inline destination,
+// whose remote Dest is then set to, or as local and remote tiers, Dest being the remote
+func (d *Database) UnmarshalYAML(node *yaml.Node) error {
+	type plain Database
+	dest := mappingValue(node, "dest")
+	if dest == nil || dest.Kind != yaml.MappingNode {
+		return node.Decode((*plain)(d))
+	}
+
+	rest := *node
+	rest.Content = nil
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != "dest" {
+			rest.Content = append(rest.Content, node.Content[i], node.Content[i+1])
+		}
+	}
+	if err := rest.Decode((*plain)(d)); err != nil {
+		return err
+	}
+
+	if mappingValue(dest, "local") != nil || mappingValue(dest, "remote") != nil {
+		var tiers TieredDest
+		if err := dest.Decode(&tiers); err != nil {
+			return err
+		}
+		d.DestTiers = &tiers
+		d.Dest = tiers.Remote
+		return nil
+	}
+	var spec InlineDest
+	if err := dest.Decode(&spec); err != nil {
+		return err
+	}
+	d.DestSpec = &spec
+	d.Dest = spec.Dest()
+	return nil
+}
+
+// MarshalYAML writes an inline destination back as its settings rather than the remote
+// it is registered under, and a tiered one as its two tiers
+func (d Database) MarshalYAML() (any, error) {
+	type plain Database
+	var spec any
+	switch {
+	case d.DestSpec != nil:
+		spec = d.DestSpec
+	case d.DestTiers != nil:
+		spec = d.DestTiers
+	default:
+		return plain(d), nil
+	}
+	var node yaml.Node
+	if err := node.Encode(plain(d)); err != nil {
+		return nil, err
+	}
+	if dest := mappingValue(&node, "dest"); dest != nil {
+		if err := dest.Encode(spec); err != nil {
+			return nil, err
+		}
+	}
+	return &node, nil
+}
+
+// LocalTier returns the directory of the local tier of a tiered dest, or "" when the
+// dest has a single tier
+func (d Database) LocalTier() string {
+	if d.DestTiers == nil {
+		return ""
+	}
+	return d.DestTiers.LocalPath()
+}
+
+// SizeAlert flags a new backup whose size is an outlier against the recent backups of the
+// database: a runaway table, or a failed or partial dump
+type SizeAlert struct {
+	Factor  float64 `yaml:"factor,omitempty"`  // warn when the backup is this many times larger or smaller than the median
+	History int     `yaml:"history,omitempty"` // how many previous backups the median is taken over (default 7)
+}
+
+// Encryption encrypts backups with an external tool once they are compressed. Only gpg
+// is supported: backups are encrypted to the public keys of the recipients, and restoring
+// them needs one of the matching secret keys in the keyring.
+type Encryption struct {
+	Type       string   `yaml:"type"`                 // gpg
+	Recipients []string `yaml:"recipients,omitempty"` // key IDs, fingerprints or emails to encrypt to
+}
+
+// DefaultSizeAlertHistory is how many previous backups size_alert compares against when
+// history is not set
+const DefaultSizeAlertHistory = 7
+
+// Enabled reports whether size alerts are configured
+func (a SizeAlert) Enabled() bool {
+	return a.Factor > 0
+}
+
+// HistorySize returns how many previous backups the median is taken over
+func (a SizeAlert) HistorySize() int {
+	if a.History > 0 {
+		return a.History
+	}
+	return DefaultSizeAlertHistory
+}
+
+// AllDatabasesWildcard is the database name that backs up every database on the server
+const AllDatabasesWildcard = "*"
+
+// AllDatabases reports whether the entry backs up every database on the server
+func (d Database) AllDatabases() bool {
+	return d.Database == AllDatabasesWildcard
+}
+
+// DumpSource returns the database as dumps connect to it: at dump_host and dump_port
+// when set, so backups can be read from a replica, at host and port otherwise. Restores
+// always target host and port.
+func (d Database) DumpSource() Database {
+	if d.DumpHost != "" {
+		d.Host = d.DumpHost
+	}
+	if d.DumpPort != 0 {
+		d.Port = d.DumpPort
+	}
+	return d
+}
+
+// HasDumpSource reports whether dumps connect to another server than restores
+func (d Database) HasDumpSource() bool {
+	return d.DumpHost != "" || d.DumpPort != 0
+}
+
+// BackupPrefix returns what the backup files of the entry name start with: file_prefix
+// when set, otherwise the entry name
+func (d Database) BackupPrefix(name string) string {
+	if d.FilePrefix != "" {
+		return d.FilePrefix
+	}
+	return name
+}
+
+// BackupFolder returns the folder of dest that a backup taken at t is uploaded to with
+// group_by ("2024-01-15" by day, "2024-01" by month), or "" for dest itself
+func (d Database) BackupFolder(t time.Time) string {
+	switch d.GroupBy {
+	case "day":
+		return t.Format("2006-01-02")
+	case "month":
+		return t.Format("2006-01")
+	}
+	return ""
+}
+
+// PostUploadVerifies reports whether post_upload_verify downloads a backup of size bytes
+// once it is uploaded: it is enabled and the backup is within post_upload_verify_max_mb
+func (d Database) PostUploadVerifies(size int64) bool {
+	if !d.PostUploadVerify {
+		return false
+	}
+	return d.PostUploadVerifyMaxMB == 0 || size <= int64(d.PostUploadVerifyMaxMB)*1024*1024
+}
+
+// DefaultMinBackupSize is the smallest mysql or postgres dump, in bytes, accepted when
+// min_backup_size is not set. The headers of an empty dump alone come close to it.
+const DefaultMinBackupSize = 1024
+
+// MinDumpSize returns the smallest dump, in bytes, that is uploaded as a backup:
+// min_backup_size, or DefaultMinBackupSize for SQL dumps. File copies have no default.
+func (d Database) MinDumpSize() int64 {
+	if d.MinBackupSize > 0 || d.Type == "file" {
+		return d.MinBackupSize
+	}
+	return DefaultMinBackupSize
+}
+
+// FileExt returns the extension of a file backup before compression: archive_ext when set,
+// otherwise the extension of path, or ".bak" when it has none
+func (d Database) FileExt() string {
+	if d.ArchiveExt != "" {
+		return "." + strings.TrimPrefix(d.ArchiveExt, ".")
+	}
+	if ext := filepath.Ext(d.Path); ext != "" {
+		return ext
+	}
+	return ".bak"
+}
+
+// archiveExtPattern matches extensions such as "dat", ".dat" or ".tar.lz4"
+var archiveExtPattern = regexp.MustCompile(`^\.?[A-Za-z0-9]+([._-][A-Za-z0-9]+)*$`)
+
+// SidecarExts end the names of files written next to backups that are not backups, even
+// when named after one: partial uploads and checksum sidecars. ".json" is not one, since
+// a file database's backups keep the source's extension.
+var SidecarExts = []string{".part", ".sha256"}
+
+// IsSidecar reports whether the file name ends in one of SidecarExts
+func IsSidecar(name string) bool {
+	for _, ext := range SidecarExts {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedExts end file names blobber reads a meaning from: compression, encryption,
+// checksum sidecars and partial uploads. A backup named with one would be misread.
+var reservedExts = append([]string{".gz", ".zst", ".xz", ".zip", ".gpg"}, SidecarExts...)
+
+// HasRetention reports whether any retention rule is configured, for dest or for either
+// tier of a tiered dest
+func (d Database) HasRetention() bool {
+	return d.DestRetention().Enabled() || (d.DestTiers != nil && d.LocalTierRetention().Enabled())
+}
+
+// DestRetention returns the retention policy applied to dest: the remote tier's own
+// policy when a tiered dest sets remote_retention, otherwise the database's retention
+func (d Database) DestRetention() Retention {
+	if d.DestTiers != nil && d.DestTiers.RemoteRetention != nil {
+		return *d.DestTiers.RemoteRetention
+	}
+	return d.Retention
+}
+
+// WithDestRetention returns d with r as the retention policy applied to dest, the
+// counterpart of DestRetention: the remote tier's own policy is replaced when a tiered
+// dest sets remote_retention, otherwise the database's retention. The tiers are copied,
+// not modified in place, as they may be shared with other copies of d.
+func (d Database) WithDestRetention(r Retention) Database {
+	if d.DestTiers != nil && d.DestTiers.RemoteRetention != nil {
+		tiers := *d.DestTiers
+		tiers.RemoteRetention = &r
+		d.DestTiers = &tiers
+		return d
+	}
+	d.Retention = r
+	return d
+}
+
+// LocalTierRetention returns the retention policy applied to the local tier of a tiered
+// dest: its local_retention when set, otherwise the database's retention
+func (d Database) LocalTierRetention() Retention {
+	if d.DestTiers != nil && d.DestTiers.LocalRetention != nil {
+		return *d.DestTiers.LocalRetention
+	}
+	return d.Retention
+}
+
+// RestoreFilePerm parses restore_file_mode. ok is false when no mode is configured, in
+// which case a restored file keeps the mode of the file it replaces.
+func (d Database) RestoreFilePerm() (mode os.FileMode, ok bool, err error) {
+	if d.RestoreFileMode == "" {
+		return 0, false, nil
+	}
+	n, err := strconv.ParseUint(d.RestoreFileMode, 8, 32)
+	if err != nil || n > 0777 {
+		return 0, false, fmt.Errorf("restore_file_mode must be an octal permission such as 0600, got %q", d.RestoreFileMode)
+	}
+	return os.FileMode(n), true, nil
+}
+
+// Restore modes (restore_mode): whether a restore may create its target, overwrite it,
+// or both
+const (
+	RestoreUpsert        = "upsert"         // restore into the target as it is, without checking it
+	RestoreCreateOnly    = "create-only"    // fail if the target already exists
+	RestoreOverwriteOnly = "overwrite-only" // fail if the target does not exist
+)
+
+// RestoreTargetMode returns restore_mode, upsert when not set
+func (d Database) RestoreTargetMode() string {
+	if d.RestoreMode == "" {
+		return RestoreUpsert
+	}
+	return d.RestoreMode
+}
+
+// mysqlCharsets are the character sets charset accepts for mysql: those mysqldump and
+// mysql take as --default-character-set (ucs2, utf16 and utf32 can't be client sets)
+var mysqlCharsets = []string{
+	"armscii8", "ascii", "big5", "binary", "cp1250", "cp1251", "cp1256", "cp1257", "cp850",
+	"cp852", "cp866", "cp932", "dec8", "eucjpms", "euckr", "gb18030", "gb2312", "gbk",
+	"geostd8", "greek", "hebrew", "hp8", "keybcs2", "koi8r", "koi8u", "latin1", "latin2",
+	"latin5", "latin7", "macce", "macroman", "sjis", "swe7", "tis620", "ujis", "utf8",
+	"utf8mb3", "utf8mb4",
+}
+
+// postgresEncodings are the character sets charset accepts for postgres: the client
+// encodings PGCLIENTENCODING takes
+var postgresEncodings = []string{
+	"BIG5", "EUC_CN", "EUC_JIS_2004", "EUC_JP", "EUC_KR", "EUC_TW", "GB18030", "GBK",
+	"ISO_8859_5", "ISO_8859_6", "ISO_8859_7", "ISO_8859_8", "JOHAB", "KOI8R", "KOI8U",
+	"LATIN1", "LATIN2", "LATIN3", "LATIN4", "LATIN5", "LATIN6", "LATIN7", "LATIN8", "LATIN9",
+	"LATIN10", "MULE_INTERNAL", "SHIFT_JIS_2004", "SJIS", "SQL_ASCII", "UHC", "UTF8",
+	"WIN1250", "WIN1251", "WIN1252", "WIN1253", "WIN1254", "WIN1255", "WIN1256", "WIN1257",
+	"WIN1258", "WIN866", "WIN874",
+}
+
+// validCharset reports whether charset is a character set the database type's client
+// tools accept. MySQL names are lowercase, PostgreSQL ones are matched in any case.
+func validCharset(dbType, charset string) bool {
+	switch dbType {
+	case "mysql":
+		return slices.Contains(mysqlCharsets, charset)
+	case "postgres":
+		return slices.Contains(postgresEncodings, strings.ToUpper(charset))
+	}
+	return false
+}
+
+// RedactRule is a parsed redact entry: the values of Column in Table are replaced in
+// dumps
+type RedactRule struct {
+	Table  string // table name, optionally schema-qualified (e.g. public.users)
+	Column string
+	Hash   bool // replace values with a hash of the original instead of NULL
+}
+
+// ParseRedactRule parses a redact entry: "table.column", optionally followed by ":null"
+// (the default) or ":hash"
+func ParseRedactRule(entry string) (RedactRule, error) {
+	target, action, _ := strings.Cut(entry, ":")
+	dot := strings.LastIndex(target, ".")
+	if dot <= 0 || dot == len(target)-1 {
+		return RedactRule{}, fmt.Errorf("redact entry %q must be table.column", entry)
+	}
+	rule := RedactRule{Table: target[:dot], Column: target[dot+1:]}
+	switch action {
+	case "", "null":
+	case "hash":
+		rule.Hash = true
+	default:
+		return RedactRule{}, fmt.Errorf("redact entry %q: action must be null or hash", entry)
+	}
+	return rule, nil
+}
+
+// RedactRules parses the redact entries
+func (d Database) RedactRules() ([]RedactRule, error) {
+	var rules []RedactRule
+	for _, entry := range d.Redact {
+		rule, err := ParseRedactRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
 }
 
 type Retention struct {
 	KeepLast  int `yaml:"keep_last,omitempty"`
 	KeepDays  int `yaml:"keep_days,omitempty"`
 	MaxSizeMB int `yaml:"max_size_mb,omitempty"`
+	MaxCount  int `yaml:"max_count,omitempty"` // hard ceiling on the number of backups, enforced over the other rules
+}
+
+// Enabled reports whether the policy has any rule, so retention deletes anything
+func (r Retention) Enabled() bool {
+	return r.KeepLast > 0 || r.KeepDays > 0 || r.MaxSizeMB > 0 || r.MaxCount > 0
 }
 
 func Load(path string) (*Config, error) {
@@ -50,6 +642,7 @@ func Load(path string) (*Config, error) {
 	}
 
 	cfg.path = path
+	cfg.readOnly = !writable(path)
 	cfg.applyDefaults()
 
 	if err := cfg.Validate(); err != nil {
@@ -65,6 +658,7 @@ func LoadOrEmpty(path string) (*Config, error) {
 	if os.IsNotExist(err) {
 		return &Config{
 			path:      path,
+			readOnly:  !writable(path),
 			Databases: make(map[string]Database),
 		}, nil
 	}
@@ -81,6 +675,7 @@ func LoadOrEmpty(path string) (*Config, error) {
 	}
 
 	cfg.path = path
+	cfg.readOnly = !writable(path)
 	if cfg.Databases == nil {
 		cfg.Databases = make(map[string]Database)
 	}
@@ -113,13 +708,48 @@ func (c *Config) applyDefaults() {
 	}
 }
 
+// writable reports whether Save can write the config file at path: the file itself when
+// it exists, and the directory Save creates its temp file in (or the nearest existing
+// directory it would be created in)
+func writable(path string) bool {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err == nil {
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return false
+	}
+
+	dir := filepath.Dir(resolveSymlink(path))
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+	probe, err := os.CreateTemp(dir, ".blobber-write-test-*")
+	if err != nil {
+		return false
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return true
+}
+
+// ReadOnly reports whether the config file could not be written when it was loaded (a
+// read-only mount in a container, for instance), in which case Save would fail
+func (c *Config) ReadOnly() bool {
+	return c.readOnly
+}
+
 // Save writes the config to its file path
 func (c *Config) Save() error {
 	var buf bytes.Buffer
-	enc := yaml.NewEncoder(&buf)
-	enc.SetIndent(2)
-	if err := enc.Encode(c); err != nil {
-		return fmt.Errorf("marshaling config: %w", err)
+	if err := c.Encode(&buf); err != nil {
+		return err
 	}
 
 	// Create parent directory if it doesn't exist
@@ -128,13 +758,661 @@ func (c *Config) Save() error {
 		return fmt.Errorf("creating config directory: %w", err)
 	}
 
-	if err := os.WriteFile(c.path, buf.Bytes(), 0644); err != nil {
+	if err := writeFileAtomic(resolveSymlink(c.path), buf.Bytes()); err != nil {
 		return fmt.Errorf("writing config file: %w", err)
 	}
 
 	return nil
 }
 
+// writeData writes the new config to the temp file (replaced in tests to simulate a
+// failing write)
+var writeData = func(f *os.File, data []byte) error {
+	_, err := f.Write(data)
+	return err
+}
+
+// writeFileAtomic replaces the file at path with data so that a crash or a full disk
+// leaves either the old or the new content, never a truncated file: data is written to a
+// temp file in the same directory, synced, then renamed over path. An existing file keeps
+// its mode.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	if err := writeData(tmp, data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself; not every platform can sync a directory
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// resolveSymlink returns the file a symlinked config points to, so saving replaces the
+// target rather than the link. Other paths are returned unchanged.
+func resolveSymlink(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// Encode writes the config as YAML, as Save writes it to the file
+func (c *Config) Encode(w io.Writer) error {
+	return encodeYAML(w, c)
+}
+
+func encodeYAML(w io.Writer, v any) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("marshaling config: %w", err)
+	}
+	return enc.Close()
+}
+
+// maskedPassword replaces database passwords in config printed for the user
+const maskedPassword = "********"
+
+// envVarRef matches a value that is only a ${VAR} reference
+var envVarRef = regexp.MustCompile(`^\$\{[^}]+\}$`)
+
+// Effective returns a copy of the config as a run uses it: defaults that are otherwise
+// implicit (connect_timeout, dictionary_dir, failed_uploads_dir, staging_dir,
+// restore_audit_log, min_backup_size) filled
+// in and passwords masked. Ports and compression are already filled in by Load.
+func (c *Config) Effective() *Config {
+	eff := *c
+	eff.ConnectTimeout = c.ConnectTimeoutDuration().String()
+	eff.DictionaryDir = c.DictionaryDirectory()
+	eff.FailedUploadsDir = c.FailedUploadsDirectory()
+	eff.StagingDir = c.StagingDirectory()
+	eff.RestoreAuditLog = c.RestoreAuditPath()
+	eff.Databases = make(map[string]Database, len(c.Databases))
+	for name, db := range c.Databases {
+		if db.Password != "" {
+			db.Password = maskedPassword
+		}
+		if db.DestSpec != nil {
+			db.DestSpec = db.DestSpec.masked()
+		}
+		db.MinBackupSize = db.MinDumpSize()
+		eff.Databases[name] = db
+	}
+	return &eff
+}
+
+// MaskPasswords returns the YAML config file in data with database passwords and the
+// secrets and options of inline destinations masked. A secret that is only a ${VAR} reference is
+// kept, since it shows where the secret comes from without revealing it.
+func MaskPasswords(data []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing config file: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return data, nil
+	}
+
+	if databases := mappingValue(doc.Content[0], "databases"); databases != nil && databases.Kind == yaml.MappingNode {
+		for i := 1; i < len(databases.Content); i += 2 {
+			db := databases.Content[i]
+			maskSecret(mappingValue(db, "password"))
+			if dest := mappingValue(db, "dest"); dest != nil {
+				maskSecret(mappingValue(dest, "secret"))
+				if options := mappingValue(dest, "options"); options != nil && options.Kind == yaml.MappingNode {
+					for j := 1; j < len(options.Content); j += 2 {
+						maskSecret(options.Content[j])
+					}
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := encodeYAML(&buf, &doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// maskSecret replaces a secret value node with maskedPassword, unless it is empty or a
+// ${VAR} reference
+func maskSecret(node *yaml.Node) {
+	if node == nil || node.Value == "" || envVarRef.MatchString(node.Value) {
+		return
+	}
+	node.Tag = "!!str"
+	node.Value = maskedPassword
+}
+
+// mappingValue returns the value of key in a YAML mapping node, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// DefaultConnectTimeout is how long to wait for database connections when
+// connect_timeout is not set
+const DefaultConnectTimeout = 5 * time.Second
+
+// ConnectTimeoutDuration returns the parsed connect_timeout, or DefaultConnectTimeout
+// if unset
+func (c *Config) ConnectTimeoutDuration() time.Duration {
+	if d, err := time.ParseDuration(c.ConnectTimeout); err == nil {
+		return d
+	}
+	return DefaultConnectTimeout
+}
+
+// DefaultStallTimeout is how long an upload or download may move no bytes before the
+// TUI reports it as stalled, when stall_timeout is not set
+const DefaultStallTimeout = 2 * time.Minute
+
+// StallTimeoutDuration returns the parsed stall_timeout, or DefaultStallTimeout if unset
+func (c *Config) StallTimeoutDuration() time.Duration {
+	if d, err := time.ParseDuration(c.StallTimeout); err == nil && d > 0 {
+		return d
+	}
+	return DefaultStallTimeout
+}
+
+// DefaultDestinationCacheTTL is how long the TUI reuses a backup listing or destination
+// test when destination_cache_ttl is not set
+const DefaultDestinationCacheTTL = 30 * time.Second
+
+// DestinationCacheDuration returns the parsed destination_cache_ttl, or
+// DefaultDestinationCacheTTL if unset. 0 disables the cache.
+func (c *Config) DestinationCacheDuration() time.Duration {
+	if d, err := time.ParseDuration(c.DestinationCacheTTL); err == nil && d >= 0 {
+		return d
+	}
+	return DefaultDestinationCacheTTL
+}
+
+// RunTimeoutDuration returns the parsed run_timeout, or 0 if unset
+func (c *Config) RunTimeoutDuration() time.Duration {
+	d, _ := time.ParseDuration(c.RunTimeout)
+	return d
+}
+
+// ReportDestination returns where the run report for the given databases should be written,
+// or "" if reports are disabled. Setting report_dest implies reports are enabled.
+func (c *Config) ReportDestination(databases []string) string {
+	if c.ReportDest != "" {
+		return c.ReportDest
+	}
+	if !c.Reports || len(databases) == 0 {
+		return ""
+	}
+	sorted := append([]string(nil), databases...)
+	sort.Strings(sorted)
+	return c.Databases[sorted[0]].Dest
+}
+
+// RestoreAuditPath returns the local log restores are recorded in, or "" if the restore
+// audit is off. Setting restore_audit_log or restore_audit_dest implies it is on.
+func (c *Config) RestoreAuditPath() string {
+	if c.RestoreAuditLog != "" {
+		return c.RestoreAuditLog
+	}
+	if !c.RestoreAudit && c.RestoreAuditDest == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(c.path), "restore_audit.log")
+}
+
+// ExceedsSizeWarning reports whether an estimated dump size is above size_warning_mb.
+// Always false when no threshold is configured.
+func (c *Config) ExceedsSizeWarning(size int64) bool {
+	return c.SizeWarningMB > 0 && size > int64(c.SizeWarningMB)*1024*1024
+}
+
+// DefaultRestoreListLimit is how many backups the TUI restore picker lists at first when
+// restore_list_limit is not set
+const DefaultRestoreListLimit = 200
+
+// RestorePickerLimit returns how many of the newest backups the TUI restore picker lists
+// at first, and how many more each "Load more" adds
+func (c *Config) RestorePickerLimit() int {
+	if c.RestoreListLimit > 0 {
+		return c.RestoreListLimit
+	}
+	return DefaultRestoreListLimit
+}
+
+// DefaultRestoreConcurrency is how many databases a batch restore restores at once when
+// restore_concurrency is not set
+const DefaultRestoreConcurrency = 2
+
+// RestoreBatchConcurrency returns how many databases a batch restore restores at once
+func (c *Config) RestoreBatchConcurrency() int {
+	if c.RestoreConcurrency > 0 {
+		return c.RestoreConcurrency
+	}
+	return DefaultRestoreConcurrency
+}
+
+// DefaultStaleAfter is how old the newest backup of a database without stale_after or
+// keep_days can get before the stale check reports it
+const DefaultStaleAfter = 48 * time.Hour
+
+// StaleThreshold returns how old the newest backup of db can get before the stale check
+// reports it: stale_after when set, otherwise the keep_days of the retention applied to
+// its dest (DestRetention)
+func (c *Config) StaleThreshold(db Database) time.Duration {
+	if d, err := time.ParseDuration(c.StaleAfter); err == nil && d > 0 {
+		return d
+	}
+	if keepDays := db.DestRetention().KeepDays; keepDays > 0 {
+		return time.Duration(keepDays) * 24 * time.Hour
+	}
+	return DefaultStaleAfter
+}
+
+// FailedUploadsDirectory returns where dumps whose upload failed are moved, or "" if
+// keep_on_upload_failure is off (they then stay in the temp directory)
+func (c *Config) FailedUploadsDirectory() string {
+	if !c.KeepOnUploadFailure {
+		return ""
+	}
+	if c.FailedUploadsDir != "" {
+		return c.FailedUploadsDir
+	}
+	return filepath.Join(filepath.Dir(c.path), "failed_uploads")
+}
+
+// StagingDirectory returns where backup --stage leaves dumps until upload-staged
+// uploads them
+func (c *Config) StagingDirectory() string {
+	if c.StagingDir != "" {
+		return c.StagingDir
+	}
+	return filepath.Join(filepath.Dir(c.path), "staged")
+}
+
+// ProfilesDir is the directory next to the default config that holds named profiles
+const ProfilesDir = "profiles"
+
+// ProfilePath returns the config file of the named profile, in the profiles directory
+// of configDir
+func ProfilePath(configDir, profile string) (string, error) {
+	if !ValidName(profile) {
+		return "", fmt.Errorf("profile %q: name must contain only letters, digits, dashes, and underscores", profile)
+	}
+	return filepath.Join(configDir, ProfilesDir, profile+".yaml"), nil
+}
+
+// Profile returns the name of the profile the config was loaded from, or "" if its
+// file is not in a profiles directory
+func (c *Config) Profile() string {
+	dir, file := filepath.Split(c.path)
+	if filepath.Base(dir) != ProfilesDir || filepath.Ext(file) != ".yaml" {
+		return ""
+	}
+	return strings.TrimSuffix(file, ".yaml")
+}
+
+// DictionaryDirectory returns where zstd dictionaries are stored
+func (c *Config) DictionaryDirectory() string {
+	if c.DictionaryDir != "" {
+		return c.DictionaryDir
+	}
+	return filepath.Join(filepath.Dir(c.path), "dictionaries")
+}
+
+// DiagnosticsLogOff is the diagnostics_log value that disables the TUI's diagnostics log
+const DiagnosticsLogOff = "off"
+
+// DiagnosticsLogPath returns where the TUI writes its diagnostics log, empty when it is
+// disabled
+func (c *Config) DiagnosticsLogPath() string {
+	switch c.DiagnosticsLog {
+	case DiagnosticsLogOff:
+		return ""
+	case "":
+		return filepath.Join(filepath.Dir(c.path), "logs", "diagnostics.log")
+	}
+	return c.DiagnosticsLog
+}
+
+// IsFavoriteRemote reports whether the rclone remote is marked as a favorite
+func (c *Config) IsFavoriteRemote(name string) bool {
+	for _, fav := range c.FavoriteRemotes {
+		if fav == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SetFavoriteRemote marks or unmarks an rclone remote as a favorite
+func (c *Config) SetFavoriteRemote(name string, favorite bool) {
+	if favorite {
+		if !c.IsFavoriteRemote(name) {
+			c.FavoriteRemotes = append(c.FavoriteRemotes, name)
+		}
+		return
+	}
+	for i, fav := range c.FavoriteRemotes {
+		if fav == name {
+			c.FavoriteRemotes = append(c.FavoriteRemotes[:i], c.FavoriteRemotes[i+1:]...)
+			return
+		}
+	}
+}
+
+// ReadDatabaseList reads a newline-separated list of database names, as used by
+// --databases-from. Blank lines and lines starting with # are ignored, as is anything
+// after a # on a line.
+func ReadDatabaseList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading database list: %w", err)
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		name := strings.TrimSpace(line)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// SelectDatabases splits names into those present in the config and those that are
+// not, preserving the given order
+func (c *Config) SelectDatabases(names []string) (selected, unknown []string) {
+	for _, name := range names {
+		if _, ok := c.Databases[name]; ok {
+			selected = append(selected, name)
+		} else {
+			unknown = append(unknown, name)
+		}
+	}
+	return selected, unknown
+}
+
+// DatabasesExcept removes the excluded names from names, or from all configured
+// databases (sorted) when names is empty. Excluded names that are not configured are
+// returned as unknown.
+func (c *Config) DatabasesExcept(names, exclude []string) (kept, unknown []string) {
+	if len(names) == 0 {
+		for name := range c.Databases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	excluded := make(map[string]bool)
+	for _, name := range exclude {
+		if _, ok := c.Databases[name]; !ok {
+			unknown = append(unknown, name)
+		}
+		excluded[name] = true
+	}
+	for _, name := range names {
+		if !excluded[name] {
+			kept = append(kept, name)
+		}
+	}
+	return kept, unknown
+}
+
+// validateConsistencyGroups checks that the databases of each consistency group can be
+// dumped at the same point: they must be on the same server, not wait for other databases
+// (after) and, for postgres, dump the same database, since a snapshot can't be imported
+// into another database
+func (c *Config) validateConsistencyGroups() error {
+	first := make(map[string]string) // first database of each group, by name
+	for _, name := range c.sortedNames() {
+		db := c.Databases[name]
+		group := db.ConsistencyGroup
+		if group == "" {
+			continue
+		}
+		if !ValidName(group) {
+			return fmt.Errorf("database %q: consistency_group must contain only letters, digits, dashes, and underscores", name)
+		}
+		if db.Type != "mysql" && db.Type != "postgres" {
+			return fmt.Errorf("database %q: consistency_group is only supported for mysql and postgres", name)
+		}
+		if db.Type == "postgres" && db.AllDatabases() {
+			return fmt.Errorf("database %q: consistency_group can't be used with database \"*\" on postgres (a snapshot can't be shared across databases)", name)
+		}
+		if len(db.After) > 0 {
+			// Waiting for another database would keep the group's session (a read lock
+			// on mysql) open for as long
+			return fmt.Errorf("database %q: consistency_group can't be combined with after (the members of a group dump together)", name)
+		}
+
+		other, ok := first[group]
+		if !ok {
+			first[group] = name
+			continue
+		}
+		o := c.Databases[other]
+		src, oSrc := db.DumpSource(), o.DumpSource()
+		if db.Type != o.Type || src.Host != oSrc.Host || src.Port != oSrc.Port {
+			return fmt.Errorf("database %q: consistency_group %q: must be on the same server as %q", name, group, other)
+		}
+		if db.Type == "postgres" && db.Database != o.Database {
+			return fmt.Errorf("database %q: consistency_group %q: must dump the same database as %q (a postgres snapshot can't be shared across databases)", name, group, other)
+		}
+	}
+	return nil
+}
+
+// validateAfter checks that after only names configured databases and that the
+// dependencies have no cycle, which would leave backups waiting on each other forever
+func (c *Config) validateAfter() error {
+	for _, name := range c.sortedNames() {
+		for _, dep := range c.Databases[name].After {
+			if dep == name {
+				return fmt.Errorf("database %q: after must not list the database itself", name)
+			}
+			if _, ok := c.Databases[dep]; !ok {
+				return fmt.Errorf("database %q: after: unknown database %q", name, dep)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			start := slices.Index(path, name)
+			cycle := append(append([]string(nil), path[start:]...), name)
+			return fmt.Errorf("database %q: after: dependency cycle %s", name, strings.Join(cycle, " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range c.Databases[name].After {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+	for _, name := range c.sortedNames() {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePresets checks that presets have a usable name, only name configured databases,
+// and don't combine run options that contradict each other
+func (c *Config) validatePresets() error {
+	for _, name := range slices.Sorted(maps.Keys(c.Presets)) {
+		preset := c.Presets[name]
+		if !ValidName(name) {
+			return fmt.Errorf("preset %q: name must contain only letters, digits, dashes, and underscores", name)
+		}
+		if len(preset.Databases) == 0 {
+			return fmt.Errorf("preset %q: databases is required", name)
+		}
+		for _, db := range preset.Databases {
+			if _, ok := c.Databases[db]; !ok {
+				return fmt.Errorf("preset %q: unknown database %q", name, db)
+			}
+		}
+		if preset.RetentionDryRun && (preset.DryRun || preset.SkipRetention) {
+			return fmt.Errorf("preset %q: retention_dry_run cannot be combined with dry_run or skip_retention", name)
+		}
+	}
+	return nil
+}
+
+// RenameDependency updates the after lists and presets that name oldName to newName, or
+// removes oldName from them when newName is empty (the database was deleted). A preset
+// left without databases is removed.
+func (c *Config) RenameDependency(oldName, newName string) {
+	for name, db := range c.Databases {
+		if !slices.Contains(db.After, oldName) {
+			continue
+		}
+		var after []string
+		for _, dep := range db.After {
+			switch {
+			case dep != oldName:
+				after = append(after, dep)
+			case newName != "":
+				after = append(after, newName)
+			}
+		}
+		db.After = after
+		c.Databases[name] = db
+	}
+
+	for name, preset := range c.Presets {
+		if !slices.Contains(preset.Databases, oldName) {
+			continue
+		}
+		var databases []string
+		for _, db := range preset.Databases {
+			switch {
+			case db != oldName:
+				databases = append(databases, db)
+			case newName != "":
+				databases = append(databases, newName)
+			}
+		}
+		if len(databases) == 0 {
+			delete(c.Presets, name)
+			continue
+		}
+		preset.Databases = databases
+		c.Presets[name] = preset
+	}
+}
+
+// InlineDests returns the inline destinations of the databases, by the remote name their
+// dest refers to
+func (c *Config) InlineDests() map[string]*InlineDest {
+	dests := make(map[string]*InlineDest)
+	for _, db := range c.Databases {
+		if db.DestSpec != nil {
+			dests[db.DestSpec.Remote()] = db.DestSpec
+		}
+	}
+	return dests
+}
+
+// SharedDestinations returns a warning for each dest used by more than one database.
+// Their backups are told apart by file name only, so a dest per database is safer.
+func (c *Config) SharedDestinations() []string {
+	byDest := make(map[string][]string)
+	var dests []string
+	for _, name := range c.sortedNames() {
+		dest := c.Databases[name].Dest
+		if _, ok := byDest[dest]; !ok {
+			dests = append(dests, dest)
+		}
+		byDest[dest] = append(byDest[dest], name)
+	}
+
+	var warnings []string
+	for _, dest := range dests {
+		if names := byDest[dest]; len(names) > 1 {
+			warnings = append(warnings, fmt.Sprintf("databases %s share dest %q (kept apart by file name only)", strings.Join(quoteAll(names), ", "), dest))
+		}
+	}
+	return warnings
+}
+
+// sortedNames returns the configured database names in sorted order
+func (c *Config) sortedNames() []string {
+	names := make([]string, 0, len(c.Databases))
+	for name := range c.Databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// quoteAll returns each string quoted with %q
+func quoteAll(ss []string) []string {
+	quoted := make([]string, len(ss))
+	for i, s := range ss {
+		quoted[i] = fmt.Sprintf("%q", s)
+	}
+	return quoted
+}
+
 // Path returns the config file path
 func (c *Config) Path() string {
 	return c.path
@@ -143,14 +1421,73 @@ func (c *Config) Path() string {
 // validNamePattern matches only letters, digits, dashes, and underscores
 var validNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 
+// ValidName reports whether name can be used as a database entry name
+func ValidName(name string) bool {
+	return validNamePattern.MatchString(name)
+}
+
 func (c *Config) Validate() error {
 	if len(c.Databases) == 0 {
 		return fmt.Errorf("no databases configured")
 	}
 
+	if c.RunTimeout != "" {
+		if d, err := time.ParseDuration(c.RunTimeout); err != nil || d <= 0 {
+			return fmt.Errorf("run_timeout must be a positive duration (e.g. 30m, 2h)")
+		}
+	}
+
+	if c.ConnectTimeout != "" {
+		if d, err := time.ParseDuration(c.ConnectTimeout); err != nil || d <= 0 {
+			return fmt.Errorf("connect_timeout must be a positive duration (e.g. 10s, 1m)")
+		}
+	}
+
+	if c.DestinationCacheTTL != "" {
+		if d, err := time.ParseDuration(c.DestinationCacheTTL); err != nil || d < 0 {
+			return fmt.Errorf("destination_cache_ttl must be a duration (e.g. 30s, 2m; 0 disables)")
+		}
+	}
+
+	if c.StallTimeout != "" {
+		if d, err := time.ParseDuration(c.StallTimeout); err != nil || d <= 0 {
+			return fmt.Errorf("stall_timeout must be a positive duration (e.g. 30s, 5m)")
+		}
+	}
+
+	if c.SizeWarningMB < 0 {
+		return fmt.Errorf("size_warning_mb must not be negative")
+	}
+
+	if c.DownloadStreams < 0 {
+		return fmt.Errorf("download_streams must not be negative")
+	}
+
+	if c.DownloadAttempts < 0 {
+		return fmt.Errorf("download_attempts must not be negative")
+	}
+
+	if c.RestoreListLimit < 0 {
+		return fmt.Errorf("restore_list_limit must not be negative")
+	}
+
+	if c.RetentionListCheckers < 0 {
+		return fmt.Errorf("retention_list_checkers must not be negative")
+	}
+
+	if c.RetentionDeleteWorkers < 0 {
+		return fmt.Errorf("retention_delete_workers must not be negative")
+	}
+
+	if c.StaleAfter != "" {
+		if d, err := time.ParseDuration(c.StaleAfter); err != nil || d <= 0 {
+			return fmt.Errorf("stale_after must be a positive duration (e.g. 36h, 168h)")
+		}
+	}
+
 	for name, db := range c.Databases {
 		// Validate database name (must be filename-safe)
-		if !validNamePattern.MatchString(name) {
+		if !ValidName(name) {
 			return fmt.Errorf("database %q: name must contain only letters, digits, dashes, and underscores", name)
 		}
 
@@ -172,10 +1509,30 @@ func (c *Config) Validate() error {
 		default:
 			return fmt.Errorf("database %q: unknown type %q", name, db.Type)
 		}
+		if db.HasDumpSource() && db.Type == "file" {
+			return fmt.Errorf("database %q: dump_host and dump_port are only supported for mysql and postgres", name)
+		}
+		if db.DumpPort < 0 {
+			return fmt.Errorf("database %q: dump_port must be positive", name)
+		}
 
+		if db.DestTiers != nil {
+			if err := db.DestTiers.Validate(); err != nil {
+				return fmt.Errorf("database %q: dest: %w", name, err)
+			}
+		}
 		if db.Dest == "" {
 			return fmt.Errorf("database %q: dest is required", name)
 		}
+		if db.DestSpec != nil {
+			if err := db.DestSpec.Validate(); err != nil {
+				return fmt.Errorf("database %q: dest: %w", name, err)
+			}
+		}
+
+		if db.FilePrefix != "" && !ValidName(db.FilePrefix) {
+			return fmt.Errorf("database %q: file_prefix must contain only letters, digits, dashes, and underscores", name)
+		}
 
 		validCompressions := map[string]bool{
 			"none": true, "gz": true, "zstd": true, "xz": true, "zip": true,
@@ -183,6 +1540,155 @@ func (c *Config) Validate() error {
 		if !validCompressions[db.Compression] {
 			return fmt.Errorf("database %q: compression must be one of: none, gz, zstd, xz, zip", name)
 		}
+
+		if db.Encryption != nil {
+			if db.Encryption.Type != "gpg" {
+				return fmt.Errorf("database %q: encryption.type must be gpg", name)
+			}
+			if len(db.Encryption.Recipients) == 0 {
+				return fmt.Errorf("database %q: encryption.recipients is required", name)
+			}
+		}
+
+		if db.GroupBy != "" && db.GroupBy != "day" && db.GroupBy != "month" {
+			return fmt.Errorf("database %q: group_by must be day or month", name)
+		}
+
+		if db.AtomicUpload && db.Immutable {
+			return fmt.Errorf("database %q: atomic_upload cannot be used with an immutable destination", name)
+		}
+		if db.BackupIndex && db.Immutable {
+			return fmt.Errorf("database %q: backup_index cannot be used with an immutable destination (the index is rewritten after each backup)", name)
+		}
+
+		if db.MinBackupSize < 0 {
+			return fmt.Errorf("database %q: min_backup_size must not be negative", name)
+		}
+
+		if db.SizeAlert.Factor != 0 && db.SizeAlert.Factor <= 1 {
+			return fmt.Errorf("database %q: size_alert.factor must be greater than 1", name)
+		}
+		if db.SizeAlert.History < 0 {
+			return fmt.Errorf("database %q: size_alert.history must not be negative", name)
+		}
+		if db.SizeAlert.History > 0 && !db.SizeAlert.Enabled() {
+			return fmt.Errorf("database %q: size_alert.history requires size_alert.factor", name)
+		}
+
+		if db.PostUploadVerifyMaxMB < 0 {
+			return fmt.Errorf("database %q: post_upload_verify_max_mb must not be negative", name)
+		}
+		if db.PostUploadVerifyMaxMB > 0 && !db.PostUploadVerify {
+			return fmt.Errorf("database %q: post_upload_verify_max_mb requires post_upload_verify", name)
+		}
+
+		if db.VerifyOnRetention < 0 {
+			return fmt.Errorf("database %q: verify_on_retention must not be negative", name)
+		}
+
+		if db.ZstdDictionary != 0 && db.Compression != "zstd" {
+			return fmt.Errorf("database %q: zstd_dictionary requires zstd compression", name)
+		}
+
+		if db.ConnectTimeout != "" {
+			if db.Type != "mysql" && db.Type != "postgres" {
+				return fmt.Errorf("database %q: connect_timeout is only supported for mysql and postgres", name)
+			}
+			if d, err := time.ParseDuration(db.ConnectTimeout); err != nil || d <= 0 {
+				return fmt.Errorf("database %q: connect_timeout must be a positive duration (e.g. 10s, 1m)", name)
+			}
+		}
+
+		if db.Charset != "" {
+			if db.Type != "mysql" && db.Type != "postgres" {
+				return fmt.Errorf("database %q: charset is only supported for mysql and postgres", name)
+			}
+			if !validCharset(db.Type, db.Charset) {
+				return fmt.Errorf("database %q: charset %q is not a %s character set", name, db.Charset, db.Type)
+			}
+		}
+
+		if len(db.Redact) > 0 {
+			if db.Type != "mysql" && db.Type != "postgres" {
+				return fmt.Errorf("database %q: redact is only supported for mysql and postgres", name)
+			}
+			if _, err := db.RedactRules(); err != nil {
+				return fmt.Errorf("database %q: %w", name, err)
+			}
+		}
+
+		if db.VacuumAnalyze && db.Type != "postgres" {
+			return fmt.Errorf("database %q: vacuum_analyze is only supported for postgres", name)
+		}
+
+		if db.MariaDB && db.Type != "mysql" {
+			return fmt.Errorf("database %q: mariadb is only supported for mysql", name)
+		}
+
+		if db.RestoreNoTransaction && db.Type != "mysql" && db.Type != "postgres" {
+			return fmt.Errorf("database %q: restore_no_transaction is only supported for mysql and postgres", name)
+		}
+
+		if len(db.ExcludeDatabases) > 0 {
+			if !db.AllDatabases() {
+				return fmt.Errorf("database %q: exclude_databases requires database \"*\"", name)
+			}
+			for _, pattern := range db.ExcludeDatabases {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return fmt.Errorf("database %q: invalid exclude_databases pattern %q", name, pattern)
+				}
+			}
+		}
+
+		if db.ArchiveExt != "" {
+			if db.Type != "file" {
+				return fmt.Errorf("database %q: archive_ext is only supported for file type", name)
+			}
+			if !archiveExtPattern.MatchString(db.ArchiveExt) {
+				return fmt.Errorf("database %q: archive_ext must be an extension such as .dat, got %q", name, db.ArchiveExt)
+			}
+			if slices.Contains(reservedExts, strings.ToLower(filepath.Ext(db.FileExt()))) {
+				return fmt.Errorf("database %q: archive_ext %q ends in an extension blobber reserves for compression, encryption, checksums or partial uploads", name, db.ArchiveExt)
+			}
+		}
+
+		switch db.RestoreMode {
+		case "", RestoreUpsert, RestoreCreateOnly, RestoreOverwriteOnly:
+		default:
+			return fmt.Errorf("database %q: restore_mode must be create-only, overwrite-only or upsert, got %q", name, db.RestoreMode)
+		}
+
+		if db.RestoreFileMode != "" {
+			if db.Type != "file" {
+				return fmt.Errorf("database %q: restore_file_mode is only supported for file type", name)
+			}
+			if _, _, err := db.RestoreFilePerm(); err != nil {
+				return fmt.Errorf("database %q: %w", name, err)
+			}
+		}
+	}
+
+	if err := c.validateAfter(); err != nil {
+		return err
+	}
+	if err := c.validateConsistencyGroups(); err != nil {
+		return err
+	}
+
+	if err := c.validatePresets(); err != nil {
+		return err
+	}
+
+	// Retention matches backup files by prefix case-insensitively, so databases whose
+	// prefixes differ only in case would delete each other's backups in a shared dest
+	seen := make(map[string]string)
+	for _, name := range c.sortedNames() {
+		db := c.Databases[name]
+		key := strings.ToLower(db.BackupPrefix(name)) + "\x00" + db.Dest
+		if other, ok := seen[key]; ok {
+			return fmt.Errorf("database %q: shares dest %q with %q, and their backup file prefixes differ only in case", name, db.Dest, other)
+		}
+		seen[key] = name
 	}
 
 	return nil
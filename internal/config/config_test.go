@@ -1,9 +1,15 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestExpandEnvVars(t *testing.T) {
@@ -173,209 +179,1551 @@ func TestValidate(t *testing.T) {
 			}},
 			wantErr: "",
 		},
+		{
+			name: "valid run timeout",
+			cfg: Config{RunTimeout: "2h", Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none"},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "invalid run timeout",
+			cfg: Config{RunTimeout: "soon", Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none"},
+			}},
+			wantErr: "run_timeout must be a positive duration",
+		},
+		{
+			name: "valid stale_after",
+			cfg: Config{StaleCheck: true, StaleAfter: "36h", Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none"},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "invalid stale_after",
+			cfg: Config{StaleAfter: "2d", Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none"},
+			}},
+			wantErr: "stale_after must be a positive duration",
+		},
+		{
+			name: "negative retention_delete_workers",
+			cfg: Config{RetentionListCheckers: 4, RetentionDeleteWorkers: -1, Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none"},
+			}},
+			wantErr: "retention_delete_workers must not be negative",
+		},
+		{
+			name: "valid connect timeouts",
+			cfg: Config{ConnectTimeout: "15s", Databases: map[string]Database{
+				"mydb": {Type: "postgres", Host: "localhost", User: "u", Database: "app", Dest: "/backup", Compression: "none", ConnectTimeout: "1m"},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "invalid connect timeout",
+			cfg: Config{ConnectTimeout: "0s", Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none"},
+			}},
+			wantErr: "connect_timeout must be a positive duration",
+		},
+		{
+			name: "invalid database connect timeout",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "mysql", Host: "localhost", User: "u", Database: "app", Dest: "/backup", Compression: "none", ConnectTimeout: "30"},
+			}},
+			wantErr: `database "mydb": connect_timeout must be a positive duration`,
+		},
+		{
+			name: "connect timeout on a file database",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", ConnectTimeout: "30s"},
+			}},
+			wantErr: "connect_timeout is only supported for mysql and postgres",
+		},
+		{
+			name: "mysql charset",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "mysql", Host: "localhost", User: "u", Database: "app", Dest: "/backup", Compression: "none", Charset: "latin1"},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "postgres encoding in any case",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "postgres", Host: "localhost", User: "u", Database: "app", Dest: "/backup", Compression: "none", Charset: "win1252"},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "unknown charset",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "mysql", Host: "localhost", User: "u", Database: "app", Dest: "/backup", Compression: "none", Charset: "latin-1"},
+			}},
+			wantErr: `database "mydb": charset "latin-1" is not a mysql character set`,
+		},
+		{
+			name: "postgres encoding on mysql",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "mysql", Host: "localhost", User: "u", Database: "app", Dest: "/backup", Compression: "none", Charset: "WIN1252"},
+			}},
+			wantErr: "is not a mysql character set",
+		},
+		{
+			name: "charset on a file database",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", Charset: "utf8mb4"},
+			}},
+			wantErr: "charset is only supported for mysql and postgres",
+		},
+		{
+			name: "vacuum analyze on postgres",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "postgres", Host: "localhost", User: "u", Database: "d", Dest: "/backup", Compression: "none", VacuumAnalyze: true},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "vacuum analyze on mysql",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "mysql", Host: "localhost", User: "u", Database: "d", Dest: "/backup", Compression: "none", VacuumAnalyze: true},
+			}},
+			wantErr: "vacuum_analyze is only supported for postgres",
+		},
+		{
+			name: "mariadb on postgres",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "postgres", Host: "localhost", User: "u", Database: "d", Dest: "/backup", Compression: "none", MariaDB: true},
+			}},
+			wantErr: "mariadb is only supported for mysql",
+		},
+		{
+			name: "restore file mode",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", RestoreFileMode: "0600"},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "invalid restore file mode",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", RestoreFileMode: "rw-------"},
+			}},
+			wantErr: "restore_file_mode must be an octal permission",
+		},
+		{
+			name: "restore file mode on postgres",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "postgres", Host: "localhost", User: "u", Database: "d", Dest: "/backup", Compression: "none", RestoreFileMode: "0600"},
+			}},
+			wantErr: "restore_file_mode is only supported for file type",
+		},
+		{
+			name: "restore mode",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "postgres", Host: "localhost", User: "u", Database: "d", Dest: "/backup", Compression: "none", RestoreMode: "create-only"},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "invalid restore mode",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", RestoreMode: "create"},
+			}},
+			wantErr: "restore_mode must be create-only, overwrite-only or upsert",
+		},
+		{
+			name: "all databases with exclusions",
+			cfg: Config{Databases: map[string]Database{
+				"server": {Type: "mysql", Host: "localhost", User: "u", Database: "*", Dest: "/backup", Compression: "none", ExcludeDatabases: []string{"tmp_*"}},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "exclusions without all databases",
+			cfg: Config{Databases: map[string]Database{
+				"server": {Type: "mysql", Host: "localhost", User: "u", Database: "app", Dest: "/backup", Compression: "none", ExcludeDatabases: []string{"tmp_*"}},
+			}},
+			wantErr: "exclude_databases requires database",
+		},
+		{
+			name: "invalid exclusion pattern",
+			cfg: Config{Databases: map[string]Database{
+				"server": {Type: "postgres", Host: "localhost", User: "u", Database: "*", Dest: "/backup", Compression: "none", ExcludeDatabases: []string{"[tmp"}},
+			}},
+			wantErr: "invalid exclude_databases pattern",
+		},
+		{
+			name: "negative size warning",
+			cfg: Config{SizeWarningMB: -1, Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none"},
+			}},
+			wantErr: "size_warning_mb must not be negative",
+		},
+		{
+			name: "negative download attempts",
+			cfg: Config{DownloadAttempts: -1, Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none"},
+			}},
+			wantErr: "download_attempts must not be negative",
+		},
+		{
+			name: "invalid stall timeout",
+			cfg: Config{StallTimeout: "90", Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none"},
+			}},
+			wantErr: "stall_timeout must be a positive duration",
+		},
+		{
+			name: "destination cache disabled",
+			cfg: Config{DestinationCacheTTL: "0", Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none"},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "negative destination cache ttl",
+			cfg: Config{DestinationCacheTTL: "-5s", Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none"},
+			}},
+			wantErr: "destination_cache_ttl must be a duration",
+		},
+		{
+			name: "names differing only in case share a dest",
+			cfg: Config{Databases: map[string]Database{
+				"App": {Type: "file", Path: "/a", Dest: "/backup", Compression: "none"},
+				"app": {Type: "file", Path: "/b", Dest: "/backup", Compression: "none"},
+			}},
+			wantErr: "their backup file prefixes differ only in case",
+		},
+		{
+			name: "file prefix matching another database in a shared dest",
+			cfg: Config{Databases: map[string]Database{
+				"prod":         {Type: "file", Path: "/a", Dest: "/backup", Compression: "none"},
+				"prod_primary": {Type: "file", Path: "/b", Dest: "/backup", Compression: "none", FilePrefix: "Prod"},
+			}},
+			wantErr: "their backup file prefixes differ only in case",
+		},
+		{
+			name: "valid file prefix",
+			cfg: Config{Databases: map[string]Database{
+				"prod_primary_mysql": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", FilePrefix: "prod"},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "invalid file prefix",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", FilePrefix: "prod/db"},
+			}},
+			wantErr: "file_prefix must contain only letters, digits, dashes, and underscores",
+		},
+		{
+			name: "names differing only in case, separate dests",
+			cfg: Config{Databases: map[string]Database{
+				"App": {Type: "file", Path: "/a", Dest: "/backup/a", Compression: "none"},
+				"app": {Type: "file", Path: "/b", Dest: "/backup/b", Compression: "none"},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "atomic upload to an immutable destination",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", AtomicUpload: true, Immutable: true},
+			}},
+			wantErr: "atomic_upload cannot be used with an immutable destination",
+		},
+		{
+			name: "backup index on an immutable destination",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", BackupIndex: true, Immutable: true},
+			}},
+			wantErr: "backup_index cannot be used with an immutable destination",
+		},
+		{
+			name: "gpg encryption",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "gz", Encryption: &Encryption{Type: "gpg", Recipients: []string{"ops@example.com"}}},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "unknown encryption type",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "gz", Encryption: &Encryption{Type: "age", Recipients: []string{"age1xyz"}}},
+			}},
+			wantErr: "encryption.type must be gpg",
+		},
+		{
+			name: "encryption without recipients",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "gz", Encryption: &Encryption{Type: "gpg"}},
+			}},
+			wantErr: "encryption.recipients is required",
+		},
+		{
+			name: "group by day",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", GroupBy: "day"},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "negative min backup size",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", MinBackupSize: -1},
+			}},
+			wantErr: `database "mydb": min_backup_size must not be negative`,
+		},
+		{
+			name: "size alert",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", SizeAlert: SizeAlert{Factor: 2, History: 10}},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "size alert factor of 1",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", SizeAlert: SizeAlert{Factor: 1}},
+			}},
+			wantErr: `database "mydb": size_alert.factor must be greater than 1`,
+		},
+		{
+			name: "size alert history without factor",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", SizeAlert: SizeAlert{History: 10}},
+			}},
+			wantErr: `database "mydb": size_alert.history requires size_alert.factor`,
+		},
+		{
+			name: "archive ext",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "gz", ArchiveExt: "dat"},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "archive ext on mysql",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "mysql", Host: "localhost", User: "root", Database: "db", Dest: "/backup", Compression: "none", ArchiveExt: ".dat"},
+			}},
+			wantErr: `database "mydb": archive_ext is only supported for file type`,
+		},
+		{
+			name: "archive ext with a slash",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", ArchiveExt: "../dat"},
+			}},
+			wantErr: `database "mydb": archive_ext must be an extension such as .dat, got "../dat"`,
+		},
+		{
+			name: "archive ext of a compression",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", ArchiveExt: ".tar.GZ"},
+			}},
+			wantErr: `database "mydb": archive_ext ".tar.GZ" ends in an extension blobber reserves for compression, encryption, checksums or partial uploads`,
+		},
+		{
+			name: "group by week",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", GroupBy: "week"},
+			}},
+			wantErr: `database "mydb": group_by must be day or month`,
+		},
+		{
+			name: "redact on mysql",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "mysql", Host: "localhost", User: "u", Database: "d", Dest: "/backup", Compression: "none", Redact: []string{"users.email:hash", "public.users.token"}},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "redact without a column",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "postgres", Host: "localhost", User: "u", Database: "d", Dest: "/backup", Compression: "none", Redact: []string{"users"}},
+			}},
+			wantErr: `database "mydb": redact entry "users" must be table.column`,
+		},
+		{
+			name: "redact with an unknown action",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "postgres", Host: "localhost", User: "u", Database: "d", Dest: "/backup", Compression: "none", Redact: []string{"users.email:mask"}},
+			}},
+			wantErr: "action must be null or hash",
+		},
+		{
+			name: "redact on a file database",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", Redact: []string{"users.email"}},
+			}},
+			wantErr: "redact is only supported for mysql and postgres",
+		},
+		{
+			name: "zstd dictionary without zstd",
+			cfg: Config{Databases: map[string]Database{
+				"mydb": {Type: "file", Path: "/test", Dest: "/backup", Compression: "gz", ZstdDictionary: 40000},
+			}},
+			wantErr: "zstd_dictionary requires zstd compression",
+		},
+		{
+			name: "after chain",
+			cfg: Config{Databases: map[string]Database{
+				"app":       {Type: "file", Path: "/test", Dest: "/backup", Compression: "none"},
+				"analytics": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", After: []string{"app"}},
+				"reports":   {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", After: []string{"analytics", "app"}},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "after unknown database",
+			cfg: Config{Databases: map[string]Database{
+				"analytics": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", After: []string{"app"}},
+			}},
+			wantErr: `after: unknown database "app"`,
+		},
+		{
+			name: "preset",
+			cfg: Config{Databases: map[string]Database{
+				"app": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none"},
+			}, Presets: map[string]Preset{"nightly": {Databases: []string{"app"}, RetentionDryRun: true}}},
+			wantErr: "",
+		},
+		{
+			name: "preset unknown database",
+			cfg: Config{Databases: map[string]Database{
+				"app": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none"},
+			}, Presets: map[string]Preset{"nightly": {Databases: []string{"app", "old"}}}},
+			wantErr: `preset "nightly": unknown database "old"`,
+		},
+		{
+			name: "preset without databases",
+			cfg: Config{Databases: map[string]Database{
+				"app": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none"},
+			}, Presets: map[string]Preset{"nightly": {}}},
+			wantErr: `preset "nightly": databases is required`,
+		},
+		{
+			name: "preset contradicting options",
+			cfg: Config{Databases: map[string]Database{
+				"app": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none"},
+			}, Presets: map[string]Preset{"nightly": {Databases: []string{"app"}, RetentionDryRun: true, DryRun: true}}},
+			wantErr: "retention_dry_run cannot be combined with dry_run or skip_retention",
+		},
+		{
+			name: "after itself",
+			cfg: Config{Databases: map[string]Database{
+				"app": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", After: []string{"app"}},
+			}},
+			wantErr: "after must not list the database itself",
+		},
+		{
+			name: "after cycle",
+			cfg: Config{Databases: map[string]Database{
+				"a": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", After: []string{"c"}},
+				"b": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", After: []string{"a"}},
+				"c": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", After: []string{"b"}},
+			}},
+			wantErr: "dependency cycle a -> c -> b -> a",
+		},
+		{
+			name: "valid consistency groups",
+			cfg: Config{Databases: map[string]Database{
+				"orders":   {Type: "mysql", Host: "db", User: "u", Database: "orders", Dest: "/backup", Compression: "none", ConsistencyGroup: "shop"},
+				"stock":    {Type: "mysql", Host: "db", User: "u", Database: "stock", Dest: "/backup", Compression: "none", ConsistencyGroup: "shop"},
+				"app":      {Type: "postgres", Host: "pg", User: "u", Database: "app", Dest: "/backup", Compression: "none", ConsistencyGroup: "app"},
+				"app_anon": {Type: "postgres", Host: "pg", User: "u", Database: "app", Dest: "/staging", Compression: "none", ConsistencyGroup: "app"},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "consistency group on a file database",
+			cfg: Config{Databases: map[string]Database{
+				"app": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", ConsistencyGroup: "shop"},
+			}},
+			wantErr: "consistency_group is only supported for mysql and postgres",
+		},
+		{
+			name: "consistency group across servers",
+			cfg: Config{Databases: map[string]Database{
+				"orders": {Type: "mysql", Host: "db1", User: "u", Database: "orders", Dest: "/backup", Compression: "none", ConsistencyGroup: "shop"},
+				"stock":  {Type: "mysql", Host: "db2", User: "u", Database: "stock", Dest: "/backup", Compression: "none", ConsistencyGroup: "shop"},
+			}},
+			wantErr: `database "stock": consistency_group "shop": must be on the same server as "orders"`,
+		},
+		{
+			name: "postgres consistency group across databases",
+			cfg: Config{Databases: map[string]Database{
+				"app":   {Type: "postgres", Host: "pg", User: "u", Database: "app", Dest: "/backup", Compression: "none", ConsistencyGroup: "pg"},
+				"users": {Type: "postgres", Host: "pg", User: "u", Database: "users", Dest: "/backup", Compression: "none", ConsistencyGroup: "pg"},
+			}},
+			wantErr: "must dump the same database as",
+		},
+		{
+			name: "consistency group member waiting for another database",
+			cfg: Config{Databases: map[string]Database{
+				"orders": {Type: "mysql", Host: "db", User: "u", Database: "orders", Dest: "/backup", Compression: "none", ConsistencyGroup: "shop"},
+				"stock":  {Type: "mysql", Host: "db", User: "u", Database: "stock", Dest: "/backup", Compression: "none", ConsistencyGroup: "shop", After: []string{"orders"}},
+			}},
+			wantErr: `database "stock": consistency_group can't be combined with after`,
+		},
+		{
+			name: "consistency group dumping from another server",
+			cfg: Config{Databases: map[string]Database{
+				"orders": {Type: "mysql", Host: "db", User: "u", Database: "orders", Dest: "/backup", Compression: "none", ConsistencyGroup: "shop", DumpHost: "replica"},
+				"stock":  {Type: "mysql", Host: "db", User: "u", Database: "stock", Dest: "/backup", Compression: "none", ConsistencyGroup: "shop"},
+			}},
+			wantErr: `database "stock": consistency_group "shop": must be on the same server as "orders"`,
+		},
+		{
+			name: "valid dump host",
+			cfg: Config{Databases: map[string]Database{
+				"app": {Type: "mysql", Host: "primary", User: "u", Database: "app", Dest: "/backup", Compression: "none", DumpHost: "replica", DumpPort: 3307},
+			}},
+			wantErr: "",
+		},
+		{
+			name: "dump host on a file database",
+			cfg: Config{Databases: map[string]Database{
+				"app": {Type: "file", Path: "/test", Dest: "/backup", Compression: "none", DumpHost: "replica"},
+			}},
+			wantErr: "dump_host and dump_port are only supported for mysql and postgres",
+		},
+		{
+			name: "negative dump port",
+			cfg: Config{Databases: map[string]Database{
+				"app": {Type: "postgres", Host: "primary", User: "u", Database: "app", Dest: "/backup", Compression: "none", DumpPort: -1},
+			}},
+			wantErr: "dump_port must be positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+			} else {
+				if err == nil {
+					t.Errorf("Validate() error = nil, want error containing %q", tt.wantErr)
+				} else if !contains(err.Error(), tt.wantErr) {
+					t.Errorf("Validate() error = %q, want error containing %q", err.Error(), tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    Database
+		wantComp string
+		wantPort int
+	}{
+		{
+			name:     "empty compression defaults to none",
+			input:    Database{Type: "file"},
+			wantComp: "none",
+			wantPort: 0,
+		},
+		{
+			name:     "mysql default port",
+			input:    Database{Type: "mysql"},
+			wantComp: "none",
+			wantPort: 3306,
+		},
+		{
+			name:     "postgres default port",
+			input:    Database{Type: "postgres"},
+			wantComp: "none",
+			wantPort: 5432,
+		},
+		{
+			name:     "custom port not overwritten",
+			input:    Database{Type: "mysql", Port: 3307},
+			wantComp: "none",
+			wantPort: 3307,
+		},
+		{
+			name:     "custom compression not overwritten",
+			input:    Database{Type: "file", Compression: "gz"},
+			wantComp: "gz",
+			wantPort: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Databases: map[string]Database{"test": tt.input}}
+			cfg.applyDefaults()
+			db := cfg.Databases["test"]
+			if db.Compression != tt.wantComp {
+				t.Errorf("Compression = %q, want %q", db.Compression, tt.wantComp)
+			}
+			if db.Port != tt.wantPort {
+				t.Errorf("Port = %d, want %d", db.Port, tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestLoadOrEmpty(t *testing.T) {
+	t.Run("nonexistent file returns empty config", func(t *testing.T) {
+		cfg, err := LoadOrEmpty("/nonexistent/path/blobber.yaml")
+		if err != nil {
+			t.Fatalf("LoadOrEmpty() error = %v, want nil", err)
+		}
+		if cfg == nil {
+			t.Fatal("LoadOrEmpty() returned nil config")
+		}
+		if len(cfg.Databases) != 0 {
+			t.Errorf("Databases = %v, want empty map", cfg.Databases)
+		}
+	})
+
+	t.Run("valid file loads correctly", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		cfgPath := filepath.Join(tmpDir, "blobber.yaml")
+		content := `databases:
+  mydb:
+    type: file
+    path: /data/test.db
+    dest: /backups
+    compression: gz
+`
+		if err := os.WriteFile(cfgPath, []byte(content), 0644); err != nil {
+			t.Fatalf("writing test config: %v", err)
+		}
+
+		cfg, err := LoadOrEmpty(cfgPath)
+		if err != nil {
+			t.Fatalf("LoadOrEmpty() error = %v", err)
+		}
+		if len(cfg.Databases) != 1 {
+			t.Errorf("len(Databases) = %d, want 1", len(cfg.Databases))
+		}
+		db, ok := cfg.Databases["mydb"]
+		if !ok {
+			t.Fatal("mydb not found in Databases")
+		}
+		if db.Type != "file" {
+			t.Errorf("Type = %q, want %q", db.Type, "file")
+		}
+		if db.Path != "/data/test.db" {
+			t.Errorf("Path = %q, want %q", db.Path, "/data/test.db")
+		}
+	})
+
+	t.Run("env vars expanded", func(t *testing.T) {
+		os.Setenv("TEST_DB_PATH", "/custom/path.db")
+		defer os.Unsetenv("TEST_DB_PATH")
+
+		tmpDir := t.TempDir()
+		cfgPath := filepath.Join(tmpDir, "blobber.yaml")
+		content := `databases:
+  mydb:
+    type: file
+    path: ${TEST_DB_PATH}
+    dest: /backups
+    compression: none
+`
+		if err := os.WriteFile(cfgPath, []byte(content), 0644); err != nil {
+			t.Fatalf("writing test config: %v", err)
+		}
+
+		cfg, err := LoadOrEmpty(cfgPath)
+		if err != nil {
+			t.Fatalf("LoadOrEmpty() error = %v", err)
+		}
+		db := cfg.Databases["mydb"]
+		if db.Path != "/custom/path.db" {
+			t.Errorf("Path = %q, want %q", db.Path, "/custom/path.db")
+		}
+	})
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "blobber.yaml")
+	if err := os.WriteFile(existing, []byte("databases: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A config under a regular file can never be created
+	notADir := filepath.Join(dir, "file")
+	if err := os.WriteFile(notADir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want bool
+	}{
+		{"writable file", existing, false},
+		{"missing file in a writable dir", filepath.Join(dir, "new", "nested", "blobber.yaml"), false},
+		{"parent is a file", filepath.Join(notADir, "blobber.yaml"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := !writable(tt.path); got != tt.want {
+				t.Errorf("read-only = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("read-only file", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("root can write read-only files")
+		}
+		path := filepath.Join(t.TempDir(), "blobber.yaml")
+		if err := os.WriteFile(path, []byte("databases: {}\n"), 0444); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		if !cfg.ReadOnly() {
+			t.Error("ReadOnly() = false for a 0444 file")
+		}
+	})
+}
+
+func TestSave(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfgPath := filepath.Join(tmpDir, "blobber.yaml")
+
+	cfg := &Config{
+		path: cfgPath,
+		Databases: map[string]Database{
+			"testdb": {
+				Type:        "file",
+				Path:        "/data/test.db",
+				Dest:        "/backups",
+				Compression: "gz",
+			},
+		},
+	}
+
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// Verify file was created
+	data, err := os.ReadFile(cfgPath)
+	if err != nil {
+		t.Fatalf("reading saved file: %v", err)
+	}
+
+	content := string(data)
+	if !contains(content, "testdb") {
+		t.Errorf("saved content missing 'testdb': %s", content)
+	}
+	if !contains(content, "file") {
+		t.Errorf("saved content missing 'file': %s", content)
+	}
+
+	// Verify it can be loaded back
+	loaded, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.Databases["testdb"].Path != "/data/test.db" {
+		t.Errorf("loaded Path = %q, want %q", loaded.Databases["testdb"].Path, "/data/test.db")
+	}
+}
+
+func TestSaveAtomic(t *testing.T) {
+	newConfig := func(path, dest string) *Config {
+		return &Config{path: path, Databases: map[string]Database{
+			"testdb": {Type: "file", Path: "/data/test.db", Dest: dest, Compression: "gz"},
+		}}
+	}
+	// saved writes a config and returns its content
+	saved := func(t *testing.T, path, dest string) []byte {
+		t.Helper()
+		if err := newConfig(path, dest).Save(); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	t.Run("failed write keeps the old config", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "blobber.yaml")
+		old := saved(t, path, "/old")
+
+		// Simulate the disk filling up halfway through the write
+		defer func(orig func(*os.File, []byte) error) { writeData = orig }(writeData)
+		writeData = func(f *os.File, data []byte) error {
+			f.Write(data[:len(data)/2])
+			return syscall.ENOSPC
+		}
+		if err := newConfig(path, "/new").Save(); !errors.Is(err, syscall.ENOSPC) {
+			t.Fatalf("Save() error = %v, want ENOSPC", err)
+		}
+
+		if data, _ := os.ReadFile(path); !bytes.Equal(data, old) {
+			t.Errorf("config after a failed save = %q, want the old content %q", data, old)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("temp file left behind: %v", entries)
+		}
+	})
+
+	t.Run("complete content replaced", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "blobber.yaml")
+		saved(t, path, "/old")
+		data := saved(t, path, "/new")
+		loaded, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load() error = %v (content %q)", err, data)
+		}
+		if loaded.Databases["testdb"].Dest != "/new" {
+			t.Errorf("dest = %q, want /new", loaded.Databases["testdb"].Dest)
+		}
+	})
+
+	t.Run("file mode preserved", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "blobber.yaml")
+		saved(t, path, "/old")
+		if err := os.Chmod(path, 0600); err != nil {
+			t.Fatal(err)
+		}
+		saved(t, path, "/new")
+		if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+			t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+		}
+	})
+
+	t.Run("symlink kept", func(t *testing.T) {
+		dir := t.TempDir()
+		target := filepath.Join(dir, "real.yaml")
+		saved(t, target, "/old")
+		link := filepath.Join(dir, "blobber.yaml")
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+		saved(t, link, "/new")
+		if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+			t.Fatalf("config is no longer a symlink (%v)", err)
+		}
+		if data, _ := os.ReadFile(target); !strings.Contains(string(data), "/new") {
+			t.Errorf("symlink target not updated: %s", data)
+		}
+	})
+}
+
+func TestExceedsSizeWarning(t *testing.T) {
+	tests := []struct {
+		name      string
+		warningMB int
+		size      int64
+		expected  bool
+	}{
+		{"no threshold", 0, 1 << 40, false},
+		{"below threshold", 100, 50 * 1024 * 1024, false},
+		{"at threshold", 100, 100 * 1024 * 1024, false},
+		{"above threshold", 100, 100*1024*1024 + 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{SizeWarningMB: tt.warningMB}
+			if got := cfg.ExceedsSizeWarning(tt.size); got != tt.expected {
+				t.Errorf("ExceedsSizeWarning(%d) = %v, want %v", tt.size, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReportDestination(t *testing.T) {
+	databases := map[string]Database{
+		"beta":  {Dest: "s3:bucket/beta"},
+		"alpha": {Dest: "s3:bucket/alpha"},
+	}
+
+	tests := []struct {
+		name     string
+		cfg      Config
+		dbs      []string
+		expected string
+	}{
+		{"disabled", Config{Databases: databases}, []string{"alpha"}, ""},
+		{"enabled uses first database dest", Config{Reports: true, Databases: databases}, []string{"beta", "alpha"}, "s3:bucket/alpha"},
+		{"report_dest wins", Config{Reports: true, ReportDest: "s3:audit", Databases: databases}, []string{"alpha"}, "s3:audit"},
+		{"report_dest enables reports", Config{ReportDest: "s3:audit", Databases: databases}, []string{"alpha"}, "s3:audit"},
+		{"no databases", Config{Reports: true, Databases: databases}, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.ReportDestination(tt.dbs); got != tt.expected {
+				t.Errorf("ReportDestination(%v) = %q, want %q", tt.dbs, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFailedUploadsDirectory(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		expected string
+	}{
+		{"disabled", Config{path: "/etc/blobber/config.yaml", FailedUploadsDir: "/srv/failed"}, ""},
+		{"next to the config", Config{path: "/etc/blobber/config.yaml", KeepOnUploadFailure: true}, "/etc/blobber/failed_uploads"},
+		{"failed_uploads_dir wins", Config{path: "/etc/blobber/config.yaml", KeepOnUploadFailure: true, FailedUploadsDir: "/srv/failed"}, "/srv/failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.FailedUploadsDirectory(); got != tt.expected {
+				t.Errorf("FailedUploadsDirectory() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestStagingDirectory(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		expected string
+	}{
+		{"next to the config", Config{path: "/etc/blobber/config.yaml"}, "/etc/blobber/staged"},
+		{"staging_dir wins", Config{path: "/etc/blobber/config.yaml", StagingDir: "/srv/staged"}, "/srv/staged"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.StagingDirectory(); got != tt.expected {
+				t.Errorf("StagingDirectory() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDiagnosticsLogPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		expected string
+	}{
+		{"under logs next to the config", Config{path: "/etc/blobber/config.yaml"}, "/etc/blobber/logs/diagnostics.log"},
+		{"diagnostics_log wins", Config{path: "/etc/blobber/config.yaml", DiagnosticsLog: "/var/log/blobber.log"}, "/var/log/blobber.log"},
+		{"off", Config{path: "/etc/blobber/config.yaml", DiagnosticsLog: DiagnosticsLogOff}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.DiagnosticsLogPath(); got != tt.expected {
+				t.Errorf("DiagnosticsLogPath() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRestoreAuditPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		expected string
+	}{
+		{"disabled", Config{path: "/etc/blobber/config.yaml"}, ""},
+		{"next to the config", Config{path: "/etc/blobber/config.yaml", RestoreAudit: true}, "/etc/blobber/restore_audit.log"},
+		{"restore_audit_log enables it", Config{path: "/etc/blobber/config.yaml", RestoreAuditLog: "/var/log/blobber/restores.log"}, "/var/log/blobber/restores.log"},
+		{"restore_audit_dest enables it", Config{path: "/etc/blobber/config.yaml", RestoreAuditDest: "s3:audit"}, "/etc/blobber/restore_audit.log"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.RestoreAuditPath(); got != tt.expected {
+				t.Errorf("RestoreAuditPath() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	path, err := ProfilePath("/home/me/.config/blobber", "prod")
+	if err != nil || path != "/home/me/.config/blobber/profiles/prod.yaml" {
+		t.Errorf("ProfilePath() = %q, %v", path, err)
+	}
+	if _, err := ProfilePath("/home/me/.config/blobber", "../prod"); err == nil {
+		t.Error("ProfilePath() accepted a name with a path")
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{"profile", "/home/me/.config/blobber/profiles/prod.yaml", "prod"},
+		{"default config", "/home/me/.config/blobber/config.yaml", ""},
+		{"other extension", "/home/me/.config/blobber/profiles/prod.yml", ""},
+		{"relative", "profiles/staging.yaml", "staging"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{path: tt.path}
+			if got := cfg.Profile(); got != tt.expected {
+				t.Errorf("Profile() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFavoriteRemotes(t *testing.T) {
+	cfg := &Config{}
+
+	cfg.SetFavoriteRemote("s3", true)
+	cfg.SetFavoriteRemote("b2", true)
+	cfg.SetFavoriteRemote("s3", true) // no duplicates
+
+	if len(cfg.FavoriteRemotes) != 2 {
+		t.Fatalf("FavoriteRemotes = %v, want 2 entries", cfg.FavoriteRemotes)
+	}
+	if !cfg.IsFavoriteRemote("s3") || !cfg.IsFavoriteRemote("b2") {
+		t.Errorf("expected s3 and b2 to be favorites, got %v", cfg.FavoriteRemotes)
+	}
+
+	cfg.SetFavoriteRemote("s3", false)
+	if cfg.IsFavoriteRemote("s3") {
+		t.Error("s3 should no longer be a favorite")
+	}
+	if !cfg.IsFavoriteRemote("b2") {
+		t.Error("b2 should still be a favorite")
+	}
+
+	cfg.SetFavoriteRemote("gcs", false) // unmarking a non-favorite is a no-op
+	if len(cfg.FavoriteRemotes) != 1 {
+		t.Errorf("FavoriteRemotes = %v, want 1 entry", cfg.FavoriteRemotes)
+	}
+}
+
+func TestReadDatabaseList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dbs.txt")
+	content := "# production fleet\nalpha\n\n  beta  \ngamma # legacy\nalpha\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write list: %v", err)
+	}
+
+	names, err := ReadDatabaseList(path)
+	if err != nil {
+		t.Fatalf("ReadDatabaseList() error = %v", err)
+	}
+	expected := []string{"alpha", "beta", "gamma"}
+	if len(names) != len(expected) {
+		t.Fatalf("ReadDatabaseList() = %v, want %v", names, expected)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("names[%d] = %q, want %q", i, names[i], expected[i])
+		}
+	}
+
+	if _, err := ReadDatabaseList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestSelectDatabases(t *testing.T) {
+	cfg := &Config{Databases: map[string]Database{
+		"alpha": {},
+		"beta":  {},
+	}}
+
+	selected, unknown := cfg.SelectDatabases([]string{"beta", "ghost", "alpha"})
+	if len(selected) != 2 || selected[0] != "beta" || selected[1] != "alpha" {
+		t.Errorf("selected = %v, want [beta alpha]", selected)
+	}
+	if len(unknown) != 1 || unknown[0] != "ghost" {
+		t.Errorf("unknown = %v, want [ghost]", unknown)
+	}
+}
+
+func contains(s, substr string) bool {
+	return len(s) >= len(substr) && (s == substr || len(substr) == 0 ||
+		(len(s) > 0 && len(substr) > 0 && findSubstring(s, substr)))
+}
+
+func findSubstring(s, substr string) bool {
+	for i := 0; i <= len(s)-len(substr); i++ {
+		if s[i:i+len(substr)] == substr {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDatabasesExcept(t *testing.T) {
+	cfg := &Config{Databases: map[string]Database{
+		"alpha": {},
+		"beta":  {},
+		"gamma": {},
+	}}
+
+	tests := []struct {
+		name        string
+		names       []string
+		exclude     []string
+		wantKept    []string
+		wantUnknown []string
+	}{
+		{"all but one", nil, []string{"beta"}, []string{"alpha", "gamma"}, nil},
+		{"selection but one", []string{"gamma", "alpha"}, []string{"alpha"}, []string{"gamma"}, nil},
+		{"unknown exclusion", nil, []string{"ghost", "gamma"}, []string{"alpha", "beta"}, []string{"ghost"}},
+		{"everything excluded", []string{"alpha"}, []string{"alpha"}, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, unknown := cfg.DatabasesExcept(tt.names, tt.exclude)
+			if strings.Join(kept, ",") != strings.Join(tt.wantKept, ",") {
+				t.Errorf("kept = %v, want %v", kept, tt.wantKept)
+			}
+			if strings.Join(unknown, ",") != strings.Join(tt.wantUnknown, ",") {
+				t.Errorf("unknown = %v, want %v", unknown, tt.wantUnknown)
+			}
+		})
+	}
+}
+
+func TestSharedDestinations(t *testing.T) {
+	cfg := &Config{Databases: map[string]Database{
+		"db":       {Dest: "/backups"},
+		"db_extra": {Dest: "/backups"},
+		"other":    {Dest: "s3:bucket/other"},
+	}}
+
+	got := cfg.SharedDestinations()
+	want := []string{`databases "db", "db_extra" share dest "/backups" (kept apart by file name only)`}
+	if !slices.Equal(got, want) {
+		t.Errorf("SharedDestinations() = %q, want %q", got, want)
+	}
+}
+
+func TestRenameDependency(t *testing.T) {
+	tests := []struct {
+		name       string
+		newName    string
+		want       []string
+		wantSolo   bool // the preset naming only app is kept
+		wantPreset []string
+	}{
+		{"renamed", "main", []string{"main", "events"}, true, []string{"main", "events"}},
+		{"deleted", "", []string{"events"}, false, []string{"events"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Databases: map[string]Database{
+				"app":       {},
+				"events":    {},
+				"analytics": {After: []string{"app", "events"}},
+			}, Presets: map[string]Preset{
+				"both": {Databases: []string{"app", "events"}},
+				"solo": {Databases: []string{"app"}},
+			}}
+			cfg.RenameDependency("app", tt.newName)
+			if got := cfg.Databases["analytics"].After; !slices.Equal(got, tt.want) {
+				t.Errorf("after = %v, want %v", got, tt.want)
+			}
+			if got := cfg.Presets["both"].Databases; !slices.Equal(got, tt.wantPreset) {
+				t.Errorf("preset databases = %v, want %v", got, tt.wantPreset)
+			}
+			if _, ok := cfg.Presets["solo"]; ok != tt.wantSolo {
+				t.Errorf("preset left without databases kept = %v, want %v", ok, tt.wantSolo)
+			}
+		})
+	}
+}
+
+func TestEffective(t *testing.T) {
+	t.Setenv("TEST_SHOW_PW", "from-env")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `databases:
+  shop:
+    type: mysql
+    host: db
+    user: app
+    password: ${TEST_SHOW_PW}
+    database: shop
+    dest: remote:shop
+  app:
+    type: file
+    path: /data/app.db
+    dest: remote:app
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	eff := cfg.Effective()
+	shop := eff.Databases["shop"]
+	if shop.Password != maskedPassword || shop.Port != 3306 || shop.Compression != "none" {
+		t.Errorf("shop = %+v, want masked password, port 3306 and compression none", shop)
+	}
+	if shop.MinBackupSize != DefaultMinBackupSize || eff.Databases["app"].MinBackupSize != 0 {
+		t.Errorf("min_backup_size = %d (shop), %d (app); want the defaults", shop.MinBackupSize, eff.Databases["app"].MinBackupSize)
+	}
+	if eff.ConnectTimeout != "5s" || eff.DictionaryDir != filepath.Join(filepath.Dir(path), "dictionaries") {
+		t.Errorf("connect_timeout = %q, dictionary_dir = %q; want the defaults", eff.ConnectTimeout, eff.DictionaryDir)
+	}
+	if cfg.Databases["shop"].Password != "from-env" || cfg.ConnectTimeout != "" {
+		t.Error("Effective() modified the config")
+	}
+
+	var out strings.Builder
+	if err := eff.Encode(&out); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if strings.Contains(out.String(), "from-env") {
+		t.Errorf("encoded config reveals the password:\n%s", out.String())
+	}
+}
+
+func TestMaskPasswords(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		want     string
+	}{
+		{"literal", "hunter2", "'********'"},
+		{"number", "12345", "'********'"},
+		{"env reference", "${DB_PASSWORD}", "${DB_PASSWORD}"},
+		{"partly from env", "pre-${DB_PASSWORD}", "'********'"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := tt.cfg.Validate()
-			if tt.wantErr == "" {
-				if err != nil {
-					t.Errorf("Validate() error = %v, want nil", err)
-				}
-			} else {
-				if err == nil {
-					t.Errorf("Validate() error = nil, want error containing %q", tt.wantErr)
-				} else if !contains(err.Error(), tt.wantErr) {
-					t.Errorf("Validate() error = %q, want error containing %q", err.Error(), tt.wantErr)
-				}
+			data := "# backups\ndatabases:\n  shop:\n    type: mysql\n    password: " + tt.password + " # prod\n"
+			got, err := MaskPasswords([]byte(data))
+			if err != nil {
+				t.Fatalf("MaskPasswords() error = %v", err)
+			}
+			want := "# backups\ndatabases:\n  shop:\n    type: mysql\n    password: " + tt.want + " # prod\n"
+			if string(got) != want {
+				t.Errorf("MaskPasswords() =\n%s\nwant\n%s", got, want)
 			}
 		})
 	}
 }
 
-func TestApplyDefaults(t *testing.T) {
+func TestFileExt(t *testing.T) {
 	tests := []struct {
-		name     string
-		input    Database
-		wantComp string
-		wantPort int
+		name string
+		db   Database
+		want string
 	}{
-		{
-			name:     "empty compression defaults to none",
-			input:    Database{Type: "file"},
-			wantComp: "none",
-			wantPort: 0,
-		},
-		{
-			name:     "mysql default port",
-			input:    Database{Type: "mysql"},
-			wantComp: "none",
-			wantPort: 3306,
-		},
-		{
-			name:     "postgres default port",
-			input:    Database{Type: "postgres"},
-			wantComp: "none",
-			wantPort: 5432,
-		},
-		{
-			name:     "custom port not overwritten",
-			input:    Database{Type: "mysql", Port: 3307},
-			wantComp: "none",
-			wantPort: 3307,
-		},
-		{
-			name:     "custom compression not overwritten",
-			input:    Database{Type: "file", Compression: "gz"},
-			wantComp: "gz",
-			wantPort: 0,
-		},
+		{"source extension", Database{Path: "/data/app.sqlite"}, ".sqlite"},
+		{"extensionless source", Database{Path: "/data/app"}, ".bak"},
+		{"dotted source", Database{Path: "/data/app.db.old-2"}, ".old-2"},
+		{"archive_ext", Database{Path: "/data/app", ArchiveExt: ".dat"}, ".dat"},
+		{"archive_ext without dot", Database{Path: "/data/app.sqlite", ArchiveExt: "dat"}, ".dat"},
+		{"multi-part archive_ext", Database{Path: "/data/app.tar.gz", ArchiveExt: ".tar.lz4"}, ".tar.lz4"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cfg := &Config{Databases: map[string]Database{"test": tt.input}}
-			cfg.applyDefaults()
-			db := cfg.Databases["test"]
-			if db.Compression != tt.wantComp {
-				t.Errorf("Compression = %q, want %q", db.Compression, tt.wantComp)
-			}
-			if db.Port != tt.wantPort {
-				t.Errorf("Port = %d, want %d", db.Port, tt.wantPort)
+			if got := tt.db.FileExt(); got != tt.want {
+				t.Errorf("FileExt() = %q, want %q", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestLoadOrEmpty(t *testing.T) {
-	t.Run("nonexistent file returns empty config", func(t *testing.T) {
-		cfg, err := LoadOrEmpty("/nonexistent/path/blobber.yaml")
-		if err != nil {
-			t.Fatalf("LoadOrEmpty() error = %v, want nil", err)
-		}
-		if cfg == nil {
-			t.Fatal("LoadOrEmpty() returned nil config")
-		}
-		if len(cfg.Databases) != 0 {
-			t.Errorf("Databases = %v, want empty map", cfg.Databases)
-		}
-	})
+func TestBackupFolder(t *testing.T) {
+	at := time.Date(2024, 1, 15, 14, 30, 22, 0, time.Local)
+	tests := []struct {
+		groupBy string
+		want    string
+	}{
+		{"", ""},
+		{"day", "2024-01-15"},
+		{"month", "2024-01"},
+	}
 
-	t.Run("valid file loads correctly", func(t *testing.T) {
-		tmpDir := t.TempDir()
-		cfgPath := filepath.Join(tmpDir, "blobber.yaml")
-		content := `databases:
-  mydb:
+	for _, tt := range tests {
+		t.Run(tt.groupBy, func(t *testing.T) {
+			if got := (Database{GroupBy: tt.groupBy}).BackupFolder(at); got != tt.want {
+				t.Errorf("BackupFolder() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInlineDest(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfgPath := filepath.Join(tmpDir, "blobber.yaml")
+	data := `databases:
+  shop:
     type: file
-    path: /data/test.db
-    dest: /backups
+    path: /data/shop.db
+    dest:
+      type: s3
+      bucket: backups
+      path: shop
+      region: eu-west-1
+      access_key_id: AKIA123
+      secret: s3cr3t
+      options:
+        session_token: t0k3n
     compression: gz
 `
-		if err := os.WriteFile(cfgPath, []byte(content), 0644); err != nil {
-			t.Fatalf("writing test config: %v", err)
-		}
+	if err := os.WriteFile(cfgPath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
 
-		cfg, err := LoadOrEmpty(cfgPath)
-		if err != nil {
-			t.Fatalf("LoadOrEmpty() error = %v", err)
-		}
-		if len(cfg.Databases) != 1 {
-			t.Errorf("len(Databases) = %d, want 1", len(cfg.Databases))
-		}
-		db, ok := cfg.Databases["mydb"]
-		if !ok {
-			t.Fatal("mydb not found in Databases")
-		}
-		if db.Type != "file" {
-			t.Errorf("Type = %q, want %q", db.Type, "file")
-		}
-		if db.Path != "/data/test.db" {
-			t.Errorf("Path = %q, want %q", db.Path, "/data/test.db")
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	db := cfg.Databases["shop"]
+	if db.DestSpec == nil {
+		t.Fatal("DestSpec not set for an inline dest")
+	}
+	remote := db.DestSpec.Remote()
+	if !strings.HasPrefix(remote, InlineRemotePrefix+"s3-") {
+		t.Errorf("Remote() = %q, want an %ss3- name", remote, InlineRemotePrefix)
+	}
+	if want := remote + ":backups/shop"; db.Dest != want {
+		t.Errorf("Dest = %q, want %q", db.Dest, want)
+	}
+	if db.Compression != "gz" {
+		t.Errorf("Compression = %q, want the fields after dest decoded", db.Compression)
+	}
+
+	opts := db.DestSpec.RcloneOptions()
+	want := map[string]string{"provider": "AWS", "access_key_id": "AKIA123", "secret_access_key": "s3cr3t", "region": "eu-west-1"}
+	for k, v := range want {
+		if opts[k] != v {
+			t.Errorf("RcloneOptions()[%q] = %q, want %q", k, opts[k], v)
 		}
-	})
+	}
+	if got := cfg.InlineDests()[remote]; got != db.DestSpec {
+		t.Errorf("InlineDests()[%q] = %v, want the database's spec", remote, got)
+	}
 
-	t.Run("env vars expanded", func(t *testing.T) {
-		os.Setenv("TEST_DB_PATH", "/custom/path.db")
-		defer os.Unsetenv("TEST_DB_PATH")
+	// Saving writes the settings back, not the remote name
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	saved, _ := os.ReadFile(cfgPath)
+	if strings.Contains(string(saved), InlineRemotePrefix) || !strings.Contains(string(saved), "bucket: backups") {
+		t.Errorf("saved config does not keep the inline dest:\n%s", saved)
+	}
+	reloaded, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load() after Save() error = %v", err)
+	}
+	if reloaded.Databases["shop"].Dest != db.Dest {
+		t.Errorf("Dest after reload = %q, want %q", reloaded.Databases["shop"].Dest, db.Dest)
+	}
 
-		tmpDir := t.TempDir()
-		cfgPath := filepath.Join(tmpDir, "blobber.yaml")
-		content := `databases:
-  mydb:
-    type: file
-    path: ${TEST_DB_PATH}
-    dest: /backups
-    compression: none
-`
-		if err := os.WriteFile(cfgPath, []byte(content), 0644); err != nil {
-			t.Fatalf("writing test config: %v", err)
-		}
+	// A change of credentials gets a new remote
+	other := *db.DestSpec
+	other.Secret = "rotated"
+	if other.Remote() == remote {
+		t.Error("Remote() unchanged after the secret changed")
+	}
 
-		cfg, err := LoadOrEmpty(cfgPath)
-		if err != nil {
-			t.Fatalf("LoadOrEmpty() error = %v", err)
-		}
-		db := cfg.Databases["mydb"]
-		if db.Path != "/custom/path.db" {
-			t.Errorf("Path = %q, want %q", db.Path, "/custom/path.db")
-		}
-	})
+	// Secrets are masked when shown
+	var buf bytes.Buffer
+	if err := cfg.Effective().Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "s3cr3t") || !strings.Contains(buf.String(), "secret: '********'") {
+		t.Errorf("Effective() does not mask the dest secret:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "t0k3n") || !strings.Contains(buf.String(), "session_token: '********'") {
+		t.Errorf("Effective() does not mask the dest options:\n%s", buf.String())
+	}
+	if spec := cfg.Databases["shop"].DestSpec; spec.Secret != "s3cr3t" || spec.Options["session_token"] != "t0k3n" {
+		t.Error("Effective() masked the secrets of the loaded config")
+	}
+	masked, err := MaskPasswords([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(masked), "s3cr3t") || strings.Contains(string(masked), "t0k3n") {
+		t.Errorf("MaskPasswords() does not mask the dest secrets:\n%s", masked)
+	}
 }
 
-func TestSave(t *testing.T) {
+func TestInlineDestValidate(t *testing.T) {
+	valid := InlineDest{Type: "s3", Bucket: "b", AccessKeyID: "id", Secret: "key"}
+	tests := []struct {
+		name    string
+		modify  func(d *InlineDest)
+		wantErr string
+	}{
+		{"valid s3", func(d *InlineDest) {}, ""},
+		{"valid b2", func(d *InlineDest) { d.Type = "b2" }, ""},
+		{"missing type", func(d *InlineDest) { d.Type = "" }, "type is required"},
+		{"unsupported type", func(d *InlineDest) { d.Type = "ftp" }, "unsupported type"},
+		{"missing bucket", func(d *InlineDest) { d.Bucket = "" }, "bucket is required"},
+		{"missing key id", func(d *InlineDest) { d.AccessKeyID = "" }, "access_key_id is required"},
+		{"missing secret", func(d *InlineDest) { d.Secret = "" }, "secret is required"},
+		{"region on b2", func(d *InlineDest) { d.Type = "b2"; d.Region = "us" }, "only supported for s3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := valid
+			tt.modify(&d)
+			err := d.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTieredDest(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
 	tmpDir := t.TempDir()
 	cfgPath := filepath.Join(tmpDir, "blobber.yaml")
+	data := `databases:
+  shop:
+    type: file
+    path: /data/shop.db
+    dest:
+      local: ~/backups
+      remote: s3:bucket/shop
+    compression: gz
+`
+	if err := os.WriteFile(cfgPath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
 
-	cfg := &Config{
-		path: cfgPath,
-		Databases: map[string]Database{
-			"testdb": {
-				Type:        "file",
-				Path:        "/data/test.db",
-				Dest:        "/backups",
-				Compression: "gz",
-			},
-		},
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	db := cfg.Databases["shop"]
+	if db.Dest != "s3:bucket/shop" || db.DestSpec != nil {
+		t.Errorf("Dest = %q, DestSpec = %v; want the remote tier", db.Dest, db.DestSpec)
+	}
+	if want := filepath.Join(home, "backups"); db.LocalTier() != want {
+		t.Errorf("LocalTier() = %q, want %q", db.LocalTier(), want)
+	}
+	if db.Compression != "gz" {
+		t.Errorf("Compression = %q, want the fields after dest decoded", db.Compression)
+	}
+	if (Database{Dest: "s3:bucket"}).LocalTier() != "" {
+		t.Error("LocalTier() set for a single-tier dest")
 	}
 
+	// Saving writes both tiers back
 	if err := cfg.Save(); err != nil {
 		t.Fatalf("Save() error = %v", err)
 	}
-
-	// Verify file was created
-	data, err := os.ReadFile(cfgPath)
+	reloaded, err := Load(cfgPath)
 	if err != nil {
-		t.Fatalf("reading saved file: %v", err)
+		t.Fatalf("Load() after Save() error = %v", err)
+	}
+	if got := reloaded.Databases["shop"]; got.Dest != db.Dest || got.DestTiers == nil || got.DestTiers.Local != "~/backups" {
+		t.Errorf("after reload Dest = %q, tiers = %+v; want both tiers kept", got.Dest, got.DestTiers)
 	}
 
-	content := string(data)
-	if !contains(content, "testdb") {
-		t.Errorf("saved content missing 'testdb': %s", content)
+	// Both tiers are required
+	missing := strings.Replace(data, "      remote: s3:bucket/shop\n", "", 1)
+	if err := os.WriteFile(cfgPath, []byte(missing), 0644); err != nil {
+		t.Fatal(err)
 	}
-	if !contains(content, "file") {
-		t.Errorf("saved content missing 'file': %s", content)
+	if _, err := Load(cfgPath); err == nil || !strings.Contains(err.Error(), "remote is required") {
+		t.Errorf("Load() without a remote tier error = %v, want remote is required", err)
 	}
+}
 
-	// Verify it can be loaded back
-	loaded, err := Load(cfgPath)
+func TestTieredDestRetention(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfgPath := filepath.Join(tmpDir, "blobber.yaml")
+	data := `databases:
+  shop:
+    type: file
+    path: /data/shop.db
+    dest:
+      local: /var/backups/shop
+      remote: s3:bucket/shop
+      local_retention:
+        keep_last: 2
+    retention:
+      keep_days: 30
+`
+	if err := os.WriteFile(cfgPath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(cfgPath)
 	if err != nil {
 		t.Fatalf("Load() error = %v", err)
 	}
-	if loaded.Databases["testdb"].Path != "/data/test.db" {
-		t.Errorf("loaded Path = %q, want %q", loaded.Databases["testdb"].Path, "/data/test.db")
+
+	// The local tier has its own policy, the remote tier falls back to the database's
+	db := cfg.Databases["shop"]
+	if got := db.LocalTierRetention(); got != (Retention{KeepLast: 2}) {
+		t.Errorf("LocalTierRetention() = %+v, want local_retention", got)
+	}
+	if got := db.DestRetention(); got != (Retention{KeepDays: 30}) {
+		t.Errorf("DestRetention() = %+v, want the database's retention", got)
+	}
+	if got := db.WithDestRetention(Retention{KeepDays: 7}); got.Retention != (Retention{KeepDays: 7}) {
+		t.Errorf("WithDestRetention() retention = %+v, want the database's retention replaced", got.Retention)
 	}
-}
 
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr || len(substr) == 0 ||
-		(len(s) > 0 && len(substr) > 0 && findSubstring(s, substr)))
-}
+	// With remote_retention, the remote tier's policy is the one edited and the one the
+	// stale check goes by, and the copy it was edited from is left alone
+	remote := Database{Retention: Retention{KeepDays: 30}, DestTiers: &TieredDest{RemoteRetention: &Retention{KeepDays: 90}}}
+	edited := remote.WithDestRetention(Retention{KeepDays: 7})
+	if edited.Retention != remote.Retention || edited.DestRetention() != (Retention{KeepDays: 7}) || remote.DestRetention() != (Retention{KeepDays: 90}) {
+		t.Errorf("WithDestRetention() = %+v, %+v; want only remote_retention of the copy changed", edited.Retention, *edited.DestTiers.RemoteRetention)
+	}
+	if got := (&Config{}).StaleThreshold(remote); got != 90*24*time.Hour {
+		t.Errorf("StaleThreshold() = %v, want remote_retention's keep_days", got)
+	}
 
-func findSubstring(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
+	// A tier's own policy is enough for the database to have retention
+	db.Retention = Retention{}
+	if !db.HasRetention() {
+		t.Error("HasRetention() = false with only local_retention set")
+	}
+	db.DestTiers = &TieredDest{Local: db.DestTiers.Local, Remote: db.DestTiers.Remote}
+	if db.HasRetention() {
+		t.Error("HasRetention() = true with no policy on either tier")
+	}
+
+	// Saving keeps the tier's policy
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	reloaded, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load() after Save() error = %v", err)
+	}
+	if got := reloaded.Databases["shop"].DestTiers; got == nil || got.LocalRetention == nil || got.LocalRetention.KeepLast != 2 {
+		t.Errorf("after reload tiers = %+v, want local_retention kept", got)
 	}
-	return false
 }
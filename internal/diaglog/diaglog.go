@@ -0,0 +1,166 @@
+// Package diaglog writes internal diagnostics (rclone logs, command stderr, panics) to a
+// size-rotated log file. Writes are queued and never block the caller.
+package diaglog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultMaxSize is the size the log grows to before it is rotated
+	DefaultMaxSize = 5 << 20
+	// DefaultBackups is how many rotated logs are kept (path.1 the newest)
+	DefaultBackups = 3
+
+	// queueSize is how many writes can wait for the file before more are dropped
+	queueSize = 1024
+)
+
+// Log is a diagnostics log file, rotated once it grows past its maximum size
+type Log struct {
+	path    string
+	maxSize int64
+	backups int
+
+	mu     sync.RWMutex // guards closed against writes racing Close
+	closed bool
+	queue  chan []byte
+	done   chan struct{}
+
+	dropped atomic.Int64 // writes dropped since the last one that made it to the file
+
+	file *os.File // owned by run
+	size int64
+}
+
+// Open opens the log at path, creating it and its directory as needed, and starts
+// writing queued diagnostics to it. Once it grows past maxSize it is renamed to path.1
+// (path.1 to path.2, ...) keeping backups of them.
+func Open(path string, maxSize int64, backups int) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("creating log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening diagnostics log: %w", err)
+	}
+	var size int64
+	if stat, err := file.Stat(); err == nil {
+		size = stat.Size()
+	}
+
+	l := &Log{
+		path:    path,
+		maxSize: maxSize,
+		backups: backups,
+		queue:   make(chan []byte, queueSize),
+		done:    make(chan struct{}),
+		file:    file,
+		size:    size,
+	}
+	go l.run()
+	return l, nil
+}
+
+// Path returns the file the log writes to
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Write queues p to be written to the log. It never blocks: when the queue is full, or
+// the log closed, p is dropped, and the next write that makes it notes how many were.
+func (l *Log) Write(p []byte) (int, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return len(p), nil
+	}
+	select {
+	case l.queue <- append([]byte(nil), p...):
+	default:
+		l.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// Printf writes a timestamped line to the log
+func (l *Log) Printf(format string, args ...any) {
+	fmt.Fprintf(l, "%s %s\n", time.Now().Format("2006/01/02 15:04:05"), fmt.Sprintf(format, args...))
+}
+
+// Close writes what is still queued and closes the file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.queue)
+	l.mu.Unlock()
+
+	<-l.done
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// run writes the queued diagnostics to the file until the log is closed
+func (l *Log) run() {
+	defer close(l.done)
+	for p := range l.queue {
+		l.noteDropped()
+		l.write(p)
+	}
+	l.noteDropped()
+}
+
+// noteDropped records in the file how many writes were dropped since the last note
+func (l *Log) noteDropped() {
+	if n := l.dropped.Swap(0); n > 0 {
+		l.write([]byte(fmt.Sprintf("%s [diagnostics log: %d write(s) dropped]\n", time.Now().Format("2006/01/02 15:04:05"), n)))
+	}
+}
+
+// write appends p to the file, rotating it first when p would take it past maxSize.
+// Errors are ignored: there is nowhere left to report them.
+func (l *Log) write(p []byte) {
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		l.rotate()
+	}
+	if l.file == nil {
+		return
+	}
+	n, _ := l.file.Write(p)
+	l.size += int64(n)
+}
+
+// rotate shifts the rotated logs up by one, dropping the oldest, and starts a new file
+func (l *Log) rotate() {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	if l.backups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", l.path, l.backups))
+		for i := l.backups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+		}
+		os.Rename(l.path, l.path+".1")
+	} else {
+		os.Remove(l.path)
+	}
+
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return
+	}
+	l.file = file
+	l.size = 0
+}
@@ -0,0 +1,70 @@
+package diaglog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "diagnostics.log")
+
+	l, err := Open(path, 10, 2)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		l.Write([]byte(line))
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Each line takes the log past 10 bytes, so each starts a new file; two are kept
+	want := map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"}
+	for file, content := range want {
+		got, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("reading %s: %v", filepath.Base(file), err)
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), got, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("rotated log kept past the number of backups")
+	}
+
+	// Writes after Close are dropped, not a panic
+	if n, err := l.Write([]byte("late\n")); n != 5 || err != nil {
+		t.Errorf("Write() after Close = %d, %v", n, err)
+	}
+}
+
+func TestLogNeverBlocks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diagnostics.log")
+	l, err := Open(path, DefaultMaxSize, DefaultBackups)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	// Far more writes than the queue holds: whatever the file can't keep up with is
+	// dropped rather than waited for
+	for range queueSize * 10 {
+		l.Printf("rclone: %s", strings.Repeat("x", 100))
+	}
+	l.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading log: %v", err)
+	}
+	lines := strings.Count(string(data), "rclone: ")
+	if lines == 0 || lines > queueSize*10 {
+		t.Errorf("log has %d lines, want some and at most every write", lines)
+	}
+	if lines < queueSize*10 && !strings.Contains(string(data), "write(s) dropped") {
+		t.Error("dropped writes not noted in the log")
+	}
+}
@@ -0,0 +1,147 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// probeWait is how long Acquire waits before trying again when the lock is taken: Held
+// probes with a shared lock, which refuses ours for that instant
+const probeWait = 20 * time.Millisecond
+
+// HeldError is returned by Acquire when another process holds the lock
+type HeldError struct {
+	PID int // 0 when the holder has not written its PID yet
+}
+
+func (e *HeldError) Error() string {
+	if e.PID == 0 {
+		return "another blobber run is in progress"
+	}
+	return fmt.Sprintf("another blobber run is in progress (pid %d)", e.PID)
+}
+
+// Lock is an acquired run lock: an flock on a file containing the holder's PID. The
+// kernel drops the flock when the holder exits, however it exits, so a lock file left
+// behind is never mistaken for a live holder, even once its PID is reused.
+type Lock struct {
+	path string
+	file *os.File
+}
+
+// PathFor returns the lock file path for a config file, so runs sharing a config
+// exclude each other while runs of unrelated configs don't
+func PathFor(configPath string) string {
+	return configPath + ".lock"
+}
+
+// Acquire takes the lock at path. A lock file that no process holds, left behind by a
+// run that died, is taken over.
+func Acquire(path string) (*Lock, error) {
+	retried := false
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("opening lock file: %w", err)
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			f.Close()
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				if !retried {
+					retried = true
+					time.Sleep(probeWait)
+					continue
+				}
+				pid, _ := readPID(path)
+				return nil, &HeldError{PID: pid}
+			}
+			return nil, fmt.Errorf("locking %s: %w", path, err)
+		}
+
+		// The holder we waited out may have released the lock by removing the file we
+		// opened; the lock then belongs to whoever holds the file now at path
+		if !samePath(f, path) {
+			f.Close()
+			continue
+		}
+
+		if err := writePID(f); err != nil {
+			os.Remove(path)
+			f.Close()
+			return nil, fmt.Errorf("writing lock file: %w", err)
+		}
+		return &Lock{path: path, file: f}, nil
+	}
+}
+
+// Held reports whether a process holds the lock at path, without taking it
+func Held(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	// A shared lock is refused while someone holds the exclusive one. Held only holds it
+	// for an instant, and Acquire tries again past it.
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+		return errors.Is(err, syscall.EWOULDBLOCK)
+	}
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return false
+}
+
+// Release removes the lock file and drops the lock. Safe to call on a nil lock.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	// Removed while still locked, so a run starting meanwhile creates a new file rather
+	// than locking this one once it is closed
+	err := os.Remove(l.path)
+	l.file.Close()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing lock file: %w", err)
+	}
+	return nil
+}
+
+// samePath reports whether f is still the file at path
+func samePath(f *os.File, path string) bool {
+	opened, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	return err == nil && os.SameFile(opened, current)
+}
+
+// writePID replaces the content of the lock file with our PID
+func writePID(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := f.WriteString(strconv.Itoa(os.Getpid()))
+	return err
+}
+
+// readPID reads the holder PID from a lock file
+func readPID(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pid in lock file")
+	}
+	return pid, nil
+}
@@ -0,0 +1,196 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAcquireRelease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml.lock")
+
+	l, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading lock file: %v", err)
+	}
+	if string(data) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("lock file contains %q, want our pid %d", data, os.Getpid())
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("lock file still exists after Release()")
+	}
+
+	// Can be taken again once released
+	l, err = Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire() after release error = %v", err)
+	}
+	l.Release()
+}
+
+func TestAcquireHeld(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml.lock")
+
+	// Held through another open file, as another process would
+	holder, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	defer holder.Release()
+
+	_, err = Acquire(path)
+	var held *HeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("Acquire() error = %v, want HeldError", err)
+	}
+	if held.PID != os.Getpid() {
+		t.Errorf("HeldError.PID = %d, want %d", held.PID, os.Getpid())
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error("held lock file was removed")
+	}
+}
+
+func TestAcquireStale(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"dead pid", "999999999"},
+		{"reused pid", strconv.Itoa(os.Getpid())}, // alive, but not holding the lock
+		{"garbage", "not-a-pid"},
+		{"empty", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml.lock")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("writing lock file: %v", err)
+			}
+
+			l, err := Acquire(path)
+			if err != nil {
+				t.Fatalf("Acquire() error = %v, want stale lock replaced", err)
+			}
+			defer l.Release()
+
+			data, _ := os.ReadFile(path)
+			if string(data) != strconv.Itoa(os.Getpid()) {
+				t.Errorf("lock file contains %q, want our pid", data)
+			}
+		})
+	}
+}
+
+func TestAcquireConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml.lock")
+	if err := os.WriteFile(path, []byte("999999999"), 0644); err != nil {
+		t.Fatalf("writing lock file: %v", err)
+	}
+
+	// Runs racing for a stale lock, some releasing theirs meanwhile: never two holders
+	var mu sync.Mutex
+	holders := 0
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				l, err := Acquire(path)
+				var held *HeldError
+				if errors.As(err, &held) {
+					continue
+				}
+				if err != nil {
+					t.Errorf("Acquire() error = %v", err)
+					return
+				}
+				mu.Lock()
+				holders++
+				if holders > 1 {
+					t.Error("two runs hold the lock at once")
+				}
+				mu.Unlock()
+				time.Sleep(100 * time.Microsecond)
+				mu.Lock()
+				holders--
+				mu.Unlock()
+				l.Release()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestHeld(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml.lock")
+	if Held(path) {
+		t.Error("Held() = true without a lock file")
+	}
+
+	l, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if !Held(path) {
+		t.Error("Held() = false while we hold the lock")
+	}
+	l.Release()
+
+	if err := os.WriteFile(path, []byte("999999999"), 0644); err != nil {
+		t.Fatalf("writing lock file: %v", err)
+	}
+	if Held(path) {
+		t.Error("Held() = true for a stale lock")
+	}
+}
+
+func TestAcquireDuringProbe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml.lock")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("writing lock file: %v", err)
+	}
+
+	// A probe's shared lock, as Held takes it, released shortly after
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+		t.Fatalf("taking the shared lock: %v", err)
+	}
+	go func() {
+		time.Sleep(probeWait / 4)
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	}()
+
+	l, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire() while probed error = %v, want the lock once the probe is done", err)
+	}
+	l.Release()
+}
+
+func TestReleaseNil(t *testing.T) {
+	var l *Lock
+	if err := l.Release(); err != nil {
+		t.Errorf("Release() on nil lock error = %v", err)
+	}
+}
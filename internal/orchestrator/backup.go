@@ -2,14 +2,24 @@ package orchestrator
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/Yoone/blobber/internal/backup"
 	"github.com/Yoone/blobber/internal/config"
 	"github.com/Yoone/blobber/internal/retention"
 	"github.com/Yoone/blobber/internal/storage"
-	"github.com/dustin/go-humanize"
 )
 
 // BackupStep represents a step in the backup process
@@ -34,10 +44,17 @@ func (s BackupStep) String() string {
 	}
 }
 
+// ErrDeadlineExceeded is reported for databases cancelled because the run deadline expired
+var ErrDeadlineExceeded = errors.New("deadline exceeded")
+
 // BackupOptions configures the backup run
 type BackupOptions struct {
-	DryRun        bool // perform dump but skip upload and retention
-	SkipRetention bool // skip retention policy
+	DryRun          bool // perform dump but skip upload and retention
+	SkipRetention   bool // skip retention policy
+	RetentionDryRun bool // apply retention as usual but only report what it would delete
+	Stage           bool // perform dump and leave it staged for upload-staged, skipping upload and retention
+
+	RetentionFailures RetentionFailures // databases whose retention pre-check failed: retention is skipped with the error
 }
 
 // BackupProgress reports progress for a single database backup
@@ -48,48 +65,517 @@ type BackupProgress struct {
 	Done    bool
 	Error   error
 	Skipped bool // true if step was skipped (e.g., no retention policy)
+
+	EstimatedSize int64    // approximate uncompressed dump size, set on the pre-dump estimate update
+	Warnings      []string // non-fatal output from the dump tool, set when the dump completes
+}
+
+// progressJSON is the JSON form of a BackupProgress
+type progressJSON struct {
+	DB             string     `json:"db"`
+	Step           BackupStep `json:"step"`
+	Message        string     `json:"message,omitempty"`
+	Done           bool       `json:"done"`
+	Skipped        bool       `json:"skipped,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	EstimatedBytes int64      `json:"estimated_bytes,omitempty"`
+	Warnings       []string   `json:"warnings,omitempty"`
+}
+
+// MarshalJSON encodes the update for machine-readable progress output
+func (p BackupProgress) MarshalJSON() ([]byte, error) {
+	out := progressJSON{
+		DB:             p.DBName,
+		Step:           p.Step,
+		Message:        p.Message,
+		Done:           p.Done,
+		Skipped:        p.Skipped,
+		EstimatedBytes: p.EstimatedSize,
+		Warnings:       p.Warnings,
+	}
+	if p.Error != nil {
+		out.Error = p.Error.Error()
+	}
+	return json.Marshal(out)
 }
 
 // BackupResult contains the final result for a database backup
 type BackupResult struct {
-	DBName  string
-	Success bool
-	Error   error
-	Steps   []BackupProgress // completed steps
+	DBName           string
+	Success          bool
+	Error            error
+	DeadlineExceeded bool             // true if the run deadline cancelled this backup
+	Filename         string           // backup file name, set once the dump succeeds
+	Size             int64            // backup file size in bytes
+	UncompressedSize int64            // dump size before compression (0 if unknown)
+	UploadedSize     int64            // bytes uploaded, set once the upload is confirmed
+	DeletedSize      int64            // bytes of old backups deleted by retention
+	Steps            []BackupProgress // completed steps
+}
+
+// CredentialFailures maps database names to the error of a connection test the server
+// rejected their credentials in
+type CredentialFailures map[string]error
+
+// CheckCredentials tests the connection of each mysql/postgres database in parallel, for
+// fail_fast_on_auth. Only rejected credentials are returned: timeouts and unreachable
+// servers may be transient, so those databases are left to fail on their own.
+func CheckCredentials(cfg *config.Config, databases []string) CredentialFailures {
+	failures := make(CredentialFailures)
+	settings := backup.SettingsFrom(cfg)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range databases {
+		db := cfg.Databases[name]
+		if db.Type != "mysql" && db.Type != "postgres" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := backup.TestConnection(db, settings); backup.IsAuthError(err) {
+				mu.Lock()
+				failures[name] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return failures
+}
+
+// DestinationCheckTimeout bounds each destination access test of CheckDestinations
+const DestinationCheckTimeout = 30 * time.Second
+
+// DestinationFailures maps database names to the error accessing their destination
+type DestinationFailures map[string]error
+
+// CheckDestinations tests access to the destination of each database before anything is
+// dumped, so an unreachable destination fails the run up front instead of after every
+// dump. Each distinct destination is tested once, in parallel. A destination that does
+// not exist yet passes, since the first upload creates it.
+func CheckDestinations(ctx context.Context, cfg *config.Config, databases []string) DestinationFailures {
+	byDest := make(map[string][]string)
+	for _, name := range databases {
+		dest := cfg.Databases[name].Dest
+		byDest[dest] = append(byDest[dest], name)
+	}
+
+	failures := make(DestinationFailures)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for dest, names := range byDest {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, DestinationCheckTimeout)
+			defer cancel()
+			err := storage.TestAccess(checkCtx, dest)
+			if err == nil || storage.IsNotFound(err) {
+				return
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = fmt.Errorf("access timed out (%s)", DestinationCheckTimeout)
+			}
+			mu.Lock()
+			for _, name := range names {
+				failures[name] = fmt.Errorf("%s: %w", dest, err)
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return failures
 }
 
 // RetentionPlan maps database names to files that would be deleted
 type RetentionPlan map[string][]storage.RemoteFile
 
+// RetentionFailures maps database names to the error listing their backups. Retention
+// is skipped for these databases rather than planned from an incomplete listing.
+type RetentionFailures map[string]error
+
 // PreCheckRetention calculates which files would be deleted by retention policies
 // without actually deleting them. Returns a plan that can be reviewed before execution.
-func PreCheckRetention(ctx context.Context, cfg *config.Config, databases []string) (RetentionPlan, error) {
+func PreCheckRetention(ctx context.Context, cfg *config.Config, databases []string) (RetentionPlan, RetentionFailures) {
+	// pendingBackups=1 because we're about to create a new backup
+	return PlanRetention(ctx, cfg, databases, 1)
+}
+
+// PlanRetention calculates which files retention would delete from each database's dest,
+// assuming pendingBackups new backups will be added (0 when pruning without a backup).
+// Databases with an immutable destination are never included. Databases whose backups
+// could not be listed are left out of the plan and returned as failures.
+func PlanRetention(ctx context.Context, cfg *config.Config, databases []string, pendingBackups int) (RetentionPlan, RetentionFailures) {
 	plan := make(RetentionPlan)
+	failures := make(RetentionFailures)
+	concurrency := retention.ConcurrencyFrom(cfg)
 
 	for _, name := range databases {
 		db := cfg.Databases[name]
-		if db.Retention.KeepLast == 0 && db.Retention.KeepDays == 0 && db.Retention.MaxSizeMB == 0 {
+		if !db.DestRetention().Enabled() || db.Immutable {
 			continue
 		}
 
-		files, err := storage.ListForDatabase(ctx, db.Dest, name)
+		files, err := listForRetention(ctx, db, name, concurrency)
 		if err != nil {
-			continue // skip on error, don't fail the whole check
+			failures[name] = err
+			continue
 		}
 
-		// pendingBackups=1 because we're about to create a new backup
-		toDelete := retention.Apply(ctx, files, name, db.Retention, 1)
+		toDelete := retention.Apply(ctx, files, db.BackupPrefix(name), db.DestRetention(), pendingBackups)
 		if len(toDelete) > 0 {
 			plan[name] = toDelete
 		}
 	}
 
-	return plan, nil
+	return plan, failures
+}
+
+// listForRetention lists the backups retention decides on. A destination that doesn't
+// exist yet has no backups; any other error means the listing can't be trusted.
+func listForRetention(ctx context.Context, db config.Database, name string, concurrency retention.Concurrency) ([]storage.RemoteFile, error) {
+	files, err := storage.ListForDatabase(concurrency.ListContext(ctx), db.Dest, db.BackupPrefix(name))
+	if err != nil && !storage.IsNotFound(err) {
+		return nil, fmt.Errorf("retention skipped, listing backups failed: %w", err)
+	}
+	return files, nil
+}
+
+// ListAfterUpload lists the backups of the database once its new backup is uploaded, for
+// retention to decide on, with the new backup counted even when the listing omits it
+func ListAfterUpload(ctx context.Context, db config.Database, name string, result *backup.Result, concurrency retention.Concurrency) ([]storage.RemoteFile, error) {
+	files, err := listForRetention(ctx, db, name, concurrency)
+	if err != nil {
+		return nil, err
+	}
+	return withUploaded(db, files, result.Filename, result.Size), nil
+}
+
+// RetentionAfterUpload lists the backups of the database once its new backup is uploaded,
+// for an accurate count including it, and returns them with the ones the retention
+// policy of its dest deletes. A dest without a policy of its own (only the local tier of
+// a tiered dest has one) deletes nothing.
+func RetentionAfterUpload(ctx context.Context, db config.Database, name string, result *backup.Result, concurrency retention.Concurrency) ([]storage.RemoteFile, []storage.RemoteFile, error) {
+	policy := db.DestRetention()
+	if !policy.Enabled() {
+		return nil, nil, nil
+	}
+	files, err := ListAfterUpload(ctx, db, name, result, concurrency)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// pendingBackups=0 because the new backup already exists in files list
+	return files, retention.Apply(ctx, files, db.BackupPrefix(name), policy, 0), nil
+}
+
+// withUploaded returns files with the backup just uploaded added when the listing doesn't
+// show it yet. Eventually consistent object stores may list a new object only a moment
+// after it was written; without it retention would decide on one backup too few and keep
+// an old backup its policy deletes.
+func withUploaded(db config.Database, files []storage.RemoteFile, fileName string, size int64) []storage.RemoteFile {
+	name := DestName(db, fileName)
+	for _, f := range files {
+		if f.Name == name {
+			return files
+		}
+	}
+	return append(files, storage.RemoteFile{Name: name, Size: size, ModTime: time.Now()})
+}
+
+// DestName returns the name of the backup fileName relative to the database's dest: in
+// its dated folder with group_by
+func DestName(db config.Database, fileName string) string {
+	return path.Join(db.BackupFolder(retention.BackupTime(storage.RemoteFile{Name: fileName})), fileName)
+}
+
+// UpdateIndex applies change to the backup index of the database's destination with
+// backup_index, after a backup, retention, a rekey or an import changed it. It returns a
+// warning when the index can't be updated (it is then deleted, and the restore picker
+// lists the destination until the next update rebuilds it), or "" when it is updated or
+// not used.
+func UpdateIndex(ctx context.Context, db config.Database, change storage.IndexChange) string {
+	if !db.BackupIndex {
+		return ""
+	}
+	if err := storage.UpdateIndex(ctx, db.Dest, change); err != nil {
+		return fmt.Sprintf("backup index not updated: %v", err)
+	}
+	return ""
+}
+
+// SizeAnomaly checks the size of a backup just uploaded against the recent backups of the
+// database, for size_alert. It returns a warning when the backup is an outlier, or "" when
+// it is not or no size_alert is configured. The check is best-effort: a listing failure
+// only skips it.
+func SizeAnomaly(ctx context.Context, db config.Database, name, fileName string) string {
+	if !db.SizeAlert.Enabled() {
+		return ""
+	}
+	files, err := storage.ListForDatabase(ctx, db.Dest, db.BackupPrefix(name))
+	if err != nil {
+		return ""
+	}
+	return retention.SizeAnomaly(files, db.BackupPrefix(name), fileName, db.SizeAlert.Factor, db.SizeAlert.HistorySize())
+}
+
+// CheckTempSpace checks that the temp directory has room for the dump of the entry name
+// before it starts, sizing it from the database's newest backup or, for an uncompressed
+// dump, the pre-dump estimate (queried here when estimate is 0). The check is
+// best-effort: when the size can't be told, the dump goes ahead.
+func CheckTempSpace(ctx context.Context, name string, db config.Database, estimate int64, settings backup.Settings) error {
+	var lastSize int64
+	if files, err := ListBackupsIndexed(ctx, name, db); err == nil && len(files) > 0 {
+		lastSize = files[0].Size
+	}
+	if lastSize <= 0 && estimate <= 0 && (db.Compression == "" || db.Compression == "none") {
+		estimate, _ = backup.EstimateSize(ctx, db, settings)
+	}
+	return backup.CheckTempSpace(backup.TempSpaceNeeded(db, lastSize, estimate))
+}
+
+// ImmutableRetention returns the retention step outcome for a database with an immutable
+// destination. Retention never deletes there; a configured policy is reported as ignored
+// since expiry has to be handled by the bucket's lifecycle rules.
+func ImmutableRetention(db config.Database) (string, []string) {
+	var warnings []string
+	if db.HasRetention() {
+		warnings = append(warnings, "retention policy ignored: destination is immutable, expire backups with bucket lifecycle rules instead")
+	}
+	return "Skipped (immutable destination)", warnings
+}
+
+// UploadDest returns where a backup file is uploaded: the database's dest or, with
+// group_by, the dated folder of dest for the timestamp in the file name
+func UploadDest(db config.Database, fileName string) string {
+	folder := db.BackupFolder(retention.BackupTime(storage.RemoteFile{Name: fileName}))
+	return storage.JoinDest(db.Dest, folder)
+}
+
+// CheckImmutableName fails if fileName already exists on the database's immutable
+// destination, so a backup never overwrites (or adds a version to) an existing object
+func CheckImmutableName(ctx context.Context, db config.Database, fileName string) error {
+	exists, err := storage.Exists(ctx, db.Dest, fileName)
+	if err != nil {
+		return fmt.Errorf("checking immutable destination: %w", err)
+	}
+	if exists {
+		return fmt.Errorf("%s already exists on immutable destination", fileName)
+	}
+	return nil
+}
+
+// ConfirmUpload checks that an uploaded backup is present on the destination with the
+// size of the local dump and, with verify_upload, the same SHA-256. The local dump must
+// only be removed once this succeeds.
+func ConfirmUpload(ctx context.Context, db config.Database, result *backup.Result, settings backup.Settings) error {
+	remote, err := storage.Stat(ctx, db.Dest, result.Filename)
+	if storage.IsNotFound(err) {
+		return fmt.Errorf("%s is missing on the destination after upload", result.Filename)
+	}
+	if err != nil {
+		return fmt.Errorf("confirming upload: %w", err)
+	}
+	if remote.Size != result.Size {
+		return fmt.Errorf("%s is %d bytes on the destination, expected %d", result.Filename, remote.Size, result.Size)
+	}
+	// post_upload_verify hashes the backup as it reads it back, so it is not downloaded twice
+	if !db.VerifyUpload || db.PostUploadVerifies(result.Size) {
+		return nil
+	}
+
+	sum, err := remoteSHA256(ctx, db.Dest, result.Filename, settings.Download)
+	if err != nil {
+		return fmt.Errorf("verifying upload: %w", err)
+	}
+	if sum != result.SHA256 {
+		return fmt.Errorf("%s checksum mismatch on the destination: expected %s, got %s", result.Filename, result.SHA256, sum)
+	}
+	return nil
+}
+
+// VerifyUploaded downloads a backup just uploaded, for post_upload_verify, and checks that
+// it matches the SHA-256 of the local dump and decompresses cleanly, proving the copy on
+// the destination can be restored. It returns a warning when the backup is above
+// post_upload_verify_max_mb and was not checked.
+func VerifyUploaded(ctx context.Context, db config.Database, result *backup.Result, settings backup.Settings) (string, error) {
+	if !db.PostUploadVerify {
+		return "", nil
+	}
+	if !db.PostUploadVerifies(result.Size) {
+		return fmt.Sprintf("post_upload_verify skipped: backup is larger than post_upload_verify_max_mb (%d MB)", db.PostUploadVerifyMaxMB), nil
+	}
+
+	rc, err := storage.Open(ctx, db.Dest, result.Filename)
+	if err != nil {
+		return "", fmt.Errorf("verifying upload: %w", err)
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	r := io.TeeReader(rc, h)
+	if backup.StreamVerifiable(result.Filename) {
+		err = backup.VerifyStream(r, result.Filename, settings)
+	}
+	// Read what the decompressor left (or the whole file) so the hash covers all of it
+	if _, copyErr := io.Copy(io.Discard, r); err == nil && copyErr != nil {
+		err = fmt.Errorf("reading backup: %w", copyErr)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s failed verification on the destination: %w", result.Filename, err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); result.SHA256 != "" && sum != result.SHA256 {
+		return "", fmt.Errorf("%s checksum mismatch on the destination: expected %s, got %s", result.Filename, result.SHA256, sum)
+	}
+	return "", nil
+}
+
+// KeepFailedUpload keeps a dump whose upload failed or couldn't be confirmed, moving it
+// to the failed uploads directory when keep_on_upload_failure is set. It returns where
+// the dump is, for the failure log.
+func KeepFailedUpload(cfg *config.Config, result *backup.Result) string {
+	dir := cfg.FailedUploadsDirectory()
+	if dir == "" || result.Kept {
+		return fmt.Sprintf("local copy kept at %s", result.Path)
+	}
+	if err := backup.MoveTo(result, dir); err != nil {
+		return fmt.Sprintf("local copy kept at %s, could not move it to %s: %v", result.Path, dir, err)
+	}
+	return fmt.Sprintf("local copy kept at %s", result.Path)
+}
+
+// KeepInLocalTier moves a dump into the local tier of a tiered dest, in its dated folder
+// with group_by. The dump is kept there rather than removed once uploaded, and the remote
+// tier is uploaded from it. Without a local tier it does nothing.
+func KeepInLocalTier(db config.Database, result *backup.Result) error {
+	tier := db.LocalTier()
+	if tier == "" {
+		return nil
+	}
+	localDB := db
+	localDB.Dest = tier
+	if err := backup.MoveTo(result, UploadDest(localDB, result.Filename)); err != nil {
+		return fmt.Errorf("writing to local tier: %w", err)
+	}
+	return nil
+}
+
+// LocalTierRetention applies the retention policy of the local tier of a tiered database
+// (local_retention, or the database's retention) to it. The tier is listed and pruned on
+// its own, so each tier keeps what its policy allows of its own backups. With dryRun
+// nothing is deleted and the message tells what would be. It returns an empty message
+// when there is no local tier, no policy for it or nothing to delete.
+func LocalTierRetention(ctx context.Context, db config.Database, name string, result *backup.Result, dryRun bool, concurrency retention.Concurrency) (string, []string, error) {
+	tier, policy := db.LocalTier(), db.LocalTierRetention()
+	if tier == "" || !policy.Enabled() {
+		return "", nil, nil
+	}
+	localDB := db
+	localDB.Dest = tier
+	files, err := listForRetention(ctx, localDB, name, concurrency)
+	if err != nil {
+		return "", nil, fmt.Errorf("local tier: %w", err)
+	}
+	files = withUploaded(localDB, files, result.Filename, result.Size)
+
+	toDelete := retention.Apply(ctx, files, db.BackupPrefix(name), policy, 0)
+	if len(toDelete) == 0 {
+		return "", nil, nil
+	}
+	if dryRun {
+		return retention.PlanMessage(toDelete), nil, nil
+	}
+	deleted := retention.Delete(ctx, tier, toDelete, concurrency)
+	return deleted.Message(), deleted.Warnings(), nil
+}
+
+// VerifyKept streams the newest verify_on_retention backups that retention keeps through
+// their decompressor, returning a warning for each that fails its format's checksum.
+// Backups without a stream checksum (uncompressed, zip) are not counted.
+func VerifyKept(ctx context.Context, db config.Database, name string, files, deleted []storage.RemoteFile, settings backup.Settings) []string {
+	var warnings []string
+	for _, f := range keptForVerification(db.BackupPrefix(name), files, deleted, db.VerifyOnRetention) {
+		if err := verifyRemote(ctx, db.Dest, f.Name, settings); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s failed verification: %v", f.Name, err))
+		}
+	}
+	return warnings
+}
+
+// keptForVerification returns up to limit of the backups starting with prefix that are
+// not being deleted and can be stream-verified, newest first
+func keptForVerification(prefix string, files, deleted []storage.RemoteFile, limit int) []storage.RemoteFile {
+	if limit <= 0 {
+		return nil
+	}
+	deleting := make(map[string]bool, len(deleted))
+	for _, f := range deleted {
+		deleting[f.Name] = true
+	}
+
+	var kept []storage.RemoteFile
+	for _, f := range files {
+		if backupName, ok := retention.BackupName(f.Name); !ok || backupName != prefix || deleting[f.Name] || !backup.StreamVerifiable(f.Name) {
+			continue
+		}
+		kept = append(kept, f)
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		return retention.BackupTime(kept[i]).After(retention.BackupTime(kept[j]))
+	})
+	if len(kept) > limit {
+		kept = kept[:limit]
+	}
+	return kept
+}
+
+func verifyRemote(ctx context.Context, dest, fileName string, settings backup.Settings) error {
+	rc, err := storage.Open(ctx, dest, fileName)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return backup.VerifyStream(rc, fileName, settings)
 }
 
-// RunBackups executes backups for the specified databases in parallel.
+// remoteSHA256 returns the SHA-256 of a remote file, downloading it when the backend
+// can't compute one
+func remoteSHA256(ctx context.Context, dest, fileName string, opts storage.DownloadOptions) (string, error) {
+	sum, err := storage.SHA256(ctx, dest, fileName)
+	if err != nil || sum != "" {
+		return sum, err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "blobber-verify-")
+	if err != nil {
+		return "", fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := storage.Download(ctx, dest, fileName, tmpDir, opts); err != nil {
+		return "", fmt.Errorf("downloading backup: %w", err)
+	}
+	return backup.FileSHA256(filepath.Join(tmpDir, fileName))
+}
+
+// RunDependencies returns the databases in queue that name is backed up after. Entries
+// of its after list that are not part of the run are not waited for.
+func RunDependencies(cfg *config.Config, name string, queue []string) []string {
+	var deps []string
+	for _, dep := range cfg.Databases[name].After {
+		if slices.Contains(queue, dep) {
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+// RunBackups executes backups for the specified databases in parallel. A database with
+// an after list starts once those databases have finished, whether or not they succeeded.
 // Progress updates are sent to the progress channel.
-// The function blocks until all backups complete.
+// The function blocks until all backups complete. If ctx carries a deadline, backups
+// still in progress when it expires are cancelled and reported with ErrDeadlineExceeded.
 // If databases is empty, all configured databases are backed up.
 func RunBackups(ctx context.Context, cfg *config.Config, databases []string, opts BackupOptions, retentionPlan RetentionPlan, progress chan<- BackupProgress) []BackupResult {
 	// If no databases specified, use all
@@ -99,14 +585,32 @@ func RunBackups(ctx context.Context, cfg *config.Config, databases []string, opt
 		}
 	}
 
+	// Databases of a consistency group dump while their group's session is consistent
+	groups := backup.NewConsistencyGroups(cfg, databases)
+	defer groups.Close()
+	ctx = backup.WithConsistencyGroups(ctx, groups)
+
 	var wg sync.WaitGroup
 	results := make([]BackupResult, len(databases))
 	resultsMu := sync.Mutex{}
 
+	// Closed when a database's backup finishes, releasing the databases after it
+	finished := make(map[string]chan struct{}, len(databases))
+	for _, name := range databases {
+		finished[name] = make(chan struct{})
+	}
+
 	for i, name := range databases {
 		wg.Add(1)
 		go func(idx int, dbName string) {
 			defer wg.Done()
+			defer close(finished[dbName])
+			for _, dep := range RunDependencies(cfg, dbName, databases) {
+				select {
+				case <-finished[dep]:
+				case <-ctx.Done():
+				}
+			}
 			result := runSingleBackup(ctx, cfg, dbName, opts, progress)
 			resultsMu.Lock()
 			results[idx] = result
@@ -121,48 +625,142 @@ func RunBackups(ctx context.Context, cfg *config.Config, databases []string, opt
 // runSingleBackup executes all backup steps for a single database
 func runSingleBackup(ctx context.Context, cfg *config.Config, name string, opts BackupOptions, progress chan<- BackupProgress) BackupResult {
 	db := cfg.Databases[name]
+	settings := backup.SettingsFrom(cfg)
 	result := BackupResult{DBName: name, Success: true}
 
+	// fail records a step failure, reporting deadline cancellation distinctly
+	fail := func(step BackupStep, err error) BackupResult {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = ErrDeadlineExceeded
+			result.DeadlineExceeded = true
+		}
+		progress <- BackupProgress{DBName: name, Step: step, Error: err, Done: true}
+		result.Success = false
+		result.Error = err
+		return result
+	}
+
 	// Step 1: Dump
 	progress <- BackupProgress{DBName: name, Step: StepDumping}
 
-	backupResult, err := backup.Run(name, db)
+	if err := ctx.Err(); err != nil {
+		backup.SkipConsistencyGroup(ctx, db)
+		return fail(StepDumping, err)
+	}
+
+	// The estimate is informational only, so failures are ignored
+	size, err := backup.EstimateSize(ctx, db, settings)
+	if err == nil && size > 0 {
+		progress <- BackupProgress{DBName: name, Step: StepDumping, EstimatedSize: size}
+	}
+	if err := CheckTempSpace(ctx, name, db, size, settings); err != nil {
+		backup.SkipConsistencyGroup(ctx, db)
+		return fail(StepDumping, err)
+	}
+
+	backupResult, err := backup.Run(ctx, name, db, settings)
 	if err != nil {
-		progress <- BackupProgress{DBName: name, Step: StepDumping, Error: err, Done: true}
-		result.Success = false
-		result.Error = err
-		return result
+		return fail(StepDumping, err)
 	}
-	// Skip cleanup in dry-run mode so user can access the file
-	if !opts.DryRun {
-		defer backup.Cleanup(backupResult)
+	// The local dump is removed only once the upload is confirmed (and never in dry-run
+	// mode, so the user can access the file). Until then it may be the only good copy.
+	keepLocal := func(err error) error {
+		return fmt.Errorf("%w (%s)", err, KeepFailedUpload(cfg, backupResult))
 	}
 
-	msg := fmt.Sprintf("Dumped %s (%s)", backupResult.Filename, humanize.IBytes(uint64(backupResult.Size)))
-	progress <- BackupProgress{DBName: name, Step: StepDumping, Message: msg}
-	result.Steps = append(result.Steps, BackupProgress{DBName: name, Step: StepDumping, Message: msg})
+	result.Filename = backupResult.Filename
+	result.Size = backupResult.Size
+	result.UncompressedSize = backupResult.UncompressedSize
+
+	msg := fmt.Sprintf("Dumped %s (%s)", backupResult.Filename, backupResult.SizeSummary())
+	progress <- BackupProgress{DBName: name, Step: StepDumping, Message: msg, Warnings: backupResult.Warnings}
+	result.Steps = append(result.Steps, BackupProgress{DBName: name, Step: StepDumping, Message: msg, Warnings: backupResult.Warnings})
 
 	// Step 2: Upload
 	if opts.DryRun {
 		msg := fmt.Sprintf("Upload skipped (dry-run), file at %s", backupResult.Path)
 		progress <- BackupProgress{DBName: name, Step: StepUploading, Message: msg, Skipped: true}
 		result.Steps = append(result.Steps, BackupProgress{DBName: name, Step: StepUploading, Message: msg, Skipped: true})
+	} else if opts.Stage {
+		// Upload and retention wait for upload-staged
+		if err := StageDump(cfg, name, backupResult); err != nil {
+			return fail(StepUploading, keepLocal(err))
+		}
+		msg := fmt.Sprintf("Staged at %s (blobber upload-staged uploads it)", backupResult.Path)
+		progress <- BackupProgress{DBName: name, Step: StepUploading, Message: msg, Skipped: true}
+		result.Steps = append(result.Steps, BackupProgress{DBName: name, Step: StepUploading, Message: msg, Skipped: true})
+		progress <- BackupProgress{DBName: name, Step: StepRetention, Message: "Applied by upload-staged", Skipped: true, Done: true}
+		result.Steps = append(result.Steps, BackupProgress{DBName: name, Step: StepRetention, Message: "Applied by upload-staged", Skipped: true})
+		return result
 	} else {
 		progress <- BackupProgress{DBName: name, Step: StepUploading}
 
-		if err := storage.Upload(ctx, backupResult.Path, db.Dest); err != nil {
-			progress <- BackupProgress{DBName: name, Step: StepUploading, Error: err, Done: true}
-			result.Success = false
-			result.Error = err
-			return result
+		msg, warnings, err := uploadBackup(ctx, db, name, backupResult, settings)
+		if err != nil {
+			return fail(StepUploading, keepLocal(err))
 		}
+		backup.Cleanup(backupResult)
+		result.UploadedSize = backupResult.Size
 
-		msg := fmt.Sprintf("Saved to %s", db.Dest)
-		progress <- BackupProgress{DBName: name, Step: StepUploading, Message: msg}
-		result.Steps = append(result.Steps, BackupProgress{DBName: name, Step: StepUploading, Message: msg})
+		progress <- BackupProgress{DBName: name, Step: StepUploading, Message: msg, Warnings: warnings}
+		result.Steps = append(result.Steps, BackupProgress{DBName: name, Step: StepUploading, Message: msg, Warnings: warnings})
 	}
 
 	// Step 3: Retention
+	applyRetention(ctx, db, name, backupResult, opts, progress, &result, settings, retention.ConcurrencyFrom(cfg))
+	return result
+}
+
+// uploadBackup uploads a dump to the destination of db (its dated folder with group_by),
+// with its checksum sidecar, and confirms it arrived. The dump is left in place: the
+// caller removes it once the upload succeeded, or keeps it. Returns the step message and
+// warnings.
+func uploadBackup(ctx context.Context, db config.Database, name string, backupResult *backup.Result, settings backup.Settings) (string, []string, error) {
+	// The upload steps work in the folder the backup goes in (dest itself without group_by)
+	uploadDB := db
+	uploadDB.Dest = UploadDest(db, backupResult.Filename)
+
+	if db.Immutable {
+		if err := CheckImmutableName(ctx, uploadDB, backupResult.Filename); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := KeepInLocalTier(db, backupResult); err != nil {
+		return "", nil, err
+	}
+	if err := storage.Upload(ctx, backupResult.Path, uploadDB.Dest, db.AtomicUpload); err != nil {
+		return "", nil, err
+	}
+
+	// The sidecar only speeds up verification, so a failure is reported but not fatal
+	var warnings []string
+	if err := storage.UploadChecksum(ctx, uploadDB.Dest, backupResult.Filename, backupResult.SHA256); err != nil {
+		warnings = append(warnings, fmt.Sprintf("checksum not saved: %v", err))
+	}
+
+	if err := ConfirmUpload(ctx, uploadDB, backupResult, settings); err != nil {
+		return "", nil, err
+	}
+	warning, err := VerifyUploaded(ctx, uploadDB, backupResult, settings)
+	if err != nil {
+		return "", nil, err
+	}
+	if warning != "" {
+		warnings = append(warnings, warning)
+	}
+	if warning := SizeAnomaly(ctx, db, name, backupResult.Filename); warning != "" {
+		warnings = append(warnings, warning)
+	}
+	written := storage.IndexChange{Written: []string{DestName(db, backupResult.Filename)}}
+	if warning := UpdateIndex(ctx, db, written); warning != "" {
+		warnings = append(warnings, warning)
+	}
+	return fmt.Sprintf("Saved to %s", uploadDB.Dest), warnings, nil
+}
+
+// applyRetention runs the retention step of a backup once its dump is uploaded,
+// recording it in result
+func applyRetention(ctx context.Context, db config.Database, name string, backupResult *backup.Result, opts BackupOptions, progress chan<- BackupProgress, result *BackupResult, settings backup.Settings, concurrency retention.Concurrency) {
 	// Re-calculate retention after upload to include the new file
 	if opts.DryRun {
 		progress <- BackupProgress{DBName: name, Step: StepRetention, Message: "Retention skipped (dry-run)", Skipped: true, Done: true}
@@ -170,38 +768,60 @@ func runSingleBackup(ctx context.Context, cfg *config.Config, name string, opts
 	} else if opts.SkipRetention {
 		progress <- BackupProgress{DBName: name, Step: StepRetention, Message: "Skipped (--skip-retention)", Skipped: true, Done: true}
 		result.Steps = append(result.Steps, BackupProgress{DBName: name, Step: StepRetention, Message: "Skipped (--skip-retention)", Skipped: true})
-	} else if db.Retention.KeepLast > 0 || db.Retention.KeepDays > 0 || db.Retention.MaxSizeMB > 0 {
+	} else if db.Immutable {
+		msg, warnings := ImmutableRetention(db)
+		progress <- BackupProgress{DBName: name, Step: StepRetention, Message: msg, Warnings: warnings, Skipped: true, Done: true}
+		result.Steps = append(result.Steps, BackupProgress{DBName: name, Step: StepRetention, Message: msg, Warnings: warnings, Skipped: true})
+	} else if err := opts.RetentionFailures[name]; err != nil {
+		progress <- BackupProgress{DBName: name, Step: StepRetention, Error: err, Done: true}
+		result.Steps = append(result.Steps, BackupProgress{DBName: name, Step: StepRetention, Error: err})
+		result.Error = err
+		return
+	} else if db.HasRetention() {
 		progress <- BackupProgress{DBName: name, Step: StepRetention}
 
-		// Re-fetch files after upload to get accurate count including new backup
-		files, err := storage.ListForDatabase(ctx, db.Dest, name)
+		files, toDelete, err := RetentionAfterUpload(ctx, db, name, backupResult, concurrency)
 		if err != nil {
 			progress <- BackupProgress{DBName: name, Step: StepRetention, Error: err, Done: true}
 			result.Steps = append(result.Steps, BackupProgress{DBName: name, Step: StepRetention, Error: err})
 			result.Error = err
-			return result
+			return
 		}
-
-		// pendingBackups=0 because the new backup already exists in files list
-		toDelete := retention.Apply(ctx, files, name, db.Retention, 0)
-		if len(toDelete) > 0 {
-			var deleted int
-			for _, f := range toDelete {
-				if err := storage.Delete(ctx, db.Dest, f.Name); err == nil {
-					deleted++
-				}
+		verifyWarnings := VerifyKept(ctx, db, name, files, toDelete, settings)
+		msg, warnings, skipped := "No old backups to delete", verifyWarnings, true
+		if len(toDelete) > 0 && opts.RetentionDryRun {
+			msg, skipped = retention.PlanMessage(toDelete), false
+		} else if len(toDelete) > 0 {
+			deleted := retention.Delete(ctx, db.Dest, toDelete, concurrency)
+			result.DeletedSize = deleted.DeletedBytes
+			msg, warnings, skipped = deleted.Message(), append(deleted.Warnings(), verifyWarnings...), false
+			if warning := UpdateIndex(ctx, db, storage.IndexChange{Deleted: deleted.Names}); warning != "" {
+				warnings = append(warnings, warning)
 			}
-			msg := fmt.Sprintf("Deleted %d old backup(s)", deleted)
-			progress <- BackupProgress{DBName: name, Step: StepRetention, Message: msg, Done: true}
-			result.Steps = append(result.Steps, BackupProgress{DBName: name, Step: StepRetention, Message: msg})
-		} else {
-			progress <- BackupProgress{DBName: name, Step: StepRetention, Message: "No old backups to delete", Skipped: true, Done: true}
-			result.Steps = append(result.Steps, BackupProgress{DBName: name, Step: StepRetention, Message: "No old backups to delete", Skipped: true})
 		}
+
+		// The local tier of a tiered dest has its own backups to apply the policy to
+		local, localWarnings, err := LocalTierRetention(ctx, db, name, backupResult, opts.RetentionDryRun, concurrency)
+		if err != nil {
+			progress <- BackupProgress{DBName: name, Step: StepRetention, Error: err, Done: true}
+			result.Steps = append(result.Steps, BackupProgress{DBName: name, Step: StepRetention, Error: err})
+			result.Error = err
+			return
+		}
+		if local != "" {
+			msg = fmt.Sprintf("%s; %s from the local tier", msg, local)
+			warnings = append(warnings, localWarnings...)
+			skipped = false
+		}
+		if opts.RetentionDryRun {
+			msg += " (dry-run)"
+		}
+
+		progress <- BackupProgress{DBName: name, Step: StepRetention, Message: msg, Warnings: warnings, Skipped: skipped, Done: true}
+		result.Steps = append(result.Steps, BackupProgress{DBName: name, Step: StepRetention, Message: msg, Warnings: warnings, Skipped: skipped})
 	} else {
 		progress <- BackupProgress{DBName: name, Step: StepRetention, Message: "No retention policy", Skipped: true, Done: true}
 		result.Steps = append(result.Steps, BackupProgress{DBName: name, Step: StepRetention, Message: "No retention policy", Skipped: true})
 	}
 
-	return result
 }
@@ -0,0 +1,2079 @@
+package orchestrator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Yoone/blobber/internal/backup"
+	"github.com/Yoone/blobber/internal/config"
+	"github.com/Yoone/blobber/internal/retention"
+	"github.com/Yoone/blobber/internal/storage"
+)
+
+func TestImmutableRetention(t *testing.T) {
+	tests := []struct {
+		name         string
+		db           config.Database
+		wantWarnings int
+	}{
+		{"no retention", config.Database{Immutable: true}, 0},
+		{"retention configured", config.Database{Immutable: true, Retention: config.Retention{KeepLast: 3}}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, warnings := ImmutableRetention(tt.db)
+			if msg != "Skipped (immutable destination)" {
+				t.Errorf("ImmutableRetention() message = %q", msg)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("ImmutableRetention() warnings = %q, want %d", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestBackupProgressJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		progress BackupProgress
+		want     string
+	}{
+		{
+			name:     "step starting",
+			progress: BackupProgress{DBName: "app", Step: StepDumping},
+			want:     `{"db":"app","step":"dumping","done":false}`,
+		},
+		{
+			name:     "estimate",
+			progress: BackupProgress{DBName: "app", Step: StepDumping, EstimatedSize: 1024},
+			want:     `{"db":"app","step":"dumping","done":false,"estimated_bytes":1024}`,
+		},
+		{
+			name:     "completed with warnings",
+			progress: BackupProgress{DBName: "app", Step: StepUploading, Message: "Saved", Done: true, Warnings: []string{"slow"}},
+			want:     `{"db":"app","step":"uploading","message":"Saved","done":true,"warnings":["slow"]}`,
+		},
+		{
+			name:     "skipped",
+			progress: BackupProgress{DBName: "app", Step: StepRetention, Message: "No retention policy", Done: true, Skipped: true},
+			want:     `{"db":"app","step":"retention","message":"No retention policy","done":true,"skipped":true}`,
+		},
+		{
+			name:     "failed",
+			progress: BackupProgress{DBName: "app", Step: StepUploading, Done: true, Error: errors.New("connection refused")},
+			want:     `{"db":"app","step":"uploading","done":true,"error":"connection refused"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.progress)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("json.Marshal() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunBackupsImmutableSkipsRetention(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcPath := filepath.Join(tmpDir, "app.db")
+	if err := os.WriteFile(srcPath, []byte("data"), 0644); err != nil {
+		t.Fatalf("writing source: %v", err)
+	}
+
+	dest := filepath.Join(tmpDir, "dest")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		t.Fatalf("creating dest: %v", err)
+	}
+	old := []string{"app_20240101_000000.db", "app_20240102_000000.db"}
+	for _, name := range old {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte("old"), 0644); err != nil {
+			t.Fatalf("writing old backup: %v", err)
+		}
+	}
+
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app": {
+			Type:        "file",
+			Path:        srcPath,
+			Dest:        dest,
+			Compression: "none",
+			Immutable:   true,
+			Retention:   config.Retention{KeepLast: 1},
+		},
+	}}
+
+	ctx := context.Background()
+	plan, failures := PreCheckRetention(ctx, cfg, []string{"app"})
+	if len(failures) > 0 {
+		t.Fatalf("PreCheckRetention() failures = %v", failures)
+	}
+	if len(plan["app"]) != 0 {
+		t.Errorf("PreCheckRetention() planned %d deletions on an immutable destination", len(plan["app"]))
+	}
+
+	progress := make(chan BackupProgress, 100)
+	results := RunBackups(ctx, cfg, []string{"app"}, BackupOptions{}, plan, progress)
+	close(progress)
+
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("RunBackups() = %+v, want one successful result", results)
+	}
+	for _, name := range old {
+		if _, err := os.Stat(filepath.Join(dest, name)); err != nil {
+			t.Errorf("old backup %s was removed: %v", name, err)
+		}
+	}
+
+	var retentionStep *BackupProgress
+	for i, step := range results[0].Steps {
+		if step.Step == StepRetention {
+			retentionStep = &results[0].Steps[i]
+		}
+	}
+	if retentionStep == nil || !retentionStep.Skipped || len(retentionStep.Warnings) != 1 {
+		t.Errorf("retention step = %+v, want skipped with one warning", retentionStep)
+	}
+}
+
+func TestExpandEntry(t *testing.T) {
+	db := config.Database{Type: "mysql", Host: "db1", Database: "*"}
+	existing := map[string]config.Database{"server": db, "server_legacy": {Type: "mysql"}}
+
+	tests := []struct {
+		name         string
+		found        []string
+		wantEntries  []string
+		wantWarnings int
+	}{
+		{"databases found", []string{"blog", "shop"}, []string{"server_blog", "server_shop"}, 0},
+		{"unsafe name skipped", []string{"shop", "my.db"}, []string{"server_shop"}, 1},
+		{"existing entry skipped", []string{"legacy", "shop"}, []string{"server_shop"}, 1},
+		{"no databases", nil, nil, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, warnings := expandEntry("server", db, tt.found, existing)
+			if len(entries) != len(tt.wantEntries) {
+				t.Fatalf("expandEntry() entries = %v, want %v", entries, tt.wantEntries)
+			}
+			for i := range entries {
+				if entries[i] != tt.wantEntries[i] {
+					t.Errorf("expandEntry() entries = %v, want %v", entries, tt.wantEntries)
+				}
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("expandEntry() warnings = %q, want %d", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestExpandedConfig(t *testing.T) {
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"server":        {Type: "mysql", Host: "db1", Database: "*", FilePrefix: "prod", ExcludeDatabases: []string{"tmp"}},
+		"server_legacy": {Type: "mysql"},
+	}}
+
+	expanded, entry, err := ExpandedConfig(cfg, "server", "shop")
+	if err != nil {
+		t.Fatalf("ExpandedConfig() error = %v", err)
+	}
+	if entry != "server_shop" {
+		t.Errorf("ExpandedConfig() entry = %q, want server_shop", entry)
+	}
+	db := expanded.Databases[entry]
+	if db.Database != "shop" || db.ExpandedFrom != "server" || db.FilePrefix != "prod_shop" || db.ExcludeDatabases != nil {
+		t.Errorf("expanded entry = %+v, want database shop of server", db)
+	}
+	if len(expanded.Databases) != 3 || len(cfg.Databases) != 2 {
+		t.Errorf("ExpandedConfig() has %d entries and left %d in cfg, want 3 and 2", len(expanded.Databases), len(cfg.Databases))
+	}
+
+	if _, _, err := ExpandedConfig(cfg, "server", "legacy"); err == nil {
+		t.Error("ExpandedConfig() error = nil for an existing entry")
+	}
+	if _, _, err := ExpandedConfig(cfg, "server", "my.db"); err == nil {
+		t.Error("ExpandedConfig() error = nil for an unsafe name")
+	}
+}
+
+func TestRestoreTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		db       config.Database
+		fileName string
+		want     string
+		wantErr  bool
+	}{
+		{"single database", config.Database{Database: "app"}, "server_20240115_143022.sql", "app", false},
+		{"all databases", config.Database{Database: "*"}, "server_shop_20240115_143022.sql.gz", "shop", false},
+		{"underscore in database name", config.Database{Database: "*"}, "server_shop_eu_20240115_143022.sql", "shop_eu", false},
+		{"backup of the entry itself", config.Database{Database: "*"}, "server_20240115_143022.sql", "", true},
+		{"other naming", config.Database{Database: "*"}, "dump.sql", "", true},
+		{"file prefix", config.Database{Database: "*", FilePrefix: "prod"}, "prod_shop_20240115_143022.sql", "shop", false},
+		{"entry name with file prefix", config.Database{Database: "*", FilePrefix: "prod"}, "server_shop_20240115_143022.sql", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RestoreTarget("server", tt.db, tt.fileName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RestoreTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Database != tt.want {
+				t.Errorf("RestoreTarget() database = %q, want %q", got.Database, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfirmUpload(t *testing.T) {
+	tmpDir := t.TempDir()
+	localPath := filepath.Join(tmpDir, "app_20240115_143022.db")
+	if err := os.WriteFile(localPath, []byte("data"), 0644); err != nil {
+		t.Fatalf("writing local dump: %v", err)
+	}
+	sum, err := backup.FileSHA256(localPath)
+	if err != nil {
+		t.Fatalf("hashing local dump: %v", err)
+	}
+	result := &backup.Result{Filename: "app_20240115_143022.db", Path: localPath, Size: 4, SHA256: sum}
+
+	tests := []struct {
+		name    string
+		remote  string // content on the destination, "" for missing
+		verify  bool
+		wantErr string
+	}{
+		{"present", "data", false, ""},
+		{"present and verified", "data", true, ""},
+		{"missing", "", false, "missing on the destination"},
+		{"size mismatch", "dat", false, "is 3 bytes on the destination, expected 4"},
+		{"same size, different content", "DATA", false, ""},
+		{"checksum mismatch", "DATA", true, "checksum mismatch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := t.TempDir()
+			if tt.remote != "" {
+				if err := os.WriteFile(filepath.Join(dest, result.Filename), []byte(tt.remote), 0644); err != nil {
+					t.Fatalf("writing remote copy: %v", err)
+				}
+			}
+
+			err := ConfirmUpload(context.Background(), config.Database{Dest: dest, VerifyUpload: tt.verify}, result, backup.Settings{})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ConfirmUpload() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ConfirmUpload() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyUploaded(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("CREATE TABLE t (id int);\n"))
+	w.Close()
+	good := gz.Bytes()
+	// Same size, but the gzip CRC no longer matches
+	corrupt := append([]byte(nil), good...)
+	corrupt[len(corrupt)-8] ^= 0xff
+
+	localPath := filepath.Join(t.TempDir(), "app_20240115_143022.sql.gz")
+	if err := os.WriteFile(localPath, good, 0644); err != nil {
+		t.Fatalf("writing local dump: %v", err)
+	}
+	sum, err := backup.FileSHA256(localPath)
+	if err != nil {
+		t.Fatalf("hashing local dump: %v", err)
+	}
+	result := &backup.Result{Filename: "app_20240115_143022.sql.gz", Path: localPath, Size: int64(len(good)), SHA256: sum}
+
+	tests := []struct {
+		name        string
+		db          config.Database
+		remote      []byte
+		wantErr     string
+		wantWarning string
+	}{
+		{"disabled", config.Database{}, corrupt, "", ""},
+		{"intact", config.Database{PostUploadVerify: true}, good, "", ""},
+		{"corrupt", config.Database{PostUploadVerify: true}, corrupt, "failed verification", ""},
+		{"within max size", config.Database{PostUploadVerify: true, PostUploadVerifyMaxMB: 1}, corrupt, "failed verification", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dest, result.Filename), tt.remote, 0644); err != nil {
+				t.Fatalf("writing remote copy: %v", err)
+			}
+			tt.db.Dest = dest
+
+			warning, err := VerifyUploaded(context.Background(), tt.db, result, backup.Settings{})
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("VerifyUploaded() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("VerifyUploaded() error = %v, want %q", err, tt.wantErr)
+			}
+			if warning != tt.wantWarning {
+				t.Errorf("VerifyUploaded() warning = %q, want %q", warning, tt.wantWarning)
+			}
+		})
+	}
+
+	t.Run("larger than max size", func(t *testing.T) {
+		big := *result
+		big.Size = 2 * 1024 * 1024
+		warning, err := VerifyUploaded(context.Background(), config.Database{Dest: t.TempDir(), PostUploadVerify: true, PostUploadVerifyMaxMB: 1}, &big, backup.Settings{})
+		if err != nil || !strings.Contains(warning, "post_upload_verify skipped") {
+			t.Errorf("VerifyUploaded() = %q, %v; want a skipped warning", warning, err)
+		}
+	})
+
+	t.Run("uncompressed checksum mismatch", func(t *testing.T) {
+		plain := &backup.Result{Filename: "app_20240115_143022.db", Size: 4, SHA256: sum}
+		dest := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dest, plain.Filename), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := VerifyUploaded(context.Background(), config.Database{Dest: dest, PostUploadVerify: true}, plain, backup.Settings{})
+		if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Errorf("VerifyUploaded() error = %v, want a checksum mismatch", err)
+		}
+	})
+}
+
+func TestRunBackupsLocalCopy(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcPath := filepath.Join(tmpDir, "app.db")
+	if err := os.WriteFile(srcPath, []byte("data"), 0644); err != nil {
+		t.Fatalf("writing source: %v", err)
+	}
+	// A dest below a regular file can't be created, so the upload fails
+	blocked := filepath.Join(tmpDir, "blocked")
+	if err := os.WriteFile(blocked, nil, 0644); err != nil {
+		t.Fatalf("writing blocker: %v", err)
+	}
+
+	failedDir := filepath.Join(tmpDir, "failed")
+
+	tests := []struct {
+		name        string
+		dest        string
+		keep        bool
+		wantSuccess bool
+		wantKept    bool
+	}{
+		{"removed once upload is confirmed", filepath.Join(tmpDir, "dest"), false, true, false},
+		{"kept when upload fails", filepath.Join(blocked, "dest"), false, false, true},
+		{"moved when upload fails with keep_on_upload_failure", filepath.Join(blocked, "dest"), true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dumpDir := t.TempDir()
+			t.Setenv("TMPDIR", dumpDir)
+
+			cfg := &config.Config{KeepOnUploadFailure: tt.keep, FailedUploadsDir: failedDir, Databases: map[string]config.Database{
+				"app": {Type: "file", Path: srcPath, Dest: tt.dest, Compression: "none", VerifyUpload: true},
+			}}
+			progress := make(chan BackupProgress, 100)
+			results := RunBackups(context.Background(), cfg, []string{"app"}, BackupOptions{}, nil, progress)
+			close(progress)
+
+			if len(results) != 1 || results[0].Success != tt.wantSuccess {
+				t.Fatalf("RunBackups() = %+v, want success %v", results, tt.wantSuccess)
+			}
+			if !tt.wantSuccess && !strings.Contains(results[0].Error.Error(), "local copy kept at") {
+				t.Errorf("RunBackups() error = %v, want it to mention the local copy", results[0].Error)
+			}
+
+			kept, _ := filepath.Glob(filepath.Join(dumpDir, "blobber-*", "app_*.db"))
+			if (len(kept) > 0) != tt.wantKept {
+				t.Errorf("local dump kept = %v, want %v", kept, tt.wantKept)
+			}
+			if tt.keep {
+				moved, _ := filepath.Glob(filepath.Join(failedDir, "app_*.db"))
+				if len(moved) != 1 || !strings.Contains(results[0].Error.Error(), moved[0]) {
+					t.Errorf("moved = %v, error = %v, want the dump in %s", moved, results[0].Error, failedDir)
+				}
+			}
+		})
+	}
+}
+
+func TestRunBackupsStorageChange(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	tmpDir := t.TempDir()
+	srcPath := filepath.Join(tmpDir, "app.db")
+	if err := os.WriteFile(srcPath, []byte("data"), 0644); err != nil {
+		t.Fatalf("writing source: %v", err)
+	}
+	dest := filepath.Join(tmpDir, "dest")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		t.Fatalf("creating dest: %v", err)
+	}
+	for _, name := range []string{"app_20240101_000000.db", "app_20240102_000000.db"} {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte("old backup"), 0644); err != nil {
+			t.Fatalf("writing old backup: %v", err)
+		}
+	}
+
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app": {Type: "file", Path: srcPath, Dest: dest, Compression: "none", Retention: config.Retention{KeepLast: 1}},
+	}}
+	progress := make(chan BackupProgress, 100)
+	results := RunBackups(context.Background(), cfg, []string{"app"}, BackupOptions{}, nil, progress)
+	close(progress)
+
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("RunBackups() = %+v, want success", results)
+	}
+	want := StorageChange{Uploaded: 4, Deleted: 20}
+	if got := SumStorageChange(results); got != want {
+		t.Errorf("SumStorageChange() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRunBackupsTieredDest(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	tmpDir := t.TempDir()
+	srcPath := filepath.Join(tmpDir, "app.db")
+	if err := os.WriteFile(srcPath, []byte("data"), 0644); err != nil {
+		t.Fatalf("writing source: %v", err)
+	}
+
+	// The tiers hold different old backups, so each applies keep_last to its own
+	local := filepath.Join(tmpDir, "local")
+	remote := filepath.Join(tmpDir, "remote")
+	old := map[string][]string{
+		local:  {"app_20240101_000000.db", "app_20240102_000000.db", "app_20240103_000000.db"},
+		remote: {"app_20240103_000000.db"},
+	}
+	for dir, names := range old {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("creating %s: %v", dir, err)
+		}
+		for _, name := range names {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte("old backup"), 0644); err != nil {
+				t.Fatalf("writing old backup: %v", err)
+			}
+		}
+	}
+
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app": {
+			Type: "file", Path: srcPath, Dest: remote, Compression: "none",
+			DestTiers: &config.TieredDest{Local: local, Remote: remote},
+			Retention: config.Retention{KeepLast: 2},
+		},
+	}}
+	progress := make(chan BackupProgress, 100)
+	results := RunBackups(context.Background(), cfg, []string{"app"}, BackupOptions{}, nil, progress)
+	close(progress)
+
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("RunBackups() = %+v, want success", results)
+	}
+	newest := results[0].Filename
+	want := map[string][]string{
+		local:  {"app_20240103_000000.db", newest},
+		remote: {"app_20240103_000000.db", newest},
+	}
+	for dir, wantNames := range want {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("reading %s: %v", dir, err)
+		}
+		var names []string
+		for _, e := range entries {
+			if !strings.HasSuffix(e.Name(), storage.ChecksumExt) {
+				names = append(names, e.Name())
+			}
+		}
+		if !slices.Equal(names, wantNames) {
+			t.Errorf("%s holds %v, want %v", filepath.Base(dir), names, wantNames)
+		}
+	}
+	if last := results[0].Steps[len(results[0].Steps)-1]; !strings.Contains(last.Message, "Deleted 2 old backup(s) from the local tier") {
+		t.Errorf("retention message = %q, want the local tier's deletions", last.Message)
+	}
+}
+
+func TestRunBackupsTierRetention(t *testing.T) {
+	oldNames := []string{"app_20240101_000000.db", "app_20240102_000000.db", "app_20240103_000000.db"}
+	tests := []struct {
+		name       string
+		tiers      func(local, remote string) *config.TieredDest
+		retention  config.Retention
+		wantLocal  []string // old backups kept besides the new one
+		wantRemote []string
+	}{
+		{
+			// The local tier keeps the newest only, the remote tier a longer history;
+			// the database's retention is overridden by both
+			name: "divergent policies",
+			tiers: func(local, remote string) *config.TieredDest {
+				return &config.TieredDest{
+					Local: local, Remote: remote,
+					LocalRetention:  &config.Retention{KeepLast: 1},
+					RemoteRetention: &config.Retention{KeepLast: 3},
+				}
+			},
+			retention:  config.Retention{KeepLast: 2},
+			wantLocal:  nil,
+			wantRemote: oldNames[1:],
+		},
+		{
+			name: "remote falls back to the database",
+			tiers: func(local, remote string) *config.TieredDest {
+				return &config.TieredDest{Local: local, Remote: remote, LocalRetention: &config.Retention{KeepLast: 1}}
+			},
+			retention:  config.Retention{KeepLast: 2},
+			wantLocal:  nil,
+			wantRemote: oldNames[2:],
+		},
+		{
+			name: "only the local tier has a policy",
+			tiers: func(local, remote string) *config.TieredDest {
+				return &config.TieredDest{Local: local, Remote: remote, LocalRetention: &config.Retention{KeepLast: 2}}
+			},
+			wantLocal:  oldNames[2:],
+			wantRemote: oldNames,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TMPDIR", t.TempDir())
+			tmpDir := t.TempDir()
+			srcPath := filepath.Join(tmpDir, "app.db")
+			if err := os.WriteFile(srcPath, []byte("data"), 0644); err != nil {
+				t.Fatalf("writing source: %v", err)
+			}
+			local := filepath.Join(tmpDir, "local")
+			remote := filepath.Join(tmpDir, "remote")
+			for _, dir := range []string{local, remote} {
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					t.Fatalf("creating %s: %v", dir, err)
+				}
+				for _, name := range oldNames {
+					if err := os.WriteFile(filepath.Join(dir, name), []byte("old backup"), 0644); err != nil {
+						t.Fatalf("writing old backup: %v", err)
+					}
+				}
+			}
+
+			cfg := &config.Config{Databases: map[string]config.Database{
+				"app": {
+					Type: "file", Path: srcPath, Dest: remote, Compression: "none",
+					DestTiers: tt.tiers(local, remote),
+					Retention: tt.retention,
+				},
+			}}
+			progress := make(chan BackupProgress, 100)
+			results := RunBackups(context.Background(), cfg, []string{"app"}, BackupOptions{}, nil, progress)
+			close(progress)
+
+			if len(results) != 1 || !results[0].Success {
+				t.Fatalf("RunBackups() = %+v, want success", results)
+			}
+			newest := results[0].Filename
+			want := map[string][]string{
+				local:  append(slices.Clone(tt.wantLocal), newest),
+				remote: append(slices.Clone(tt.wantRemote), newest),
+			}
+			for dir, wantNames := range want {
+				entries, err := os.ReadDir(dir)
+				if err != nil {
+					t.Fatalf("reading %s: %v", dir, err)
+				}
+				var names []string
+				for _, e := range entries {
+					if !strings.HasSuffix(e.Name(), storage.ChecksumExt) {
+						names = append(names, e.Name())
+					}
+				}
+				if !slices.Equal(names, wantNames) {
+					t.Errorf("%s holds %v, want %v", filepath.Base(dir), names, wantNames)
+				}
+			}
+		})
+	}
+}
+
+func TestRunBackupsRetentionDryRun(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	tmpDir := t.TempDir()
+	srcPath := filepath.Join(tmpDir, "app.db")
+	if err := os.WriteFile(srcPath, []byte("data"), 0644); err != nil {
+		t.Fatalf("writing source: %v", err)
+	}
+
+	local := filepath.Join(tmpDir, "local")
+	remote := filepath.Join(tmpDir, "remote")
+	old := map[string][]string{
+		local:  {"app_20240101_000000.db"},
+		remote: {"app_20240101_000000.db", "app_20240102_000000.db", "app_20240103_000000.db"},
+	}
+	for dir, names := range old {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("creating %s: %v", dir, err)
+		}
+		for _, name := range names {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte("old backup"), 0644); err != nil {
+				t.Fatalf("writing old backup: %v", err)
+			}
+		}
+	}
+
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app": {
+			Type: "file", Path: srcPath, Dest: remote, Compression: "none",
+			DestTiers: &config.TieredDest{Local: local, Remote: remote},
+			Retention: config.Retention{KeepLast: 1},
+		},
+	}}
+	progress := make(chan BackupProgress, 100)
+	results := RunBackups(context.Background(), cfg, []string{"app"}, BackupOptions{RetentionDryRun: true}, nil, progress)
+	close(progress)
+
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("RunBackups() = %+v, want success", results)
+	}
+	if results[0].DeletedSize != 0 {
+		t.Errorf("DeletedSize = %d, want 0", results[0].DeletedSize)
+	}
+	newest := results[0].Filename
+	for dir, names := range old {
+		for _, name := range append(names, newest) {
+			if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+				t.Errorf("%s was deleted from %s: %v", name, filepath.Base(dir), err)
+			}
+		}
+	}
+
+	last := results[0].Steps[len(results[0].Steps)-1]
+	want := "Would delete 3 old backup(s); Would delete 1 old backup(s) from the local tier (dry-run)"
+	if last.Step != StepRetention || last.Message != want || last.Skipped {
+		t.Errorf("retention step = %+v, want message %q", last, want)
+	}
+}
+
+func TestStageDumpEntry(t *testing.T) {
+	staging := t.TempDir()
+	all := config.Database{Type: "mysql", Host: "db", Port: 3306, User: "u", Database: "*", Dest: "/backups"}
+	cfg := &config.Config{StagingDir: staging, Databases: map[string]config.Database{
+		"all": all,
+		// Its own entry, though named as "all" would name its database shop
+		"all_shop": {Type: "mysql", Host: "other", Port: 3306, User: "u", Database: "shop", Dest: "/backups"},
+		"all_blog": expandedDatabase("all", all, "blog"),
+	}}
+
+	for _, name := range []string{"all_shop", "all_blog"} {
+		dumpPath := filepath.Join(t.TempDir(), name+".sql")
+		if err := os.WriteFile(dumpPath, []byte("dump"), 0600); err != nil {
+			t.Fatalf("writing dump: %v", err)
+		}
+		result := &backup.Result{Name: name, Filename: name + "_20240101_000000.sql", Path: dumpPath, Size: 4}
+		if err := StageDump(cfg, name, result); err != nil {
+			t.Fatalf("StageDump(%s) error = %v", name, err)
+		}
+	}
+
+	staged, err := ListStaged(cfg)
+	if err != nil {
+		t.Fatalf("ListStaged() error = %v", err)
+	}
+	entries := map[string]string{}
+	for _, s := range staged {
+		entries[s.Name] = s.Entry + "/" + s.Database
+	}
+	if entries["all_shop"] != "all_shop/" || entries["all_blog"] != "all/blog" {
+		t.Errorf("staged entries = %v, want all_shop as its own entry and all_blog from all", entries)
+	}
+}
+
+func TestStagedBackup(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	tmpDir := t.TempDir()
+	srcPath := filepath.Join(tmpDir, "app.db")
+	if err := os.WriteFile(srcPath, []byte("data"), 0644); err != nil {
+		t.Fatalf("writing source: %v", err)
+	}
+	dest := filepath.Join(tmpDir, "dest")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		t.Fatalf("creating dest: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dest, "app_20240101_000000.db"), []byte("old backup"), 0644); err != nil {
+		t.Fatalf("writing old backup: %v", err)
+	}
+	staging := filepath.Join(tmpDir, "staged")
+
+	cfg := &config.Config{StagingDir: staging, Databases: map[string]config.Database{
+		"app": {Type: "file", Path: srcPath, Dest: dest, Compression: "none", Retention: config.Retention{KeepLast: 1}},
+	}}
+
+	// Staging dumps without touching the destination
+	progress := make(chan BackupProgress, 100)
+	results := RunBackups(context.Background(), cfg, []string{"app"}, BackupOptions{Stage: true}, nil, progress)
+	close(progress)
+	if len(results) != 1 || !results[0].Success || results[0].UploadedSize != 0 {
+		t.Fatalf("RunBackups() = %+v, want a staged dump", results)
+	}
+	filename := results[0].Filename
+	if _, err := os.Stat(filepath.Join(dest, filename)); !os.IsNotExist(err) {
+		t.Errorf("%s uploaded by a staged run (stat error %v)", filename, err)
+	}
+
+	staged, err := ListStaged(cfg)
+	if err != nil {
+		t.Fatalf("ListStaged() error = %v", err)
+	}
+	if len(staged) != 1 || staged[0].Name != "app" || staged[0].Filename != filename || staged[0].Path != filepath.Join(staging, filename) {
+		t.Fatalf("ListStaged() = %+v, want %s staged in %s", staged, filename, staging)
+	}
+
+	// An entry removed from the config leaves its dumps staged
+	if _, uploadable, warnings := StagedConfig(&config.Config{}, staged); len(uploadable) != 0 || len(warnings) != 1 {
+		t.Errorf("StagedConfig() without the entry = %v, %v, want the dump left staged with a warning", uploadable, warnings)
+	}
+
+	// A failed upload stays staged
+	blocked := filepath.Join(tmpDir, "blocked")
+	if err := os.WriteFile(blocked, nil, 0644); err != nil {
+		t.Fatalf("writing blocker: %v", err)
+	}
+	blockedCfg := &config.Config{StagingDir: staging, Databases: map[string]config.Database{
+		"app": {Type: "file", Path: srcPath, Dest: filepath.Join(blocked, "dest"), Compression: "none"},
+	}}
+	progress = make(chan BackupProgress, 100)
+	results = RunStagedUploads(context.Background(), blockedCfg, staged, BackupOptions{}, progress)
+	close(progress)
+	if len(results) != 1 || results[0].Success || !strings.Contains(results[0].Error.Error(), "still staged at") {
+		t.Fatalf("RunStagedUploads() to a blocked dest = %+v, want a failure leaving the dump staged", results)
+	}
+	if again, _ := ListStaged(cfg); len(again) != 1 {
+		t.Errorf("ListStaged() after a failed upload = %+v, want the dump still staged", again)
+	}
+
+	// Uploading applies retention and empties the staging directory
+	runCfg, uploadable, warnings := StagedConfig(cfg, staged)
+	if len(uploadable) != 1 || len(warnings) != 0 {
+		t.Fatalf("StagedConfig() = %v, %v, want the dump uploadable", uploadable, warnings)
+	}
+	progress = make(chan BackupProgress, 100)
+	results = RunStagedUploads(context.Background(), runCfg, uploadable, BackupOptions{}, progress)
+	close(progress)
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("RunStagedUploads() = %+v, want success", results)
+	}
+	want := StorageChange{Uploaded: 4, Deleted: 10}
+	if got := SumStorageChange(results); got != want {
+		t.Errorf("SumStorageChange() = %+v, want %+v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dest, filename)); err != nil {
+		t.Errorf("%s not uploaded: %v", filename, err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "app_20240101_000000.db")); !os.IsNotExist(err) {
+		t.Errorf("old backup not deleted by retention (stat error %v)", err)
+	}
+	if left, _ := os.ReadDir(staging); len(left) != 0 {
+		t.Errorf("staging directory holds %v after the upload, want it empty", left)
+	}
+}
+
+func TestStorageChangeString(t *testing.T) {
+	tests := []struct {
+		name   string
+		change StorageChange
+		want   string
+	}{
+		{"growing", StorageChange{Uploaded: 3 << 30, Deleted: 2 << 30}, "Uploaded 3.0 GiB, deleted 2.0 GiB (net +1.0 GiB)"},
+		{"shrinking", StorageChange{Uploaded: 1 << 20, Deleted: 3 << 20}, "Uploaded 1.0 MiB, deleted 3.0 MiB (net -2.0 MiB)"},
+		{"unchanged", StorageChange{Uploaded: 512, Deleted: 512}, "Uploaded 512 B, deleted 512 B (net 0 B)"},
+		{"nothing deleted", StorageChange{Uploaded: 2048}, "Uploaded 2.0 KiB, deleted 0 B (net +2.0 KiB)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.change.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunBackupsAtomicUpload(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	tmpDir := t.TempDir()
+	srcPath := filepath.Join(tmpDir, "app.db")
+	if err := os.WriteFile(srcPath, []byte("data"), 0644); err != nil {
+		t.Fatalf("writing source: %v", err)
+	}
+	dest := filepath.Join(tmpDir, "dest")
+
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app": {Type: "file", Path: srcPath, Dest: dest, Compression: "none", AtomicUpload: true},
+	}}
+	progress := make(chan BackupProgress, 100)
+	results := RunBackups(context.Background(), cfg, []string{"app"}, BackupOptions{}, nil, progress)
+	close(progress)
+
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("RunBackups() = %+v, want success", results)
+	}
+	if _, err := os.Stat(filepath.Join(dest, results[0].Filename)); err != nil {
+		t.Errorf("backup not at its final name: %v", err)
+	}
+	if parts, _ := filepath.Glob(filepath.Join(dest, "*"+storage.PartExt)); len(parts) > 0 {
+		t.Errorf("partial uploads left behind: %v", parts)
+	}
+}
+
+func TestPartialUploadIgnored(t *testing.T) {
+	// An interrupted atomic upload leaves only the .part file
+	dest := t.TempDir()
+	part := "app_20240102_000000.db" + storage.PartExt
+	serverPart := "server_shop_20240102_000000.sql" + storage.PartExt
+	for _, name := range []string{"app_20240101_000000.db", part, serverPart} {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte("data"), 0644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		entry string
+		db    config.Database
+	}{
+		{"single database", "app", config.Database{Type: "file", Dest: dest, Retention: config.Retention{KeepLast: 1}}},
+		{"all databases", "server", config.Database{Type: "mysql", Database: "*", Dest: dest}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := ListBackups(ctx, tt.entry, tt.db)
+			if err != nil {
+				t.Fatalf("ListBackups() error = %v", err)
+			}
+			for _, f := range files {
+				if strings.HasSuffix(f.Name, storage.PartExt) {
+					t.Errorf("ListBackups() offers %s for restore", f.Name)
+				}
+			}
+		})
+	}
+
+	// Retention neither counts nor deletes it
+	cfg := &config.Config{Databases: map[string]config.Database{"app": tests[0].db}}
+	plan, failures := PlanRetention(ctx, cfg, []string{"app"}, 0)
+	if len(failures) > 0 {
+		t.Fatalf("PlanRetention() failures = %v", failures)
+	}
+	if len(plan["app"]) != 0 {
+		t.Errorf("PlanRetention() = %v, want nothing to delete", plan["app"])
+	}
+}
+
+func TestVerifyKept(t *testing.T) {
+	dest := t.TempDir()
+	good := gzipBytes(t, "CREATE TABLE t (id INT);")
+	corrupt := append([]byte(nil), good...)
+	corrupt[len(corrupt)-10] ^= 0xff
+
+	remote := map[string][]byte{
+		"app_20240103_000000.sql.gz":   corrupt,
+		"app_20240102_000000.sql.gz":   good,
+		"app_20240101_000000.sql.gz":   corrupt, // older than the limit
+		"app_20240104_000000.sql":      []byte("plain"),
+		"app_20231231_000000.sql.gz":   corrupt, // being deleted
+		"other_20240105_000000.sql.gz": corrupt,
+	}
+	var files []storage.RemoteFile
+	for name, data := range remote {
+		if err := os.WriteFile(filepath.Join(dest, name), data, 0644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+		files = append(files, storage.RemoteFile{Name: name})
+	}
+	deleted := []storage.RemoteFile{{Name: "app_20231231_000000.sql.gz"}}
+
+	tests := []struct {
+		name         string
+		limit        int
+		wantWarnings []string
+	}{
+		{"disabled", 0, nil},
+		{"newest two", 2, []string{"app_20240103_000000.sql.gz"}},
+		{"all kept", 10, []string{"app_20240103_000000.sql.gz", "app_20240101_000000.sql.gz"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := config.Database{Dest: dest, VerifyOnRetention: tt.limit}
+			warnings := VerifyKept(context.Background(), db, "app", files, deleted, backup.Settings{})
+			if len(warnings) != len(tt.wantWarnings) {
+				t.Fatalf("VerifyKept() = %q, want warnings for %v", warnings, tt.wantWarnings)
+			}
+			for i, name := range tt.wantWarnings {
+				if !strings.HasPrefix(warnings[i], name+" failed verification") {
+					t.Errorf("VerifyKept()[%d] = %q, want a failure for %s", i, warnings[i], name)
+				}
+			}
+		})
+	}
+}
+
+func TestWithUploaded(t *testing.T) {
+	listed := []storage.RemoteFile{
+		{Name: "app_20240101_000000.sql.gz"},
+		{Name: "app_20240102_000000.sql.gz"},
+		{Name: "app_20240103_000000.sql.gz"},
+	}
+	uploaded := "app_20240104_000000.sql.gz"
+
+	tests := []struct {
+		name       string
+		groupBy    string
+		files      []storage.RemoteFile
+		wantAdded  string
+		wantDelete []string
+	}{
+		{"listing omits the new backup", "", listed, uploaded, []string{"app_20240101_000000.sql.gz"}},
+		{"listing omits it in its folder", "day", listed, "2024-01-04/" + uploaded, []string{"app_20240101_000000.sql.gz"}},
+		{"listing shows it", "", append(slices.Clone(listed), storage.RemoteFile{Name: uploaded}), "", []string{"app_20240101_000000.sql.gz"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := config.Database{GroupBy: tt.groupBy, Retention: config.Retention{KeepLast: 3}}
+			files := withUploaded(db, tt.files, uploaded, 100)
+
+			if tt.wantAdded == "" && len(files) != len(tt.files) {
+				t.Errorf("withUploaded() = %v, want the listing unchanged", files)
+			}
+			if tt.wantAdded != "" && (len(files) != len(tt.files)+1 || files[len(files)-1].Name != tt.wantAdded) {
+				t.Errorf("withUploaded() = %v, want %s added", files, tt.wantAdded)
+			}
+
+			var deleted []string
+			for _, f := range retention.Apply(context.Background(), files, "app", db.Retention, 0) {
+				deleted = append(deleted, f.Name)
+			}
+			if !slices.Equal(deleted, tt.wantDelete) {
+				t.Errorf("retention deletes %v, want %v", deleted, tt.wantDelete)
+			}
+		})
+	}
+}
+
+func TestRunDependencies(t *testing.T) {
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app":       {},
+		"analytics": {After: []string{"app", "events"}},
+		"events":    {},
+	}}
+
+	tests := []struct {
+		name  string
+		db    string
+		queue []string
+		want  []string
+	}{
+		{"all in the run", "analytics", []string{"app", "analytics", "events"}, []string{"app", "events"}},
+		{"dependency not in the run", "analytics", []string{"analytics", "events"}, []string{"events"}},
+		{"no dependencies", "app", []string{"app", "analytics"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RunDependencies(cfg, tt.db, tt.queue); !slices.Equal(got, tt.want) {
+				t.Errorf("RunDependencies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunBackupsAfter(t *testing.T) {
+	tmpDir := t.TempDir()
+	// Dry-run dumps are left in the temp directory
+	t.Setenv("TMPDIR", t.TempDir())
+	srcPath := filepath.Join(tmpDir, "src.db")
+	if err := os.WriteFile(srcPath, []byte("data"), 0644); err != nil {
+		t.Fatalf("writing source: %v", err)
+	}
+	db := func(after ...string) config.Database {
+		return config.Database{Type: "file", Path: srcPath, Dest: tmpDir, Compression: "none", After: after}
+	}
+	// A chain (app -> analytics -> reports) next to an independent database
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app":       db(),
+		"analytics": db("app"),
+		"reports":   db("analytics"),
+		"other":     db(),
+	}}
+
+	queue := []string{"reports", "analytics", "app", "other"}
+	progress := make(chan BackupProgress, 100)
+	results := RunBackups(context.Background(), cfg, queue, BackupOptions{DryRun: true}, nil, progress)
+	close(progress)
+
+	for _, r := range results {
+		if !r.Success {
+			t.Fatalf("RunBackups() %s failed: %v", r.DBName, r.Error)
+		}
+	}
+
+	// Position of each database's first and last progress update
+	first := make(map[string]int)
+	last := make(map[string]int)
+	i := 0
+	for p := range progress {
+		if _, ok := first[p.DBName]; !ok {
+			first[p.DBName] = i
+		}
+		last[p.DBName] = i
+		i++
+	}
+	for _, pair := range [][2]string{{"app", "analytics"}, {"analytics", "reports"}} {
+		if first[pair[1]] < last[pair[0]] {
+			t.Errorf("%s started before %s finished", pair[1], pair[0])
+		}
+	}
+}
+
+func TestCheckCredentials(t *testing.T) {
+	// Fake clients: the mysql server rejects the credentials, the postgres server is down
+	bin := t.TempDir()
+	clients := map[string]string{
+		"mysql": "echo \"ERROR 1045 (28000): Access denied for user 'app'@'localhost' (using password: YES)\" >&2; exit 1",
+		"psql":  "echo 'psql: error: connection to server at \"db\" failed: Connection refused' >&2; exit 2",
+	}
+	for name, script := range clients {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+	t.Setenv("PATH", bin)
+
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"shop":  {Type: "mysql", Host: "db", Port: 3306, User: "app", Database: "shop"},
+		"stats": {Type: "postgres", Host: "db", Port: 5432, User: "app", Database: "stats"},
+		"local": {Type: "file", Path: "/no/such/file.db"},
+	}}
+
+	failures := CheckCredentials(cfg, []string{"shop", "stats", "local"})
+	if len(failures) != 1 || !strings.Contains(failures["shop"].Error(), "Access denied") {
+		t.Errorf("CheckCredentials() = %v, want only shop's rejected credentials", failures)
+	}
+}
+
+func TestCheckDestinations(t *testing.T) {
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"ok":       {Type: "file", Dest: t.TempDir()},
+		"new":      {Type: "file", Dest: filepath.Join(t.TempDir(), "not", "created", "yet")},
+		"broken":   {Type: "file", Dest: "preflight-no-such-remote:backups"},
+		"broken_2": {Type: "file", Dest: "preflight-no-such-remote:backups"},
+	}}
+
+	failures := CheckDestinations(context.Background(), cfg, []string{"ok", "new", "broken", "broken_2"})
+	if len(failures) != 2 || failures["broken"] == nil || failures["broken_2"] == nil {
+		t.Fatalf("CheckDestinations() = %v, want failures for broken and broken_2 only", failures)
+	}
+	if !strings.Contains(failures["broken"].Error(), "preflight-no-such-remote:backups") {
+		t.Errorf("failure %q does not name the destination", failures["broken"])
+	}
+}
+
+func TestPlanRetentionSharedDest(t *testing.T) {
+	// "db" and "db_extra" back up to the same destination
+	dest := t.TempDir()
+	for _, name := range []string{
+		"db_20240102_000000.sql", "db_20240101_000000.sql",
+		"db_extra_20240103_000000.sql", "db_extra_20240102_000000.sql", "db_extra_20240101_000000.sql",
+	} {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte("data"), 0644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"db":       {Type: "file", Dest: dest, Retention: config.Retention{KeepLast: 1}},
+		"db_extra": {Type: "file", Dest: dest, Retention: config.Retention{KeepLast: 1}},
+	}}
+
+	plan, failures := PlanRetention(context.Background(), cfg, []string{"db", "db_extra"}, 0)
+	if len(failures) > 0 {
+		t.Fatalf("PlanRetention() failures = %v", failures)
+	}
+
+	want := map[string][]string{
+		"db":       {"db_20240101_000000.sql"},
+		"db_extra": {"db_extra_20240101_000000.sql", "db_extra_20240102_000000.sql"},
+	}
+	for name, wantFiles := range want {
+		var got []string
+		for _, f := range plan[name] {
+			got = append(got, f.Name)
+		}
+		sort.Strings(got)
+		if !slices.Equal(got, wantFiles) {
+			t.Errorf("plan[%q] = %v, want %v", name, got, wantFiles)
+		}
+	}
+}
+
+func TestPlanRetentionListError(t *testing.T) {
+	dest := t.TempDir()
+	for _, name := range []string{"ok_20240102_000000.sql", "ok_20240101_000000.sql"} {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte("data"), 0644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"ok":     {Type: "file", Dest: dest, Retention: config.Retention{KeepLast: 1}},
+		"new":    {Type: "file", Dest: filepath.Join(dest, "never-written"), Retention: config.Retention{KeepLast: 1}},
+		"broken": {Type: "file", Dest: "no-such-remote:backups", Retention: config.Retention{KeepLast: 1}},
+	}}
+
+	plan, failures := PlanRetention(context.Background(), cfg, []string{"ok", "new", "broken"}, 0)
+	if len(plan["ok"]) != 1 {
+		t.Errorf("plan[ok] = %v, want one file", plan["ok"])
+	}
+	if _, ok := plan["broken"]; ok {
+		t.Errorf("plan[broken] = %v, want no entry", plan["broken"])
+	}
+	if len(failures) != 1 || failures["broken"] == nil {
+		t.Fatalf("failures = %v, want only broken (a missing dest has no backups)", failures)
+	}
+
+	// The run reports the failure on the retention step instead of treating it as "no files"
+	src := filepath.Join(t.TempDir(), "broken.db")
+	if err := os.WriteFile(src, []byte("data"), 0644); err != nil {
+		t.Fatalf("writing source: %v", err)
+	}
+	old := filepath.Join(dest, "broken_20240101_000000.db")
+	if err := os.WriteFile(old, []byte("old"), 0644); err != nil {
+		t.Fatalf("writing old backup: %v", err)
+	}
+	t.Setenv("TMPDIR", t.TempDir())
+	runCfg := &config.Config{Databases: map[string]config.Database{
+		"broken": {Type: "file", Path: src, Dest: dest, Compression: "none", Retention: config.Retention{KeepLast: 1}},
+	}}
+	progress := make(chan BackupProgress, 100)
+	results := RunBackups(context.Background(), runCfg, []string{"broken"}, BackupOptions{RetentionFailures: failures}, plan, progress)
+	close(progress)
+
+	if len(results) != 1 || results[0].Error == nil {
+		t.Fatalf("RunBackups() = %+v, want a retention error", results)
+	}
+	last := results[0].Steps[len(results[0].Steps)-1]
+	if last.Step != StepRetention || last.Error == nil {
+		t.Errorf("last step = %+v, want a retention error", last)
+	}
+	if _, err := os.Stat(old); err != nil {
+		t.Errorf("old backup was deleted: %v", err)
+	}
+}
+
+func TestPlanRetentionFilePrefix(t *testing.T) {
+	// "prod_primary_mysql" names its backups "prod", next to an older "prod_primary_mysql" backup
+	dest := t.TempDir()
+	for _, name := range []string{
+		"prod_20240103_000000.sql", "prod_20240102_000000.sql", "prod_20240101_000000.sql",
+		"prod_primary_mysql_20231231_000000.sql",
+	} {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte("data"), 0644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"prod_primary_mysql": {Type: "file", Dest: dest, FilePrefix: "prod", Retention: config.Retention{KeepLast: 1}},
+	}}
+
+	plan, failures := PlanRetention(context.Background(), cfg, []string{"prod_primary_mysql"}, 0)
+	if len(failures) > 0 {
+		t.Fatalf("PlanRetention() failures = %v", failures)
+	}
+
+	var got []string
+	for _, f := range plan["prod_primary_mysql"] {
+		got = append(got, f.Name)
+	}
+	sort.Strings(got)
+	want := []string{"prod_20240101_000000.sql", "prod_20240102_000000.sql"}
+	if !slices.Equal(got, want) {
+		t.Errorf("plan = %v, want %v", got, want)
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	write := func(dest, name string) {
+		t.Helper()
+		path := filepath.Join(dest, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+
+	t.Run("keep_last across folders", func(t *testing.T) {
+		dest := t.TempDir()
+		for _, name := range []string{
+			"2024-01-13/app_20240113_000000.db", "2024-01-14/app_20240114_000000.db", "2024-01-15/app_20240115_000000.db",
+			"archive/app_20240101_000000.db", // not a dated folder: left alone
+		} {
+			write(dest, name)
+		}
+		src := filepath.Join(t.TempDir(), "app.db")
+		write(filepath.Dir(src), "app.db")
+		cfg := &config.Config{Databases: map[string]config.Database{
+			"app": {Type: "file", Path: src, Dest: dest, Compression: "none", GroupBy: "day", Retention: config.Retention{KeepLast: 2}},
+		}}
+
+		progress := make(chan BackupProgress, 100)
+		results := RunBackups(context.Background(), cfg, []string{"app"}, BackupOptions{}, nil, progress)
+		close(progress)
+		if len(results) != 1 || !results[0].Success || results[0].Error != nil {
+			t.Fatalf("RunBackups() = %+v, want success", results)
+		}
+
+		uploaded := time.Now().Format("2006-01-02") + "/" + results[0].Filename
+		files, err := storage.List(context.Background(), dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range files {
+			got = append(got, f.Name)
+		}
+		sort.Strings(got)
+		want := []string{"2024-01-15/app_20240115_000000.db", uploaded, "archive/app_20240101_000000.db"}
+		sort.Strings(want)
+		if !slices.Equal(got, want) {
+			t.Errorf("files after run = %v, want %v", got, want)
+		}
+		for _, folder := range []string{"2024-01-13", "2024-01-14"} {
+			if _, err := os.Stat(filepath.Join(dest, folder)); !os.IsNotExist(err) {
+				t.Errorf("emptied folder %s still exists (%v)", folder, err)
+			}
+		}
+	})
+
+	t.Run("keep_days across folders", func(t *testing.T) {
+		dest := t.TempDir()
+		now := time.Now()
+		var names []string
+		for _, days := range []int{1, 5, 40} {
+			at := now.AddDate(0, 0, -days)
+			name := at.Format("2006-01") + "/app_" + at.Format("20060102_150405") + ".db"
+			write(dest, name)
+			names = append(names, name)
+		}
+		cfg := &config.Config{Databases: map[string]config.Database{
+			"app": {Type: "file", Dest: dest, GroupBy: "month", Retention: config.Retention{KeepDays: 3}},
+		}}
+
+		plan, failures := PlanRetention(context.Background(), cfg, []string{"app"}, 0)
+		if len(failures) > 0 {
+			t.Fatalf("PlanRetention() failures = %v", failures)
+		}
+		var got []string
+		for _, f := range plan["app"] {
+			got = append(got, f.Name)
+		}
+		sort.Strings(got)
+		want := names[1:]
+		sort.Strings(want)
+		if !slices.Equal(got, want) {
+			t.Errorf("plan = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestSizeAlert(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	dest := t.TempDir()
+	for day := 1; day <= 3; day++ {
+		name := fmt.Sprintf("app_202401%02d_000000.db", day)
+		if err := os.WriteFile(filepath.Join(dest, name), bytes.Repeat([]byte("x"), 1000), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := filepath.Join(t.TempDir(), "app.db")
+
+	tests := []struct {
+		name    string
+		size    int
+		alert   config.SizeAlert
+		wantHit bool
+	}{
+		{"partial dump", 100, config.SizeAlert{Factor: 2}, true},
+		{"usual size", 1000, config.SizeAlert{Factor: 2}, false},
+		{"not configured", 100, config.SizeAlert{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(src, bytes.Repeat([]byte("x"), tt.size), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cfg := &config.Config{Databases: map[string]config.Database{
+				"app": {Type: "file", Path: src, Dest: dest, Compression: "none", SizeAlert: tt.alert},
+			}}
+			progress := make(chan BackupProgress, 100)
+			results := RunBackups(context.Background(), cfg, []string{"app"}, BackupOptions{}, nil, progress)
+			close(progress)
+			if len(results) != 1 || results[0].Error != nil {
+				t.Fatalf("RunBackups() = %+v, want success", results)
+			}
+			os.Remove(filepath.Join(dest, results[0].Filename))
+			os.Remove(filepath.Join(dest, results[0].Filename+storage.ChecksumExt))
+
+			var hit bool
+			for _, step := range results[0].Steps {
+				for _, warning := range step.Warnings {
+					hit = hit || strings.HasPrefix(warning, "size anomaly:")
+				}
+			}
+			if hit != tt.wantHit {
+				t.Errorf("size anomaly warned = %v, want %v (steps %+v)", hit, tt.wantHit, results[0].Steps)
+			}
+		})
+	}
+}
+
+func TestListBackups(t *testing.T) {
+	dest := t.TempDir()
+	for _, name := range []string{
+		"server_shop_20240101_000000.sql", "server_blog_20240101_000000.sql", "server_20240101_000000.sql",
+		"prod_20240101_000000.sql", "prod_shop_20240101_000000.sql", "app_20240101_000000.db",
+	} {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte("data"), 0644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		entry string
+		db    config.Database
+		want  []string
+	}{
+		{"single database", "app", config.Database{Database: "app"}, []string{"app_20240101_000000.db"}},
+		{"file prefix", "prod_primary", config.Database{Database: "app", FilePrefix: "prod"}, []string{"prod_20240101_000000.sql"}},
+		{"all databases", "server", config.Database{Database: "*"}, []string{"server_blog_20240101_000000.sql", "server_shop_20240101_000000.sql"}},
+		{"all databases with file prefix", "server", config.Database{Database: "*", FilePrefix: "prod"}, []string{"prod_shop_20240101_000000.sql"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.db.Dest = dest
+			files, err := ListBackups(context.Background(), tt.entry, tt.db)
+			if err != nil {
+				t.Fatalf("ListBackups() error = %v", err)
+			}
+			var got []string
+			for _, f := range files {
+				got = append(got, f.Name)
+			}
+			sort.Strings(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ListBackups() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func gzipBytes(t *testing.T, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatalf("gzip write: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDestsOnRemote(t *testing.T) {
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"a": {Dest: "s3:bucket/a"},
+		"b": {Dest: "s3:bucket/a"},
+		"c": {Dest: "s3:bucket/a/nested"},
+		"d": {Dest: "s3:bucket/a-b"},
+		"e": {Dest: "s3:bucket/a-b/x"},
+		"f": {Dest: "s3-eu:bucket/f"},
+		"g": {Dest: "/local/g"},
+		"h": {Dest: "b2:"},
+		"i": {Dest: "b2:bucket"},
+	}}
+
+	tests := []struct {
+		remote string
+		want   []string
+	}{
+		{"s3", []string{"s3:bucket/a", "s3:bucket/a-b"}},
+		{"s3-eu", []string{"s3-eu:bucket/f"}},
+		{"b2", []string{"b2:"}},
+		{"gcs", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.remote, func(t *testing.T) {
+			if got := DestsOnRemote(cfg, tt.remote); !slices.Equal(got, tt.want) {
+				t.Errorf("DestsOnRemote() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDestinations(t *testing.T) {
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"a": {Dest: "s3:bucket/a"},
+		"b": {Dest: "s3:bucket/a"},
+		"c": {Dest: "s3:bucket/a/nested"},
+		"d": {Dest: "/backups/d"},
+	}}
+
+	tests := []struct {
+		name      string
+		databases []string
+		want      []string
+	}{
+		{"all databases", nil, []string{"/backups/d", "s3:bucket/a"}},
+		{"nested only", []string{"c"}, []string{"s3:bucket/a/nested"}},
+		{"shared dest", []string{"a", "b"}, []string{"s3:bucket/a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Destinations(cfg, tt.databases); !slices.Equal(got, tt.want) {
+				t.Errorf("Destinations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsage(t *testing.T) {
+	dest := t.TempDir()
+	files := map[string]int{
+		"app_20240101_000000.sql.gz":        100,
+		"app_20240102_000000.sql.gz":        200,
+		"app_20240102_000000.sql.gz.sha256": 64,
+		"notes.txt":                         10,
+		"sub/db_20240101_000000.db":         50,
+	}
+	for name, size := range files {
+		path := filepath.Join(dest, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("creating %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+
+	usage, err := Usage(context.Background(), []string{dest, filepath.Join(t.TempDir(), "never-written")})
+	if err != nil {
+		t.Fatalf("Usage() error = %v", err)
+	}
+	if want := (BackupUsage{Backups: 3, Bytes: 350}); usage != want {
+		t.Errorf("Usage() = %+v, want %+v", usage, want)
+	}
+}
+
+func TestStaleDatabases(t *testing.T) {
+	dest := t.TempDir()
+	for _, name := range []string{
+		"fresh_20240110_000000.db",
+		"old_20240105_000000.db",
+		"kept_20240105_000000.db",
+		"old_extra_20240110_000000.db",
+	} {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte("backup"), 0644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"fresh":   {Dest: dest},
+		"old":     {Dest: dest},
+		"kept":    {Dest: dest, Retention: config.Retention{KeepDays: 7}},
+		"never":   {Dest: dest},
+		"missing": {Dest: filepath.Join(t.TempDir(), "never-written")},
+	}}
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.Local)
+
+	got := StaleDatabases(context.Background(), cfg, now)
+	want := []StaleDatabase{
+		{Name: "missing", Threshold: config.DefaultStaleAfter},
+		{Name: "never", Threshold: config.DefaultStaleAfter},
+		{Name: "old", LastBackup: time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local), Threshold: config.DefaultStaleAfter},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("StaleDatabases() = %+v, want %+v", got, want)
+	}
+
+	// stale_after applies to every database, over keep_days
+	cfg.StaleAfter = "1h"
+	var names []string
+	for _, s := range StaleDatabases(context.Background(), cfg, now) {
+		names = append(names, s.Name)
+	}
+	if want := []string{"fresh", "kept", "missing", "never", "old"}; !slices.Equal(names, want) {
+		t.Errorf("StaleDatabases() with stale_after = %v, want %v", names, want)
+	}
+}
+
+func TestWriteRestoreRecord(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "audit-dest")
+	cfg := &config.Config{RestoreAuditLog: filepath.Join(dir, "logs", "restores.log"), RestoreAuditDest: dest}
+
+	first := NewRestoreRecord("prod", "s3:bucket/prod", "prod_20240115_143022.sql.gz", 2048, nil)
+	second := NewRestoreRecord("prod", "local", "/tmp/prod.sql.gz", 10, errors.New("access denied"))
+	second.Time = first.Time.Add(time.Second)
+	for _, rec := range []RestoreRecord{first, second} {
+		if warnings := WriteRestoreRecord(context.Background(), cfg, rec); len(warnings) > 0 {
+			t.Fatalf("WriteRestoreRecord() warnings = %v", warnings)
+		}
+	}
+
+	data, err := os.ReadFile(cfg.RestoreAuditLog)
+	if err != nil {
+		t.Fatalf("reading audit log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("audit log has %d lines, want 2:\n%s", len(lines), data)
+	}
+	var got RestoreRecord
+	if err := json.Unmarshal([]byte(lines[1]), &got); err != nil {
+		t.Fatalf("decoding record: %v", err)
+	}
+	if got.Database != "prod" || got.Source != "local" || got.Success || got.Error != "access denied" || got.Size != 10 {
+		t.Errorf("second record = %+v", got)
+	}
+
+	uploaded, err := filepath.Glob(filepath.Join(dest, storage.ReportsDir, "restore_*_prod.json"))
+	if err != nil || len(uploaded) != 2 {
+		t.Errorf("uploaded records = %v, want 2", uploaded)
+	}
+
+	// Writing is best-effort: a failure is a warning, not an error
+	cfg = &config.Config{RestoreAuditLog: filepath.Join(cfg.RestoreAuditLog, "not-a-dir", "restores.log")}
+	if warnings := WriteRestoreRecord(context.Background(), cfg, first); len(warnings) != 1 || !strings.Contains(warnings[0], "restore audit log not written") {
+		t.Errorf("WriteRestoreRecord() warnings = %v, want the log write failure", warnings)
+	}
+
+	// No record without the restore audit
+	if warnings := WriteRestoreRecord(context.Background(), &config.Config{}, first); warnings != nil {
+		t.Errorf("WriteRestoreRecord() with the audit off = %v", warnings)
+	}
+}
+
+func TestRestoreBatch(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	tmpDir := t.TempDir()
+	dest := filepath.Join(tmpDir, "dest")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		t.Fatalf("creating dest: %v", err)
+	}
+	for name, content := range map[string]string{
+		"app_20240101_000000.db":  "app monday",
+		"app_20240102_000000.db":  "app tuesday",
+		"logs_20240101_060000.db": "logs monday",
+		"logs_20240103_000000.db": "logs wednesday",
+	} {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte(content), 0644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app":     {Type: "file", Path: filepath.Join(tmpDir, "app.db"), Dest: dest},
+		"logs":    {Type: "file", Path: filepath.Join(tmpDir, "logs.db"), Dest: dest},
+		"staging": {Type: "file", Path: filepath.Join(tmpDir, "staging.db"), Dest: filepath.Join(tmpDir, "staging-dest")},
+		"shop":    {Type: "mysql", Database: "shop", Dest: dest},
+	}}
+
+	tests := []struct {
+		name      string
+		databases []string
+		sources   map[string]string
+		at        time.Time
+		want      map[string]string // database -> backup picked
+		failed    []string
+	}{
+		{
+			name:      "latest",
+			databases: []string{"app", "logs"},
+			want:      map[string]string{"app": "app_20240102_000000.db", "logs": "logs_20240103_000000.db"},
+		},
+		{
+			name:      "point in time",
+			databases: []string{"app", "logs"},
+			at:        time.Date(2024, 1, 2, 12, 0, 0, 0, time.Local),
+			want:      map[string]string{"app": "app_20240102_000000.db", "logs": "logs_20240101_060000.db"},
+		},
+		{
+			name:      "before any backup",
+			databases: []string{"app", "logs"},
+			at:        time.Date(2023, 12, 31, 0, 0, 0, 0, time.Local),
+			failed:    []string{"app", "logs"},
+		},
+		{
+			name:      "clone",
+			databases: []string{"staging"},
+			sources:   map[string]string{"staging": "app"},
+			want:      map[string]string{"staging": "app_20240102_000000.db"},
+		},
+		{
+			name:      "clone across types",
+			databases: []string{"shop", "app"},
+			sources:   map[string]string{"shop": "app"},
+			want:      map[string]string{"app": "app_20240102_000000.db"},
+			failed:    []string{"shop"},
+		},
+		{
+			name:      "no backups",
+			databases: []string{"staging", "missing"},
+			failed:    []string{"missing", "staging"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests, failures := PlanRestoreBatch(context.Background(), cfg, tt.databases, tt.sources, tt.at)
+			got := make(map[string]string)
+			for _, req := range requests {
+				got[req.Name] = req.File
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("PlanRestoreBatch() picked %v, want %v", got, tt.want)
+			}
+			failed := slices.Sorted(maps.Keys(failures))
+			if !slices.Equal(failed, tt.failed) {
+				t.Errorf("PlanRestoreBatch() failures = %v, want %v", failures, tt.failed)
+			}
+		})
+	}
+
+	// Restoring the plan, one database at a time
+	requests, failures := PlanRestoreBatch(context.Background(), cfg, []string{"app", "logs", "staging"}, map[string]string{"staging": "logs"}, time.Time{})
+	if len(failures) != 0 {
+		t.Fatalf("PlanRestoreBatch() failures = %v", failures)
+	}
+	progress := make(chan RestoreProgress, 100)
+	results := RestoreBatch(context.Background(), cfg, requests, 1, progress)
+	close(progress)
+	for _, r := range results {
+		if !r.Success {
+			t.Errorf("RestoreBatch() %s failed: %v", r.DBName, r.Error)
+		}
+	}
+	for path, want := range map[string]string{"app.db": "app tuesday", "logs.db": "logs wednesday", "staging.db": "logs wednesday"} {
+		if data, err := os.ReadFile(filepath.Join(tmpDir, path)); err != nil || string(data) != want {
+			t.Errorf("%s = %q (error %v), want %q", path, data, err, want)
+		}
+	}
+
+	// Restores not started when the batch is cancelled fail
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	progress = make(chan RestoreProgress, 100)
+	results = RestoreBatch(ctx, cfg, requests, 1, progress)
+	close(progress)
+	for _, r := range results {
+		if r.Success || !errors.Is(r.Error, context.Canceled) {
+			t.Errorf("RestoreBatch() cancelled %s = %+v, want it cancelled", r.DBName, r)
+		}
+	}
+}
+
+func TestStreamRestore(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	tmpDir := t.TempDir()
+	dest := filepath.Join(tmpDir, "dest")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		t.Fatalf("creating dest: %v", err)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("app streamed"))
+	gz.Close()
+	file := "app_20240101_000000.db.gz"
+	if err := os.WriteFile(filepath.Join(dest, file), buf.Bytes(), 0644); err != nil {
+		t.Fatalf("writing backup: %v", err)
+	}
+	db := config.Database{Type: "file", Path: filepath.Join(tmpDir, "app.db"), Dest: dest, StreamRestore: true}
+
+	if !StreamsRestore(db, file) || StreamsRestore(db, "app_20240101_000000.db.zip") {
+		t.Errorf("StreamsRestore() should stream %s and not a zip backup", file)
+	}
+	plain := db
+	plain.StreamRestore = false
+	if StreamsRestore(plain, file) {
+		t.Errorf("StreamsRestore() without stream_restore = true")
+	}
+
+	progress := make(chan storage.TransferProgress, 10)
+	go StreamRestore(context.Background(), db, dest, file, int64(buf.Len()), backup.Settings{}, progress)
+	var last storage.TransferProgress
+	for p := range progress {
+		last = p
+	}
+	if !last.Done || last.Error != nil || last.BytesDone != int64(buf.Len()) {
+		t.Errorf("StreamRestore() last update = %+v, want done with the %d bytes read", last, buf.Len())
+	}
+	if data, err := os.ReadFile(db.Path); err != nil || string(data) != "app streamed" {
+		t.Errorf("app.db = %q (error %v), want the backup's content", data, err)
+	}
+
+	// A batch restore streams it too, with no temp dir
+	cfg := &config.Config{Databases: map[string]config.Database{"app": db}}
+	os.Remove(db.Path)
+	results := RestoreBatch(context.Background(), cfg, []RestoreRequest{{Name: "app", Entry: "app", Source: "app", File: file}}, 1, make(chan RestoreProgress, 100))
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("RestoreBatch() = %+v, want it restored", results)
+	}
+	if data, _ := os.ReadFile(db.Path); string(data) != "app streamed" {
+		t.Errorf("app.db = %q after the batch restore, want the backup's content", data)
+	}
+	if entries, _ := os.ReadDir(os.Getenv("TMPDIR")); len(entries) != 0 {
+		t.Errorf("temp dir holds %d entries, want none", len(entries))
+	}
+}
+
+func TestPickBackups(t *testing.T) {
+	files := []storage.RemoteFile{
+		{Name: "server_shop_20240103_000000.sql.gz"},
+		{Name: "server_shop_20240103_000000.sql.gz" + storage.ChecksumExt},
+		{Name: "server_blog_20240102_000000.sql.gz"},
+		{Name: "server_shop_20240101_000000.sql.gz"},
+		{Name: "server_blog_20240101_000000.sql.gz"},
+	}
+	db := config.Database{Type: "mysql", Database: "*"}
+
+	got := pickBackups("server", "server", db, files, time.Time{})
+	want := []string{"server_blog_20240102_000000.sql.gz", "server_shop_20240103_000000.sql.gz"}
+	if len(got) != 2 || got[0].Name != "server_blog" || got[0].File != want[0] || got[1].Name != "server_shop" || got[1].File != want[1] {
+		t.Errorf("pickBackups() = %+v, want %v", got, want)
+	}
+
+	got = pickBackups("server", "server", db, files, time.Date(2024, 1, 1, 23, 0, 0, 0, time.Local))
+	if len(got) != 2 || got[0].File != "server_blog_20240101_000000.sql.gz" || got[1].File != "server_shop_20240101_000000.sql.gz" {
+		t.Errorf("pickBackups() at 2024-01-01 = %+v, want each database's backup of that day", got)
+	}
+}
+
+func TestParseRestorePoint(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"", time.Time{}, false},
+		{"2024-01-15", time.Date(2024, 1, 15, 23, 59, 59, 0, time.Local), false},
+		{"2024-01-15 12:30", time.Date(2024, 1, 15, 12, 30, 0, 0, time.Local), false},
+		{"2024-01-15T12:30:05", time.Date(2024, 1, 15, 12, 30, 5, 0, time.Local), false},
+		{"2024-01-15T12:30:00Z", time.Date(2024, 1, 15, 12, 30, 0, 0, time.UTC), false},
+		{"yesterday", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseRestorePoint(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRestorePoint(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseRestorePoint(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInferBackupTime(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	local := func(year int, month time.Month, day, hour, minute, second int) time.Time {
+		return time.Date(year, month, day, hour, minute, second, 0, time.Local)
+	}
+
+	tests := []struct {
+		filename string
+		want     time.Time
+		rest     string
+		found    bool
+		wantErr  string
+	}{
+		{filename: "dump_2024_01_15.sql.gz", want: local(2024, 1, 15, 0, 0, 0), rest: ".sql.gz", found: true},
+		{filename: "dump-2024-01-15T03:30:15.sql", want: local(2024, 1, 15, 3, 30, 15), rest: ".sql", found: true},
+		{filename: "app.20240115_0300.db", want: local(2024, 1, 15, 3, 0, 0), rest: ".db", found: true},
+		{filename: "2024-01-15-shop.sql", want: local(2024, 1, 15, 0, 0, 0), rest: "-shop.sql", found: true},
+		{filename: "shop_2024-01-15_v2_99.sql", want: local(2024, 1, 15, 0, 0, 0), rest: "_v2_99.sql", found: true},
+		{filename: "shop_31-01-2024.sql", want: local(2024, 1, 31, 0, 0, 0), rest: ".sql", found: true},
+		{filename: "shop_01-31-2024.sql", want: local(2024, 1, 31, 0, 0, 0), rest: ".sql", found: true},
+		{filename: "shop_05-05-2024.sql", want: local(2024, 5, 5, 0, 0, 0), rest: ".sql", found: true},
+		{filename: "shop.sql.gz"},
+		{filename: "shop_12345678901.sql"},
+		{filename: "shop_20241345.sql"},
+		{filename: "shop_03-04-2024.sql", found: true, wantErr: "could be 2024-04-03 or 2024-03-04"},
+		{filename: "shop_2024-01-15_to_2024-01-16.sql", found: true, wantErr: "2 dates"},
+		{filename: "shop_2024-12-01.sql", found: true, wantErr: "in the future"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			got, rest, found, err := InferBackupTime(tt.filename, now)
+			if found != tt.found {
+				t.Errorf("InferBackupTime() found = %v, want %v", found, tt.found)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("InferBackupTime() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("InferBackupTime() error = %v", err)
+			}
+			if !got.Equal(tt.want) || rest != tt.rest {
+				t.Errorf("InferBackupTime() = %v, %q, want %v, %q", got, rest, tt.want, tt.rest)
+			}
+		})
+	}
+}
+
+func TestPlanImport(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	mtime := time.Date(2024, 2, 10, 8, 15, 30, 0, time.Local)
+	files := []storage.RemoteFile{
+		{Name: "dump_2024_01_15.sql.gz", ModTime: mtime},
+		{Name: "old/dump-2024-01-15.sql.gz", ModTime: mtime},
+		{Name: "dump_2024_01_16.sql.gz", ModTime: mtime},
+		{Name: "latest.sql.gz", ModTime: mtime},
+		{Name: "dump_03-04-2024.sql", ModTime: mtime},
+		{Name: "shop_20240101_000000.sql.gz", ModTime: mtime},
+		{Name: "nightly", ModTime: mtime},
+		{Name: "notes_2024-01-20.txt", ModTime: mtime},
+		{Name: "dump_2024_01_17.sql.gz.sha256", ModTime: mtime},
+		{Name: "dump_2024_01_18.sql.gz.part", ModTime: mtime},
+		{Name: "dump_2024_01_19.sql.xz.gpg", ModTime: mtime},
+	}
+	db := config.Database{Type: "postgres", Dest: "/backups/shop", GroupBy: "month"}
+
+	plan := PlanImport("shop", db, files, []string{"2024-01/shop_20240116_000000.sql.gz"}, false, now)
+	got := make(map[string]string)
+	for _, f := range plan {
+		if f.Skip != "" {
+			got[f.Source] = "skip: " + f.Skip
+			continue
+		}
+		got[f.Source] = f.Target + " in " + f.Dest
+	}
+	want := map[string]string{
+		"dump_03-04-2024.sql":         "skip: date 03-04-2024 could be 2024-04-03 or 2024-03-04",
+		"dump_2024_01_15.sql.gz":      "shop_20240115_000000.sql.gz in /backups/shop/2024-01",
+		"dump_2024_01_16.sql.gz":      "skip: a backup of that name already exists",
+		"latest.sql.gz":               "shop_20240210_081530.sql.gz in /backups/shop/2024-02",
+		"dump_2024_01_19.sql.xz.gpg":  "shop_20240119_000000.sql.xz.gpg in /backups/shop/2024-01",
+		"nightly":                     "skip: no extension to tell its format from (want .sql)",
+		"notes_2024-01-20.txt":        "skip: extension .txt is not a .sql backup",
+		"old/dump-2024-01-15.sql.gz":  "skip: same backup name as dump_2024_01_15.sql.gz",
+		"shop_20240101_000000.sql.gz": "skip: already named as a blobber backup",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("PlanImport() = %v, want %v", got, want)
+	}
+
+	// A file database's backups keep its path's extension
+	fileDB := config.Database{Type: "file", Path: "/srv/app.db", Dest: "/backups/app"}
+	fileFiles := []storage.RemoteFile{{Name: "app-2024-01-15.db.gz"}, {Name: "app-2024-01-16.sql"}}
+	plan = PlanImport("app", fileDB, fileFiles, nil, false, now)
+	if len(plan) != 2 || plan[0].Target != "app_20240115_000000.db.gz" || plan[1].Skip != "extension .sql is not a .db backup" {
+		t.Errorf("PlanImport() for a file database = %+v, want the .db backup imported and the .sql one skipped", plan)
+	}
+
+	// --mtime dates every file by its modification time, even when its name holds a date
+	for _, f := range PlanImport("shop", db, files[4:5], nil, true, now) {
+		if f.Skip != "" || !f.FromMTime || f.Target != "shop_20240210_081530.sql" {
+			t.Errorf("PlanImport() with useMTime = %+v, want it dated by modification time", f)
+		}
+	}
+}
+
+func TestRunImport(t *testing.T) {
+	src := t.TempDir()
+	dest := filepath.Join(t.TempDir(), "dest")
+	for _, name := range []string{"dump_2024_01_15.sql.gz", "dump_2024_01_16.sql.gz"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+	db := config.Database{Type: "postgres", Dest: dest}
+
+	files, err := storage.List(context.Background(), src)
+	if err != nil {
+		t.Fatalf("listing source: %v", err)
+	}
+	plan := PlanImport("shop", db, files, nil, false, time.Now())
+	if _, warnings := RunImport(context.Background(), db, src, plan[:1], false, nil); len(warnings) > 0 {
+		t.Errorf("RunImport() warnings = %v", warnings)
+	}
+	results, _ := RunImport(context.Background(), db, src, plan[1:], true, nil)
+	if len(results) != 1 || results[0].Error != nil {
+		t.Fatalf("RunImport() = %+v", results)
+	}
+
+	backups, err := ListBackups(context.Background(), "shop", db)
+	if err != nil {
+		t.Fatalf("ListBackups() error = %v", err)
+	}
+	var names []string
+	for _, f := range backups {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	if want := []string{"shop_20240115_000000.sql.gz", "shop_20240116_000000.sql.gz"}; !slices.Equal(names, want) {
+		t.Errorf("backups after import = %v, want %v", names, want)
+	}
+	// Copied files stay at the source, moved ones don't
+	if _, err := os.Stat(filepath.Join(src, "dump_2024_01_15.sql.gz")); err != nil {
+		t.Errorf("copied file gone from the source: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(src, "dump_2024_01_16.sql.gz")); !os.IsNotExist(err) {
+		t.Errorf("moved file still at the source: %v", err)
+	}
+}
+
+func TestRunRekey(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not installed")
+	}
+	// The agent socket lives in the home, so keep its path short
+	home, err := os.MkdirTemp("", "gpg")
+	if err != nil {
+		t.Fatalf("creating gpg home: %v", err)
+	}
+	t.Setenv("GNUPGHOME", home)
+	t.Cleanup(func() {
+		exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+		os.RemoveAll(home)
+	})
+	for _, uid := range []string{"Old <old@example.com>", "New <new@example.com>"} {
+		cmd := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key", uid, "future-default", "default", "never")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("generating test key: %v: %s", err, out)
+		}
+	}
+	t.Setenv("TMPDIR", t.TempDir())
+
+	// An encrypted backup with its checksum, and a plain one a rekey leaves alone
+	dest := t.TempDir()
+	encrypted := "app_20240102_000000.db.gpg"
+	cmd := exec.Command("gpg", "--batch", "--trust-model", "always", "--output", filepath.Join(dest, encrypted), "--encrypt", "--recipient", "old@example.com")
+	cmd.Stdin = strings.NewReader("data")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("encrypting backup: %v: %s", err, out)
+	}
+	oldSum, _ := backup.FileSHA256(filepath.Join(dest, encrypted))
+	os.WriteFile(filepath.Join(dest, encrypted+storage.ChecksumExt), []byte(oldSum+"  "+encrypted+"\n"), 0644)
+	os.WriteFile(filepath.Join(dest, "app_20240101_000000.db"), []byte("plain"), 0644)
+
+	db := config.Database{Type: "file", Dest: dest}
+	targets, plain, err := PlanRekey(context.Background(), "app", db)
+	if err != nil {
+		t.Fatalf("PlanRekey() error = %v", err)
+	}
+	if plain != 1 || len(targets) != 1 || len(targets[0].Files) != 1 || targets[0].Files[0].Name != encrypted {
+		t.Fatalf("PlanRekey() = %+v, %d plain; want the encrypted backup only", targets, plain)
+	}
+
+	// A failed re-encryption leaves the original as it was
+	results, _ := RunRekey(context.Background(), db, targets, "", []string{"nobody@example.com"}, backup.Settings{}, nil)
+	if len(results) != 1 || results[0].Error == nil {
+		t.Fatalf("RunRekey() to an unknown recipient = %+v, want a failure", results)
+	}
+	if sum, _ := backup.FileSHA256(filepath.Join(dest, encrypted)); sum != oldSum {
+		t.Error("failed rekey changed the original backup")
+	}
+
+	results, _ = RunRekey(context.Background(), db, targets, "old@example.com", []string{"new@example.com"}, backup.Settings{}, nil)
+	if len(results) != 1 || results[0].Error != nil {
+		t.Fatalf("RunRekey() = %+v, want success", results)
+	}
+	newSum, _ := backup.FileSHA256(filepath.Join(dest, encrypted))
+	if newSum == oldSum {
+		t.Fatal("backup not re-encrypted")
+	}
+	if recorded, err := storage.ReadChecksum(context.Background(), dest, encrypted); err != nil || recorded != newSum {
+		t.Errorf("recorded checksum = %q, %v; want the re-encrypted backup's %s", recorded, err, newSum)
+	}
+	out, err := exec.Command("gpg", "--batch", "--list-packets", filepath.Join(dest, encrypted)).CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "new@example.com") || strings.Contains(string(out), "old@example.com") {
+		t.Errorf("re-encrypted backup is not encrypted to the new key only: %v\n%s", err, out)
+	}
+	entries, _ := os.ReadDir(dest)
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), storage.PartExt) {
+			t.Errorf("rekey left %s behind", e.Name())
+		}
+	}
+
+	// The local tier's backups are rekeyed too, and its index updated with them
+	remote := t.TempDir()
+	tiered := config.Database{Type: "file", Dest: remote, BackupIndex: true, DestTiers: &config.TieredDest{Local: dest, Remote: remote}}
+	targets, _, err = PlanRekey(context.Background(), "app", tiered)
+	if err != nil || len(targets) != 1 || targets[0].Dest != dest {
+		t.Fatalf("PlanRekey() on a tiered dest = %+v, %v; want the local tier's backup", targets, err)
+	}
+	results, warnings := RunRekey(context.Background(), tiered, targets, "new@example.com", []string{"old@example.com"}, backup.Settings{}, nil)
+	if len(results) != 1 || results[0].Error != nil || len(warnings) != 0 {
+		t.Fatalf("RunRekey() on the local tier = %+v, %v; want success", results, warnings)
+	}
+	if _, err := os.Stat(filepath.Join(dest, storage.IndexFile)); err != nil {
+		t.Errorf("local tier index not written: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(remote, storage.IndexFile)); err == nil {
+		t.Error("rekey of the local tier wrote an index on the remote tier")
+	}
+
+	if _, _, err := PlanRekey(context.Background(), "app", config.Database{Type: "file", Dest: dest, Immutable: true}); err == nil {
+		t.Error("PlanRekey() on an immutable destination succeeded, want an error")
+	}
+}
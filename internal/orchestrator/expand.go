@@ -0,0 +1,189 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/Yoone/blobber/internal/backup"
+	"github.com/Yoone/blobber/internal/config"
+	"github.com/Yoone/blobber/internal/retention"
+	"github.com/Yoone/blobber/internal/storage"
+)
+
+// ExpandedName is the entry name used for one database discovered by a database "*"
+// entry; it prefixes that database's backup files
+func ExpandedName(name, database string) string {
+	return name + "_" + database
+}
+
+// ExpandDatabases replaces every database "*" entry among databases with one entry per
+// database found on its server. It returns a copy of cfg holding the expanded entries,
+// the expanded list of names and warnings for databases that had to be skipped.
+// cfg itself is not modified.
+func ExpandDatabases(ctx context.Context, cfg *config.Config, databases []string) (*config.Config, []string, []string, error) {
+	if len(databases) == 0 {
+		for name := range cfg.Databases {
+			databases = append(databases, name)
+		}
+	}
+
+	expanded := *cfg
+	expanded.Databases = make(map[string]config.Database, len(cfg.Databases))
+	for name, db := range cfg.Databases {
+		expanded.Databases[name] = db
+	}
+
+	var names, warnings []string
+	expandedFrom := make(map[string][]string)
+	settings := backup.SettingsFrom(cfg)
+	for _, name := range databases {
+		db := cfg.Databases[name]
+		if !db.AllDatabases() {
+			names = append(names, name)
+			continue
+		}
+
+		found, err := backup.ListDatabases(ctx, db, settings)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("database %q: %w", name, err)
+		}
+		entries, skipped := expandEntry(name, db, found, expanded.Databases)
+		warnings = append(warnings, skipped...)
+		for _, entry := range entries {
+			expanded.Databases[entry] = expandedDatabase(name, db, strings.TrimPrefix(entry, name+"_"))
+			names = append(names, entry)
+		}
+		expandedFrom[name] = entries
+	}
+
+	// Databases backed up after a database "*" entry wait for all of its databases
+	for name, db := range expanded.Databases {
+		if after := expandAfter(db.After, expandedFrom); !slices.Equal(after, db.After) {
+			db.After = after
+			expanded.Databases[name] = db
+		}
+	}
+
+	return &expanded, names, warnings, nil
+}
+
+// ExpandedConfig returns a copy of cfg with the entry for database on the server of the
+// database "*" entry name added, and that entry's name, so that one database of the
+// entry can be backed up without listing the others. cfg itself is not modified.
+func ExpandedConfig(cfg *config.Config, name, database string) (*config.Config, string, error) {
+	entry := ExpandedName(name, database)
+	if !config.ValidName(entry) {
+		return nil, "", fmt.Errorf("%s: database %q: name must contain only letters, digits, dashes, and underscores", name, database)
+	}
+	if _, ok := cfg.Databases[entry]; ok {
+		return nil, "", fmt.Errorf("%s: database %q: entry %q already exists", name, database, entry)
+	}
+
+	expanded := *cfg
+	expanded.Databases = maps.Clone(cfg.Databases)
+	expanded.Databases[entry] = expandedDatabase(name, cfg.Databases[name], database)
+	return &expanded, entry, nil
+}
+
+// expandedDatabase returns the entry of database on the server of the database "*"
+// entry name
+func expandedDatabase(name string, db config.Database, database string) config.Database {
+	db.Database = database
+	db.ExpandedFrom = name
+	db.ExcludeDatabases = nil
+	if db.FilePrefix != "" {
+		db.FilePrefix = ExpandedName(db.FilePrefix, database)
+	}
+	return db
+}
+
+// expandAfter replaces the database "*" entries in after with the entries they expanded to
+func expandAfter(after []string, expandedFrom map[string][]string) []string {
+	var result []string
+	for _, dep := range after {
+		if entries, ok := expandedFrom[dep]; ok {
+			result = append(result, entries...)
+		} else {
+			result = append(result, dep)
+		}
+	}
+	return result
+}
+
+// expandEntry returns the entry names for the databases found by the database "*" entry
+// name. Databases whose entry name is not filename-safe or collides with an existing
+// entry are skipped with a warning, as is a server with no databases to back up.
+func expandEntry(name string, db config.Database, found []string, existing map[string]config.Database) ([]string, []string) {
+	if len(found) == 0 {
+		return nil, []string{fmt.Sprintf("%s: no databases found on %s", name, db.DumpSource().Host)}
+	}
+
+	var entries, warnings []string
+	for _, database := range found {
+		entry := ExpandedName(name, database)
+		if !config.ValidName(entry) {
+			warnings = append(warnings, fmt.Sprintf("%s: skipping database %q: name must contain only letters, digits, dashes, and underscores", name, database))
+			continue
+		}
+		if _, ok := existing[entry]; ok {
+			warnings = append(warnings, fmt.Sprintf("%s: skipping database %q: entry %q already exists", name, database, entry))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, warnings
+}
+
+// RestoreTarget returns the entry to restore fileName into. For a database "*" entry the
+// database is taken from the backup's filename; other entries are returned unchanged.
+func RestoreTarget(name string, db config.Database, fileName string) (config.Database, error) {
+	if !db.AllDatabases() {
+		return db, nil
+	}
+	backupName, ok := retention.BackupName(fileName)
+	database := strings.TrimPrefix(backupName, db.BackupPrefix(name)+"_")
+	if !ok || database == backupName || database == "" {
+		return db, fmt.Errorf("cannot tell which database %s belongs to", fileName)
+	}
+	db.Database = database
+	db.ExcludeDatabases = nil
+	return db, nil
+}
+
+// ListBackups lists the backups of the entry name at its destination, newest first.
+// For a database "*" entry these are the backups of every database it expanded to.
+func ListBackups(ctx context.Context, name string, db config.Database) ([]storage.RemoteFile, error) {
+	return listBackups(ctx, name, db, false)
+}
+
+// ListBackupsIndexed is ListBackups reading the destination's backup index with
+// backup_index. It is meant for picking a backup to restore: anything deciding what to
+// keep or delete needs ListBackups' live listing.
+func ListBackupsIndexed(ctx context.Context, name string, db config.Database) ([]storage.RemoteFile, error) {
+	return listBackups(ctx, name, db, db.BackupIndex)
+}
+
+func listBackups(ctx context.Context, name string, db config.Database, indexed bool) ([]storage.RemoteFile, error) {
+	list, listForDatabase := storage.List, storage.ListForDatabase
+	if indexed {
+		list, listForDatabase = storage.ListIndexed, storage.ListForDatabaseIndexed
+	}
+	if !db.AllDatabases() {
+		return listForDatabase(ctx, db.Dest, db.BackupPrefix(name))
+	}
+
+	files, err := list(ctx, db.Dest)
+	if err != nil {
+		return nil, err
+	}
+	var filtered []storage.RemoteFile
+	for _, f := range files {
+		if _, err := RestoreTarget(name, db, f.Name); err == nil {
+			filtered = append(filtered, f)
+		}
+	}
+	return filtered, nil
+}
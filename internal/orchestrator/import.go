@@ -0,0 +1,281 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Yoone/blobber/internal/backup"
+	"github.com/Yoone/blobber/internal/config"
+	"github.com/Yoone/blobber/internal/retention"
+	"github.com/Yoone/blobber/internal/storage"
+)
+
+// ImportFile is a file found outside blobber's naming convention and the backup it is
+// imported as
+type ImportFile struct {
+	Source    string    // file, relative to the import source
+	Size      int64     // of the file
+	Target    string    // backup filename in blobber's convention
+	Dest      string    // where Target goes: dest, or its dated folder with group_by
+	Time      time.Time // when the backup was taken, as inferred
+	FromMTime bool      // Time is the file's modification time: its name holds no date
+	Skip      string    // why the file is not imported, empty when it is
+	BadDate   bool      // skipped for the date in its name, which useMTime would ignore
+}
+
+// importYearFirst matches a date at the start of a string, year first: 2024-01-15,
+// 2024_01_15, 2024.01.15 or 20240115
+var importYearFirst = regexp.MustCompile(`^((?:19|20)\d{2})[-_.]?(\d{2})[-_.]?(\d{2})`)
+
+// importTimeOfDay matches a time of day following a year-first date: _1430, T14:30:22...
+var importTimeOfDay = regexp.MustCompile(`^[T_ -]?(\d{2})[-_:.]?(\d{2})(?:[-_:.]?(\d{2}))?`)
+
+// importYearLast matches a date at the start of a string with the year last (15-01-2024
+// or 01-15-2024), whose day and month order is read from which one is above 12
+var importYearLast = regexp.MustCompile(`^(\d{2})[-_.](\d{2})[-_.]((?:19|20)\d{2})`)
+
+// importMinTime is the earliest timestamp an import accepts: anything before it is more
+// likely a misread number than the date of a backup
+var importMinTime = time.Date(1990, 1, 1, 0, 0, 0, 0, time.Local)
+
+// importDate is a date found in the name of an imported file, or why it can't be used
+type importDate struct {
+	t    time.Time
+	rest string // what follows the date in the name
+	err  error
+}
+
+// InferBackupTime reads when a backup was taken from a filename that doesn't follow
+// blobber's convention, e.g. dump_2024_01_15.sql.gz or app-15.01.2024.sql. It returns
+// the time and the rest of the name after the date, or found false when the name holds
+// no date. Names holding several dates, a day and month that could be either way round,
+// or a date in the future are errors: the backup would be misplaced in time.
+func InferBackupTime(filename string, now time.Time) (t time.Time, rest string, found bool, err error) {
+	var dates []importDate
+	for i := 0; i < len(filename); i++ {
+		if i > 0 && isDigit(filename[i-1]) {
+			continue
+		}
+		d, n, ok := parseImportDate(filename[i:])
+		if !ok || (i+n < len(filename) && isDigit(filename[i+n])) {
+			continue
+		}
+		d.rest = filename[i+n:]
+		dates = append(dates, d)
+		i += n - 1
+	}
+
+	switch {
+	case len(dates) == 0:
+		return time.Time{}, "", false, nil
+	case len(dates) > 1:
+		return time.Time{}, "", true, fmt.Errorf("name holds %d dates", len(dates))
+	}
+	d := dates[0]
+	switch {
+	case d.err != nil:
+		return time.Time{}, "", true, d.err
+	case d.t.After(now):
+		return time.Time{}, "", true, fmt.Errorf("date %s is in the future", d.t.Format("2006-01-02 15:04:05"))
+	case d.t.Before(importMinTime):
+		return time.Time{}, "", true, fmt.Errorf("date %s is implausibly old", d.t.Format("2006-01-02"))
+	}
+	return d.t, d.rest, true, nil
+}
+
+// parseImportDate parses the date at the start of s and returns how many bytes of s it
+// spans. ok is false when s doesn't start with a date.
+func parseImportDate(s string) (d importDate, n int, ok bool) {
+	if m := importYearFirst.FindStringSubmatch(s); m != nil {
+		t, valid := validDate(atoi(m[1]), atoi(m[2]), atoi(m[3]), 0, 0, 0)
+		if !valid {
+			return d, 0, false
+		}
+		d.t, n = t, len(m[0])
+		// A time of day may follow; digits that don't make one (e.g. a version) are
+		// left out of the date
+		if tm := importTimeOfDay.FindStringSubmatch(s[n:]); tm != nil {
+			withTime, valid := validDate(t.Year(), int(t.Month()), t.Day(), atoi(tm[1]), atoi(tm[2]), atoi(tm[3]))
+			if valid && (n+len(tm[0]) == len(s) || !isDigit(s[n+len(tm[0])])) {
+				d.t, n = withTime, n+len(tm[0])
+			}
+		}
+		return d, n, true
+	}
+
+	if m := importYearLast.FindStringSubmatch(s); m != nil {
+		first, second, year := atoi(m[1]), atoi(m[2]), atoi(m[3])
+		dayFirst, dayFirstOK := validDate(year, second, first, 0, 0, 0)
+		monthFirst, monthFirstOK := validDate(year, first, second, 0, 0, 0)
+		switch {
+		case dayFirstOK && monthFirstOK && first != second:
+			d.err = fmt.Errorf("date %s could be %s or %s", m[0], dayFirst.Format("2006-01-02"), monthFirst.Format("2006-01-02"))
+		case dayFirstOK:
+			d.t = dayFirst
+		case monthFirstOK:
+			d.t = monthFirst
+		default:
+			return d, 0, false
+		}
+		return d, len(m[0]), true
+	}
+	return d, 0, false
+}
+
+// validDate returns the local time of the given fields, and whether they make a real
+// date and time of day (no February 30th or 25 o'clock)
+func validDate(year, month, day, hour, minute, second int) (time.Time, bool) {
+	t := time.Date(year, time.Month(month), day, hour, minute, second, 0, time.Local)
+	valid := t.Year() == year && int(t.Month()) == month && t.Day() == day &&
+		t.Hour() == hour && t.Minute() == minute && t.Second() == second
+	return t, valid
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// PlanImport decides the backup each of files (found at the import source) becomes for
+// the entry name: a filename in blobber's convention holding the time inferred from the
+// file's name, or its modification time when the name holds no date (or always with
+// useMTime). Files already following the convention, whose time can't be trusted, whose
+// extension isn't a backup of the entry, or which would take an existing backup's name
+// (in existing) are skipped with the reason. Partial uploads and checksum sidecars are
+// left out.
+func PlanImport(name string, db config.Database, files []storage.RemoteFile, existing []string, useMTime bool, now time.Time) []ImportFile {
+	exists := make(map[string]bool)
+	for _, f := range existing {
+		exists[path.Base(f)] = true
+	}
+	taken := make(map[string]string) // backup names planned, to the file they import
+
+	sorted := append([]storage.RemoteFile(nil), files...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	var plan []ImportFile
+	for _, f := range sorted {
+		base := path.Base(f.Name)
+		item := ImportFile{Source: f.Name, Size: f.Size}
+		if config.IsSidecar(base) {
+			continue
+		}
+		if _, ok := retention.BackupName(base); ok {
+			item.Skip = "already named as a blobber backup"
+			plan = append(plan, item)
+			continue
+		}
+
+		var t time.Time
+		var rest string
+		var found bool
+		if !useMTime {
+			var err error
+			if t, rest, found, err = InferBackupTime(base, now); err != nil {
+				item.Skip, item.BadDate = err.Error(), true
+				plan = append(plan, item)
+				continue
+			}
+		}
+		if !found {
+			t, rest, item.FromMTime = f.ModTime.In(time.Local).Truncate(time.Second), base, true
+		}
+		item.Time = t
+
+		ext := ""
+		if i := strings.Index(rest, "."); i >= 0 {
+			ext = rest[i:]
+		}
+		if skip := importExtProblem(db, ext); skip != "" {
+			item.Skip = skip
+			plan = append(plan, item)
+			continue
+		}
+		item.Target = fmt.Sprintf("%s_%s%s", db.BackupPrefix(name), t.Format("20060102_150405"), ext)
+		item.Dest = UploadDest(db, item.Target)
+
+		if exists[item.Target] {
+			item.Skip = "a backup of that name already exists"
+			plan = append(plan, item)
+			continue
+		}
+		if other, ok := taken[item.Target]; ok {
+			item.Skip = fmt.Sprintf("same backup name as %s", other)
+			plan = append(plan, item)
+			continue
+		}
+		taken[item.Target] = f.Name
+		plan = append(plan, item)
+	}
+	return plan
+}
+
+// importExtProblem tells why ext, the extension a file is imported with, doesn't name a
+// backup the entry can restore: its dump extension (.sql, or the path's for a file
+// database), optionally compressed and encrypted. It returns "" when it does.
+func importExtProblem(db config.Database, ext string) string {
+	want := ".sql"
+	if db.Type == "file" {
+		want = db.FileExt()
+	}
+	if ext == "" {
+		return fmt.Sprintf("no extension to tell its format from (want %s)", want)
+	}
+	inner := strings.TrimSuffix(ext, backup.EncryptedExt)
+	if backup.CompressionFromFilename(inner) != "" {
+		inner = strings.TrimSuffix(inner, path.Ext(inner))
+	}
+	if !strings.EqualFold(inner, want) {
+		return fmt.Sprintf("extension %s is not a %s backup", ext, want)
+	}
+	return ""
+}
+
+// ImportResult is the outcome of importing one file
+type ImportResult struct {
+	File  ImportFile
+	Error error
+}
+
+// RunImport copies (or with move, moves) the planned files from src to their backups at
+// the database's destination, then adds them to its backup index. Skipped files are left
+// out. The files imported are then subject to retention like any other backup.
+func RunImport(ctx context.Context, db config.Database, src string, plan []ImportFile, move bool, progress func(ImportResult)) ([]ImportResult, []string) {
+	var results []ImportResult
+	var imported storage.IndexChange
+	for _, f := range plan {
+		if f.Skip != "" {
+			continue
+		}
+		var err error
+		if err = ctx.Err(); err == nil {
+			err = storage.Transfer(ctx, src, f.Source, f.Dest, f.Target, move)
+		}
+		result := ImportResult{File: f, Error: err}
+		results = append(results, result)
+		if err == nil {
+			imported.Written = append(imported.Written, DestName(db, f.Target))
+		}
+		if progress != nil {
+			progress(result)
+		}
+	}
+
+	var warnings []string
+	if len(imported.Written) > 0 {
+		if warning := UpdateIndex(ctx, db, imported); warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	return results, warnings
+}
@@ -0,0 +1,174 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Yoone/blobber/internal/backup"
+	"github.com/Yoone/blobber/internal/config"
+	"github.com/Yoone/blobber/internal/storage"
+)
+
+// RekeyResult is the outcome of re-encrypting one backup
+type RekeyResult struct {
+	Dest  string // destination holding the backup
+	File  string // backup, relative to Dest
+	Error error
+}
+
+// RekeyTarget is a destination of a database and the encrypted backups on it
+type RekeyTarget struct {
+	Dest  string
+	Files []storage.RemoteFile
+}
+
+// PlanRekey lists the encrypted backups of the entry name that a rekey re-encrypts: on
+// its dest, and on the local tier of a tiered dest, which holds its own copies. It also
+// returns how many backups were left out for not being encrypted. Backups on an immutable
+// destination can't be rekeyed.
+func PlanRekey(ctx context.Context, name string, db config.Database) ([]RekeyTarget, int, error) {
+	if db.Immutable {
+		return nil, 0, fmt.Errorf("backups on an immutable destination can't be replaced")
+	}
+	dbs := []config.Database{db}
+	if tier := db.LocalTier(); tier != "" {
+		localDB := db
+		localDB.Dest = tier
+		dbs = append(dbs, localDB)
+	}
+
+	var targets []RekeyTarget
+	var plain int
+	for _, d := range dbs {
+		files, err := ListBackups(ctx, name, d)
+		if err != nil {
+			return nil, 0, fmt.Errorf("listing %s: %w", d.Dest, err)
+		}
+		target := RekeyTarget{Dest: d.Dest}
+		for _, f := range files {
+			if backup.Encrypted(f.Name) {
+				target.Files = append(target.Files, f)
+			} else {
+				plain++
+			}
+		}
+		if len(target.Files) > 0 {
+			targets = append(targets, target)
+		}
+	}
+	return targets, plain, nil
+}
+
+// RunRekey re-encrypts the planned backups to recipients, decrypting them with the
+// secret key from (or whichever key of the keyring matches when empty). Each backup is
+// downloaded, re-encrypted and uploaded next to the original, and only replaces it once
+// the new copy's checksum is confirmed on the destination; a failure leaves the original
+// untouched. The database itself is never read. The backup index of each destination,
+// the local tier included, is updated with the backups replaced on it.
+func RunRekey(ctx context.Context, db config.Database, targets []RekeyTarget, from string, recipients []string, settings backup.Settings, progress func(RekeyResult)) ([]RekeyResult, []string) {
+	var results []RekeyResult
+	var warnings []string
+	for _, target := range targets {
+		var replaced storage.IndexChange
+		for _, f := range target.Files {
+			err := ctx.Err()
+			if err == nil {
+				err = rekeyBackup(ctx, target.Dest, f.Name, from, recipients, settings)
+			}
+			result := RekeyResult{Dest: target.Dest, File: f.Name, Error: err}
+			results = append(results, result)
+			if err == nil {
+				replaced.Written = append(replaced.Written, f.Name)
+			}
+			if progress != nil {
+				progress(result)
+			}
+		}
+
+		if len(replaced.Written) > 0 {
+			targetDB := db
+			targetDB.Dest = target.Dest
+			if warning := UpdateIndex(ctx, targetDB, replaced); warning != "" {
+				warnings = append(warnings, warning)
+			}
+		}
+	}
+	return results, warnings
+}
+
+// rekeyBackup re-encrypts the backup fileName at dest, uploading the new copy under a
+// .part name and moving it over the original once its checksum matches. The checksum
+// sidecar is written before the move, so the backup is never left with a stale one; it
+// is put back as it was when the move fails.
+func rekeyBackup(ctx context.Context, dest, fileName, from string, recipients []string, settings backup.Settings) error {
+	tmpDir, err := os.MkdirTemp("", "blobber-rekey-")
+	if err != nil {
+		return fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := storage.Download(ctx, dest, fileName, tmpDir, settings.Download); err != nil {
+		return fmt.Errorf("downloading backup: %w", err)
+	}
+	oldPath := filepath.Join(tmpDir, fileName)
+	expected, err := storage.ReadChecksum(ctx, dest, fileName)
+	if err != nil {
+		return err
+	}
+	if expected != "" {
+		if err := backup.Verify(oldPath, expected, backup.VerifyFast, settings); err != nil {
+			return fmt.Errorf("verifying download: %w", err)
+		}
+	}
+
+	// The new copy goes next to the original, in its dated folder with group_by
+	dir, base := path.Split(fileName)
+	folder := storage.JoinDest(dest, strings.TrimSuffix(dir, "/"))
+	partName := base + storage.PartExt
+	newPath := filepath.Join(tmpDir, partName)
+	if err := backup.Reencrypt(ctx, oldPath, newPath, from, recipients); err != nil {
+		return err
+	}
+	sum, err := backup.FileSHA256(newPath)
+	if err != nil {
+		return err
+	}
+
+	if err := storage.Upload(ctx, newPath, folder, false); err != nil {
+		return err
+	}
+	uploaded, err := remoteSHA256(ctx, folder, partName, settings.Download)
+	if err == nil && uploaded != sum {
+		err = fmt.Errorf("checksum mismatch on the destination: expected %s, got %s", sum, uploaded)
+	}
+	if err != nil {
+		storage.Delete(ctx, folder, partName)
+		return fmt.Errorf("verifying re-encrypted backup: %w", err)
+	}
+
+	if err := storage.UploadChecksum(ctx, folder, base, sum); err != nil {
+		storage.Delete(ctx, folder, partName)
+		return fmt.Errorf("writing checksum: %w", err)
+	}
+	if err := storage.Transfer(ctx, folder, partName, folder, base, true); err != nil {
+		storage.Delete(ctx, folder, partName)
+		if restoreErr := restoreChecksum(ctx, folder, base, expected); restoreErr != nil {
+			return fmt.Errorf("replacing backup: %w (original kept, but its checksum could not be put back: %v)", err, restoreErr)
+		}
+		return fmt.Errorf("replacing backup: %w", err)
+	}
+	return nil
+}
+
+// restoreChecksum puts back the checksum sidecar of fileName at dest as it was before a
+// rekey: with sum, or removed when the backup had none
+func restoreChecksum(ctx context.Context, dest, fileName, sum string) error {
+	if sum == "" {
+		return storage.Delete(ctx, dest, fileName+storage.ChecksumExt)
+	}
+	return storage.UploadChecksum(ctx, dest, fileName, sum)
+}
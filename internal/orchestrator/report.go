@@ -0,0 +1,205 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/Yoone/blobber/internal/config"
+	"github.com/Yoone/blobber/internal/storage"
+	"github.com/dustin/go-humanize"
+)
+
+// RunReport summarizes a backup run. It is written as JSON next to the backups so
+// the run history can be inspected on the remote without blobber.
+type RunReport struct {
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+	Databases  []ReportDatabase `json:"databases"`
+}
+
+// ReportDatabase is the outcome of a single database in a RunReport
+type ReportDatabase struct {
+	Name     string   `json:"name"`
+	Success  bool     `json:"success"`
+	Filename string   `json:"filename,omitempty"`
+	Size     int64    `json:"size,omitempty"`
+	RawSize  int64    `json:"uncompressed_size,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// NewRunReport builds a report from the results of RunBackups
+func NewRunReport(startedAt, finishedAt time.Time, results []BackupResult) RunReport {
+	report := RunReport{StartedAt: startedAt, FinishedAt: finishedAt}
+	for _, r := range results {
+		entry := ReportDatabase{
+			Name:     r.DBName,
+			Success:  r.Success,
+			Filename: r.Filename,
+			Size:     r.Size,
+			RawSize:  r.UncompressedSize,
+		}
+		if r.Error != nil {
+			entry.Error = r.Error.Error()
+		}
+		for _, step := range r.Steps {
+			entry.Warnings = append(entry.Warnings, step.Warnings...)
+		}
+		report.Databases = append(report.Databases, entry)
+	}
+	return report
+}
+
+// StorageChange is how much a backup run added to and removed from its destinations
+type StorageChange struct {
+	Uploaded int64 // bytes of new backups uploaded
+	Deleted  int64 // bytes of old backups deleted by retention
+}
+
+// SumStorageChange adds up the bytes uploaded and deleted in the results of RunBackups
+func SumStorageChange(results []BackupResult) StorageChange {
+	var change StorageChange
+	for _, r := range results {
+		change.Uploaded += r.UploadedSize
+		change.Deleted += r.DeletedSize
+	}
+	return change
+}
+
+// String formats the change, e.g. "Uploaded 3.1 GiB, deleted 2.8 GiB (net +300 MiB)"
+func (c StorageChange) String() string {
+	net, sign := c.Uploaded-c.Deleted, "+"
+	if net < 0 {
+		net, sign = -net, "-"
+	} else if net == 0 {
+		sign = ""
+	}
+	return fmt.Sprintf("Uploaded %s, deleted %s (net %s%s)",
+		humanize.IBytes(uint64(c.Uploaded)), humanize.IBytes(uint64(c.Deleted)), sign, humanize.IBytes(uint64(net)))
+}
+
+// FormatSizeDelta formats a size difference between two backups (see
+// retention.SizeDeltas) with an explicit sign, e.g. "+3.2 MiB"
+func FormatSizeDelta(delta int64) string {
+	if delta < 0 {
+		return "-" + humanize.IBytes(uint64(-delta))
+	}
+	return "+" + humanize.IBytes(uint64(delta))
+}
+
+// WriteRunReport uploads the report as reports/run_{YYYYMMDD_HHMMSS}.json under dest.
+// Returns the full path of the written report.
+func WriteRunReport(ctx context.Context, dest string, report RunReport) (string, error) {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("encoding report: %w", err)
+	}
+
+	dir := reportDir(dest)
+	fileName := fmt.Sprintf("run_%s.json", report.StartedAt.Format("20060102_150405"))
+	if err := storage.UploadBytes(ctx, data, dir, fileName); err != nil {
+		return "", fmt.Errorf("writing report: %w", err)
+	}
+	return dir + "/" + fileName, nil
+}
+
+// WriteRunReportFor writes the report to the destination configured for the given
+// databases. It is a no-op returning "" when reports are disabled.
+func WriteRunReportFor(ctx context.Context, cfg *config.Config, databases []string, report RunReport) (string, error) {
+	dest := cfg.ReportDestination(databases)
+	if dest == "" {
+		return "", nil
+	}
+	return WriteRunReport(ctx, dest, report)
+}
+
+// reportDir returns the reports directory under dest
+func reportDir(dest string) string {
+	return storage.JoinDest(dest, storage.ReportsDir)
+}
+
+// RestoreRecord is the audit record of a restore: who restored which backup into which
+// database, and whether it worked. Records are appended to the restore audit log as
+// JSON lines.
+type RestoreRecord struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user"`
+	Host     string    `json:"host"`
+	Database string    `json:"database"`
+	Source   string    `json:"source"`   // destination the backup was downloaded from, or "local"
+	Filename string    `json:"filename"` // the backup restored (its path for a local file)
+	Size     int64     `json:"size"`
+	Success  bool      `json:"success"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// NewRestoreRecord builds the record of restoring filename from source into database,
+// taken now by the current user. err is the restore's error, nil when it succeeded.
+func NewRestoreRecord(database, source, filename string, size int64, err error) RestoreRecord {
+	rec := RestoreRecord{
+		Time:     time.Now(),
+		User:     os.Getenv("USER"),
+		Database: database,
+		Source:   source,
+		Filename: filename,
+		Size:     size,
+		Success:  err == nil,
+	}
+	if u, uerr := user.Current(); uerr == nil {
+		rec.User = u.Username
+	}
+	rec.Host, _ = os.Hostname()
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	return rec
+}
+
+// WriteRestoreRecord appends rec to the restore audit log and, with restore_audit_dest,
+// uploads it as reports/restore_{YYYYMMDD_HHMMSS}_{database}.json there. It is a no-op
+// when the restore audit is off. Writing is best-effort, so as not to fail a restore
+// that already happened: a warning is returned for each write that failed.
+func WriteRestoreRecord(ctx context.Context, cfg *config.Config, rec RestoreRecord) []string {
+	path := cfg.RestoreAuditPath()
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return []string{fmt.Sprintf("restore audit record not written: %v", err)}
+	}
+
+	var warnings []string
+	if err := appendLine(path, data); err != nil {
+		warnings = append(warnings, fmt.Sprintf("restore audit log not written: %v", err))
+	}
+	if cfg.RestoreAuditDest != "" {
+		fileName := fmt.Sprintf("restore_%s_%s.json", rec.Time.Format("20060102_150405"), rec.Database)
+		if err := storage.UploadBytes(ctx, data, reportDir(cfg.RestoreAuditDest), fileName); err != nil {
+			warnings = append(warnings, fmt.Sprintf("restore audit record not uploaded: %v", err))
+		}
+	}
+	return warnings
+}
+
+// appendLine appends data and a newline to the file at path, creating it (readable by
+// its owner only, as it names databases and hosts) and its directory if needed
+func appendLine(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
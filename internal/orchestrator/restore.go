@@ -0,0 +1,309 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Yoone/blobber/internal/backup"
+	"github.com/Yoone/blobber/internal/config"
+	"github.com/Yoone/blobber/internal/retention"
+	"github.com/Yoone/blobber/internal/storage"
+	"github.com/dustin/go-humanize"
+)
+
+// RestoreStep represents a step in restoring a database
+type RestoreStep string
+
+const (
+	StepDownloading RestoreStep = "downloading"
+	StepRestoring   RestoreStep = "restoring"
+	StepPostRestore RestoreStep = "post_restore"
+)
+
+func (s RestoreStep) String() string {
+	switch s {
+	case StepDownloading:
+		return "Downloading backup"
+	case StepRestoring:
+		return "Restoring database"
+	case StepPostRestore:
+		return "Running post-restore steps"
+	default:
+		return string(s)
+	}
+}
+
+// RestoreRequest is a database of a batch restore and the backup it restores
+type RestoreRequest struct {
+	Name   string    // database restored, as reported (expanded for database "*" entries)
+	Entry  string    // config entry restored into
+	Source string    // config entry whose backup is restored: Entry, or another of its type (clone)
+	File   string    // backup restored, relative to the destination of Source
+	Size   int64     // of the backup
+	Time   time.Time // when the backup was taken
+}
+
+// RestoreFailures maps databases to the error that kept them out of a batch restore
+type RestoreFailures map[string]error
+
+// RestoreProgress reports progress for a single database of a batch restore
+type RestoreProgress struct {
+	DBName   string
+	Step     RestoreStep
+	Message  string
+	Done     bool
+	Error    error
+	Warnings []string // restore audit records that could not be written
+}
+
+// RestoreResult contains the final result for a database of a batch restore
+type RestoreResult struct {
+	DBName   string
+	Filename string
+	Success  bool
+	Error    error
+	Steps    []RestoreProgress // completed steps
+}
+
+// restorePointLayouts are the local time layouts ParseRestorePoint accepts
+var restorePointLayouts = []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02T15:04"}
+
+// ParseRestorePoint parses the point in time a batch restore brings databases back to:
+// a local date and time ("2024-01-15 12:00"), an RFC 3339 time, or a date alone, which
+// means the end of that day. An empty value is the zero time, for the latest backups.
+func ParseRestorePoint(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range restorePointLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if date, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return date.AddDate(0, 0, 1).Add(-time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid point in time %q, use YYYY-MM-DD or YYYY-MM-DD HH:MM", value)
+}
+
+// PlanRestoreBatch picks the backup each database of a batch restore restores: its
+// newest, or with at set the newest taken at or before at, to bring every database back
+// to the same point in time. sources maps databases to the entry whose backups they
+// restore instead of their own (clone). A database "*" entry restores each database it
+// has a backup of. Databases with no backup to restore are returned in the failures.
+func PlanRestoreBatch(ctx context.Context, cfg *config.Config, databases []string, sources map[string]string, at time.Time) ([]RestoreRequest, RestoreFailures) {
+	var requests []RestoreRequest
+	failures := make(RestoreFailures)
+	for _, name := range databases {
+		db, ok := cfg.Databases[name]
+		if !ok {
+			failures[name] = fmt.Errorf("database %q not found in config", name)
+			continue
+		}
+		source := name
+		if s := sources[name]; s != "" && s != name {
+			sourceDB, ok := cfg.Databases[s]
+			if !ok {
+				failures[name] = fmt.Errorf("source database %q not found in config", s)
+				continue
+			}
+			if sourceDB.Type != db.Type {
+				failures[name] = fmt.Errorf("cannot restore %s database %q into %s database %q", sourceDB.Type, s, db.Type, name)
+				continue
+			}
+			source = s
+		}
+
+		files, err := ListBackupsIndexed(ctx, source, cfg.Databases[source])
+		if err != nil {
+			failures[name] = fmt.Errorf("listing backups: %w", err)
+			continue
+		}
+		picked := pickBackups(name, source, db, files, at)
+		if len(picked) == 0 {
+			if at.IsZero() {
+				failures[name] = fmt.Errorf("no backups found for %q", source)
+			} else {
+				failures[name] = fmt.Errorf("no backup of %q taken at or before %s", source, at.Format("2006-01-02 15:04:05"))
+			}
+			continue
+		}
+		requests = append(requests, picked...)
+	}
+	return requests, failures
+}
+
+// pickBackups returns the newest backup of source among files to restore into the entry
+// name, taken at or before at unless it is zero. A database "*" entry gets one per
+// database, sorted by name.
+func pickBackups(name, source string, db config.Database, files []storage.RemoteFile, at time.Time) []RestoreRequest {
+	newest := make(map[string]RestoreRequest)
+	for _, f := range files {
+		if strings.HasSuffix(f.Name, storage.ChecksumExt) {
+			continue
+		}
+		taken := retention.BackupTime(f)
+		if !at.IsZero() && taken.After(at) {
+			continue
+		}
+		target, err := RestoreTarget(source, db, path.Base(f.Name))
+		if err != nil {
+			continue
+		}
+		label := name
+		if db.AllDatabases() {
+			label = ExpandedName(name, target.Database)
+		}
+		if prev, ok := newest[label]; ok && !taken.After(prev.Time) {
+			continue
+		}
+		newest[label] = RestoreRequest{Name: label, Entry: name, Source: source, File: f.Name, Size: f.Size, Time: taken}
+	}
+
+	picked := make([]RestoreRequest, 0, len(newest))
+	for _, req := range newest {
+		picked = append(picked, req)
+	}
+	sort.Slice(picked, func(i, j int) bool { return picked[i].Name < picked[j].Name })
+	return picked
+}
+
+// RestoreBatch restores the planned databases (see PlanRestoreBatch) in parallel, at most
+// concurrency at a time, sending progress updates to the progress channel. Each restore
+// is recorded in the restore audit as a single restore would be.
+// The function blocks until all restores complete. Restores not started when ctx is
+// cancelled fail with its error; one already restoring runs to its end.
+func RestoreBatch(ctx context.Context, cfg *config.Config, requests []RestoreRequest, concurrency int, progress chan<- RestoreProgress) []RestoreResult {
+	slots := make(chan struct{}, max(concurrency, 1))
+	var wg sync.WaitGroup
+	results := make([]RestoreResult, len(requests))
+
+	for i, req := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+			}
+			results[i] = runSingleRestore(ctx, cfg, req, progress)
+		}()
+	}
+
+	wg.Wait()
+	return results
+}
+
+// StreamsRestore reports whether restoring fileName into db reads it straight from the
+// destination, as stream_restore asks, rather than downloading it to a temp file first.
+// Zip and encrypted backups are always downloaded.
+func StreamsRestore(db config.Database, fileName string) bool {
+	return db.StreamRestore && backup.Streamable(fileName)
+}
+
+// StreamRestore restores the backup fileName at sourceDest into db as it is downloaded,
+// reporting the download via progressCh like storage.DownloadWithProgress. The final
+// update carries the error of the restore. The channel is closed when it finishes.
+func StreamRestore(ctx context.Context, db config.Database, sourceDest, fileName string, fileSize int64, settings backup.Settings, progressCh chan<- storage.TransferProgress) {
+	storage.StreamWithProgress(ctx, sourceDest, fileName, fileSize, func(r io.Reader) error {
+		return backup.RestoreStream(db, r, path.Base(fileName), settings)
+	}, progressCh)
+}
+
+// runSingleRestore downloads, restores and runs the post-restore steps of one database
+func runSingleRestore(ctx context.Context, cfg *config.Config, req RestoreRequest, progress chan<- RestoreProgress) RestoreResult {
+	result := RestoreResult{DBName: req.Name, Filename: req.File, Success: true}
+	settings := backup.SettingsFrom(cfg)
+
+	fail := func(step RestoreStep, err error, warnings []string) RestoreResult {
+		progress <- RestoreProgress{DBName: req.Name, Step: step, Error: err, Done: true, Warnings: warnings}
+		result.Success = false
+		result.Error = err
+		return result
+	}
+	complete := func(step RestoreStep, msg string, warnings []string, done bool) {
+		update := RestoreProgress{DBName: req.Name, Step: step, Message: msg, Done: done, Warnings: warnings}
+		progress <- update
+		result.Steps = append(result.Steps, update)
+	}
+
+	// Step 1: Download
+	progress <- RestoreProgress{DBName: req.Name, Step: StepDownloading}
+	if err := ctx.Err(); err != nil {
+		return fail(StepDownloading, err, nil)
+	}
+	// A database "*" entry restores into the database the backup was taken from
+	db, err := RestoreTarget(req.Source, cfg.Databases[req.Entry], path.Base(req.File))
+	if err != nil {
+		return fail(StepDownloading, err, nil)
+	}
+	sourceDest := cfg.Databases[req.Source].Dest
+	size := req.Size
+	stream := StreamsRestore(db, req.File)
+	var localPath string
+	if stream {
+		// The download happens during the restore step
+		complete(StepDownloading, fmt.Sprintf("Streaming %s (%s)", req.File, humanize.IBytes(uint64(size))), nil, false)
+	} else {
+		tmpDir, err := os.MkdirTemp("", "blobber-restore-")
+		if err != nil {
+			return fail(StepDownloading, fmt.Errorf("creating temp dir: %w", err), nil)
+		}
+		defer os.RemoveAll(tmpDir)
+		if err := storage.Download(ctx, sourceDest, req.File, tmpDir, settings.Download); err != nil {
+			return fail(StepDownloading, fmt.Errorf("downloading backup: %w", err), nil)
+		}
+		localPath = filepath.Join(tmpDir, req.File)
+		if stat, err := os.Stat(localPath); err == nil {
+			size = stat.Size()
+		}
+		complete(StepDownloading, fmt.Sprintf("Downloaded %s (%s)", req.File, humanize.IBytes(uint64(size))), nil, false)
+	}
+
+	// Step 2: Restore
+	progress <- RestoreProgress{DBName: req.Name, Step: StepRestoring}
+	if stream {
+		transfer := make(chan storage.TransferProgress, 10)
+		go StreamRestore(ctx, db, sourceDest, req.File, size, settings, transfer)
+		for p := range transfer {
+			if p.Done {
+				err = p.Error
+			}
+		}
+	} else {
+		err = backup.Restore(db, localPath, settings)
+	}
+	warnings := WriteRestoreRecord(ctx, cfg, NewRestoreRecord(req.Name, sourceDest, req.File, size, err))
+	if err != nil {
+		return fail(StepRestoring, fmt.Errorf("restoring backup: %w", err), warnings)
+	}
+	target := db.Database
+	if db.Type == "file" {
+		target = db.Path
+	}
+	complete(StepRestoring, fmt.Sprintf("Restored to %s", target), warnings, !backup.HasPostRestore(db))
+
+	// Step 3: Post-restore
+	if backup.HasPostRestore(db) {
+		progress <- RestoreProgress{DBName: req.Name, Step: StepPostRestore}
+		msg, err := backup.PostRestore(db, settings)
+		if err != nil {
+			return fail(StepPostRestore, fmt.Errorf("post-restore: %w", err), nil)
+		}
+		complete(StepPostRestore, msg, nil, true)
+	}
+	return result
+}
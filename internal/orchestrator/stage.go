@@ -0,0 +1,195 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Yoone/blobber/internal/backup"
+	"github.com/Yoone/blobber/internal/config"
+	"github.com/Yoone/blobber/internal/retention"
+)
+
+// stagedExt suffixes the record saved next to each staged dump
+const stagedExt = ".staged.json"
+
+// StagedDump is a dump left in the staging directory by backup --stage, waiting for
+// upload-staged to upload it and apply retention
+type StagedDump struct {
+	Name             string    `json:"name"`               // entry it was dumped as (expanded for database "*" entries)
+	Entry            string    `json:"entry"`              // config entry: Name, or the database "*" entry it expanded from
+	Database         string    `json:"database,omitempty"` // database of an expanded entry
+	Filename         string    `json:"filename"`
+	Size             int64     `json:"size"`
+	UncompressedSize int64     `json:"uncompressed_size,omitempty"`
+	SHA256           string    `json:"sha256"`
+	DumpedAt         time.Time `json:"dumped_at"`
+
+	Path string `json:"-"` // where the dump is, set by ListStaged
+}
+
+// StageDump moves the dump of the entry name into the staging directory and records it
+// there for upload-staged
+func StageDump(cfg *config.Config, name string, result *backup.Result) error {
+	db := cfg.Databases[name]
+	staged := StagedDump{
+		Name:             name,
+		Entry:            name,
+		Filename:         result.Filename,
+		Size:             result.Size,
+		UncompressedSize: result.UncompressedSize,
+		SHA256:           result.SHA256,
+		DumpedAt:         time.Now(),
+	}
+	if db.ExpandedFrom != "" {
+		staged.Entry, staged.Database = db.ExpandedFrom, db.Database
+	}
+
+	data, err := json.MarshalIndent(staged, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding staged dump: %w", err)
+	}
+	dir := cfg.StagingDirectory()
+	if err := backup.MoveTo(result, dir); err != nil {
+		return fmt.Errorf("staging dump: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, result.Filename+stagedExt), data, 0600); err != nil {
+		return fmt.Errorf("staging dump: %w", err)
+	}
+	return nil
+}
+
+// ListStaged returns the dumps waiting in the staging directory, oldest first. A record
+// whose dump is gone is skipped.
+func ListStaged(cfg *config.Config) ([]StagedDump, error) {
+	dir := cfg.StagingDirectory()
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading staging directory: %w", err)
+	}
+
+	var staged []StagedDump
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), stagedExt) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading staged dump: %w", err)
+		}
+		var s StagedDump
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, fmt.Errorf("decoding staged dump %s: %w", entry.Name(), err)
+		}
+		s.Path = filepath.Join(dir, s.Filename)
+		if _, err := os.Stat(s.Path); err != nil {
+			continue
+		}
+		staged = append(staged, s)
+	}
+	sort.SliceStable(staged, func(i, j int) bool { return staged[i].DumpedAt.Before(staged[j].DumpedAt) })
+	return staged, nil
+}
+
+// result returns the staged dump as the backup result the upload steps work on
+func (s StagedDump) result() *backup.Result {
+	return &backup.Result{
+		Name:             s.Name,
+		Filename:         s.Filename,
+		Path:             s.Path,
+		Size:             s.Size,
+		SHA256:           s.SHA256,
+		Kept:             true,
+		UncompressedSize: s.UncompressedSize,
+	}
+}
+
+// unstage removes an uploaded dump from the staging directory along with its record.
+// The dump may already have been moved out (into the local tier of a tiered dest).
+func unstage(s StagedDump, result *backup.Result) {
+	if result.Path == s.Path {
+		os.Remove(s.Path)
+	}
+	os.Remove(s.Path + stagedExt)
+}
+
+// StagedConfig returns a copy of cfg holding the entries the staged dumps were dumped as
+// (database "*" entries expanded again) and the dumps it can upload. Dumps of entries no
+// longer in cfg are left staged, with a warning.
+func StagedConfig(cfg *config.Config, staged []StagedDump) (*config.Config, []StagedDump, []string) {
+	runCfg := *cfg
+	runCfg.Databases = make(map[string]config.Database, len(cfg.Databases))
+	for name, db := range cfg.Databases {
+		runCfg.Databases[name] = db
+	}
+
+	var uploadable []StagedDump
+	var warnings []string
+	for _, s := range staged {
+		db, ok := cfg.Databases[s.Entry]
+		switch {
+		case !ok:
+			warnings = append(warnings, fmt.Sprintf("%s: database %q is no longer configured, left staged", s.Filename, s.Entry))
+			continue
+		case s.Entry != s.Name && !db.AllDatabases():
+			warnings = append(warnings, fmt.Sprintf("%s: database %q no longer backs up all databases, left staged", s.Filename, s.Entry))
+			continue
+		case s.Entry != s.Name:
+			runCfg.Databases[s.Name] = expandedDatabase(s.Entry, db, s.Database)
+		}
+		uploadable = append(uploadable, s)
+	}
+	return &runCfg, uploadable, warnings
+}
+
+// RunStagedUploads uploads staged dumps one at a time, oldest first, and applies
+// retention as a backup run would, sending progress updates. A dump whose upload fails
+// stays staged for the next attempt. cfg must hold the entries of the dumps (see
+// StagedConfig).
+func RunStagedUploads(ctx context.Context, cfg *config.Config, staged []StagedDump, opts BackupOptions, progress chan<- BackupProgress) []BackupResult {
+	settings, concurrency := backup.SettingsFrom(cfg), retention.ConcurrencyFrom(cfg)
+	var results []BackupResult
+	for _, s := range staged {
+		db := cfg.Databases[s.Name]
+		result := BackupResult{DBName: s.Name, Success: true, Filename: s.Filename, Size: s.Size, UncompressedSize: s.UncompressedSize}
+
+		fail := func(err error) {
+			err = fmt.Errorf("%w (still staged at %s)", err, s.Path)
+			progress <- BackupProgress{DBName: s.Name, Step: StepUploading, Error: err, Done: true}
+			result.Success = false
+			result.Error = err
+		}
+
+		progress <- BackupProgress{DBName: s.Name, Step: StepUploading}
+		if err := ctx.Err(); err != nil {
+			fail(err)
+			results = append(results, result)
+			continue
+		}
+		backupResult := s.result()
+		msg, warnings, err := uploadBackup(ctx, db, s.Name, backupResult, settings)
+		if err != nil {
+			fail(err)
+			results = append(results, result)
+			continue
+		}
+		unstage(s, backupResult)
+		result.UploadedSize = s.Size
+
+		progress <- BackupProgress{DBName: s.Name, Step: StepUploading, Message: msg, Warnings: warnings}
+		result.Steps = append(result.Steps, BackupProgress{DBName: s.Name, Step: StepUploading, Message: msg, Warnings: warnings})
+
+		applyRetention(ctx, db, s.Name, backupResult, opts, progress, &result, settings, concurrency)
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,78 @@
+package orchestrator
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/Yoone/blobber/internal/config"
+	"github.com/Yoone/blobber/internal/retention"
+	"github.com/Yoone/blobber/internal/storage"
+)
+
+// StaleDatabase is a database whose newest backup is older than its stale threshold
+// (config.StaleThreshold), or that has none
+type StaleDatabase struct {
+	Name       string
+	LastBackup time.Time     // zero when no backup was found
+	Threshold  time.Duration // age after which its newest backup is stale
+	Err        error         // set when its destination could not be listed
+}
+
+// StaleDatabases returns the configured databases without a backup newer than their
+// stale threshold at now, sorted by name. Each destination is listed once; a database
+// whose destination can't be listed is reported with the error, since its backups can't
+// be vouched for either.
+func StaleDatabases(ctx context.Context, cfg *config.Config, now time.Time) []StaleDatabase {
+	listings := make(map[string][]storage.RemoteFile)
+	listErrs := make(map[string]error)
+
+	var stale []StaleDatabase
+	for name, db := range cfg.Databases {
+		if _, listed := listings[db.Dest]; !listed && listErrs[db.Dest] == nil {
+			files, err := storage.List(ctx, db.Dest)
+			if err != nil && !storage.IsNotFound(err) {
+				listErrs[db.Dest] = err
+			} else {
+				listings[db.Dest] = files // none backed up there yet when not found
+			}
+		}
+
+		threshold := cfg.StaleThreshold(db)
+		if err := listErrs[db.Dest]; err != nil {
+			stale = append(stale, StaleDatabase{Name: name, Threshold: threshold, Err: err})
+			continue
+		}
+		last := newestBackup(name, db, listings[db.Dest])
+		if last.IsZero() || now.Sub(last) > threshold {
+			stale = append(stale, StaleDatabase{Name: name, LastBackup: last, Threshold: threshold})
+		}
+	}
+
+	sort.Slice(stale, func(i, j int) bool { return stale[i].Name < stale[j].Name })
+	return stale
+}
+
+// newestBackup returns when the newest backup of the entry name among files was taken,
+// or the zero time if it has none. For a database "*" entry this is the newest backup of
+// any database it expanded to.
+func newestBackup(name string, db config.Database, files []storage.RemoteFile) time.Time {
+	var newest time.Time
+	for _, f := range files {
+		backupName, ok := retention.BackupName(f.Name)
+		if !ok {
+			continue
+		}
+		if db.AllDatabases() {
+			if _, err := RestoreTarget(name, db, f.Name); err != nil {
+				continue
+			}
+		} else if backupName != db.BackupPrefix(name) {
+			continue
+		}
+		if t := retention.BackupTime(f); t.After(newest) {
+			newest = t
+		}
+	}
+	return newest
+}
@@ -0,0 +1,90 @@
+package orchestrator
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Yoone/blobber/internal/config"
+	"github.com/Yoone/blobber/internal/retention"
+	"github.com/Yoone/blobber/internal/storage"
+)
+
+// BackupUsage is the space blobber's backups take
+type BackupUsage struct {
+	Backups int   // number of backup files
+	Bytes   int64 // their total size
+}
+
+// DestsOnRemote returns the distinct destinations of the configured databases that are
+// on the rclone remote, sorted. Destinations inside another one are left out.
+func DestsOnRemote(cfg *config.Config, remote string) []string {
+	var dests []string
+	for _, db := range cfg.Databases {
+		if strings.HasPrefix(db.Dest, remote+":") {
+			dests = append(dests, db.Dest)
+		}
+	}
+	return outermostDests(dests)
+}
+
+// Destinations returns the distinct destinations of the named databases (all of them
+// when databases is empty), sorted. Destinations inside another one are left out.
+func Destinations(cfg *config.Config, databases []string) []string {
+	if len(databases) == 0 {
+		databases = slices.Collect(maps.Keys(cfg.Databases))
+	}
+	var dests []string
+	for _, name := range databases {
+		dests = append(dests, cfg.Databases[name].Dest)
+	}
+	return outermostDests(dests)
+}
+
+// outermostDests sorts dests and drops duplicates and destinations inside another one:
+// listing a destination includes its subdirectories, so they would be counted twice
+func outermostDests(all []string) []string {
+	sort.Strings(all)
+	var dests []string
+	for _, dest := range all {
+		if !slices.ContainsFunc(dests, func(parent string) bool { return withinDest(dest, parent) }) {
+			dests = append(dests, dest)
+		}
+	}
+	return dests
+}
+
+// withinDest reports whether dest is parent or a directory inside it
+func withinDest(dest, parent string) bool {
+	if dest == parent {
+		return true
+	}
+	if !strings.HasSuffix(parent, ":") && !strings.HasSuffix(parent, "/") {
+		parent += "/"
+	}
+	return strings.HasPrefix(dest, parent)
+}
+
+// Usage sums the blobber backups in dests. Other files (checksums, reports, anything
+// not named like a backup) are not counted.
+func Usage(ctx context.Context, dests []string) (BackupUsage, error) {
+	var usage BackupUsage
+	for _, dest := range dests {
+		files, err := storage.List(ctx, dest)
+		if err != nil {
+			if storage.IsNotFound(err) {
+				continue // nothing backed up there yet
+			}
+			return BackupUsage{}, err
+		}
+		for _, f := range files {
+			if _, ok := retention.BackupName(f.Name); ok {
+				usage.Backups++
+				usage.Bytes += f.Size
+			}
+		}
+	}
+	return usage, nil
+}
@@ -0,0 +1,144 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Yoone/blobber/internal/config"
+	"github.com/Yoone/blobber/internal/storage"
+	"github.com/rclone/rclone/fs"
+)
+
+// deleteAttempts is how many times a deletion failing with a transient error is tried
+const deleteAttempts = 3
+
+// deleteRetryDelay is the pause between attempts (a variable so tests can shorten it)
+var deleteRetryDelay = 2 * time.Second
+
+// Concurrency of a retention run: how many directories are listed in parallel when
+// listing the backups retention decides on (rclone's checkers) and how many backups it
+// deletes at once. Providers often rate-limit listing and deleting separately. Zero
+// values keep the defaults: rclone's 8 checkers and one deletion at a time.
+type Concurrency struct {
+	ListCheckers  int
+	DeleteWorkers int
+}
+
+// ConcurrencyFrom returns the concurrency set by retention_list_checkers and
+// retention_delete_workers
+func ConcurrencyFrom(cfg *config.Config) Concurrency {
+	return Concurrency{ListCheckers: cfg.RetentionListCheckers, DeleteWorkers: cfg.RetentionDeleteWorkers}
+}
+
+// ListContext returns ctx with the checkers of c, for listing the backups retention
+// decides on. Backends listing a whole destination in one request (ListR, e.g. S3)
+// don't use checkers.
+func (c Concurrency) ListContext(ctx context.Context) context.Context {
+	if c.ListCheckers <= 0 {
+		return ctx
+	}
+	ctx, ci := fs.AddConfig(ctx)
+	ci.Checkers = c.ListCheckers
+	return ctx
+}
+
+// DeleteFailure records a backup that retention could not delete
+type DeleteFailure struct {
+	Name string
+	Err  error
+}
+
+// DeleteResult is the outcome of deleting the files selected by Apply
+type DeleteResult struct {
+	Deleted      int
+	DeletedBytes int64    // total size of the deleted backups
+	Names        []string // of the deleted backups
+	Failed       []DeleteFailure
+}
+
+// Message summarizes the result for the retention step log
+func (r DeleteResult) Message() string {
+	if len(r.Failed) == 0 {
+		return fmt.Sprintf("Deleted %d old backup(s)", r.Deleted)
+	}
+	return fmt.Sprintf("Deleted %d old backup(s), %d could not be deleted", r.Deleted, len(r.Failed))
+}
+
+// Warnings returns one line per file that could not be deleted
+func (r DeleteResult) Warnings() []string {
+	var warnings []string
+	for _, f := range r.Failed {
+		warnings = append(warnings, fmt.Sprintf("could not delete %s: %v", f.Name, f.Err))
+	}
+	return warnings
+}
+
+// PlanMessage summarizes the backups selected by Apply for a retention dry run, which
+// reports them instead of deleting them
+func PlanMessage(files []storage.RemoteFile) string {
+	return fmt.Sprintf("Would delete %d old backup(s)", len(files))
+}
+
+// Delete removes the given backups from dest. Transient errors are retried; files that
+// are already gone count as deleted. Failures are collected instead of aborting.
+// Backups are deleted in parallel when c allows it.
+func Delete(ctx context.Context, dest string, files []storage.RemoteFile, c Concurrency) DeleteResult {
+	return deleteFiles(ctx, files, c.DeleteWorkers, func(name string) error {
+		return storage.Delete(ctx, dest, name)
+	})
+}
+
+func deleteFiles(ctx context.Context, files []storage.RemoteFile, workers int, del func(name string) error) DeleteResult {
+	// Each worker takes the next file; errors are kept by index so failures are
+	// reported in the order of files
+	errs := make([]error, len(files))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(workers, 1), len(files)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = deleteWithRetry(ctx, files[i].Name, del)
+			}
+		}()
+	}
+	for i := range files {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var result DeleteResult
+	for i, f := range files {
+		if err := errs[i]; err == nil || storage.IsNotFound(err) {
+			result.Deleted++
+			result.DeletedBytes += f.Size
+			result.Names = append(result.Names, f.Name)
+		} else {
+			result.Failed = append(result.Failed, DeleteFailure{Name: f.Name, Err: err})
+		}
+	}
+	return result
+}
+
+// deleteWithRetry deletes one file, retrying transient errors
+func deleteWithRetry(ctx context.Context, name string, del func(name string) error) error {
+	var err error
+	for attempt := 1; attempt <= deleteAttempts; attempt++ {
+		err = del(name)
+		if err == nil || storage.IsNotFound(err) || !storage.IsTransient(err) || attempt == deleteAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(deleteRetryDelay):
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
@@ -2,14 +2,17 @@ package retention
 
 import (
 	"context"
+	"fmt"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/Yoone/blobber/internal/config"
 	"github.com/Yoone/blobber/internal/storage"
+	"github.com/dustin/go-humanize"
 )
 
 // backupFile represents a backup file with parsed timestamp
@@ -28,6 +31,11 @@ func parseFilename(filename string) (name string, timestamp time.Time, ok bool)
 	// Remove any directory prefix
 	base := filepath.Base(filename)
 
+	// A partial upload is not a backup, even though it is named like one
+	if strings.HasSuffix(base, storage.PartExt) {
+		return "", time.Time{}, false
+	}
+
 	matches := filenamePattern.FindStringSubmatch(base)
 	if matches == nil {
 		return "", time.Time{}, false
@@ -63,17 +71,127 @@ func filterByName(files []storage.RemoteFile, dbName string) []backupFile {
 		})
 	}
 
-	// Sort by timestamp, newest first
+	// Sort by timestamp, newest first. Backups sharing a timestamp (e.g. an imported
+	// .sql next to a .sql.gz) are ordered by name, so which one a rule deletes doesn't
+	// depend on the order they were listed in.
 	sort.Slice(filtered, func(i, j int) bool {
-		return filtered[i].Timestamp.After(filtered[j].Timestamp)
+		return newerBackup(filtered[i], filtered[j])
 	})
 
 	return filtered
 }
 
+// newerBackup reports whether a sorts as the newer backup of a and b: the one with the
+// later timestamp, or the later name when their timestamps are equal
+func newerBackup(a, b backupFile) bool {
+	if !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.After(b.Timestamp)
+	}
+	return a.Name > b.Name
+}
+
+// SizeDeltas returns, for each backup file, the size difference against the previous
+// (older) backup of the same database. Files are grouped by the database name parsed
+// from the filename, so backups of other databases sharing a destination never affect
+// each other. The oldest backup of each database and files not matching the naming
+// convention have no entry in the returned map.
+func SizeDeltas(files []storage.RemoteFile) map[string]int64 {
+	groups := make(map[string][]backupFile)
+	for _, f := range files {
+		name, ts, ok := parseFilename(f.Name)
+		if !ok {
+			continue
+		}
+		key := strings.ToLower(name)
+		groups[key] = append(groups[key], backupFile{RemoteFile: f, Timestamp: ts})
+	}
+
+	deltas := make(map[string]int64)
+	for _, group := range groups {
+		// Sort by timestamp, oldest first
+		sort.Slice(group, func(i, j int) bool {
+			return newerBackup(group[j], group[i])
+		})
+		for i := 1; i < len(group); i++ {
+			deltas[group[i].Name] = group[i].Size - group[i-1].Size
+		}
+	}
+	return deltas
+}
+
+// SizeAnomaly compares the size of the backup named newest with the median size of up to
+// history backups of dbName taken before it. It returns a warning when newest is at least
+// factor times larger or smaller than the median, or "" when it is within range or there
+// is no earlier backup to compare with.
+func SizeAnomaly(files []storage.RemoteFile, dbName, newest string, factor float64, history int) string {
+	backups := filterByName(files, dbName) // newest first
+	idx := slices.IndexFunc(backups, func(f backupFile) bool {
+		return filepath.Base(f.Name) == filepath.Base(newest)
+	})
+	if idx < 0 || idx == len(backups)-1 {
+		return ""
+	}
+	previous := backups[idx+1 : min(idx+1+history, len(backups))]
+
+	sizes := make([]int64, len(previous))
+	for i, f := range previous {
+		sizes[i] = f.Size
+	}
+	slices.Sort(sizes)
+	median := float64(sizes[len(sizes)/2])
+	if len(sizes)%2 == 0 {
+		median = float64(sizes[len(sizes)/2-1]+sizes[len(sizes)/2]) / 2
+	}
+	if median <= 0 {
+		return ""
+	}
+
+	size := backups[idx].Size
+	ratio := float64(size) / median
+	switch {
+	case ratio >= factor:
+		return fmt.Sprintf("size anomaly: %s is %.1fx the median of the last %d backups (%s)",
+			humanize.IBytes(uint64(size)), ratio, len(previous), humanize.IBytes(uint64(median)))
+	case ratio <= 1/factor:
+		return fmt.Sprintf("size anomaly: %s is %.1fx smaller than the median of the last %d backups (%s)",
+			humanize.IBytes(uint64(size)), median/float64(max(size, 1)), len(previous), humanize.IBytes(uint64(median)))
+	}
+	return ""
+}
+
+// BackupName returns the database entry name a backup file was written for, as encoded
+// in its filename ({name}_{YYYYMMDD_HHMMSS}.{ext})
+func BackupName(filename string) (string, bool) {
+	name, _, ok := parseFilename(filename)
+	return name, ok
+}
+
+// Unmatched returns the files whose names don't follow the backup naming convention.
+// Retention never considers them, so they are never deleted.
+func Unmatched(files []storage.RemoteFile) []storage.RemoteFile {
+	var unmatched []storage.RemoteFile
+	for _, f := range files {
+		if _, _, ok := parseFilename(f.Name); !ok {
+			unmatched = append(unmatched, f)
+		}
+	}
+	return unmatched
+}
+
+// BackupTime returns when a backup was taken: the timestamp in its filename (in local
+// time, as written by backup.Run), or its modification time for other files
+func BackupTime(f storage.RemoteFile) time.Time {
+	if _, ts, ok := parseFilename(f.Name); ok {
+		return time.Date(ts.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), 0, time.Local)
+	}
+	return f.ModTime
+}
+
 // Apply applies the retention policy and returns files to delete.
-// Only considers files matching the database name and naming convention.
-// Multiple retention rules can be combined - a file is deleted if ANY rule marks it for deletion.
+// Only considers files matching the database's backup prefix (config.Database.BackupPrefix)
+// and naming convention.
+// Multiple retention rules can be combined - a file is deleted if ANY rule marks it for deletion,
+// and max_count then caps the number of backups left.
 // The pendingBackups parameter indicates how many new backups will be added after this calculation,
 // so the retention policy accounts for them (e.g., if keepLast=5 and pendingBackups=1, we keep 4 existing).
 func Apply(ctx context.Context, files []storage.RemoteFile, dbName string, retention config.Retention, pendingBackups int) []storage.RemoteFile {
@@ -112,10 +230,21 @@ func Apply(ctx context.Context, files []storage.RemoteFile, dbName string, reten
 		}
 	}
 
-	// Convert map to slice
+	// max_count caps what the rules above keep, deleting the oldest backups beyond it
+	// even when keep_days would keep them
+	if retention.MaxCount > 0 {
+		maxCount := max(retention.MaxCount-pendingBackups, 0)
+		for _, f := range applyMaxCount(filtered, toDeleteMap, maxCount) {
+			toDeleteMap[f.Name] = f
+		}
+	}
+
+	// Convert map to slice, newest first like filtered so the order is stable
 	result := make([]storage.RemoteFile, 0, len(toDeleteMap))
-	for _, f := range toDeleteMap {
-		result = append(result, f.RemoteFile)
+	for _, f := range filtered {
+		if _, ok := toDeleteMap[f.Name]; ok {
+			result = append(result, f.RemoteFile)
+		}
 	}
 	return result
 }
@@ -127,6 +256,22 @@ func applyKeepLast(files []backupFile, keepLast int) []backupFile {
 	return files[keepLast:]
 }
 
+// applyMaxCount returns the backups of files (newest first) beyond the maxCount newest
+// of those not already in toDelete
+func applyMaxCount(files []backupFile, toDelete map[string]backupFile, maxCount int) []backupFile {
+	var kept int
+	var over []backupFile
+	for _, f := range files {
+		if _, deleted := toDelete[f.Name]; deleted {
+			continue
+		}
+		if kept++; kept > maxCount {
+			over = append(over, f)
+		}
+	}
+	return over
+}
+
 func applyKeepDays(files []backupFile, keepDays int) []backupFile {
 	cutoff := time.Now().AddDate(0, 0, -keepDays)
 
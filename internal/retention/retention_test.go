@@ -2,11 +2,22 @@ package retention
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/Yoone/blobber/internal/config"
 	"github.com/Yoone/blobber/internal/storage"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/fserrors"
 )
 
 func TestParseFilename(t *testing.T) {
@@ -31,6 +42,13 @@ func TestParseFilename(t *testing.T) {
 			wantTimestamp: "20240115_143022",
 			wantOk:        true,
 		},
+		{
+			name:          "archive_ext",
+			filename:      "files_20240115_143022.dat",
+			wantName:      "files",
+			wantTimestamp: "20240115_143022",
+			wantOk:        true,
+		},
 		{
 			name:          "valid db extension",
 			filename:      "sqlite_db_20240115_143022.db.zst",
@@ -38,6 +56,13 @@ func TestParseFilename(t *testing.T) {
 			wantTimestamp: "20240115_143022",
 			wantOk:        true,
 		},
+		{
+			name:          "encrypted",
+			filename:      "mydb_20240115_143022.sql.gz.gpg",
+			wantName:      "mydb",
+			wantTimestamp: "20240115_143022",
+			wantOk:        true,
+		},
 		{
 			name:          "underscores in name",
 			filename:      "my_database_name_20240115_143022.sql",
@@ -50,6 +75,11 @@ func TestParseFilename(t *testing.T) {
 			filename: "mydb_20240115_143022",
 			wantOk:   false,
 		},
+		{
+			name:     "partial upload",
+			filename: "mydb_20240115_143022.sql.gz.part",
+			wantOk:   false,
+		},
 		{
 			name:     "invalid timestamp format",
 			filename: "mydb_2024-01-15_14:30:22.sql.gz",
@@ -233,6 +263,54 @@ func TestApplyMaxSize(t *testing.T) {
 	})
 }
 
+func TestApplyMaxCount(t *testing.T) {
+	ctx := context.Background()
+
+	// 20 daily backups, all within keep_days
+	now := time.Now()
+	var files []storage.RemoteFile
+	for i := range 20 {
+		files = append(files, storage.RemoteFile{Name: "mydb_" + now.AddDate(0, 0, -i).Format("20060102_150405") + ".sql.gz", Size: 100})
+	}
+	oldest := func(n int) []string {
+		var names []string
+		for _, f := range files[len(files)-n:] {
+			names = append(names, f.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name      string
+		retention config.Retention
+		pending   int
+		want      []string
+	}{
+		{"keep_days alone keeps all 20", config.Retention{KeepDays: 30}, 0, nil},
+		{"max_count trims what keep_days keeps", config.Retention{KeepDays: 30, MaxCount: 10}, 0, oldest(10)},
+		{"max_count alone", config.Retention{MaxCount: 15}, 0, oldest(5)},
+		{"max_count accounts for pending backups", config.Retention{KeepDays: 30, MaxCount: 10}, 1, oldest(11)},
+		{"lower than keep_last", config.Retention{KeepLast: 12, MaxCount: 10}, 0, oldest(10)},
+		{"higher than keep_last", config.Retention{KeepLast: 5, MaxCount: 10}, 0, oldest(15)},
+		{"over the backup count", config.Retention{KeepDays: 30, MaxCount: 25}, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range Apply(ctx, files, "mydb", tt.retention, tt.pending) {
+				got = append(got, f.Name)
+			}
+			slices.Sort(got)
+			want := slices.Clone(tt.want)
+			slices.Sort(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("Apply() deletes %d backup(s) %v, want %d %v", len(got), got, len(want), want)
+			}
+		})
+	}
+}
+
 func TestApplyCombinedRules(t *testing.T) {
 	ctx := context.Background()
 
@@ -329,3 +407,343 @@ func TestApplyPendingBackups(t *testing.T) {
 		}
 	})
 }
+
+func TestSizeAnomaly(t *testing.T) {
+	// history builds backups of mydb one day apart, oldest first, ending with newest
+	history := func(sizes ...int64) []storage.RemoteFile {
+		var files []storage.RemoteFile
+		for i, size := range sizes {
+			files = append(files, storage.RemoteFile{Name: fmt.Sprintf("mydb_202401%02d_000000.sql.gz", i+1), Size: size})
+		}
+		return files
+	}
+	newest := func(files []storage.RemoteFile) string { return files[len(files)-1].Name }
+
+	tests := []struct {
+		name    string
+		files   []storage.RemoteFile
+		history int
+		want    string
+	}{
+		{"within range", history(1000, 1100, 900, 1500), 7, ""},
+		{"twice the median", history(1000, 1100, 900, 2000), 7, "size anomaly: 2.0 KiB is 2.0x the median of the last 3 backups (1000 B)"},
+		{"half the median", history(1000, 1100, 900, 500), 7, "size anomaly: 500 B is 2.0x smaller than the median of the last 3 backups (1000 B)"},
+		{"even history", history(1000, 2000, 4000), 7, "size anomaly: 3.9 KiB is 2.7x the median of the last 2 backups (1.5 KiB)"},
+		{"only the last n count", history(100, 100, 100, 1000, 1000, 1000), 2, ""},
+		{"outlier in the history", history(1000, 1000, 90000, 1000), 7, ""},
+		{"first backup", history(1000), 7, ""},
+		{"empty history", history(0, 0, 1000), 7, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SizeAnomaly(tt.files, "mydb", newest(tt.files), 2, tt.history); got != tt.want {
+				t.Errorf("SizeAnomaly() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("other databases and dated folders", func(t *testing.T) {
+		files := []storage.RemoteFile{
+			{Name: "2024-01-01/mydb_20240101_000000.sql.gz", Size: 1000},
+			{Name: "2024-01-02/mydb_20240102_000000.sql.gz", Size: 1000},
+			{Name: "mydb_other_20240102_120000.sql.gz", Size: 5},
+			{Name: "2024-01-03/mydb_20240103_000000.sql.gz", Size: 3000},
+		}
+		if got := SizeAnomaly(files, "mydb", "mydb_20240103_000000.sql.gz", 2, 7); got == "" {
+			t.Error("SizeAnomaly() = \"\", want the 3x backup flagged")
+		}
+	})
+}
+
+func TestSizeDeltas(t *testing.T) {
+	files := []storage.RemoteFile{
+		{Name: "mydb_20240115_150000.sql.gz", Size: 1500},
+		{Name: "mydb_20240115_140000.sql.gz", Size: 1000},
+		{Name: "mydb_20240115_130000.sql.gz", Size: 1200},
+		{Name: "mydb_other_20240115_145000.sql.gz", Size: 50},
+		{Name: "mydb_other_20240115_135000.sql.gz", Size: 80},
+		{Name: "random_file.txt", Size: 10},
+	}
+
+	deltas := SizeDeltas(files)
+
+	tests := []struct {
+		name     string
+		expected int64
+		present  bool
+	}{
+		{"mydb_20240115_150000.sql.gz", 500, true},
+		{"mydb_20240115_140000.sql.gz", -200, true},
+		{"mydb_20240115_130000.sql.gz", 0, false}, // oldest, no previous backup
+		{"mydb_other_20240115_145000.sql.gz", -30, true},
+		{"mydb_other_20240115_135000.sql.gz", 0, false},
+		{"random_file.txt", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta, ok := deltas[tt.name]
+			if ok != tt.present {
+				t.Fatalf("deltas[%q] present = %v, want %v", tt.name, ok, tt.present)
+			}
+			if delta != tt.expected {
+				t.Errorf("deltas[%q] = %d, want %d", tt.name, delta, tt.expected)
+			}
+		})
+	}
+}
+
+func TestApplyDuplicateTimestamps(t *testing.T) {
+	ctx := context.Background()
+
+	// Two backups taken in the same second: an imported .sql next to the usual .sql.gz
+	files := []storage.RemoteFile{
+		{Name: "mydb_20240115_150000.sql.gz", Size: 100},
+		{Name: "mydb_20240115_140000.sql", Size: 300},
+		{Name: "mydb_20240115_140000.sql.gz", Size: 100},
+		{Name: "mydb_20240115_130000.sql.gz", Size: 100},
+	}
+
+	// Whatever order the destination lists them in, the same backups are deleted, in
+	// the same order, with the later name counted as the newer of the two
+	want := []string{"mydb_20240115_140000.sql", "mydb_20240115_130000.sql.gz"}
+	for _, order := range [][]int{{0, 1, 2, 3}, {3, 2, 1, 0}, {2, 0, 3, 1}, {1, 3, 0, 2}} {
+		listed := make([]storage.RemoteFile, len(files))
+		for i, idx := range order {
+			listed[i] = files[idx]
+		}
+
+		var got []string
+		for _, f := range Apply(ctx, listed, "mydb", config.Retention{KeepLast: 2}, 0) {
+			got = append(got, f.Name)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("listed as %v: Apply() deletes %v, want %v", order, got, want)
+		}
+
+		deltas := SizeDeltas(listed)
+		if deltas["mydb_20240115_140000.sql.gz"] != -200 || deltas["mydb_20240115_140000.sql"] != 200 {
+			t.Errorf("listed as %v: SizeDeltas() = %v, want each duplicate compared with the other", order, deltas)
+		}
+	}
+}
+
+func TestUnmatched(t *testing.T) {
+	files := []storage.RemoteFile{
+		{Name: "mydb_20240115_150000.sql.gz"},
+		{Name: "sub/mydb_20240115_140000.sql.gz"},
+		{Name: "random_file.txt"},
+		{Name: "mydb_2024-01-15.sql.gz"},
+		{Name: "mydb_20241315_140000.sql.gz"}, // month 13
+		{Name: "mydb_20240115_140000"},        // no extension
+	}
+
+	var got []string
+	for _, f := range Unmatched(files) {
+		got = append(got, f.Name)
+	}
+	want := []string{"random_file.txt", "mydb_2024-01-15.sql.gz", "mydb_20241315_140000.sql.gz", "mydb_20240115_140000"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmatched() = %v, want %v", got, want)
+	}
+}
+
+func TestDeleteFiles(t *testing.T) {
+	deleteRetryDelay = time.Millisecond
+	defer func() { deleteRetryDelay = 2 * time.Second }()
+
+	files := []storage.RemoteFile{
+		{Name: "mydb_20240101_000000.sql", Size: 100},
+		{Name: "mydb_20240102_000000.sql", Size: 20},
+		{Name: "mydb_20240103_000000.sql", Size: 3},
+		{Name: "mydb_20240104_000000.sql", Size: 4},
+	}
+
+	attempts := make(map[string]int)
+	result := deleteFiles(context.Background(), files, 1, func(name string) error {
+		attempts[name]++
+		switch name {
+		case "mydb_20240101_000000.sql": // succeeds after a transient failure
+			if attempts[name] == 1 {
+				return fserrors.RetryErrorf("connection reset")
+			}
+			return nil
+		case "mydb_20240102_000000.sql": // already gone
+			return fmt.Errorf("getting object: %w", fs.ErrorObjectNotFound)
+		case "mydb_20240103_000000.sql": // permanent failure, not retried
+			return errors.New("access denied")
+		default: // transient failure that never clears
+			return fserrors.RetryErrorf("timeout")
+		}
+	})
+
+	if result.Deleted != 2 {
+		t.Errorf("Deleted = %d, want 2", result.Deleted)
+	}
+	if result.DeletedBytes != 120 {
+		t.Errorf("DeletedBytes = %d, want 120", result.DeletedBytes)
+	}
+	if len(result.Failed) != 2 || result.Failed[0].Name != "mydb_20240103_000000.sql" || result.Failed[1].Name != "mydb_20240104_000000.sql" {
+		t.Fatalf("Failed = %v, want the last two files", result.Failed)
+	}
+	if attempts["mydb_20240101_000000.sql"] != 2 {
+		t.Errorf("transient failure tried %d times, want 2", attempts["mydb_20240101_000000.sql"])
+	}
+	if attempts["mydb_20240103_000000.sql"] != 1 {
+		t.Errorf("permanent failure tried %d times, want 1", attempts["mydb_20240103_000000.sql"])
+	}
+	if attempts["mydb_20240104_000000.sql"] != deleteAttempts {
+		t.Errorf("persistent transient failure tried %d times, want %d", attempts["mydb_20240104_000000.sql"], deleteAttempts)
+	}
+	if got := result.Message(); got != "Deleted 2 old backup(s), 2 could not be deleted" {
+		t.Errorf("Message() = %q", got)
+	}
+	if len(result.Warnings()) != 2 {
+		t.Errorf("Warnings() = %q, want 2 entries", result.Warnings())
+	}
+}
+
+func TestApplyPrefixCollidingNames(t *testing.T) {
+	// "db" and "db_extra" share a destination; neither may touch the other's backups
+	files := []storage.RemoteFile{
+		{Name: "db_20240115_100000.sql.gz", Size: 100},
+		{Name: "db_20240114_100000.sql.gz", Size: 100},
+		{Name: "db_extra_20240115_100000.sql.gz", Size: 100},
+		{Name: "db_extra_20240114_100000.sql.gz", Size: 100},
+		{Name: "db_extra_20240113_100000.sql.gz", Size: 100},
+	}
+
+	tests := []struct {
+		dbName     string
+		wantDelete []string
+	}{
+		{"db", []string{"db_20240114_100000.sql.gz"}},
+		{"db_extra", []string{"db_extra_20240114_100000.sql.gz", "db_extra_20240113_100000.sql.gz"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dbName, func(t *testing.T) {
+			var got []string
+			for _, f := range Apply(context.Background(), files, tt.dbName, config.Retention{KeepLast: 1}, 0) {
+				got = append(got, f.Name)
+			}
+			sort.Strings(got)
+			want := append([]string(nil), tt.wantDelete...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Apply(%q) deletes %v, want %v", tt.dbName, got, want)
+			}
+		})
+	}
+}
+
+// concurrency tracks how many calls are in flight at once, and the most seen
+type concurrency struct {
+	mu   sync.Mutex
+	cur  int
+	peak int
+}
+
+func (c *concurrency) enter() func() {
+	c.mu.Lock()
+	c.cur++
+	c.peak = max(c.peak, c.cur)
+	c.mu.Unlock()
+	time.Sleep(20 * time.Millisecond) // slow remote: calls overlap as much as allowed
+	return func() {
+		c.mu.Lock()
+		c.cur--
+		c.mu.Unlock()
+	}
+}
+
+// slowFs is a local directory behind a slow remote, counting concurrent listings and
+// deletions
+type slowFs struct {
+	fs.Fs
+	lists, deletes *concurrency
+}
+
+func (f *slowFs) List(ctx context.Context, dir string) (fs.DirEntries, error) {
+	defer f.lists.enter()()
+	return f.Fs.List(ctx, dir)
+}
+
+func (f *slowFs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	obj, err := f.Fs.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return &slowObject{Object: obj, deletes: f.deletes}, nil
+}
+
+type slowObject struct {
+	fs.Object
+	deletes *concurrency
+}
+
+func (o *slowObject) Remove(ctx context.Context) error {
+	defer o.deletes.enter()()
+	return o.Object.Remove(ctx)
+}
+
+// slowCalls counts the calls of the slowtest backend for the running test
+var slowCalls struct{ lists, deletes *concurrency }
+
+var registerSlowFs sync.Once
+
+func TestConcurrency(t *testing.T) {
+	registerSlowFs.Do(func() {
+		fs.Register(&fs.RegInfo{
+			Name: "slowtest",
+			NewFs: func(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+				f, err := fs.NewFs(ctx, root)
+				if err != nil {
+					return nil, err
+				}
+				return &slowFs{Fs: f, lists: slowCalls.lists, deletes: slowCalls.deletes}, nil
+			},
+		})
+	})
+	lists, deletes := &concurrency{}, &concurrency{}
+	slowCalls.lists, slowCalls.deletes = lists, deletes
+
+	// One backup a day in dated folders (group_by: day), so listing walks 8 directories
+	dir := t.TempDir()
+	for day := 1; day <= 8; day++ {
+		folder := filepath.Join(dir, fmt.Sprintf("2024-01-%02d", day))
+		if err := os.MkdirAll(folder, 0755); err != nil {
+			t.Fatal(err)
+		}
+		name := fmt.Sprintf("mydb_202401%02d_000000.sql", day)
+		if err := os.WriteFile(filepath.Join(folder, name), []byte("backup"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dest := ":slowtest:" + dir
+
+	c := ConcurrencyFrom(&config.Config{RetentionListCheckers: 2, RetentionDeleteWorkers: 3})
+	ctx := context.Background()
+	files, err := storage.ListForDatabase(c.ListContext(ctx), dest, "mydb")
+	if err != nil {
+		t.Fatalf("ListForDatabase() error = %v", err)
+	}
+	if len(files) != 8 {
+		t.Fatalf("listed %d backups, want 8", len(files))
+	}
+	if lists.peak != 2 {
+		t.Errorf("%d directories listed at once, want the 2 checkers", lists.peak)
+	}
+
+	result := Delete(ctx, dest, files, c)
+	if result.Deleted != 8 || len(result.Failed) != 0 {
+		t.Fatalf("Delete() = %+v, want 8 deleted", result)
+	}
+	if deletes.peak != 3 {
+		t.Errorf("%d backups deleted at once, want the 3 delete workers", deletes.peak)
+	}
+	if lists.peak != 2 {
+		t.Errorf("deleting changed the listing concurrency to %d", lists.peak)
+	}
+}
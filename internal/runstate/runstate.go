@@ -0,0 +1,112 @@
+package runstate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Status is where a database of a run is at
+type Status string
+
+const (
+	Pending Status = "pending" // not started yet (waiting on after or for its turn)
+	Running Status = "running" // dumping, uploading or applying retention
+	Done    Status = "done"
+	Failed  Status = "failed" // failed or cancelled
+)
+
+// Database is the status of one database of a run
+type Database struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+}
+
+// State is the progress of a backup run, saved while it runs so a run that was killed
+// can be reported and its unfinished databases resumed. A run that ends removes it.
+type State struct {
+	Started         time.Time  `json:"started"`
+	Updated         time.Time  `json:"updated"`
+	Selected        []string   `json:"selected"` // config entries the run was started with
+	SkipRetention   bool       `json:"skip_retention,omitempty"`
+	RetentionDryRun bool       `json:"retention_dry_run,omitempty"`
+	Databases       []Database `json:"databases"` // in run order, database "*" entries expanded
+}
+
+// PathFor returns the state file path for a config file, next to its lock file
+func PathFor(configPath string) string {
+	return configPath + ".state"
+}
+
+// Names returns the databases of the run with one of the statuses, in run order
+func (s *State) Names(statuses ...Status) []string {
+	var names []string
+	for _, db := range s.Databases {
+		for _, status := range statuses {
+			if db.Status == status {
+				names = append(names, db.Name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// Unfinished returns the databases the run did not get to finish: pending or running
+// when it was killed
+func (s *State) Unfinished() []string {
+	return s.Names(Pending, Running)
+}
+
+// Save writes the state to path, replacing the previous one atomically so a run killed
+// mid-write leaves a readable file
+func Save(path string, s *State) error {
+	s.Updated = time.Now()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding run state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("writing run state: %w", err)
+	}
+	_, werr := tmp.Write(data)
+	cerr := tmp.Close()
+	if werr != nil || cerr != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing run state: %w", errors.Join(werr, cerr))
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing run state: %w", err)
+	}
+	return nil
+}
+
+// Load reads the state at path. Returns nil without error when there is none.
+func Load(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading run state: %w", err)
+	}
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("decoding run state %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Remove deletes the state at path, once its run ended or was dismissed
+func Remove(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing run state: %w", err)
+	}
+	return nil
+}
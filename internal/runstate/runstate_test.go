@@ -0,0 +1,68 @@
+package runstate
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSaveLoadRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml.state")
+
+	s, err := Load(path)
+	if err != nil || s != nil {
+		t.Fatalf("Load() without a state file = %v, %v, want nil, nil", s, err)
+	}
+
+	started := time.Date(2024, 1, 15, 2, 0, 0, 0, time.UTC)
+	want := &State{
+		Started:       started,
+		Selected:      []string{"app", "all"},
+		SkipRetention: true,
+		Databases: []Database{
+			{Name: "app", Status: Done},
+			{Name: "all_users", Status: Failed},
+			{Name: "all_orders", Status: Running},
+			{Name: "all_stock", Status: Pending},
+		},
+	}
+	if err := Save(path, want); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !got.Started.Equal(started) || got.Updated.IsZero() || !got.SkipRetention || !slices.Equal(got.Selected, want.Selected) {
+		t.Errorf("Load() = %+v, want %+v", got, want)
+	}
+	if names := got.Unfinished(); !slices.Equal(names, []string{"all_orders", "all_stock"}) {
+		t.Errorf("Unfinished() = %v, want the running and pending databases", names)
+	}
+	if names := got.Names(Done); !slices.Equal(names, []string{"app"}) {
+		t.Errorf("Names(Done) = %v, want [app]", names)
+	}
+
+	if err := Remove(path); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("state file still exists after Remove()")
+	}
+	if err := Remove(path); err != nil {
+		t.Errorf("Remove() without a state file error = %v", err)
+	}
+}
+
+func TestLoadCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml.state")
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Load() of a corrupt state file should fail")
+	}
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/fspath"
+)
+
+// fsCache holds the fs.Fs of each remote destination, so operations on the same
+// destination share one backend (and its authentication and connections) instead of
+// creating a new one each time. fs.Fs objects are safe for concurrent use.
+var fsCache = struct {
+	mu      sync.Mutex
+	entries map[string]*fsEntry
+}{entries: make(map[string]*fsEntry)}
+
+// fsEntry is a cached fs.Fs. ready is closed once f and err are set.
+type fsEntry struct {
+	ready chan struct{}
+	f     fs.Fs
+	err   error
+}
+
+// openFs returns the fs.Fs for a remote destination, creating it on first use.
+// Concurrent callers for the same destination wait for a single creation; each caller
+// stops waiting when its own context is done. The backend is created with a context
+// that is never canceled, since it outlives the call that created it. Errors are not
+// cached, so a failed destination is retried by the next call.
+func openFs(ctx context.Context, dest string) (fs.Fs, error) {
+	key := fsCacheKey(dest)
+
+	fsCache.mu.Lock()
+	entry, ok := fsCache.entries[key]
+	if !ok {
+		entry = &fsEntry{ready: make(chan struct{})}
+		fsCache.entries[key] = entry
+		go func() {
+			entry.f, entry.err = newFs(context.WithoutCancel(ctx), dest)
+			if entry.err != nil {
+				fsCache.mu.Lock()
+				delete(fsCache.entries, key)
+				fsCache.mu.Unlock()
+			}
+			close(entry.ready)
+		}()
+	}
+	fsCache.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+		return entry.f, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// inlineRemotes holds the backends of destinations written inline in the blobber config,
+// by remote name. They only exist in memory and are never saved to the rclone config.
+var inlineRemotes = struct {
+	mu      sync.RWMutex
+	remotes map[string]inlineRemote
+}{remotes: make(map[string]inlineRemote)}
+
+// inlineRemote is the backend type and options of an inline destination
+type inlineRemote struct {
+	backend string
+	options configmap.Simple
+}
+
+// RegisterInlineRemote makes name usable as a remote in destinations, backed by the
+// given rclone backend and options instead of a section of the rclone config
+func RegisterInlineRemote(name, backend string, options map[string]string) {
+	inlineRemotes.mu.Lock()
+	defer inlineRemotes.mu.Unlock()
+	inlineRemotes.remotes[name] = inlineRemote{backend: backend, options: configmap.Simple(options)}
+}
+
+// newFs creates the fs.Fs of a destination. Destinations on an inline remote are built
+// from its registered options; backend environment variables (such as the no-check-bucket
+// settings of Init) still apply.
+func newFs(ctx context.Context, dest string) (fs.Fs, error) {
+	parsed, err := fspath.Parse(dest)
+	if err != nil {
+		return fs.NewFs(ctx, dest)
+	}
+	inlineRemotes.mu.RLock()
+	remote, ok := inlineRemotes.remotes[parsed.Name]
+	inlineRemotes.mu.RUnlock()
+	if !ok {
+		return fs.NewFs(ctx, dest)
+	}
+
+	info, err := fs.Find(remote.backend)
+	if err != nil {
+		return nil, err
+	}
+	m := fs.ConfigMap(info.Prefix, info.Options, "", remote.options)
+	return info.NewFs(ctx, parsed.Name, parsed.Path, m)
+}
+
+// fsCacheKey is the cache key of a destination: the destination itself plus the
+// settings of its remote in the rclone config, so editing a remote (e.g. from the TUI)
+// gets a fresh backend instead of one built from the old settings
+func fsCacheKey(dest string) string {
+	parsed, err := fspath.Parse(dest)
+	if err != nil || parsed.Name == "" || strings.HasPrefix(parsed.Name, ":") {
+		return dest
+	}
+	name, _, _ := strings.Cut(parsed.Name, ",")
+
+	data := config.LoadedData()
+	keys := data.GetKeyList(name)
+	slices.Sort(keys)
+
+	var b strings.Builder
+	b.WriteString(dest)
+	for _, k := range keys {
+		v, _ := data.GetValue(name, k)
+		b.WriteString("\x00" + k + "=" + v)
+	}
+	return b.String()
+}
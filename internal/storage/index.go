@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// IndexFile is the backup index kept at the root of a destination with backup_index.
+// List never returns it.
+const IndexFile = "blobber-index.json"
+
+// backupIndex is the content of IndexFile: every backup at the destination, newest first
+type backupIndex struct {
+	Updated time.Time    `json:"updated"`
+	Files   []indexEntry `json:"files"`
+}
+
+type indexEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// IndexChange is what an operation changed at a destination, for UpdateIndex: the
+// backups it wrote (uploaded or replaced) and those it deleted, by name relative to the
+// destination
+type IndexChange struct {
+	Written []string
+	Deleted []string
+}
+
+// RefreshIndex lists the destination and writes its backup index. When that fails the
+// index is deleted, so that readers list the destination instead of trusting an index
+// that may be missing a change.
+func RefreshIndex(ctx context.Context, remoteDest string) error {
+	files, err := List(ctx, remoteDest)
+	if err == nil {
+		err = writeIndex(ctx, remoteDest, files)
+	}
+	if err != nil {
+		return dropIndex(ctx, remoteDest, err)
+	}
+	return nil
+}
+
+// UpdateIndex applies change to the destination's backup index, looking up only the
+// backups written instead of listing the destination. A missing or unreadable index is
+// rebuilt with RefreshIndex. As with RefreshIndex, an index that can't be updated is
+// deleted.
+func UpdateIndex(ctx context.Context, remoteDest string, change IndexChange) error {
+	files, err := readIndex(ctx, remoteDest)
+	if err != nil {
+		return RefreshIndex(ctx, remoteDest)
+	}
+
+	changed := make(map[string]bool)
+	for _, name := range change.Deleted {
+		changed[name] = true
+	}
+	for _, name := range change.Written {
+		changed[name] = true
+	}
+	files = slices.DeleteFunc(files, func(f RemoteFile) bool { return changed[f.Name] })
+	for _, name := range change.Written {
+		f, err := Stat(ctx, remoteDest, name)
+		if err != nil {
+			return dropIndex(ctx, remoteDest, fmt.Errorf("looking up %s: %w", name, err))
+		}
+		files = append(files, f)
+	}
+	sortNewestFirst(files)
+
+	if err := writeIndex(ctx, remoteDest, files); err != nil {
+		return dropIndex(ctx, remoteDest, err)
+	}
+	return nil
+}
+
+// dropIndex deletes the destination's backup index after err kept it from being updated,
+// returning err
+func dropIndex(ctx context.Context, remoteDest string, err error) error {
+	if delErr := Delete(ctx, remoteDest, IndexFile); delErr != nil && !IsNotFound(delErr) {
+		return fmt.Errorf("%w (and the outdated index could not be deleted: %v)", err, delErr)
+	}
+	return err
+}
+
+func writeIndex(ctx context.Context, remoteDest string, files []RemoteFile) error {
+	index := backupIndex{Updated: time.Now().UTC(), Files: make([]indexEntry, len(files))}
+	for i, f := range files {
+		index.Files[i] = indexEntry{Name: f.Name, Size: f.Size, ModTime: f.ModTime}
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("encoding backup index: %w", err)
+	}
+	return UploadBytes(ctx, data, remoteDest, IndexFile)
+}
+
+// readIndex returns the backups recorded in the destination's index, newest first
+func readIndex(ctx context.Context, remoteDest string) ([]RemoteFile, error) {
+	rc, err := Open(ctx, remoteDest, IndexFile)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("reading backup index: %w", err)
+	}
+	var index backupIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("decoding backup index: %w", err)
+	}
+	files := make([]RemoteFile, len(index.Files))
+	for i, e := range index.Files {
+		files[i] = RemoteFile{Name: e.Name, Size: e.Size, ModTime: e.ModTime}
+	}
+	return files, nil
+}
+
+// ListIndexed lists the files at the remote destination from its backup index, so a
+// destination with many backups is read in one request. See listIndexed for when it
+// falls back to List.
+func ListIndexed(ctx context.Context, remoteDest string) ([]RemoteFile, error) {
+	return listIndexed(ctx, remoteDest, func(files []RemoteFile) []RemoteFile { return files })
+}
+
+// ListForDatabaseIndexed is ListForDatabase reading the destination's backup index
+func ListForDatabaseIndexed(ctx context.Context, remoteDest, dbName string) ([]RemoteFile, error) {
+	return listIndexed(ctx, remoteDest, func(files []RemoteFile) []RemoteFile {
+		return filterForDatabase(files, dbName)
+	})
+}
+
+// listIndexed returns the indexed files kept by filter. It falls back to List when the
+// index is missing or unreadable, when it records none of these files, and when it is
+// stale: the newest or the oldest backup it records is gone or has another size, so
+// something changed the destination without updating it (a backup deleted by hand, or
+// retention run elsewhere). Checking both takes two lookups, whatever the number of
+// backups. An index that was read is then rewritten from the listing.
+func listIndexed(ctx context.Context, remoteDest string, filter func([]RemoteFile) []RemoteFile) ([]RemoteFile, error) {
+	indexed, err := readIndex(ctx, remoteDest)
+	if files := filter(indexed); err == nil && len(files) > 0 {
+		if indexCurrent(ctx, remoteDest, files[0]) && indexCurrent(ctx, remoteDest, files[len(files)-1]) {
+			return files, nil
+		}
+	}
+
+	files, listErr := List(ctx, remoteDest)
+	if listErr != nil {
+		return nil, listErr
+	}
+	if err == nil {
+		// Best effort: the listing is still right if the index can't be rewritten
+		_ = writeIndex(ctx, remoteDest, files)
+	}
+	return filter(files), nil
+}
+
+// indexCurrent reports whether the indexed backup f is at the destination as recorded
+func indexCurrent(ctx context.Context, remoteDest string, f RemoteFile) bool {
+	current, err := Stat(ctx, remoteDest, f.Name)
+	return err == nil && current.Size == f.Size
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestListIndexed(t *testing.T) {
+	ctx := context.Background()
+	dest := t.TempDir()
+	write := func(name string, day int) {
+		t.Helper()
+		path := filepath.Join(dest, name)
+		if err := os.WriteFile(path, []byte("backup"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func(files []RemoteFile) []string {
+		var out []string
+		for _, f := range files {
+			out = append(out, f.Name)
+		}
+		return out
+	}
+
+	write("app_20240101_000000.db", 1)
+	write("app_20240102_000000.db", 2)
+	write("other_20240102_000000.db", 2)
+
+	// Without an index the destination is listed
+	files, err := ListForDatabaseIndexed(ctx, dest, "app")
+	if err != nil || len(files) != 2 {
+		t.Fatalf("ListForDatabaseIndexed() without index = %v, %v; want the 2 backups", names(files), err)
+	}
+
+	if err := RefreshIndex(ctx, dest); err != nil {
+		t.Fatalf("RefreshIndex() error = %v", err)
+	}
+	listed, _ := List(ctx, dest)
+	if len(listed) != 3 {
+		t.Errorf("List() = %v, want the index left out", names(listed))
+	}
+
+	// A backup the index doesn't know about yet isn't seen: the index is read, not a listing
+	write("app_20231231_000000.db", 0)
+	files, _ = ListForDatabaseIndexed(ctx, dest, "app")
+	if len(files) != 2 || files[0].Name != "app_20240102_000000.db" {
+		t.Errorf("ListForDatabaseIndexed() = %v, want the 2 indexed backups, newest first", names(files))
+	}
+
+	// The newest indexed backup is gone, so the index is stale and rebuilt
+	if err := os.Remove(filepath.Join(dest, "app_20240102_000000.db")); err != nil {
+		t.Fatal(err)
+	}
+	files, _ = ListForDatabaseIndexed(ctx, dest, "app")
+	if len(files) != 2 || files[0].Name != "app_20240101_000000.db" || files[1].Name != "app_20231231_000000.db" {
+		t.Errorf("ListForDatabaseIndexed() with a stale index = %v, want a fresh listing", names(files))
+	}
+	indexed, err := readIndex(ctx, dest)
+	if err != nil || len(indexed) != 3 {
+		t.Errorf("index after the fallback = %v, %v; want it rewritten from the listing", names(indexed), err)
+	}
+
+	// The oldest indexed backup is gone too (retention run elsewhere, say)
+	if err := RefreshIndex(ctx, dest); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dest, "app_20231231_000000.db")); err != nil {
+		t.Fatal(err)
+	}
+	files, _ = ListForDatabaseIndexed(ctx, dest, "app")
+	if len(files) != 1 || files[0].Name != "app_20240101_000000.db" {
+		t.Errorf("ListForDatabaseIndexed() with the oldest backup gone = %v, want a fresh listing", names(files))
+	}
+
+	// An unreadable index falls back to a listing
+	if err := os.WriteFile(filepath.Join(dest, IndexFile), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if files, err := ListIndexed(ctx, dest); err != nil || len(files) != 2 {
+		t.Errorf("ListIndexed() with a corrupt index = %v, %v; want the listing", names(files), err)
+	}
+}
+
+func TestUpdateIndex(t *testing.T) {
+	ctx := context.Background()
+	dest := t.TempDir()
+	write := func(name string, day int) {
+		t.Helper()
+		path := filepath.Join(dest, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("backup"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	indexed := func() []string {
+		t.Helper()
+		files, err := readIndex(ctx, dest)
+		if err != nil {
+			t.Fatalf("readIndex() error = %v", err)
+		}
+		var out []string
+		for _, f := range files {
+			out = append(out, f.Name)
+		}
+		return out
+	}
+
+	// Without an index, the update builds it from a listing
+	write("app_20240101_000000.db", 1)
+	write("app_20240102_000000.db", 2)
+	if err := UpdateIndex(ctx, dest, IndexChange{Written: []string{"app_20240102_000000.db"}}); err != nil {
+		t.Fatalf("UpdateIndex() error = %v", err)
+	}
+	if got := indexed(); !slices.Equal(got, []string{"app_20240102_000000.db", "app_20240101_000000.db"}) {
+		t.Errorf("index = %v, want both backups", got)
+	}
+
+	// Only the change is applied: a backup written by other means stays out
+	write("2024/01/app_20240103_000000.db", 3)
+	write("app_20231231_000000.db", 0)
+	if err := os.Remove(filepath.Join(dest, "app_20240101_000000.db")); err != nil {
+		t.Fatal(err)
+	}
+	change := IndexChange{Written: []string{"2024/01/app_20240103_000000.db"}, Deleted: []string{"app_20240101_000000.db"}}
+	if err := UpdateIndex(ctx, dest, change); err != nil {
+		t.Fatalf("UpdateIndex() error = %v", err)
+	}
+	if got := indexed(); !slices.Equal(got, []string{"2024/01/app_20240103_000000.db", "app_20240102_000000.db"}) {
+		t.Errorf("index = %v, want the written backup added and the deleted one removed", got)
+	}
+
+	// A written backup that can't be looked up deletes the index
+	if err := UpdateIndex(ctx, dest, IndexChange{Written: []string{"app_20240104_000000.db"}}); err == nil {
+		t.Error("UpdateIndex() with a missing backup succeeded, want an error")
+	}
+	if _, err := os.Stat(filepath.Join(dest, IndexFile)); !os.IsNotExist(err) {
+		t.Errorf("index left after a failed update: %v", err)
+	}
+}
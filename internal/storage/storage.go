@@ -1,15 +1,21 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/rclone/rclone/backend/all"
@@ -17,6 +23,9 @@ import (
 	"github.com/rclone/rclone/fs/accounting"
 	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/config/configfile"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/hash"
+	rclonelog "github.com/rclone/rclone/fs/log"
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/fs/walk"
 )
@@ -28,15 +37,62 @@ type RemoteFile struct {
 	ModTime time.Time
 }
 
+// SizeUnknown is the BytesTotal of a transfer whose size isn't known upfront, such as
+// a streamed dump or an object whose backend doesn't report its size
+const SizeUnknown = -1
+
 // TransferProgress represents the progress of a file transfer
 type TransferProgress struct {
 	BytesDone  int64   // bytes transferred so far
-	BytesTotal int64   // total bytes to transfer
+	BytesTotal int64   // total bytes to transfer, or SizeUnknown
 	Speed      float64 // transfer speed in bytes/second
 	Done       bool    // true when transfer is complete
 	Error      error   // error if transfer failed
+	Attempt    int     // download attempt in progress, from 2 once a transient failure is retried
+	Attempts   int     // how many attempts the download may take, set along with Attempt
+	Retrying   error   // set on the update starting a retry: why the previous attempt failed
+}
+
+// TotalKnown reports whether the size of the transfer is known, so that a percentage
+// and an ETA can be shown
+func (p TransferProgress) TotalKnown() bool {
+	return p.BytesTotal >= 0
 }
 
+// Fraction returns the completed share of the transfer in [0, 1], or 0 if the total is unknown
+func (p TransferProgress) Fraction() float64 {
+	if p.BytesTotal <= 0 {
+		return 0
+	}
+	f := float64(p.BytesDone) / float64(p.BytesTotal)
+	if f > 1 {
+		return 1
+	}
+	return f
+}
+
+// ETA estimates the time remaining at the current speed, or 0 if it cannot be estimated
+func (p TransferProgress) ETA() time.Duration {
+	if p.Speed <= 0 || p.BytesTotal <= p.BytesDone {
+		return 0
+	}
+	seconds := float64(p.BytesTotal-p.BytesDone) / p.Speed
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second)
+}
+
+// ReportsDir is the directory under a destination where run reports are written.
+// Files inside it are never returned by List, so restore and retention ignore them.
+const ReportsDir = "reports"
+
+// PartExt is appended to the name of a backup while it is uploaded atomically. Such
+// files are partial uploads and are never treated as backups.
+const PartExt = ".part"
+
+// ChecksumExt is appended to a backup file name to form its checksum sidecar.
+// Sidecars are never returned by List and are removed along with their backup.
+// PartExt and ChecksumExt are also listed in config.SidecarExts.
+const ChecksumExt = ".sha256"
+
 var initOnce sync.Once
 
 // Init initializes the rclone storage backend.
@@ -71,8 +127,20 @@ func Init(configPath string) {
 	})
 }
 
-// Upload uploads a local file to the remote destination
-func Upload(ctx context.Context, localPath, remoteDest string) error {
+// SetDiagnosticsLog sends rclone's logs, from info level up, to w instead of discarding
+// them. Call it once, after Init.
+func SetDiagnosticsLog(w io.Writer) {
+	ci := fs.GetConfig(context.Background())
+	ci.LogLevel = fs.LogLevelInfo
+	rclonelog.Handler.SetLevel(slog.LevelInfo)
+	rclonelog.Handler.SetOutput(func(_ slog.Level, text string) {
+		io.WriteString(w, text)
+	})
+}
+
+// Upload uploads a local file to the remote destination. With atomic set, the file only
+// appears under its name once complete (see copyObject).
+func Upload(ctx context.Context, localPath, remoteDest string, atomic bool) error {
 	// Create fs for local directory containing the file
 	localDir := filepath.Dir(localPath)
 	fileName := filepath.Base(localPath)
@@ -82,7 +150,7 @@ func Upload(ctx context.Context, localPath, remoteDest string) error {
 		return fmt.Errorf("parsing local path: %w", err)
 	}
 
-	fdst, err := fs.NewFs(ctx, remoteDest)
+	fdst, err := openFs(ctx, remoteDest)
 	if err != nil {
 		return fmt.Errorf("parsing remote destination: %w", err)
 	}
@@ -94,7 +162,41 @@ func Upload(ctx context.Context, localPath, remoteDest string) error {
 	}
 
 	// Copy the file
-	_, err = operations.Copy(ctx, fdst, nil, srcObj.Remote(), srcObj)
+	if err := copyObject(ctx, fdst, srcObj, atomic); err != nil {
+		return fmt.Errorf("uploading file: %w", err)
+	}
+
+	return nil
+}
+
+// copyObject copies srcObj into fdst under the same name. With atomic set on a backend
+// that renames server-side, it is written as name+PartExt and renamed once complete, so
+// a concurrent listing never sees a partial backup. Other backends are written directly.
+func copyObject(ctx context.Context, fdst fs.Fs, srcObj fs.Object, atomic bool) error {
+	name := srcObj.Remote()
+	if !atomic || fdst.Features().Move == nil {
+		_, err := operations.Copy(ctx, fdst, nil, name, srcObj)
+		return err
+	}
+
+	partObj, err := operations.Copy(ctx, fdst, nil, name+PartExt, srcObj)
+	if err != nil {
+		return err
+	}
+	if _, err := operations.Move(ctx, fdst, nil, name, partObj); err != nil {
+		return fmt.Errorf("renaming %s: %w", name+PartExt, err)
+	}
+	return nil
+}
+
+// UploadBytes writes data as fileName at the remote destination
+func UploadBytes(ctx context.Context, data []byte, remoteDest, fileName string) error {
+	fdst, err := openFs(ctx, remoteDest)
+	if err != nil {
+		return fmt.Errorf("parsing remote destination: %w", err)
+	}
+
+	_, err = operations.Rcat(ctx, fdst, fileName, io.NopCloser(bytes.NewReader(data)), time.Now(), nil)
 	if err != nil {
 		return fmt.Errorf("uploading file: %w", err)
 	}
@@ -102,10 +204,51 @@ func Upload(ctx context.Context, localPath, remoteDest string) error {
 	return nil
 }
 
+// UploadChecksum writes the SHA-256 sidecar for fileName at the remote destination,
+// in the format used by sha256sum so it can be checked without blobber
+func UploadChecksum(ctx context.Context, remoteDest, fileName, sum string) error {
+	line := fmt.Sprintf("%s  %s\n", sum, fileName)
+	return UploadBytes(ctx, []byte(line), remoteDest, fileName+ChecksumExt)
+}
+
+// ReadChecksum returns the SHA-256 recorded in the sidecar for fileName, or "" if the
+// backup has no sidecar (e.g. it was written by an older version)
+func ReadChecksum(ctx context.Context, remoteDest, fileName string) (string, error) {
+	fsrc, err := openFs(ctx, remoteDest)
+	if err != nil {
+		return "", fmt.Errorf("parsing remote destination: %w", err)
+	}
+
+	obj, err := fsrc.NewObject(ctx, fileName+ChecksumExt)
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("getting checksum: %w", err)
+	}
+
+	rc, err := obj.Open(ctx)
+	if err != nil {
+		return "", fmt.Errorf("opening checksum: %w", err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return "", fmt.Errorf("reading checksum: %w", err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("checksum file for %s is empty", fileName)
+	}
+	return fields[0], nil
+}
+
 // UploadWithProgress uploads a file and reports progress via the provided channel.
 // Progress updates are sent periodically until the upload completes.
 // The channel is closed when the upload finishes (successfully or with error).
-func UploadWithProgress(ctx context.Context, localPath, remoteDest string, fileSize int64, progressCh chan<- TransferProgress) {
+// With atomic set, the file only appears under its name once complete (see copyObject).
+func UploadWithProgress(ctx context.Context, localPath, remoteDest string, fileSize int64, atomic bool, progressCh chan<- TransferProgress) {
 	defer close(progressCh)
 
 	// Reset stats before starting
@@ -122,7 +265,7 @@ func UploadWithProgress(ctx context.Context, localPath, remoteDest string, fileS
 		return
 	}
 
-	fdst, err := fs.NewFs(ctx, remoteDest)
+	fdst, err := openFs(ctx, remoteDest)
 	if err != nil {
 		progressCh <- TransferProgress{Error: fmt.Errorf("parsing remote destination: %w", err), Done: true}
 		return
@@ -177,7 +320,7 @@ func UploadWithProgress(ctx context.Context, localPath, remoteDest string, fileS
 	}()
 
 	// Perform the upload
-	_, err = operations.Copy(ctx, fdst, nil, srcObj.Remote(), srcObj)
+	err = copyObject(ctx, fdst, srcObj, atomic)
 	close(done)
 
 	if err != nil {
@@ -201,7 +344,7 @@ func UploadWithProgress(ctx context.Context, localPath, remoteDest string, fileS
 
 // List lists files at the remote destination
 func List(ctx context.Context, remoteDest string) ([]RemoteFile, error) {
-	fdst, err := fs.NewFs(ctx, remoteDest)
+	fdst, err := openFs(ctx, remoteDest)
 	if err != nil {
 		return nil, fmt.Errorf("parsing remote destination: %w", err)
 	}
@@ -210,6 +353,9 @@ func List(ctx context.Context, remoteDest string) ([]RemoteFile, error) {
 	err = walk.ListR(ctx, fdst, "", false, -1, walk.ListObjects, func(entries fs.DirEntries) error {
 		for _, entry := range entries {
 			if obj, ok := entry.(fs.Object); ok {
+				if strings.HasPrefix(obj.Remote(), ReportsDir+"/") || strings.HasSuffix(obj.Remote(), ChecksumExt) || obj.Remote() == IndexFile {
+					continue
+				}
 				files = append(files, RemoteFile{
 					Name:    obj.Remote(),
 					Size:    obj.Size(),
@@ -223,35 +369,132 @@ func List(ctx context.Context, remoteDest string) ([]RemoteFile, error) {
 		return nil, fmt.Errorf("listing files: %w", err)
 	}
 
-	// Sort by modification time, newest first
+	sortNewestFirst(files)
+	return files, nil
+}
+
+// sortNewestFirst sorts files by modification time, newest first, then by name so files
+// modified at the same time are always listed in the same order
+func sortNewestFirst(files []RemoteFile) {
 	sort.Slice(files, func(i, j int) bool {
-		return files[i].ModTime.After(files[j].ModTime)
+		if !files[i].ModTime.Equal(files[j].ModTime) {
+			return files[i].ModTime.After(files[j].ModTime)
+		}
+		return files[i].Name > files[j].Name
 	})
-
-	return files, nil
 }
 
 // ListForDatabase lists files at the remote destination filtered by database name.
-// Only files with the prefix "{dbName}_" are returned.
+// Only files named "{dbName}_{YYYYMMDD_HHMMSS}.{ext}" are returned, so a database never
+// picks up the backups of another whose name it prefixes (e.g. "db" and "db_extra").
 func ListForDatabase(ctx context.Context, remoteDest, dbName string) ([]RemoteFile, error) {
 	files, err := List(ctx, remoteDest)
 	if err != nil {
 		return nil, err
 	}
+	return filterForDatabase(files, dbName), nil
+}
+
+// backupSuffixPattern matches what follows "{dbName}_" in a backup filename
+var backupSuffixPattern = regexp.MustCompile(`^\d{8}_\d{6}\.`)
 
+// datedFolderPattern matches the folders group_by puts backups in (YYYY-MM-DD or YYYY-MM)
+var datedFolderPattern = regexp.MustCompile(`^\d{4}-\d{2}(-\d{2})?$`)
+
+// filterForDatabase returns the files that are backups of dbName, either directly in
+// the destination or in one of its dated folders
+func filterForDatabase(files []RemoteFile, dbName string) []RemoteFile {
 	prefix := dbName + "_"
 	var filtered []RemoteFile
 	for _, f := range files {
-		if strings.HasPrefix(f.Name, prefix) {
+		dir, base := path.Split(f.Name)
+		if dir != "" && !datedFolderPattern.MatchString(strings.TrimSuffix(dir, "/")) {
+			continue
+		}
+		if strings.HasPrefix(base, prefix) && backupSuffixPattern.MatchString(base[len(prefix):]) && !strings.HasSuffix(base, PartExt) {
 			filtered = append(filtered, f)
 		}
 	}
-	return filtered, nil
+	return filtered
+}
+
+// JoinDest returns the rclone path of dir inside dest, which may be a local path, a
+// remote root ("s3:") or a remote path ("s3:bucket/path")
+func JoinDest(dest, dir string) string {
+	if dir == "" {
+		return dest
+	}
+	if strings.HasSuffix(dest, ":") {
+		return dest + dir
+	}
+	return strings.TrimSuffix(dest, "/") + "/" + dir
+}
+
+// DefaultDownloadAttempts is how many times a download failing with a transient error
+// is tried when the config doesn't say
+const DefaultDownloadAttempts = 3
+
+// DownloadOptions tune downloads, from the config's download_streams and
+// download_attempts
+type DownloadOptions struct {
+	Streams  int // parallel streams for a file large enough for rclone's multi-thread copy; 0 keeps rclone's default
+	Attempts int // tries of a download failing with a transient error; 0 keeps DefaultDownloadAttempts
+}
+
+// downloadRetryDelay is the pause before the first retry of a download, doubled before
+// each next one (a variable so tests can shorten it)
+var downloadRetryDelay = 2 * time.Second
+
+// MaxAttempts returns how many times a download failing with a transient error is tried
+func (o DownloadOptions) MaxAttempts() int {
+	if o.Attempts <= 0 {
+		return DefaultDownloadAttempts
+	}
+	return o.Attempts
+}
+
+// downloadWithRetry runs download until it succeeds, fails with an error that isn't
+// transient, or has been tried attempts times, backing off between attempts.
+// A failed attempt's file at localFile is removed, so the next one starts cleanly:
+// rclone doesn't resume downloads. retrying is called before each retry.
+func downloadWithRetry(ctx context.Context, attempts int, localFile string, download func(attempt int) error, retrying func(attempt int, err error)) error {
+	delay := downloadRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := download(attempt)
+		if err == nil || !IsTransient(err) || attempt >= attempts || ctx.Err() != nil {
+			return err
+		}
+		os.Remove(localFile)
+		if retrying != nil {
+			retrying(attempt+1, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// downloadContext returns ctx with the number of download streams of opts. Files below
+// rclone's multi_thread_cutoff (256 MiB) are still downloaded in one stream.
+func downloadContext(ctx context.Context, opts DownloadOptions) context.Context {
+	if opts.Streams <= 0 {
+		return ctx
+	}
+	ctx, ci := fs.AddConfig(ctx)
+	ci.MultiThreadStreams = opts.Streams
+	// Marks the count as chosen, so it also applies between two local paths
+	ci.MultiThreadSet = true
+	return ctx
 }
 
 // Download downloads a file from remote storage to local path
-func Download(ctx context.Context, remoteDest, fileName, localPath string) error {
-	fsrc, err := fs.NewFs(ctx, remoteDest)
+func Download(ctx context.Context, remoteDest, fileName, localPath string, opts DownloadOptions) error {
+	ctx = downloadContext(ctx, opts)
+
+	fsrc, err := openFs(ctx, remoteDest)
 	if err != nil {
 		return fmt.Errorf("parsing remote destination: %w", err)
 	}
@@ -267,8 +510,11 @@ func Download(ctx context.Context, remoteDest, fileName, localPath string) error
 		return fmt.Errorf("getting remote object: %w", err)
 	}
 
-	// Copy the file
-	_, err = operations.Copy(ctx, fdst, nil, srcObj.Remote(), srcObj)
+	// Copy the file, retrying transient failures
+	err = downloadWithRetry(ctx, opts.MaxAttempts(), filepath.Join(localPath, srcObj.Remote()), func(int) error {
+		_, err := operations.Copy(ctx, fdst, nil, srcObj.Remote(), srcObj)
+		return err
+	}, nil)
 	if err != nil {
 		return fmt.Errorf("downloading file: %w", err)
 	}
@@ -276,17 +522,110 @@ func Download(ctx context.Context, remoteDest, fileName, localPath string) error
 	return nil
 }
 
+// FileSize returns the size in bytes of a file in remote storage
+func FileSize(ctx context.Context, remoteDest, fileName string) (int64, error) {
+	f, err := Stat(ctx, remoteDest, fileName)
+	return f.Size, err
+}
+
+// Stat returns the size and modification time of a file in remote storage
+func Stat(ctx context.Context, remoteDest, fileName string) (RemoteFile, error) {
+	fsrc, err := openFs(ctx, remoteDest)
+	if err != nil {
+		return RemoteFile{}, fmt.Errorf("parsing remote destination: %w", err)
+	}
+	obj, err := fsrc.NewObject(ctx, fileName)
+	if err != nil {
+		return RemoteFile{}, fmt.Errorf("getting remote object: %w", err)
+	}
+	return RemoteFile{Name: fileName, Size: obj.Size(), ModTime: obj.ModTime(ctx)}, nil
+}
+
+// SHA256 returns the hex-encoded SHA-256 of a file as computed by the remote, or "" if
+// the backend doesn't support SHA-256
+func SHA256(ctx context.Context, remoteDest, fileName string) (string, error) {
+	fsrc, err := openFs(ctx, remoteDest)
+	if err != nil {
+		return "", fmt.Errorf("parsing remote destination: %w", err)
+	}
+	if !fsrc.Hashes().Contains(hash.SHA256) {
+		return "", nil
+	}
+	obj, err := fsrc.NewObject(ctx, fileName)
+	if err != nil {
+		return "", fmt.Errorf("getting remote object: %w", err)
+	}
+	sum, err := obj.Hash(ctx, hash.SHA256)
+	if errors.Is(err, hash.ErrUnsupported) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("hashing remote object: %w", err)
+	}
+	return sum, nil
+}
+
+// Open opens a file in remote storage for reading
+func Open(ctx context.Context, remoteDest, fileName string) (io.ReadCloser, error) {
+	fsrc, err := openFs(ctx, remoteDest)
+	if err != nil {
+		return nil, fmt.Errorf("parsing remote destination: %w", err)
+	}
+	obj, err := fsrc.NewObject(ctx, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("getting remote object: %w", err)
+	}
+	rc, err := obj.Open(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("opening remote object: %w", err)
+	}
+	return rc, nil
+}
+
+// Exists reports whether fileName is present at the remote destination
+func Exists(ctx context.Context, remoteDest, fileName string) (bool, error) {
+	_, err := FileSize(ctx, remoteDest, fileName)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SplitRemoteFile splits a path to a single file ("s3:bucket/dir/file.sql.gz", "/tmp/file.sql")
+// into the directory to open and the file name within it
+func SplitRemoteFile(remotePath string) (string, string, error) {
+	remotePath = strings.TrimSpace(remotePath)
+	if remotePath == "" || strings.HasSuffix(remotePath, "/") || strings.HasSuffix(remotePath, ":") {
+		return "", "", fmt.Errorf("%q does not name a file", remotePath)
+	}
+
+	if i := strings.LastIndex(remotePath, "/"); i >= 0 {
+		dir := remotePath[:i]
+		if dir == "" || strings.HasSuffix(dir, ":") {
+			dir += "/"
+		}
+		return dir, remotePath[i+1:], nil
+	}
+	if i := strings.LastIndex(remotePath, ":"); i >= 0 {
+		return remotePath[:i+1], remotePath[i+1:], nil
+	}
+	return ".", remotePath, nil
+}
+
 // DownloadWithProgress downloads a file and reports progress via the provided channel.
-// Progress updates are sent periodically until the download completes.
+// Progress updates are sent periodically until the download completes. Large files are
+// downloaded over parallel streams (see DownloadOptions); progress and speed count the
+// bytes of all streams together.
 // The channel is closed when the download finishes (successfully or with error).
-func DownloadWithProgress(ctx context.Context, remoteDest, fileName, localPath string, fileSize int64, progressCh chan<- TransferProgress) {
+func DownloadWithProgress(ctx context.Context, remoteDest, fileName, localPath string, fileSize int64, opts DownloadOptions, progressCh chan<- TransferProgress) {
 	defer close(progressCh)
+	ctx = downloadContext(ctx, opts)
 
 	// Reset stats before starting
 	stats := accounting.GlobalStats()
 	stats.ResetCounters()
 
-	fsrc, err := fs.NewFs(ctx, remoteDest)
+	fsrc, err := openFs(ctx, remoteDest)
 	if err != nil {
 		progressCh <- TransferProgress{Error: fmt.Errorf("parsing remote destination: %w", err), Done: true}
 		return
@@ -305,50 +644,17 @@ func DownloadWithProgress(ctx context.Context, remoteDest, fileName, localPath s
 		return
 	}
 
-	// Start progress monitoring in a goroutine
-	done := make(chan struct{})
-	go func() {
-		ticker := time.NewTicker(100 * time.Millisecond)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-done:
-				return
-			case <-ticker.C:
-				rs, err := stats.RemoteStats(false)
-				if err != nil {
-					continue
-				}
-
-				var bytesDone int64
-				var speed float64
-
-				// Get bytes from stats
-				if b, ok := rs["bytes"].(int64); ok {
-					bytesDone = b
-				}
-				if s, ok := rs["speed"].(float64); ok {
-					speed = s
-				}
-
-				// Send progress update
-				select {
-				case progressCh <- TransferProgress{
-					BytesDone:  bytesDone,
-					BytesTotal: fileSize,
-					Speed:      speed,
-				}:
-				default:
-					// Skip if channel is full
-				}
-			}
-		}
-	}()
-
-	// Perform the download
-	_, err = operations.Copy(ctx, fdst, nil, srcObj.Remote(), srcObj)
-	close(done)
+	// Perform the download, retrying transient failures from a clean file and progress
+	err = downloadWithRetry(ctx, opts.MaxAttempts(), filepath.Join(localPath, srcObj.Remote()), func(attempt int) error {
+		done := make(chan struct{})
+		defer close(done)
+		go reportDownloadProgress(stats, fileSize, attempt, progressCh, done)
+		_, err := operations.Copy(ctx, fdst, nil, srcObj.Remote(), srcObj)
+		return err
+	}, func(attempt int, err error) {
+		stats.ResetCounters()
+		progressCh <- TransferProgress{BytesTotal: fileSize, Attempt: attempt, Attempts: opts.MaxAttempts(), Retrying: err}
+	})
 
 	if err != nil {
 		progressCh <- TransferProgress{
@@ -369,9 +675,118 @@ func DownloadWithProgress(ctx context.Context, remoteDest, fileName, localPath s
 	}
 }
 
+// reportDownloadProgress sends the progress of a download attempt to progressCh every
+// 100ms until done is closed, skipping updates the channel has no room for
+func reportDownloadProgress(stats *accounting.StatsInfo, fileSize int64, attempt int, progressCh chan<- TransferProgress, done <-chan struct{}) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			rs, err := stats.RemoteStats(false)
+			if err != nil {
+				continue
+			}
+
+			var bytesDone int64
+			var speed float64
+
+			// Get bytes from stats
+			if b, ok := rs["bytes"].(int64); ok {
+				bytesDone = b
+			}
+			if s, ok := rs["speed"].(float64); ok {
+				speed = s
+			}
+
+			// Send progress update
+			select {
+			case progressCh <- TransferProgress{
+				BytesDone:  bytesDone,
+				BytesTotal: fileSize,
+				Speed:      speed,
+				Attempt:    attempt,
+			}:
+			default:
+				// Skip if channel is full
+			}
+		}
+	}
+}
+
+// StreamWithProgress opens a file in remote storage and hands it to consume as it is
+// downloaded, reporting the bytes read so far via progressCh every 100ms. The final update
+// carries the error of consume, or of opening the file. Unlike DownloadWithProgress
+// nothing is written locally and a failed transfer is not retried, as consume has
+// already read part of it. The channel is closed once consume returns.
+func StreamWithProgress(ctx context.Context, remoteDest, fileName string, fileSize int64, consume func(io.Reader) error, progressCh chan<- TransferProgress) {
+	defer close(progressCh)
+
+	rc, err := Open(ctx, remoteDest, fileName)
+	if err != nil {
+		progressCh <- TransferProgress{BytesTotal: fileSize, Error: err, Done: true}
+		return
+	}
+	defer rc.Close()
+
+	src := &countingReader{r: rc}
+	done := make(chan struct{})
+	go reportStreamProgress(src, fileSize, progressCh, done)
+	err = consume(src)
+	close(done)
+
+	progressCh <- TransferProgress{
+		BytesDone:  src.n.Load(),
+		BytesTotal: fileSize,
+		Error:      err,
+		Done:       true,
+	}
+}
+
+// countingReader counts the bytes read from r, for another goroutine to report
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// reportStreamProgress sends the bytes read from src to progressCh every 100ms until done
+// is closed, skipping updates the channel has no room for
+func reportStreamProgress(src *countingReader, fileSize int64, progressCh chan<- TransferProgress, done <-chan struct{}) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	start := time.Now()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			bytesDone := src.n.Load()
+			select {
+			case progressCh <- TransferProgress{
+				BytesDone:  bytesDone,
+				BytesTotal: fileSize,
+				Speed:      float64(bytesDone) / time.Since(start).Seconds(),
+			}:
+			default:
+				// Skip if channel is full
+			}
+		}
+	}
+}
+
 // Delete deletes a file from remote storage
 func Delete(ctx context.Context, remoteDest, fileName string) error {
-	fdst, err := fs.NewFs(ctx, remoteDest)
+	fdst, err := openFs(ctx, remoteDest)
 	if err != nil {
 		return fmt.Errorf("parsing remote destination: %w", err)
 	}
@@ -385,12 +800,60 @@ func Delete(ctx context.Context, remoteDest, fileName string) error {
 		return fmt.Errorf("deleting file: %w", err)
 	}
 
+	// Remove the checksum sidecar too, if there is one
+	if sidecar, err := fdst.NewObject(ctx, fileName+ChecksumExt); err == nil {
+		_ = sidecar.Remove(ctx)
+	}
+
+	// Remove a dated folder once its last backup is gone; Rmdir fails if it isn't empty
+	if dir := path.Dir(fileName); datedFolderPattern.MatchString(dir) {
+		_ = fdst.Rmdir(ctx, dir)
+	}
+
+	return nil
+}
+
+// Transfer copies srcName at srcDest to dstName at dstDest, server-side when both are on
+// the same remote and it supports it. With move set, the source is removed once copied.
+func Transfer(ctx context.Context, srcDest, srcName, dstDest, dstName string, move bool) error {
+	fsrc, err := openFs(ctx, srcDest)
+	if err != nil {
+		return fmt.Errorf("parsing source: %w", err)
+	}
+	fdst, err := openFs(ctx, dstDest)
+	if err != nil {
+		return fmt.Errorf("parsing remote destination: %w", err)
+	}
+
+	srcObj, err := fsrc.NewObject(ctx, srcName)
+	if err != nil {
+		return fmt.Errorf("getting object: %w", err)
+	}
+	if move {
+		_, err = operations.Move(ctx, fdst, nil, dstName, srcObj)
+	} else {
+		_, err = operations.Copy(ctx, fdst, nil, dstName, srcObj)
+	}
+	if err != nil {
+		return fmt.Errorf("copying file: %w", err)
+	}
 	return nil
 }
 
+// IsNotFound reports whether err means the remote file or directory does not exist
+func IsNotFound(err error) bool {
+	return errors.Is(err, fs.ErrorObjectNotFound) || errors.Is(err, fs.ErrorDirNotFound)
+}
+
+// IsTransient reports whether err looks temporary (timeouts, dropped connections,
+// throttling), so retrying the operation may succeed
+func IsTransient(err error) bool {
+	return fserrors.IsRetryError(err) || fserrors.ShouldRetry(err)
+}
+
 // TestAccess tests if the destination is accessible (can list files)
 func TestAccess(ctx context.Context, remoteDest string) error {
-	fdst, err := fs.NewFs(ctx, remoteDest)
+	fdst, err := openFs(ctx, remoteDest)
 	if err != nil {
 		return fmt.Errorf("invalid destination: %w", err)
 	}
@@ -403,3 +866,106 @@ func TestAccess(ctx context.Context, remoteDest string) error {
 
 	return nil
 }
+
+// ErrUsageUnsupported is returned by Usage for backends that can't report their space
+var ErrUsageUnsupported = errors.New("backend does not report space usage")
+
+// Space is the space of a remote as reported by its backend. Fields the backend
+// doesn't report are -1.
+type Space struct {
+	Total int64
+	Free  int64
+}
+
+// Usage returns the total and free space of the remote holding remoteDest
+func Usage(ctx context.Context, remoteDest string) (Space, error) {
+	fdst, err := openFs(ctx, remoteDest)
+	if err != nil {
+		return Space{}, fmt.Errorf("invalid destination: %w", err)
+	}
+
+	about := fdst.Features().About
+	if about == nil {
+		return Space{}, ErrUsageUnsupported
+	}
+	usage, err := about(ctx)
+	if err != nil {
+		return Space{}, fmt.Errorf("reading space usage: %w", err)
+	}
+
+	space := Space{Total: -1, Free: -1}
+	if usage.Total != nil {
+		space.Total = *usage.Total
+	}
+	if usage.Free != nil {
+		space.Free = *usage.Free
+	}
+	return space, nil
+}
+
+// ConfigPath returns the path of the rclone config file in use
+func ConfigPath() string {
+	return config.GetConfigPath()
+}
+
+// RemoteNames returns the names of the remotes in the rclone config
+func RemoteNames() []string {
+	return config.GetRemoteNames()
+}
+
+// RemoteCheck describes whether a configured rclone remote is usable as a backup destination
+type RemoteCheck struct {
+	Name   string // remote name as configured in rclone.conf
+	Type   string // backend type (s3, drive, ...)
+	Usable bool   // true if the type is recognized and the remote is accessible
+	Reason string // why the remote is not usable (empty if usable)
+}
+
+// CheckRemotes inspects every remote in the rclone config and tests access to its root.
+// Each remote is tested with the given timeout so one unreachable remote doesn't stall the others.
+// Results are sorted by remote name.
+func CheckRemotes(ctx context.Context, timeout time.Duration) []RemoteCheck {
+	names := config.GetRemoteNames()
+	sort.Strings(names)
+
+	checks := make([]RemoteCheck, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		remoteType, _ := config.FileGetValue(name, "type")
+		checks[i] = RemoteCheck{Name: name, Type: remoteType}
+
+		if _, err := fs.Find(remoteType); err != nil {
+			checks[i].Reason = fmt.Sprintf("unknown backend type %q", remoteType)
+			continue
+		}
+
+		wg.Add(1)
+		go func(c *RemoteCheck) {
+			defer wg.Done()
+			testCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			if err := TestAccess(testCtx, c.Name+":"); err != nil {
+				if testCtx.Err() == context.DeadlineExceeded {
+					c.Reason = fmt.Sprintf("timed out after %s", timeout)
+				} else {
+					c.Reason = err.Error()
+				}
+				return
+			}
+			c.Usable = true
+		}(&checks[i])
+	}
+	wg.Wait()
+
+	return checks
+}
+
+// RemoteExists reports whether a remote with the given name is configured in rclone
+func RemoteExists(name string) bool {
+	for _, n := range config.GetRemoteNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
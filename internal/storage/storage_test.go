@@ -0,0 +1,413 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/fserrors"
+)
+
+func TestOpenFs(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("same dest shares one fs", func(t *testing.T) {
+		dest := t.TempDir()
+		const callers = 8
+		got := make([]fs.Fs, callers)
+		var wg sync.WaitGroup
+		for i := range callers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				f, err := openFs(ctx, dest)
+				if err != nil {
+					t.Errorf("openFs() error = %v", err)
+				}
+				got[i] = f
+			}()
+		}
+		wg.Wait()
+		for i, f := range got {
+			if f == nil || f != got[0] {
+				t.Fatalf("caller %d got %p, want %p", i, f, got[0])
+			}
+		}
+	})
+
+	t.Run("different dests", func(t *testing.T) {
+		a, err := openFs(ctx, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := openFs(ctx, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a == b {
+			t.Error("different dests share an fs")
+		}
+	})
+
+	t.Run("remote edit gets a new fs", func(t *testing.T) {
+		data := config.LoadedData()
+		data.SetValue("fscachetest", "type", "local")
+		defer data.DeleteSection("fscachetest")
+		dest := "fscachetest:" + t.TempDir()
+
+		before, err := openFs(ctx, dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, _ := openFs(ctx, dest); again != before {
+			t.Error("unchanged remote got a new fs")
+		}
+		data.SetValue("fscachetest", "case_sensitive", "true")
+		after, err := openFs(ctx, dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after == before {
+			t.Error("edited remote reused the old fs")
+		}
+	})
+
+	t.Run("inline remote", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "db_20240115_143022.sql"), []byte("dump"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		RegisterInlineRemote("inline-fscachetest", "local", map[string]string{"case_sensitive": "true"})
+		if config.LoadedData().HasSection("inline-fscachetest") {
+			t.Error("inline remote was added to the rclone config")
+		}
+
+		f, err := openFs(ctx, "inline-fscachetest:"+dir)
+		if err != nil {
+			t.Fatalf("openFs() error = %v", err)
+		}
+		if f.Name() != "inline-fscachetest" {
+			t.Errorf("Name() = %q, want the inline remote", f.Name())
+		}
+		if _, err := f.NewObject(ctx, "db_20240115_143022.sql"); err != nil {
+			t.Errorf("NewObject() error = %v", err)
+		}
+	})
+
+	t.Run("errors are not cached", func(t *testing.T) {
+		dest := "fscache-no-such-remote:backups"
+		for range 2 {
+			if _, err := openFs(ctx, dest); err == nil {
+				t.Fatal("openFs() succeeded for an unknown remote")
+			}
+		}
+		fsCache.mu.Lock()
+		_, cached := fsCache.entries[fsCacheKey(dest)]
+		fsCache.mu.Unlock()
+		if cached {
+			t.Error("failed fs left in the cache")
+		}
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := openFs(canceled, t.TempDir()); !errors.Is(err, context.Canceled) {
+			t.Errorf("openFs() error = %v, want context.Canceled", err)
+		}
+	})
+}
+
+// TestCachedFsOperations runs the operations of a backup run against one destination,
+// which all share the cached fs, and checks each sees the effects of the previous ones
+func TestCachedFsOperations(t *testing.T) {
+	ctx := context.Background()
+	dest := t.TempDir()
+	local := filepath.Join(t.TempDir(), "mydb_20240115_143022.sql.gz")
+	if err := os.WriteFile(local, []byte("dump"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Upload(ctx, local, dest, true); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if err := UploadChecksum(ctx, dest, filepath.Base(local), "abc"); err != nil {
+		t.Fatalf("UploadChecksum() error = %v", err)
+	}
+	files, err := List(ctx, dest)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(files) != 1 || files[0].Name != filepath.Base(local) || files[0].Size != 4 {
+		t.Fatalf("List() = %+v, want the uploaded file", files)
+	}
+
+	restoreDir := t.TempDir()
+	if err := Download(ctx, dest, files[0].Name, restoreDir, DownloadOptions{}); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(restoreDir, files[0].Name)); string(data) != "dump" {
+		t.Errorf("downloaded %q, want %q", data, "dump")
+	}
+
+	if err := Delete(ctx, dest, files[0].Name); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if files, err := List(ctx, dest); err != nil || len(files) != 0 {
+		t.Errorf("List() after Delete = %+v, %v; want none", files, err)
+	}
+	if exists, _ := Exists(ctx, dest, files[0].Name+ChecksumExt); exists {
+		t.Error("checksum sidecar left after Delete")
+	}
+}
+
+func TestFilterForDatabase(t *testing.T) {
+	files := []RemoteFile{
+		{Name: "db_20240115_143022.sql.gz"},
+		{Name: "2024-01-14/db_20240114_143022.sql.gz"},
+		{Name: "2024-01/db_20240101_143022.sql.gz"},
+		{Name: "db_extra_20240115_143022.sql.gz"},
+		{Name: "archive/db_20231231_143022.sql.gz"},
+		{Name: "2024-01-15/db_20240115_150000.sql.gz" + PartExt},
+	}
+	var got []string
+	for _, f := range filterForDatabase(files, "db") {
+		got = append(got, f.Name)
+	}
+	want := []string{"db_20240115_143022.sql.gz", "2024-01-14/db_20240114_143022.sql.gz", "2024-01/db_20240101_143022.sql.gz"}
+	if !slices.Equal(got, want) {
+		t.Errorf("filterForDatabase() = %v, want %v", got, want)
+	}
+}
+
+func TestJoinDest(t *testing.T) {
+	tests := []struct {
+		dest, dir, want string
+	}{
+		{"/backups", "2024-01-15", "/backups/2024-01-15"},
+		{"/backups/", "2024-01-15", "/backups/2024-01-15"},
+		{"s3:", "2024-01", "s3:2024-01"},
+		{"s3:bucket/app", "2024-01", "s3:bucket/app/2024-01"},
+		{"s3:bucket/app", "", "s3:bucket/app"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := JoinDest(tt.dest, tt.dir); got != tt.want {
+				t.Errorf("JoinDest(%q, %q) = %q, want %q", tt.dest, tt.dir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitRemoteFile(t *testing.T) {
+	tests := []struct {
+		path, dir, file string
+		wantErr         bool
+	}{
+		{path: "s3:bucket/dir/file.sql.gz", dir: "s3:bucket/dir", file: "file.sql.gz"},
+		{path: "remote:file", dir: "remote:", file: "file"},
+		{path: "/tmp/file.sql", dir: "/tmp", file: "file.sql"},
+		{path: "/file", dir: "/", file: "file"},
+		{path: "file", dir: ".", file: "file"},
+		{path: "s3:bucket/dir/", wantErr: true},
+		{path: "remote:", wantErr: true},
+		{path: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			dir, file, err := SplitRemoteFile(tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("SplitRemoteFile(%q) = %q, %q; want an error", tt.path, dir, file)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SplitRemoteFile(%q) error = %v", tt.path, err)
+			}
+			if dir != tt.dir || file != tt.file {
+				t.Errorf("SplitRemoteFile(%q) = %q, %q; want %q, %q", tt.path, dir, file, tt.dir, tt.file)
+			}
+		})
+	}
+}
+
+func BenchmarkList(b *testing.B) {
+	ctx := context.Background()
+	dest := b.TempDir()
+	for _, name := range []string{"a_20240101_000000.sql.gz", "a_20240102_000000.sql.gz", "b_20240101_000000.db"} {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte("x"), 0o644); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.Run("new fs", func(b *testing.B) {
+		for b.Loop() {
+			f, err := fs.NewFs(ctx, dest)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := f.List(ctx, ""); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("cached fs", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := List(ctx, dest); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestDownloadStreams(t *testing.T) {
+	t.Run("default leaves rclone config", func(t *testing.T) {
+		ctx := context.Background()
+		if got := downloadContext(ctx, DownloadOptions{}); got != ctx {
+			t.Error("downloadContext() changed the context without download_streams")
+		}
+	})
+
+	t.Run("multi-thread download", func(t *testing.T) {
+		opts := DownloadOptions{Streams: 4}
+		ctx, ci := fs.AddConfig(context.Background())
+		ci.MultiThreadCutoff = 1 << 20 // multi-thread a small file
+		ci.MultiThreadChunkSize = 256 << 10
+		if got := fs.GetConfig(downloadContext(ctx, opts)); got.MultiThreadStreams != 4 || !got.MultiThreadSet {
+			t.Fatalf("streams = %d (set %v), want 4", got.MultiThreadStreams, got.MultiThreadSet)
+		}
+
+		dest := t.TempDir()
+		content := bytes.Repeat([]byte("0123456789abcdef"), 256<<10) // 4 MiB
+		if err := os.WriteFile(filepath.Join(dest, "big_20240115_143022.db"), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		restoreDir := t.TempDir()
+		progress := make(chan TransferProgress, 1000)
+		go DownloadWithProgress(ctx, dest, "big_20240115_143022.db", restoreDir, int64(len(content)), opts, progress)
+		var last TransferProgress
+		for p := range progress {
+			if p.BytesDone > p.BytesTotal {
+				t.Errorf("progress %d exceeds the total %d", p.BytesDone, p.BytesTotal)
+			}
+			last = p
+		}
+		if !last.Done || last.Error != nil || last.BytesDone != int64(len(content)) {
+			t.Fatalf("final progress = %+v, want done with every byte", last)
+		}
+		if got, _ := os.ReadFile(filepath.Join(restoreDir, "big_20240115_143022.db")); !bytes.Equal(got, content) {
+			t.Error("downloaded file differs from the original")
+		}
+	})
+}
+
+func TestDownloadWithRetry(t *testing.T) {
+	downloadRetryDelay = time.Millisecond
+	defer func() { downloadRetryDelay = 2 * time.Second }()
+
+	transient := fserrors.RetryErrorf("connection reset by peer")
+	tests := []struct {
+		name      string
+		attempts  int
+		errs      []error // returned by each attempt, then success
+		wantErr   bool
+		wantTries int
+	}{
+		{"succeeds first time", 0, nil, false, 1},
+		{"transient failure retried", 0, []error{transient, transient}, false, 3},
+		{"gives up after the attempts", 0, []error{transient, transient, transient}, true, 3},
+		{"configured attempts", 5, []error{transient, transient, transient}, false, 4},
+		{"retries disabled", 1, []error{transient}, true, 1},
+		{"permanent failure not retried", 0, []error{fs.ErrorObjectNotFound}, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local := filepath.Join(t.TempDir(), "app_20240115_143022.sql.gz")
+			var tries int
+			var retries []int
+			err := downloadWithRetry(context.Background(), DownloadOptions{Attempts: tt.attempts}.MaxAttempts(), local, func(attempt int) error {
+				tries++
+				if attempt != tries {
+					t.Errorf("attempt = %d, want %d", attempt, tries)
+				}
+				// Each attempt must start from a clean file
+				if _, err := os.Stat(local); !os.IsNotExist(err) {
+					t.Errorf("attempt %d found the previous attempt's file", attempt)
+				}
+				os.WriteFile(local, []byte("partial"), 0o644)
+				if attempt <= len(tt.errs) {
+					return tt.errs[attempt-1]
+				}
+				return nil
+			}, func(attempt int, err error) {
+				retries = append(retries, attempt)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("downloadWithRetry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tries != tt.wantTries || len(retries) != tt.wantTries-1 {
+				t.Errorf("tries = %d, retries announced = %v, want %d tries", tries, retries, tt.wantTries)
+			}
+		})
+	}
+
+	t.Run("cancelled while backing off", func(t *testing.T) {
+		downloadRetryDelay = time.Hour
+		ctx, cancel := context.WithCancel(context.Background())
+		err := downloadWithRetry(ctx, DefaultDownloadAttempts, filepath.Join(t.TempDir(), "f"), func(int) error { return transient }, func(int, error) { cancel() })
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("downloadWithRetry() error = %v, want the cancellation", err)
+		}
+	})
+}
+
+func TestCheckRemotes(t *testing.T) {
+	data := config.LoadedData()
+	data.SetValue("checkok", "type", "local")
+	data.SetValue("checkmissing", "type", "alias")
+	data.SetValue("checkmissing", "remote", filepath.Join(t.TempDir(), "missing"))
+	data.SetValue("checkunknown", "type", "nosuchbackend")
+	t.Cleanup(func() {
+		for _, name := range []string{"checkok", "checkmissing", "checkunknown"} {
+			data.DeleteSection(name)
+		}
+	})
+
+	// Other remotes may be configured: only look at those of the test
+	var checks []RemoteCheck
+	for _, c := range CheckRemotes(context.Background(), 10*time.Second) {
+		if c.Name == "checkok" || c.Name == "checkmissing" || c.Name == "checkunknown" {
+			checks = append(checks, c)
+		}
+	}
+	if len(checks) != 3 || checks[0].Name != "checkmissing" || checks[1].Name != "checkok" || checks[2].Name != "checkunknown" {
+		t.Fatalf("CheckRemotes() = %+v, want the three remotes sorted by name", checks)
+	}
+
+	if c := checks[1]; !c.Usable || c.Type != "local" || c.Reason != "" {
+		t.Errorf("local remote = %+v, want usable", c)
+	}
+	if c := checks[0]; c.Usable || c.Reason == "" {
+		t.Errorf("remote with a missing root = %+v, want not usable with a reason", c)
+	}
+	if c := checks[2]; c.Usable || c.Reason != `unknown backend type "nosuchbackend"` {
+		t.Errorf("remote of an unknown type = %+v, want not usable for its type", c)
+	}
+
+	if !RemoteExists("checkok") || RemoteExists("checknone") {
+		t.Error("RemoteExists() should only report configured remotes")
+	}
+}
@@ -0,0 +1,112 @@
+package tui
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Yoone/blobber/internal/storage"
+)
+
+// destCache keeps the backup listings and successful access tests of destinations for a
+// short while, so going back and forth between screens doesn't reach the remote each
+// time. Commands read and fill it from their own goroutines, hence the lock. Errors are
+// never cached: a failed listing or test is retried by the next visit.
+// Destinations are keyed by their expanded form, so callers may pass either.
+type destCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration // 0 disables the cache
+	listings map[string]cachedListing
+	access   map[string]time.Time // destination -> when it was last found accessible
+}
+
+// cachedListing is the backup listing of a database entry
+type cachedListing struct {
+	dest  string // destination the listing is of, for invalidation
+	files []storage.RemoteFile
+	at    time.Time
+}
+
+// destinations is the cache the TUI's listings and destination tests go through. Its TTL
+// is set from destination_cache_ttl when the TUI starts; until then nothing is cached.
+var destinations = &destCache{}
+
+// setTTL sets how long entries are reused and drops the cached ones
+func (c *destCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+	c.listings, c.access = nil, nil
+}
+
+// listing returns the backups of the entry name at dest listed within the TTL, or lists
+// them with list and keeps the result. The slice returned is the caller's to modify.
+func (c *destCache) listing(dest, name string, list func() ([]storage.RemoteFile, error)) ([]storage.RemoteFile, error) {
+	dest = expandDest(dest)
+	key := dest + "\x00" + name
+	c.mu.Lock()
+	if l, ok := c.listings[key]; ok && time.Since(l.at) < c.ttl {
+		c.mu.Unlock()
+		return slices.Clone(l.files), nil
+	}
+	c.mu.Unlock()
+
+	files, err := list()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl > 0 {
+		if c.listings == nil {
+			c.listings = make(map[string]cachedListing)
+		}
+		c.listings[key] = cachedListing{dest: dest, files: slices.Clone(files), at: time.Now()}
+	}
+	return files, nil
+}
+
+// testAccess returns nil when dest was found accessible within the TTL, or tests it
+// with test and remembers a success
+func (c *destCache) testAccess(dest string, test func() error) error {
+	dest = expandDest(dest)
+	c.mu.Lock()
+	if at, ok := c.access[dest]; ok && time.Since(at) < c.ttl {
+		c.mu.Unlock()
+		return nil
+	}
+	c.mu.Unlock()
+
+	if err := test(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl > 0 {
+		if c.access == nil {
+			c.access = make(map[string]time.Time)
+		}
+		c.access[dest] = time.Now()
+	}
+	return nil
+}
+
+// invalidate drops what is cached of dest, after a backup or prune changed it
+func (c *destCache) invalidate(dest string) {
+	dest = expandDest(dest)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, l := range c.listings {
+		if l.dest == dest {
+			delete(c.listings, key)
+		}
+	}
+	delete(c.access, dest)
+}
+
+// clear drops everything cached, after a remote was edited
+func (c *destCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listings, c.access = nil, nil
+}
@@ -3,28 +3,38 @@ package tui
 import (
 	"context"
 	"fmt"
+	"io"
+	"maps"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
+	"runtime/debug"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Yoone/blobber/internal/backup"
 	"github.com/Yoone/blobber/internal/config"
+	"github.com/Yoone/blobber/internal/lock"
+	"github.com/Yoone/blobber/internal/orchestrator"
 	"github.com/Yoone/blobber/internal/retention"
+	"github.com/Yoone/blobber/internal/runstate"
 	"github.com/Yoone/blobber/internal/storage"
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/progress"
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
 	"github.com/dustin/go-humanize"
 	"github.com/rclone/rclone/fs"
 	rcloneconfig "github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/obscure"
 )
 
 type view int
@@ -32,15 +42,27 @@ type view int
 const (
 	viewMainMenu view = iota
 	viewBackupSelect
+	viewBackupPresetSelect  // pick a saved preset before selecting databases
+	viewBackupPresetSave    // name the current selection to save it as a preset
+	viewBackupExpand        // listing the databases of database "*" entries before backup
+	viewCredentialCheck     // testing database credentials before backup (fail_fast_on_auth)
+	viewDestinationCheck    // testing access to the destinations before backup
 	viewRetentionPreCheck   // checking retention policies before backup
 	viewRetentionPreConfirm // confirmation before starting backups
+	viewRetentionEdit       // edit the retention policy of a database of the plan, then check again
 	viewBackupRunning
+	viewBackupError // full error of a failed database, opened from the backup progress
 	viewRestoreDBSelect
 	viewRestoreSourceSelect
+	viewRestoreCloneSourceSelect // pick another database whose backup to restore
 	viewRestoreFileSelect
 	viewRestoreLocalInput
 	viewRestoreConfirm
 	viewRestoreRunning
+	viewRestoreBatchForm    // point in time of a batch restore of the databases picked with space
+	viewRestoreBatchPlan    // picking the backup each database of the batch restores
+	viewRestoreBatchConfirm // review the batch and type its size to confirm
+	viewRestoreBatchRunning // per-database progress of a batch restore
 	viewAddDBType
 	viewAddDBForm
 	viewAddDBFormConfirmExit
@@ -48,19 +70,26 @@ const (
 	viewDBActions
 	viewEditDBForm
 	viewEditDBFormConfirmExit
+	viewEditDBSaveConfirm // review the changes of an edit before saving
 	viewDeleteConfirm
 	viewDBTest // Testing database connection
+	viewPrune  // Applying retention to a single database without a backup
+	viewStale  // Databases without a recent backup, found by the startup check
 	viewDone
 
 	// Rclone management views
 	viewRcloneList               // List configured remotes
 	viewRcloneActions            // Edit/Delete/Test actions for a remote
+	viewRcloneQuickEdit          // Pick one option of a remote to change
+	viewRcloneQuickEditField     // Input the new value of that option
 	viewRcloneAddType            // Select backend type (s3, azureblob, etc.)
 	viewRcloneAddForm            // Form for configuring the remote
 	viewRcloneAddFormConfirmExit // Confirm exit with unsaved changes
 	viewRcloneDeleteConfirm      // Confirm deletion
 	viewRcloneTestBucket         // Input bucket/path for testing
+	viewDBDestTestBucket         // Input bucket/path for testing a database destination
 	viewRcloneTest               // Testing remote connection
+	viewRcloneUsage              // Space used by backups on a remote
 	viewRcloneOAuth              // OAuth authentication in progress
 )
 
@@ -85,12 +114,14 @@ const (
 	// Restore source options
 	restoreSourceRemote = iota
 	restoreSourceLocal
+	restoreSourceOtherDB
 )
 
 const (
 	// DB actions options
 	dbActionEdit = iota
 	dbActionTest
+	dbActionPrune
 	dbActionDelete
 	dbActionBack
 )
@@ -98,7 +129,9 @@ const (
 const (
 	// Rclone actions options
 	rcloneActionEdit = iota
+	rcloneActionQuickEdit
 	rcloneActionTest
+	rcloneActionUsage
 	rcloneActionDelete
 	rcloneActionBack
 )
@@ -109,11 +142,19 @@ const (
 	confirmNo
 )
 
+// listMaxVisible is the number of rows shown at once in scrollable list views,
+// also used as the PageUp/PageDown step
+const listMaxVisible = 10
+
+// errorDetailMaxLines is the number of error lines shown at once in the error view
+const errorDetailMaxLines = 15
+
 // backupStep represents the current step in the backup process
 type backupStep int
 
 const (
 	stepIdle backupStep = iota
+	stepWaiting
 	stepDumping
 	stepUploading
 	stepRetention
@@ -121,6 +162,8 @@ const (
 
 func (s backupStep) String() string {
 	switch s {
+	case stepWaiting:
+		return "Waiting for other databases"
 	case stepDumping:
 		return "Dumping database"
 	case stepUploading:
@@ -134,22 +177,45 @@ func (s backupStep) String() string {
 
 // backupLogEntry represents a completed backup step
 type backupLogEntry struct {
-	DBName    string
-	Step      backupStep
-	Message   string
-	IsError   bool
-	IsSkipped bool
+	DBName      string
+	Step        backupStep
+	Message     string
+	IsError     bool
+	IsSkipped   bool
+	IsCancelled bool
+	Warnings    []string // non-fatal output from the dump tool
 }
 
 // dbBackupState tracks the backup state for a single database
 type dbBackupState struct {
-	currentStep      backupStep       // current step (stepIdle when done)
-	logs             []backupLogEntry // completed steps
-	result           *backup.Result   // result from dump step (for upload)
-	done             bool             // true when all steps complete
-	uploadBytesDone  int64            // bytes uploaded so far
-	uploadBytesTotal int64            // total bytes to upload
-	uploadSpeed      float64          // upload speed in bytes/second
+	currentStep      backupStep         // current step (stepIdle when done)
+	logs             []backupLogEntry   // completed steps
+	result           *backup.Result     // result from dump step (for upload)
+	done             bool               // true when all steps complete
+	uploadBytesDone  int64              // bytes uploaded so far
+	uploadBytesTotal int64              // total bytes to upload
+	uploadSpeed      float64            // upload speed in bytes/second
+	estimatedSize    int64              // approximate uncompressed dump size (0 if unknown)
+	filename         string             // backup file name, kept for the run report
+	size             int64              // backup file size, kept for the run report
+	uploadedSize     int64              // bytes uploaded, set once the upload is confirmed
+	deletedSize      int64              // bytes of old backups deleted by retention
+	ctx              context.Context    // cancelled to stop this database's dump or upload
+	cancel           context.CancelFunc // cancels ctx
+	cancelled        bool               // true once the user cancelled this database
+}
+
+// context returns the context the database's steps run under
+func (s *dbBackupState) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// failed reports whether a step of the database failed or was cancelled
+func (s *dbBackupState) failed() bool {
+	return slices.ContainsFunc(s.logs, func(e backupLogEntry) bool { return e.IsError || e.IsCancelled })
 }
 
 // restoreStep represents the current step in the restore process
@@ -159,6 +225,8 @@ const (
 	restoreStepIdle restoreStep = iota
 	restoreStepDownloading
 	restoreStepRestoring
+	restoreStepPostRestore
+	restoreStepBackingUp // backing up the restored database as a new baseline
 )
 
 func (s restoreStep) String() string {
@@ -167,6 +235,10 @@ func (s restoreStep) String() string {
 		return "Downloading backup"
 	case restoreStepRestoring:
 		return "Restoring database"
+	case restoreStepPostRestore:
+		return "Running post-restore steps"
+	case restoreStepBackingUp:
+		return "Backing up restored database"
 	default:
 		return ""
 	}
@@ -174,8 +246,31 @@ func (s restoreStep) String() string {
 
 // restoreLogEntry represents a completed restore step
 type restoreLogEntry struct {
-	Message string
-	IsError bool
+	Message  string
+	IsError  bool
+	Warnings []string
+}
+
+// restoreBatchState tracks the restore of a single database of a batch
+type restoreBatchState struct {
+	step orchestrator.RestoreStep // step in progress ("" until the database's turn)
+	logs []restoreLogEntry        // completed steps
+	done bool                     // true once restored or failed
+}
+
+// update records a progress update of the database
+func (s *restoreBatchState) update(p orchestrator.RestoreProgress) {
+	switch {
+	case p.Error != nil:
+		s.logs = append(s.logs, restoreLogEntry{Message: fmt.Sprintf("%s failed: %v", p.Step, p.Error), IsError: true, Warnings: p.Warnings})
+	case p.Message != "":
+		s.logs = append(s.logs, restoreLogEntry{Message: p.Message, Warnings: p.Warnings})
+	default:
+		s.step = p.Step
+	}
+	if p.Done {
+		s.done = true
+	}
 }
 
 // formFields holds form field values in a heap-allocated struct
@@ -195,6 +290,35 @@ type formFields struct {
 	maxSizeMB   string
 }
 
+// setRetention fills the retention fields from a policy, leaving unset rules empty
+func (f *formFields) setRetention(r config.Retention) {
+	f.keepLast, f.keepDays, f.maxSizeMB = "", "", ""
+	if r.KeepLast > 0 {
+		f.keepLast = fmt.Sprintf("%d", r.KeepLast)
+	}
+	if r.KeepDays > 0 {
+		f.keepDays = fmt.Sprintf("%d", r.KeepDays)
+	}
+	if r.MaxSizeMB > 0 {
+		f.maxSizeMB = fmt.Sprintf("%d", r.MaxSizeMB)
+	}
+}
+
+// retention parses the retention fields. max_count has no field and is left unset.
+func (f *formFields) retention() config.Retention {
+	var r config.Retention
+	if f.keepLast != "" {
+		fmt.Sscanf(f.keepLast, "%d", &r.KeepLast)
+	}
+	if f.keepDays != "" {
+		fmt.Sscanf(f.keepDays, "%d", &r.KeepDays)
+	}
+	if f.maxSizeMB != "" {
+		fmt.Sscanf(f.maxSizeMB, "%d", &r.MaxSizeMB)
+	}
+	return r
+}
+
 // restoreFormFields holds restore form field values in a heap-allocated struct
 type restoreFormFields struct {
 	path string
@@ -205,34 +329,104 @@ type rcloneTestFormFields struct {
 	bucket string
 }
 
+// transferWatch tracks when an upload or download last moved bytes, telling a stalled
+// transfer from a slow one
+type transferWatch struct {
+	bytesDone    int64
+	lastProgress time.Time
+	stalledFor   time.Duration // how long no bytes have moved, once past the stall timeout
+}
+
+// progressed records a progress update, which restarts the stall timer when bytes moved
+func (w *transferWatch) progressed(bytesDone int64, now time.Time) {
+	if bytesDone != w.bytesDone || w.lastProgress.IsZero() {
+		w.bytesDone = bytesDone
+		w.lastProgress = now
+		w.stalledFor = 0
+	}
+}
+
+// check marks the transfer stalled when no bytes have moved for timeout
+func (w *transferWatch) check(now time.Time, timeout time.Duration) {
+	w.stalledFor = 0
+	if idle := now.Sub(w.lastProgress); idle >= timeout {
+		w.stalledFor = idle.Truncate(time.Second)
+	}
+}
+
+// stallWarning describes a stalled transfer, or returns "" while it is moving
+func (w *transferWatch) stallWarning() string {
+	if w.stalledFor == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Transfer appears stalled (no progress for %s): r to retry, x to cancel", w.stalledFor)
+}
+
 // downloadState holds download state in a heap-allocated struct to survive model copies
 type downloadState struct {
+	transferWatch
 	progressCh <-chan storage.TransferProgress
+	cancel     context.CancelFunc
+	cancelled  bool        // stopped with x: its error is the cancellation
+	abandoned  atomic.Bool // superseded by a retry: its progress is dropped
 	tmpDir     string
 	fileName   string
 	fileSize   int64
+	target     *config.Database // with stream_restore, the database the backup is restored into as it arrives
+}
+
+// stallWarning describes a stalled download. A streamed restore has already applied
+// part of the backup to its target, so it is offered no retry, only cancelling.
+func (ds *downloadState) stallWarning() string {
+	if ds.target == nil || ds.stalledFor == 0 {
+		return ds.transferWatch.stallWarning()
+	}
+	return fmt.Sprintf("Transfer appears stalled (no progress for %s): x to cancel", ds.stalledFor)
+}
+
+// doneMessage logs the finished download, with its size when the transfer knew it
+func (ds *downloadState) doneMessage() string {
+	if ds.fileSize < 0 {
+		return fmt.Sprintf("Downloaded %s", ds.fileName)
+	}
+	return fmt.Sprintf("Downloaded %s (%s)", ds.fileName, humanize.IBytes(uint64(ds.fileSize)))
 }
 
 // uploadState holds upload state in a heap-allocated struct to survive model copies
 type uploadState struct {
-	progressCh <-chan storage.TransferProgress
-	dbName     string
-	fileSize   int64
+	transferWatch
+	progressCh   <-chan storage.TransferProgress
+	cancel       context.CancelFunc
+	abandoned    atomic.Bool // superseded by a retry: its progress is dropped
+	dbName       string
+	backupPath   string // what a retry uploads again
+	dest         string
+	atomicUpload bool
+	fileSize     int64
 }
 
 type model struct {
 	cfg                *config.Config
+	readOnly           bool // config file can't be written: adding, editing and deleting databases is disabled
 	version            string
+	diagnosticsLog     string // file rclone logs, command stderr and panics go to, hinted at on the main menu
 	view               view
 	cursor             int
 	width              int // terminal width for dynamic sizing
 	dbNames            []string
 	selected           map[string]bool // for backup multi-select
 	skipRetention      bool            // skip retention policy for this backup run
+	retentionDryRun    bool            // apply retention but only report what it would delete
 	dryRun             bool            // perform dump but skip upload and retention
+	skipPreflight      bool            // don't test the destinations before backing up
 	selectedDB         string          // for restore
+	cloneSourceDB      string          // database whose backups are restored into selectedDB ("" for its own)
+	cloneCandidates    []string        // databases of the same type as selectedDB
 	backupFiles        []storage.RemoteFile
-	backupFilesLoading bool // true while fetching backup files
+	backupFilesLoading bool             // true while fetching backup files
+	backupFilesLimit   int              // newest backups shown without a filter (grows with "Load more")
+	backupFilesMore    bool             // older backups exist beyond backupFilesLimit
+	backupFileDeltas   map[string]int64 // file name -> size change since previous backup
 	selectedFile       string
 	selectedFileSize   int64 // size of selected file for restore
 	isLocalRestore     bool  // true if restoring from local file
@@ -247,22 +441,51 @@ type model struct {
 	progressBar progress.Model
 
 	// Backup progress tracking (parallel execution)
-	backupQueue  []string                  // databases to backup (in order for display)
-	backupStates map[string]*dbBackupState // per-database state
-	uploadStates map[string]*uploadState   // per-database upload state (heap-allocated for channel)
+	backupQueue     []string                  // databases to backup (in order for display)
+	backupCfg       *config.Config            // config for backupQueue, with database "*" entries expanded
+	backupWarnings  []string                  // databases skipped while expanding database "*" entries
+	backupStates    map[string]*dbBackupState // per-database state
+	uploadStates    map[string]*uploadState   // per-database upload state (heap-allocated for channel)
+	backupStartedAt time.Time                 // when the current run started (for the run report)
+	runReportStatus string                    // outcome of writing the run report, shown when done
+	runLock         *lock.Lock                // held while backups run, so other runs can't overlap
+
+	consistencyGroups *backup.ConsistencyGroups // sessions of the run's consistency groups, closed when it ends
 
 	// Restore progress tracking
-	restoreStep      restoreStep       // current restore step
-	restoreLogs      []restoreLogEntry // completed restore steps
-	restoreLocalPath string            // path to local file being restored
+	restoreStep       restoreStep       // current restore step
+	restoreLogs       []restoreLogEntry // completed restore steps
+	restoreLocalPath  string            // path to local file being restored
+	restoreThenBackup bool              // back up the database once the restore succeeds
+
+	// Batch restore of several databases (viewRestoreBatch*)
+	restoreBatchSelected map[string]bool                   // databases picked with space on the restore select
+	restoreBatchSources  map[string]string                 // database -> entry whose backups it restores (clone), picked with ctrl+o
+	restoreBatchForm     *huh.Form                         // point in time input, then the typed confirmation
+	restoreBatchInput    *string                           // heap-allocated value of that input
+	restoreBatchAt       time.Time                         // point in time restored to (zero for the latest backups)
+	restoreBatchPlan     []orchestrator.RestoreRequest     // backup each database restores
+	restoreBatchFailures orchestrator.RestoreFailures      // databases left out of the plan
+	restoreBatchStates   map[string]*restoreBatchState     // per-database progress
+	restoreBatchProgress chan orchestrator.RestoreProgress // updates of the running batch (nil once it ended)
 
 	// Download progress tracking
 	downloadBytesDone int64          // bytes downloaded so far
 	downloadSpeed     float64        // download speed in bytes/second
+	downloadRetry     string         // why the download is being retried, empty on the first attempt
 	downloadState     *downloadState // heap-allocated download state (survives model copies)
+	stallWatching     bool           // stallCheckMsg ticks while uploads or downloads run
 
 	// Retention plan (pre-calculated before backup starts)
-	retentionPlan map[string][]storage.RemoteFile // dbName -> files to delete
+	retentionPlan        map[string][]storage.RemoteFile // dbName -> files to delete
+	retentionFailures    orchestrator.RetentionFailures  // dbName -> why its backups could not be listed (retention skipped)
+	retentionAfterUpload bool                            // not pre-checked (skip_retention_precheck): each database lists its destination after its upload
+
+	// Standalone prune (viewPrune)
+	pruneDB       string   // database being pruned from the management screen (empty otherwise)
+	pruneRunning  bool     // true while deletions are in progress
+	pruneResult   string   // rendered outcome once done
+	pruneWarnings []string // files that could not be deleted
 
 	// Add database form (huh)
 	addDBType string      // file, mysql, postgres
@@ -278,14 +501,31 @@ type model struct {
 	pendingDestTest bool   // true when destination test should run after connection test
 
 	// Database list/edit/delete (viewDBList)
-	editingDB      string   // name of database being edited (empty for add)
-	dbFilter       string   // search filter text for database list
-	dbFilteredList []string // databases filtered by search
+	editingDB      string          // name of database being edited (empty for add)
+	editedDB       config.Database // database built from the edit form, saved once confirmed
+	editChanges    []fieldChange   // what the edit changes, shown before saving
+	dbFilter       string          // search filter text for database list
+	dbFilteredList []string        // databases filtered by search
 
 	// Backup select (viewBackupSelect)
 	backupFilter       string   // search filter for backup database selection
 	backupFilteredList []string // databases filtered by search
 
+	// Backup presets (viewBackupPresetSelect, viewBackupPresetSave)
+	presetNames  []string  // presets offered before selecting databases, sorted
+	presetForm   *huh.Form // input for the name of the preset being saved
+	presetName   *string   // heap-allocated value of that input
+	presetStatus string    // outcome of the last preset save, shown on the backup select screen
+
+	// Startup check for databases without a recent backup (stale_check)
+	staleChecked bool                         // the check has finished at least once
+	staleDBs     []orchestrator.StaleDatabase // databases it found, sorted by name
+
+	// Run state, saved while backups run so a killed run can be resumed
+	backupSelection []string        // config entries the run was started with
+	interruptedRun  *runstate.State // left by a run that was killed, offered on the main menu
+	resumePending   map[string]bool // resuming: only these databases of the queue are backed up
+
 	// Restore database select (viewRestoreDBSelect)
 	restoreDBFilter       string   // search filter for restore database selection
 	restoreDBFilteredList []string // databases filtered by search
@@ -297,9 +537,17 @@ type model struct {
 	// Backup running scroll (viewBackupRunning)
 	backupScrollOffset int // index of first visible DB in backup progress
 
+	// Backup error detail (viewBackupError)
+	errorDetailDB     string // database whose failure is shown
+	errorDetailOffset int    // index of the first visible line of the error
+
 	// Retention pre-confirm pagination (viewRetentionPreConfirm)
 	retentionDBPage int // current page (0-indexed) in retention preview
 
+	// Retention policy edited from the pre-confirm (viewRetentionEdit)
+	retentionEditDB   string    // database of the plan whose policy e edits (tab picks it)
+	retentionEditForm *huh.Form // its retention fields
+
 	// Restore local path form
 	restorePathForm *huh.Form
 	restoreFormData *restoreFormFields // heap-allocated form values
@@ -308,7 +556,9 @@ type model struct {
 	rcloneRemotes            []string              // list of configured remote names
 	rcloneRemoteFilter       string                // search filter for remote list
 	rcloneRemoteFilteredList []string              // remotes filtered by search
-	rcloneBackends           []*fs.RegInfo         // available backends (filtered, non-hidden)
+	rcloneBackends           []*fs.RegInfo         // available backends (filtered, non-hidden), preferred ones first
+	rclonePreferredCount     int                   // how many of rcloneBackends are in preferred_backends
+	rcloneShowAllBackends    bool                  // list every backend, not only the preferred ones
 	rcloneFilteredList       []*fs.RegInfo         // backends filtered by search
 	rcloneFilter             string                // search filter text
 	selectedRemote           string                // currently selected remote for actions
@@ -320,7 +570,14 @@ type model struct {
 	advancedStartPage        int                   // page index where advanced options start
 	rcloneTestForm           *huh.Form             // form for entering bucket to test
 	rcloneTestFormData       *rcloneTestFormFields // heap-allocated form values
+	destTestRemote           string                // remote whose root is the DB form destination
+	destTestReturnView       view                  // DB form view to return to after the bucket prompt
 	rcloneTestResult         string                // result of rclone connection test
+	rcloneUsage              string                // result of the usage check (empty while it runs)
+	quickEditOptions         []fs.Option           // options listed by quick edit
+	quickEditForm            *huh.Form             // input for the option being changed
+	quickEditValue           *string               // heap-allocated value of that input
+	quickEditStatus          string                // outcome of the last quick edit save
 
 	// OAuth state
 	oauthStatus string // status message during OAuth
@@ -417,6 +674,36 @@ func formatDestForDisplay(dest string, maxLen int) string {
 	return dest
 }
 
+// getDestSuggestions returns suggestions for a backup destination. While the input could
+// still be a remote name, configured rclone remotes are suggested first (favorites on top),
+// followed by matching local paths.
+func (m *model) getDestSuggestions(partial string) []string {
+	// Once a remote is chosen, the path part is free-form
+	if strings.Contains(partial, ":") {
+		return nil
+	}
+
+	var suggestions []string
+	if !strings.ContainsAny(partial, "/~.") {
+		var favorites, others []string
+		for _, name := range rcloneconfig.GetRemoteNames() {
+			if !strings.HasPrefix(strings.ToLower(name), strings.ToLower(partial)) {
+				continue
+			}
+			if m.cfg != nil && m.cfg.IsFavoriteRemote(name) {
+				favorites = append(favorites, name+":")
+			} else {
+				others = append(others, name+":")
+			}
+		}
+		sort.Strings(favorites)
+		sort.Strings(others)
+		suggestions = append(favorites, others...)
+	}
+
+	return append(suggestions, getPathSuggestions(partial)...)
+}
+
 // getPathSuggestions returns suggestions for a partial local path (used for SQLite file paths)
 func getPathSuggestions(partial string) []string {
 	// Skip suggestions for rclone remotes (contain :)
@@ -573,6 +860,33 @@ func customKeyMap() *huh.KeyMap {
 	return km
 }
 
+// diagnostics receives the stack of a panic in the TUI before it exits (see logPanic),
+// and diagnosticsPath is the file it writes to, hinted at on the main menu. nil logs
+// nothing.
+var (
+	diagnostics     io.Writer
+	diagnosticsPath string
+)
+
+// SetDiagnosticsLog sets where the TUI logs panics, and the file shown on the main menu
+// for diagnostics
+func SetDiagnosticsLog(w io.Writer, path string) {
+	diagnostics, diagnosticsPath = w, path
+}
+
+// logPanic logs the stack of a panic to the diagnostics log and panics again, for
+// bubbletea to restore the terminal. Deferred by Update and View.
+func logPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if diagnostics != nil {
+		fmt.Fprintf(diagnostics, "%s panic: %v\n%s\n", time.Now().Format("2006-01-02 15:04:05"), r, debug.Stack())
+	}
+	panic(r)
+}
+
 // validNamePattern matches only letters, digits, dashes, and underscores
 var validNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 
@@ -607,6 +921,16 @@ func validateName(name string) error {
 	return nil
 }
 
+// nameConflict reports whether saving the form under name would overwrite another
+// database: any existing name when adding, or an existing name other than the one
+// being edited when renaming
+func (m *model) nameConflict(name string) bool {
+	if _, exists := m.cfg.Databases[name]; !exists {
+		return false
+	}
+	return m.view != viewEditDBForm || name != m.editingDB
+}
+
 // validateForm checks required fields and returns an error message if any are missing
 func (m *model) validateForm() string {
 	if m.formData == nil {
@@ -619,6 +943,8 @@ func (m *model) validateForm() string {
 		errors = append(errors, "Name is required")
 	} else if err := validateName(m.formData.name); err != nil {
 		errors = append(errors, "Name: "+err.Error())
+	} else if m.nameConflict(m.formData.name) {
+		errors = append(errors, fmt.Sprintf("Name: database %q already exists", m.formData.name))
 	}
 	if m.formData.dest == "" {
 		errors = append(errors, "Backup destination is required")
@@ -684,7 +1010,15 @@ func (m *model) buildAddDBForm(resetValues bool) *huh.Form {
 		Title("Name (identifier)").
 		Placeholder("mydb").
 		Value(&m.formData.name).
-		Validate(validateName)
+		Validate(func(name string) error {
+			if err := validateName(name); err != nil {
+				return err
+			}
+			if m.nameConflict(name) {
+				return fmt.Errorf("a database named %q already exists", name)
+			}
+			return nil
+		})
 
 	switch m.addDBType {
 	case "file":
@@ -709,7 +1043,7 @@ func (m *model) buildAddDBForm(resetValues bool) *huh.Form {
 			Description("Local path or rclone remote").
 			Value(&m.formData.dest).
 			SuggestionsFunc(func() []string {
-				return getPathSuggestions(m.formData.dest)
+				return m.getDestSuggestions(m.formData.dest)
 			}, &m.formData.dest)
 
 		compressionSelect := huh.NewSelect[string]().
@@ -771,7 +1105,7 @@ func (m *model) buildAddDBForm(resetValues bool) *huh.Form {
 			Description("Local path or rclone remote").
 			Value(&m.formData.dest).
 			SuggestionsFunc(func() []string {
-				return getPathSuggestions(m.formData.dest)
+				return m.getDestSuggestions(m.formData.dest)
 			}, &m.formData.dest)
 
 		compressionSelect := huh.NewSelect[string]().
@@ -787,6 +1121,28 @@ func (m *model) buildAddDBForm(resetValues bool) *huh.Form {
 	}
 
 	// Retention policy fields (common to all database types)
+	namedGroups = append(namedGroups, namedGroup{
+		name:  "Retention Policy (applied on backup)",
+		group: huh.NewGroup(m.retentionInputs()...),
+	})
+
+	// Add page numbers to group titles
+	var groups []*huh.Group
+	total := len(namedGroups)
+	for i, ng := range namedGroups {
+		groups = append(groups, ng.group.Title(fmt.Sprintf("%s (%d/%d)", ng.name, i+1, total)))
+	}
+
+	return huh.NewForm(groups...).
+		WithShowHelp(true).
+		WithShowErrors(true).
+		WithKeyMap(customKeyMap()).
+		WithTheme(themeAmber()).
+		WithWidth(m.formWidth())
+}
+
+// retentionInputs returns the retention policy fields of the database form
+func (m *model) retentionInputs() []huh.Field {
 	keepLastInput := huh.NewInput().
 		Key("keep_last").
 		Title("Keep last N backups").
@@ -808,24 +1164,7 @@ func (m *model) buildAddDBForm(resetValues bool) *huh.Form {
 		Placeholder("e.g. 1000").
 		Value(&m.formData.maxSizeMB)
 
-	namedGroups = append(namedGroups, namedGroup{
-		name:  "Retention Policy (applied on backup)",
-		group: huh.NewGroup(keepLastInput, keepDaysInput, maxSizeInput),
-	})
-
-	// Add page numbers to group titles
-	var groups []*huh.Group
-	total := len(namedGroups)
-	for i, ng := range namedGroups {
-		groups = append(groups, ng.group.Title(fmt.Sprintf("%s (%d/%d)", ng.name, i+1, total)))
-	}
-
-	return huh.NewForm(groups...).
-		WithShowHelp(true).
-		WithShowErrors(true).
-		WithKeyMap(customKeyMap()).
-		WithTheme(themeAmber()).
-		WithWidth(m.formWidth())
+	return []huh.Field{keepLastInput, keepDaysInput, maxSizeInput}
 }
 
 // formWidth returns the width for forms (terminal width - 8 for border margin + padding, min 60)
@@ -899,6 +1238,27 @@ func (m *model) buildRcloneTestForm() *huh.Form {
 		WithWidth(m.formWidth())
 }
 
+// s3RootRemote returns the remote name when dest points at the root of an S3-like
+// remote (e.g. "s3:"), where a test would need permission to list all buckets
+func s3RootRemote(dest string) (string, bool) {
+	remote, path, found := strings.Cut(dest, ":")
+	if !found || strings.Trim(path, "/") != "" {
+		return "", false
+	}
+	return remote, isS3LikeBackend(getRcloneRemoteType(remote))
+}
+
+// promptDestTestBucket asks for a bucket before testing a DB form destination
+// that points at the root of an S3-like remote
+func (m model) promptDestTestBucket(remote string) (tea.Model, tea.Cmd) {
+	m.destTestRemote = remote
+	m.destTestReturnView = m.view
+	m.view = viewDBDestTestBucket
+	m.rcloneTestFormData = nil // Reset so buildRcloneTestForm allocates fresh
+	m.rcloneTestForm = m.buildRcloneTestForm()
+	return m, m.rcloneTestForm.Init()
+}
+
 // isS3LikeBackend checks if a backend type requires a bucket/container.
 // These backends need a bucket specified when testing, as root-level access
 // often requires ListBuckets permission which users may not have.
@@ -928,15 +1288,7 @@ func (m *model) populateFormFromDB(name string) {
 	}
 
 	// Retention fields
-	if db.Retention.KeepLast > 0 {
-		m.formData.keepLast = fmt.Sprintf("%d", db.Retention.KeepLast)
-	}
-	if db.Retention.KeepDays > 0 {
-		m.formData.keepDays = fmt.Sprintf("%d", db.Retention.KeepDays)
-	}
-	if db.Retention.MaxSizeMB > 0 {
-		m.formData.maxSizeMB = fmt.Sprintf("%d", db.Retention.MaxSizeMB)
-	}
+	m.formData.setRetention(db.Retention)
 
 	switch db.Type {
 	case "file":
@@ -979,29 +1331,38 @@ func (m *model) testDestinationAccess() (bool, string) {
 	return true, "Destination accessible"
 }
 
+// saveRcloneConfig writes the rclone config after a remote was added, edited or deleted,
+// and drops what was cached of destinations, which may be on that remote
+func saveRcloneConfig() {
+	rcloneconfig.SaveConfig()
+	destinations.clear()
+}
+
 // runConnectionTestCmd returns a tea.Cmd that tests MySQL/Postgres database connection
 // runDBTestCmd runs connection and destination tests for the selected database
 func (m *model) runDBTestCmd() tea.Cmd {
 	dbName := m.editingDB
 	db := m.cfg.Databases[dbName]
+	settings := backup.SettingsFrom(m.cfg)
 	m.testRunning = true
 
 	return func() tea.Msg {
 		// First test connection for MySQL/Postgres
 		if db.Type == "mysql" || db.Type == "postgres" {
-			if err := backup.TestConnection(db); err != nil {
+			message, err := testDBConnections(db, settings)
+			if err != nil {
 				// Send connection failure, then test destination
 				return dbTestResultMsg{testType: "connection", success: false, message: err.Error()}
 			}
 			// Connection succeeded, send result and continue to destination test
-			return dbTestResultMsg{testType: "connection", success: true, message: "Database connection successful"}
+			return dbTestResultMsg{testType: "connection", success: true, message: message}
 		}
 		// For file type, skip to destination test
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
 		dest := expandDest(db.Dest)
-		if err := storage.TestAccess(ctx, dest); err != nil {
+		if err := destinations.testAccess(dest, func() error { return storage.TestAccess(ctx, dest) }); err != nil {
 			return dbTestResultMsg{testType: "destination", success: false, message: err.Error(), done: true}
 		}
 		return dbTestResultMsg{testType: "destination", success: true, message: "Destination accessible", done: true}
@@ -1018,7 +1379,7 @@ func (m *model) runDBDestTestCmd() tea.Cmd {
 		defer cancel()
 
 		dest := expandDest(db.Dest)
-		if err := storage.TestAccess(ctx, dest); err != nil {
+		if err := destinations.testAccess(dest, func() error { return storage.TestAccess(ctx, dest) }); err != nil {
 			return dbTestResultMsg{testType: "destination", success: false, message: err.Error(), done: true}
 		}
 		return dbTestResultMsg{testType: "destination", success: true, message: "Destination accessible", done: true}
@@ -1039,6 +1400,8 @@ func (m *model) runConnectionTestCmd() tea.Cmd {
 	user := m.formData.user
 	password := m.formData.password
 	database := m.formData.database
+	prev := m.cfg.Databases[m.editingDB] // for config-only settings
+	settings := backup.SettingsFrom(m.cfg)
 
 	return func() tea.Msg {
 		if host == "" || user == "" || database == "" {
@@ -1051,19 +1414,41 @@ func (m *model) runConnectionTestCmd() tea.Cmd {
 		}
 
 		db := config.Database{
-			Type:     dbType,
-			Host:     host,
-			Port:     portNum,
-			User:     user,
-			Password: password,
-			Database: database,
+			Type:           dbType,
+			Host:           host,
+			Port:           portNum,
+			User:           user,
+			Password:       password,
+			Database:       database,
+			ConnectTimeout: prev.ConnectTimeout,
+			DumpHost:       prev.DumpHost,
+			DumpPort:       prev.DumpPort,
+		}
+
+		message, err := testDBConnections(db, settings)
+		if err != nil {
+			return testResultMsg{testType: "connection", success: false, message: err.Error()}
 		}
+		return testResultMsg{testType: "connection", success: true, message: message}
+	}
+}
 
-		if err := backup.TestConnection(db); err != nil {
-			return testResultMsg{testType: "connection", success: false, message: err.Error()}
+// testDBConnections tests the connection dumps use and, when dump_host or dump_port
+// point them at another server, the one restores use. Returns the success message.
+func testDBConnections(db config.Database, settings backup.Settings) (string, error) {
+	if err := backup.TestConnection(db, settings); err != nil {
+		if db.HasDumpSource() {
+			return "", fmt.Errorf("dump host: %w", err)
 		}
-		return testResultMsg{testType: "connection", success: true, message: "Database connection successful"}
+		return "", err
 	}
+	if !db.HasDumpSource() {
+		return "Database connection successful", nil
+	}
+	if err := backup.TestRestoreConnection(db, settings); err != nil {
+		return "", fmt.Errorf("restore host: %w", err)
+	}
+	return "Dump and restore connections successful", nil
 }
 
 // runDestinationTestCmd returns a tea.Cmd that tests backup destination access
@@ -1075,22 +1460,31 @@ func (m *model) runDestinationTestCmd() tea.Cmd {
 	}
 
 	dest := m.formData.dest
-
-	return func() tea.Msg {
-		if dest == "" {
+	if dest == "" {
+		return func() tea.Msg {
 			return testResultMsg{testType: "destination", success: false, message: "Enter a destination first"}
 		}
-		expandedDest := expandDest(dest)
+	}
+
+	expandedDest := expandDest(dest)
+	if _, ok := s3RootRemote(expandedDest); ok {
+		return func() tea.Msg {
+			return testResultMsg{testType: "destination", skipped: true, message: "Destination test skipped: add a bucket or press ctrl+t to test one"}
+		}
+	}
+	return testDestinationCmd(expandedDest, backup.SettingsFrom(m.cfg).ConnectTimeoutFor(m.cfg.Databases[m.editingDB]))
+}
 
-		// Create context with connection timeout
-		timeout := time.Duration(backup.ConnectTimeoutSeconds) * time.Second
+// testDestinationCmd checks that an expanded destination is accessible within timeout
+func testDestinationCmd(expandedDest string, timeout time.Duration) tea.Cmd {
+	return func() tea.Msg {
 		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
 
 		err := storage.TestAccess(ctx, expandedDest)
 		if err != nil {
 			if ctx.Err() == context.DeadlineExceeded {
-				return testResultMsg{testType: "destination", success: false, message: fmt.Sprintf("Destination access timed out (%ds)", backup.ConnectTimeoutSeconds)}
+				return testResultMsg{testType: "destination", success: false, message: fmt.Sprintf("Destination access timed out (%s)", timeout)}
 			}
 			return testResultMsg{testType: "destination", success: false, message: fmt.Sprintf("Destination not accessible: %v", err)}
 		}
@@ -1098,31 +1492,6 @@ func (m *model) runDestinationTestCmd() tea.Cmd {
 	}
 }
 
-// checkRequiredUtilities checks if required dump/restore utilities are in PATH
-// Returns a list of warning messages for missing utilities
-func checkRequiredUtilities(dbType string) []string {
-	var warnings []string
-
-	switch dbType {
-	case "mysql":
-		if _, err := exec.LookPath("mysqldump"); err != nil {
-			warnings = append(warnings, "mysqldump not found in PATH (required for backup)")
-		}
-		if _, err := exec.LookPath("mysql"); err != nil {
-			warnings = append(warnings, "mysql client not found in PATH (required for restore)")
-		}
-	case "postgres":
-		if _, err := exec.LookPath("pg_dump"); err != nil {
-			warnings = append(warnings, "pg_dump not found in PATH (required for backup)")
-		}
-		if _, err := exec.LookPath("psql"); err != nil {
-			warnings = append(warnings, "psql not found in PATH (required for restore)")
-		}
-	}
-
-	return warnings
-}
-
 func Run(cfg *config.Config, version string) error {
 	// Get sorted database names
 	var dbNames []string
@@ -1147,6 +1516,7 @@ func Run(cfg *config.Config, version string) error {
 
 	m := model{
 		cfg:            cfg,
+		readOnly:       cfg.ReadOnly(),
 		version:        version,
 		view:           viewMainMenu,
 		dbNames:        dbNames,
@@ -1154,18 +1524,27 @@ func Run(cfg *config.Config, version string) error {
 		selected:       selected,
 		spinner:        s,
 		progressBar:    prog,
+		interruptedRun: interruptedRun(cfg),
+		diagnosticsLog: diagnosticsPath,
 	}
 
+	destinations.setTTL(cfg.DestinationCacheDuration())
+
 	p := tea.NewProgram(m)
 	_, err := p.Run()
 	return err
 }
 
 func (m model) Init() tea.Cmd {
+	if m.cfg.StaleCheck {
+		return tea.Batch(m.spinner.Tick, m.checkStale())
+	}
 	return m.spinner.Tick
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	defer logPanic()
+
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
@@ -1324,6 +1703,11 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 
+		// Handle prune view - any key returns to actions when done
+		if m.view == viewPrune && !m.pruneRunning {
+			return m.endPrune(), nil
+		}
+
 		// Handle rclone test view - any key returns to previous view
 		if m.view == viewRcloneTest && m.rcloneTestResult != "" {
 			// Return to form if we came from there, otherwise to actions menu
@@ -1337,12 +1721,47 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 
+		// Handle backup error view - scroll the error, esc/enter returns to the progress
+		if m.view == viewBackupError {
+			lastOffset := max(len(m.errorDetailLines())-errorDetailMaxLines, 0)
+			switch msg.String() {
+			case "up", "k":
+				m.errorDetailOffset = max(m.errorDetailOffset-1, 0)
+			case "down", "j":
+				m.errorDetailOffset = min(m.errorDetailOffset+1, lastOffset)
+			case "pgup":
+				m.errorDetailOffset = max(m.errorDetailOffset-errorDetailMaxLines, 0)
+			case "pgdown":
+				m.errorDetailOffset = min(m.errorDetailOffset+errorDetailMaxLines, lastOffset)
+			case "esc", "enter", "e":
+				m.view = viewBackupRunning
+				m.errorDetailDB = ""
+				m.errorDetailOffset = 0
+			}
+			return m, nil
+		}
+
+		// Handle stale backups view - any key returns to the main menu
+		if m.view == viewStale {
+			m.view = viewMainMenu
+			m.cursor = menuBackup
+			return m, nil
+		}
+
+		// Handle rclone usage view - any key returns to actions once loaded
+		if m.view == viewRcloneUsage && m.rcloneUsage != "" {
+			m.view = viewRcloneActions
+			m.cursor = rcloneActionUsage
+			m.rcloneUsage = ""
+			return m, nil
+		}
+
 		// Handle rclone OAuth view - allow escape to cancel on error
 		if m.view == viewRcloneOAuth && m.oauthErr != nil {
 			if msg.Type == tea.KeyEsc || msg.Type == tea.KeyEnter {
 				// Delete the incomplete remote and go back
 				rcloneconfig.DeleteRemote(m.selectedRemote)
-				rcloneconfig.SaveConfig()
+				saveRcloneConfig()
 				m.refreshRcloneRemotes()
 				m.view = viewRcloneList
 				m.cursor = 0
@@ -1361,13 +1780,22 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return newModel, nil
 				}
 			case tea.KeyRunes:
+				// alt+<letter> jumps to the next entry starting with that letter
+				if msg.Alt {
+					return m.jumpToLetter(string(msg.Runes)), nil
+				}
 				return m.handleFilterInput(string(msg.Runes)), nil
+			case tea.KeySpace:
+				// The restore file filter combines terms such as "mydb >7d"
+				if m.view == viewRestoreFileSelect {
+					return m.handleFilterInput(" "), nil
+				}
 			}
 			// Fall through to generic key handling for esc/up/down/enter
 		}
 
 		// Skip generic key handling for form views - let the form handle its own keys
-		if m.view != viewAddDBForm && m.view != viewEditDBForm && m.view != viewRestoreLocalInput && m.view != viewRcloneAddForm && m.view != viewRcloneTestBucket {
+		if m.view != viewAddDBForm && m.view != viewEditDBForm && m.view != viewRestoreLocalInput && m.view != viewRcloneAddForm && m.view != viewRcloneTestBucket && m.view != viewDBDestTestBucket && m.view != viewRcloneQuickEditField && m.view != viewBackupPresetSave && m.view != viewRetentionEdit && m.view != viewRestoreBatchForm && m.view != viewRestoreBatchConfirm {
 			switch msg.String() {
 			case "ctrl+c":
 				m.quitting = true
@@ -1378,6 +1806,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.quitting = true
 					return m, tea.Quit
 				}
+				if m.view == viewEditDBSaveConfirm {
+					return m.returnToEditForm()
+				}
 				return m.goBack(), nil
 
 			case "up", "k":
@@ -1394,6 +1825,16 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.cursor = 0 // cycle to top
 				}
 
+			case "pgup":
+				if m.isFilterableView() {
+					m.cursor = max(m.cursor-listMaxVisible, 0)
+				}
+
+			case "pgdown":
+				if m.isFilterableView() {
+					m.cursor = min(m.cursor+listMaxVisible, m.maxCursor())
+				}
+
 			case "left", "h":
 				// Previous page in retention preview
 				if m.view == viewRetentionPreConfirm && m.retentionDBPage > 0 {
@@ -1403,19 +1844,152 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case "right", "l":
 				// Next page in retention preview
 				if m.view == viewRetentionPreConfirm {
-					// Count DBs with files to delete
-					dbCount := 0
-					for _, name := range m.backupQueue {
-						if len(m.retentionPlan[name]) > 0 {
-							dbCount++
-						}
-					}
-					maxPage := (dbCount - 1) / 5 // 5 DBs per page
+					maxPage := (len(m.retentionPlanDatabases()) - 1) / retentionDBsPerPage
 					if m.retentionDBPage < maxPage {
 						m.retentionDBPage++
 					}
 				}
 
+			case "tab":
+				// Pick the database of the plan whose retention e edits
+				if m.view == viewRetentionPreConfirm && m.pruneDB == "" {
+					dbs := m.retentionPlanDatabases()
+					if len(dbs) > 0 {
+						i := (slices.Index(dbs, m.retentionEditDB) + 1) % len(dbs)
+						m.retentionEditDB = dbs[i]
+						m.retentionDBPage = i / retentionDBsPerPage
+					}
+					return m, nil
+				}
+
+			case "ctrl+s":
+				// Save the backup selection and its toggles as a preset
+				if m.view == viewBackupSelect && !m.readOnly {
+					if !slices.ContainsFunc(m.dbNames, func(name string) bool { return m.selected[name] }) {
+						m.presetStatus = errorStyle.Render("✗ Select the databases to save as a preset first")
+						return m, nil
+					}
+					m.presetForm = m.buildPresetForm()
+					m.view = viewBackupPresetSave
+					return m, m.presetForm.Init()
+				}
+
+			case "ctrl+f":
+				// Toggle favorite on the selected rclone remote
+				if m.view == viewRcloneList && m.cursor < len(m.rcloneRemoteFilteredList) && !m.readOnly {
+					name := m.rcloneRemoteFilteredList[m.cursor]
+					m.cfg.SetFavoriteRemote(name, !m.cfg.IsFavoriteRemote(name))
+					if err := m.cfg.Save(); err != nil {
+						m.err = err
+					}
+					return m, nil
+				}
+
+			case "s":
+				// Show the databases the startup check found without a recent backup
+				if m.view == viewMainMenu && len(m.staleDBs) > 0 {
+					m.view = viewStale
+					return m, nil
+				}
+
+			case "r":
+				// Back up the databases the interrupted run did not finish
+				if m.view == viewMainMenu && m.interruptedRun != nil {
+					return m.resumeInterruptedRun()
+				}
+				// Start a stalled transfer again
+				if m.downloading() && m.downloadState.stalledFor > 0 {
+					return m.retryDownload()
+				}
+				if m.view == viewBackupRunning && m.cursor < len(m.backupQueue) {
+					if us := m.uploadStates[m.backupQueue[m.cursor]]; us != nil && us.stalledFor > 0 {
+						return m.retryUpload(m.backupQueue[m.cursor])
+					}
+				}
+
+			case "d":
+				// Forget the interrupted run
+				if m.view == viewMainMenu && m.interruptedRun != nil {
+					m.interruptedRun = nil
+					if err := runstate.Remove(runstate.PathFor(m.cfg.Path())); err != nil {
+						m.err = err
+					}
+					return m, nil
+				}
+
+			case "b":
+				// Toggle backing up the database after the restore
+				if m.view == viewRestoreConfirm {
+					m.restoreThenBackup = !m.restoreThenBackup
+					return m, nil
+				}
+
+			case "x":
+				// Cancel the highlighted database, leaving the others running
+				if m.view == viewBackupRunning && m.cursor < len(m.backupQueue) {
+					return m, m.cancelBackup(m.backupQueue[m.cursor])
+				}
+				// Cancel the download of the backup being restored
+				if m.downloading() && !m.downloadState.cancelled {
+					m.downloadState.cancelled = true
+					m.downloadState.cancel()
+					return m, nil
+				}
+
+			case "e":
+				// Show the full error of the highlighted database
+				if m.view == viewBackupRunning && m.cursor < len(m.backupQueue) {
+					if m.failedEntry(m.backupQueue[m.cursor]) != nil {
+						m.view = viewBackupError
+						m.errorDetailDB = m.backupQueue[m.cursor]
+						m.errorDetailOffset = 0
+					}
+					return m, nil
+				}
+				// Fix the retention policy of the picked database and check the plan again
+				if m.view == viewRetentionPreConfirm && m.pruneDB == "" && m.retentionEditDB != "" && !m.readOnly {
+					return m.editPlanRetention()
+				}
+
+			case "ctrl+r":
+				// List the backups or test the destination again, bypassing the cache
+				if m.view == viewRestoreFileSelect && !m.backupFilesLoading {
+					destinations.invalidate(m.cfg.Databases[m.restoreSourceDB()].Dest)
+					m.backupFilesLoading = true
+					m.backupFiles = nil
+					m.backupFilesLimit = m.cfg.RestorePickerLimit()
+					return m, tea.Batch(m.spinner.Tick, m.fetchBackupFiles())
+				}
+				if m.view == viewDBTest && !m.testRunning {
+					destinations.invalidate(expandDest(m.cfg.Databases[m.editingDB].Dest))
+					m.testConnResult = ""
+					m.testDestResult = ""
+					return m, m.runDBTestCmd()
+				}
+
+			case "ctrl+o":
+				// Restore the picked database from another database's backups instead
+				if m.view == viewRestoreDBSelect && m.cursor < len(m.restoreDBFilteredList) {
+					if name := m.restoreDBFilteredList[m.cursor]; m.restoreBatchSelected[name] {
+						m.cycleRestoreBatchSource(name)
+					}
+					return m, nil
+				}
+
+			case "ctrl+a":
+				// Select or deselect every database shown in the backup view
+				if m.view == viewBackupSelect {
+					m.toggleAllBackupDatabases()
+					return m, nil
+				}
+				// Show every backend, or only the preferred ones again
+				if m.view == viewRcloneAddType && m.rclonePreferredCount > 0 {
+					m.rcloneShowAllBackends = !m.rcloneShowAllBackends
+					m.filterRcloneBackends(m.rcloneFilter)
+					m.cursor = 0
+					return m, nil
+				}
+
 			case "a":
 				// Shortcut to add new rclone remote
 				if m.view == viewRcloneList {
@@ -1426,6 +2000,14 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 
 			case " ":
+				// Pick the database for a batch restore
+				if m.view == viewRestoreDBSelect && m.cursor < len(m.restoreDBFilteredList) {
+					if m.restoreBatchSelected == nil {
+						m.restoreBatchSelected = make(map[string]bool)
+					}
+					name := m.restoreDBFilteredList[m.cursor]
+					m.restoreBatchSelected[name] = !m.restoreBatchSelected[name]
+				}
 				// Toggle selection in backup view
 				if m.view == viewBackupSelect {
 					if m.cursor < len(m.backupFilteredList) {
@@ -1435,9 +2017,26 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					} else if m.cursor == len(m.backupFilteredList) {
 						// Toggle retention policy
 						m.skipRetention = !m.skipRetention
+						if m.skipRetention {
+							m.retentionDryRun = false
+						}
 					} else if m.cursor == len(m.backupFilteredList)+1 {
+						// Toggle retention dry-run mode, which needs retention to run, as
+						// --retention-dry-run refuses --dry-run and --skip-retention
+						m.retentionDryRun = !m.retentionDryRun
+						if m.retentionDryRun {
+							m.skipRetention = false
+							m.dryRun = false
+						}
+					} else if m.cursor == len(m.backupFilteredList)+2 {
 						// Toggle dry-run mode
 						m.dryRun = !m.dryRun
+						if m.dryRun {
+							m.retentionDryRun = false
+						}
+					} else if m.cursor == len(m.backupFilteredList)+3 {
+						// Toggle the destination test, as --skip-preflight does
+						m.skipPreflight = !m.skipPreflight
 					}
 				}
 
@@ -1451,35 +2050,175 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.spinner, cmd = m.spinner.Update(msg)
 		return m, cmd
 
-	case retentionPreCheckMsg:
-		m.retentionPlan = msg.plan
-		if len(m.retentionPlan) > 0 {
-			// Show confirmation screen
-			m.view = viewRetentionPreConfirm
-			m.cursor = 0
-			m.retentionDBPage = 0
+	case backupExpandMsg:
+		if msg.err != nil {
+			m.err = msg.err
+			m.view = viewDone
+			m.resumePending = nil
 			return m, nil
 		}
-		// No files to delete, start backups directly
-		return m.startBackups()
-
-	case backupStepDoneMsg:
-		return m.handleBackupStepDone(msg)
+		m.backupCfg = msg.cfg
+		m.backupQueue = msg.queue
+		m.backupWarnings = msg.warnings
+		if len(m.backupQueue) == 0 {
+			m.err = fmt.Errorf("no databases to back up")
+			m.logs = msg.warnings
+			m.view = viewDone
+			m.resumePending = nil
+			return m, nil
+		}
+		return m.beginBackups()
 
-	case allBackupsDoneMsg:
-		// Stay on viewBackupRunning to show results with scrolling
+	case credentialCheckMsg:
+		if len(msg.failures) > 0 {
+			m.err = fmt.Errorf("backup aborted: %d database(s) rejected their credentials (fail_fast_on_auth)", len(msg.failures))
+			m.logs = nil
+			for _, name := range m.backupQueue {
+				if err := msg.failures[name]; err != nil {
+					m.logs = append(m.logs, errorStyle.Render("✗ "+name)+": "+err.Error())
+				}
+			}
+			m.view = viewDone
+			return m, nil
+		}
+		return m.checkDestinations()
+
+	case destinationCheckMsg:
+		if len(msg.failures) > 0 {
+			m.err = fmt.Errorf("backup aborted: %d database(s) have an inaccessible destination", len(msg.failures))
+			m.logs = nil
+			for _, name := range m.backupQueue {
+				if err := msg.failures[name]; err != nil {
+					m.logs = append(m.logs, errorStyle.Render("✗ "+name)+": "+err.Error())
+				}
+			}
+			m.view = viewDone
+			return m, nil
+		}
+		return m.preCheckRetention()
+
+	case retentionPreCheckMsg:
+		m.retentionPlan = msg.plan
+		m.retentionFailures = msg.failures
+		if err := m.retentionFailures[m.pruneDB]; m.pruneDB != "" && err != nil {
+			m.view = viewPrune
+			m.pruneResult = errorStyle.Render("✗ " + err.Error())
+			return m, nil
+		}
+		if m.pruneDB != "" && len(m.retentionPlan) == 0 {
+			m.view = viewPrune
+			m.pruneResult = dimStyle.Render("○ No old backups to delete")
+			return m, nil
+		}
+		if len(m.retentionPlan) > 0 && !m.retentionDryRun {
+			// Show confirmation screen
+			m.view = viewRetentionPreConfirm
+			m.cursor = 0
+			// Back from editing a policy, stay on the database that was edited
+			m.retentionDBPage = 0
+			dbs := m.retentionPlanDatabases()
+			if i := slices.Index(dbs, m.retentionEditDB); i >= 0 {
+				m.retentionDBPage = i / retentionDBsPerPage
+			} else if len(dbs) > 0 {
+				m.retentionEditDB = dbs[0]
+			}
+			return m, nil
+		}
+		// No files to delete (or only to report), start backups directly
+		return m.startBackups()
+
+	case backupStepDoneMsg:
+		return m.handleBackupStepDone(msg)
+
+	case restoreBatchPlanMsg:
+		if m.view != viewRestoreBatchPlan {
+			return m, nil
+		}
+		m.restoreBatchPlan = msg.plan
+		m.restoreBatchFailures = msg.failures
+		if len(m.restoreBatchPlan) == 0 {
+			m.err = fmt.Errorf("no database to restore")
+			m.logs = nil
+			for _, name := range m.restoreBatchNames() {
+				if err := msg.failures[name]; err != nil {
+					m.logs = append(m.logs, errorStyle.Render("✗ "+name)+": "+err.Error())
+				}
+			}
+			m.view = viewDone
+			return m, nil
+		}
+		m.restoreBatchForm = m.buildRestoreBatchConfirmForm()
+		m.view = viewRestoreBatchConfirm
+		return m, m.restoreBatchForm.Init()
+
+	case restoreBatchProgressMsg:
+		if !msg.ok {
+			m.restoreBatchProgress = nil
+			return m, nil
+		}
+		if state := m.restoreBatchStates[msg.progress.DBName]; state != nil {
+			state.update(msg.progress)
+		}
+		return m, m.waitForRestoreBatchProgress()
+
+	case pruneDoneMsg:
+		m.runLock.Release()
+		m.runLock = nil
+		m.pruneRunning = false
+		m.pruneResult = successStyle.Render("✓ " + msg.result.Message())
+		if len(msg.result.Failed) > 0 {
+			m.pruneResult = errorStyle.Render("✗ " + msg.result.Message())
+		}
+		m.pruneWarnings = msg.result.Warnings()
+		if msg.warning != "" {
+			m.pruneWarnings = append(m.pruneWarnings, msg.warning)
+		}
+		return m, nil
+
+	case sizeEstimateMsg:
+		if state := m.backupStates[msg.dbName]; state != nil {
+			state.estimatedSize = msg.size
+		}
+		return m, nil
+
+	case allBackupsDoneMsg:
+		// Stay on viewBackupRunning to show results with scrolling
 		// User can press enter or esc to go back
+		// The run ended, so there is nothing to resume
+		if !m.dryRun && m.cfg.Path() != "" {
+			runstate.Remove(runstate.PathFor(m.cfg.Path()))
+		}
+		m.runLock.Release()
+		m.runLock = nil
+		for _, state := range m.backupStates {
+			if state.cancel != nil {
+				state.cancel()
+			}
+		}
+		m.consistencyGroups.Close()
+		m.consistencyGroups = nil
+		if !m.dryRun {
+			return m, m.runWriteReportCmd()
+		}
+		return m, nil
+
+	case runReportMsg:
+		if msg.err != nil {
+			m.runReportStatus = errorStyle.Render(fmt.Sprintf("⚠ %v", msg.err))
+		} else if msg.path != "" {
+			m.runReportStatus = dimStyle.Render("Run report written to " + msg.path)
+		}
 		return m, nil
 
 	case fileListMsg:
 		m.backupFilesLoading = false
 		m.backupFiles = msg.files
+		m.backupFileDeltas = msg.deltas
 		m.err = msg.err
 		if m.err == nil {
 			m.view = viewRestoreFileSelect
 			m.cursor = 0
-			m.restoreFileFilter = ""
-			m.restoreFileFilteredList = m.backupFiles
+			m.filterRestoreFiles("")
 		}
 
 	case downloadProgressMsg:
@@ -1488,8 +2227,14 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case uploadProgressMsg:
 		return m.handleUploadProgress(msg)
 
+	case stallCheckMsg:
+		return m.checkStalls()
+
 	case startUploadMsg:
-		return m.startUploadWithProgress(msg.dbName, msg.backupPath, msg.dest)
+		if state := m.backupStates[msg.dbName]; state != nil && msg.result != nil {
+			state.result = msg.result
+		}
+		return m.startUploadWithProgress(msg.dbName, msg.backupPath, msg.dest, msg.atomic)
 
 	case restoreStepDoneMsg:
 		return m.handleRestoreStepDone(msg)
@@ -1497,7 +2242,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case testResultMsg:
 		m.testRunning = false
 		var result string
-		if msg.success {
+		if msg.skipped {
+			result = dimStyle.Render("○ " + msg.message)
+		} else if msg.success {
 			result = successStyle.Render("✓ " + msg.message)
 		} else {
 			result = errorStyle.Render("✗ " + msg.message)
@@ -1526,6 +2273,23 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, nil
 
+	case staleCheckMsg:
+		m.staleChecked = true
+		m.staleDBs = msg.stale
+		return m, nil
+
+	case rcloneUsageMsg:
+		// Ignore a result arriving after the user left the view
+		if m.view != viewRcloneUsage || msg.remote != m.selectedRemote {
+			return m, nil
+		}
+		if msg.err != nil {
+			m.rcloneUsage = errorStyle.Render("✗ " + msg.err.Error())
+		} else {
+			m.rcloneUsage = formatRemoteUsage(msg.dests, msg.usage, msg.space, msg.spaceErr)
+		}
+		return m, nil
+
 	case rcloneTestResultMsg:
 		m.testRunning = false
 		if msg.success {
@@ -1567,7 +2331,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 		// OAuth succeeded
-		rcloneconfig.SaveConfig()
+		saveRcloneConfig()
 		m.refreshRcloneRemotes()
 
 		if msg.isEdit {
@@ -1627,6 +2391,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.testConnResult = ""
 				return m, tea.Batch(m.spinner.Tick, m.runConnectionTestCmd())
 			} else if page == 2 {
+				// The root of an S3-like remote needs a bucket to test meaningfully
+				if remote, ok := s3RootRemote(expandDest(m.formData.dest)); ok {
+					return m.promptDestTestBucket(remote)
+				}
 				m.testRunning = true
 				m.testDestResult = ""
 				return m, tea.Batch(m.spinner.Tick, m.runDestinationTestCmd())
@@ -1705,6 +2473,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.testConnResult = ""
 				return m, tea.Batch(m.spinner.Tick, m.runConnectionTestCmd())
 			} else if page == 2 {
+				// The root of an S3-like remote needs a bucket to test meaningfully
+				if remote, ok := s3RootRemote(expandDest(m.formData.dest)); ok {
+					return m.promptDestTestBucket(remote)
+				}
 				m.testRunning = true
 				m.testDestResult = ""
 				return m, tea.Batch(m.spinner.Tick, m.runDestinationTestCmd())
@@ -1853,6 +2625,126 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, cmd
 	}
 
+	// Update DB destination test bucket form if active
+	if m.view == viewDBDestTestBucket && m.rcloneTestForm != nil {
+		// Handle Esc before form consumes it
+		if keyMsg, ok := msg.(tea.KeyMsg); ok {
+			if keyMsg.Type == tea.KeyEsc {
+				return m.goBack(), nil
+			}
+		}
+
+		form, cmd := m.rcloneTestForm.Update(msg)
+		if f, ok := form.(*huh.Form); ok {
+			m.rcloneTestForm = f
+		}
+
+		// Check if form completed - return to the DB form and start the test
+		if m.rcloneTestForm.State == huh.StateCompleted {
+			dest := m.destTestRemote + ":"
+			if m.rcloneTestFormData != nil {
+				dest += m.rcloneTestFormData.bucket
+			}
+			m = m.goBack()
+			m.testRunning = true
+			m.testDestResult = ""
+			return m, tea.Batch(m.spinner.Tick, testDestinationCmd(dest, backup.SettingsFrom(m.cfg).ConnectTimeoutFor(m.cfg.Databases[m.editingDB])))
+		}
+
+		// Check if form aborted
+		if m.rcloneTestForm.State == huh.StateAborted {
+			return m.goBack(), nil
+		}
+
+		return m, cmd
+	}
+
+	// Update the retention fields edited from the retention pre-confirm
+	if m.view == viewRetentionEdit && m.retentionEditForm != nil {
+		if keyMsg, ok := msg.(tea.KeyMsg); ok && keyMsg.Type == tea.KeyEsc {
+			return m.goBack(), nil
+		}
+
+		form, cmd := m.retentionEditForm.Update(msg)
+		if f, ok := form.(*huh.Form); ok {
+			m.retentionEditForm = f
+		}
+
+		if m.retentionEditForm.State == huh.StateCompleted {
+			return m.savePlanRetention()
+		}
+		if m.retentionEditForm.State == huh.StateAborted {
+			return m.goBack(), nil
+		}
+		return m, cmd
+	}
+
+	// Update preset name input if active
+	if m.view == viewBackupPresetSave && m.presetForm != nil {
+		if keyMsg, ok := msg.(tea.KeyMsg); ok && keyMsg.Type == tea.KeyEsc {
+			return m.goBack(), nil
+		}
+
+		form, cmd := m.presetForm.Update(msg)
+		if f, ok := form.(*huh.Form); ok {
+			m.presetForm = f
+		}
+
+		if m.presetForm.State == huh.StateCompleted {
+			return m.savePreset(), nil
+		}
+		if m.presetForm.State == huh.StateAborted {
+			return m.goBack(), nil
+		}
+		return m, cmd
+	}
+
+	// Update the point in time or confirmation input of a batch restore
+	if (m.view == viewRestoreBatchForm || m.view == viewRestoreBatchConfirm) && m.restoreBatchForm != nil {
+		if keyMsg, ok := msg.(tea.KeyMsg); ok && keyMsg.Type == tea.KeyEsc {
+			return m.goBack(), nil
+		}
+
+		form, cmd := m.restoreBatchForm.Update(msg)
+		if f, ok := form.(*huh.Form); ok {
+			m.restoreBatchForm = f
+		}
+
+		if m.restoreBatchForm.State == huh.StateCompleted {
+			if m.view == viewRestoreBatchConfirm {
+				return m.startRestoreBatch()
+			}
+			m.restoreBatchAt, _ = orchestrator.ParseRestorePoint(*m.restoreBatchInput)
+			m.restoreBatchForm = nil
+			m.view = viewRestoreBatchPlan
+			return m, tea.Batch(m.spinner.Tick, m.planRestoreBatch())
+		}
+		if m.restoreBatchForm.State == huh.StateAborted {
+			return m.goBack(), nil
+		}
+		return m, cmd
+	}
+
+	// Update rclone quick edit input if active
+	if m.view == viewRcloneQuickEditField && m.quickEditForm != nil {
+		if keyMsg, ok := msg.(tea.KeyMsg); ok && keyMsg.Type == tea.KeyEsc {
+			return m.goBack(), nil
+		}
+
+		form, cmd := m.quickEditForm.Update(msg)
+		if f, ok := form.(*huh.Form); ok {
+			m.quickEditForm = f
+		}
+
+		if m.quickEditForm.State == huh.StateCompleted {
+			return m.saveQuickEdit(), nil
+		}
+		if m.quickEditForm.State == huh.StateAborted {
+			return m.goBack(), nil
+		}
+		return m, cmd
+	}
+
 	// Update rclone test bucket form if active
 	if m.view == viewRcloneTestBucket && m.rcloneTestForm != nil {
 		// Handle Esc before form consumes it
@@ -1896,21 +2788,46 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 func (m model) goBack() model {
 	switch m.view {
-	case viewBackupSelect, viewRestoreDBSelect, viewDBList, viewDone:
+	case viewBackupSelect, viewBackupPresetSelect, viewRestoreDBSelect, viewDBList, viewDone:
 		m.view = viewMainMenu
 		m.cursor = 0
 		m.err = nil
 		m.logs = nil
 	case viewRetentionPreConfirm:
+		if m.pruneDB != "" {
+			return m.endPrune()
+		}
 		m.view = viewBackupSelect
 		m.cursor = 0
 		m.retentionPlan = nil
+		m.retentionFailures = nil
+	case viewRetentionEdit:
+		m.view = viewRetentionPreConfirm
+		m.retentionEditForm = nil
+	case viewRestoreBatchForm:
+		m.view = viewRestoreDBSelect
+		m.restoreBatchForm = nil
+		m.restoreBatchInput = nil
+	case viewRestoreBatchPlan, viewRestoreBatchConfirm:
+		m.view = viewRestoreBatchForm
+		m.restoreBatchForm = m.buildRestoreBatchAtForm()
+	case viewRestoreBatchRunning:
+		if m.restoreBatchDone() {
+			return m.endRestoreBatch()
+		}
 	case viewRestoreSourceSelect:
 		m.view = viewRestoreDBSelect
 		m.cursor = 0
+	case viewRestoreCloneSourceSelect:
+		m.view = viewRestoreSourceSelect
+		m.cursor = restoreSourceOtherDB
 	case viewRestoreFileSelect, viewRestoreLocalInput:
 		m.view = viewRestoreSourceSelect
 		m.cursor = 0
+		if m.cloneSourceDB != "" {
+			m.view = viewRestoreCloneSourceSelect
+			m.cursor = slices.Index(m.cloneCandidates, m.cloneSourceDB)
+		}
 		m.restoreFormData = nil
 	case viewRestoreConfirm:
 		if m.isLocalRestore {
@@ -1964,8 +2881,7 @@ func (m model) goBack() model {
 	case viewRcloneAddType:
 		m.view = viewRcloneList
 		m.cursor = len(m.rcloneRemoteFilteredList) // back to Add button
-		m.rcloneFilter = ""
-		m.rcloneFilteredList = m.rcloneBackends
+		m.filterRcloneBackends("")
 		m.rcloneRemoteFilter = ""
 		m.rcloneRemoteFilteredList = m.rcloneRemotes
 	case viewRcloneAddForm:
@@ -1986,6 +2902,23 @@ func (m model) goBack() model {
 	case viewRcloneDeleteConfirm:
 		m.view = viewRcloneActions
 		m.cursor = rcloneActionDelete
+	case viewRcloneQuickEdit:
+		m.view = viewRcloneActions
+		m.cursor = rcloneActionQuickEdit
+		m.quickEditOptions = nil
+		m.quickEditStatus = ""
+	case viewRcloneQuickEditField:
+		m.view = viewRcloneQuickEdit
+		m.quickEditForm = nil
+		m.quickEditValue = nil
+	case viewBackupPresetSave:
+		m.view = viewBackupSelect
+		m.presetForm = nil
+		m.presetName = nil
+	case viewDBDestTestBucket:
+		m.view = m.destTestReturnView
+		m.rcloneTestFormData = nil
+		m.rcloneTestForm = nil
 	case viewRcloneTestBucket:
 		// Return to form if we came from there, otherwise to actions menu
 		if m.rcloneForm != nil {
@@ -2005,6 +2938,10 @@ func (m model) goBack() model {
 			m.cursor = rcloneActionTest
 		}
 		m.rcloneTestResult = ""
+	case viewRcloneUsage:
+		m.view = viewRcloneActions
+		m.cursor = rcloneActionUsage
+		m.rcloneUsage = ""
 	case viewRcloneOAuth:
 		m.view = viewRcloneAddForm
 		m.oauthStatus = ""
@@ -2027,7 +2964,13 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 			m.cursor = 0
 			m.backupFilter = ""
 			m.backupFilteredList = m.dbNames
+			m.presetStatus = ""
+			if len(m.cfg.Presets) > 0 {
+				m.view = viewBackupPresetSelect
+				m.presetNames = slices.Sorted(maps.Keys(m.cfg.Presets))
+			}
 		case menuRestore:
+			m.restoreThenBackup = false
 			if len(m.dbNames) == 0 {
 				m.err = fmt.Errorf("no databases configured")
 				m.view = viewDone
@@ -2037,6 +2980,9 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 			m.cursor = 0
 			m.restoreDBFilter = ""
 			m.restoreDBFilteredList = m.dbNames
+			m.restoreBatchSelected = nil
+			m.restoreBatchSources = nil
+			m.restoreBatchAt = time.Time{}
 		case menuManage:
 			m.view = viewDBList
 			m.cursor = 0
@@ -2051,6 +2997,14 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 			return m, tea.Quit
 		}
 
+	case viewBackupPresetSelect:
+		// The last entry chooses the databases by hand
+		if m.cursor < len(m.presetNames) {
+			return m.applyPreset(m.presetNames[m.cursor]), nil
+		}
+		m.view = viewBackupSelect
+		m.cursor = 0
+
 	case viewAddDBType:
 		types := []string{"file", "mysql", "postgres"}
 		m.addDBType = types[m.cursor]
@@ -2059,51 +3013,19 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 		return m, m.addDBForm.Init()
 
 	case viewBackupSelect:
-		// Run Backup is after filtered databases, retention toggle, and dry-run toggle
-		if m.cursor == len(m.backupFilteredList)+2 {
-			// Build ordered queue of selected databases (from ALL databases, not just filtered)
-			m.backupQueue = nil
-			for _, name := range m.dbNames {
-				if m.selected[name] {
-					m.backupQueue = append(m.backupQueue, name)
-				}
-			}
-			if len(m.backupQueue) == 0 {
-				m.err = fmt.Errorf("no databases selected")
-				m.view = viewDone
-				return m, nil
-			}
-
-			// Reset cursor for backup running view
-			m.cursor = 0
-
-			// Skip retention pre-check if dry-run or skip-retention is enabled
-			if m.dryRun || m.skipRetention {
-				return m.startBackups()
-			}
-
-			// Check if any selected database has retention policy
-			hasRetention := false
-			for _, name := range m.backupQueue {
-				db := m.cfg.Databases[name]
-				if db.Retention.KeepLast > 0 || db.Retention.KeepDays > 0 || db.Retention.MaxSizeMB > 0 {
-					hasRetention = true
-					break
-				}
-			}
-
-			if hasRetention {
-				// Pre-check retention policies before starting backups
-				m.view = viewRetentionPreCheck
-				m.retentionPlan = nil
-				return m, tea.Batch(m.spinner.Tick, m.runRetentionPreCheck())
-			}
-
-			// No retention to check, start backups directly
-			return m.startBackups()
+		// Run Backup is after filtered databases, retention toggle, retention dry-run
+		// toggle, dry-run toggle, and destination test toggle
+		if m.cursor == len(m.backupFilteredList)+4 {
+			return m.runSelectedBackups()
 		}
 
 	case viewRestoreDBSelect:
+		// Databases picked with space are restored together
+		if len(m.restoreBatchNames()) > 0 {
+			m.restoreBatchForm = m.buildRestoreBatchAtForm()
+			m.view = viewRestoreBatchForm
+			return m, m.restoreBatchForm.Init()
+		}
 		if m.cursor < len(m.restoreDBFilteredList) {
 			m.selectedDB = m.restoreDBFilteredList[m.cursor]
 			m.view = viewRestoreSourceSelect
@@ -2111,14 +3033,22 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 		}
 
 	case viewRestoreSourceSelect:
-		if m.cursor == restoreSourceRemote {
+		m.cloneSourceDB = ""
+		switch m.cursor {
+		case restoreSourceRemote:
 			// From remote
 			m.isLocalRestore = false
 			m.view = viewRestoreFileSelect
 			m.backupFilesLoading = true
 			m.backupFiles = nil
+			m.backupFilesLimit = m.cfg.RestorePickerLimit()
 			return m, tea.Batch(m.spinner.Tick, m.fetchBackupFiles())
-		} else {
+		case restoreSourceOtherDB:
+			// From another database's backups (e.g. refresh staging from prod)
+			m.cloneCandidates = m.cloneCandidatesFor(m.selectedDB)
+			m.view = viewRestoreCloneSourceSelect
+			m.cursor = 0
+		default:
 			// From local file
 			m.isLocalRestore = true
 			m.view = viewRestoreLocalInput
@@ -2127,12 +3057,28 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 			return m, m.restorePathForm.Init()
 		}
 
+	case viewRestoreCloneSourceSelect:
+		if m.cursor < len(m.cloneCandidates) {
+			m.cloneSourceDB = m.cloneCandidates[m.cursor]
+			m.isLocalRestore = false
+			m.view = viewRestoreFileSelect
+			m.backupFilesLoading = true
+			m.backupFiles = nil
+			m.backupFilesLimit = m.cfg.RestorePickerLimit()
+			return m, tea.Batch(m.spinner.Tick, m.fetchBackupFiles())
+		}
+
 	case viewRestoreFileSelect:
 		if m.cursor < len(m.restoreFileFilteredList) {
 			m.selectedFile = m.restoreFileFilteredList[m.cursor].Name
 			m.selectedFileSize = m.restoreFileFilteredList[m.cursor].Size
 			m.view = viewRestoreConfirm
 			m.cursor = 0
+		} else if m.backupFilesMore {
+			// "Load more": show the next batch of older backups, the cursor staying on
+			// the first of them
+			m.backupFilesLimit += m.cfg.RestorePickerLimit()
+			m.filterRestoreFiles(m.restoreFileFilter)
 		}
 
 	case viewRestoreConfirm:
@@ -2155,7 +3101,7 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 			m.editingDB = m.dbFilteredList[m.cursor]
 			m.view = viewDBActions
 			m.cursor = 0
-		} else {
+		} else if !m.readOnly {
 			// Add new database (cursor == len(dbFilteredList))
 			m.view = viewAddDBType
 			m.cursor = 0
@@ -2164,6 +3110,9 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 	case viewDBActions:
 		switch m.cursor {
 		case dbActionEdit:
+			if m.readOnly {
+				return m, nil
+			}
 			m.populateFormFromDB(m.editingDB)
 			m.addDBForm = m.buildAddDBForm(false)
 			m.view = viewEditDBForm
@@ -2173,7 +3122,12 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 			m.testConnResult = ""
 			m.testDestResult = ""
 			return m, m.runDBTestCmd()
+		case dbActionPrune:
+			return m.startPrune()
 		case dbActionDelete:
+			if m.readOnly {
+				return m, nil
+			}
 			m.view = viewDeleteConfirm
 			m.cursor = confirmNo // Default to "No, go back"
 		case dbActionBack:
@@ -2182,6 +3136,12 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 			m.editingDB = ""
 		}
 
+	case viewEditDBSaveConfirm:
+		if m.cursor == confirmYes { // Yes, save
+			return m.commitEditedDatabase()
+		}
+		return m.returnToEditForm()
+
 	case viewDeleteConfirm:
 		if m.cursor == confirmYes { // Yes, delete
 			return m.deleteDatabase()
@@ -2191,11 +3151,18 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 		}
 
 	case viewRetentionPreConfirm:
+		if m.pruneDB != "" {
+			if m.cursor == confirmYes {
+				return m.runPrune()
+			}
+			return m.endPrune(), nil
+		}
 		if m.cursor == confirmYes { // Yes, proceed with retention
 			return m.startBackups()
 		} else { // No, skip retention
 			m.skipRetention = true
 			m.retentionPlan = nil
+			m.retentionFailures = nil
 			return m.startBackups()
 		}
 
@@ -2228,12 +3195,24 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 				m.view = viewRcloneAddForm
 				return m, m.rcloneForm.Init()
 			}
+		case rcloneActionQuickEdit:
+			backend, _ := fs.Find(getRcloneRemoteType(m.selectedRemote))
+			if backend != nil {
+				m.quickEditOptions = quickEditOptions(backend, m.loadRcloneRemoteValues(m.selectedRemote))
+				m.quickEditStatus = ""
+				m.view = viewRcloneQuickEdit
+				m.cursor = 0
+			}
 		case rcloneActionTest:
 			m.view = viewRcloneTestBucket
 			m.rcloneTestFormData = nil // Reset so buildRcloneTestForm allocates fresh
 			m.rcloneTestResult = ""
 			m.rcloneTestForm = m.buildRcloneTestForm()
 			return m, m.rcloneTestForm.Init()
+		case rcloneActionUsage:
+			m.view = viewRcloneUsage
+			m.rcloneUsage = ""
+			return m, tea.Batch(m.spinner.Tick, m.fetchRcloneUsage())
 		case rcloneActionDelete:
 			m.view = viewRcloneDeleteConfirm
 			m.cursor = confirmNo // Default to "No, go back"
@@ -2245,6 +3224,14 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 			m.rcloneRemoteFilteredList = m.rcloneRemotes
 		}
 
+	case viewRcloneQuickEdit:
+		if m.cursor < len(m.quickEditOptions) {
+			m.quickEditForm = m.buildQuickEditForm(m.quickEditOptions[m.cursor])
+			m.quickEditStatus = ""
+			m.view = viewRcloneQuickEditField
+			return m, m.quickEditForm.Init()
+		}
+
 	case viewRcloneAddType:
 		if len(m.rcloneFilteredList) > 0 && m.cursor < len(m.rcloneFilteredList) {
 			m.selectedBackend = m.rcloneFilteredList[m.cursor]
@@ -2259,7 +3246,7 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 	case viewRcloneDeleteConfirm:
 		if m.cursor == confirmYes {
 			rcloneconfig.DeleteRemote(m.selectedRemote)
-			rcloneconfig.SaveConfig()
+			saveRcloneConfig()
 			m.refreshRcloneRemotes()
 			m.view = viewRcloneList
 			m.cursor = 0
@@ -2269,13 +3256,24 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 			m.cursor = 0
 		}
 
+	case viewRestoreBatchRunning:
+		if m.restoreBatchDone() {
+			return m.endRestoreBatch(), nil
+		}
+
 	case viewBackupRunning:
 		// If all backups done, allow enter to go back to menu
 		if m.allBackupsDone() {
 			m.view = viewMainMenu
 			m.cursor = 0
 			m.backupQueue = nil
+			m.backupCfg = nil
+			m.backupWarnings = nil
 			m.backupStates = nil
+			if m.cfg.StaleCheck {
+				// The run may have backed up databases the last check found stale
+				return m, m.checkStale()
+			}
 		}
 
 	case viewDone:
@@ -2293,8 +3291,12 @@ func (m model) maxCursor() int {
 	case viewMainMenu:
 		return menuExit // Backup, Restore, Manage DBs, Manage rclone, Exit
 	case viewBackupSelect:
-		// Filtered DBs + retention toggle + dry-run toggle + Run button
-		return len(m.backupFilteredList) + 2
+		// Filtered DBs + retention toggle + retention dry-run toggle + dry-run toggle +
+		// destination test toggle + Run button
+		return len(m.backupFilteredList) + 4
+	case viewBackupPresetSelect:
+		// Presets + choose databases
+		return len(m.presetNames)
 	case viewRestoreDBSelect:
 		// Filtered DBs
 		if len(m.restoreDBFilteredList) == 0 {
@@ -2302,14 +3304,22 @@ func (m model) maxCursor() int {
 		}
 		return len(m.restoreDBFilteredList) - 1
 	case viewRestoreSourceSelect:
-		return restoreSourceLocal // Remote or Local
+		return restoreSourceOtherDB // Remote, Local or another database
+	case viewRestoreCloneSourceSelect:
+		if len(m.cloneCandidates) == 0 {
+			return 0
+		}
+		return len(m.cloneCandidates) - 1
 	case viewRestoreFileSelect:
-		// Filtered backup files
+		// Filtered backup files + "Load more" when older backups were left out
+		if m.backupFilesMore {
+			return len(m.restoreFileFilteredList)
+		}
 		if len(m.restoreFileFilteredList) == 0 {
 			return 0
 		}
 		return len(m.restoreFileFilteredList) - 1
-	case viewRestoreConfirm, viewDeleteConfirm, viewRetentionPreConfirm, viewRcloneDeleteConfirm:
+	case viewRestoreConfirm, viewEditDBSaveConfirm, viewDeleteConfirm, viewRetentionPreConfirm, viewRcloneDeleteConfirm:
 		return confirmNo // Yes or No
 	case viewAddDBType:
 		return dbTypePostgres // file, mysql, postgres
@@ -2322,13 +3332,23 @@ func (m model) maxCursor() int {
 		// Filtered remotes + Add button
 		return len(m.rcloneRemoteFilteredList) // Add button at position len(filtered list)
 	case viewRcloneActions:
-		return rcloneActionBack // Edit, Test, Delete, Back
+		return rcloneActionBack // Edit, Quick edit, Test, Usage, Delete, Back
+	case viewRcloneQuickEdit:
+		if len(m.quickEditOptions) == 0 {
+			return 0
+		}
+		return len(m.quickEditOptions) - 1
 	case viewRcloneAddType:
 		// Filtered backends list
 		if len(m.rcloneFilteredList) == 0 {
 			return 0
 		}
 		return len(m.rcloneFilteredList) - 1
+	case viewRestoreBatchRunning:
+		if len(m.restoreBatchPlan) == 0 {
+			return 0
+		}
+		return len(m.restoreBatchPlan) - 1
 	case viewBackupRunning:
 		// Number of databases being backed up (for scroll navigation)
 		if len(m.backupQueue) == 0 {
@@ -2339,7 +3359,19 @@ func (m model) maxCursor() int {
 	return 0
 }
 
+// missingUtilities lists the tools the database in the add or edit form needs that are
+// not in PATH: its clients, and gpg when an edited entry's backups are encrypted
+func (m model) missingUtilities() []string {
+	db := config.Database{Type: m.addDBType}
+	if m.view == viewEditDBForm {
+		db.Encryption = m.cfg.Databases[m.editingDB].Encryption
+	}
+	return backup.CheckRequiredUtilities(db)
+}
+
 func (m model) View() string {
+	defer logPanic()
+
 	if m.quitting {
 		return ""
 	}
@@ -2358,16 +3390,34 @@ func (m model) View() string {
 		s.WriteString(m.renderMainMenu())
 	case viewBackupSelect:
 		s.WriteString(m.renderBackupSelect())
+	case viewBackupPresetSelect:
+		s.WriteString(m.renderBackupPresetSelect())
+	case viewBackupPresetSave:
+		s.WriteString(m.renderBackupPresetSave())
+	case viewStale:
+		s.WriteString(m.renderStale())
+	case viewBackupExpand:
+		s.WriteString(m.renderBackupExpand())
+	case viewCredentialCheck:
+		s.WriteString(m.renderCredentialCheck())
+	case viewDestinationCheck:
+		s.WriteString(m.renderDestinationCheck())
 	case viewRetentionPreCheck:
 		s.WriteString(m.renderRetentionPreCheck())
 	case viewRetentionPreConfirm:
 		s.WriteString(m.renderRetentionPreConfirm())
+	case viewRetentionEdit:
+		s.WriteString(m.renderRetentionEdit())
 	case viewBackupRunning:
 		s.WriteString(m.renderBackupRunning())
+	case viewBackupError:
+		s.WriteString(m.renderBackupError())
 	case viewRestoreDBSelect:
 		s.WriteString(m.renderRestoreDBSelect())
 	case viewRestoreSourceSelect:
 		s.WriteString(m.renderRestoreSourceSelect())
+	case viewRestoreCloneSourceSelect:
+		s.WriteString(m.renderRestoreCloneSourceSelect())
 	case viewRestoreFileSelect:
 		s.WriteString(m.renderRestoreFileSelect())
 	case viewRestoreLocalInput:
@@ -2376,15 +3426,23 @@ func (m model) View() string {
 		s.WriteString(m.renderRestoreConfirm())
 	case viewRestoreRunning:
 		s.WriteString(m.renderRestoreRunning())
+	case viewRestoreBatchForm:
+		s.WriteString(m.renderRestoreBatchForm())
+	case viewRestoreBatchPlan:
+		s.WriteString(m.renderRestoreBatchPlan())
+	case viewRestoreBatchConfirm:
+		s.WriteString(m.renderRestoreBatchConfirm())
+	case viewRestoreBatchRunning:
+		s.WriteString(m.renderRestoreBatchRunning())
 	case viewAddDBType:
 		s.WriteString(m.renderAddDBType())
 	case viewAddDBForm:
 		s.WriteString(fmt.Sprintf("Configure %s database:\n\n", selectedStyle.Render(m.addDBType)))
-		for _, warning := range checkRequiredUtilities(m.addDBType) {
-			s.WriteString(errorStyle.Render("⚠ " + warning))
-			s.WriteString("\n")
-		}
-		if len(checkRequiredUtilities(m.addDBType)) > 0 {
+		if missing := m.missingUtilities(); len(missing) > 0 {
+			for _, warning := range missing {
+				s.WriteString(errorStyle.Render("⚠ " + warning))
+				s.WriteString("\n")
+			}
 			s.WriteString("\n")
 		}
 		if m.formError != "" {
@@ -2431,11 +3489,11 @@ func (m model) View() string {
 		s.WriteString(m.renderDBActions())
 	case viewEditDBForm:
 		s.WriteString(fmt.Sprintf("Edit %s database:\n\n", selectedStyle.Render(m.editingDB)))
-		for _, warning := range checkRequiredUtilities(m.addDBType) {
-			s.WriteString(errorStyle.Render("⚠ " + warning))
-			s.WriteString("\n")
-		}
-		if len(checkRequiredUtilities(m.addDBType)) > 0 {
+		if missing := m.missingUtilities(); len(missing) > 0 {
+			for _, warning := range missing {
+				s.WriteString(errorStyle.Render("⚠ " + warning))
+				s.WriteString("\n")
+			}
 			s.WriteString("\n")
 		}
 		if m.formError != "" {
@@ -2474,10 +3532,14 @@ func (m model) View() string {
 		if m.addDBForm != nil {
 			s.WriteString(m.addDBForm.View())
 		}
+	case viewEditDBSaveConfirm:
+		s.WriteString(m.renderEditSaveConfirm())
 	case viewDeleteConfirm:
 		s.WriteString(m.renderDeleteConfirm())
 	case viewDBTest:
 		s.WriteString(m.renderDBTest())
+	case viewPrune:
+		s.WriteString(m.renderPrune())
 	case viewRcloneList:
 		s.WriteString(m.renderRcloneList())
 	case viewRcloneActions:
@@ -2490,10 +3552,18 @@ func (m model) View() string {
 		s.WriteString(m.renderConfirmExit())
 	case viewRcloneDeleteConfirm:
 		s.WriteString(m.renderRcloneDeleteConfirm())
+	case viewRcloneQuickEdit:
+		s.WriteString(m.renderRcloneQuickEdit())
+	case viewRcloneQuickEditField:
+		s.WriteString(m.renderRcloneQuickEditField())
 	case viewRcloneTestBucket:
 		s.WriteString(m.renderRcloneTestBucket())
+	case viewDBDestTestBucket:
+		s.WriteString(m.renderDBDestTestBucket())
 	case viewRcloneTest:
 		s.WriteString(m.renderRcloneTest())
+	case viewRcloneUsage:
+		s.WriteString(m.renderRcloneUsage())
 	case viewRcloneOAuth:
 		s.WriteString(m.renderRcloneOAuth())
 	case viewDone:
@@ -2503,53 +3573,121 @@ func (m model) View() string {
 	s.WriteString("\n")
 	switch m.view {
 	case viewMainMenu:
-		s.WriteString(dimStyle.Render("↑/↓: navigate • enter: select • esc: quit"))
+		help := "↑/↓: navigate • enter: select"
+		if len(m.staleDBs) > 0 {
+			help += " • s: stale backups"
+		}
+		if m.interruptedRun != nil {
+			help += " • r: resume interrupted run • d: dismiss"
+		}
+		s.WriteString(dimStyle.Render(help + " • esc: quit"))
 	case viewBackupSelect:
-		s.WriteString(dimStyle.Render("type to filter • ↑/↓: navigate • space: toggle • enter: run • esc: back"))
-	case viewRestoreDBSelect, viewRestoreFileSelect:
-		s.WriteString(dimStyle.Render("type to filter • ↑/↓: navigate • enter: select • esc: back"))
+		s.WriteString(dimStyle.Render("type to filter • ↑/↓/pgup/pgdn: navigate • alt+letter: jump • space: toggle • ctrl+a: toggle all • ctrl+s: save as preset • enter: run • esc: back"))
+	case viewBackupPresetSelect:
+		s.WriteString(dimStyle.Render("↑/↓: navigate • enter: select • esc: back"))
+	case viewBackupPresetSave:
+		s.WriteString(dimStyle.Render("enter: save • esc: back"))
+	case viewRestoreDBSelect:
+		if len(m.restoreBatchNames()) > 0 {
+			s.WriteString(dimStyle.Render("type to filter • ↑/↓/pgup/pgdn: navigate • alt+letter: jump • space: toggle • ctrl+o: restore from another database • enter: restore selected • esc: back"))
+		} else {
+			s.WriteString(dimStyle.Render("type to filter • ↑/↓/pgup/pgdn: navigate • alt+letter: jump • space: select several • enter: select • esc: back"))
+		}
+	case viewRestoreBatchForm:
+		s.WriteString(dimStyle.Render("enter: continue • esc: back"))
+	case viewRestoreBatchPlan:
+		s.WriteString(dimStyle.Render("Listing backups..."))
+	case viewRestoreBatchConfirm:
+		s.WriteString(dimStyle.Render("enter: restore • esc: back"))
+	case viewRestoreBatchRunning:
+		if m.restoreBatchDone() {
+			s.WriteString(dimStyle.Render("↑/↓: scroll • enter: back to menu"))
+		} else {
+			s.WriteString(dimStyle.Render("↑/↓: scroll • waiting for restores to complete..."))
+		}
+	case viewRestoreFileSelect:
+		s.WriteString(dimStyle.Render("type to filter (>7d older, <12h newer, <2024-01-01 before date) • ↑/↓/pgup/pgdn: navigate • alt+letter: jump • enter: select • ctrl+r: refresh • esc: back"))
 	case viewRestoreLocalInput:
 		s.WriteString(dimStyle.Render("type path • enter: confirm • esc: back"))
+	case viewRestoreConfirm:
+		s.WriteString(dimStyle.Render("↑/↓: select • b: toggle backup after restore • enter: confirm • esc: back"))
 	case viewAddDBForm, viewEditDBForm:
 		s.WriteString(dimStyle.Render("↑/↓/enter: navigate • tab: cycle • ctrl+s: save • ctrl+t: test • esc: back"))
 	case viewAddDBFormConfirmExit, viewEditDBFormConfirmExit, viewRcloneAddFormConfirmExit:
 		s.WriteString(dimStyle.Render("↑/↓: select • enter: confirm • esc: cancel"))
 	case viewDBList:
-		s.WriteString(dimStyle.Render("type to filter • ↑/↓: navigate • enter: select • esc: back"))
+		s.WriteString(dimStyle.Render("type to filter • ↑/↓/pgup/pgdn: navigate • alt+letter: jump • enter: select • esc: back"))
+	case viewBackupExpand:
+		s.WriteString(dimStyle.Render("Listing databases..."))
+	case viewCredentialCheck:
+		s.WriteString(dimStyle.Render("Testing connections..."))
+	case viewDestinationCheck:
+		s.WriteString(dimStyle.Render("Testing destinations..."))
 	case viewRetentionPreCheck:
 		s.WriteString(dimStyle.Render("Checking retention policies..."))
 	case viewRetentionPreConfirm:
-		s.WriteString(dimStyle.Render("←/→: page • ↑/↓: select • enter: confirm • esc: back"))
+		if m.pruneDB == "" && !m.readOnly {
+			s.WriteString(dimStyle.Render("←/→: page • tab: pick database • e: edit its retention • ↑/↓: select • enter: confirm • esc: back"))
+		} else {
+			s.WriteString(dimStyle.Render("←/→: page • ↑/↓: select • enter: confirm • esc: back"))
+		}
+	case viewRetentionEdit:
+		s.WriteString(dimStyle.Render("enter: save and check again • esc: back"))
 	case viewBackupRunning:
 		if m.allBackupsDone() {
-			s.WriteString(dimStyle.Render("↑/↓: scroll • enter: back to menu"))
+			s.WriteString(dimStyle.Render("↑/↓: scroll • e: view error • enter: back to menu"))
 		} else {
-			s.WriteString(dimStyle.Render("↑/↓: scroll • waiting for backups to complete..."))
+			s.WriteString(dimStyle.Render("↑/↓: select • x: cancel selected • r: retry stalled upload • e: view error • waiting for backups to complete..."))
 		}
+	case viewBackupError:
+		s.WriteString(dimStyle.Render("↑/↓/pgup/pgdn: scroll • esc: back to progress"))
 	case viewRestoreRunning:
-		// No help text needed - progress is shown in main view
+		// Progress is shown in the main view; only a download can be stopped
+		if m.downloading() {
+			s.WriteString(dimStyle.Render("x: cancel download"))
+		}
 	case viewDone:
 		s.WriteString(dimStyle.Render("enter: continue"))
 	case viewRcloneList:
-		s.WriteString(dimStyle.Render("type to filter • ↑/↓: navigate • enter: select • a: add • esc: back"))
+		s.WriteString(dimStyle.Render("type to filter • ↑/↓/pgup/pgdn: navigate • alt+letter: jump • enter: select • a: add • ctrl+f: favorite • esc: back"))
 	case viewRcloneAddType:
-		s.WriteString(dimStyle.Render("type to filter • ↑/↓: navigate • enter: select • esc: back"))
+		if m.rclonePreferredCount > 0 {
+			s.WriteString(dimStyle.Render("type to filter • ↑/↓/pgup/pgdn: navigate • alt+letter: jump • enter: select • ctrl+a: preferred/all • esc: back"))
+		} else {
+			s.WriteString(dimStyle.Render("type to filter • ↑/↓/pgup/pgdn: navigate • alt+letter: jump • enter: select • esc: back"))
+		}
 	case viewRcloneAddForm:
 		s.WriteString(dimStyle.Render("↑/↓/enter: navigate • tab: cycle • ctrl+s: save • ctrl+t: test • esc: back"))
-	case viewRcloneTestBucket:
+	case viewRcloneTestBucket, viewDBDestTestBucket:
 		s.WriteString(dimStyle.Render("enter: test • esc: back"))
+	case viewRcloneQuickEdit:
+		s.WriteString(dimStyle.Render("↑/↓/pgup/pgdn: navigate • enter: change • esc: back"))
+	case viewRcloneQuickEditField:
+		s.WriteString(dimStyle.Render("enter: save • esc: back"))
 	case viewDBTest:
 		if !m.testRunning {
-			s.WriteString(dimStyle.Render("enter: continue"))
+			s.WriteString(dimStyle.Render("ctrl+r: test again • enter: continue"))
 		} else {
 			s.WriteString(dimStyle.Render("Testing..."))
 		}
+	case viewPrune:
+		if !m.pruneRunning {
+			s.WriteString(dimStyle.Render("enter: continue"))
+		}
 	case viewRcloneTest:
 		if m.rcloneTestResult != "" {
 			s.WriteString(dimStyle.Render("enter: continue"))
 		} else {
 			s.WriteString(dimStyle.Render("esc: cancel"))
 		}
+	case viewStale:
+		s.WriteString(dimStyle.Render("enter: continue"))
+	case viewRcloneUsage:
+		if m.rcloneUsage != "" {
+			s.WriteString(dimStyle.Render("enter: continue"))
+		} else {
+			s.WriteString(dimStyle.Render("esc: back"))
+		}
 	case viewRcloneOAuth:
 		if m.oauthErr != nil {
 			s.WriteString(dimStyle.Render("enter: dismiss • esc: cancel"))
@@ -2572,6 +3710,9 @@ func (m model) renderMainMenu() string {
 	var s strings.Builder
 
 	cfgPath := collapsePath(m.cfg.Path())
+	if profile := m.cfg.Profile(); profile != "" {
+		s.WriteString(fmt.Sprintf("Profile: %s\n", selectedStyle.Render(profile)))
+	}
 	dbCount := len(m.dbNames)
 	if dbCount == 0 {
 		s.WriteString(dimStyle.Render(fmt.Sprintf("No databases configured (config: %s)", cfgPath)))
@@ -2580,9 +3721,33 @@ func (m model) renderMainMenu() string {
 	} else {
 		s.WriteString(dimStyle.Render(fmt.Sprintf("%d databases configured (config: %s)", dbCount, cfgPath)))
 	}
+	if m.diagnosticsLog != "" {
+		s.WriteString("\n" + dimStyle.Render(fmt.Sprintf("Diagnostics: %s", collapsePath(m.diagnosticsLog))))
+	}
 	s.WriteString("\n\n")
+	if m.readOnly {
+		s.WriteString(renderReadOnlyBanner())
+	}
 
-	s.WriteString("What would you like to do?\n\n")
+	switch {
+	case len(m.staleDBs) == 1:
+		s.WriteString(errorStyle.Render("⚠ 1 database hasn't been backed up recently") + dimStyle.Render(" (s: details)") + "\n\n")
+	case len(m.staleDBs) > 1:
+		s.WriteString(errorStyle.Render(fmt.Sprintf("⚠ %d databases haven't been backed up recently", len(m.staleDBs))) + dimStyle.Render(" (s: details)") + "\n\n")
+	case m.cfg.StaleCheck && !m.staleChecked:
+		s.WriteString(dimStyle.Render(m.spinner.View()+" Checking for databases without a recent backup...") + "\n\n")
+	}
+
+	if run := m.interruptedRun; run != nil {
+		notice := fmt.Sprintf("⚠ The backup run started %s was interrupted: %d completed, %d pending",
+			run.Started.Format("2006-01-02 15:04"), len(run.Names(runstate.Done)), len(run.Unfinished()))
+		if failed := len(run.Names(runstate.Failed)); failed > 0 {
+			notice += fmt.Sprintf(", %d failed", failed)
+		}
+		s.WriteString(errorStyle.Render(notice) + dimStyle.Render(" (r: resume pending • d: dismiss)") + "\n\n")
+	}
+
+	s.WriteString("What would you like to do?\n\n")
 
 	items := []string{"Backup databases", "Restore a database", "Manage databases", "Manage rclone destinations", "Exit"}
 	for i, item := range items {
@@ -2614,7 +3779,7 @@ func (m model) renderBackupSelect() string {
 		s.WriteString("\n")
 	} else {
 		// Show databases with scrolling
-		maxVisible := 10
+		maxVisible := listMaxVisible
 		start, end := calcScrollWindow(m.cursor, len(m.backupFilteredList), maxVisible)
 
 		// Scroll indicator if there are items above
@@ -2676,8 +3841,24 @@ func (m model) renderBackupSelect() string {
 	}
 	s.WriteString(fmt.Sprintf("%s%s\n", cursor, retentionLabel))
 
-	// Dry-run toggle (index = len(backupFilteredList) + 1)
-	dryRunIdx := retentionIdx + 1
+	// Retention dry-run toggle (index = len(backupFilteredList) + 1)
+	retentionDryRunIdx := retentionIdx + 1
+	cursor = "  "
+	if m.cursor == retentionDryRunIdx {
+		cursor = cursorStyle.Render("▸ ")
+	}
+	check = "[ ]"
+	if m.retentionDryRun {
+		check = checkStyle.Render("[✓]")
+	}
+	retentionDryRunLabel := fmt.Sprintf("%s Retention dry run (report, don't delete)", check)
+	if m.cursor == retentionDryRunIdx {
+		retentionDryRunLabel = selectedStyle.Render(fmt.Sprintf("%s Retention dry run (report, don't delete)", check))
+	}
+	s.WriteString(fmt.Sprintf("%s%s\n", cursor, retentionDryRunLabel))
+
+	// Dry-run toggle (index = len(backupFilteredList) + 2)
+	dryRunIdx := retentionDryRunIdx + 1
 	cursor = "  "
 	if m.cursor == dryRunIdx {
 		cursor = cursorStyle.Render("▸ ")
@@ -2692,16 +3873,134 @@ func (m model) renderBackupSelect() string {
 	}
 	s.WriteString(fmt.Sprintf("%s%s\n", cursor, dryRunLabel))
 
-	// Run Backup button (index = len(backupFilteredList) + 2)
+	// Destination test toggle (index = len(backupFilteredList) + 3)
+	preflightIdx := dryRunIdx + 1
+	cursor = "  "
+	if m.cursor == preflightIdx {
+		cursor = cursorStyle.Render("▸ ")
+	}
+	check = "[ ]"
+	if !m.skipPreflight {
+		check = checkStyle.Render("[✓]")
+	}
+	preflightLabel := fmt.Sprintf("%s Test destinations first", check)
+	if m.cursor == preflightIdx {
+		preflightLabel = selectedStyle.Render(fmt.Sprintf("%s Test destinations first", check))
+	}
+	s.WriteString(fmt.Sprintf("%s%s\n", cursor, preflightLabel))
+
+	// Run Backup button (index = len(backupFilteredList) + 4)
 	s.WriteString("\n")
 	runLabel := "▶ Run Backup"
 	cursor = "  "
-	if m.cursor == dryRunIdx+1 {
+	if m.cursor == preflightIdx+1 {
 		cursor = cursorStyle.Render("▸ ")
 		runLabel = selectedStyle.Render(runLabel)
 	}
 	s.WriteString(fmt.Sprintf("%s%s\n", cursor, runLabel))
 
+	if m.presetStatus != "" {
+		s.WriteString("\n")
+		s.WriteString(m.presetStatus)
+		s.WriteString("\n")
+	}
+
+	return s.String()
+}
+
+func (m model) renderBackupPresetSelect() string {
+	var s strings.Builder
+	s.WriteString("Back up a preset:\n\n")
+
+	start, end := calcScrollWindow(m.cursor, len(m.presetNames), listMaxVisible)
+	if start > 0 {
+		s.WriteString(dimStyle.Render(fmt.Sprintf("↑ %d more above", start)))
+		s.WriteString("\n\n")
+	}
+	for i := start; i < end; i++ {
+		name := m.presetNames[i]
+		cursor := "  "
+		line := name
+		if m.cursor == i {
+			cursor = cursorStyle.Render("▸ ")
+			line = selectedStyle.Render(name)
+		}
+		s.WriteString(fmt.Sprintf("%s%s %s\n", cursor, line, dimStyle.Render(presetSummary(m.cfg.Presets[name]))))
+	}
+	if end < len(m.presetNames) {
+		s.WriteString("\n")
+		s.WriteString(dimStyle.Render(fmt.Sprintf("↓ %d more below", len(m.presetNames)-end)))
+		s.WriteString("\n")
+	}
+
+	s.WriteString("\n")
+	cursor := "  "
+	label := "Choose databases..."
+	if m.cursor == len(m.presetNames) {
+		cursor = cursorStyle.Render("▸ ")
+		label = selectedStyle.Render(label)
+	}
+	s.WriteString(fmt.Sprintf("%s%s\n", cursor, label))
+
+	return s.String()
+}
+
+// presetSummary describes the databases and options of a preset for the preset picker
+func presetSummary(p config.Preset) string {
+	parts := []string{strings.Join(p.Databases, ", ")}
+	switch {
+	case p.DryRun:
+		parts = append(parts, "dry run")
+	case p.SkipRetention:
+		parts = append(parts, "no retention")
+	case p.RetentionDryRun:
+		parts = append(parts, "retention dry run")
+	}
+	return "(" + strings.Join(parts, " • ") + ")"
+}
+
+func (m model) renderRetentionEdit() string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("Retention policy of %s\n\n", m.configEntry(m.retentionEditDB)))
+	if entry := m.configEntry(m.retentionEditDB); entry != m.retentionEditDB {
+		s.WriteString(dimStyle.Render(fmt.Sprintf("Applies to every database of %s", entry)) + "\n\n")
+	}
+	if tiers := m.cfg.Databases[m.configEntry(m.retentionEditDB)].DestTiers; tiers != nil && tiers.RemoteRetention != nil {
+		s.WriteString(dimStyle.Render("The remote tier's own policy (remote_retention)") + "\n\n")
+	}
+	if m.retentionEditForm != nil {
+		s.WriteString(m.retentionEditForm.View())
+	}
+	return s.String()
+}
+
+func (m model) renderBackupPresetSave() string {
+	var s strings.Builder
+	s.WriteString("Save selection as preset\n\n")
+	if m.presetForm != nil {
+		s.WriteString(m.presetForm.View())
+	}
+	return s.String()
+}
+
+func (m model) renderBackupExpand() string {
+	var s strings.Builder
+	s.WriteString("Listing databases...\n\n")
+	s.WriteString(fmt.Sprintf("  %s Finding the databases to back up on each server\n", m.spinner.View()))
+	return s.String()
+}
+
+func (m model) renderCredentialCheck() string {
+	var s strings.Builder
+	s.WriteString("Testing connections...\n\n")
+	s.WriteString(fmt.Sprintf("  %s Checking database credentials before anything is dumped\n", m.spinner.View()))
+	return s.String()
+}
+
+func (m model) renderDestinationCheck() string {
+	var s strings.Builder
+	s.WriteString("Testing destinations...\n\n")
+	s.WriteString(fmt.Sprintf("  %s Checking every destination is accessible before anything is dumped\n", m.spinner.View()))
 	return s.String()
 }
 
@@ -2712,24 +4011,35 @@ func (m model) renderRetentionPreCheck() string {
 	return s.String()
 }
 
+// retentionDBsPerPage is how many databases a page of the retention pre-confirm lists
+const retentionDBsPerPage = 5
+
+// retentionPlanDatabases returns the databases the retention plan deletes backups of,
+// in queue order
+func (m model) retentionPlanDatabases() []string {
+	var dbs []string
+	for _, name := range m.retentionQueue() {
+		if len(m.retentionPlan[name]) > 0 {
+			dbs = append(dbs, name)
+		}
+	}
+	return dbs
+}
+
 func (m model) renderRetentionPreConfirm() string {
 	var s strings.Builder
 
 	// Count total files and build list of DBs with files to delete
 	totalFiles := 0
-	var dbsWithFiles []string
-	for _, name := range m.backupQueue {
-		files := m.retentionPlan[name]
-		if len(files) > 0 {
-			totalFiles += len(files)
-			dbsWithFiles = append(dbsWithFiles, name)
-		}
+	dbsWithFiles := m.retentionPlanDatabases()
+	for _, name := range dbsWithFiles {
+		totalFiles += len(m.retentionPlan[name])
 	}
 
 	s.WriteString(fmt.Sprintf("Retention policy will delete %d backup(s):\n\n", totalFiles))
 
-	// Calculate page bounds (5 DBs per page)
-	perPage := 5
+	// Calculate page bounds
+	perPage := retentionDBsPerPage
 	totalPages := (len(dbsWithFiles) + perPage - 1) / perPage
 	start := m.retentionDBPage * perPage
 	end := start + perPage
@@ -2749,6 +4059,9 @@ func (m model) renderRetentionPreConfirm() string {
 		files := m.retentionPlan[name]
 
 		s.WriteString(selectedStyle.Render(name))
+		if name == m.retentionEditDB && m.pruneDB == "" && !m.readOnly {
+			s.WriteString(dimStyle.Render(" ← e: edit retention"))
+		}
 		s.WriteString("\n")
 
 		for i, f := range files {
@@ -2764,6 +4077,19 @@ func (m model) renderRetentionPreConfirm() string {
 
 	s.WriteString("\n")
 
+	// Databases whose backups could not be listed are not part of the plan
+	var failed bool
+	for _, name := range m.retentionQueue() {
+		if err := m.retentionFailures[name]; err != nil {
+			s.WriteString(errorStyle.Render(fmt.Sprintf("⚠ %s: %s", name, truncateString(err.Error(), 80))))
+			s.WriteString("\n")
+			failed = true
+		}
+	}
+	if failed {
+		s.WriteString("\n")
+	}
+
 	items := []string{"Yes, delete old backups", "No, keep all backups"}
 	for i, item := range items {
 		cursor := "  "
@@ -2795,6 +4121,12 @@ func (m model) renderBackupRunning() string {
 	} else {
 		s.WriteString(fmt.Sprintf("Running backups: %d / %d databases backed up\n\n", done, total))
 	}
+	for _, warning := range m.backupWarnings {
+		s.WriteString(dimStyle.Render("⚠ "+truncateString(warning, 80)) + "\n")
+	}
+	if len(m.backupWarnings) > 0 {
+		s.WriteString("\n")
+	}
 
 	// Calculate visible window (show 5 databases at a time)
 	maxVisible := 5
@@ -2837,19 +4169,27 @@ func (m model) renderBackupRunning() string {
 		for _, entry := range state.logs {
 			if entry.IsError {
 				s.WriteString(fmt.Sprintf("    %s %s\n", errorStyle.Render("✗"), errorStyle.Render(entry.Message)))
+			} else if entry.IsCancelled {
+				s.WriteString(fmt.Sprintf("    %s %s\n", selectedStyle.Render("⊘"), selectedStyle.Render(entry.Message)))
 			} else if entry.IsSkipped {
 				s.WriteString(fmt.Sprintf("    %s %s\n", dimStyle.Render("○"), dimStyle.Render(entry.Message)))
 			} else {
 				s.WriteString(fmt.Sprintf("    %s %s\n", successStyle.Render("✓"), entry.Message))
 			}
+			for _, warning := range entry.Warnings {
+				s.WriteString(fmt.Sprintf("      %s\n", dimStyle.Render("⚠ "+truncateString(warning, 80))))
+			}
 		}
 
 		// Show current step with spinner (if not done)
 		if !state.done && state.currentStep != stepIdle {
 			stepName := state.currentStep.String()
+			if state.currentStep == stepWaiting {
+				stepName = "Waiting for " + strings.Join(m.pendingDependencies(dbName), ", ")
+			}
 			// Add compression info for dump step
 			if state.currentStep == stepDumping {
-				if db, ok := m.cfg.Databases[dbName]; ok {
+				if db := m.backupDB(dbName); db.Type != "" {
 					if label := backup.CompressionLabel(db.Compression); label != "" {
 						stepName = fmt.Sprintf("Dumping & compressing database (%s)", label)
 					}
@@ -2857,26 +4197,28 @@ func (m model) renderBackupRunning() string {
 			}
 			s.WriteString(fmt.Sprintf("    %s %s...\n", m.spinner.View(), stepName))
 
-			// Show progress bar for upload step
-			if state.currentStep == stepUploading && state.uploadBytesTotal > 0 {
-				var pct float64
-				if state.uploadBytesTotal > 0 {
-					pct = float64(state.uploadBytesDone) / float64(state.uploadBytesTotal)
+			// Show the size estimate while dumping, with a warning above the threshold
+			if state.currentStep == stepDumping && state.estimatedSize > 0 {
+				estimate := fmt.Sprintf("estimated %s before compression", humanize.IBytes(uint64(state.estimatedSize)))
+				if m.cfg.ExceedsSizeWarning(state.estimatedSize) {
+					s.WriteString(fmt.Sprintf("       %s\n", errorStyle.Render(fmt.Sprintf("⚠ %s (above %d MB warning)", estimate, m.cfg.SizeWarningMB))))
+				} else {
+					s.WriteString(fmt.Sprintf("       %s\n", dimStyle.Render(estimate)))
 				}
+			}
 
-				// Progress bar
-				s.WriteString("       ")
-				s.WriteString(m.progressBar.ViewAs(pct))
-				s.WriteString("\n")
-
-				// Size and speed info
-				s.WriteString(fmt.Sprintf("       %s / %s",
-					humanize.IBytes(uint64(state.uploadBytesDone)),
-					humanize.IBytes(uint64(state.uploadBytesTotal))))
-				if state.uploadSpeed > 0 {
-					s.WriteString(fmt.Sprintf(" • %s/s", humanize.IBytes(uint64(state.uploadSpeed))))
+			// Show progress bar for upload step
+			if state.currentStep == stepUploading && state.uploadBytesTotal != 0 {
+				s.WriteString(m.renderTransferProgress("       ", storage.TransferProgress{
+					BytesDone:  state.uploadBytesDone,
+					BytesTotal: state.uploadBytesTotal,
+					Speed:      state.uploadSpeed,
+				}))
+			}
+			if us := m.uploadStates[dbName]; state.currentStep == stepUploading && us != nil {
+				if warning := us.stallWarning(); warning != "" {
+					s.WriteString(errorStyle.Render("       ⚠ "+warning) + "\n")
 				}
-				s.WriteString("\n")
 			}
 		}
 	}
@@ -2887,6 +4229,77 @@ func (m model) renderBackupRunning() string {
 		s.WriteString("\n")
 	}
 
+	if done == total && !m.dryRun {
+		s.WriteString("\n")
+		s.WriteString(m.storageChange().String())
+		s.WriteString("\n")
+	}
+
+	if m.runReportStatus != "" {
+		s.WriteString("\n")
+		s.WriteString(m.runReportStatus)
+		s.WriteString("\n")
+	}
+
+	return s.String()
+}
+
+// failedEntry returns the failed step of a database in the backup run, or nil if
+// none of its steps failed
+func (m model) failedEntry(dbName string) *backupLogEntry {
+	state := m.backupStates[dbName]
+	if state == nil {
+		return nil
+	}
+	for i := len(state.logs) - 1; i >= 0; i-- {
+		if state.logs[i].IsError {
+			return &state.logs[i]
+		}
+	}
+	return nil
+}
+
+// errorDetailLines returns the full error of the database shown in the error view,
+// wrapped to the terminal width
+func (m model) errorDetailLines() []string {
+	entry := m.failedEntry(m.errorDetailDB)
+	if entry == nil {
+		return nil
+	}
+	text := entry.Message
+	for _, warning := range entry.Warnings {
+		text += "\n⚠ " + warning
+	}
+	wrapped := lipgloss.NewStyle().Width(m.formWidth()).Render(text)
+	return strings.Split(wrapped, "\n")
+}
+
+func (m model) renderBackupError() string {
+	var s strings.Builder
+
+	entry := m.failedEntry(m.errorDetailDB)
+	if entry == nil {
+		return ""
+	}
+	s.WriteString(fmt.Sprintf("%s failed while %s\n\n",
+		selectedStyle.Render(m.errorDetailDB), strings.ToLower(entry.Step.String())))
+
+	lines := m.errorDetailLines()
+	start := min(m.errorDetailOffset, len(lines))
+	end := min(start+errorDetailMaxLines, len(lines))
+	if start > 0 {
+		s.WriteString(dimStyle.Render(fmt.Sprintf("↑ %d more lines above", start)))
+		s.WriteString("\n")
+	}
+	for _, line := range lines[start:end] {
+		s.WriteString(errorStyle.Render(line))
+		s.WriteString("\n")
+	}
+	if end < len(lines) {
+		s.WriteString(dimStyle.Render(fmt.Sprintf("↓ %d more lines below", len(lines)-end)))
+		s.WriteString("\n")
+	}
+
 	return s.String()
 }
 
@@ -2894,7 +4307,11 @@ func (m model) renderRestoreRunning() string {
 	var s strings.Builder
 
 	// Header
-	s.WriteString(fmt.Sprintf("Restoring to %s\n", selectedStyle.Render(m.selectedDB)))
+	if m.cloneSourceDB != "" {
+		s.WriteString(fmt.Sprintf("Restoring %s's backup into %s\n", selectedStyle.Render(m.cloneSourceDB), selectedStyle.Render(m.selectedDB)))
+	} else {
+		s.WriteString(fmt.Sprintf("Restoring to %s\n", selectedStyle.Render(m.selectedDB)))
+	}
 
 	// Show completed steps
 	for _, entry := range m.restoreLogs {
@@ -2903,11 +4320,17 @@ func (m model) renderRestoreRunning() string {
 		} else {
 			s.WriteString(fmt.Sprintf("  %s %s\n", successStyle.Render("✓"), entry.Message))
 		}
+		for _, warning := range entry.Warnings {
+			s.WriteString(fmt.Sprintf("    %s\n", dimStyle.Render("⚠ "+truncateString(warning, 80))))
+		}
 	}
 
 	// Show current step with spinner
 	if m.restoreStep != restoreStepIdle {
 		stepStr := m.restoreStep.String()
+		if m.restoreStep == restoreStepDownloading && m.downloadState != nil && m.downloadState.target != nil {
+			stepStr = "Streaming backup into the database"
+		}
 		// Add decompression info for restore step
 		if m.restoreStep == restoreStepRestoring {
 			if comp := backup.CompressionFromFilename(m.selectedFile); comp != "" {
@@ -2919,28 +4342,45 @@ func (m model) renderRestoreRunning() string {
 		s.WriteString(fmt.Sprintf("  %s %s...\n", m.spinner.View(), stepStr))
 
 		// Show progress bar for download step
-		if m.restoreStep == restoreStepDownloading && m.selectedFileSize > 0 {
-			// Calculate progress percentage
-			var pct float64
-			if m.selectedFileSize > 0 {
-				pct = float64(m.downloadBytesDone) / float64(m.selectedFileSize)
+		if m.restoreStep == restoreStepDownloading && m.selectedFileSize != 0 {
+			s.WriteString(m.renderTransferProgress("     ", storage.TransferProgress{
+				BytesDone:  m.downloadBytesDone,
+				BytesTotal: m.selectedFileSize,
+				Speed:      m.downloadSpeed,
+			}))
+		}
+		if m.restoreStep == restoreStepDownloading && m.downloadRetry != "" {
+			s.WriteString(dimStyle.Render("     ⚠ "+truncateString(m.downloadRetry, 100)) + "\n")
+		}
+		if m.restoreStep == restoreStepDownloading && m.downloadState != nil {
+			if warning := m.downloadState.stallWarning(); warning != "" {
+				s.WriteString(errorStyle.Render("     ⚠ "+warning) + "\n")
 			}
+		}
+	}
 
-			// Progress bar
-			s.WriteString("     ")
-			s.WriteString(m.progressBar.ViewAs(pct))
-			s.WriteString("\n")
+	return s.String()
+}
 
-			// Size and speed info
-			s.WriteString(fmt.Sprintf("     %s / %s",
-				humanize.IBytes(uint64(m.downloadBytesDone)),
-				humanize.IBytes(uint64(m.selectedFileSize))))
-			if m.downloadSpeed > 0 {
-				s.WriteString(fmt.Sprintf(" • %s/s", humanize.IBytes(uint64(m.downloadSpeed))))
-			}
-			s.WriteString("\n")
-		}
+// renderTransferProgress renders an upload or download below its step: a progress bar
+// with byte counts, or only the bytes so far when the total is unknown, since the step's
+// spinner already shows it is moving
+func (m model) renderTransferProgress(indent string, p storage.TransferProgress) string {
+	var s strings.Builder
+
+	if p.TotalKnown() {
+		s.WriteString(indent)
+		s.WriteString(m.progressBar.ViewAs(p.Fraction()))
+		s.WriteString("\n")
+		s.WriteString(fmt.Sprintf("%s%s / %s", indent,
+			humanize.IBytes(uint64(p.BytesDone)), humanize.IBytes(uint64(p.BytesTotal))))
+	} else {
+		s.WriteString(fmt.Sprintf("%s%s so far", indent, humanize.IBytes(uint64(p.BytesDone))))
+	}
+	if p.Speed > 0 {
+		s.WriteString(fmt.Sprintf(" • %s/s", humanize.IBytes(uint64(p.Speed))))
 	}
+	s.WriteString("\n")
 
 	return s.String()
 }
@@ -2968,12 +4408,13 @@ func (m model) renderRestoreDBSelect() string {
 		s.WriteString("\n\n")
 	}
 
+	batch := len(m.restoreBatchNames()) > 0
 	if len(m.restoreDBFilteredList) == 0 {
 		s.WriteString(dimStyle.Render("  No matching databases found."))
 		s.WriteString("\n")
 	} else {
 		// Show databases with scrolling
-		maxVisible := 10
+		maxVisible := listMaxVisible
 		start := 0
 		if m.cursor >= maxVisible {
 			start = m.cursor - maxVisible + 1
@@ -2987,10 +4428,22 @@ func (m model) renderRestoreDBSelect() string {
 			name := m.restoreDBFilteredList[i]
 			cursor := "  "
 			db := m.cfg.Databases[name]
-			line := fmt.Sprintf("%s %s", name, dimStyle.Render(fmt.Sprintf("(%s)", db.Type)))
+			label := name
+			if batch {
+				// Databases picked for a batch restore
+				check := "[ ]"
+				if m.restoreBatchSelected[name] {
+					check = checkStyle.Render("[✓]")
+				}
+				label = check + " " + name
+				if source := m.restoreBatchSources[name]; source != "" && m.restoreBatchSelected[name] {
+					label += " ← " + source + "'s backups"
+				}
+			}
+			line := fmt.Sprintf("%s %s", label, dimStyle.Render(fmt.Sprintf("(%s)", db.Type)))
 			if m.cursor == i {
 				cursor = cursorStyle.Render("▸ ")
-				line = selectedStyle.Render(name) + " " + dimStyle.Render(fmt.Sprintf("(%s)", db.Type))
+				line = selectedStyle.Render(label) + " " + dimStyle.Render(fmt.Sprintf("(%s)", db.Type))
 			}
 			s.WriteString(fmt.Sprintf("%s%s\n", cursor, line))
 		}
@@ -3004,6 +4457,127 @@ func (m model) renderRestoreDBSelect() string {
 		}
 		s.WriteString("\n")
 	}
+	if batch {
+		s.WriteString(selectedStyle.Render(fmt.Sprintf("%d selected: enter restores them together", len(m.restoreBatchNames()))))
+		s.WriteString("\n")
+	}
+
+	return s.String()
+}
+
+func (m model) renderRestoreBatchForm() string {
+	var s strings.Builder
+	names := m.restoreBatchNames()
+	s.WriteString(fmt.Sprintf("Restore %d databases: %s\n\n", len(names), selectedStyle.Render(truncateString(strings.Join(names, ", "), 80))))
+	if m.restoreBatchForm != nil {
+		s.WriteString(m.restoreBatchForm.View())
+	}
+	return s.String()
+}
+
+func (m model) renderRestoreBatchPlan() string {
+	var s strings.Builder
+	s.WriteString("Listing backups...\n\n")
+	s.WriteString(fmt.Sprintf("  %s Finding the backup each database restores\n", m.spinner.View()))
+	return s.String()
+}
+
+func (m model) renderRestoreBatchConfirm() string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("Restore %d databases?\n\n", len(m.restoreBatchPlan)))
+	if !m.restoreBatchAt.IsZero() {
+		s.WriteString(fmt.Sprintf("  As of: %s\n\n", m.restoreBatchAt.Format("2006-01-02 15:04:05")))
+	}
+	for _, req := range m.restoreBatchPlan {
+		file := req.File
+		if req.Source != req.Entry {
+			file = req.Source + ": " + file
+		}
+		s.WriteString(fmt.Sprintf("  %s ← %s %s\n", selectedStyle.Render(req.Name), file,
+			dimStyle.Render(fmt.Sprintf("(%s, %s)", req.Time.Format("2006-01-02 15:04"), humanize.IBytes(uint64(req.Size))))))
+	}
+	for _, name := range m.restoreBatchNames() {
+		if err := m.restoreBatchFailures[name]; err != nil {
+			s.WriteString(fmt.Sprintf("  %s %s\n", errorStyle.Render("✗ "+name), dimStyle.Render("not restored: "+err.Error())))
+		}
+	}
+	s.WriteString("\n")
+	s.WriteString(errorStyle.Render(fmt.Sprintf("⚠ This will overwrite %d databases!", len(m.restoreBatchPlan))))
+	s.WriteString("\n\n")
+	if m.restoreBatchForm != nil {
+		s.WriteString(m.restoreBatchForm.View())
+	}
+	return s.String()
+}
+
+func (m model) renderRestoreBatchRunning() string {
+	var s strings.Builder
+
+	var done int
+	for _, state := range m.restoreBatchStates {
+		if state.done {
+			done++
+		}
+	}
+	if m.restoreBatchDone() {
+		s.WriteString(fmt.Sprintf("Restore complete: %d / %d databases restored\n\n", done, len(m.restoreBatchPlan)))
+	} else {
+		s.WriteString(fmt.Sprintf("Restoring databases: %d / %d done\n\n", done, len(m.restoreBatchPlan)))
+	}
+
+	// Show 5 databases at a time, like the backup progress
+	maxVisible := 5
+	start := 0
+	if m.cursor >= maxVisible {
+		start = m.cursor - maxVisible + 1
+	}
+	end := min(start+maxVisible, len(m.restoreBatchPlan))
+	if start > 0 {
+		s.WriteString(dimStyle.Render(fmt.Sprintf("↑ %d more above", start)))
+		s.WriteString("\n\n")
+	}
+
+	for i := start; i < end; i++ {
+		req := m.restoreBatchPlan[i]
+		state := m.restoreBatchStates[req.Name]
+		if state == nil {
+			continue
+		}
+		if i > start {
+			s.WriteString("\n")
+		}
+
+		cursor := "  "
+		if m.cursor == i {
+			cursor = cursorStyle.Render("▸ ")
+		}
+		s.WriteString(fmt.Sprintf("%s%s %s\n", cursor, selectedStyle.Render(truncateString(req.Name, 60)), dimStyle.Render("← "+req.File)))
+
+		for _, entry := range state.logs {
+			if entry.IsError {
+				s.WriteString(fmt.Sprintf("    %s %s\n", errorStyle.Render("✗"), errorStyle.Render(entry.Message)))
+			} else {
+				s.WriteString(fmt.Sprintf("    %s %s\n", successStyle.Render("✓"), entry.Message))
+			}
+			for _, warning := range entry.Warnings {
+				s.WriteString(fmt.Sprintf("      %s\n", dimStyle.Render("⚠ "+truncateString(warning, 80))))
+			}
+		}
+
+		if !state.done {
+			if state.step == "" {
+				s.WriteString(fmt.Sprintf("    %s\n", dimStyle.Render("○ Waiting for its turn")))
+			} else {
+				s.WriteString(fmt.Sprintf("    %s %s...\n", m.spinner.View(), state.step))
+			}
+		}
+	}
+
+	if end < len(m.restoreBatchPlan) {
+		s.WriteString("\n")
+		s.WriteString(dimStyle.Render(fmt.Sprintf("↓ %d more below", len(m.restoreBatchPlan)-end)))
+		s.WriteString("\n")
+	}
 
 	return s.String()
 }
@@ -3012,7 +4586,7 @@ func (m model) renderRestoreSourceSelect() string {
 	var s strings.Builder
 	s.WriteString(fmt.Sprintf("Restore %s from:\n\n", selectedStyle.Render(m.selectedDB)))
 
-	items := []string{"Remote backup", "Local file"}
+	items := []string{"Remote backup", "Local file", "Another database's backup"}
 	for i, item := range items {
 		cursor := "  "
 		if m.cursor == i {
@@ -3025,6 +4599,40 @@ func (m model) renderRestoreSourceSelect() string {
 	return s.String()
 }
 
+func (m model) renderRestoreCloneSourceSelect() string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("Restore %s from a backup of:\n\n", selectedStyle.Render(m.selectedDB)))
+
+	if len(m.cloneCandidates) == 0 {
+		s.WriteString(dimStyle.Render(fmt.Sprintf("  No other %s databases configured.", m.cfg.Databases[m.selectedDB].Type)))
+		s.WriteString("\n")
+		return s.String()
+	}
+
+	start, end := calcScrollWindow(m.cursor, len(m.cloneCandidates), listMaxVisible)
+	if start > 0 {
+		s.WriteString(dimStyle.Render(fmt.Sprintf("↑ %d more above", start)))
+		s.WriteString("\n\n")
+	}
+	for i := start; i < end; i++ {
+		name := m.cloneCandidates[i]
+		cursor := "  "
+		line := name
+		if m.cursor == i {
+			cursor = cursorStyle.Render("▸ ")
+			line = selectedStyle.Render(name)
+		}
+		s.WriteString(fmt.Sprintf("%s%s\n", cursor, line))
+	}
+	if end < len(m.cloneCandidates) {
+		s.WriteString("\n")
+		s.WriteString(dimStyle.Render(fmt.Sprintf("↓ %d more below", len(m.cloneCandidates)-end)))
+		s.WriteString("\n")
+	}
+
+	return s.String()
+}
+
 func (m model) renderRestoreLocalInput() string {
 	var s strings.Builder
 	s.WriteString(fmt.Sprintf("Restore %s from local file:\n\n", selectedStyle.Render(m.selectedDB)))
@@ -3036,7 +4644,11 @@ func (m model) renderRestoreLocalInput() string {
 
 func (m model) renderRestoreFileSelect() string {
 	var s strings.Builder
-	s.WriteString(fmt.Sprintf("Select backup to restore for %s:\n\n", selectedStyle.Render(m.selectedDB)))
+	if m.cloneSourceDB != "" {
+		s.WriteString(fmt.Sprintf("Select backup of %s to restore into %s:\n\n", selectedStyle.Render(m.cloneSourceDB), selectedStyle.Render(m.selectedDB)))
+	} else {
+		s.WriteString(fmt.Sprintf("Select backup to restore for %s:\n\n", selectedStyle.Render(m.selectedDB)))
+	}
 
 	// Show spinner while loading
 	if m.backupFilesLoading {
@@ -3058,12 +4670,12 @@ func (m model) renderRestoreFileSelect() string {
 		return s.String()
 	}
 
-	if len(m.restoreFileFilteredList) == 0 {
+	if len(m.restoreFileFilteredList) == 0 && !m.backupFilesMore {
 		s.WriteString(dimStyle.Render("  No matching backups found."))
 		s.WriteString("\n")
 	} else {
 		// Show files with scrolling
-		maxVisible := 10
+		maxVisible := listMaxVisible
 		start := 0
 		if m.cursor >= maxVisible {
 			start = m.cursor - maxVisible + 1
@@ -3081,6 +4693,20 @@ func (m model) renderRestoreFileSelect() string {
 				cursor = cursorStyle.Render("▸ ")
 				line = selectedStyle.Render(line)
 			}
+			if delta, ok := m.backupFileDeltas[f.Name]; ok {
+				line += "  " + dimStyle.Render(orchestrator.FormatSizeDelta(delta)+" since previous")
+			}
+			s.WriteString(fmt.Sprintf("%s%s\n", cursor, line))
+		}
+
+		// Older backups are left out until asked for
+		if m.backupFilesMore {
+			cursor := "  "
+			line := fmt.Sprintf("Load %d more...", min(m.cfg.RestorePickerLimit(), len(m.backupFiles)-len(m.restoreFileFilteredList)))
+			if m.cursor == len(m.restoreFileFilteredList) {
+				cursor = cursorStyle.Render("▸ ")
+				line = selectedStyle.Render(line)
+			}
 			s.WriteString(fmt.Sprintf("%s%s\n", cursor, line))
 		}
 
@@ -3088,6 +4714,8 @@ func (m model) renderRestoreFileSelect() string {
 		s.WriteString("\n")
 		if m.restoreFileFilter != "" {
 			s.WriteString(dimStyle.Render(fmt.Sprintf("Showing %d of %d backups", len(m.restoreFileFilteredList), len(m.backupFiles))))
+		} else if m.backupFilesMore {
+			s.WriteString(dimStyle.Render(fmt.Sprintf("Newest %d of %d backups", len(m.restoreFileFilteredList), len(m.backupFiles))))
 		} else {
 			s.WriteString(dimStyle.Render(fmt.Sprintf("%d backups", len(m.backupFiles))))
 		}
@@ -3115,14 +4743,35 @@ func (m model) renderRestoreConfirm() string {
 		}
 	}
 
-	s.WriteString(fmt.Sprintf("Restore to %s?\n\n", selectedStyle.Render(m.selectedDB)))
+	if m.cloneSourceDB != "" {
+		// Restoring across databases: make both sides impossible to miss
+		s.WriteString(fmt.Sprintf("Restore %s's backup INTO %s?\n\n", selectedStyle.Render(m.cloneSourceDB), selectedStyle.Render(m.selectedDB)))
+		s.WriteString(fmt.Sprintf("  Source: %s (backups listed from %s)\n", m.cloneSourceDB, m.cfg.Databases[m.cloneSourceDB].Dest))
+		s.WriteString(fmt.Sprintf("  Target: %s (overwritten)\n", m.selectedDB))
+	} else {
+		s.WriteString(fmt.Sprintf("Restore to %s?\n\n", selectedStyle.Render(m.selectedDB)))
+	}
 	s.WriteString(fmt.Sprintf("  File: %s\n", m.selectedFile))
 	if fileSize > 0 {
 		s.WriteString(fmt.Sprintf("  Size: %s\n", humanize.IBytes(uint64(fileSize))))
 	}
-	s.WriteString("\n")
-	s.WriteString(errorStyle.Render("⚠ This will overwrite the current database!"))
+	switch m.cfg.Databases[m.selectedDB].RestoreTargetMode() {
+	case config.RestoreCreateOnly:
+		s.WriteString(fmt.Sprintf("  Mode: %s (fails if the target exists)\n\n", config.RestoreCreateOnly))
+		s.WriteString(errorStyle.Render("⚠ This will create the database from the backup!"))
+	case config.RestoreOverwriteOnly:
+		s.WriteString(fmt.Sprintf("  Mode: %s (fails if the target is missing)\n\n", config.RestoreOverwriteOnly))
+		s.WriteString(errorStyle.Render("⚠ This will overwrite the current database!"))
+	default:
+		s.WriteString(fmt.Sprintf("  Mode: %s (restores into the target as it is)\n\n", config.RestoreUpsert))
+		s.WriteString(errorStyle.Render("⚠ This will overwrite the current database!"))
+	}
 	s.WriteString("\n\n")
+	if m.restoreThenBackup {
+		s.WriteString(fmt.Sprintf("  Then back up: %s\n\n", checkStyle.Render("yes")))
+	} else {
+		s.WriteString(fmt.Sprintf("  Then back up: %s\n\n", dimStyle.Render("no")))
+	}
 
 	items := []string{"Yes, restore", "No, go back"}
 	for i, item := range items {
@@ -3195,6 +4844,9 @@ func (m model) renderConfirmExit() string {
 func (m model) renderDBList() string {
 	var s strings.Builder
 	s.WriteString("Manage databases:\n\n")
+	if m.readOnly {
+		s.WriteString(renderReadOnlyBanner())
+	}
 
 	// Filter input
 	if m.dbFilter != "" {
@@ -3212,7 +4864,7 @@ func (m model) renderDBList() string {
 		s.WriteString("\n\n")
 	} else {
 		// Show databases with scrolling
-		maxVisible := 10
+		maxVisible := listMaxVisible
 		start, end := calcScrollWindow(m.cursor, len(m.dbFilteredList), maxVisible)
 
 		// Scroll indicator if there are items above
@@ -3254,7 +4906,12 @@ func (m model) renderDBList() string {
 	addIdx := len(m.dbFilteredList)
 	cursor := "  "
 	addLabel := "+ Add new database"
-	if m.cursor == addIdx {
+	if m.readOnly {
+		addLabel = dimStyle.Render(addLabel + " (config is read-only)")
+		if m.cursor == addIdx {
+			cursor = cursorStyle.Render("▸ ")
+		}
+	} else if m.cursor == addIdx {
 		cursor = cursorStyle.Render("▸ ")
 		addLabel = selectedStyle.Render(addLabel)
 	}
@@ -3267,12 +4924,20 @@ func (m model) renderDBActions() string {
 	var s strings.Builder
 	db := m.cfg.Databases[m.editingDB]
 	s.WriteString(fmt.Sprintf("Database: %s %s\n\n", selectedStyle.Render(m.editingDB), dimStyle.Render(fmt.Sprintf("(%s)", db.Type))))
+	if m.readOnly {
+		s.WriteString(renderReadOnlyBanner())
+	}
 
-	items := []string{"Edit", "Test connection", "Delete", "Back"}
+	items := []string{"Edit", "Test connection", "Prune old backups", "Delete", "Back"}
 	for i, item := range items {
 		cursor := "  "
 		if m.cursor == i {
 			cursor = cursorStyle.Render("▸ ")
+		}
+		switch {
+		case m.readOnly && (i == dbActionEdit || i == dbActionDelete):
+			item = dimStyle.Render(item)
+		case m.cursor == i:
 			item = selectedStyle.Render(item)
 		}
 		s.WriteString(fmt.Sprintf("%s%s\n", cursor, item))
@@ -3281,6 +4946,28 @@ func (m model) renderDBActions() string {
 	return s.String()
 }
 
+// renderReadOnlyBanner explains why the actions that save the config are unavailable
+func renderReadOnlyBanner() string {
+	return errorStyle.Render("⚠ Config is read-only: adding, editing and deleting databases is disabled") + "\n\n"
+}
+
+func (m model) renderPrune() string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("Pruning %s\n\n", selectedStyle.Render(m.pruneDB)))
+
+	if m.pruneRunning {
+		s.WriteString(fmt.Sprintf("  %s Deleting old backups\n", m.spinner.View()))
+		return s.String()
+	}
+
+	s.WriteString(m.pruneResult)
+	s.WriteString("\n")
+	for _, warning := range m.pruneWarnings {
+		s.WriteString(fmt.Sprintf("  %s\n", dimStyle.Render("⚠ "+truncateString(warning, 80))))
+	}
+	return s.String()
+}
+
 func (m model) renderDBTest() string {
 	var s strings.Builder
 
@@ -3320,6 +5007,107 @@ func (m model) renderDBTest() string {
 	return s.String()
 }
 
+// fieldChange is a database setting changed by an edit
+type fieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// databaseChanges lists the settings of the edit form that differ between a database
+// before and after an edit, plus config-only settings the edit drops
+func databaseChanges(oldName string, old config.Database, newName string, edited config.Database) []fieldChange {
+	var changes []fieldChange
+	add := func(field, before, after string) {
+		if before != after {
+			changes = append(changes, fieldChange{Field: field, Old: valueOrNone(before), New: valueOrNone(after)})
+		}
+	}
+	number := func(n int) string {
+		if n == 0 {
+			return ""
+		}
+		return strconv.Itoa(n)
+	}
+	compression := func(c string) string {
+		if c == "" {
+			return "none"
+		}
+		return c
+	}
+
+	add("Name", oldName, newName)
+	add("File path", old.Path, edited.Path)
+	add("Host", old.Host, edited.Host)
+	add("Port", number(old.Port), number(edited.Port))
+	add("Username", old.User, edited.User)
+	if old.Password != edited.Password {
+		// Passwords are never shown, only whether one is set
+		before, after := "(set)", "(changed)"
+		if old.Password == "" {
+			before, after = "(none)", "(set)"
+		} else if edited.Password == "" {
+			after = "(none)"
+		}
+		changes = append(changes, fieldChange{Field: "Password", Old: before, New: after})
+	}
+	add("Database name", old.Database, edited.Database)
+	add("Backup destination", old.Dest, edited.Dest)
+	add("Compression", compression(old.Compression), compression(edited.Compression))
+	add("Keep last N backups", number(old.Retention.KeepLast), number(edited.Retention.KeepLast))
+	add("Keep backups for N days", number(old.Retention.KeepDays), number(edited.Retention.KeepDays))
+	add("Max total size (MB)", number(old.Retention.MaxSizeMB), number(edited.Retention.MaxSizeMB))
+	if old.ZstdDictionary != edited.ZstdDictionary {
+		// Only ever dropped, when the compression changes from zstd
+		add("zstd dictionary", strconv.FormatUint(uint64(old.ZstdDictionary), 10), "")
+	}
+	return changes
+}
+
+// valueOrNone shows an empty setting as "(none)"
+func valueOrNone(value string) string {
+	if value == "" {
+		return "(none)"
+	}
+	return value
+}
+
+func (m model) renderEditSaveConfirm() string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("Save changes to %s?\n\n", selectedStyle.Render(m.editingDB)))
+
+	width := 0
+	for _, c := range m.editChanges {
+		width = max(width, len(c.Field))
+	}
+	for _, c := range m.editChanges {
+		s.WriteString(fmt.Sprintf("  %-*s  %s → %s\n", width, c.Field, dimStyle.Render(c.Old), selectedStyle.Render(c.New)))
+	}
+	s.WriteString("\n")
+
+	prev := m.cfg.Databases[m.editingDB]
+	if prev.HasRetention() && !m.editedDB.HasRetention() {
+		s.WriteString(errorStyle.Render("⚠ Retention is removed: old backups will no longer be deleted."))
+		s.WriteString("\n\n")
+	}
+	if prev.Dest != m.editedDB.Dest {
+		s.WriteString(dimStyle.Render("  (Existing backups stay at the old destination)"))
+		s.WriteString("\n\n")
+	}
+
+	items := []string{"Yes, save", "No, keep editing"}
+	for i, item := range items {
+		cursor := "  "
+		if m.cursor == i {
+			cursor = cursorStyle.Render("▸ ")
+			item = selectedStyle.Render(item)
+		}
+		s.WriteString(fmt.Sprintf("%s%s\n", cursor, item))
+	}
+
+	return s.String()
+}
+
 func (m model) renderDeleteConfirm() string {
 	var s strings.Builder
 	s.WriteString(fmt.Sprintf("Delete database %s?\n\n", errorStyle.Render(m.editingDB)))
@@ -3342,6 +5130,13 @@ func (m model) renderDeleteConfirm() string {
 }
 
 func (m model) saveNewDatabase() (tea.Model, tea.Cmd) {
+	// Never overwrite another database (ctrl+s can save without completing the form)
+	if m.nameConflict(m.formData.name) {
+		m.formError = fmt.Sprintf("Name: database %q already exists", m.formData.name)
+		m.addDBForm = m.buildAddDBForm(false)
+		return m, m.addDBForm.Init()
+	}
+
 	// Build the database config using form field values
 	// (validation is done before calling this function via validateForm())
 	db := config.Database{
@@ -3368,15 +5163,7 @@ func (m model) saveNewDatabase() (tea.Model, tea.Cmd) {
 	}
 
 	// Parse retention settings
-	if m.formData.keepLast != "" {
-		fmt.Sscanf(m.formData.keepLast, "%d", &db.Retention.KeepLast)
-	}
-	if m.formData.keepDays != "" {
-		fmt.Sscanf(m.formData.keepDays, "%d", &db.Retention.KeepDays)
-	}
-	if m.formData.maxSizeMB != "" {
-		fmt.Sscanf(m.formData.maxSizeMB, "%d", &db.Retention.MaxSizeMB)
-	}
+	db.Retention = m.formData.retention()
 
 	// Add to config
 	m.cfg.Databases[m.formData.name] = db
@@ -3408,8 +5195,36 @@ func (m model) saveNewDatabase() (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// saveEditedDatabase shows the changes of the edit for confirmation before saving them
 func (m model) saveEditedDatabase() (tea.Model, tea.Cmd) {
-	// Build the database config using form field values
+	// Never overwrite another database (ctrl+s can save without completing the form)
+	if m.nameConflict(m.formData.name) {
+		m.formError = fmt.Sprintf("Name: database %q already exists", m.formData.name)
+		m.addDBForm = m.buildAddDBForm(false)
+		return m, m.addDBForm.Init()
+	}
+
+	m.editedDB = m.buildEditedDatabase()
+	m.editChanges = databaseChanges(m.editingDB, m.cfg.Databases[m.editingDB], m.formData.name, m.editedDB)
+	if len(m.editChanges) == 0 {
+		return m.commitEditedDatabase()
+	}
+	m.view = viewEditDBSaveConfirm
+	m.cursor = confirmYes
+	return m, nil
+}
+
+// returnToEditForm reopens the edit form with the values entered so far
+func (m model) returnToEditForm() (tea.Model, tea.Cmd) {
+	m.view = viewEditDBForm
+	m.cursor = 0
+	m.editChanges = nil
+	m.addDBForm = m.buildAddDBForm(false)
+	return m, m.addDBForm.Init()
+}
+
+// buildEditedDatabase builds the database config from the edit form's values
+func (m model) buildEditedDatabase() config.Database {
 	db := config.Database{
 		Type:        m.addDBType,
 		Dest:        expandDest(m.formData.dest),
@@ -3434,15 +5249,65 @@ func (m model) saveEditedDatabase() (tea.Model, tea.Cmd) {
 	}
 
 	// Parse retention settings
-	if m.formData.keepLast != "" {
-		fmt.Sscanf(m.formData.keepLast, "%d", &db.Retention.KeepLast)
+	db.Retention = m.formData.retention()
+
+	// Keep settings that can only be set in the config file
+	prev := m.cfg.Databases[m.editingDB]
+	db.Retention.MaxCount = prev.Retention.MaxCount
+	if db.Dest == prev.Dest {
+		db.DestSpec = prev.DestSpec
+		db.DestTiers = prev.DestTiers
+	}
+	db.PostRestoreCommand = prev.PostRestoreCommand
+	db.RestoreMode = prev.RestoreMode
+	db.StreamRestore = prev.StreamRestore
+	db.Immutable = prev.Immutable
+	db.VerifyUpload = prev.VerifyUpload
+	db.PostUploadVerify = prev.PostUploadVerify
+	db.PostUploadVerifyMaxMB = prev.PostUploadVerifyMaxMB
+	db.AtomicUpload = prev.AtomicUpload
+	db.GroupBy = prev.GroupBy
+	db.MinBackupSize = prev.MinBackupSize
+	db.SizeAlert = prev.SizeAlert
+	db.VerifyOnRetention = prev.VerifyOnRetention
+	db.Encryption = prev.Encryption
+	db.BackupIndex = prev.BackupIndex
+	db.After = prev.After
+	db.FilePrefix = prev.FilePrefix
+	if db.Type == "postgres" {
+		db.VacuumAnalyze = prev.VacuumAnalyze
+	}
+	if db.Type == "mysql" {
+		db.MariaDB = prev.MariaDB
+	}
+	if db.Type == "file" {
+		db.RestoreFileMode = prev.RestoreFileMode
+		db.ArchiveExt = prev.ArchiveExt
+	} else {
+		db.ConnectTimeout = prev.ConnectTimeout
+		db.Redact = prev.Redact
+		db.RestoreNoTransaction = prev.RestoreNoTransaction
+		db.ConsistencyGroup = prev.ConsistencyGroup
+		db.DumpHost = prev.DumpHost
+		db.DumpPort = prev.DumpPort
+		// A mysql character set means nothing to postgres, and the other way round
+		if db.Type == prev.Type {
+			db.Charset = prev.Charset
+		}
 	}
-	if m.formData.keepDays != "" {
-		fmt.Sscanf(m.formData.keepDays, "%d", &db.Retention.KeepDays)
+	if db.AllDatabases() {
+		db.ExcludeDatabases = prev.ExcludeDatabases
 	}
-	if m.formData.maxSizeMB != "" {
-		fmt.Sscanf(m.formData.maxSizeMB, "%d", &db.Retention.MaxSizeMB)
+	if db.Compression == "zstd" {
+		db.ZstdDictionary = prev.ZstdDictionary
 	}
+	return db
+}
+
+// commitEditedDatabase saves the confirmed edit to the config file
+func (m model) commitEditedDatabase() (tea.Model, tea.Cmd) {
+	db := m.editedDB
+	m.editChanges = nil
 
 	// Check if name changed
 	oldName := m.editingDB
@@ -3452,6 +5317,7 @@ func (m model) saveEditedDatabase() (tea.Model, tea.Cmd) {
 		// Delete old entry, add new
 		delete(m.cfg.Databases, oldName)
 		m.cfg.Databases[newName] = db
+		m.cfg.RenameDependency(oldName, newName)
 
 		// Update dbNames list
 		for i, name := range m.dbNames {
@@ -3499,6 +5365,7 @@ func (m model) deleteDatabase() (tea.Model, tea.Cmd) {
 
 	// Delete from config
 	delete(m.cfg.Databases, name)
+	m.cfg.RenameDependency(name, "")
 
 	// Save config file
 	if err := m.cfg.Save(); err != nil {
@@ -3534,23 +5401,32 @@ func (m model) deleteDatabase() (tea.Model, tea.Cmd) {
 
 // retentionPreCheckMsg is sent when retention pre-check completes
 type retentionPreCheckMsg struct {
-	plan map[string][]storage.RemoteFile // dbName -> files to delete
-	err  error
+	plan     map[string][]storage.RemoteFile // dbName -> files to delete
+	failures orchestrator.RetentionFailures  // dbName -> error listing its backups
 }
 
 // backupStepDoneMsg is sent when a backup step completes
 type backupStepDoneMsg struct {
-	dbName  string
-	step    backupStep
-	result  *backup.Result // set after dump step
-	message string         // status message
-	err     error
-	skipped bool // true if step was skipped (e.g., retention skipped)
+	dbName   string
+	step     backupStep
+	result   *backup.Result // set after dump step
+	message  string         // status message
+	err      error
+	skipped  bool     // true if step was skipped (e.g., retention skipped)
+	warnings []string // non-fatal issues to show under the step
+	deleted  int64    // bytes deleted by the retention step
+}
+
+// sizeEstimateMsg carries the pre-dump size estimate for a database
+type sizeEstimateMsg struct {
+	dbName string
+	size   int64
 }
 
 type fileListMsg struct {
-	files []storage.RemoteFile
-	err   error
+	files  []storage.RemoteFile
+	deltas map[string]int64 // size change of each listed file since the previous backup
+	err    error
 }
 
 // restoreStepDoneMsg is sent when a restore step completes
@@ -3559,9 +5435,23 @@ type restoreStepDoneMsg struct {
 	message   string
 	localPath string // set after download step (path to downloaded file)
 	err       error
+	warnings  []string
 	done      bool // true if restore is complete
 }
 
+// restoreBatchPlanMsg carries the backup each database of a batch restore restores
+type restoreBatchPlanMsg struct {
+	plan     []orchestrator.RestoreRequest
+	failures orchestrator.RestoreFailures // databases left out: no backup or listing failed
+}
+
+// restoreBatchProgressMsg carries an update of the running batch restore. ok is false
+// once the batch has ended.
+type restoreBatchProgressMsg struct {
+	progress orchestrator.RestoreProgress
+	ok       bool
+}
+
 // downloadProgressMsg is sent periodically during file download with progress info
 type downloadProgressMsg struct {
 	bytesDone  int64
@@ -3569,8 +5459,15 @@ type downloadProgressMsg struct {
 	speed      float64
 	done       bool
 	err        error
+	attempt    int   // download attempt in progress, from 2 once retrying
+	attempts   int   // how many attempts the download may take, set with retrying
+	retrying   error // set when a retry starts: why the previous attempt failed
 }
 
+// stallCheckMsg is sent every stallCheckInterval while transfers run, to check whether
+// any has stopped moving bytes
+type stallCheckMsg struct{}
+
 // uploadProgressMsg is sent periodically during file upload with progress info
 type uploadProgressMsg struct {
 	dbName     string
@@ -3586,12 +5483,15 @@ type startUploadMsg struct {
 	dbName     string
 	backupPath string
 	dest       string
+	atomic     bool           // upload under a .part name and rename once complete
+	result     *backup.Result // the dump, moved to the local tier of a tiered dest
 }
 
 // testResultMsg is sent when a connection/destination test completes
 type testResultMsg struct {
 	testType string // "connection" or "destination"
 	success  bool
+	skipped  bool // test not run (e.g. needs input only available interactively)
 	message  string
 }
 
@@ -3601,6 +5501,21 @@ type rcloneTestResultMsg struct {
 	message string
 }
 
+// staleCheckMsg is sent when the check for databases without a recent backup is done
+type staleCheckMsg struct {
+	stale []orchestrator.StaleDatabase
+}
+
+// rcloneUsageMsg is sent when the space usage of a remote has been checked
+type rcloneUsageMsg struct {
+	remote   string
+	dests    int // configured destinations on the remote
+	usage    orchestrator.BackupUsage
+	space    storage.Space
+	spaceErr error // why the remote's total and free space are unknown
+	err      error
+}
+
 // dbTestResultMsg is sent when a database test completes
 type dbTestResultMsg struct {
 	testType string // "connection" or "destination"
@@ -3630,59 +5545,484 @@ func (m model) allBackupsDone() bool {
 	return true
 }
 
+// backupDB returns the config of a database in the backup run, including entries
+// expanded from database "*"
+func (m model) backupDB(name string) config.Database {
+	if m.backupCfg != nil {
+		return m.backupCfg.Databases[name]
+	}
+	return m.cfg.Databases[name]
+}
+
+// backupExpandMsg is sent when the database "*" entries of the backup queue are expanded
+type backupExpandMsg struct {
+	cfg      *config.Config
+	queue    []string
+	warnings []string
+	err      error
+}
+
+// runExpandDatabasesCmd lists the databases of the database "*" entries in queue
+func (m model) runExpandDatabasesCmd(queue []string) tea.Cmd {
+	cfg := m.cfg
+	return func() tea.Msg {
+		expanded, names, warnings, err := orchestrator.ExpandDatabases(context.Background(), cfg, queue)
+		return backupExpandMsg{cfg: expanded, queue: names, warnings: warnings, err: err}
+	}
+}
+
+// credentialCheckMsg is sent when the database credentials of the backup queue are tested
+type credentialCheckMsg struct {
+	failures orchestrator.CredentialFailures // dbName -> error of the rejected connection
+}
+
+// runSelectedBackups backs up the databases selected for backup, in config order, once
+// the database "*" entries among them are expanded
+func (m model) runSelectedBackups() (tea.Model, tea.Cmd) {
+	// Build ordered queue of selected databases (from ALL databases, not just filtered)
+	m.backupQueue = nil
+	for _, name := range m.dbNames {
+		if m.selected[name] {
+			m.backupQueue = append(m.backupQueue, name)
+		}
+	}
+	if len(m.backupQueue) == 0 {
+		m.err = fmt.Errorf("no databases selected")
+		m.view = viewDone
+		m.resumePending = nil
+		return m, nil
+	}
+	m.backupSelection = slices.Clone(m.backupQueue)
+
+	// Reset cursor for backup running view
+	m.cursor = 0
+
+	m.backupCfg = m.cfg
+	m.backupWarnings = nil
+	for _, name := range m.backupQueue {
+		if m.cfg.Databases[name].AllDatabases() {
+			// Find the databases on the server before anything else
+			m.view = viewBackupExpand
+			return m, tea.Batch(m.spinner.Tick, m.runExpandDatabasesCmd(m.backupQueue))
+		}
+	}
+	return m.beginBackups()
+}
+
+// resumeInterruptedRun backs up again the databases the interrupted run left pending or
+// running, with its retention options. Database "*" entries are expanded again and
+// only their unfinished databases backed up.
+func (m model) resumeInterruptedRun() (tea.Model, tea.Cmd) {
+	run := m.interruptedRun
+	m.interruptedRun = nil
+
+	m.resumePending = make(map[string]bool)
+	for _, name := range run.Unfinished() {
+		m.resumePending[name] = true
+	}
+	m.selected = make(map[string]bool)
+	for _, name := range run.Selected {
+		if _, ok := m.cfg.Databases[name]; ok {
+			m.selected[name] = true
+		}
+	}
+	m.skipRetention = run.SkipRetention
+	m.retentionDryRun = run.RetentionDryRun
+	m.dryRun = false
+	return m.runSelectedBackups()
+}
+
+// beginBackups tests the database credentials first when fail_fast_on_auth is set, then
+// the destinations, then checks retention and starts the backups
+func (m model) beginBackups() (tea.Model, tea.Cmd) {
+	// Resuming an interrupted run backs up only the databases it did not finish
+	if m.resumePending != nil {
+		m.backupQueue = slices.DeleteFunc(slices.Clone(m.backupQueue), func(name string) bool { return !m.resumePending[name] })
+		m.resumePending = nil
+		if len(m.backupQueue) == 0 {
+			m.err = fmt.Errorf("nothing to resume: the pending databases of the interrupted run are no longer configured")
+			m.view = viewDone
+			return m, nil
+		}
+	}
+
+	if m.cfg.FailFastOnAuth {
+		m.view = viewCredentialCheck
+		return m, tea.Batch(m.spinner.Tick, m.runCredentialCheckCmd(m.backupQueue))
+	}
+	return m.checkDestinations()
+}
+
+// destinationCheckMsg is sent when the destinations of the backup queue are tested
+type destinationCheckMsg struct {
+	failures orchestrator.DestinationFailures // dbName -> error accessing its destination
+}
+
+// checkDestinations tests access to the destinations of the backup queue, then checks
+// retention. Dry runs don't upload, so they skip the test, as do runs with "Test
+// destinations first" unchecked.
+func (m model) checkDestinations() (tea.Model, tea.Cmd) {
+	if m.dryRun || m.skipPreflight {
+		return m.preCheckRetention()
+	}
+
+	// Capture a snapshot of the config so edits can't race with the check
+	snapshot := &config.Config{Databases: make(map[string]config.Database)}
+	for _, name := range m.backupQueue {
+		snapshot.Databases[name] = m.backupDB(name)
+	}
+	queue := m.backupQueue
+
+	m.view = viewDestinationCheck
+	return m, tea.Batch(m.spinner.Tick, func() tea.Msg {
+		return destinationCheckMsg{failures: orchestrator.CheckDestinations(context.Background(), snapshot, queue)}
+	})
+}
+
+// runCredentialCheckCmd tests the connections of the databases in queue
+func (m model) runCredentialCheckCmd(queue []string) tea.Cmd {
+	// Capture a snapshot of the config so edits can't race with the check
+	snapshot := &config.Config{Databases: make(map[string]config.Database)}
+	for _, name := range queue {
+		snapshot.Databases[name] = m.backupDB(name)
+	}
+
+	return func() tea.Msg {
+		return credentialCheckMsg{failures: orchestrator.CheckCredentials(snapshot, queue)}
+	}
+}
+
+// preCheckRetention checks retention for the backup queue if needed, then starts the backups
+func (m model) preCheckRetention() (tea.Model, tea.Cmd) {
+	m.retentionAfterUpload = false
+
+	// Skip retention pre-check if dry-run or skip-retention is enabled
+	if m.dryRun || m.skipRetention {
+		return m.startBackups()
+	}
+
+	// skip_retention_precheck: no listing or confirmation before dumping, retention
+	// decides after each upload
+	if m.cfg.SkipRetentionPrecheck {
+		m.retentionAfterUpload = true
+		m.retentionPlan = nil
+		m.retentionFailures = nil
+		return m.startBackups()
+	}
+
+	// Check if any selected database has retention policy
+	hasRetention := false
+	for _, name := range m.backupQueue {
+		db := m.backupDB(name)
+		if db.HasRetention() && !db.Immutable {
+			hasRetention = true
+			break
+		}
+	}
+
+	if hasRetention {
+		// Pre-check retention policies before starting backups
+		m.view = viewRetentionPreCheck
+		m.retentionPlan = nil
+		m.retentionFailures = nil
+		return m, tea.Batch(m.spinner.Tick, m.runRetentionPreCheck(m.backupQueue, 1))
+	}
+
+	// No retention to check, start backups directly
+	return m.startBackups()
+}
+
 // startBackups initializes and starts the backup process for all DBs in parallel
 func (m model) startBackups() (tea.Model, tea.Cmd) {
+	// Prevent overlapping runs against the same config (e.g. a cron-triggered CLI run)
+	runLock, err := acquireRunLock(m.cfg)
+	if err != nil {
+		m.err = err
+		m.view = viewDone
+		return m, nil
+	}
+	m.runLock = runLock
+
 	m.backupStates = make(map[string]*dbBackupState)
+	m.backupStartedAt = time.Now()
+	m.runReportStatus = ""
 	m.view = viewBackupRunning
 
+	// Databases of a consistency group dump while their group's session is consistent
+	runCfg := m.backupCfg
+	if runCfg == nil {
+		runCfg = m.cfg
+	}
+	m.consistencyGroups = backup.NewConsistencyGroups(runCfg, m.backupQueue)
+	runCtx := backup.WithConsistencyGroups(context.Background(), m.consistencyGroups)
+
 	// Initialize state for each DB and start all dumps in parallel
 	var cmds []tea.Cmd
 	cmds = append(cmds, m.spinner.Tick)
 
 	for _, name := range m.backupQueue {
+		ctx, cancel := context.WithCancel(runCtx)
 		m.backupStates[name] = &dbBackupState{
-			currentStep: stepDumping,
+			currentStep: stepWaiting,
+			ctx:         ctx,
+			cancel:      cancel,
 		}
-		cmds = append(cmds, m.runBackupStepFor(name))
+	}
+	cmds = append(cmds, m.startReadyBackups()...)
+	m.saveRunState()
+	if !m.dryRun {
+		m.interruptedRun = nil // its state file is replaced by this run's
 	}
 
 	return m, tea.Batch(cmds...)
 }
 
+// saveRunState records which databases of the backup run are done, failed, running or
+// pending in the run state file, so the run can be resumed if the TUI is killed. Dry
+// runs upload nothing and are not recorded. Best effort: the run goes on without it.
+func (m model) saveRunState() {
+	if m.dryRun || m.cfg.Path() == "" {
+		return
+	}
+	run := &runstate.State{
+		Started:         m.backupStartedAt,
+		Selected:        m.backupSelection,
+		SkipRetention:   m.skipRetention,
+		RetentionDryRun: m.retentionDryRun,
+	}
+	for _, name := range m.backupQueue {
+		status := runstate.Running
+		switch state := m.backupStates[name]; {
+		case state == nil || state.currentStep == stepWaiting:
+			status = runstate.Pending
+		case state.done && state.failed():
+			status = runstate.Failed
+		case state.done:
+			status = runstate.Done
+		}
+		run.Databases = append(run.Databases, runstate.Database{Name: name, Status: status})
+	}
+	_ = runstate.Save(runstate.PathFor(m.cfg.Path()), run)
+}
+
+// acquireRunLock takes the run lock of cfg. A config without a file (never saved) has no
+// run to share the lock with, so nothing is locked and the lock returned is nil.
+func acquireRunLock(cfg *config.Config) (*lock.Lock, error) {
+	if cfg.Path() == "" {
+		return nil, nil
+	}
+	return lock.Acquire(lock.PathFor(cfg.Path()))
+}
+
+// interruptedRun returns the state left by a backup run of cfg that was killed before it
+// ended, or nil. A state whose run is still going in another process is not one, and a
+// state with nothing left to resume is removed.
+func interruptedRun(cfg *config.Config) *runstate.State {
+	if cfg.Path() == "" || lock.Held(lock.PathFor(cfg.Path())) {
+		return nil
+	}
+	path := runstate.PathFor(cfg.Path())
+	run, err := runstate.Load(path)
+	if err != nil || run == nil {
+		return nil
+	}
+	if len(run.Unfinished()) == 0 {
+		runstate.Remove(path)
+		return nil
+	}
+	return run
+}
+
+// pendingDependencies returns the databases of the run that name is backed up after and
+// that have not finished yet
+func (m model) pendingDependencies(name string) []string {
+	var pending []string
+	for _, dep := range m.backupDB(name).After {
+		if state := m.backupStates[dep]; state != nil && !state.done {
+			pending = append(pending, dep)
+		}
+	}
+	return pending
+}
+
+// startReadyBackups starts the dumps of waiting databases whose dependencies have all
+// finished, and returns their commands
+func (m model) startReadyBackups() []tea.Cmd {
+	var cmds []tea.Cmd
+	for _, name := range m.backupQueue {
+		state := m.backupStates[name]
+		if state == nil || state.currentStep != stepWaiting || len(m.pendingDependencies(name)) > 0 {
+			continue
+		}
+		state.currentStep = stepDumping
+		cmds = append(cmds, m.runBackupStepFor(name), m.runSizeEstimateCmd(name))
+	}
+	return cmds
+}
+
+// runSizeEstimateCmd estimates the dump size of a database while it is being dumped
+func (m model) runSizeEstimateCmd(name string) tea.Cmd {
+	db := m.backupDB(name)
+	settings := backup.SettingsFrom(m.cfg)
+	return func() tea.Msg {
+		// The estimate is informational only, so failures are ignored
+		size, _ := backup.EstimateSize(context.Background(), db, settings)
+		return sizeEstimateMsg{dbName: name, size: size}
+	}
+}
+
 // runRetentionPreCheck checks retention policies for all selected databases
-func (m model) runRetentionPreCheck() tea.Cmd {
-	// Capture values needed inside the closure
-	queue := m.backupQueue
-	databases := make(map[string]config.Database)
+func (m model) runRetentionPreCheck(queue []string, pendingBackups int) tea.Cmd {
+	// Capture a snapshot of the config so edits can't race with the check
+	snapshot := &config.Config{Databases: make(map[string]config.Database)}
 	for _, name := range queue {
-		databases[name] = m.cfg.Databases[name]
+		snapshot.Databases[name] = m.backupDB(name)
 	}
 
 	return func() tea.Msg {
-		ctx := context.Background()
-		plan := make(map[string][]storage.RemoteFile)
+		// Errors listing a destination skip retention for that database, so this never fails
+		plan, failures := orchestrator.PlanRetention(context.Background(), snapshot, queue, pendingBackups)
+		return retentionPreCheckMsg{plan: plan, failures: failures}
+	}
+}
 
-		for _, name := range queue {
-			db := databases[name]
-			if db.Retention.KeepLast == 0 && db.Retention.KeepDays == 0 && db.Retention.MaxSizeMB == 0 {
-				continue
-			}
+// configEntry returns the config entry a database of the backup queue comes from: itself,
+// or the database "*" entry it was expanded from
+func (m model) configEntry(name string) string {
+	if _, ok := m.cfg.Databases[name]; ok {
+		return name
+	}
+	database := m.backupDB(name).Database
+	for entry, db := range m.cfg.Databases {
+		if db.AllDatabases() && orchestrator.ExpandedName(entry, database) == name {
+			return entry
+		}
+	}
+	return name
+}
 
-			files, err := storage.ListForDatabase(ctx, db.Dest, name)
-			if err != nil {
-				// Skip this database on error, don't fail the whole check
-				continue
+// editPlanRetention opens the retention fields of the database picked on the retention
+// pre-confirm screen, to fix its policy without starting the selection over
+func (m model) editPlanRetention() (tea.Model, tea.Cmd) {
+	m.formData = &formFields{}
+	m.formData.setRetention(m.cfg.Databases[m.configEntry(m.retentionEditDB)].DestRetention())
+	m.retentionEditForm = huh.NewForm(huh.NewGroup(m.retentionInputs()...)).
+		WithShowHelp(true).
+		WithShowErrors(true).
+		WithKeyMap(customKeyMap()).
+		WithTheme(themeAmber()).
+		WithWidth(m.formWidth())
+	m.view = viewRetentionEdit
+	return m, m.retentionEditForm.Init()
+}
+
+// savePlanRetention saves the retention policy entered for the picked database, the
+// remote tier's own when its tiered dest has one, and checks the retention plan of the
+// backup queue again
+func (m model) savePlanRetention() (tea.Model, tea.Cmd) {
+	entry := m.configEntry(m.retentionEditDB)
+	db := m.cfg.Databases[entry]
+	policy := m.formData.retention()
+	policy.MaxCount = db.DestRetention().MaxCount // only set in the config file
+	m.cfg.Databases[entry] = db.WithDestRetention(policy)
+	m.retentionEditForm = nil
+	if err := m.cfg.Save(); err != nil {
+		m.err = fmt.Errorf("saving config: %w", err)
+		m.view = viewDone
+		return m, nil
+	}
+
+	// The databases of a database "*" entry are copies made when the queue was expanded
+	if m.backupCfg != nil && m.backupCfg != m.cfg {
+		for _, name := range m.backupQueue {
+			if expanded, ok := m.backupCfg.Databases[name]; ok && m.configEntry(name) == entry {
+				m.backupCfg.Databases[name] = expanded.WithDestRetention(policy)
 			}
+		}
+	}
+
+	m.view = viewRetentionPreCheck
+	m.retentionPlan = nil
+	m.retentionFailures = nil
+	return m, tea.Batch(m.spinner.Tick, m.runRetentionPreCheck(m.backupQueue, 1))
+}
+
+// retentionQueue returns the databases shown on the retention pre-confirm screen
+func (m model) retentionQueue() []string {
+	if m.pruneDB != "" {
+		return []string{m.pruneDB}
+	}
+	return m.backupQueue
+}
+
+// pruneDoneMsg is sent when a standalone prune finishes deleting
+type pruneDoneMsg struct {
+	result  retention.DeleteResult
+	warning string // the backup index could not be updated
+}
+
+// startPrune checks what retention would delete for the database being managed,
+// without taking a new backup first
+func (m model) startPrune() (tea.Model, tea.Cmd) {
+	name := m.editingDB
+	db := m.cfg.Databases[name]
+	m.pruneDB = name
+	m.pruneResult = ""
+	m.pruneWarnings = nil
+	m.backupCfg = nil // the retention check reads the live config
+
+	if db.Immutable {
+		msg, warnings := orchestrator.ImmutableRetention(db)
+		m.view = viewPrune
+		m.pruneResult = dimStyle.Render("○ " + msg)
+		m.pruneWarnings = warnings
+		return m, nil
+	}
+	if !db.HasRetention() {
+		m.view = viewPrune
+		m.pruneResult = dimStyle.Render("○ No retention policy")
+		return m, nil
+	}
 
-			// pendingBackups=1 because we're about to create a new backup
-			toDelete := retention.Apply(ctx, files, name, db.Retention, 1)
-			if len(toDelete) > 0 {
-				plan[name] = toDelete
-			}
-		}
+	m.view = viewRetentionPreCheck
+	m.retentionPlan = nil
+	m.retentionFailures = nil
+	return m, tea.Batch(m.spinner.Tick, m.runRetentionPreCheck([]string{name}, 0))
+}
 
-		return retentionPreCheckMsg{plan: plan}
+// runPrune deletes the confirmed retention plan for the database being pruned
+func (m model) runPrune() (tea.Model, tea.Cmd) {
+	m.view = viewPrune
+
+	// Deleting while a backup run applies retention to the same files would race
+	runLock, err := acquireRunLock(m.cfg)
+	if err != nil {
+		m.pruneResult = errorStyle.Render(fmt.Sprintf("✗ %v", err))
+		return m, nil
 	}
+	m.runLock = runLock
+	m.pruneRunning = true
+
+	db := m.cfg.Databases[m.pruneDB]
+	files := m.retentionPlan[m.pruneDB]
+	concurrency := retention.ConcurrencyFrom(m.cfg)
+	return m, tea.Batch(m.spinner.Tick, func() tea.Msg {
+		result := retention.Delete(context.Background(), db.Dest, files, concurrency)
+		destinations.invalidate(db.Dest)
+		return pruneDoneMsg{result: result, warning: orchestrator.UpdateIndex(context.Background(), db, storage.IndexChange{Deleted: result.Names})}
+	})
+}
+
+// endPrune returns from a prune to the database actions
+func (m model) endPrune() model {
+	m.view = viewDBActions
+	m.cursor = dbActionPrune
+	m.pruneDB = ""
+	m.pruneResult = ""
+	m.pruneWarnings = nil
+	m.retentionPlan = nil
+	m.retentionFailures = nil
+	return m
 }
 
 // runBackupStepFor runs the current step for a specific database
@@ -3692,25 +6032,34 @@ func (m model) runBackupStepFor(name string) tea.Cmd {
 		return nil
 	}
 
-	db := m.cfg.Databases[name]
+	db := m.backupDB(name)
 	step := state.currentStep
 
 	// Capture values needed inside the closure to avoid race conditions
 	skipRetention := m.skipRetention
+	retentionDryRun := m.retentionDryRun
 	dryRun := m.dryRun
+	result := state.result
 	var backupPath string
-	if state.result != nil {
-		backupPath = state.result.Path
+	if result != nil {
+		backupPath = result.Path
 	}
 	// Get pre-calculated retention files for this database
 	retentionFiles := m.retentionPlan[name]
+	retentionErr := m.retentionFailures[name]
+	retentionAfterUpload := m.retentionAfterUpload
+	settings, concurrency := backup.SettingsFrom(m.cfg), retention.ConcurrencyFrom(m.cfg)
+	ctx := state.context()
 
 	return func() tea.Msg {
-		ctx := context.Background()
 
 		switch step {
 		case stepDumping:
-			result, err := backup.Run(name, db)
+			if err := orchestrator.CheckTempSpace(ctx, name, db, 0, settings); err != nil {
+				backup.SkipConsistencyGroup(ctx, db)
+				return backupStepDoneMsg{dbName: name, step: stepDumping, err: err}
+			}
+			result, err := backup.Run(ctx, name, db, settings)
 			if err != nil {
 				return backupStepDoneMsg{
 					dbName: name,
@@ -3722,7 +6071,7 @@ func (m model) runBackupStepFor(name string) tea.Cmd {
 				dbName:  name,
 				step:    stepDumping,
 				result:  result,
-				message: fmt.Sprintf("Dumped %s (%s)", result.Filename, humanize.IBytes(uint64(result.Size))),
+				message: fmt.Sprintf("Dumped %s (%s)", result.Filename, result.SizeSummary()),
 			}
 
 		case stepUploading:
@@ -3743,16 +6092,52 @@ func (m model) runBackupStepFor(name string) tea.Cmd {
 				}
 			}
 
+			// The upload goes in the backup's dated folder with group_by
+			uploadDB := db
+			uploadDB.Dest = orchestrator.UploadDest(db, filepath.Base(backupPath))
+
+			if db.Immutable {
+				if err := orchestrator.CheckImmutableName(ctx, uploadDB, filepath.Base(backupPath)); err != nil {
+					return backupStepDoneMsg{dbName: name, step: stepUploading, err: err}
+				}
+			}
+			// A tiered dest keeps the dump in its local tier and uploads it from there. The
+			// move is made on a copy, handed to Update with the message, as Update reads
+			// the state's result concurrently.
+			kept := *result
+			if err := orchestrator.KeepInLocalTier(db, &kept); err != nil {
+				return backupStepDoneMsg{dbName: name, step: stepUploading, err: err}
+			}
+
 			// Return a message to trigger upload with progress tracking
 			return startUploadMsg{
 				dbName:     name,
-				backupPath: backupPath,
-				dest:       db.Dest,
+				backupPath: kept.Path,
+				dest:       uploadDB.Dest,
+				atomic:     db.AtomicUpload,
+				result:     &kept,
 			}
 
 		case stepRetention:
+			// Deciding what to delete needs a complete listing
+			if retentionErr != nil && !dryRun && !skipRetention && !db.Immutable {
+				return backupStepDoneMsg{dbName: name, step: stepRetention, err: retentionErr}
+			}
+
+			// Without the pre-check, retention decides on a listing taken after the
+			// upload, as headless runs do
+			if retentionAfterUpload && !dryRun && !skipRetention && !db.Immutable && db.HasRetention() && result != nil {
+				_, toDelete, err := orchestrator.RetentionAfterUpload(ctx, db, name, result, concurrency)
+				if err != nil {
+					return backupStepDoneMsg{dbName: name, step: stepRetention, err: err}
+				}
+				retentionFiles = toDelete
+			}
+
 			var message string
 			var skipped bool
+			var warnings []string
+			var deletedBytes int64
 
 			if dryRun {
 				message = "Retention skipped (dry-run)"
@@ -3760,16 +6145,20 @@ func (m model) runBackupStepFor(name string) tea.Cmd {
 			} else if skipRetention {
 				message = "Retention skipped"
 				skipped = true
+			} else if db.Immutable {
+				message, warnings = orchestrator.ImmutableRetention(db)
+				skipped = true
+			} else if len(retentionFiles) > 0 && retentionDryRun {
+				message = retention.PlanMessage(retentionFiles)
 			} else if len(retentionFiles) > 0 {
-				// Delete pre-calculated files (user already confirmed)
-				var deleted int
-				for _, f := range retentionFiles {
-					if err := storage.Delete(ctx, db.Dest, f.Name); err == nil {
-						deleted++
-					}
+				// Delete pre-calculated files (user already confirmed) or those decided above
+				deleted := retention.Delete(ctx, db.Dest, retentionFiles, concurrency)
+				message, warnings = deleted.Message(), deleted.Warnings()
+				deletedBytes = deleted.DeletedBytes
+				if warning := orchestrator.UpdateIndex(ctx, db, storage.IndexChange{Deleted: deleted.Names}); warning != "" {
+					warnings = append(warnings, warning)
 				}
-				message = fmt.Sprintf("Deleted %d old backup(s)", deleted)
-			} else if db.Retention.KeepLast > 0 || db.Retention.KeepDays > 0 || db.Retention.MaxSizeMB > 0 {
+			} else if db.HasRetention() {
 				message = "No old backups to delete"
 				skipped = true
 			} else {
@@ -3777,11 +6166,37 @@ func (m model) runBackupStepFor(name string) tea.Cmd {
 				skipped = true
 			}
 
+			// The local tier of a tiered dest has its own backups to apply the policy to
+			if !dryRun && !skipRetention && !db.Immutable && result != nil {
+				local, localWarnings, err := orchestrator.LocalTierRetention(ctx, db, name, result, retentionDryRun, concurrency)
+				if err != nil {
+					return backupStepDoneMsg{dbName: name, step: stepRetention, err: err}
+				}
+				if local != "" {
+					message = fmt.Sprintf("%s; %s from the local tier", message, local)
+					warnings = append(warnings, localWarnings...)
+					skipped = false
+				}
+			}
+			if retentionDryRun && !dryRun && !skipRetention && !db.Immutable && db.HasRetention() {
+				message += " (dry-run)"
+			}
+
+			// Check the backups retention kept while it has the destination open, from the
+			// listing headless runs decide on
+			if !dryRun && !skipRetention && !db.Immutable && db.HasRetention() && db.VerifyOnRetention > 0 && result != nil {
+				if files, err := orchestrator.ListAfterUpload(ctx, db, name, result, concurrency); err == nil {
+					warnings = append(warnings, orchestrator.VerifyKept(ctx, db, name, files, retentionFiles, settings)...)
+				}
+			}
+
 			return backupStepDoneMsg{
-				dbName:  name,
-				step:    stepRetention,
-				message: message,
-				skipped: skipped,
+				dbName:   name,
+				step:     stepRetention,
+				message:  message,
+				skipped:  skipped,
+				warnings: warnings,
+				deleted:  deletedBytes,
 			}
 		}
 
@@ -3796,6 +6211,20 @@ func (m model) handleBackupStepDone(msg backupStepDoneMsg) (tea.Model, tea.Cmd)
 		return m, nil
 	}
 
+	// Uploads and retention change the destination, even when they fail part way
+	if msg.step == stepUploading || msg.step == stepRetention {
+		destinations.invalidate(m.backupDB(msg.dbName).Dest)
+	}
+
+	// A cancelled database stops here, whatever its step produced
+	if state.cancelled {
+		if msg.result != nil {
+			backup.Cleanup(msg.result)
+		}
+		m.endCancelledBackup(msg.dbName)
+		return m, m.advanceBackups()
+	}
+
 	// Log the completed step
 	entry := backupLogEntry{
 		DBName:    msg.dbName,
@@ -3807,22 +6236,29 @@ func (m model) handleBackupStepDone(msg backupStepDoneMsg) (tea.Model, tea.Cmd)
 	if msg.err != nil {
 		entry.Message = msg.err.Error()
 	}
+	if msg.result != nil {
+		entry.Warnings = msg.result.Warnings
+	}
+	entry.Warnings = append(entry.Warnings, msg.warnings...)
+	// A dump that wasn't confirmed on the destination is kept, since it may be the only
+	// good copy
+	if msg.err != nil && state.result != nil {
+		entry.Warnings = append(entry.Warnings, orchestrator.KeepFailedUpload(m.backupCfg, state.result))
+	}
 	state.logs = append(state.logs, entry)
 
 	// Handle errors - mark this DB as done
 	if msg.err != nil {
-		if state.result != nil {
-			backup.Cleanup(state.result)
-			state.result = nil
-		}
 		state.done = true
 		state.currentStep = stepIdle
-		return m, m.checkAllBackupsDone()
+		return m, m.advanceBackups()
 	}
 
 	// Save result from dump step for upload
 	if msg.step == stepDumping && msg.result != nil {
 		state.result = msg.result
+		state.filename = msg.result.Filename
+		state.size = msg.result.Size
 	}
 
 	// Advance to next step
@@ -3832,24 +6268,90 @@ func (m model) handleBackupStepDone(msg backupStepDoneMsg) (tea.Model, tea.Cmd)
 	case stepUploading:
 		// Clean up upload state
 		delete(m.uploadStates, msg.dbName)
-		state.currentStep = stepRetention
-	case stepRetention:
-		// Clean up the backup result (skip in dry-run so user can access the file)
+		// The upload is confirmed, so the local dump can go (kept in dry-run so the
+		// user can access the file)
 		if state.result != nil && !m.dryRun {
 			backup.Cleanup(state.result)
 			state.result = nil
 		}
+		if !m.dryRun {
+			state.uploadedSize = state.size
+		}
+		state.currentStep = stepRetention
+	case stepRetention:
+		state.deletedSize = msg.deleted
 		state.done = true
 		state.currentStep = stepIdle
-		return m, m.checkAllBackupsDone()
+		return m, m.advanceBackups()
 	}
 
 	// Continue with next step for this DB
 	return m, tea.Batch(m.spinner.Tick, m.runBackupStepFor(msg.dbName))
 }
 
-// checkAllBackupsDone checks if all backups are complete and transitions to done view
-func (m model) checkAllBackupsDone() tea.Cmd {
+// storageChange sums the bytes the backup run uploaded and deleted
+func (m model) storageChange() orchestrator.StorageChange {
+	var change orchestrator.StorageChange
+	for _, state := range m.backupStates {
+		change.Uploaded += state.uploadedSize
+		change.Deleted += state.deletedSize
+	}
+	return change
+}
+
+// cancelBackup cancels the dump or upload of a database in the running backup, leaving
+// the other databases running. Retention is not interrupted once it has started.
+func (m model) cancelBackup(name string) tea.Cmd {
+	state := m.backupStates[name]
+	if state == nil || state.done || state.cancelled || state.currentStep == stepRetention {
+		return nil
+	}
+	state.cancelled = true
+	if state.cancel != nil {
+		state.cancel()
+	}
+	// A database still waiting has no step in flight to report the cancellation
+	if state.currentStep == stepWaiting {
+		backup.SkipConsistencyGroup(state.ctx, m.backupDB(name))
+		m.endCancelledBackup(name)
+		return m.advanceBackups()
+	}
+	return nil
+}
+
+// endCancelledBackup records a cancelled database as done and removes its local dump
+func (m model) endCancelledBackup(name string) {
+	state := m.backupStates[name]
+	delete(m.uploadStates, name)
+	if state.result != nil {
+		backup.Cleanup(state.result)
+		state.result = nil
+	}
+	message := state.currentStep.String() + " cancelled"
+	if state.currentStep == stepWaiting {
+		message = "Cancelled before starting"
+	}
+	state.logs = append(state.logs, backupLogEntry{
+		DBName:      name,
+		Step:        state.currentStep,
+		Message:     message,
+		IsCancelled: true,
+	})
+	state.done = true
+	state.currentStep = stepIdle
+}
+
+// advanceBackups moves the backup run on after a database finished: it starts the
+// waiting databases that were released, saves the run state, and once every database is
+// done sends allBackupsDoneMsg
+func (m model) advanceBackups() tea.Cmd {
+	// A finished database may release the databases backed up after it
+	cmds := m.startReadyBackups()
+	m.saveRunState()
+	if len(cmds) > 0 {
+		return tea.Batch(append(cmds, m.spinner.Tick)...)
+	}
+
 	allDone := true
 	for _, state := range m.backupStates {
 		if !state.done {
@@ -3869,14 +6371,51 @@ func (m model) checkAllBackupsDone() tea.Cmd {
 // allBackupsDoneMsg signals all backups are complete
 type allBackupsDoneMsg struct{}
 
+// runReportMsg is sent after attempting to write the run report
+type runReportMsg struct {
+	path string // empty if reports are disabled
+	err  error
+}
+
+// runWriteReportCmd writes the best-effort run report for the finished backups
+func (m model) runWriteReportCmd() tea.Cmd {
+	report := orchestrator.RunReport{StartedAt: m.backupStartedAt, FinishedAt: time.Now()}
+	for _, name := range m.backupQueue {
+		state := m.backupStates[name]
+		if state == nil {
+			continue
+		}
+		entry := orchestrator.ReportDatabase{Name: name, Success: true, Filename: state.filename, Size: state.size}
+		for _, logEntry := range state.logs {
+			if logEntry.IsError || logEntry.IsCancelled {
+				entry.Success = false
+				entry.Error = logEntry.Message
+			}
+			entry.Warnings = append(entry.Warnings, logEntry.Warnings...)
+		}
+		report.Databases = append(report.Databases, entry)
+	}
+
+	cfg := m.backupCfg
+	queue := m.backupQueue
+	return func() tea.Msg {
+		path, err := orchestrator.WriteRunReportFor(context.Background(), cfg, queue, report)
+		return runReportMsg{path: path, err: err}
+	}
+}
+
 func (m model) handleDownloadProgress(msg downloadProgressMsg) (tea.Model, tea.Cmd) {
 	// Handle download error
 	if msg.err != nil {
+		message := "Downloading backup failed"
+		if m.downloadState != nil && m.downloadState.cancelled {
+			message = "Downloading backup cancelled"
+		}
 		m.err = msg.err
 		m.view = viewDone
 		m.restoreStep = restoreStepIdle
 		m.restoreLogs = append(m.restoreLogs, restoreLogEntry{
-			Message: "Downloading backup failed",
+			Message: message,
 			IsError: true,
 		})
 		m.logs = m.buildRestoreSummaryLogs()
@@ -3887,6 +6426,12 @@ func (m model) handleDownloadProgress(msg downloadProgressMsg) (tea.Model, tea.C
 	// Update progress
 	m.downloadBytesDone = msg.bytesDone
 	m.downloadSpeed = msg.speed
+	if m.downloadState != nil {
+		m.downloadState.progressed(msg.bytesDone, time.Now())
+	}
+	if msg.retrying != nil {
+		m.downloadRetry = fmt.Sprintf("Attempt %d of %d: the last one failed (%v)", msg.attempt, msg.attempts, msg.retrying)
+	}
 
 	// If done, the next message will be restoreStepDoneMsg
 	// Continue waiting for progress updates
@@ -3901,26 +6446,38 @@ func (m model) handleUploadProgress(msg uploadProgressMsg) (tea.Model, tea.Cmd)
 
 	// Handle upload error
 	if msg.err != nil {
+		if state.cancelled {
+			m.endCancelledBackup(msg.dbName)
+			return m, m.advanceBackups()
+		}
+
 		// Clean up upload state
 		delete(m.uploadStates, msg.dbName)
 
 		// Record error and move to next step
-		state.logs = append(state.logs, backupLogEntry{
+		entry := backupLogEntry{
 			DBName:  msg.dbName,
 			Step:    stepUploading,
-			Message: "Upload failed",
+			Message: "Upload failed: " + msg.err.Error(),
 			IsError: true,
-		})
+		}
+		if state.result != nil {
+			entry.Warnings = append(entry.Warnings, orchestrator.KeepFailedUpload(m.backupCfg, state.result))
+		}
+		state.logs = append(state.logs, entry)
 		state.done = true
 		state.currentStep = stepIdle
 
-		return m, m.checkAllBackupsDone()
+		return m, m.advanceBackups()
 	}
 
 	// Update progress
 	state.uploadBytesDone = msg.bytesDone
 	state.uploadBytesTotal = msg.bytesTotal
 	state.uploadSpeed = msg.speed
+	if us := m.uploadStates[msg.dbName]; us != nil {
+		us.progressed(msg.bytesDone, time.Now())
+	}
 
 	// If done, the next message will be backupStepDoneMsg
 	// Continue waiting for progress updates
@@ -3930,13 +6487,21 @@ func (m model) handleUploadProgress(msg uploadProgressMsg) (tea.Model, tea.Cmd)
 func (m model) handleRestoreStepDone(msg restoreStepDoneMsg) (tea.Model, tea.Cmd) {
 	// Log the completed step
 	entry := restoreLogEntry{
-		Message: msg.message,
-		IsError: msg.err != nil,
+		Message:  msg.message,
+		IsError:  msg.err != nil,
+		Warnings: msg.warnings,
 	}
 	if msg.err != nil {
 		// Use generic message for log entry (full error shown separately at top)
 		entry.Message = msg.step.String() + " failed"
 	}
+	// A streamed restore finishes with its download
+	if msg.step == restoreStepRestoring && m.downloadState != nil {
+		if msg.err != nil && m.downloadState.cancelled {
+			entry.Message = "Restoring database cancelled"
+		}
+		m.downloadState = nil
+	}
 	m.restoreLogs = append(m.restoreLogs, entry)
 
 	// Handle errors
@@ -3956,6 +6521,10 @@ func (m model) handleRestoreStepDone(msg restoreStepDoneMsg) (tea.Model, tea.Cmd
 
 	// Check if done
 	if msg.done {
+		if m.restoreThenBackup && msg.step != restoreStepBackingUp {
+			m.restoreStep = restoreStepBackingUp
+			return m, tea.Batch(m.spinner.Tick, m.runRestoreStep())
+		}
 		m.view = viewDone
 		m.restoreStep = restoreStepIdle
 		m.logs = m.buildRestoreSummaryLogs()
@@ -3966,6 +6535,8 @@ func (m model) handleRestoreStepDone(msg restoreStepDoneMsg) (tea.Model, tea.Cmd
 	switch msg.step {
 	case restoreStepDownloading:
 		m.restoreStep = restoreStepRestoring
+	case restoreStepRestoring:
+		m.restoreStep = restoreStepPostRestore
 	}
 
 	return m, tea.Batch(m.spinner.Tick, m.runRestoreStep())
@@ -3975,7 +6546,11 @@ func (m model) handleRestoreStepDone(msg restoreStepDoneMsg) (tea.Model, tea.Cmd
 func (m model) buildRestoreSummaryLogs() []string {
 	var logs []string
 
-	logs = append(logs, selectedStyle.Render(fmt.Sprintf("Restore to %s", m.selectedDB)))
+	if m.cloneSourceDB != "" {
+		logs = append(logs, selectedStyle.Render(fmt.Sprintf("Restore of %s's backup into %s", m.cloneSourceDB, m.selectedDB)))
+	} else {
+		logs = append(logs, selectedStyle.Render(fmt.Sprintf("Restore to %s", m.selectedDB)))
+	}
 
 	for _, entry := range m.restoreLogs {
 		if entry.IsError {
@@ -3983,6 +6558,9 @@ func (m model) buildRestoreSummaryLogs() []string {
 		} else {
 			logs = append(logs, fmt.Sprintf("  %s %s", successStyle.Render("✓"), entry.Message))
 		}
+		for _, warning := range entry.Warnings {
+			logs = append(logs, fmt.Sprintf("    %s", dimStyle.Render("⚠ "+truncateString(warning, 80))))
+		}
 	}
 
 	return logs
@@ -4010,24 +6588,56 @@ func (m model) buildBackupSummaryLogs() []string {
 		for _, entry := range state.logs {
 			if entry.IsError {
 				logs = append(logs, fmt.Sprintf("  %s %s", errorStyle.Render("✗"), errorStyle.Render(entry.Message)))
+			} else if entry.IsCancelled {
+				logs = append(logs, fmt.Sprintf("  %s %s", selectedStyle.Render("⊘"), selectedStyle.Render(entry.Message)))
 			} else if entry.IsSkipped {
 				logs = append(logs, fmt.Sprintf("  %s %s", dimStyle.Render("○"), dimStyle.Render(entry.Message)))
 			} else {
 				logs = append(logs, fmt.Sprintf("  %s %s", successStyle.Render("✓"), entry.Message))
 			}
+			for _, warning := range entry.Warnings {
+				logs = append(logs, fmt.Sprintf("    %s", dimStyle.Render("⚠ "+truncateString(warning, 80))))
+			}
 		}
 	}
 
 	return logs
 }
 
+// restoreSourceDB returns the database whose backups are being restored into selectedDB
+func (m model) restoreSourceDB() string {
+	if m.cloneSourceDB != "" {
+		return m.cloneSourceDB
+	}
+	return m.selectedDB
+}
+
+// cloneCandidatesFor returns the other configured databases of the same type as target,
+// whose backups can be restored into it
+func (m model) cloneCandidatesFor(target string) []string {
+	targetType := m.cfg.Databases[target].Type
+	var names []string
+	for _, name := range m.dbNames {
+		if name != target && m.cfg.Databases[name].Type == targetType {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// fetchBackupFiles lists every backup of the restore source for the picker, which shows
+// the newest of them (see filterRestoreFiles)
 func (m model) fetchBackupFiles() tea.Cmd {
 	return func() tea.Msg {
 		ctx := context.Background()
-		db := m.cfg.Databases[m.selectedDB]
+		source := m.restoreSourceDB()
+		db := m.cfg.Databases[source]
 
-		files, err := storage.ListForDatabase(ctx, db.Dest, m.selectedDB)
-		return fileListMsg{files: files, err: err}
+		files, err := destinations.listing(db.Dest, source, func() ([]storage.RemoteFile, error) {
+			return orchestrator.ListBackupsIndexed(ctx, source, db)
+		})
+		files = slices.DeleteFunc(files, func(f storage.RemoteFile) bool { return !isSelectableBackup(f.Name) })
+		return fileListMsg{files: files, deltas: retention.SizeDeltas(files), err: err}
 	}
 }
 
@@ -4042,6 +6652,7 @@ func (m model) startRestore() (tea.Model, tea.Cmd) {
 	m.view = viewRestoreRunning
 	m.downloadBytesDone = 0
 	m.downloadSpeed = 0
+	m.downloadRetry = ""
 	m.downloadState = nil
 
 	if m.isLocalRestore {
@@ -4058,12 +6669,167 @@ func (m model) startRestore() (tea.Model, tea.Cmd) {
 	return m, tea.Batch(m.spinner.Tick, cmd)
 }
 
+// restoreBatchNames returns the databases picked for a batch restore, in list order
+func (m model) restoreBatchNames() []string {
+	var names []string
+	for _, name := range m.dbNames {
+		if m.restoreBatchSelected[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// buildRestoreBatchAtForm builds the input of the point in time a batch restore brings
+// its databases back to
+func (m *model) buildRestoreBatchAtForm() *huh.Form {
+	// Allocate on heap so pointer survives bubbletea model copies
+	value := ""
+	if !m.restoreBatchAt.IsZero() {
+		value = m.restoreBatchAt.Format("2006-01-02 15:04:05")
+	}
+	m.restoreBatchInput = &value
+
+	input := huh.NewInput().
+		Key("at").
+		Title("Restore as of").
+		Description("Each database restores its newest backup taken at or before this time (YYYY-MM-DD [HH:MM]); empty for the latest").
+		Placeholder("latest").
+		Value(m.restoreBatchInput).
+		Validate(func(s string) error {
+			_, err := orchestrator.ParseRestorePoint(s)
+			return err
+		})
+
+	return huh.NewForm(huh.NewGroup(input)).
+		WithShowHelp(true).
+		WithShowErrors(true).
+		WithTheme(themeAmber()).
+		WithKeyMap(customKeyMap()).
+		WithWidth(m.formWidth())
+}
+
+// buildRestoreBatchConfirmForm builds the input the number of databases the batch
+// overwrites must be typed in to start it
+func (m *model) buildRestoreBatchConfirmForm() *huh.Form {
+	// Allocate on heap so pointer survives bubbletea model copies
+	value := ""
+	m.restoreBatchInput = &value
+	count := strconv.Itoa(len(m.restoreBatchPlan))
+
+	input := huh.NewInput().
+		Key("confirm").
+		Title(fmt.Sprintf("Type %s to restore %s databases", count, count)).
+		Value(m.restoreBatchInput).
+		Validate(func(s string) error {
+			if strings.TrimSpace(s) != count {
+				return fmt.Errorf("type %s to confirm", count)
+			}
+			return nil
+		})
+
+	return huh.NewForm(huh.NewGroup(input)).
+		WithShowHelp(true).
+		WithShowErrors(true).
+		WithTheme(themeAmber()).
+		WithKeyMap(customKeyMap()).
+		WithWidth(m.formWidth())
+}
+
+// cycleRestoreBatchSource moves the database picked for a batch restore on to the next
+// database whose backups it can restore (see cloneCandidatesFor), back to its own backups
+// after the last one, like --source target=source of restore-batch
+func (m *model) cycleRestoreBatchSource(name string) {
+	candidates := m.cloneCandidatesFor(name)
+	next := ""
+	if i := slices.Index(candidates, m.restoreBatchSources[name]); i+1 < len(candidates) {
+		next = candidates[i+1]
+	}
+	if m.restoreBatchSources == nil {
+		m.restoreBatchSources = make(map[string]string)
+	}
+	if next == "" {
+		delete(m.restoreBatchSources, name)
+	} else {
+		m.restoreBatchSources[name] = next
+	}
+}
+
+// planRestoreBatch picks the backup each database of the batch restores
+func (m model) planRestoreBatch() tea.Cmd {
+	cfg, databases, sources, at := m.cfg, m.restoreBatchNames(), maps.Clone(m.restoreBatchSources), m.restoreBatchAt
+	return func() tea.Msg {
+		plan, failures := orchestrator.PlanRestoreBatch(context.Background(), cfg, databases, sources, at)
+		return restoreBatchPlanMsg{plan: plan, failures: failures}
+	}
+}
+
+// startRestoreBatch restores the planned databases in the background, restore_concurrency
+// at a time, and follows their progress
+func (m model) startRestoreBatch() (tea.Model, tea.Cmd) {
+	m.restoreBatchForm = nil
+	m.restoreBatchInput = nil
+	m.restoreBatchStates = make(map[string]*restoreBatchState, len(m.restoreBatchPlan))
+	for _, req := range m.restoreBatchPlan {
+		m.restoreBatchStates[req.Name] = &restoreBatchState{}
+	}
+	m.view = viewRestoreBatchRunning
+	m.cursor = 0
+
+	progress := make(chan orchestrator.RestoreProgress, 100)
+	m.restoreBatchProgress = progress
+	cfg, plan, concurrency := m.cfg, m.restoreBatchPlan, m.cfg.RestoreBatchConcurrency()
+	go func() {
+		orchestrator.RestoreBatch(context.Background(), cfg, plan, concurrency, progress)
+		close(progress)
+	}()
+	return m, tea.Batch(m.spinner.Tick, m.waitForRestoreBatchProgress())
+}
+
+// waitForRestoreBatchProgress waits for the next update of the running batch restore
+func (m model) waitForRestoreBatchProgress() tea.Cmd {
+	progress := m.restoreBatchProgress
+	if progress == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		p, ok := <-progress
+		return restoreBatchProgressMsg{progress: p, ok: ok}
+	}
+}
+
+// restoreBatchDone returns true once every database of the batch restore has finished
+func (m model) restoreBatchDone() bool {
+	return m.restoreBatchStates != nil && m.restoreBatchProgress == nil
+}
+
+// endRestoreBatch leaves the finished batch restore for the main menu
+func (m model) endRestoreBatch() model {
+	m.view = viewMainMenu
+	m.cursor = 0
+	m.restoreBatchSelected = nil
+	m.restoreBatchSources = nil
+	m.restoreBatchPlan = nil
+	m.restoreBatchFailures = nil
+	m.restoreBatchStates = nil
+	return m
+}
+
 // runRestoreStep runs the current step in the restore process
 func (m model) runRestoreStep() tea.Cmd {
 	db := m.cfg.Databases[m.selectedDB]
+	settings := backup.SettingsFrom(m.cfg)
 	step := m.restoreStep
 	localPath := m.restoreLocalPath
 
+	// A database "*" entry restores into the database the backup was taken from
+	db, err := orchestrator.RestoreTarget(m.restoreSourceDB(), db, filepath.Base(m.selectedFile))
+	if err != nil {
+		return func() tea.Msg {
+			return restoreStepDoneMsg{step: step, err: err}
+		}
+	}
+
 	switch step {
 	case restoreStepDownloading:
 		// Download progress is handled via downloadState which is set up before this is called
@@ -4074,17 +6840,54 @@ func (m model) runRestoreStep() tea.Cmd {
 		return m.waitForDownloadProgress()
 
 	case restoreStepRestoring:
+		cfg, name, fileName := m.cfg, m.selectedDB, m.selectedFile
+		source := m.cfg.Databases[m.restoreSourceDB()].Dest
+		if m.isLocalRestore {
+			source = "local"
+		}
 		return func() tea.Msg {
-			if err := backup.Restore(db, localPath); err != nil {
+			var size int64
+			if stat, err := os.Stat(localPath); err == nil {
+				size = stat.Size()
+			}
+			err := backup.Restore(db, localPath, settings)
+			warnings := orchestrator.WriteRestoreRecord(context.Background(), cfg, orchestrator.NewRestoreRecord(name, source, fileName, size, err))
+			if err != nil {
 				return restoreStepDoneMsg{
-					step: restoreStepRestoring,
-					err:  err,
+					step:     restoreStepRestoring,
+					err:      err,
+					warnings: warnings,
 				}
 			}
 
 			return restoreStepDoneMsg{
-				step:    restoreStepRestoring,
-				message: fmt.Sprintf("Restored to %s", db.Database),
+				step:     restoreStepRestoring,
+				message:  fmt.Sprintf("Restored to %s", db.Database),
+				warnings: warnings,
+				done:     !backup.HasPostRestore(db),
+			}
+		}
+
+	case restoreStepPostRestore:
+		return func() tea.Msg {
+			msg, err := backup.PostRestore(db, settings)
+			return restoreStepDoneMsg{
+				step:    restoreStepPostRestore,
+				message: msg,
+				err:     err,
+				done:    true,
+			}
+		}
+
+	case restoreStepBackingUp:
+		cfg, name, database := m.cfg, m.selectedDB, db.Database
+		return func() tea.Msg {
+			msg, err := backupAfterRestore(cfg, name, database)
+			destinations.invalidate(cfg.Databases[name].Dest)
+			return restoreStepDoneMsg{
+				step:    restoreStepBackingUp,
+				message: msg,
+				err:     err,
 				done:    true,
 			}
 		}
@@ -4093,37 +6896,118 @@ func (m model) runRestoreStep() tea.Cmd {
 	return nil
 }
 
+// backupAfterRestore backs up a database that was just restored, so the restored state
+// becomes its newest backup. Of a database "*" entry, only database (the one restored
+// into) is backed up. Returns the message describing the backup.
+func backupAfterRestore(cfg *config.Config, name, database string) (string, error) {
+	ctx := context.Background()
+	runLock, err := acquireRunLock(cfg)
+	if err != nil {
+		return "", err
+	}
+	defer runLock.Release()
+
+	runCfg := cfg
+	if cfg.Databases[name].AllDatabases() {
+		if runCfg, name, err = orchestrator.ExpandedConfig(cfg, name, database); err != nil {
+			return "", err
+		}
+	}
+
+	progress := make(chan orchestrator.BackupProgress, 100)
+	go func() {
+		for range progress {
+		}
+	}()
+	results := orchestrator.RunBackups(ctx, runCfg, []string{name}, orchestrator.BackupOptions{}, nil, progress)
+	close(progress)
+
+	var files []string
+	for _, r := range results {
+		if r.Error != nil {
+			return "", fmt.Errorf("backing up %s: %w", r.DBName, r.Error)
+		}
+		files = append(files, r.Filename)
+	}
+	return fmt.Sprintf("Backed up as %s", strings.Join(files, ", ")), nil
+}
+
 // startDownload initializes download state and starts the download goroutine
 // Returns the model with downloadState set and a command to wait for progress
 func (m model) startDownload() (model, tea.Cmd) {
-	db := m.cfg.Databases[m.selectedDB]
+	db := m.cfg.Databases[m.restoreSourceDB()]
 	fileName := m.selectedFile
 	fileSize := m.selectedFileSize
 	remoteDest := db.Dest
 
-	tmpDir, err := createTempDir()
+	// A database "*" entry restores into the database the backup was taken from
+	target, err := orchestrator.RestoreTarget(m.restoreSourceDB(), m.cfg.Databases[m.selectedDB], filepath.Base(fileName))
 	if err != nil {
-		// Return an immediate error
 		return m, func() tea.Msg {
 			return downloadProgressMsg{err: err, done: true}
 		}
 	}
+	stream := orchestrator.StreamsRestore(target, fileName)
+	settings := backup.SettingsFrom(m.cfg)
+
+	var tmpDir string
+	if !stream {
+		tmpDir, err = createTempDir()
+		if err != nil {
+			// Return an immediate error
+			return m, func() tea.Msg {
+				return downloadProgressMsg{err: err, done: true}
+			}
+		}
+	}
 
 	progressCh := make(chan storage.TransferProgress, 10)
+	ctx, cancel := context.WithCancel(context.Background())
 
 	// Store state in heap-allocated struct
 	m.downloadState = &downloadState{
-		progressCh: progressCh,
-		tmpDir:     tmpDir,
-		fileName:   fileName,
-		fileSize:   fileSize,
+		transferWatch: transferWatch{lastProgress: time.Now()},
+		progressCh:    progressCh,
+		cancel:        cancel,
+		tmpDir:        tmpDir,
+		fileName:      fileName,
+		fileSize:      fileSize,
+	}
+
+	// Start download in a goroutine, restoring as it arrives with stream_restore
+	if stream {
+		m.downloadState.target = &target
+		go orchestrator.StreamRestore(ctx, target, remoteDest, fileName, fileSize, settings, progressCh)
+	} else {
+		go storage.DownloadWithProgress(ctx, remoteDest, fileName, tmpDir, fileSize, settings.Download, progressCh)
 	}
 
-	// Start download in a goroutine
-	go storage.DownloadWithProgress(context.Background(), remoteDest, fileName, tmpDir, fileSize, progressCh)
-
 	// Return command to wait for first progress update
-	return m, m.waitForDownloadProgress()
+	m, watch := m.watchStalls()
+	return m, tea.Batch(m.waitForDownloadProgress(), watch)
+}
+
+// downloading reports whether the restore view is at its download step. The download
+// state outlives the step, so its keys are only handled there.
+func (m model) downloading() bool {
+	return m.view == viewRestoreRunning && m.restoreStep == restoreStepDownloading && m.downloadState != nil
+}
+
+// retryDownload abandons the running download, stalled with no bytes moving, and
+// starts it again from the beginning
+func (m model) retryDownload() (model, tea.Cmd) {
+	ds := m.downloadState
+	if ds.target != nil {
+		// Streamed into the database: starting over would restore on top of what the
+		// stalled attempt already applied
+		return m, nil
+	}
+	ds.abandoned.Store(true)
+	ds.cancel()
+	m.downloadBytesDone = 0
+	m.downloadSpeed = 0
+	m.downloadRetry = fmt.Sprintf("Restarted after no progress for %s", ds.stalledFor)
+	return m.startDownload()
 }
 
 // waitForDownloadProgress waits for the next progress update from the channel
@@ -4132,19 +7016,30 @@ func (m model) waitForDownloadProgress() tea.Cmd {
 	if ds == nil {
 		return nil
 	}
+	cfg, name, source := m.cfg, m.selectedDB, m.cfg.Databases[m.restoreSourceDB()].Dest
 
 	return func() tea.Msg {
 		progress, ok := <-ds.progressCh
+		if ds.abandoned.Load() {
+			// Superseded by a retry: let the download wind down and drop what it wrote
+			for range ds.progressCh {
+			}
+			os.RemoveAll(ds.tmpDir)
+			return nil
+		}
 		if !ok {
 			// Channel closed, download complete
 			downloadedPath := ds.tmpDir + "/" + ds.fileName
 			return restoreStepDoneMsg{
 				step:      restoreStepDownloading,
-				message:   fmt.Sprintf("Downloaded %s (%s)", ds.fileName, humanize.IBytes(uint64(ds.fileSize))),
+				message:   ds.doneMessage(),
 				localPath: downloadedPath,
 			}
 		}
 
+		if progress.Done && ds.target != nil {
+			return streamedRestoreDone(cfg, name, source, ds, progress.Error)
+		}
 		if progress.Done {
 			if progress.Error != nil {
 				return downloadProgressMsg{err: progress.Error, done: true}
@@ -4152,7 +7047,7 @@ func (m model) waitForDownloadProgress() tea.Cmd {
 			downloadedPath := ds.tmpDir + "/" + ds.fileName
 			return restoreStepDoneMsg{
 				step:      restoreStepDownloading,
-				message:   fmt.Sprintf("Downloaded %s (%s)", ds.fileName, humanize.IBytes(uint64(ds.fileSize))),
+				message:   ds.doneMessage(),
 				localPath: downloadedPath,
 			}
 		}
@@ -4162,12 +7057,31 @@ func (m model) waitForDownloadProgress() tea.Cmd {
 			bytesTotal: progress.BytesTotal,
 			speed:      progress.Speed,
 			done:       false,
+			attempt:    progress.Attempt,
+			attempts:   progress.Attempts,
+			retrying:   progress.Retrying,
 		}
 	}
 }
 
+// streamedRestoreDone records the restore of a streamed download that finished with err
+// and reports it as the restore step, the download having been the restore
+func streamedRestoreDone(cfg *config.Config, name, source string, ds *downloadState, err error) tea.Msg {
+	size := max(ds.fileSize, 0)
+	warnings := orchestrator.WriteRestoreRecord(context.Background(), cfg, orchestrator.NewRestoreRecord(name, source, ds.fileName, size, err))
+	if err != nil {
+		return restoreStepDoneMsg{step: restoreStepRestoring, err: err, warnings: warnings}
+	}
+	return restoreStepDoneMsg{
+		step:     restoreStepRestoring,
+		message:  fmt.Sprintf("Streamed %s and restored to %s", ds.fileName, ds.target.Database),
+		warnings: warnings,
+		done:     !backup.HasPostRestore(*ds.target),
+	}
+}
+
 // startUploadWithProgress initializes upload state and starts the upload goroutine
-func (m model) startUploadWithProgress(dbName, backupPath, dest string) (tea.Model, tea.Cmd) {
+func (m model) startUploadWithProgress(dbName, backupPath, dest string, atomic bool) (tea.Model, tea.Cmd) {
 	// Get file size for progress tracking
 	fileInfo, err := os.Stat(backupPath)
 	if err != nil {
@@ -4187,27 +7101,123 @@ func (m model) startUploadWithProgress(dbName, backupPath, dest string) (tea.Mod
 		m.uploadStates = make(map[string]*uploadState)
 	}
 
-	progressCh := make(chan storage.TransferProgress, 10)
+	progressCh := make(chan storage.TransferProgress, 10)
+
+	// Start upload in a goroutine, stopped if the database is cancelled
+	ctx := context.Background()
+	if state := m.backupStates[dbName]; state != nil {
+		ctx = state.context()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+
+	// Store state in heap-allocated struct
+	m.uploadStates[dbName] = &uploadState{
+		transferWatch: transferWatch{lastProgress: time.Now()},
+		progressCh:    progressCh,
+		cancel:        cancel,
+		dbName:        dbName,
+		backupPath:    backupPath,
+		dest:          dest,
+		atomicUpload:  atomic,
+		fileSize:      fileSize,
+	}
+
+	// Initialize progress in backup state
+	if state := m.backupStates[dbName]; state != nil {
+		state.uploadBytesTotal = fileSize
+		state.uploadBytesDone = 0
+		state.uploadSpeed = 0
+	}
+
+	go storage.UploadWithProgress(ctx, backupPath, dest, fileSize, atomic, progressCh)
+
+	// Return command to wait for first progress update
+	m, watch := m.watchStalls()
+	return m, tea.Batch(m.waitForUploadProgress(dbName), watch)
+}
+
+// retryUpload abandons the running upload of a database, stalled with no bytes moving,
+// and starts it again from the beginning
+func (m model) retryUpload(dbName string) (tea.Model, tea.Cmd) {
+	us := m.uploadStates[dbName]
+	us.abandoned.Store(true)
+	us.cancel()
+	return m.startUploadWithProgress(dbName, us.backupPath, us.dest, us.atomicUpload)
+}
+
+// stallCheckInterval is how often running transfers are checked for stalls
+const stallCheckInterval = 5 * time.Second
 
-	// Store state in heap-allocated struct
-	m.uploadStates[dbName] = &uploadState{
-		progressCh: progressCh,
-		dbName:     dbName,
-		fileSize:   fileSize,
+// watchStalls starts checking the running transfers for stalls, unless it already is
+func (m model) watchStalls() (model, tea.Cmd) {
+	if m.stallWatching {
+		return m, nil
 	}
+	m.stallWatching = true
+	return m, tea.Tick(stallCheckInterval, func(time.Time) tea.Msg { return stallCheckMsg{} })
+}
 
-	// Initialize progress in backup state
-	if state := m.backupStates[dbName]; state != nil {
-		state.uploadBytesTotal = fileSize
-		state.uploadBytesDone = 0
-		state.uploadSpeed = 0
+// checkStalls marks the running uploads and downloads that moved no bytes for the
+// stall timeout, and keeps checking while any runs
+func (m model) checkStalls() (tea.Model, tea.Cmd) {
+	m.stallWatching = false
+	now, timeout := time.Now(), m.cfg.StallTimeoutDuration()
+	running := false
+	if ds := m.downloadState; ds != nil {
+		ds.check(now, timeout)
+		running = true
+	}
+	for _, us := range m.uploadStates {
+		us.check(now, timeout)
+		running = true
+	}
+	if !running {
+		return m, nil
 	}
+	return m.watchStalls()
+}
 
-	// Start upload in a goroutine
-	go storage.UploadWithProgress(context.Background(), backupPath, dest, fileSize, progressCh)
+// uploadChecksum writes the checksum sidecar for an uploaded backup. The sidecar only
+// speeds up verification, so a failure is returned as a warning rather than an error.
+func uploadChecksum(dest string, result backup.Result) []string {
+	if result.SHA256 == "" {
+		return nil
+	}
+	if err := storage.UploadChecksum(context.Background(), dest, result.Filename, result.SHA256); err != nil {
+		return []string{fmt.Sprintf("checksum not saved: %v", err)}
+	}
+	return nil
+}
 
-	// Return command to wait for first progress update
-	return m, m.waitForUploadProgress(dbName)
+// uploadDone finishes an upload: it writes the checksum sidecar, then confirms the backup
+// is on the destination, which must succeed before the local dump is removed
+func uploadDone(dbName string, db config.Database, result backup.Result, settings backup.Settings) tea.Msg {
+	uploadDB := db
+	uploadDB.Dest = orchestrator.UploadDest(db, result.Filename)
+	warnings := uploadChecksum(uploadDB.Dest, result)
+	if err := orchestrator.ConfirmUpload(context.Background(), uploadDB, &result, settings); err != nil {
+		return backupStepDoneMsg{dbName: dbName, step: stepUploading, err: err, warnings: warnings}
+	}
+	warning, err := orchestrator.VerifyUploaded(context.Background(), uploadDB, &result, settings)
+	if err != nil {
+		return backupStepDoneMsg{dbName: dbName, step: stepUploading, err: err, warnings: warnings}
+	}
+	if warning != "" {
+		warnings = append(warnings, warning)
+	}
+	if warning := orchestrator.SizeAnomaly(context.Background(), db, dbName, result.Filename); warning != "" {
+		warnings = append(warnings, warning)
+	}
+	written := storage.IndexChange{Written: []string{orchestrator.DestName(db, result.Filename)}}
+	if warning := orchestrator.UpdateIndex(context.Background(), db, written); warning != "" {
+		warnings = append(warnings, warning)
+	}
+	return backupStepDoneMsg{
+		dbName:   dbName,
+		step:     stepUploading,
+		message:  fmt.Sprintf("Saved to %s", formatDestForDisplay(uploadDB.Dest, 50)),
+		warnings: warnings,
+	}
 }
 
 // waitForUploadProgress waits for the next progress update from the channel
@@ -4217,19 +7227,26 @@ func (m model) waitForUploadProgress(dbName string) tea.Cmd {
 		return nil
 	}
 
-	// Capture dest for the completion message
-	db := m.cfg.Databases[dbName]
-	dest := db.Dest
+	// Capture the database and dump result to confirm the upload
+	db := m.backupDB(dbName)
+	settings := backup.SettingsFrom(m.cfg)
+
+	var result backup.Result
+	if state := m.backupStates[dbName]; state != nil && state.result != nil {
+		result = *state.result
+	}
 
 	return func() tea.Msg {
 		progress, ok := <-us.progressCh
+		if us.abandoned.Load() {
+			// Superseded by a retry: let the upload wind down
+			for range us.progressCh {
+			}
+			return nil
+		}
 		if !ok {
 			// Channel closed, upload complete
-			return backupStepDoneMsg{
-				dbName:  dbName,
-				step:    stepUploading,
-				message: fmt.Sprintf("Saved to %s", formatDestForDisplay(dest, 50)),
-			}
+			return uploadDone(dbName, db, result, settings)
 		}
 
 		if progress.Done {
@@ -4240,11 +7257,7 @@ func (m model) waitForUploadProgress(dbName string) tea.Cmd {
 					done:   true,
 				}
 			}
-			return backupStepDoneMsg{
-				dbName:  dbName,
-				step:    stepUploading,
-				message: fmt.Sprintf("Saved to %s", formatDestForDisplay(dest, 50)),
-			}
+			return uploadDone(dbName, db, result, settings)
 		}
 
 		return uploadProgressMsg{
@@ -4291,7 +7304,7 @@ func (m model) renderRcloneList() string {
 		s.WriteString("\n\n")
 	} else {
 		// Show remotes with scrolling
-		maxVisible := 10
+		maxVisible := listMaxVisible
 		start, end := calcScrollWindow(m.cursor, len(m.rcloneRemoteFilteredList), maxVisible)
 
 		// Scroll indicator if there are items above
@@ -4309,6 +7322,9 @@ func (m model) renderRcloneList() string {
 				cursor = cursorStyle.Render("▸ ")
 				line = selectedStyle.Render(name) + " " + dimStyle.Render(fmt.Sprintf("(%s)", remoteType))
 			}
+			if m.cfg.IsFavoriteRemote(name) {
+				line += " " + selectedStyle.Render("★")
+			}
 			s.WriteString(fmt.Sprintf("%s%s\n", cursor, line))
 		}
 
@@ -4348,7 +7364,7 @@ func (m model) renderRcloneActions() string {
 	remoteType := getRcloneRemoteType(m.selectedRemote)
 	s.WriteString(fmt.Sprintf("%s %s\n\n", selectedStyle.Render(m.selectedRemote), dimStyle.Render(fmt.Sprintf("(%s)", remoteType))))
 
-	items := []string{"Edit", "Test connection", "Delete", "Back"}
+	items := []string{"Edit", "Quick edit", "Test connection", "Space usage", "Delete", "Back"}
 	for i, item := range items {
 		cursor := "  "
 		if m.cursor == i {
@@ -4361,6 +7377,54 @@ func (m model) renderRcloneActions() string {
 	return s.String()
 }
 
+func (m model) renderRcloneQuickEdit() string {
+	var s strings.Builder
+
+	s.WriteString(fmt.Sprintf("Quick edit %s\n\n", selectedStyle.Render(m.selectedRemote)))
+	if m.quickEditStatus != "" {
+		s.WriteString(m.quickEditStatus + "\n\n")
+	}
+	if len(m.quickEditOptions) == 0 {
+		s.WriteString(dimStyle.Render("No options to edit."))
+		s.WriteString("\n")
+		return s.String()
+	}
+
+	start, end := calcScrollWindow(m.cursor, len(m.quickEditOptions), listMaxVisible)
+	if start > 0 {
+		s.WriteString(dimStyle.Render(fmt.Sprintf("  ↑ %d more above", start)))
+		s.WriteString("\n")
+	}
+	for i := start; i < end; i++ {
+		opt := m.quickEditOptions[i]
+		value, _ := rcloneconfig.FileGetValue(m.selectedRemote, opt.Name)
+		cursor := "  "
+		name := opt.Name
+		if m.cursor == i {
+			cursor = cursorStyle.Render("▸ ")
+			name = selectedStyle.Render(name)
+		}
+		s.WriteString(fmt.Sprintf("%s%s %s\n", cursor, name, dimStyle.Render(quickEditDisplayValue(opt, value))))
+	}
+	if end < len(m.quickEditOptions) {
+		s.WriteString(dimStyle.Render(fmt.Sprintf("  ↓ %d more below", len(m.quickEditOptions)-end)))
+		s.WriteString("\n")
+	}
+
+	return s.String()
+}
+
+func (m model) renderRcloneQuickEditField() string {
+	var s strings.Builder
+
+	s.WriteString(fmt.Sprintf("Quick edit %s\n\n", selectedStyle.Render(m.selectedRemote)))
+	if m.quickEditForm != nil {
+		s.WriteString(m.quickEditForm.View())
+	}
+
+	return s.String()
+}
+
 func (m model) renderRcloneAddType() string {
 	var s strings.Builder
 
@@ -4375,7 +7439,7 @@ func (m model) renderRcloneAddType() string {
 	}
 
 	// Show backends
-	maxVisible := 10
+	maxVisible := listMaxVisible
 	if len(m.rcloneFilteredList) == 0 {
 		s.WriteString(dimStyle.Render("No matching backends found."))
 		s.WriteString("\n")
@@ -4403,9 +7467,12 @@ func (m model) renderRcloneAddType() string {
 
 		// Show count
 		s.WriteString("\n")
-		if m.rcloneFilter != "" {
+		switch {
+		case m.rcloneFilter != "":
 			s.WriteString(dimStyle.Render(fmt.Sprintf("Showing %d of %d backends", len(m.rcloneFilteredList), len(m.rcloneBackends))))
-		} else {
+		case len(m.rcloneFilteredList) < len(m.rcloneBackends):
+			s.WriteString(dimStyle.Render(fmt.Sprintf("%d preferred backends • ctrl+a: show all %d", len(m.rcloneFilteredList), len(m.rcloneBackends))))
+		default:
 			s.WriteString(dimStyle.Render(fmt.Sprintf("%d backends available", len(m.rcloneBackends))))
 		}
 		s.WriteString("\n")
@@ -4478,6 +7545,17 @@ func (m model) renderRcloneTestBucket() string {
 	return s.String()
 }
 
+func (m model) renderDBDestTestBucket() string {
+	var s strings.Builder
+
+	s.WriteString(fmt.Sprintf("Test destination %s\n\n", selectedStyle.Render(m.destTestRemote+":")))
+	if m.rcloneTestForm != nil {
+		s.WriteString(m.rcloneTestForm.View())
+	}
+
+	return s.String()
+}
+
 func (m model) renderRcloneTest() string {
 	var s strings.Builder
 
@@ -4499,6 +7577,80 @@ func (m model) renderRcloneTest() string {
 	return s.String()
 }
 
+func (m model) renderStale() string {
+	var s strings.Builder
+	s.WriteString("Databases without a recent backup:\n\n")
+
+	for _, db := range m.staleDBs {
+		var detail string
+		switch {
+		case db.Err != nil:
+			detail = errorStyle.Render("destination not readable: " + db.Err.Error())
+		case db.LastBackup.IsZero():
+			detail = errorStyle.Render("never backed up")
+		default:
+			detail = fmt.Sprintf("last backup %s (%s)", humanize.Time(db.LastBackup), db.LastBackup.Format("2006-01-02 15:04"))
+		}
+		s.WriteString(fmt.Sprintf("  %s  %s %s\n", selectedStyle.Render(db.Name), detail, dimStyle.Render("• expected within "+formatStaleThreshold(db.Threshold))))
+	}
+
+	s.WriteString("\n")
+	s.WriteString(dimStyle.Render("Press any key to continue"))
+	return s.String()
+}
+
+// formatStaleThreshold shows a stale threshold in days when it is a whole number of them
+// (keep_days, the default), and as a Go duration otherwise
+func formatStaleThreshold(d time.Duration) string {
+	const day = 24 * time.Hour
+	if d%day == 0 {
+		if d == day {
+			return "1 day"
+		}
+		return fmt.Sprintf("%d days", d/day)
+	}
+	return d.String()
+}
+
+func (m model) renderRcloneUsage() string {
+	var s strings.Builder
+
+	s.WriteString(fmt.Sprintf("Space usage of %s\n\n", selectedStyle.Render(m.selectedRemote)))
+
+	if m.rcloneUsage != "" {
+		s.WriteString(m.rcloneUsage)
+		s.WriteString("\n\n")
+		s.WriteString(dimStyle.Render("Press any key to continue"))
+	} else {
+		s.WriteString(m.spinner.View())
+		s.WriteString(" Listing backups...\n")
+	}
+
+	return s.String()
+}
+
+// formatRemoteUsage describes the space blobber's backups take on a remote and, when
+// its backend reports them, the remote's free and total space
+func formatRemoteUsage(dests int, usage orchestrator.BackupUsage, space storage.Space, spaceErr error) string {
+	var line string
+	if dests == 0 {
+		line = "No configured database backs up to this remote"
+	} else {
+		line = fmt.Sprintf("blobber using %s (%d backup(s) in %d destination(s))", humanize.IBytes(uint64(usage.Bytes)), usage.Backups, dests)
+	}
+
+	switch {
+	case spaceErr != nil:
+		return line + "\n" + dimStyle.Render("Free space unknown: "+spaceErr.Error())
+	case space.Free >= 0 && space.Total >= 0:
+		return fmt.Sprintf("%s; %s free of %s", line, humanize.IBytes(uint64(space.Free)), humanize.IBytes(uint64(space.Total)))
+	case space.Free >= 0:
+		return fmt.Sprintf("%s; %s free", line, humanize.IBytes(uint64(space.Free)))
+	default:
+		return line
+	}
+}
+
 func (m model) renderRcloneOAuth() string {
 	var s strings.Builder
 
@@ -4798,7 +7950,7 @@ func (m model) saveRcloneRemote() (tea.Model, tea.Cmd) {
 	// Check if this backend needs OAuth (has Config function)
 	if m.selectedBackend.Config != nil {
 		// Save config so PostConfig can read it
-		rcloneconfig.SaveConfig()
+		saveRcloneConfig()
 
 		// Start OAuth flow
 		m.selectedRemote = name // Store name for OAuth view
@@ -4810,7 +7962,7 @@ func (m model) saveRcloneRemote() (tea.Model, tea.Cmd) {
 	}
 
 	// No OAuth needed, just save
-	rcloneconfig.SaveConfig()
+	saveRcloneConfig()
 
 	// Refresh remotes and return to appropriate view
 	m.refreshRcloneRemotes()
@@ -4829,6 +7981,39 @@ func (m model) saveRcloneRemote() (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// checkStale looks for the configured databases without a recent backup. It works on
+// a copy of the database list, since the config can be edited while it runs.
+func (m model) checkStale() tea.Cmd {
+	cfg := *m.cfg
+	cfg.Databases = maps.Clone(m.cfg.Databases)
+
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		return staleCheckMsg{stale: orchestrator.StaleDatabases(ctx, &cfg, time.Now())}
+	}
+}
+
+// fetchRcloneUsage sums the backups in the configured destinations on the selected
+// remote and asks its backend for the remote's total and free space
+func (m model) fetchRcloneUsage() tea.Cmd {
+	remote := m.selectedRemote
+	dests := orchestrator.DestsOnRemote(m.cfg, remote)
+
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+
+		msg := rcloneUsageMsg{remote: remote, dests: len(dests)}
+		msg.usage, msg.err = orchestrator.Usage(ctx, dests)
+		if msg.err != nil {
+			return msg
+		}
+		msg.space, msg.spaceErr = storage.Usage(ctx, remote+":")
+		return msg
+	}
+}
+
 // runRcloneTestCmd runs a connection test for the selected rclone remote
 func (m *model) runRcloneTestCmd() tea.Cmd {
 	remoteName := m.selectedRemote
@@ -4901,7 +8086,7 @@ func (m *model) runRcloneFormTestCmd(bucket string) tea.Cmd {
 		}
 
 		// Save config
-		rcloneconfig.SaveConfig()
+		saveRcloneConfig()
 
 		// Build test path - include bucket if provided
 		testPath := remoteName + ":"
@@ -4914,7 +8099,7 @@ func (m *model) runRcloneFormTestCmd(bucket string) tea.Cmd {
 		// Clean up temp remote if we created one
 		if remoteName == "__test_temp_remote__" || remoteName == "__test_edit_remote__" {
 			rcloneconfig.DeleteRemote(remoteName)
-			rcloneconfig.SaveConfig()
+			saveRcloneConfig()
 		}
 
 		if err != nil {
@@ -4965,6 +8150,105 @@ func (m *model) refreshRcloneRemotes() {
 	m.rcloneRemoteFilteredList = m.rcloneRemotes
 }
 
+// quickEditOptions returns the options quick edit lists for a remote: the backend's
+// standard options plus any advanced option that is set
+func quickEditOptions(backend *fs.RegInfo, values map[string]string) []fs.Option {
+	var opts []fs.Option
+	for _, opt := range backend.Options {
+		if opt.Hide != 0 {
+			continue
+		}
+		if _, set := values[opt.Name]; opt.Advanced && !set {
+			continue
+		}
+		opts = append(opts, opt)
+	}
+	return opts
+}
+
+// quickEditSecret reports whether an option holds a secret: a password, stored
+// obscured, or a sensitive value like an access key, stored as is. Quick edit never
+// shows either.
+func quickEditSecret(opt fs.Option) bool {
+	return opt.IsPassword || opt.Sensitive
+}
+
+// quickEditDisplayValue formats an option's current value for the quick edit list,
+// masking secrets
+func quickEditDisplayValue(opt fs.Option, value string) string {
+	switch {
+	case value == "":
+		return "(not set)"
+	case quickEditSecret(opt):
+		return "••••••••"
+	default:
+		return truncateString(value, 50)
+	}
+}
+
+// buildQuickEditForm builds the input for changing one option of the selected remote
+func (m *model) buildQuickEditForm(opt fs.Option) *huh.Form {
+	// Allocate on heap so pointer survives bubbletea model copies
+	value := ""
+	m.quickEditValue = &value
+
+	description := "Leave empty to unset"
+	input := huh.NewInput().
+		Key(opt.Name).
+		Title(opt.Name)
+	if quickEditSecret(opt) {
+		// Secrets aren't shown for editing (and passwords are stored obscured)
+		description = "Enter the new value, or leave empty to keep the current one"
+		input = input.EchoMode(huh.EchoModePassword)
+	} else {
+		value, _ = rcloneconfig.FileGetValue(m.selectedRemote, opt.Name)
+		*m.quickEditValue = value
+	}
+	if help := strings.SplitN(opt.Help, "\n", 2)[0]; help != "" {
+		description = help + "\n" + description
+	}
+	input = input.Description(description).Value(m.quickEditValue)
+
+	return huh.NewForm(huh.NewGroup(input)).
+		WithShowHelp(true).
+		WithShowErrors(true).
+		WithTheme(themeAmber()).
+		WithWidth(m.formWidth())
+}
+
+// saveQuickEdit writes the quick edit input to the rclone config and returns to the list
+func (m model) saveQuickEdit() model {
+	opt := m.quickEditOptions[m.cursor]
+	value := strings.TrimSpace(*m.quickEditValue)
+
+	switch {
+	case value == "" && quickEditSecret(opt):
+		m.quickEditStatus = dimStyle.Render(fmt.Sprintf("○ %s unchanged", opt.Name))
+	case value == "":
+		rcloneconfig.LoadedData().DeleteKey(m.selectedRemote, opt.Name)
+		saveRcloneConfig()
+		m.quickEditStatus = successStyle.Render(fmt.Sprintf("✓ %s unset", opt.Name))
+	default:
+		if opt.IsPassword {
+			// rclone expects passwords obscured in its config
+			obscured, err := obscure.Obscure(value)
+			if err != nil {
+				m.quickEditStatus = errorStyle.Render(fmt.Sprintf("✗ %v", err))
+				break
+			}
+			value = obscured
+		}
+		rcloneconfig.FileSetValue(m.selectedRemote, opt.Name, value)
+		saveRcloneConfig()
+		m.quickEditStatus = successStyle.Render(fmt.Sprintf("✓ %s saved", opt.Name))
+	}
+
+	m.view = viewRcloneQuickEdit
+	m.quickEditForm = nil
+	m.quickEditValue = nil
+	return m
+}
+
 // loadRcloneRemoteValues loads all values for an existing remote
 func (m *model) loadRcloneRemoteValues(remoteName string) map[string]string {
 	values := make(map[string]string)
@@ -4987,29 +8271,47 @@ func (m *model) loadRcloneRemoteValues(remoteName string) map[string]string {
 	return values
 }
 
-// loadRcloneBackends loads the list of available rclone backends (filtered, non-hidden)
+// loadRcloneBackends loads the list of available rclone backends (filtered, non-hidden):
+// those in preferred_backends first, in their order, then the others by name
 func (m *model) loadRcloneBackends() {
-	m.rcloneBackends = nil
+	var preferred, others []*fs.RegInfo
 	for _, ri := range fs.Registry {
 		if ri.Hide {
 			continue
 		}
-		m.rcloneBackends = append(m.rcloneBackends, ri)
+		if slices.Contains(m.cfg.PreferredBackends, ri.Name) {
+			preferred = append(preferred, ri)
+		} else {
+			others = append(others, ri)
+		}
 	}
-	// Sort alphabetically by name
-	sort.Slice(m.rcloneBackends, func(i, j int) bool {
-		return m.rcloneBackends[i].Name < m.rcloneBackends[j].Name
+	sort.Slice(preferred, func(i, j int) bool {
+		return slices.Index(m.cfg.PreferredBackends, preferred[i].Name) < slices.Index(m.cfg.PreferredBackends, preferred[j].Name)
+	})
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].Name < others[j].Name
 	})
-	// Initialize filtered list to all backends
-	m.rcloneFilteredList = m.rcloneBackends
-	m.rcloneFilter = ""
+	m.rcloneBackends = append(preferred, others...)
+	m.rclonePreferredCount = len(preferred)
+	m.rcloneShowAllBackends = false
+	m.filterRcloneBackends("")
 }
 
-// filterRcloneBackends filters the backend list by search term
+// shownRcloneBackends returns the backends listed without a filter: only the preferred
+// ones when preferred_backends names any, until every backend is shown with ctrl+a
+func (m model) shownRcloneBackends() []*fs.RegInfo {
+	if m.rclonePreferredCount == 0 || m.rcloneShowAllBackends {
+		return m.rcloneBackends
+	}
+	return m.rcloneBackends[:m.rclonePreferredCount]
+}
+
+// filterRcloneBackends filters the backend list by search term. A filter searches every
+// backend, preferred or not.
 func (m *model) filterRcloneBackends(filter string) {
 	m.rcloneFilter = filter
 	if filter == "" {
-		m.rcloneFilteredList = m.rcloneBackends
+		m.rcloneFilteredList = m.shownRcloneBackends()
 		return
 	}
 
@@ -5061,6 +8363,100 @@ func (m *model) filterRcloneRemotes(filter string) {
 	}
 }
 
+// toggleAllBackupDatabases deselects every database in the filtered backup list if they
+// are all selected, and selects them all otherwise. Combined with the filter, this
+// deselects everything matching a search.
+func (m *model) toggleAllBackupDatabases() {
+	allSelected := true
+	for _, name := range m.backupFilteredList {
+		if !m.selected[name] {
+			allSelected = false
+			break
+		}
+	}
+	for _, name := range m.backupFilteredList {
+		m.selected[name] = !allSelected
+	}
+}
+
+// applyPreset selects the databases and toggles of a preset on the backup select screen,
+// with the cursor on Run Backup so enter starts it
+func (m model) applyPreset(name string) model {
+	preset := m.cfg.Presets[name]
+	m.selected = make(map[string]bool, len(preset.Databases))
+	for _, db := range preset.Databases {
+		m.selected[db] = true
+	}
+	m.skipRetention = preset.SkipRetention
+	m.retentionDryRun = preset.RetentionDryRun
+	m.dryRun = preset.DryRun
+	m.view = viewBackupSelect
+	m.cursor = m.maxCursor()
+	return m
+}
+
+// buildPresetForm builds the input naming the preset the backup selection is saved as
+func (m *model) buildPresetForm() *huh.Form {
+	// Allocate on heap so pointer survives bubbletea model copies
+	name := ""
+	m.presetName = &name
+
+	input := huh.NewInput().
+		Key("preset").
+		Title("Preset name").
+		Description("Saves the selected databases and options; a preset with the same name is replaced").
+		Placeholder("nightly").
+		Value(m.presetName).
+		Validate(func(s string) error {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				return fmt.Errorf("name is required")
+			}
+			if !config.ValidName(s) {
+				return fmt.Errorf("name must contain only letters, digits, dashes, and underscores")
+			}
+			return nil
+		})
+
+	return huh.NewForm(huh.NewGroup(input)).
+		WithShowHelp(true).
+		WithShowErrors(true).
+		WithTheme(themeAmber()).
+		WithWidth(m.formWidth())
+}
+
+// savePreset saves the selected databases and toggles as the preset named in the input
+// and returns to the backup select screen
+func (m model) savePreset() model {
+	name := strings.TrimSpace(*m.presetName)
+	preset := config.Preset{
+		SkipRetention: m.skipRetention,
+		DryRun:        m.dryRun,
+		// A dry run or skipped retention deletes nothing anyway
+		RetentionDryRun: m.retentionDryRun && !m.skipRetention && !m.dryRun,
+	}
+	for _, db := range m.dbNames {
+		if m.selected[db] {
+			preset.Databases = append(preset.Databases, db)
+		}
+	}
+
+	if m.cfg.Presets == nil {
+		m.cfg.Presets = make(map[string]config.Preset)
+	}
+	m.cfg.Presets[name] = preset
+	if err := m.cfg.Save(); err != nil {
+		m.presetStatus = errorStyle.Render(fmt.Sprintf("✗ Saving config: %v", err))
+	} else {
+		m.presetStatus = successStyle.Render(fmt.Sprintf("✓ Saved preset %s (blobber backup --preset %s)", name, name))
+	}
+
+	m.view = viewBackupSelect
+	m.presetForm = nil
+	m.presetName = nil
+	return m
+}
+
 // filterBackupDatabases filters the backup database list by search term (viewBackupSelect)
 func (m *model) filterBackupDatabases(filter string) {
 	m.backupFilter = filter
@@ -5099,21 +8495,88 @@ func (m *model) filterRestoreDatabases(filter string) {
 	}
 }
 
-// filterRestoreFiles filters the backup files list by search term (viewRestoreFileSelect)
+// filterRestoreFiles filters the backup files list by search term (viewRestoreFileSelect).
+// A filter searches every backup listed. Without one, only the newest backupFilesLimit
+// are shown, most restores being recent, and "Load more" shows the older ones.
 func (m *model) filterRestoreFiles(filter string) {
 	m.restoreFileFilter = filter
-	if filter == "" {
-		m.restoreFileFilteredList = m.backupFiles
-		return
-	}
-
-	filter = strings.ToLower(filter)
+	now := time.Now()
 	m.restoreFileFilteredList = nil
+	m.backupFilesMore = false
 	for _, f := range m.backupFiles {
-		if strings.Contains(strings.ToLower(f.Name), filter) {
-			m.restoreFileFilteredList = append(m.restoreFileFilteredList, f)
+		if !isSelectableBackup(f.Name) || (filter != "" && !restoreFileMatches(f, filter, now)) {
+			continue
+		}
+		if filter == "" && m.backupFilesLimit > 0 && len(m.restoreFileFilteredList) == m.backupFilesLimit {
+			m.backupFilesMore = true
+			break
+		}
+		m.restoreFileFilteredList = append(m.restoreFileFilteredList, f)
+	}
+}
+
+// isSelectableBackup reports whether a file listed at a destination is a backup that can
+// be restored: named {name}_{YYYYMMDD_HHMMSS}.{ext}, outside the reports folder and not
+// the backup index or a sidecar. The restore picker only offers these, so features
+// writing files next to backups need no special case there.
+func isSelectableBackup(name string) bool {
+	if name == storage.IndexFile || strings.HasPrefix(name, storage.ReportsDir+"/") {
+		return false
+	}
+	base := path.Base(name)
+	if config.IsSidecar(base) {
+		return false
+	}
+	_, ok := retention.BackupName(base)
+	return ok
+}
+
+// restoreFileMatches reports whether a backup file matches every space-separated term
+// of the restore filter. Terms are name substrings or age predicates: ">7d" (older than
+// 7 days), "<12h" (newer than 12 hours), ">2024-01-01" (on or after a date) and
+// "<2024-01-01" (before a date). Units are h, d and w. A predicate still being typed
+// (">" or ">7") matches everything so the list doesn't flicker empty.
+func restoreFileMatches(f storage.RemoteFile, filter string, now time.Time) bool {
+	name := strings.ToLower(f.Name)
+	for _, term := range strings.Fields(strings.ToLower(filter)) {
+		if term[0] == '>' || term[0] == '<' {
+			cutoff, before, ok := parseAgeFilter(term, now)
+			if !ok {
+				continue
+			}
+			if taken := retention.BackupTime(f); taken.Before(cutoff) != before {
+				return false
+			}
+			continue
+		}
+		if !strings.Contains(name, term) {
+			return false
 		}
 	}
+	return true
+}
+
+// ageFilterPattern matches relative ages such as "7d", "12h" or "2w"
+var ageFilterPattern = regexp.MustCompile(`^(\d+)([hdw])$`)
+
+// parseAgeFilter parses an age predicate into a cutoff time. before is true when
+// matching backups must have been taken before the cutoff.
+func parseAgeFilter(term string, now time.Time) (cutoff time.Time, before bool, ok bool) {
+	op, value := term[0], term[1:]
+
+	if match := ageFilterPattern.FindStringSubmatch(value); match != nil {
+		n, _ := strconv.Atoi(match[1])
+		unit := map[string]time.Duration{"h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour}[match[2]]
+		// ">7d" is older than 7 days, i.e. taken before now-7d
+		return now.Add(-time.Duration(n) * unit), op == '>', true
+	}
+
+	if date, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		// "<2024-01-01" is before the date, ">2024-01-01" on or after it
+		return date, op == '<', true
+	}
+
+	return time.Time{}, false, false
 }
 
 // isFilterableView returns true if the view supports filter input
@@ -5231,6 +8694,52 @@ func (m model) handleFilterInput(input string) model {
 	return m
 }
 
+// filterableListLabels returns the labels of the entries shown in the current filterable list view
+func (m model) filterableListLabels() []string {
+	switch m.view {
+	case viewRcloneAddType:
+		labels := make([]string, len(m.rcloneFilteredList))
+		for i, b := range m.rcloneFilteredList {
+			labels[i] = b.Name
+		}
+		return labels
+	case viewRcloneList:
+		return m.rcloneRemoteFilteredList
+	case viewDBList:
+		return m.dbFilteredList
+	case viewBackupSelect:
+		return m.backupFilteredList
+	case viewRestoreDBSelect:
+		return m.restoreDBFilteredList
+	case viewRestoreFileSelect:
+		labels := make([]string, len(m.restoreFileFilteredList))
+		for i, f := range m.restoreFileFilteredList {
+			labels[i] = f.Name
+		}
+		return labels
+	}
+	return nil
+}
+
+// jumpToLetter moves the cursor to the next entry starting with letter, wrapping around
+// to the top of the list. The cursor is left unchanged if no entry matches.
+func (m model) jumpToLetter(letter string) model {
+	labels := m.filterableListLabels()
+	letter = strings.ToLower(letter)
+	for offset := 1; offset <= len(labels); offset++ {
+		// Start after the current entry (or at the top when on a trailing button)
+		i := (m.cursor + offset) % len(labels)
+		if m.cursor >= len(labels) {
+			i = offset - 1
+		}
+		if strings.HasPrefix(strings.ToLower(labels[i]), letter) {
+			m.cursor = i
+			break
+		}
+	}
+	return m
+}
+
 // getRcloneRemoteType returns the type of a configured remote
 func getRcloneRemoteType(name string) string {
 	t, _ := rcloneconfig.FileGetValue(name, "type")
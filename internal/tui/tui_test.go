@@ -1,11 +1,27 @@
 package tui
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"sort"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/Yoone/blobber/internal/backup"
+	"github.com/Yoone/blobber/internal/config"
+	"github.com/Yoone/blobber/internal/orchestrator"
+	"github.com/Yoone/blobber/internal/runstate"
+	"github.com/Yoone/blobber/internal/storage"
+	"github.com/charmbracelet/bubbles/progress"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/rclone/rclone/fs"
+	rcloneconfig "github.com/rclone/rclone/fs/config"
 )
 
 func TestCollapsePath(t *testing.T) {
@@ -568,6 +584,58 @@ func TestGetPathSuggestionsOrdering(t *testing.T) {
 	}
 }
 
+func TestGetDestSuggestions(t *testing.T) {
+	data := rcloneconfig.LoadedData()
+	for _, name := range []string{"sugbeta", "sugalpha", "sugzeta"} {
+		data.SetValue(name, "type", "local")
+		t.Cleanup(func() { data.DeleteSection(name) })
+	}
+	cfgPath := filepath.Join(t.TempDir(), "blobber.yaml")
+	cfg, err := config.LoadOrEmpty(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.SetFavoriteRemote("sugzeta", true)
+	m := model{cfg: cfg}
+
+	if got, want := m.getDestSuggestions("sug"), []string{"sugzeta:", "sugalpha:", "sugbeta:"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getDestSuggestions(\"sug\") = %v, want favorites first, then the others by name: %v", got, want)
+	}
+	if got, want := m.getDestSuggestions("SUGB"), []string{"sugbeta:"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getDestSuggestions(\"SUGB\") = %v, want %v", got, want)
+	}
+	if got := m.getDestSuggestions("sugalpha:bucket"); got != nil {
+		t.Errorf("getDestSuggestions() after a remote = %v, want none", got)
+	}
+	if got := m.getDestSuggestions("./sug"); slices.ContainsFunc(got, func(s string) bool { return strings.HasSuffix(s, ":") }) {
+		t.Errorf("getDestSuggestions() of a local path = %v, want no remotes", got)
+	}
+
+	t.Run("ctrl+f toggles a favorite", func(t *testing.T) {
+		m := model{cfg: cfg, view: viewRcloneList, rcloneRemoteFilteredList: []string{"sugalpha", "sugbeta"}, cursor: 1}
+		result, _ := m.Update(tea.KeyMsg{Type: tea.KeyCtrlF})
+		m = result.(model)
+		if !cfg.IsFavoriteRemote("sugbeta") || m.err != nil {
+			t.Fatalf("sugbeta favorite = %v, err = %v; want it marked", cfg.IsFavoriteRemote("sugbeta"), m.err)
+		}
+		saved, err := config.LoadOrEmpty(cfgPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !saved.IsFavoriteRemote("sugbeta") {
+			t.Error("favorite was not saved to the config")
+		}
+		if got := m.getDestSuggestions("sug"); !reflect.DeepEqual(got, []string{"sugbeta:", "sugzeta:", "sugalpha:"}) {
+			t.Errorf("getDestSuggestions(\"sug\") = %v, want both favorites first", got)
+		}
+
+		m.Update(tea.KeyMsg{Type: tea.KeyCtrlF})
+		if cfg.IsFavoriteRemote("sugbeta") {
+			t.Error("second ctrl+f did not unmark sugbeta")
+		}
+	})
+}
+
 func TestTruncateString(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -650,6 +718,241 @@ func TestBackupStepString(t *testing.T) {
 	}
 }
 
+func TestJumpToLetter(t *testing.T) {
+	list := []string{"alpha", "beta", "bravo", "charlie", "Bob"}
+
+	tests := []struct {
+		name     string
+		cursor   int
+		letter   string
+		expected int
+	}{
+		{"first match from top", 0, "b", 1},
+		{"next match after cursor", 1, "b", 2},
+		{"case insensitive", 2, "B", 4},
+		{"wraps around", 4, "b", 1},
+		{"no match keeps cursor", 3, "z", 3},
+		{"from add button starts at top", 5, "c", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := model{view: viewDBList, dbFilteredList: list, cursor: tt.cursor}
+			result := m.jumpToLetter(tt.letter).cursor
+			if result != tt.expected {
+				t.Errorf("jumpToLetter(%q) from %d = %d, want %d", tt.letter, tt.cursor, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCloneCandidatesFor(t *testing.T) {
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"prod":    {Type: "postgres"},
+		"staging": {Type: "postgres"},
+		"cache":   {Type: "mysql"},
+		"local":   {Type: "file"},
+	}}
+	m := model{cfg: cfg, dbNames: []string{"cache", "local", "prod", "staging"}}
+
+	tests := []struct {
+		target   string
+		expected []string
+	}{
+		{"staging", []string{"prod"}},
+		{"prod", []string{"staging"}},
+		{"cache", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			result := m.cloneCandidatesFor(tt.target)
+			if !slices.Equal(result, tt.expected) {
+				t.Errorf("cloneCandidatesFor(%q) = %v, want %v", tt.target, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSaveDatabaseNameConflict(t *testing.T) {
+	existing := config.Database{Type: "file", Path: "/data/prod.db", Dest: "/backups", Compression: "gz"}
+
+	tests := []struct {
+		name      string
+		view      view
+		editingDB string
+		formName  string
+	}{
+		{"add with existing name", viewAddDBForm, "", "prod"},
+		{"rename onto existing name", viewEditDBForm, "staging", "prod"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Databases: map[string]config.Database{
+				"prod":    existing,
+				"staging": {Type: "file", Path: "/data/staging.db", Dest: "/backups"},
+			}}
+			m := model{
+				cfg:       cfg,
+				view:      tt.view,
+				editingDB: tt.editingDB,
+				addDBType: "file",
+				formData:  &formFields{name: tt.formName, path: "/data/other.db", dest: "/other"},
+			}
+
+			var result tea.Model
+			if tt.view == viewAddDBForm {
+				result, _ = m.saveNewDatabase()
+			} else {
+				result, _ = m.saveEditedDatabase()
+			}
+
+			if !reflect.DeepEqual(cfg.Databases["prod"], existing) {
+				t.Errorf("existing database was overwritten: %+v", cfg.Databases["prod"])
+			}
+			if len(cfg.Databases) != 2 {
+				t.Errorf("expected 2 databases, got %d", len(cfg.Databases))
+			}
+			got := result.(model)
+			if got.view != tt.view {
+				t.Errorf("view = %v, want to stay on form %v", got.view, tt.view)
+			}
+			if !strings.Contains(got.formError, "already exists") {
+				t.Errorf("formError = %q, want an 'already exists' error", got.formError)
+			}
+		})
+	}
+
+	t.Run("editing keeps its own name", func(t *testing.T) {
+		m := model{
+			cfg:       &config.Config{Databases: map[string]config.Database{"prod": existing}},
+			view:      viewEditDBForm,
+			editingDB: "prod",
+		}
+		if m.nameConflict("prod") {
+			t.Error("nameConflict() = true for the database being edited")
+		}
+	})
+}
+
+func TestDatabaseChanges(t *testing.T) {
+	old := config.Database{
+		Type: "mysql", Host: "db1", Port: 3306, User: "app", Password: "secret", Database: "shop",
+		Dest: "s3:bucket/shop", Compression: "zstd", ZstdDictionary: 40000,
+		Retention: config.Retention{KeepLast: 7, KeepDays: 30},
+	}
+
+	tests := []struct {
+		name    string
+		newName string
+		edit    func(db *config.Database)
+		want    []fieldChange
+	}{
+		{"no changes", "shop", func(db *config.Database) {}, nil},
+		{"rename", "shop2", func(db *config.Database) {}, []fieldChange{{"Name", "shop", "shop2"}}},
+		{"retention cleared", "shop", func(db *config.Database) { db.Retention = config.Retention{} }, []fieldChange{
+			{"Keep last N backups", "7", "(none)"},
+			{"Keep backups for N days", "30", "(none)"},
+		}},
+		{"dest and port", "shop", func(db *config.Database) { db.Dest = "s3:other/shop"; db.Port = 3307 }, []fieldChange{
+			{"Port", "3306", "3307"},
+			{"Backup destination", "s3:bucket/shop", "s3:other/shop"},
+		}},
+		{"password is not shown", "shop", func(db *config.Database) { db.Password = "hunter2" }, []fieldChange{
+			{"Password", "(set)", "(changed)"},
+		}},
+		{"compression drops the dictionary", "shop", func(db *config.Database) { db.Compression = "gz"; db.ZstdDictionary = 0 }, []fieldChange{
+			{"Compression", "zstd", "gz"},
+			{"zstd dictionary", "40000", "(none)"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edited := old
+			tt.edit(&edited)
+			if got := databaseChanges("shop", old, tt.newName, edited); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("databaseChanges() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveEditedDatabaseConfirm(t *testing.T) {
+	prod := config.Database{Type: "file", Path: "/data/prod.db", Dest: "/backups", Compression: "gz", Retention: config.Retention{KeepLast: 7}}
+
+	newModel := func(t *testing.T) model {
+		cfg, err := config.LoadOrEmpty(filepath.Join(t.TempDir(), "config.yaml"))
+		if err != nil {
+			t.Fatalf("LoadOrEmpty() error = %v", err)
+		}
+		cfg.Databases = map[string]config.Database{"prod": prod}
+		return model{
+			cfg:       cfg,
+			view:      viewEditDBForm,
+			editingDB: "prod",
+			addDBType: "file",
+			formData:  &formFields{name: "prod", path: "/data/prod.db", dest: "/backups", compression: "gz"},
+		}
+	}
+
+	t.Run("changes wait for confirmation", func(t *testing.T) {
+		m := newModel(t)
+		result, _ := m.saveEditedDatabase()
+		m = result.(model)
+		if m.view != viewEditDBSaveConfirm {
+			t.Fatalf("view = %v, want the save confirmation", m.view)
+		}
+		if !reflect.DeepEqual(m.cfg.Databases["prod"], prod) {
+			t.Errorf("config changed before confirming: %+v", m.cfg.Databases["prod"])
+		}
+		if view := m.renderEditSaveConfirm(); !strings.Contains(view, "Keep last N backups") || !strings.Contains(view, "Retention is removed") {
+			t.Errorf("confirmation = %q, want the cleared retention shown", view)
+		}
+
+		m.cursor = confirmNo
+		result, _ = m.handleEnter()
+		if got := result.(model); got.view != viewEditDBForm || got.addDBForm == nil {
+			t.Errorf("view = %v, want back on the edit form", got.view)
+		}
+
+		m.cursor = confirmYes
+		result, _ = m.handleEnter()
+		if got := result.(model); got.view != viewDone || got.cfg.Databases["prod"].HasRetention() {
+			t.Errorf("view = %v, retention = %+v, want the edit saved", got.view, got.cfg.Databases["prod"].Retention)
+		}
+	})
+
+	t.Run("no changes save directly", func(t *testing.T) {
+		m := newModel(t)
+		m.formData.keepLast = "7"
+		result, _ := m.saveEditedDatabase()
+		if got := result.(model); got.view != viewDone {
+			t.Errorf("view = %v, want saved without confirmation", got.view)
+		}
+	})
+}
+
+func TestS3RootRemoteNonRoot(t *testing.T) {
+	// Only the root of a configured S3-like remote needs a bucket prompt
+	tests := []string{
+		"/backups/mydb",
+		"./backups",
+		"s3:bucket/path",
+		"s3:bucket",
+		"unknown-remote:",
+	}
+
+	for _, dest := range tests {
+		t.Run(dest, func(t *testing.T) {
+			if remote, ok := s3RootRemote(dest); ok {
+				t.Errorf("s3RootRemote(%q) = %q, true; want false", dest, remote)
+			}
+		})
+	}
+}
+
 func TestTestDestinationAccess(t *testing.T) {
 	// Create temp directory for testing local destination access
 	tmpDir, err := os.MkdirTemp("", "destaccesstest")
@@ -696,3 +999,1809 @@ func TestTestDestinationAccess(t *testing.T) {
 		}
 	})
 }
+
+func TestRestorePickerLimit(t *testing.T) {
+	dest := t.TempDir()
+	for day := 1; day <= 5; day++ {
+		name := fmt.Sprintf("app_2024010%d_000000.db", day)
+		if err := os.WriteFile(filepath.Join(dest, name), []byte(strings.Repeat("x", day)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC)
+		if err := os.Chtimes(filepath.Join(dest, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.Config{RestoreListLimit: 2, Databases: map[string]config.Database{
+		"app": {Type: "file", Dest: dest},
+	}}
+
+	m := model{cfg: cfg, view: viewRestoreSourceSelect, selectedDB: "app", cursor: restoreSourceRemote}
+	load := func(cmd tea.Cmd) {
+		t.Helper()
+		for _, msg := range cmd().(tea.BatchMsg) {
+			if msg == nil {
+				continue
+			}
+			if list, ok := msg().(fileListMsg); ok {
+				result, _ := m.Update(list)
+				m = result.(model)
+				return
+			}
+		}
+		t.Fatal("no file list fetched")
+	}
+	result, cmd := m.handleEnter()
+	m = result.(model)
+	load(cmd)
+
+	shown := m.restoreFileFilteredList
+	if len(shown) != 2 || shown[0].Name != "app_20240105_000000.db" || !m.backupFilesMore {
+		t.Fatalf("shown = %v, more = %v; want the newest 2 and more to load", shown, m.backupFilesMore)
+	}
+	if _, ok := m.backupFileDeltas["app_20240104_000000.db"]; !ok {
+		t.Error("no size delta for the oldest listed backup")
+	}
+	if m.maxCursor() != 2 || !strings.Contains(m.renderRestoreFileSelect(), "Load 2 more") {
+		t.Errorf("maxCursor() = %d, want the load more entry after the files", m.maxCursor())
+	}
+
+	// A filter searches the backups not shown yet
+	m = m.handleFilterInput("20240101")
+	if len(m.restoreFileFilteredList) != 1 || m.restoreFileFilteredList[0].Name != "app_20240101_000000.db" {
+		t.Errorf("filtered = %v, want the oldest backup", m.restoreFileFilteredList)
+	}
+	m.filterRestoreFiles("")
+
+	for _, want := range []int{4, 5} {
+		loaded := len(m.restoreFileFilteredList)
+		m.cursor = loaded
+		result, cmd = m.handleEnter()
+		m = result.(model)
+		if cmd != nil {
+			t.Error("load more listed the destination again")
+		}
+		if len(m.restoreFileFilteredList) != want || m.cursor != loaded {
+			t.Errorf("after load more: %d files, cursor %d; want %d files, cursor on the first one loaded", len(m.restoreFileFilteredList), m.cursor, want)
+		}
+	}
+	if m.backupFilesMore || m.maxCursor() != 4 {
+		t.Errorf("more = %v, maxCursor() = %d; want every backup listed", m.backupFilesMore, m.maxCursor())
+	}
+}
+
+func TestRestorePickerDuplicateTimestamps(t *testing.T) {
+	dest := t.TempDir()
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, size := range map[string]int{"app_20240101_000000.db": 3, "app_20240101_000000.db.gz": 1} {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte(strings.Repeat("x", size)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(dest, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app": {Type: "file", Dest: dest},
+	}}
+
+	// Both backups are listed, always in the same order, and told apart by name and size
+	for range 3 {
+		m := model{cfg: cfg, view: viewRestoreSourceSelect, selectedDB: "app", cursor: restoreSourceRemote}
+		result, cmd := m.handleEnter()
+		m = result.(model)
+		for _, msg := range cmd().(tea.BatchMsg) {
+			if msg == nil {
+				continue
+			}
+			if list, ok := msg().(fileListMsg); ok {
+				result, _ = m.Update(list)
+				m = result.(model)
+			}
+		}
+
+		var names []string
+		for _, f := range m.backupFiles {
+			names = append(names, f.Name)
+		}
+		if want := []string{"app_20240101_000000.db.gz", "app_20240101_000000.db"}; !slices.Equal(names, want) {
+			t.Fatalf("backups listed as %v, want %v", names, want)
+		}
+		view := m.renderRestoreFileSelect()
+		for _, want := range []string{"1 B  app_20240101_000000.db.gz", "3 B  app_20240101_000000.db\n"} {
+			if !strings.Contains(view, want) {
+				t.Errorf("picker missing %q:\n%s", want, view)
+			}
+		}
+	}
+}
+
+func TestDestinationCache(t *testing.T) {
+	t.Run("listings and access", func(t *testing.T) {
+		c := &destCache{}
+		c.setTTL(time.Minute)
+		var lists int
+		list := func() ([]storage.RemoteFile, error) {
+			lists++
+			return []storage.RemoteFile{{Name: "app_20240115_143022.db"}}, nil
+		}
+		c.listing("/backups", "app", list)
+		c.listing("/backups", "app", list)
+		if lists != 1 {
+			t.Errorf("listed %d times within the TTL, want 1", lists)
+		}
+		c.invalidate("/backups")
+		c.listing("/backups", "app", list)
+		if lists != 2 {
+			t.Errorf("listed %d times after invalidate, want 2", lists)
+		}
+
+		// A failed test is tried again, a successful one is reused until cleared
+		var tests int
+		fail := func() error { tests++; return errors.New("unreachable") }
+		succeed := func() error { tests++; return nil }
+		c.testAccess("/backups", fail)
+		c.testAccess("/backups", succeed)
+		c.testAccess("/backups", succeed)
+		if tests != 2 {
+			t.Errorf("tested %d times, want the failure retried and the success reused", tests)
+		}
+		c.clear()
+		c.testAccess("/backups", succeed)
+		if tests != 3 {
+			t.Errorf("tested %d times after clear, want 3", tests)
+		}
+
+		c.setTTL(0)
+		c.listing("/backups", "app", list)
+		c.listing("/backups", "app", list)
+		if lists != 4 {
+			t.Errorf("listed %d times with the cache disabled, want 4", lists)
+		}
+	})
+
+	t.Run("copies and key forms", func(t *testing.T) {
+		t.Setenv("HOME", t.TempDir())
+		c := &destCache{}
+		c.setTTL(time.Minute)
+		var lists int
+		list := func() ([]storage.RemoteFile, error) {
+			lists++
+			return []storage.RemoteFile{{Name: "a.db"}, {Name: "b.db"}}, nil
+		}
+
+		// A caller trimming its listing leaves the cached one whole
+		files, _ := c.listing("~/backups", "app", list)
+		files[0].Name = "changed"
+		files, _ = c.listing(expandDest("~/backups"), "app", list)
+		if lists != 1 || len(files) != 2 || files[0].Name != "a.db" {
+			t.Errorf("cached listing %v after %d lists, want [a.db b.db] listed once", files, lists)
+		}
+
+		// Either form of the destination drops what the other cached
+		c.testAccess(expandDest("~/backups"), func() error { return nil })
+		c.invalidate("~/backups")
+		c.listing(expandDest("~/backups"), "app", list)
+		if lists != 2 {
+			t.Errorf("listed %d times after invalidating the unexpanded dest, want 2", lists)
+		}
+		var tests int
+		c.testAccess("~/backups", func() error { tests++; return nil })
+		if tests != 1 {
+			t.Errorf("access test reused after invalidate")
+		}
+	})
+
+	t.Run("restore picker refresh", func(t *testing.T) {
+		destinations.setTTL(time.Minute)
+		defer destinations.setTTL(0)
+
+		dest := t.TempDir()
+		write := func(name string) {
+			if err := os.WriteFile(filepath.Join(dest, name), []byte("backup"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		write("app_20240101_000000.db")
+		cfg := &config.Config{Databases: map[string]config.Database{
+			"app": {Type: "file", Dest: dest},
+		}}
+		load := func(m model, key tea.Msg) model {
+			t.Helper()
+			var cmd tea.Cmd
+			var result tea.Model
+			if key == nil {
+				result, cmd = m.handleEnter()
+			} else {
+				result, cmd = m.Update(key)
+			}
+			m = result.(model)
+			for _, msg := range cmd().(tea.BatchMsg) {
+				if msg == nil {
+					continue
+				}
+				if list, ok := msg().(fileListMsg); ok {
+					result, _ = m.Update(list)
+					return result.(model)
+				}
+			}
+			t.Fatal("no file list fetched")
+			return m
+		}
+
+		m := load(model{cfg: cfg, view: viewRestoreSourceSelect, selectedDB: "app", cursor: restoreSourceRemote}, nil)
+		write("app_20240102_000000.db")
+
+		// Coming back to the picker reuses the listing; ctrl+r lists the destination again
+		again := load(model{cfg: cfg, view: viewRestoreSourceSelect, selectedDB: "app", cursor: restoreSourceRemote}, nil)
+		if len(m.backupFiles) != 1 || len(again.backupFiles) != 1 {
+			t.Fatalf("listed %d then %d backups, want the cached listing reused", len(m.backupFiles), len(again.backupFiles))
+		}
+		refreshed := load(again, tea.KeyMsg{Type: tea.KeyCtrlR})
+		if len(refreshed.backupFiles) != 2 {
+			t.Errorf("listed %d backups after ctrl+r, want the new one too", len(refreshed.backupFiles))
+		}
+	})
+}
+
+func TestRestoreFileMatches(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.Local)
+	recent := storage.RemoteFile{Name: "mydb_20240314_120000.sql.gz"}
+	old := storage.RemoteFile{Name: "mydb_20231220_120000.sql.gz"}
+	unparsed := storage.RemoteFile{Name: "manual.sql", ModTime: now.Add(-48 * time.Hour)}
+
+	tests := []struct {
+		filter   string
+		file     storage.RemoteFile
+		expected bool
+	}{
+		{"mydb", recent, true},
+		{"other", recent, false},
+		{">7d", recent, false},
+		{">7d", old, true},
+		{"<7d", recent, true},
+		{"<12h", recent, false},
+		{">1w", old, true},
+		{"<2024-01-01", old, true},
+		{"<2024-01-01", recent, false},
+		{">2024-01-01", recent, true},
+		{"mydb >7d", old, true},
+		{"other >7d", old, false},
+		{">1d", unparsed, true},
+		{">3d", unparsed, false},
+		{">", old, true},     // predicate still being typed
+		{">7", recent, true}, // predicate still being typed
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter+" "+tt.file.Name, func(t *testing.T) {
+			if got := restoreFileMatches(tt.file, tt.filter, now); got != tt.expected {
+				t.Errorf("restoreFileMatches(%q, %q) = %v, want %v", tt.file.Name, tt.filter, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsSelectableBackup(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"mydb_20240115_143022.sql.gz", true},
+		{"mydb_20240115_143022.sql.zst.gpg", true},
+		{"2024-01-15/mydb_20240115_143022.sql.gz", true},
+		{"settings_20240115_143022.json", true},                  // file database backing up a JSON file
+		{"mydb_20240115_143022.sql.gz" + storage.PartExt, false}, // config.SidecarExts must list what storage writes
+		{"mydb_20240115_143022.sql.gz" + storage.ChecksumExt, false},
+		{"blobber-index.json", false},
+		{"reports/run_20240115_143022.json", false},
+		{"reports/restore_20240115_143022_mydb.json", false},
+		{"mydb_latest.sql.gz", false},
+		{"manual.sql", false},
+		{"notes.txt", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSelectableBackup(tt.name); got != tt.expected {
+				t.Errorf("isSelectableBackup(%q) = %v, want %v", tt.name, got, tt.expected)
+			}
+		})
+	}
+
+	m := model{backupFiles: []storage.RemoteFile{
+		{Name: "mydb_20240115_143022.sql.gz"},
+		{Name: "mydb_20240115_143022.sql.gz.part"},
+		{Name: "mydb_20240114_143022.sql.gz"},
+	}}
+	for _, filter := range []string{"", "mydb"} {
+		m.filterRestoreFiles(filter)
+		if len(m.restoreFileFilteredList) != 2 {
+			t.Errorf("filterRestoreFiles(%q) = %v, want the 2 backups", filter, m.restoreFileFilteredList)
+		}
+	}
+}
+
+func TestStartPrune(t *testing.T) {
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"nopolicy": {Type: "file"},
+		"locked":   {Type: "file", Immutable: true, Retention: config.Retention{KeepLast: 3}},
+		"kept":     {Type: "file", Retention: config.Retention{KeepLast: 3}},
+	}}
+
+	tests := []struct {
+		db       string
+		view     view
+		warnings int
+	}{
+		{"nopolicy", viewPrune, 0},
+		{"locked", viewPrune, 1},
+		{"kept", viewRetentionPreCheck, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.db, func(t *testing.T) {
+			m := model{cfg: cfg, view: viewDBActions, editingDB: tt.db, cursor: dbActionPrune}
+			result, _ := m.startPrune()
+			pm := result.(model)
+			if pm.view != tt.view {
+				t.Errorf("view = %v, want %v", pm.view, tt.view)
+			}
+			if len(pm.pruneWarnings) != tt.warnings {
+				t.Errorf("pruneWarnings = %q, want %d", pm.pruneWarnings, tt.warnings)
+			}
+			if pm.retentionQueue()[0] != tt.db {
+				t.Errorf("retentionQueue() = %v, want [%s]", pm.retentionQueue(), tt.db)
+			}
+		})
+	}
+
+	t.Run("nothing to delete", func(t *testing.T) {
+		m := model{cfg: cfg, view: viewRetentionPreCheck, pruneDB: "kept"}
+		result, _ := m.Update(retentionPreCheckMsg{plan: map[string][]storage.RemoteFile{}})
+		if pm := result.(model); pm.view != viewPrune || pm.pruneResult == "" {
+			t.Errorf("view = %v, result = %q, want prune result shown", pm.view, pm.pruneResult)
+		}
+	})
+
+	t.Run("listing failed", func(t *testing.T) {
+		m := model{cfg: cfg, view: viewRetentionPreCheck, pruneDB: "kept"}
+		failures := orchestrator.RetentionFailures{"kept": errors.New("listing files: timeout")}
+		result, _ := m.Update(retentionPreCheckMsg{plan: map[string][]storage.RemoteFile{}, failures: failures})
+		if pm := result.(model); pm.view != viewPrune || !strings.Contains(pm.pruneResult, "timeout") {
+			t.Errorf("view = %v, result = %q, want the listing error shown", pm.view, pm.pruneResult)
+		}
+	})
+}
+
+func TestRetentionStepListingFailed(t *testing.T) {
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app": {Type: "file", Dest: "/backups", Retention: config.Retention{KeepLast: 3}},
+	}}
+	m := model{
+		cfg:               cfg,
+		backupCfg:         cfg,
+		backupStates:      map[string]*dbBackupState{"app": {currentStep: stepRetention}},
+		retentionFailures: orchestrator.RetentionFailures{"app": errors.New("listing files: timeout")},
+	}
+	msg, ok := m.runBackupStepFor("app")().(backupStepDoneMsg)
+	if !ok || msg.step != stepRetention || msg.err == nil {
+		t.Fatalf("retention step = %+v, want it to fail with the listing error", msg)
+	}
+}
+
+func TestRetentionStepDryRun(t *testing.T) {
+	dest := t.TempDir()
+	oldPath := filepath.Join(dest, "app_20240101_000000.db")
+	if err := os.WriteFile(oldPath, []byte("old backup"), 0644); err != nil {
+		t.Fatalf("writing old backup: %v", err)
+	}
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app": {Type: "file", Dest: dest, Retention: config.Retention{KeepLast: 1}},
+	}}
+	m := model{
+		cfg:             cfg,
+		backupCfg:       cfg,
+		backupQueue:     []string{"app"},
+		backupStates:    map[string]*dbBackupState{"app": {currentStep: stepRetention}},
+		retentionDryRun: true,
+	}
+
+	updated, _ := m.Update(retentionPreCheckMsg{plan: orchestrator.RetentionPlan{"app": {{Name: "app_20240101_000000.db", Size: 10}}}})
+	m = updated.(model)
+	if m.view == viewRetentionPreConfirm {
+		t.Fatal("retention dry run asked to confirm deletions")
+	}
+
+	m.backupStates = map[string]*dbBackupState{"app": {currentStep: stepRetention}}
+	msg, ok := m.runBackupStepFor("app")().(backupStepDoneMsg)
+	if !ok || msg.err != nil || msg.message != "Would delete 1 old backup(s) (dry-run)" || msg.deleted != 0 {
+		t.Fatalf("retention step = %+v, want the plan reported", msg)
+	}
+	if _, err := os.Stat(oldPath); err != nil {
+		t.Errorf("old backup deleted by a retention dry run: %v", err)
+	}
+}
+
+func TestRetentionDryRunToggleExclusive(t *testing.T) {
+	m := model{
+		view:               viewBackupSelect,
+		backupFilteredList: []string{"app"},
+		selected:           map[string]bool{},
+		dryRun:             true,
+		skipRetention:      true,
+	}
+	toggle := func(offset int) {
+		t.Helper()
+		m.cursor = len(m.backupFilteredList) + offset
+		result, _ := m.Update(tea.KeyMsg{Type: tea.KeySpace})
+		m = result.(model)
+	}
+
+	toggle(1)
+	if !m.retentionDryRun || m.dryRun || m.skipRetention {
+		t.Fatalf("retention dry run on: retentionDryRun=%v dryRun=%v skipRetention=%v, want only the retention dry run", m.retentionDryRun, m.dryRun, m.skipRetention)
+	}
+	toggle(2)
+	if !m.dryRun || m.retentionDryRun {
+		t.Fatalf("dry run on: dryRun=%v retentionDryRun=%v, want the retention dry run cleared", m.dryRun, m.retentionDryRun)
+	}
+	toggle(1)
+	toggle(0)
+	if !m.skipRetention || m.retentionDryRun {
+		t.Fatalf("skip retention on: skipRetention=%v retentionDryRun=%v, want the retention dry run cleared", m.skipRetention, m.retentionDryRun)
+	}
+}
+
+func TestSkipPreflightToggle(t *testing.T) {
+	m := model{
+		view:               viewBackupSelect,
+		backupFilteredList: []string{"app"},
+		backupQueue:        []string{"app"},
+		selected:           map[string]bool{},
+		cfg:                &config.Config{Databases: map[string]config.Database{"app": {Type: "file", Path: "/data/app.db", Dest: "/nonexistent/backups"}}},
+	}
+	if !strings.Contains(m.renderBackupSelect(), "[✓] Test destinations first") {
+		t.Error("destination test not checked by default")
+	}
+	m.cursor = len(m.backupFilteredList) + 3
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeySpace})
+	m = result.(model)
+	if !m.skipPreflight {
+		t.Fatal("space on the destination test toggle didn't turn it off")
+	}
+
+	// The run goes straight to the retention check
+	result, _ = m.checkDestinations()
+	if view := result.(model).view; view == viewDestinationCheck {
+		t.Errorf("view = %v, want the destination test skipped", view)
+	}
+}
+
+func TestSkipRetentionPrecheck(t *testing.T) {
+	dest := t.TempDir()
+	for _, name := range []string{"app_20240101_000000.db", "app_20240102_000000.db"} {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte("old backup"), 0644); err != nil {
+			t.Fatalf("writing old backup: %v", err)
+		}
+	}
+	cfgPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := "skip_retention_precheck: true\ndatabases:\n  app:\n    type: file\n    path: /data/app.db\n    dest: " + dest + "\n    retention:\n      keep_last: 2\n"
+	if err := os.WriteFile(cfgPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := model{cfg: cfg, backupCfg: cfg, backupQueue: []string{"app"}}
+
+	updated, _ := m.preCheckRetention()
+	m = updated.(model)
+	defer m.runLock.Release()
+	if m.view != viewBackupRunning || !m.retentionAfterUpload {
+		t.Fatalf("view = %v, retentionAfterUpload = %v, want backups started without a pre-check", m.view, m.retentionAfterUpload)
+	}
+
+	// The new backup is uploaded: retention keeps it and the newest old one
+	if err := os.WriteFile(filepath.Join(dest, "app_20240103_000000.db"), []byte("new backup"), 0644); err != nil {
+		t.Fatalf("writing new backup: %v", err)
+	}
+	m.backupStates["app"] = &dbBackupState{currentStep: stepRetention, result: &backup.Result{Filename: "app_20240103_000000.db", Size: 10}}
+	msg, ok := m.runBackupStepFor("app")().(backupStepDoneMsg)
+	if !ok || msg.err != nil || msg.message != "Deleted 1 old backup(s)" {
+		t.Fatalf("retention step = %+v, want 1 backup deleted", msg)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "app_20240101_000000.db")); !os.IsNotExist(err) {
+		t.Errorf("oldest backup should have been deleted, stat err = %v", err)
+	}
+}
+
+func TestRetentionPlanEdit(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "backups")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"app_20240101_000000.db", "app_20240102_000000.db", "logs_20240101_000000.db", "logs_20240102_000000.db"} {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte("old backup"), 0644); err != nil {
+			t.Fatalf("writing old backup: %v", err)
+		}
+	}
+	cfgPath := filepath.Join(dir, "config.yaml")
+	content := "databases:\n  app:\n    type: file\n    path: /data/app.db\n    dest: " + dest + "\n    retention:\n      keep_last: 1\n" +
+		"  logs:\n    type: file\n    path: /data/logs.db\n    dest: " + dest + "\n    retention:\n      keep_last: 1\n      max_count: 20\n"
+	if err := os.WriteFile(cfgPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := model{cfg: cfg, backupCfg: cfg, backupQueue: []string{"app", "logs"}}
+
+	updated, _ := m.Update(m.runRetentionPreCheck(m.backupQueue, 1)())
+	m = updated.(model)
+	if m.view != viewRetentionPreConfirm || m.retentionEditDB != "app" {
+		t.Fatalf("view = %v, retentionEditDB = %q, want the pre-confirm with app picked", m.view, m.retentionEditDB)
+	}
+
+	// tab picks the next database of the plan, e opens its retention fields
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyTab})
+	m = updated.(model)
+	if m.retentionEditDB != "logs" {
+		t.Fatalf("retentionEditDB after tab = %q, want logs", m.retentionEditDB)
+	}
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("e")})
+	m = updated.(model)
+	if m.view != viewRetentionEdit || m.formData.keepLast != "1" {
+		t.Fatalf("view = %v, keepLast = %q, want the retention of logs in the form", m.view, m.formData.keepLast)
+	}
+
+	// esc goes back to the plan unchanged
+	back := m.goBack()
+	if back.view != viewRetentionPreConfirm || back.retentionEditForm != nil {
+		t.Errorf("view after esc = %v, want the pre-confirm", back.view)
+	}
+
+	// Saving a policy keeping both old backups checks the plan again without them
+	m.formData.keepLast = "5"
+	updated, _ = m.savePlanRetention()
+	m = updated.(model)
+	if m.view != viewRetentionPreCheck {
+		t.Fatalf("view after save = %v, want the plan checked again", m.view)
+	}
+	saved, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (config.Retention{KeepLast: 5, MaxCount: 20}); saved.Databases["logs"].Retention != want {
+		t.Errorf("saved retention = %+v, want %+v", saved.Databases["logs"].Retention, want)
+	}
+
+	updated, _ = m.Update(m.runRetentionPreCheck(m.backupQueue, 1)())
+	m = updated.(model)
+	if m.view != viewRetentionPreConfirm || len(m.retentionPlan["logs"]) != 0 || len(m.retentionPlan["app"]) != 2 {
+		t.Fatalf("view = %v, plan = %v, want only app's backups planned", m.view, m.retentionPlan)
+	}
+	if m.retentionEditDB != "app" {
+		t.Errorf("retentionEditDB = %q, want app, the only database left in the plan", m.retentionEditDB)
+	}
+}
+
+func TestRetentionPlanEditRemoteTier(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yaml")
+	content := "databases:\n  shop:\n    type: file\n    path: /data/shop.db\n    dest:\n      local: " + filepath.Join(dir, "local") +
+		"\n      remote: " + filepath.Join(dir, "remote") + "\n      remote_retention:\n        keep_days: 90\n        max_count: 50\n    retention:\n      keep_days: 30\n"
+	if err := os.WriteFile(cfgPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := model{cfg: cfg, backupCfg: cfg, backupQueue: []string{"shop"}, retentionEditDB: "shop"}
+
+	// The plan is the remote tier's, so its own policy is the one edited
+	updated, _ := m.editPlanRetention()
+	m = updated.(model)
+	if m.formData.keepDays != "90" {
+		t.Fatalf("keepDays = %q, want remote_retention's 90", m.formData.keepDays)
+	}
+	if out := m.renderRetentionEdit(); !strings.Contains(out, "remote_retention") {
+		t.Errorf("retention edit screen doesn't say it edits the remote tier's policy:\n%s", out)
+	}
+
+	m.formData.keepDays = "14"
+	updated, _ = m.savePlanRetention()
+	m = updated.(model)
+	saved, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := saved.Databases["shop"]
+	if want := (config.Retention{KeepDays: 14, MaxCount: 50}); db.DestRetention() != want {
+		t.Errorf("saved remote_retention = %+v, want %+v", db.DestRetention(), want)
+	}
+	if want := (config.Retention{KeepDays: 30}); db.Retention != want {
+		t.Errorf("saved retention = %+v, want %+v untouched", db.Retention, want)
+	}
+}
+
+func TestInterruptedRun(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yaml")
+	content := "databases:\n  app:\n    type: file\n    path: /data/app.db\n    dest: " + dir + "\n  logs:\n    type: file\n    path: /data/logs.db\n    dest: " + dir + "\n"
+	if err := os.WriteFile(cfgPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statePath := runstate.PathFor(cfgPath)
+
+	if interruptedRun(cfg) != nil {
+		t.Fatal("interruptedRun() without a state file should be nil")
+	}
+	killed := &runstate.State{
+		Started:       time.Now(),
+		Selected:      []string{"app", "logs"},
+		SkipRetention: true,
+		Databases:     []runstate.Database{{Name: "app", Status: runstate.Done}, {Name: "logs", Status: runstate.Running}},
+	}
+	if err := runstate.Save(statePath, killed); err != nil {
+		t.Fatal(err)
+	}
+	run := interruptedRun(cfg)
+	if run == nil {
+		t.Fatal("interruptedRun() should return the killed run")
+	}
+
+	m := model{cfg: cfg, dbNames: []string{"app", "logs"}, view: viewMainMenu, interruptedRun: run}
+	if out := m.renderMainMenu(); !strings.Contains(out, "1 completed, 1 pending") {
+		t.Errorf("main menu should report the interrupted run:\n%s", out)
+	}
+
+	t.Run("resume", func(t *testing.T) {
+		result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+		resumed := result.(model)
+		if !slices.Equal(resumed.backupQueue, []string{"logs"}) || !resumed.skipRetention || resumed.interruptedRun != nil {
+			t.Fatalf("backupQueue = %v, skipRetention = %v, want only logs with retention skipped", resumed.backupQueue, resumed.skipRetention)
+		}
+
+		result, _ = resumed.startBackups()
+		resumed = result.(model)
+		saved, err := runstate.Load(statePath)
+		if err != nil || saved == nil || !slices.Equal(saved.Unfinished(), []string{"logs"}) || !slices.Equal(saved.Selected, []string{"app", "logs"}) {
+			t.Fatalf("state during the resumed run = %+v (%v), want logs unfinished", saved, err)
+		}
+
+		for _, state := range resumed.backupStates {
+			state.done = true
+		}
+		resumed.Update(allBackupsDoneMsg{})
+		if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+			t.Errorf("state file should be removed once the run ends, stat err = %v", err)
+		}
+	})
+
+	t.Run("dismiss", func(t *testing.T) {
+		if err := runstate.Save(statePath, killed); err != nil {
+			t.Fatal(err)
+		}
+		result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("d")})
+		if result.(model).interruptedRun != nil {
+			t.Error("d should dismiss the interrupted run")
+		}
+		if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+			t.Errorf("dismissing should remove the state file, stat err = %v", err)
+		}
+	})
+}
+
+func TestCredentialCheck(t *testing.T) {
+	cfg := &config.Config{FailFastOnAuth: true, Databases: map[string]config.Database{
+		"app":  {Type: "mysql", Dest: "/backups", Retention: config.Retention{KeepLast: 3}},
+		"logs": {Type: "postgres", Dest: "/backups", Retention: config.Retention{KeepLast: 3}},
+	}}
+	base := model{cfg: cfg, backupCfg: cfg, backupQueue: []string{"app", "logs"}}
+
+	t.Run("tested before retention", func(t *testing.T) {
+		updated, cmd := base.beginBackups()
+		if got := updated.(model).view; got != viewCredentialCheck || cmd == nil {
+			t.Errorf("view = %v, want viewCredentialCheck with a command", got)
+		}
+	})
+
+	t.Run("rejected credentials abort", func(t *testing.T) {
+		msg := credentialCheckMsg{failures: orchestrator.CredentialFailures{"logs": errors.New(`password authentication failed for user "app"`)}}
+		updated, _ := base.Update(msg)
+		m := updated.(model)
+		if m.view != viewDone || m.err == nil {
+			t.Fatalf("view = %v, err = %v; want viewDone with an error", m.view, m.err)
+		}
+		if len(m.logs) != 1 || !strings.Contains(m.logs[0], "logs") {
+			t.Errorf("logs = %q, want the rejected database", m.logs)
+		}
+	})
+
+	t.Run("accepted credentials continue", func(t *testing.T) {
+		updated, _ := base.Update(credentialCheckMsg{failures: orchestrator.CredentialFailures{}})
+		if got := updated.(model).view; got != viewDestinationCheck {
+			t.Errorf("view = %v, want viewDestinationCheck", got)
+		}
+	})
+}
+
+func TestDestinationCheck(t *testing.T) {
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app":  {Type: "mysql", Dest: "/backups", Retention: config.Retention{KeepLast: 3}},
+		"logs": {Type: "postgres", Dest: "s3:logs", Retention: config.Retention{KeepLast: 3}},
+	}}
+	base := model{cfg: cfg, backupCfg: cfg, backupQueue: []string{"app", "logs"}}
+
+	t.Run("tested before retention", func(t *testing.T) {
+		updated, cmd := base.beginBackups()
+		if got := updated.(model).view; got != viewDestinationCheck || cmd == nil {
+			t.Errorf("view = %v, want viewDestinationCheck with a command", got)
+		}
+	})
+
+	t.Run("dry run skips the test", func(t *testing.T) {
+		dry := base
+		dry.dryRun = true
+		updated, _ := dry.beginBackups()
+		if got := updated.(model).view; got == viewDestinationCheck {
+			t.Error("dry run tested the destinations")
+		}
+	})
+
+	t.Run("inaccessible destination aborts", func(t *testing.T) {
+		msg := destinationCheckMsg{failures: orchestrator.DestinationFailures{"logs": errors.New("s3:logs: access denied")}}
+		updated, _ := base.Update(msg)
+		m := updated.(model)
+		if m.view != viewDone || m.err == nil {
+			t.Fatalf("view = %v, err = %v; want viewDone with an error", m.view, m.err)
+		}
+		if len(m.logs) != 1 || !strings.Contains(m.logs[0], "logs") {
+			t.Errorf("logs = %q, want the database with the inaccessible destination", m.logs)
+		}
+	})
+
+	t.Run("accessible destinations continue", func(t *testing.T) {
+		updated, _ := base.Update(destinationCheckMsg{failures: orchestrator.DestinationFailures{}})
+		if got := updated.(model).view; got != viewRetentionPreCheck {
+			t.Errorf("view = %v, want viewRetentionPreCheck", got)
+		}
+	})
+}
+
+func TestHandleBackupStepDoneLocalCopy(t *testing.T) {
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app": {Type: "file", Dest: "/backups"},
+	}}
+
+	tests := []struct {
+		name     string
+		msg      backupStepDoneMsg
+		wantKept bool
+	}{
+		{"upload confirmed", backupStepDoneMsg{dbName: "app", step: stepUploading, message: "Saved"}, false},
+		{"upload not confirmed", backupStepDoneMsg{dbName: "app", step: stepUploading, err: os.ErrNotExist}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dumpDir := filepath.Join(t.TempDir(), "blobber-dump")
+			if err := os.MkdirAll(dumpDir, 0755); err != nil {
+				t.Fatalf("creating dump dir: %v", err)
+			}
+			dumpPath := filepath.Join(dumpDir, "app_20240115_143022.db")
+			if err := os.WriteFile(dumpPath, []byte("data"), 0644); err != nil {
+				t.Fatalf("writing dump: %v", err)
+			}
+
+			m := model{
+				cfg:          cfg,
+				backupCfg:    cfg,
+				backupStates: map[string]*dbBackupState{"app": {currentStep: stepUploading, result: &backup.Result{Path: dumpPath}}},
+			}
+			result, _ := m.handleBackupStepDone(tt.msg)
+			state := result.(model).backupStates["app"]
+
+			_, err := os.Stat(dumpPath)
+			if kept := err == nil; kept != tt.wantKept {
+				t.Errorf("local dump kept = %v, want %v", kept, tt.wantKept)
+			}
+			last := state.logs[len(state.logs)-1]
+			if warned := slices.Contains(last.Warnings, "local copy kept at "+dumpPath); warned != tt.wantKept {
+				t.Errorf("warnings = %q, want local copy warning %v", last.Warnings, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestBackupStorageChange(t *testing.T) {
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app": {Type: "file", Dest: "/backups"},
+	}}
+
+	tests := []struct {
+		name   string
+		dryRun bool
+		want   orchestrator.StorageChange
+	}{
+		{"backup", false, orchestrator.StorageChange{Uploaded: 100, Deleted: 30}},
+		{"dry run", true, orchestrator.StorageChange{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := model{
+				cfg:          cfg,
+				backupCfg:    cfg,
+				dryRun:       tt.dryRun,
+				backupQueue:  []string{"app"},
+				backupStates: map[string]*dbBackupState{"app": {currentStep: stepUploading, size: 100}},
+			}
+			result, _ := m.handleBackupStepDone(backupStepDoneMsg{dbName: "app", step: stepUploading, message: "Saved"})
+			var deleted int64
+			if !tt.dryRun {
+				deleted = 30
+			}
+			result, _ = result.(model).handleBackupStepDone(backupStepDoneMsg{dbName: "app", step: stepRetention, message: "Deleted", deleted: deleted})
+			m = result.(model)
+
+			if got := m.storageChange(); got != tt.want {
+				t.Errorf("storageChange() = %+v, want %+v", got, tt.want)
+			}
+			if shown := strings.Contains(m.renderBackupRunning(), tt.want.String()); shown == tt.dryRun {
+				t.Errorf("summary shown = %v, want %v", shown, !tt.dryRun)
+			}
+		})
+	}
+}
+
+func TestRenderTransferProgress(t *testing.T) {
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app": {Type: "file", Dest: "/backups"},
+	}}
+
+	tests := []struct {
+		name  string
+		total int64
+		want  []string
+		avoid []string
+	}{
+		{"known total", 4096, []string{"50%", "2.0 KiB / 4.0 KiB", "1.0 KiB/s"}, []string{"so far"}},
+		{"unknown total", storage.SizeUnknown, []string{"2.0 KiB so far", "1.0 KiB/s"}, []string{"%", "KiB / "}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := model{
+				cfg:          cfg,
+				backupCfg:    cfg,
+				progressBar:  progress.New(),
+				backupQueue:  []string{"app"},
+				backupStates: map[string]*dbBackupState{"app": {currentStep: stepUploading, uploadBytesDone: 2048, uploadBytesTotal: tt.total, uploadSpeed: 1024}},
+			}
+			restore := model{
+				cfg:               cfg,
+				progressBar:       progress.New(),
+				selectedDB:        "app",
+				restoreStep:       restoreStepDownloading,
+				selectedFileSize:  tt.total,
+				downloadBytesDone: 2048,
+				downloadSpeed:     1024,
+			}
+
+			for view, out := range map[string]string{"backup": m.renderBackupRunning(), "restore": restore.renderRestoreRunning()} {
+				for _, want := range tt.want {
+					if !strings.Contains(out, want) {
+						t.Errorf("%s progress = %q, want %q", view, out, want)
+					}
+				}
+				for _, avoid := range tt.avoid {
+					if strings.Contains(out, avoid) {
+						t.Errorf("%s progress = %q, should not contain %q", view, out, avoid)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCancelBackup(t *testing.T) {
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app":   {Type: "file", Dest: "/backups"},
+		"other": {Type: "file", Dest: "/backups"},
+	}}
+
+	tests := []struct {
+		name string
+		step backupStep
+		msg  backupStepDoneMsg
+	}{
+		{"during dump", stepDumping, backupStepDoneMsg{dbName: "app", step: stepDumping, err: context.Canceled}},
+		{"dump finished as cancelled", stepDumping, backupStepDoneMsg{dbName: "app", step: stepDumping, message: "Dumped"}},
+		{"during upload", stepUploading, backupStepDoneMsg{dbName: "app", step: stepUploading, err: context.Canceled}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dumpDir := filepath.Join(t.TempDir(), "blobber-dump")
+			if err := os.MkdirAll(dumpDir, 0755); err != nil {
+				t.Fatalf("creating dump dir: %v", err)
+			}
+			dumpPath := filepath.Join(dumpDir, "app_20240115_143022.db")
+			if err := os.WriteFile(dumpPath, []byte("data"), 0644); err != nil {
+				t.Fatalf("writing dump: %v", err)
+			}
+			result := &backup.Result{Path: dumpPath}
+			if tt.step == stepDumping {
+				tt.msg.result = result
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			state := &dbBackupState{currentStep: tt.step, ctx: ctx, cancel: cancel}
+			if tt.step == stepUploading {
+				state.result = result
+			}
+			m := model{
+				cfg:          cfg,
+				backupCfg:    cfg,
+				backupQueue:  []string{"app", "other"},
+				backupStates: map[string]*dbBackupState{"app": state, "other": {currentStep: stepUploading}},
+			}
+
+			m.cancelBackup("app")
+			if ctx.Err() == nil {
+				t.Fatal("cancelBackup() did not cancel the database's context")
+			}
+			updated, _ := m.handleBackupStepDone(tt.msg)
+			m = updated.(model)
+
+			if !state.done || state.currentStep != stepIdle {
+				t.Errorf("state done = %v, step = %v, want done and idle", state.done, state.currentStep)
+			}
+			if last := state.logs[len(state.logs)-1]; !last.IsCancelled || last.IsError {
+				t.Errorf("last log = %+v, want a cancelled entry", last)
+			}
+			if _, err := os.Stat(dumpPath); !os.IsNotExist(err) {
+				t.Errorf("local dump still exists after cancel (stat error %v)", err)
+			}
+			if other := m.backupStates["other"]; other.done || other.cancelled {
+				t.Errorf("other database done = %v, cancelled = %v, want still running", other.done, other.cancelled)
+			}
+		})
+	}
+}
+
+func TestBackupErrorView(t *testing.T) {
+	long := "pg_dump: error: connection to server failed: " + strings.Repeat("FATAL: password authentication failed for user \"app\"\n", 30)
+	m := model{
+		width:       100,
+		view:        viewBackupRunning,
+		backupQueue: []string{"app", "other"},
+		backupStates: map[string]*dbBackupState{
+			"app":   {done: true, logs: []backupLogEntry{{DBName: "app", Step: stepDumping, Message: long, IsError: true}}},
+			"other": {done: true, logs: []backupLogEntry{{DBName: "other", Step: stepDumping, Message: "Dumped"}}},
+		},
+	}
+	press := func(key string) {
+		t.Helper()
+		msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+		switch key {
+		case "down":
+			msg = tea.KeyMsg{Type: tea.KeyDown}
+		case "esc":
+			msg = tea.KeyMsg{Type: tea.KeyEsc}
+		}
+		result, _ := m.Update(msg)
+		m = result.(model)
+	}
+
+	m.cursor = 1
+	press("e")
+	if m.view != viewBackupRunning {
+		t.Fatalf("e on a database without an error opened view %v", m.view)
+	}
+
+	m.cursor = 0
+	press("e")
+	if m.view != viewBackupError || m.errorDetailDB != "app" {
+		t.Fatalf("view = %v, db = %q; want the error view of app", m.view, m.errorDetailDB)
+	}
+	out := m.renderBackupError()
+	if !strings.Contains(out, "connection to server failed") || !strings.Contains(out, "more lines below") {
+		t.Errorf("error view = %q, want the start of the error and a scroll indicator", out)
+	}
+
+	lines := len(m.errorDetailLines())
+	for range lines + 5 {
+		press("down")
+	}
+	if want := lines - errorDetailMaxLines; m.errorDetailOffset != want {
+		t.Errorf("offset after scrolling past the end = %d, want %d", m.errorDetailOffset, want)
+	}
+	if out := m.renderBackupError(); strings.Contains(out, "more lines below") {
+		t.Errorf("error view at the end = %q, want no indicator below", out)
+	}
+
+	press("esc")
+	if m.view != viewBackupRunning || m.cursor != 0 || m.errorDetailOffset != 0 {
+		t.Errorf("view = %v, cursor = %d, offset = %d; want back to the progress", m.view, m.cursor, m.errorDetailOffset)
+	}
+}
+
+func TestStartReadyBackups(t *testing.T) {
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app":       {Type: "file", Dest: "/backups"},
+		"analytics": {Type: "file", Dest: "/backups", After: []string{"app"}},
+		"other":     {Type: "file", Dest: "/backups"},
+	}}
+	m := model{
+		cfg:         cfg,
+		backupQueue: []string{"analytics", "app", "other"},
+		backupStates: map[string]*dbBackupState{
+			"analytics": {currentStep: stepWaiting},
+			"app":       {currentStep: stepWaiting},
+			"other":     {currentStep: stepWaiting},
+		},
+	}
+
+	steps := func() map[string]backupStep {
+		got := make(map[string]backupStep)
+		for name, state := range m.backupStates {
+			got[name] = state.currentStep
+		}
+		return got
+	}
+
+	// Independent databases start together; analytics waits for app
+	m.startReadyBackups()
+	want := map[string]backupStep{"analytics": stepWaiting, "app": stepDumping, "other": stepDumping}
+	if got := steps(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after start: steps = %v, want %v", got, want)
+	}
+	if pending := m.pendingDependencies("analytics"); !slices.Equal(pending, []string{"app"}) {
+		t.Errorf("pendingDependencies() = %v, want [app]", pending)
+	}
+
+	// Finishing app releases analytics
+	m.backupStates["app"].done = true
+	m.backupStates["app"].currentStep = stepIdle
+	m.advanceBackups()
+	want = map[string]backupStep{"analytics": stepDumping, "app": stepIdle, "other": stepDumping}
+	if got := steps(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after app finished: steps = %v, want %v", got, want)
+	}
+}
+
+func TestToggleAllBackupDatabases(t *testing.T) {
+	m := model{
+		selected:           map[string]bool{"alpha": true, "beta": true, "gamma": true},
+		backupFilteredList: []string{"alpha", "beta"},
+	}
+
+	// Everything shown is selected, so the shown databases are deselected
+	m.toggleAllBackupDatabases()
+	if m.selected["alpha"] || m.selected["beta"] || !m.selected["gamma"] {
+		t.Errorf("after first toggle selected = %v, want only gamma", m.selected)
+	}
+
+	// Partially selected lists become fully selected
+	m.selected["alpha"] = true
+	m.toggleAllBackupDatabases()
+	if !m.selected["alpha"] || !m.selected["beta"] || !m.selected["gamma"] {
+		t.Errorf("after second toggle selected = %v, want all", m.selected)
+	}
+}
+
+func TestQuickEditOptions(t *testing.T) {
+	backend := &fs.RegInfo{Options: fs.Options{
+		{Name: "provider"},
+		{Name: "secret_access_key", IsPassword: true},
+		{Name: "chunk_size", Advanced: true},
+		{Name: "region", Advanced: true},
+		{Name: "internal", Hide: fs.OptionHideConfigurator},
+	}}
+
+	tests := []struct {
+		name   string
+		values map[string]string
+		want   []string
+	}{
+		{"standard options only", map[string]string{}, []string{"provider", "secret_access_key"}},
+		{"advanced option set", map[string]string{"region": "eu"}, []string{"provider", "secret_access_key", "region"}},
+		{"hidden option set", map[string]string{"internal": "x"}, []string{"provider", "secret_access_key"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, opt := range quickEditOptions(backend, tt.values) {
+				got = append(got, opt.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("quickEditOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuickEditDisplayValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		opt   fs.Option
+		value string
+		want  string
+	}{
+		{"unset", fs.Option{Name: "region"}, "", "(not set)"},
+		{"plain", fs.Option{Name: "region"}, "eu-west-1", "eu-west-1"},
+		{"password masked", fs.Option{Name: "pass", IsPassword: true}, "obscured", "••••••••"},
+		{"unset password", fs.Option{Name: "pass", IsPassword: true}, "", "(not set)"},
+		{"sensitive masked", fs.Option{Name: "key", Sensitive: true}, "AKIA", "••••••••"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := quickEditDisplayValue(tt.opt, tt.value); got != tt.want {
+				t.Errorf("quickEditDisplayValue() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuickEditSensitiveOption(t *testing.T) {
+	backend, err := fs.Find("s3")
+	if err != nil {
+		t.Fatalf("fs.Find(s3): %v", err)
+	}
+	var opt fs.Option
+	for _, o := range backend.Options {
+		if o.Name == "secret_access_key" {
+			opt = o
+		}
+	}
+	if !quickEditSecret(opt) {
+		t.Fatalf("s3 secret_access_key not treated as a secret: %+v", opt)
+	}
+	if got := quickEditDisplayValue(opt, "wJalrXUtnFEMI"); got != "••••••••" {
+		t.Errorf("quickEditDisplayValue() = %q, want it masked", got)
+	}
+
+	m := model{selectedRemote: "quickedit-test-remote"}
+	m.buildQuickEditForm(opt)
+	if *m.quickEditValue != "" {
+		t.Errorf("quick edit prefilled %q, want empty", *m.quickEditValue)
+	}
+
+	// Leaving the input empty keeps the key instead of unsetting it
+	m.quickEditOptions = []fs.Option{opt}
+	m = m.saveQuickEdit()
+	if !strings.Contains(m.quickEditStatus, "unchanged") {
+		t.Errorf("quickEditStatus = %q, want unchanged", m.quickEditStatus)
+	}
+}
+
+func TestFormatRemoteUsage(t *testing.T) {
+	usage := orchestrator.BackupUsage{Backups: 12, Bytes: 3 << 30}
+	tests := []struct {
+		name     string
+		dests    int
+		space    storage.Space
+		spaceErr error
+		want     []string
+	}{
+		{"total and free", 2, storage.Space{Total: 8 << 40, Free: 1 << 40}, nil, []string{"blobber using 3.0 GiB (12 backup(s) in 2 destination(s))", "; 1.0 TiB free of 8.0 TiB"}},
+		{"free only", 1, storage.Space{Total: -1, Free: 1 << 40}, nil, []string{"; 1.0 TiB free"}},
+		{"nothing reported", 1, storage.Space{Total: -1, Free: -1}, nil, []string{"blobber using 3.0 GiB"}},
+		{"unsupported", 1, storage.Space{}, storage.ErrUsageUnsupported, []string{"blobber using 3.0 GiB", "Free space unknown: " + storage.ErrUsageUnsupported.Error()}},
+		{"no destinations", 0, storage.Space{Total: 8 << 40, Free: 1 << 40}, nil, []string{"No configured database backs up to this remote", "1.0 TiB free"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatRemoteUsage(tt.dests, usage, tt.space, tt.spaceErr)
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("formatRemoteUsage() = %q, want it to contain %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadOnlyConfig(t *testing.T) {
+	cfg := &config.Config{Databases: map[string]config.Database{"app": {Type: "file", Dest: "/backups"}}}
+	base := model{cfg: cfg, readOnly: true, dbNames: []string{"app"}, dbFilteredList: []string{"app"}, editingDB: "app"}
+
+	tests := []struct {
+		name   string
+		view   view
+		cursor int
+		want   view
+	}{
+		{"add database", viewDBList, 1, viewDBList},
+		{"edit", viewDBActions, dbActionEdit, viewDBActions},
+		{"delete", viewDBActions, dbActionDelete, viewDBActions},
+		{"open database", viewDBList, 0, viewDBActions},
+		{"test connection", viewDBActions, dbActionTest, viewDBTest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := base
+			m.view, m.cursor = tt.view, tt.cursor
+			result, _ := m.handleEnter()
+			if got := result.(model).view; got != tt.want {
+				t.Errorf("view = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("banner", func(t *testing.T) {
+		for _, out := range []string{base.renderMainMenu(), base.renderDBList(), base.renderDBActions()} {
+			if !strings.Contains(out, "Config is read-only") {
+				t.Errorf("missing read-only banner in:\n%s", out)
+			}
+		}
+		writable := base
+		writable.readOnly = false
+		if strings.Contains(writable.renderDBList(), "read-only") {
+			t.Error("banner shown for a writable config")
+		}
+	})
+}
+
+func TestRestoreThenBackup(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	dir := t.TempDir()
+	src := filepath.Join(dir, "app.db")
+	if err := os.WriteFile(src, []byte("restored state"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "dest")
+	cfgPath := filepath.Join(dir, "config.yaml")
+	content := "databases:\n  app:\n    type: file\n    path: " + src + "\n    dest: " + dest + "\n"
+	if err := os.WriteFile(cfgPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := model{cfg: cfg, view: viewRestoreConfirm, selectedDB: "app"}
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("b")})
+	m = result.(model)
+	if !m.restoreThenBackup {
+		t.Fatal("b did not turn on backing up after the restore")
+	}
+
+	m.view = viewRestoreRunning
+	m.restoreStep = restoreStepRestoring
+	result, cmd := m.handleRestoreStepDone(restoreStepDoneMsg{step: restoreStepRestoring, message: "Restored to app.db", done: true})
+	m = result.(model)
+	if m.view != viewRestoreRunning || m.restoreStep != restoreStepBackingUp || cmd == nil {
+		t.Fatalf("view = %v, step = %v; want the backup step to run", m.view, m.restoreStep)
+	}
+
+	msg, err := backupAfterRestore(cfg, "app", "")
+	if err != nil {
+		t.Fatalf("backupAfterRestore() error = %v", err)
+	}
+	files, _ := os.ReadDir(dest)
+	if len(files) == 0 || !strings.Contains(msg, files[0].Name()) {
+		t.Errorf("message = %q, files = %v; want the uploaded backup named", msg, files)
+	}
+
+	result, _ = m.handleRestoreStepDone(restoreStepDoneMsg{step: restoreStepBackingUp, message: msg, done: true})
+	m = result.(model)
+	if m.view != viewDone || m.err != nil || len(m.restoreLogs) != 2 {
+		t.Errorf("view = %v, err = %v, logs = %v; want both steps logged", m.view, m.err, m.restoreLogs)
+	}
+}
+
+func TestRestoreConfirmCrossDatabase(t *testing.T) {
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"prod":    {Type: "postgres", Dest: "s3:backups/prod"},
+		"staging": {Type: "postgres", Dest: "s3:backups/staging"},
+	}}
+
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{"own backup", "", []string{"Restore to staging?", "Mode: upsert"}},
+		{"another database's backup", "prod", []string{"Restore prod's backup INTO staging?", "Source: prod (backups listed from s3:backups/prod)", "Target: staging (overwritten)"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := model{cfg: cfg, view: viewRestoreConfirm, selectedDB: "staging", cloneSourceDB: tt.source, selectedFile: "prod_20240115_120000.sql.gz"}
+			out := m.renderRestoreConfirm()
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("confirm screen missing %q:\n%s", want, out)
+				}
+			}
+		})
+	}
+}
+
+func TestBackupPresets(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yaml")
+	content := `databases:
+  app:
+    type: file
+    path: /data/app.db
+    dest: /backups
+  logs:
+    type: file
+    path: /data/logs.db
+    dest: /backups
+presets:
+  nightly:
+    databases: [logs]
+    retention_dry_run: true
+`
+	if err := os.WriteFile(cfgPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := model{cfg: cfg, dbNames: []string{"app", "logs"}, selected: map[string]bool{}, view: viewMainMenu, cursor: menuBackup}
+	result, _ := m.handleEnter()
+	m = result.(model)
+	if m.view != viewBackupPresetSelect || !slices.Equal(m.presetNames, []string{"nightly"}) {
+		t.Fatalf("view = %v, presets = %v; want the preset picker", m.view, m.presetNames)
+	}
+
+	result, _ = m.handleEnter()
+	m = result.(model)
+	if m.view != viewBackupSelect || !m.selected["logs"] || m.selected["app"] || !m.retentionDryRun {
+		t.Fatalf("view = %v, selected = %v, retentionDryRun = %v; want the preset applied", m.view, m.selected, m.retentionDryRun)
+	}
+	if m.cursor != m.maxCursor() {
+		t.Errorf("cursor = %d, want Run Backup (%d)", m.cursor, m.maxCursor())
+	}
+
+	// Save a new selection under another name
+	m.selected["app"] = true
+	m.dryRun = true
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyCtrlS})
+	m = result.(model)
+	if m.view != viewBackupPresetSave || m.presetName == nil {
+		t.Fatalf("view = %v, want the preset name input", m.view)
+	}
+	*m.presetName = "weekly"
+	m = m.savePreset()
+	if m.view != viewBackupSelect || !strings.Contains(m.presetStatus, "Saved preset weekly") {
+		t.Fatalf("view = %v, status = %q; want the preset saved", m.view, m.presetStatus)
+	}
+
+	saved, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := config.Preset{Databases: []string{"app", "logs"}, DryRun: true}
+	if got := saved.Presets["weekly"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("saved preset = %+v, want %+v", got, want)
+	}
+}
+
+func TestStaleCheck(t *testing.T) {
+	dest := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dest, "app_"+time.Now().Format("20060102_150405")+".db"), []byte("backup"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{StaleCheck: true, Databases: map[string]config.Database{
+		"app":  {Type: "file", Path: "/data/app.db", Dest: dest},
+		"logs": {Type: "file", Path: "/data/logs.db", Dest: dest},
+	}}
+	m := model{cfg: cfg, dbNames: []string{"app", "logs"}, view: viewMainMenu}
+
+	if !strings.Contains(m.renderMainMenu(), "Checking for databases without a recent backup") {
+		t.Error("main menu should say the check is running")
+	}
+
+	msg, ok := m.checkStale()().(staleCheckMsg)
+	if !ok {
+		t.Fatal("checkStale() should return a staleCheckMsg")
+	}
+	result, _ := m.Update(msg)
+	m = result.(model)
+	if len(m.staleDBs) != 1 || m.staleDBs[0].Name != "logs" {
+		t.Fatalf("staleDBs = %+v, want only logs", m.staleDBs)
+	}
+	if !strings.Contains(m.renderMainMenu(), "1 database hasn't been backed up recently") {
+		t.Errorf("main menu should warn about the stale database:\n%s", m.renderMainMenu())
+	}
+
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("s")})
+	m = result.(model)
+	if m.view != viewStale {
+		t.Fatalf("view = %v, want viewStale", m.view)
+	}
+	if out := m.renderStale(); !strings.Contains(out, "logs") || !strings.Contains(out, "never backed up") || !strings.Contains(out, "2 days") {
+		t.Errorf("renderStale() should list logs as never backed up:\n%s", out)
+	}
+
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if m = result.(model); m.view != viewMainMenu {
+		t.Errorf("view = %v, want back to the main menu", m.view)
+	}
+}
+
+func TestRestoreBatch(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "backups")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"app_20240101_000000.db":  "app monday",
+		"app_20240102_000000.db":  "app tuesday",
+		"logs_20240101_000000.db": "logs monday",
+	} {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte(content), 0644); err != nil {
+			t.Fatalf("writing backup: %v", err)
+		}
+	}
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app":  {Type: "file", Path: filepath.Join(dir, "app.db"), Dest: dest},
+		"logs": {Type: "file", Path: filepath.Join(dir, "logs.db"), Dest: dest},
+		"shop": {Type: "file", Path: filepath.Join(dir, "shop.db"), Dest: dest},
+	}}
+	names := []string{"app", "logs", "shop"}
+	m := model{cfg: cfg, dbNames: names, view: viewRestoreDBSelect, restoreDBFilteredList: names}
+
+	// space picks databases, enter asks for the point in time of the batch
+	for _, cursor := range []int{0, 2} {
+		m.cursor = cursor
+		updated, _ := m.Update(tea.KeyMsg{Type: tea.KeySpace})
+		m = updated.(model)
+	}
+	if got := m.restoreBatchNames(); !slices.Equal(got, []string{"app", "shop"}) {
+		t.Fatalf("restoreBatchNames() = %v, want [app shop]", got)
+	}
+	updated, _ := m.handleEnter()
+	m = updated.(model)
+	if m.view != viewRestoreBatchForm {
+		t.Fatalf("view after enter = %v, want the batch point in time input", m.view)
+	}
+
+	// shop has no backup: it is left out of the plan
+	m.restoreBatchAt = time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	m.view = viewRestoreBatchPlan
+	updated, _ = m.Update(m.planRestoreBatch()())
+	m = updated.(model)
+	if m.view != viewRestoreBatchConfirm || len(m.restoreBatchPlan) != 1 || m.restoreBatchPlan[0].File != "app_20240101_000000.db" {
+		t.Fatalf("view = %v, plan = %+v, want app's backup of monday to confirm", m.view, m.restoreBatchPlan)
+	}
+	if m.restoreBatchFailures["shop"] == nil {
+		t.Errorf("restoreBatchFailures = %v, want shop's missing backup", m.restoreBatchFailures)
+	}
+	if view := m.renderRestoreBatchConfirm(); !strings.Contains(view, "Type 1 to restore 1 databases") {
+		t.Errorf("confirm view does not ask for the count:\n%s", view)
+	}
+
+	// Restoring follows the progress of each database until the batch ends
+	updated, _ = m.startRestoreBatch()
+	m = updated.(model)
+	for !m.restoreBatchDone() {
+		updated, _ = m.Update(m.waitForRestoreBatchProgress()())
+		m = updated.(model)
+	}
+	if state := m.restoreBatchStates["app"]; !state.done || len(state.logs) != 2 || state.logs[1].IsError {
+		t.Errorf("app state = %+v, want downloaded and restored", state)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "app.db")); err != nil || string(data) != "app monday" {
+		t.Errorf("app.db = %q (error %v), want monday's backup", data, err)
+	}
+
+	m = m.goBack()
+	if m.view != viewMainMenu || m.restoreBatchSelected != nil {
+		t.Errorf("view after the batch = %v, want the main menu with the selection cleared", m.view)
+	}
+}
+
+func TestRestoreBatchSource(t *testing.T) {
+	dest := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dest, "logs_20240101_000000.db"), []byte("logs monday"), 0644); err != nil {
+		t.Fatalf("writing backup: %v", err)
+	}
+	cfg := &config.Config{Databases: map[string]config.Database{
+		"app":  {Type: "file", Path: "/data/app.db", Dest: dest},
+		"logs": {Type: "file", Path: "/data/logs.db", Dest: dest},
+		"shop": {Type: "file", Path: "/data/shop.db", Dest: dest},
+		"pg":   {Type: "postgres", Host: "db", Database: "pg", Dest: dest},
+	}}
+	names := []string{"app", "logs", "pg", "shop"}
+	m := model{cfg: cfg, dbNames: names, view: viewRestoreDBSelect, restoreDBFilteredList: names, cursor: 3}
+	press := func(msg tea.KeyMsg) {
+		t.Helper()
+		updated, _ := m.Update(msg)
+		m = updated.(model)
+	}
+
+	// ctrl+o only applies to a database picked for the batch
+	press(tea.KeyMsg{Type: tea.KeyCtrlO})
+	if len(m.restoreBatchSources) != 0 {
+		t.Fatalf("sources = %v, want none before shop is picked", m.restoreBatchSources)
+	}
+	press(tea.KeyMsg{Type: tea.KeySpace})
+
+	// It cycles through the databases of the same type, then back to shop's own backups
+	for _, want := range []string{"app", "logs", ""} {
+		press(tea.KeyMsg{Type: tea.KeyCtrlO})
+		if got := m.restoreBatchSources["shop"]; got != want {
+			t.Fatalf("source of shop = %q, want %q", got, want)
+		}
+	}
+	press(tea.KeyMsg{Type: tea.KeyCtrlO})
+	press(tea.KeyMsg{Type: tea.KeyCtrlO})
+	if !strings.Contains(m.renderRestoreDBSelect(), "shop ← logs's backups") {
+		t.Errorf("restore select doesn't show the source:\n%s", m.renderRestoreDBSelect())
+	}
+
+	// The plan restores logs' backup into shop
+	m.view = viewRestoreBatchPlan
+	updated, _ := m.Update(m.planRestoreBatch()())
+	m = updated.(model)
+	if len(m.restoreBatchPlan) != 1 || m.restoreBatchPlan[0].Source != "logs" || m.restoreBatchPlan[0].Entry != "shop" {
+		t.Fatalf("plan = %+v (failures %v), want logs' backup restored into shop", m.restoreBatchPlan, m.restoreBatchFailures)
+	}
+	if view := m.renderRestoreBatchConfirm(); !strings.Contains(view, "logs: logs_20240101_000000.db") {
+		t.Errorf("confirm view doesn't name the source:\n%s", view)
+	}
+}
+
+func TestDiagnosticsLog(t *testing.T) {
+	m := model{cfg: &config.Config{}, view: viewMainMenu}
+	if strings.Contains(m.renderMainMenu(), "Diagnostics:") {
+		t.Error("main menu hints at a diagnostics log while it is off")
+	}
+	m.diagnosticsLog = "/var/log/blobber/diagnostics.log"
+	if !strings.Contains(m.renderMainMenu(), "Diagnostics: /var/log/blobber/diagnostics.log") {
+		t.Errorf("main menu missing the diagnostics log:\n%s", m.renderMainMenu())
+	}
+
+	var buf strings.Builder
+	SetDiagnosticsLog(&buf, "")
+	defer SetDiagnosticsLog(nil, "")
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("recovered %v, want the panic raised again", r)
+			}
+		}()
+		defer logPanic()
+		panic("boom")
+	}()
+	if got := buf.String(); !strings.Contains(got, "panic: boom") || !strings.Contains(got, "TestDiagnosticsLog") {
+		t.Errorf("diagnostics log = %q, want the panic and its stack", got)
+	}
+}
+
+func TestDownloadRetryShown(t *testing.T) {
+	ch := make(chan storage.TransferProgress)
+	m := model{
+		cfg:           &config.Config{},
+		view:          viewRestoreRunning,
+		restoreStep:   restoreStepDownloading,
+		selectedFile:  "app_20240115_143022.sql.gz",
+		downloadState: &downloadState{progressCh: ch, fileName: "app_20240115_143022.sql.gz"},
+	}
+	result, _ := m.handleDownloadProgress(downloadProgressMsg{bytesTotal: 100, attempt: 2, attempts: 3, retrying: errors.New("connection reset by peer")})
+	m = result.(model)
+	if m.err != nil || m.view != viewRestoreRunning {
+		t.Fatalf("view = %v, err = %v; want the download to go on", m.view, m.err)
+	}
+	if out := m.renderRestoreRunning(); !strings.Contains(out, "Attempt 2 of 3: the last one failed (connection reset by peer)") {
+		t.Errorf("restore screen missing the retry:\n%s", out)
+	}
+}
+
+func TestTransferStall(t *testing.T) {
+	t.Run("download", func(t *testing.T) {
+		// The progress channel goes quiet: the remote stopped sending anything
+		var cancelled bool
+		ds := &downloadState{progressCh: make(chan storage.TransferProgress), cancel: func() { cancelled = true }, fileName: "app.db"}
+		ds.progressed(1024, time.Now().Add(-3*time.Minute))
+		m := model{
+			cfg:           &config.Config{StallTimeout: "2m"},
+			view:          viewRestoreRunning,
+			restoreStep:   restoreStepDownloading,
+			selectedFile:  "app.db",
+			downloadState: ds,
+		}
+
+		result, cmd := m.Update(stallCheckMsg{})
+		m = result.(model)
+		if cmd == nil {
+			t.Error("stall checks stopped while the download runs")
+		}
+		if out := m.renderRestoreRunning(); !strings.Contains(out, "Transfer appears stalled (no progress for 3m0s)") {
+			t.Errorf("restore screen missing the stall:\n%s", out)
+		}
+
+		// Bytes moving again clear the warning, updates without any don't
+		result, _ = m.handleDownloadProgress(downloadProgressMsg{bytesDone: 1024})
+		m = result.(model)
+		if ds.stalledFor == 0 {
+			t.Error("stall cleared by an update that moved no bytes")
+		}
+		result, _ = m.handleDownloadProgress(downloadProgressMsg{bytesDone: 2048})
+		m = result.(model)
+		if out := m.renderRestoreRunning(); strings.Contains(out, "stalled") {
+			t.Errorf("stall still shown once bytes moved:\n%s", out)
+		}
+
+		// x cancels the download, which then ends as cancelled rather than failed
+		result, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x")})
+		m = result.(model)
+		if !cancelled {
+			t.Fatal("x did not cancel the download")
+		}
+		result, _ = m.handleDownloadProgress(downloadProgressMsg{err: context.Canceled, done: true})
+		m = result.(model)
+		if last := m.restoreLogs[len(m.restoreLogs)-1]; last.Message != "Downloading backup cancelled" {
+			t.Errorf("restore log = %q, want the download cancelled", last.Message)
+		}
+	})
+
+	t.Run("streamed restore offers no retry", func(t *testing.T) {
+		var cancelled bool
+		ds := &downloadState{
+			progressCh: make(chan storage.TransferProgress),
+			cancel:     func() { cancelled = true },
+			fileName:   "app_20240115_143022.sql.gz",
+			target:     &config.Database{Type: "postgres", Database: "app"},
+		}
+		ds.progressed(1024, time.Now().Add(-3*time.Minute))
+		m := model{
+			cfg:           &config.Config{StallTimeout: "2m"},
+			view:          viewRestoreRunning,
+			restoreStep:   restoreStepDownloading,
+			selectedFile:  "app_20240115_143022.sql.gz",
+			downloadState: ds,
+		}
+
+		result, _ := m.Update(stallCheckMsg{})
+		m = result.(model)
+		out := m.renderRestoreRunning()
+		if !strings.Contains(out, "Transfer appears stalled (no progress for 3m0s): x to cancel") || strings.Contains(out, "r to retry") {
+			t.Errorf("restore screen should only offer cancelling:\n%s", out)
+		}
+
+		result, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+		m = result.(model)
+		if m.downloadState != ds || ds.abandoned.Load() || cancelled {
+			t.Error("r restarted a streamed restore")
+		}
+	})
+
+	t.Run("keys end with the download step", func(t *testing.T) {
+		var cancelled bool
+		ds := &downloadState{progressCh: make(chan storage.TransferProgress), cancel: func() { cancelled = true }, fileName: "app.db"}
+		ds.progressed(1024, time.Now().Add(-3*time.Minute))
+		ds.stalledFor = 3 * time.Minute
+		m := model{
+			cfg:           &config.Config{StallTimeout: "2m"},
+			view:          viewRestoreRunning,
+			restoreStep:   restoreStepRestoring,
+			selectedFile:  "app.db",
+			downloadState: ds,
+		}
+
+		for _, key := range []string{"x", "r"} {
+			result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)})
+			m = result.(model)
+		}
+		if cancelled || ds.cancelled || ds.abandoned.Load() || m.downloadState != ds {
+			t.Error("x or r acted on a download that already finished")
+		}
+		if out := m.View(); strings.Contains(out, "cancel download") {
+			t.Errorf("restore step still offers cancelling the download:\n%s", out)
+		}
+	})
+
+	t.Run("upload retry", func(t *testing.T) {
+		backupPath := filepath.Join(t.TempDir(), "app_20240115_143022.db")
+		if err := os.WriteFile(backupPath, []byte("backup"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var cancelled bool
+		stalled := &uploadState{
+			progressCh: make(chan storage.TransferProgress),
+			cancel:     func() { cancelled = true },
+			dbName:     "app",
+			backupPath: backupPath,
+			dest:       t.TempDir(),
+		}
+		stalled.progressed(0, time.Now().Add(-3*time.Minute))
+		m := model{
+			cfg:          &config.Config{},
+			view:         viewBackupRunning,
+			backupQueue:  []string{"app"},
+			backupStates: map[string]*dbBackupState{"app": {currentStep: stepUploading}},
+			uploadStates: map[string]*uploadState{"app": stalled},
+		}
+
+		result, _ := m.Update(stallCheckMsg{})
+		m = result.(model)
+		if out := m.renderBackupRunning(); !strings.Contains(out, "Transfer appears stalled (no progress for 3m0s)") {
+			t.Errorf("backup screen missing the stall:\n%s", out)
+		}
+
+		// r abandons the stalled upload and starts it again
+		result, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+		m = result.(model)
+		retried := m.uploadStates["app"]
+		if !cancelled || !stalled.abandoned.Load() || retried == stalled {
+			t.Fatalf("cancelled = %v, abandoned = %v; want the stalled upload replaced", cancelled, stalled.abandoned.Load())
+		}
+		if retried.stallWarning() != "" {
+			t.Errorf("retried upload already stalled: %q", retried.stallWarning())
+		}
+		for range retried.progressCh {
+		}
+	})
+}
+
+func TestPreferredBackends(t *testing.T) {
+	names := func(list []*fs.RegInfo) []string {
+		var out []string
+		for _, ri := range list {
+			out = append(out, ri.Name)
+		}
+		return out
+	}
+
+	// Without preferred_backends, every backend is listed by name
+	m := model{cfg: &config.Config{}, view: viewRcloneAddType}
+	m.loadRcloneBackends()
+	all := names(m.rcloneFilteredList)
+	if len(all) < 10 || !slices.IsSorted(all) {
+		t.Fatalf("backends = %v, want all of them by name", all)
+	}
+
+	// Only the preferred ones, in their order, until ctrl+a; unknown names are ignored
+	m = model{cfg: &config.Config{PreferredBackends: []string{"s3", "local", "nosuchbackend"}}, view: viewRcloneAddType}
+	m.loadRcloneBackends()
+	if got := names(m.rcloneFilteredList); !slices.Equal(got, []string{"s3", "local"}) {
+		t.Errorf("backends = %v, want the preferred ones", got)
+	}
+	if out := m.renderRcloneAddType(); !strings.Contains(out, fmt.Sprintf("2 preferred backends • ctrl+a: show all %d", len(all))) {
+		t.Errorf("renderRcloneAddType() should offer every backend:\n%s", out)
+	}
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyCtrlA})
+	m = result.(model)
+	got := names(m.rcloneFilteredList)
+	if len(got) != len(all) || got[0] != "s3" || got[1] != "local" {
+		t.Errorf("backends after ctrl+a = %v, want all with the preferred ones first", got)
+	}
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyCtrlA})
+	if m = result.(model); len(m.rcloneFilteredList) != 2 {
+		t.Errorf("second ctrl+a lists %d backends, want the preferred ones again", len(m.rcloneFilteredList))
+	}
+
+	// A filter searches every backend
+	m = m.handleFilterInput("drive")
+	if got := names(m.rcloneFilteredList); !slices.Contains(got, "drive") {
+		t.Errorf("filtered backends = %v, want drive although it isn't preferred", got)
+	}
+}